@@ -0,0 +1,806 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "flag"
+    "math"
+    "sort"
+    "sync"
+    "time"
+    "strings"
+    "strconv"
+    "path/filepath"
+    "encoding/csv"
+)
+
+func init() {
+    Commands["analyze"] = analyzeCommand
+}
+
+/*
+ Analyzes recordings offline. Without options, a summary of every recording is printed. Multi-channel recordings (the
+ muscle signal and auxiliary sensors that measure EMG in volts) can be decomposed into components with PCA or ICA,
+ which are plotted. The signal can then be reconstructed without some of the components, for example to remove the
+ crosstalk of a nearby muscle; it is written next to the recording (data.csv -> data.reconstructed.csv).
+ Examples:
+    $ plot analyze data.csv
+    $ plot analyze --decompose=ica data.csv
+    $ plot analyze --decompose=ica --remove=2 data.csv
+ With --per-segment, the statistics of every segment (see EventSegment) are written as CSV instead, in long format
+ with one value per row, for all recordings together:
+    $ plot analyze --per-segment -o phases.csv session1.csv session2.csv
+    File;Segment;Repetition;Start [s];End [s];Signal;Measure;Value
+    session1.csv;contract;1;5.000000;8.000000;raw;mean;0.001234
+ --glob analyzes every recording that matches a pattern (the files of processing stages and segments are skipped),
+ and summarizes them in one table with a row per recording, followed by the mean and the standard deviation over all
+ of them. With -o, the table is written as CSV.
+    $ plot analyze --glob='sessions/*.csv'
+ Several recordings are analyzed at the same time, one per processor by default (see --jobs).
+ */
+func analyzeCommand(args []string) {
+    flags := flag.NewFlagSet("analyze", flag.ExitOnError)
+    decompose := flags.String("decompose", "", "Decompose the channels into components: pca or ica")
+    channels := flags.String("channels", "", "The channels that are decomposed, separated by commas. emg is the " +
+        "muscle signal. Default: every channel in volts.")
+    remove := flags.String("remove", "", "The components (starting at 1) that are removed when the channels are " +
+        "reconstructed, separated by commas")
+    width := flags.Int("width", terminalWidth(), "The width of the plots of the components")
+    height := flags.Int("height", 12, "The height of the plot of every component")
+    perSegment := flags.Bool("per-segment", false, "Write the statistics of every segment as CSV instead of the " +
+        "summary")
+    output := flags.String("o", "", "The file that the statistics of the segments or the summary table are " +
+        "written into, as CSV. Default: the standard output.")
+    glob := flags.String("glob", "", "Analyze every recording that matches the pattern, and summarize them in " +
+        "one table")
+    jobs := flags.Int("jobs", defaultJobs, "How many recordings are analyzed at the same time. Decompositions " +
+        "are done one after another.")
+    flags.StringVar(&(Settings.KeyFile), "key", "", "The key file for encrypted recordings")
+    flags.Float64Var(&(Settings.Motion), "motion", 0, "The acceleration in g above which the accelerometer counts " +
+        "as motion. Default: the threshold of the recording, or 0.5.")
+    flags.Float64Var(&(Settings.MotionHold), "motion-hold", 0.5, "How many seconds before and after the motion " +
+        "are flagged as well")
+    flags.BoolVar(&(Settings.MotionGate), "motion-gate", false, "Repeat the statistics without the motion. Always " +
+        "done for recordings that were made with --motion-gate.")
+    flags.Parse(args)
+    files := flags.Args()
+    if *glob != "" {
+        matches, err := globRecordings(*glob)
+        if err != nil {
+            fail("%v", err)
+        }
+        if len(matches) == 0 {
+            fail("no recordings match %s", *glob)
+        }
+        files = append(files, matches...)
+    }
+    if len(files) == 0 {
+        fail("Usage: plot analyze [--decompose=pca|ica] [--channels=names] [--remove=components] [--key=file] " +
+            "[--per-segment] [--glob=pattern] [-o file] <file>...")
+    }
+    if *decompose != "" && *decompose != "pca" && *decompose != "ica" {
+        fail("unknown decomposition %s, expected pca or ica", *decompose)
+    }
+    if *remove != "" && *decompose == "" {
+        fail("--remove requires --decompose")
+    }
+    if *perSegment && *decompose != "" {
+        fail("--per-segment can't be combined with --decompose")
+    }
+    if *glob != "" && !*perSegment && *decompose == "" {
+        summaries := make([]RecordingSummary, len(files))
+        err := ProcessFiles(files, *jobs, "Analyzed", func(i int) (string, error) {
+            var err error
+            summaries[i], err = SummarizeRecording(files[i])
+            return "", err
+        })
+        if err != nil {
+            fail("%v", err)
+        }
+        err = writeSummaries(summaries, *output)
+        if err != nil {
+            fail("%v", err)
+        }
+        return
+    }
+    if *perSegment {
+        segments := make([][]SegmentStatistics, len(files))
+        err := ProcessFiles(files, *jobs, "Analyzed", func(i int) (string, error) {
+            meta, err := LoadMetadata(files[i])
+            if err != nil {
+                return "", err
+            }
+            events, err := ReadAnnotatedEvents(files[i])
+            if err != nil {
+                return "", err
+            }
+            segments[i], err = segmentStatistics(files[i], meta, events)
+            return "", err
+        })
+        if err != nil {
+            fail("%v", err)
+        }
+        out := os.Stdout
+        if *output != "" {
+            f, err := os.Create(*output)
+            if err != nil {
+                fail("%v", err)
+            }
+            defer f.Close()
+            out = f
+        }
+        table := csv.NewWriter(out)
+        table.Comma = ';'
+        table.Write([]string{"File", "Segment", "Repetition", "Start [s]", "End [s]", "Signal", "Measure", "Value"})
+        for i, file := range files {
+            for _, segment := range segments[i] {
+                for _, measure := range segment.Measures {
+                    table.Write([]string{file, segment.Name, strconv.Itoa(segment.Repetition),
+                        fmt.Sprintf("%f", segment.Start), fmt.Sprintf("%f", segment.End), segment.Signal,
+                        measure.Name, strconv.FormatFloat(measure.Value, 'g', -1, 64)})
+                }
+            }
+        }
+        table.Flush()
+        return
+    }
+    if *decompose == "" {
+        err := ProcessFiles(files, *jobs, "Analyzed", func(i int) (string, error) {
+            meta, err := LoadMetadata(files[i])
+            if err != nil {
+                return "", err
+            }
+            var text strings.Builder
+            err = printAnalysis(&text, files[i], meta)
+            return text.String(), err
+        })
+        if err != nil {
+            fail("%v", err)
+        }
+        return
+    }
+    removed := map[int]bool{}
+    if *remove != "" {
+        for _, component := range strings.Split(*remove, ",") {
+            i, err := strconv.Atoi(strings.TrimSpace(component))
+            if err != nil || i < 1 {
+                fail("invalid component %s", component)
+            }
+            removed[i - 1] = true
+        }
+    }
+
+    // Decompositions need the whole recording in memory
+    for _, file := range files {
+        meta, header, columns, err := readRecording(file)
+        if err != nil {
+            fail("%s: %v", file, err)
+        }
+        err = printAnalysis(os.Stdout, file, meta)
+        if err != nil {
+            fail("%s: %v", file, err)
+        }
+
+        // Collect the channels that are decomposed
+        first := len(header) - len(meta.Aux)
+        selected, err := decomposedChannels(meta, first, *channels)
+        if err != nil {
+            fail("%s: %v", file, err)
+        }
+        if len(selected) < 2 {
+            fail("%s: decomposing needs at least two channels in volts, see --channels", file)
+        }
+        signals := [][]float64{}
+        names := []string{}
+        for _, i := range selected {
+            name, unit := ParseColumn(header[i])
+            signal := make([]float64, len(columns[i]))
+            for t, v := range columns[i] {
+                signal[t] = v / unit.Scale
+            }
+            signals = append(signals, signal)
+            names = append(names, name)
+        }
+        var decomposition Decomposition
+        if *decompose == "pca" {
+            decomposition = PCA(signals)
+        } else {
+            decomposition, err = ICA(signals)
+            if err != nil {
+                fail("%s: %v", file, err)
+            }
+        }
+        for i := range removed {
+            if i >= len(decomposition.Components) {
+                fail("%s: there are only %d components", file, len(decomposition.Components))
+            }
+        }
+
+        // Plot the components, and how strongly they appear in every channel
+        for j, component := range decomposition.Components {
+            chart := NewChart(*width, *height)
+            chart.XLabel = "Time [s]"
+            chart.Keys = columns[0]
+            chart.Axes[LeftAxis].Label = fmt.Sprintf("Component %d", j + 1)
+            if decomposition.Explained != nil {
+                chart.Axes[LeftAxis].Label += fmt.Sprintf(" (%.1f%% of the variance)", decomposition.Explained[j])
+            }
+            chart.AddSeries(LeftAxis, component)
+            fmt.Println(chart.Draw())
+            weights := []string{}
+            for i, name := range names {
+                weights = append(weights, fmt.Sprintf("%s %.3g", name, decomposition.Mixing[i][j]))
+            }
+            fmt.Printf("Weights: %s\n\n", strings.Join(weights, "   "))
+        }
+        if len(removed) == 0 {
+            continue
+        }
+
+        // Write the channels without the removed components, in the layout of a recording
+        reconstructed := decomposition.Reconstruct(removed)
+        meta.Stage = "reconstructed"
+        aux := []AuxChannel{}
+        for _, i := range selected[1:] {
+            aux = append(aux, meta.Aux[i - first])
+        }
+        meta.Aux = aux
+        target := strings.TrimSuffix(file, filepath.Ext(file)) + ".reconstructed" + filepath.Ext(file)
+        output := []string{voltageUnits[0].Column(names[0])}
+        for _, channel := range aux {
+            output = append(output, channel.Column())
+        }
+        recorder, err := NewRecorder(target, meta, output)
+        if err != nil {
+            fail("%s: %v", target, err)
+        }
+        for t, time := range columns[0] {
+            values := make([]float64, len(reconstructed))
+            for i := range reconstructed {
+                values[i] = reconstructed[i][t]
+                if i > 0 {
+                    // The auxiliary sensors are stored in their own unit
+                    _, unit := ParseColumn(header[selected[i]])
+                    values[i] *= unit.Scale
+                }
+            }
+            err = recorder.Write(time, values...)
+            if err != nil {
+                fail("%s: %v", target, err)
+            }
+        }
+        err = recorder.Close()
+        if err != nil {
+            fail("%s: %v", target, err)
+        }
+        fmt.Printf("Reconstructed without components %s into %s\n", *remove, target)
+    }
+}
+
+/*
+ Reads the metadata and the columns of a recording, which must not be empty
+ */
+func readRecording(file string) (Metadata, []string, [][]float64, error) {
+    meta, err := LoadMetadata(file)
+    if err != nil {
+        return Metadata{}, nil, nil, err
+    }
+    header, columns, err := ReadColumns(file)
+    if err != nil {
+        return Metadata{}, nil, nil, err
+    }
+    if len(columns) < 2 || len(columns[0]) == 0 {
+        return Metadata{}, nil, nil, fmt.Errorf("the recording is empty")
+    }
+    return meta, header, columns, nil
+}
+
+/*
+ Writes a summary of a recording. The recording is read one row after the other, so it can be of any size.
+ */
+func printAnalysis(w io.Writer, file string, meta Metadata) error {
+    scanner, err := ScanRecording(file)
+    if err != nil {
+        return err
+    }
+    defer scanner.Close()
+    if len(scanner.Header) < 2 {
+        return fmt.Errorf("the recording has no signal")
+    }
+    _, unit := ParseColumn(scanner.Header[1])
+    first := len(scanner.Header) - len(meta.Aux)
+
+    // Movements of the subject are reported, and the statistics are repeated without them
+    stream := NewMotionStream(meta.Aux, meta.Interval, motionThreshold(meta), Settings.MotionHold)
+    var all, still RunningStats
+    start, end := 0.0, 0.0
+    episodes := 0
+    duration := 0.0
+    moving := false
+    add := func(sample MotionSample) {
+        value := sample.Values[1] / unit.Scale
+        all.Add(value)
+        if all.Stats().Count == 1 {
+            start = sample.Time
+        } else if sample.Moving {
+            duration += sample.Time - end
+        }
+        if sample.Moving && !moving {
+            episodes++
+        } else if !sample.Moving {
+            still.Add(value)
+        }
+        moving = sample.Moving
+        end = sample.Time
+    }
+    for scanner.Scan() {
+        values := scanner.Values()
+        for _, sample := range stream.Add(values[0], values, values[first:]) {
+            add(sample)
+        }
+    }
+    if scanner.Err() != nil {
+        return scanner.Err()
+    }
+    for _, sample := range stream.Flush() {
+        add(sample)
+    }
+    stats := all.Stats()
+    if stats.Count == 0 {
+        return fmt.Errorf("the recording is empty")
+    }
+
+    fmt.Fprintf(w, "%s: %s, %d samples, recorded %s\n", file, formatDuration(end - start), stats.Count,
+        meta.Created.Format("2006-01-02 15:04"))
+    fmt.Fprintln(w, stats.Format(AutoUnit.For(stats.Peak())))
+    if meta.Quality != nil {
+        fmt.Fprintf(w, "Quality: %s\n", meta.Quality)
+    }
+    if stream.Detecting() {
+        fmt.Fprintf(w, "Motion: %d episodes, %s (%.1f%%)\n", episodes, formatDuration(duration),
+            duration / (end - start) * 100)
+        if episodes > 0 && (Settings.MotionGate || meta.MotionGate) {
+            stats := still.Stats()
+            fmt.Fprintf(w, "Without motion: %s\n", stats.Format(AutoUnit.For(stats.Peak())))
+        }
+    }
+    fmt.Fprintln(w)
+    return nil
+}
+
+/*
+ Returns the threshold of the motion detection in g: --motion, the threshold of the recording, or 0.5
+ */
+func motionThreshold(meta Metadata) float64 {
+    if Settings.Motion > 0 {
+        return Settings.Motion
+    } else if meta.Motion > 0 {
+        return meta.Motion
+    }
+    return 0.5
+}
+
+/*
+ The summary of a recording in the table of --glob
+ */
+type RecordingSummary struct {
+    File string
+    Created time.Time
+    Duration float64
+    Measures []Measure
+    Quality float64
+}
+
+/*
+ The measures of the raw signal in the summary table
+ */
+var summaryMeasures = []string{"samples", "mean", "rms", "peak", "iemg"}
+
+/*
+ Returns the recordings that match a pattern, without the files of processing stages and segments, which belong to
+ the recording of the raw signal
+ */
+func globRecordings(pattern string) ([]string, error) {
+    matches, err := filepath.Glob(pattern)
+    if err != nil {
+        return nil, err
+    }
+    files := []string{}
+    for _, file := range matches {
+        meta, err := LoadMetadata(file)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %v", file, err)
+        }
+        if meta.Stage == "" {
+            files = append(files, file)
+        }
+    }
+    return files, nil
+}
+
+/*
+ Summarizes the raw signal of a recording
+ */
+func SummarizeRecording(file string) (RecordingSummary, error) {
+    meta, err := LoadMetadata(file)
+    if err != nil {
+        return RecordingSummary{}, err
+    }
+    segments, err := segmentStatistics(file, meta, nil)
+    if err != nil {
+        return RecordingSummary{}, err
+    }
+    if len(segments) == 0 {
+        return RecordingSummary{}, fmt.Errorf("the recording is empty")
+    }
+    whole := segments[0]
+    summary := RecordingSummary{File: file, Created: meta.Created, Duration: whole.End - whole.Start,
+        Quality: math.NaN()}
+    for _, name := range summaryMeasures {
+        summary.Measures = append(summary.Measures, Measure{name, whole.Measure(name)})
+    }
+    if meta.Quality != nil {
+        summary.Quality = meta.Quality.Score
+    }
+    return summary, nil
+}
+
+/*
+ Writes the summary table of --glob, followed by the mean and the standard deviation of every column. Without a
+ file, the table is printed, otherwise it is written into the file as CSV.
+ */
+func writeSummaries(summaries []RecordingSummary, file string) error {
+    names := []string{"Duration"}
+    for _, name := range summaryMeasures {
+        names = append(names, measureLabels[name])
+    }
+    names = append(names, "Quality")
+    rows := [][]float64{}
+    for _, summary := range summaries {
+        row := []float64{summary.Duration}
+        for _, measure := range summary.Measures {
+            row = append(row, measure.Value)
+        }
+        rows = append(rows, append(row, summary.Quality))
+    }
+    means, deviations := make([]float64, len(names)), make([]float64, len(names))
+    for i := range names {
+        values := []float64{}
+        for _, row := range rows {
+            if !math.IsNaN(row[i]) {
+                values = append(values, row[i])
+            }
+        }
+        means[i], deviations[i] = math.NaN(), math.NaN()
+        if len(values) > 0 {
+            means[i] = Calculate(values).Mean
+            deviations[i] = Calculate(removeMean(values)).RMS
+        }
+    }
+
+    if file != "" {
+        f, err := os.Create(file)
+        if err != nil {
+            return err
+        }
+        table := csv.NewWriter(f)
+        table.Comma = ';'
+        header := []string{"File", "Recorded", "Duration [s]", "Samples", "Mean [V]", "RMS [V]", "Peak [V]",
+            "iEMG [V s]", "Quality"}
+        table.Write(header)
+        cells := func(values []float64) []string {
+            row := []string{}
+            for _, v := range values {
+                if math.IsNaN(v) {
+                    row = append(row, "")
+                } else {
+                    row = append(row, strconv.FormatFloat(v, 'g', -1, 64))
+                }
+            }
+            return row
+        }
+        for i, summary := range summaries {
+            table.Write(append([]string{summary.File, summary.Created.Format(time.RFC3339)}, cells(rows[i])...))
+        }
+        table.Write(append([]string{"Mean", ""}, cells(means)...))
+        table.Write(append([]string{"SD", ""}, cells(deviations)...))
+        table.Flush()
+        if table.Error() != nil {
+            f.Close()
+            return table.Error()
+        }
+        return f.Close()
+    }
+
+    // Formats the cells like the summary of a single recording
+    format := func(values []float64) []string {
+        row := []string{}
+        for i, v := range values {
+            switch {
+            case math.IsNaN(v):
+                row = append(row, "-")
+            case i == 0:
+                row = append(row, formatDuration(v))
+            case i == len(values) - 1:
+                row = append(row, fmt.Sprintf("%.0f", v))
+            default:
+                row = append(row, formatMeasure(summaryMeasures[i - 1], v))
+            }
+        }
+        return row
+    }
+    table := [][]string{append([]string{"File", "Recorded"}, names...)}
+    for i, summary := range summaries {
+        table = append(table, append([]string{summary.File, summary.Created.Format("2006-01-02 15:04")},
+            format(rows[i])...))
+    }
+    table = append(table, append([]string{"Mean", ""}, format(means)...))
+    table = append(table, append([]string{"SD", ""}, format(deviations)...))
+    widths := make([]int, len(table[0]))
+    for _, row := range table {
+        for i, cell := range row {
+            if n := len([]rune(cell)); n > widths[i] {
+                widths[i] = n
+            }
+        }
+    }
+    for r, row := range table {
+        if r == len(table) - 2 {
+            fmt.Println()
+        }
+        line := ""
+        for i, cell := range row {
+            if i < 2 {
+                line += fmt.Sprintf("%-*s   ", widths[i], cell)
+            } else {
+                line += fmt.Sprintf("%*s   ", widths[i], cell)
+            }
+        }
+        fmt.Println(strings.TrimRight(line, " "))
+    }
+    return nil
+}
+
+/*
+ Returns the columns of a recording that are decomposed, starting with the muscle signal. The muscle signal is called
+ emg, the auxiliary sensors by their names; they are stored in the last columns, starting at first. By default, the
+ muscle signal and every auxiliary sensor in volts are decomposed.
+ */
+func decomposedChannels(meta Metadata, first int, names string) ([]int, error) {
+    selected := []int{}
+    if names == "" {
+        selected = append(selected, 1)
+        for i, aux := range meta.Aux {
+            if isVoltageUnit(aux.Unit) {
+                selected = append(selected, first + i)
+            }
+        }
+        return selected, nil
+    }
+    for _, name := range strings.Split(names, ",") {
+        if name == muscleChannel {
+            selected = append(selected, 1)
+            continue
+        }
+        found := false
+        for i, aux := range meta.Aux {
+            if aux.Name == name {
+                selected = append(selected, first + i)
+                found = true
+            }
+        }
+        if !found {
+            return nil, fmt.Errorf("there is no auxiliary sensor called %s", name)
+        }
+    }
+    sort.Ints(selected)
+    if selected[0] != 1 {
+        return nil, fmt.Errorf("the channels need to include the muscle signal, emg")
+    }
+    return selected, nil
+}
+
+func isVoltageUnit(name string) bool {
+    for _, unit := range voltageUnits {
+        if unit.Name == name {
+            return true
+        }
+    }
+    return name == "uV"
+}
+
+/*
+ A measure of a signal, like its RMS
+ */
+type Measure struct {
+    Name string `json:"name"`
+    Value float64 `json:"value"`
+}
+
+/*
+ The measures of one signal over one segment of a recording
+ */
+type SegmentStatistics struct {
+    Name string
+    Repetition int
+    Start float64
+    End float64
+    Signal string
+    Measures []Measure
+}
+
+/*
+ Returns a measure by its name, or NaN if there is none
+ */
+func (s SegmentStatistics) Measure(name string) float64 {
+    for _, measure := range s.Measures {
+        if measure.Name == name {
+            return measure.Value
+        }
+    }
+    return math.NaN()
+}
+
+/*
+ How many rows of a recording are read before the signals are analyzed. Every signal is analyzed by a goroutine of
+ its own.
+ */
+const analysisChunk = 4096
+
+/*
+ Returns the statistics of every segment of a recording. The segments start with the events of the phases and
+ markers, and segments with the same name are numbered as repetitions. Without events, the whole recording is one
+ segment. The measures are computed for the raw signal and for the last processing stage, if the stages were
+ recorded. With --motion-gate, the samples during motion are left out. The recording is read in chunks, so it can be
+ of any size.
+ */
+func segmentStatistics(file string, meta Metadata, events []RecordedEvent) ([]SegmentStatistics, error) {
+    scanner, err := ScanRecording(file)
+    if err != nil {
+        return nil, err
+    }
+    defer scanner.Close()
+    header := scanner.Header
+    if len(header) < 2 {
+        return nil, fmt.Errorf("the recording has no signal")
+    }
+    first := len(header) - len(meta.Aux)
+    names := []string{"raw"}
+    columns := []int{1}
+    if first > 2 {
+        name, _ := ParseColumn(header[first - 1])
+        names = append(names, name)
+        columns = append(columns, first - 1)
+    }
+    scales := make([]float64, len(columns))
+    for i, column := range columns {
+        _, unit := ParseColumn(header[column])
+        scales[i] = unit.Scale
+    }
+    threshold := 0.0
+    if Settings.MotionGate || meta.MotionGate {
+        threshold = motionThreshold(meta)
+    }
+    stream := NewMotionStream(meta.Aux, meta.Interval, threshold, Settings.MotionHold)
+
+    // Find where the segments start, like the segmenter did during the recording. The rows remember their segment.
+    type span struct {
+        name string
+        repetition int
+        start float64
+        end float64
+    }
+    spans := []span{}
+    repetitions := map[string]int{}
+    name := "start"
+    split := true
+    chunk := []MotionSample{}
+    segment := []int{}
+    add := func(sample MotionSample) {
+        for len(events) > 0 && events[0].Time <= sample.Time {
+            if next, ok := EventSegment(events[0].Event); ok {
+                name = next
+                split = true
+            }
+            events = events[1:]
+        }
+        if split {
+            repetitions[name]++
+            spans = append(spans, span{name, repetitions[name], sample.Time, sample.Time})
+            split = false
+        }
+        spans[len(spans) - 1].end = sample.Time
+        chunk = append(chunk, sample)
+        segment = append(segment, len(spans) - 1)
+    }
+
+    // Every signal keeps the measures of its current segment, and finishes them when the next one starts
+    type state struct {
+        segment int
+        stats RunningStats
+        iemg float64
+        previous float64
+        time float64
+        counted bool
+        finished []SegmentStatistics
+    }
+    states := make([]state, len(names))
+    for n := range states {
+        states[n].segment = -1
+    }
+    finish := func(n int) {
+        s := &states[n]
+        if s.segment < 0 {
+            return
+        }
+        stats := s.stats.Stats()
+        current := spans[s.segment]
+        s.finished = append(s.finished, SegmentStatistics{Name: current.name, Repetition: current.repetition,
+            Start: current.start, End: current.end, Signal: names[n], Measures: []Measure{
+                {"samples", float64(stats.Count)}, {"mean", stats.Mean}, {"rms", stats.RMS}, {"min", stats.Min},
+                {"max", stats.Max}, {"peak", stats.Peak()}, {"iemg", s.iemg}}})
+    }
+    analyze := func() {
+        var wait sync.WaitGroup
+        for n := range names {
+            wait.Add(1)
+            go func(n int) {
+                defer wait.Done()
+                s := &states[n]
+                for i, sample := range chunk {
+                    if segment[i] != s.segment {
+                        finish(n)
+                        *s = state{segment: segment[i], finished: s.finished}
+                    }
+                    if sample.Moving {
+                        s.counted = false
+                        continue
+                    }
+                    value := sample.Values[columns[n]] / scales[n]
+                    s.stats.Add(value)
+                    if s.counted {
+                        s.iemg += (math.Abs(value) + math.Abs(s.previous)) / 2 * (sample.Time - s.time)
+                    }
+                    s.previous, s.time, s.counted = value, sample.Time, true
+                }
+            }(n)
+        }
+        wait.Wait()
+        chunk, segment = chunk[:0], segment[:0]
+    }
+
+    for scanner.Scan() {
+        values := scanner.Values()
+        for _, sample := range stream.Add(values[0], values, values[first:]) {
+            add(sample)
+        }
+        if len(chunk) >= analysisChunk {
+            analyze()
+        }
+    }
+    if scanner.Err() != nil {
+        return nil, scanner.Err()
+    }
+    for _, sample := range stream.Flush() {
+        add(sample)
+    }
+    analyze()
+
+    segments := []SegmentStatistics{}
+    for n := range names {
+        finish(n)
+    }
+    for j := range spans {
+        for n := range names {
+            segments = append(segments, states[n].finished[j])
+        }
+    }
+    return segments, nil
+}
@@ -0,0 +1,288 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+//go:build !nogui
+
+package main
+
+import (
+    "github.com/buger/goterm"
+    "fmt"
+    "math"
+    "time"
+    "sort"
+    "unicode"
+)
+
+/*
+ The keys of the annotation view
+ */
+const annotateHelp = "←→ move   ↑↓ zoom   PgUp/PgDn page   n/p next/previous marker   a add   m move here   " +
+    "r relabel   d delete   w save   q quit"
+
+/*
+ The state of the annotation view. The cursor and the shown range are indexes into the samples of the recording, the
+ selected annotation is an index into the annotations, or -1.
+ */
+type annotationView struct {
+    times []float64
+    values []float64
+    annotations []Annotation
+    creator string
+    unit Unit
+
+    from int
+    span int
+    cursor int
+    selected int
+    changed bool
+    notice string
+}
+
+/*
+ Shows the signal of a recording with its annotations, and edits them with the keys until the view is quit
+ */
+func annotateRecording(file string, creator string, times []float64, values []float64,
+    annotations []Annotation) error {
+    peak := 0.0
+    for _, value := range values {
+        peak = math.Max(peak, math.Abs(value))
+    }
+    view := &annotationView{times: times, values: values, annotations: annotations, creator: creator,
+        unit: AutoUnit.For(peak), span: min(len(times), 10 * annotateWidth()), selected: -1}
+    if len(annotations) > 0 {
+        view.selected = 0
+        view.cursor = view.index(annotations[0].Start)
+    }
+
+    goterm.Clear()
+    warned := false
+    input := ReadKeys()
+    defer RestoreTerminal()
+    for true {
+        view.draw()
+        key, ok := <-input
+        if !ok {
+            return nil
+        }
+        view.notice = ""
+        step := max(1, view.span / annotateWidth())
+        switch key {
+        case KeyLeft:
+            view.cursor -= step
+        case KeyRight:
+            view.cursor += step
+        case KeyPageUp:
+            view.cursor -= view.span
+        case KeyPageDown:
+            view.cursor += view.span
+        case KeyHome:
+            view.cursor = 0
+        case KeyEnd:
+            view.cursor = len(times) - 1
+        case KeyUp:
+            view.span = min(len(times), max(annotateWidth() / 4, view.span / 2))
+        case KeyDown:
+            view.span = min(len(times), view.span * 2)
+        case 'n', '\t':
+            view.jump(1)
+        case 'p':
+            view.jump(-1)
+        case 'a':
+            label, ok := view.prompt("Label", fmt.Sprintf("marker %d", len(view.annotations) + 1), input)
+            if ok {
+                t := times[view.cursor]
+                view.update(append(view.annotations, Annotation{Label: label, Start: t, End: t}),
+                    len(view.annotations))
+            }
+        case 'm':
+            if view.selected >= 0 {
+                annotation := &view.annotations[view.selected]
+                annotation.End += times[view.cursor] - annotation.Start
+                annotation.Start = times[view.cursor]
+                view.update(view.annotations, view.selected)
+            }
+        case 'r':
+            if view.selected >= 0 {
+                label, ok := view.prompt("Label", view.annotations[view.selected].Label, input)
+                if ok {
+                    view.annotations[view.selected].Label = label
+                    view.update(view.annotations, view.selected)
+                }
+            }
+        case 'd', KeyDelete:
+            if view.selected >= 0 {
+                view.annotations = append(view.annotations[:view.selected], view.annotations[view.selected + 1:]...)
+                view.selected = min(view.selected, len(view.annotations) - 1)
+                view.changed = true
+            }
+        case 'w':
+            err := SaveAnnotations(file, view.annotations)
+            if err != nil {
+                view.notice = fmt.Sprintf("Saving failed: %v", err)
+                break
+            }
+            view.changed = false
+            view.notice = fmt.Sprintf("Saved %d markers to %s", len(view.annotations), AnnotationsFile(file))
+        case 'q':
+            // Unsaved changes are only thrown away when q is pressed again
+            if view.changed && !warned {
+                warned = true
+                view.notice = "The markers are not saved. w saves them, q quits anyway."
+                break
+            }
+            goterm.Clear()
+            goterm.MoveCursor(0, 0)
+            goterm.Flush()
+            return nil
+        }
+        warned = warned && key == 'q'
+        view.cursor = min(len(times) - 1, max(0, view.cursor))
+    }
+    return nil
+}
+
+/*
+ Replaces the annotations after one was added or changed, and keeps the one at the given index selected once they are
+ sorted again. The changed annotation is credited to the creator of the view.
+ */
+func (v *annotationView) update(annotations []Annotation, selected int) {
+    annotations[selected].Creator = v.creator
+    annotations[selected].Timestamp = time.Now().UTC().Truncate(time.Second)
+    annotation := annotations[selected]
+    SortAnnotations(annotations)
+    v.annotations = annotations
+    for i := range annotations {
+        if annotations[i] == annotation {
+            v.selected = i
+        }
+    }
+    v.changed = true
+}
+
+/*
+ Selects the next or the previous annotation from the cursor, and moves the cursor to it
+ */
+func (v *annotationView) jump(direction int) {
+    if len(v.annotations) == 0 {
+        return
+    }
+    t := v.times[v.cursor]
+    next := -1
+    for i, annotation := range v.annotations {
+        if direction > 0 && annotation.Start > t && next < 0 {
+            next = i
+        } else if direction < 0 && annotation.Start < t {
+            next = i
+        }
+    }
+    if next < 0 {
+        return
+    }
+    v.selected = next
+    v.cursor = v.index(v.annotations[next].Start)
+}
+
+/*
+ Returns the index of the sample at a time, or of the first one after it
+ */
+func (v *annotationView) index(t float64) int {
+    return min(len(v.times) - 1, sort.SearchFloat64s(v.times, t))
+}
+
+/*
+ Reads a line of text from the keys below the chart, starting with the given text. Enter accepts it, escape cancels.
+ */
+func (v *annotationView) prompt(name string, text string, input <-chan rune) (string, bool) {
+    line := []rune(text)
+    for true {
+        fmt.Printf("\r%s%s: %s", goterm.RESET_LINE, bold(name), string(line))
+        goterm.Flush()
+        key, ok := <-input
+        switch {
+        case !ok || key == 0x1B:
+            return "", false
+        case key == '\r' || key == '\n':
+            return string(line), len(line) > 0
+        case key == 0x7F || key == '\b':
+            if len(line) > 0 {
+                line = line[:len(line) - 1]
+            }
+        case key < KeyUp && unicode.IsPrint(key):
+            line = append(line, key)
+        }
+    }
+    return "", false
+}
+
+/*
+ Draws the range around the cursor, with the annotations in it, and the annotation that is selected below it
+ */
+func (v *annotationView) draw() {
+    width := annotateWidth()
+    if v.cursor < v.from || v.cursor >= v.from + v.span {
+        v.from = v.cursor - v.span / 2
+    }
+    v.from = max(0, min(len(v.times) - v.span, v.from))
+
+    // Long ranges are reduced to the lowest and the highest value of every column, so no burst gets lost
+    step := max(1, v.span / width)
+    keys := []float64{}
+    series := []float64{}
+    cursor := -1
+    for i := v.from; i < v.from + v.span; i += step {
+        low, high := math.Inf(1), math.Inf(-1)
+        for _, value := range v.values[i:min(i + step, len(v.values))] {
+            low, high = math.Min(low, value), math.Max(high, value)
+        }
+        if v.cursor >= i && v.cursor < i + step {
+            cursor = len(keys)
+        }
+        keys = append(keys, v.times[i], v.times[i])
+        series = append(series, v.unit.Convert(low), v.unit.Convert(high))
+    }
+
+    chart := NewChart(width, terminalHeight() - 4)
+    chart.XLabel = "Time [s]"
+    chart.Keys = keys
+    chart.Cursor = cursor
+    chart.Axes[LeftAxis].Label = v.unit.Column("Voltage")
+    chart.AddSeries(LeftAxis, series)
+    for _, annotation := range v.annotations {
+        if annotation.Start >= keys[0] && annotation.Start <= keys[len(keys) - 1] {
+            chart.Marks = append(chart.Marks, sort.SearchFloat64s(keys, annotation.Start))
+        }
+    }
+
+    info := fmt.Sprintf("%.3f s   %s   %d markers", v.times[v.cursor], v.unit.Format(v.values[v.cursor]),
+        len(v.annotations))
+    if v.selected >= 0 {
+        annotation := v.annotations[v.selected]
+        info += fmt.Sprintf("   %s at %.3f s", bold(annotation.Label), annotation.Start)
+        if annotation.Creator != "" {
+            info += " by " + annotation.Creator
+        }
+    }
+    if v.changed {
+        info += "   (not saved)"
+    }
+    if v.notice != "" {
+        info += "   " + v.notice
+    }
+    goterm.MoveCursor(0, 0)
+    fmt.Println(chart.Draw())
+    fmt.Println(goterm.RESET_LINE + info)
+    fmt.Println(goterm.RESET_LINE + annotateHelp)
+    fmt.Print(goterm.RESET_LINE)
+    goterm.Flush()
+}
+
+/*
+ The width of the terminal, which is unknown if the output isn't one. The chart keeps a minimum size then.
+ */
+func annotateWidth() int {
+    return max(20, terminalWidth())
+}
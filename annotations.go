@@ -0,0 +1,352 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "fmt"
+    "flag"
+    "sort"
+    "time"
+    "strings"
+    "strconv"
+    "os/user"
+    "path/filepath"
+    "encoding/json"
+)
+
+func init() {
+    Commands["annotate"] = annotateCommand
+    Commands["merge"] = mergeCommand
+}
+
+const annotationsHeader = "Start;End;Creator;Timestamp;Label"
+
+/*
+ A marker that was added to a recording after it was made, in seconds since the start of the session. A marker at a
+ single moment ends where it starts. The creator is whoever added it, like one of the reviewers of a recording, and
+ the timestamp tells when it was added or last changed.
+ */
+type Annotation struct {
+    Label string `json:"label"`
+    Start float64 `json:"start"`
+    End float64 `json:"end"`
+    Creator string `json:"creator,omitempty"`
+    Timestamp time.Time `json:"timestamp,omitzero"`
+}
+
+/*
+ Returns the event that an annotation stands for, which starts a segment like a marker that was set during the
+ recording (see EventSegment)
+ */
+func (a Annotation) Event() string {
+    if strings.HasPrefix(a.Label, "marker ") {
+        return a.Label
+    }
+    return "marker " + a.Label
+}
+
+/*
+ Returns the path of the file with the annotations that belong to a recording. The recording itself and its events
+ are never changed, so it keeps matching its checksum, and the annotations survive when it is reprocessed.
+ The annotations are kept as data.csv.annotations, which is CSV, or as data.csv.annotations.json. The JSON file is
+ only used if it exists and the CSV file doesn't.
+ */
+func AnnotationsFile(file string) string {
+    path := file + ".annotations"
+    if _, err := os.Stat(path); os.IsNotExist(err) {
+        if _, err := os.Stat(path + ".json"); err == nil {
+            return path + ".json"
+        }
+    }
+    return path
+}
+
+/*
+ Reads the annotations of a recording, sorted by their start. As long as a recording wasn't annotated, its markers
+ (the events that start with "marker") are taken as the annotations to start from.
+ */
+func LoadAnnotations(file string) ([]Annotation, error) {
+    annotations, err := ReadAnnotations(AnnotationsFile(file))
+    if os.IsNotExist(err) {
+        return recordedMarkers(file)
+    }
+    return annotations, err
+}
+
+/*
+ Writes the annotations of a recording, replacing the ones it had
+ */
+func SaveAnnotations(file string, annotations []Annotation) error {
+    return WriteAnnotations(AnnotationsFile(file), annotations)
+}
+
+/*
+ Reads a file of annotations, sorted by their start. Files that end with .json hold a JSON array of annotations,
+ everything else is CSV with the columns of annotationsHeader. The label is the last column, so it can contain
+ semicolons.
+ */
+func ReadAnnotations(path string) ([]Annotation, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    annotations := []Annotation{}
+    if strings.HasSuffix(path, ".json") {
+        err = json.Unmarshal(data, &annotations)
+        if err != nil {
+            return nil, err
+        }
+        SortAnnotations(annotations)
+        return annotations, nil
+    }
+
+    // The first annotations had no creator and no timestamp
+    lines := strings.Split(string(data), "\n")
+    columns := 3
+    if lines[0] == annotationsHeader {
+        columns = 5
+    }
+    for _, line := range lines[1:] {
+        parts := strings.SplitN(line, ";", columns)
+        if len(parts) < columns {
+            continue
+        }
+        annotation := Annotation{Label: parts[columns - 1]}
+        annotation.Start, err = strconv.ParseFloat(parts[0], 64)
+        if err == nil {
+            annotation.End, err = strconv.ParseFloat(parts[1], 64)
+        }
+        if err == nil && columns == 5 {
+            annotation.Creator = parts[2]
+            if parts[3] != "" {
+                annotation.Timestamp, err = time.Parse(time.RFC3339, parts[3])
+            }
+        }
+        if err != nil {
+            return nil, fmt.Errorf("invalid annotation %q", line)
+        }
+        annotations = append(annotations, annotation)
+    }
+    SortAnnotations(annotations)
+    return annotations, nil
+}
+
+/*
+ Writes a file of annotations, as JSON if it ends with .json, or as CSV
+ */
+func WriteAnnotations(path string, annotations []Annotation) error {
+    SortAnnotations(annotations)
+    if strings.HasSuffix(path, ".json") {
+        data, err := json.MarshalIndent(annotations, "", "    ")
+        if err != nil {
+            return err
+        }
+        return os.WriteFile(path, append(data, '\n'), 0600)
+    }
+    clean := strings.NewReplacer("\n", " ", "\r", " ")
+    text := annotationsHeader
+    for _, annotation := range annotations {
+        timestamp := ""
+        if !annotation.Timestamp.IsZero() {
+            timestamp = annotation.Timestamp.UTC().Format(time.RFC3339)
+        }
+        creator := strings.ReplaceAll(clean.Replace(annotation.Creator), ";", ",")
+        text += fmt.Sprintf("\n%f;%f;%s;%s;%s", annotation.Start, annotation.End, creator, timestamp,
+            clean.Replace(annotation.Label))
+    }
+    return os.WriteFile(path, []byte(text + "\n"), 0600)
+}
+
+func SortAnnotations(annotations []Annotation) {
+    sort.SliceStable(annotations, func(i int, j int) bool {
+        return annotations[i].Start < annotations[j].Start
+    })
+}
+
+/*
+ Combines the annotations of several reviewers. Annotations that differ in anything but their timestamp are all
+ kept, so it stays visible where the reviewers disagree. Of those that are the same, the one that was changed last
+ is kept.
+ */
+func MergeAnnotations(sets ...[]Annotation) []Annotation {
+    type key struct {
+        label string
+        start float64
+        end float64
+        creator string
+    }
+    merged := []Annotation{}
+    found := map[key]int{}
+    for _, annotations := range sets {
+        for _, annotation := range annotations {
+            k := key{annotation.Label, annotation.Start, annotation.End, annotation.Creator}
+            i, ok := found[k]
+            if !ok {
+                found[k] = len(merged)
+                merged = append(merged, annotation)
+            } else if annotation.Timestamp.After(merged[i].Timestamp) {
+                merged[i] = annotation
+            }
+        }
+    }
+    SortAnnotations(merged)
+    return merged
+}
+
+/*
+ Reads the events of a recording with its annotations. If the recording was annotated, its annotations replace the
+ markers that were set during the recording, since the annotations started with them (see LoadAnnotations). This is
+ what the analyses use to find the segments of a recording.
+ */
+func ReadAnnotatedEvents(file string) ([]RecordedEvent, error) {
+    events, err := ReadEvents(file)
+    if err != nil {
+        return nil, err
+    }
+    annotations, err := ReadAnnotations(AnnotationsFile(file))
+    if os.IsNotExist(err) {
+        return events, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", filepath.Base(AnnotationsFile(file)), err)
+    }
+    annotated := []RecordedEvent{}
+    for _, event := range events {
+        if !strings.HasPrefix(event.Event, "marker") {
+            annotated = append(annotated, event)
+        }
+    }
+    for _, annotation := range annotations {
+        annotated = append(annotated, RecordedEvent{Time: annotation.Start, Sample: -1, Event: annotation.Event()})
+    }
+    sort.SliceStable(annotated, func(i int, j int) bool {
+        return annotated[i].Time < annotated[j].Time
+    })
+    return annotated, nil
+}
+
+/*
+ Copies the annotations of a recording to another one, like a copy or a reprocessed version of it. Recordings
+ without annotations are skipped.
+ */
+func CopyAnnotations(from string, to string) error {
+    path := AnnotationsFile(from)
+    err := copyFile(path, to + strings.TrimPrefix(path, from))
+    if os.IsNotExist(err) {
+        return nil
+    }
+    return err
+}
+
+/*
+ Returns the markers that were set while a recording was made, as annotations
+ */
+func recordedMarkers(file string) ([]Annotation, error) {
+    events, err := ReadEvents(file)
+    if err != nil {
+        return nil, err
+    }
+    annotations := []Annotation{}
+    for _, event := range events {
+        if strings.HasPrefix(event.Event, "marker") {
+            annotations = append(annotations, Annotation{Start: event.Time, End: event.Time, Label: event.Event})
+        }
+    }
+    return annotations, nil
+}
+
+/*
+ Returns the name that new annotations are created with by default, the name of the user
+ */
+func defaultCreator() string {
+    current, err := user.Current()
+    if err != nil {
+        return os.Getenv("USER")
+    }
+    return current.Username
+}
+
+/*
+ Opens a recording in an interactive view to add, move, relabel and delete its markers, and saves them to
+ data.csv.annotations. The view starts with the markers that were set during the recording, or the annotations that
+ were saved before. The keys are shown below the chart. Annotations that are added or changed are marked with the
+ creator and the time.
+ Example:
+    $ plot annotate --creator=reviewer1 data.csv
+ */
+func annotateCommand(args []string) {
+    flags := flag.NewFlagSet("annotate", flag.ExitOnError)
+    creator := flags.String("creator", defaultCreator(), "Who adds the annotations")
+    flags.Parse(args)
+    if flags.NArg() != 1 {
+        fail("Usage: plot annotate [--creator=name] <file>")
+    }
+    file := flags.Arg(0)
+    times, values, err := ReadSignal(file)
+    if err != nil {
+        fail("%s: %v", file, err)
+    }
+    if len(times) == 0 {
+        fail("%s: the recording is empty", file)
+    }
+    annotations, err := LoadAnnotations(file)
+    if err != nil {
+        fail("%s: %v", file, err)
+    }
+    err = annotateRecording(file, *creator, times, values, annotations)
+    if err != nil {
+        fail("%s: %v", file, err)
+    }
+}
+
+/*
+ Merges the annotations of several reviewers into the annotations of a recording (see MergeAnnotations), or into
+ another file with --output. The files of the reviewers are CSV or JSON, like the annotations of a recording.
+ Annotations without a creator are credited to the file that they come from.
+ Example:
+    $ plot merge data.csv reviewer1.annotations reviewer2.annotations.json
+    data.csv.annotations: 14 annotations from 3 files
+ */
+func mergeCommand(args []string) {
+    flags := flag.NewFlagSet("merge", flag.ExitOnError)
+    output := flags.String("output", "", "The file that the merged annotations are written into, instead of the " +
+        "annotations of the recording. Ends with .json for JSON.")
+    flags.Parse(args)
+    if flags.NArg() < 2 {
+        fail("Usage: plot merge [--output=file] <recording> <annotations>...")
+    }
+    file := flags.Arg(0)
+    if *output == "" {
+        *output = AnnotationsFile(file)
+    }
+    sets := [][]Annotation{}
+    existing, err := ReadAnnotations(AnnotationsFile(file))
+    if err == nil {
+        sets = append(sets, existing)
+    } else if !os.IsNotExist(err) {
+        fail("%s: %v", AnnotationsFile(file), err)
+    }
+    for _, path := range flags.Args()[1:] {
+        annotations, err := ReadAnnotations(path)
+        if err != nil {
+            fail("%s: %v", path, err)
+        }
+        for i := range annotations {
+            if annotations[i].Creator == "" {
+                annotations[i].Creator = filepath.Base(path)
+            }
+        }
+        sets = append(sets, annotations)
+    }
+    merged := MergeAnnotations(sets...)
+    err = WriteAnnotations(*output, merged)
+    if err != nil {
+        fail("%s: %v", *output, err)
+    }
+    fmt.Printf("%s: %d annotations from %d files\n", *output, len(merged), len(sets))
+}
@@ -0,0 +1,412 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "io"
+    "fmt"
+    "math"
+    "bufio"
+    "strconv"
+    "net/url"
+    "encoding/binary"
+)
+
+/*
+ The magic at the start and the end of an Arrow file
+ */
+const arrowMagic = "ARROW1"
+
+/*
+ The values of the enums of the Arrow schema (see Schema.fbs and Message.fbs of Arrow) that are used here
+ */
+const (
+    arrowVersion = 4 // MetadataVersion V5
+    arrowSchema = 1 // MessageHeader
+    arrowRecordBatch = 3 // MessageHeader
+    arrowFloatingPoint = 3 // Type
+    arrowDouble = 2 // Precision
+)
+
+/*
+ Writes tables of float64 columns in the IPC format of Apache Arrow, which pandas, Polars and R read without parsing
+ anything. Every column has the name of its table, and NaN stays NaN. The stream format is a schema followed by record
+ batches, the file format (Feather) wraps it with the magic and a footer that points to the batches:
+    w := NewArrowWriter(out, names, true)
+    w.Write(columns)
+    w.Close()
+ The metadata is encoded as flatbuffers, with the few parts of the format that are needed.
+ */
+type ArrowWriter struct {
+    out io.Writer
+    names []string
+    file bool
+    written int64
+    batches [][3]int64
+    err error
+}
+
+/*
+ Creates a writer for columns with the given names. A file is written in the file format, otherwise the stream
+ format is used.
+ */
+func NewArrowWriter(out io.Writer, names []string, file bool) *ArrowWriter {
+    w := &ArrowWriter{out: out, names: names, file: file}
+    if file {
+        w.write([]byte(arrowMagic + "\x00\x00"))
+    }
+    w.message(arrowSchema, arrowSchemaTable(names), nil)
+    return w
+}
+
+/*
+ Writes a record batch of columns that all have the same length
+ */
+func (w *ArrowWriter) Write(columns [][]float64) error {
+    rows := 0
+    if len(columns) > 0 {
+        rows = len(columns[0])
+    }
+    nodes := []byte{}
+    buffers := []byte{}
+    body := make([]byte, 0, len(columns) * rows * 8)
+    for _, column := range columns {
+        nodes = binary.LittleEndian.AppendUint64(nodes, uint64(rows))
+        nodes = binary.LittleEndian.AppendUint64(nodes, 0)
+
+        // Without nulls, the validity bitmap is left out
+        buffers = binary.LittleEndian.AppendUint64(buffers, uint64(len(body)))
+        buffers = binary.LittleEndian.AppendUint64(buffers, 0)
+        buffers = binary.LittleEndian.AppendUint64(buffers, uint64(len(body)))
+        buffers = binary.LittleEndian.AppendUint64(buffers, uint64(rows * 8))
+        for _, value := range column {
+            body = binary.LittleEndian.AppendUint64(body, math.Float64bits(value))
+        }
+    }
+    batch := flatTable{
+        0: flatScalar(uint64(rows), 8),
+        1: {child: flatStructs{len(columns), nodes}},
+        2: {child: flatStructs{len(columns) * 2, buffers}},
+    }
+    w.message(arrowRecordBatch, batch, body)
+    return w.err
+}
+
+/*
+ Ends the stream, and writes the footer of a file
+ */
+func (w *ArrowWriter) Close() error {
+    w.write([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0})
+    if !w.file {
+        return w.err
+    }
+    blocks := []byte{}
+    for _, batch := range w.batches {
+        blocks = binary.LittleEndian.AppendUint64(blocks, uint64(batch[0]))
+        blocks = binary.LittleEndian.AppendUint32(blocks, uint32(batch[1]))
+        blocks = binary.LittleEndian.AppendUint32(blocks, 0)
+        blocks = binary.LittleEndian.AppendUint64(blocks, uint64(batch[2]))
+    }
+    footer := flatFinish(flatTable{
+        0: flatScalar(arrowVersion, 2),
+        1: {child: arrowSchemaTable(w.names)},
+        2: {child: flatStructs{0, nil}},
+        3: {child: flatStructs{len(w.batches), blocks}},
+    })
+    w.write(footer)
+    w.write(binary.LittleEndian.AppendUint32(nil, uint32(len(footer))))
+    w.write([]byte(arrowMagic))
+    return w.err
+}
+
+/*
+ Writes an encapsulated message: the continuation marker, the size of the metadata, the metadata and the body, each
+ padded to 8 bytes
+ */
+func (w *ArrowWriter) message(kind int, header flatTable, body []byte) {
+    metadata := flatFinish(flatTable{
+        0: flatScalar(arrowVersion, 2),
+        1: flatScalar(uint64(kind), 1),
+        2: {child: header},
+        3: flatScalar(uint64(len(body)), 8),
+    })
+    for len(metadata) % 8 != 0 {
+        metadata = append(metadata, 0)
+    }
+    start := w.written
+    w.write([]byte{0xff, 0xff, 0xff, 0xff})
+    w.write(binary.LittleEndian.AppendUint32(nil, uint32(len(metadata))))
+    w.write(metadata)
+    w.write(body)
+    if kind == arrowRecordBatch {
+        w.batches = append(w.batches, [3]int64{start, int64(len(metadata) + 8), int64(len(body))})
+    }
+}
+
+func (w *ArrowWriter) write(data []byte) {
+    if w.err != nil {
+        return
+    }
+    n, err := w.out.Write(data)
+    w.written += int64(n)
+    w.err = err
+}
+
+/*
+ Returns the first error of the writer, or nil if everything was written so far
+ */
+func (w *ArrowWriter) Err() error {
+    return w.err
+}
+
+/*
+ How many samples an Arrow sink collects into one record batch by default
+ */
+const arrowBatch = 250
+
+/*
+ Streams the samples in the Arrow stream format, for the file and the network sinks with ?format=arrow:
+    --sink=tcp://host:port?format=arrow&batch=250
+ The samples are collected into record batches of the given number of samples, with the columns of a SampleTable.
+ Events and segments don't fit the columns of the samples, so they are left out. Every session starts a new stream
+ with its schema.
+ */
+type ArrowSink struct {
+    output io.WriteCloser
+    buffer *bufio.Writer
+    writer *ArrowWriter
+    session SessionMessage
+    samples []SampleMessage
+    batch int
+}
+
+func NewArrowSink(output io.WriteCloser, buffered bool, batch int) *ArrowSink {
+    sink := &ArrowSink{output: output, batch: batch}
+    if buffered {
+        sink.buffer = bufio.NewWriter(output)
+    }
+    return sink
+}
+
+func (s *ArrowSink) Write(message interface{}) error {
+    switch message := message.(type) {
+    case SessionMessage:
+        if s.writer != nil {
+            s.flush()
+            s.writer.Close()
+        }
+        var out io.Writer = s.output
+        if s.buffer != nil {
+            out = s.buffer
+        }
+        s.session = message
+        s.writer = NewArrowWriter(out, NewSampleTable(message, nil).Names, false)
+        return s.writer.Err()
+    case SampleMessage:
+        if s.writer == nil {
+            return nil
+        }
+        s.samples = append(s.samples, message)
+        if len(s.samples) >= s.batch {
+            return s.flush()
+        }
+    }
+    return nil
+}
+
+func (s *ArrowSink) flush() error {
+    if len(s.samples) > 0 {
+        s.writer.Write(NewSampleTable(s.session, s.samples).Columns)
+        s.samples = s.samples[:0]
+    }
+    return s.writer.Err()
+}
+
+func (s *ArrowSink) Close() error {
+    if s.writer != nil {
+        s.flush()
+        s.writer.Close()
+    }
+    if s.buffer != nil {
+        s.buffer.Flush()
+    }
+    err := s.output.Close()
+    if s.writer != nil && s.writer.Err() != nil {
+        return s.writer.Err()
+    }
+    return err
+}
+
+/*
+ Creates the sink for the format that a URL asks for with ?format=, JSON (see JSONSink) or arrow (see ArrowSink)
+ */
+func NewFormatSink(output io.WriteCloser, buffered bool, target *url.URL) (Sink, error) {
+    query := target.Query()
+    switch query.Get("format") {
+    case "", "json":
+        return NewJSONSink(output, buffered), nil
+    case "arrow":
+        batch := arrowBatch
+        if value := query.Get("batch"); value != "" {
+            parsed, err := strconv.Atoi(value)
+            if err != nil || parsed < 1 {
+                output.Close()
+                return nil, fmt.Errorf("invalid batch %s, expected a number of samples", value)
+            }
+            batch = parsed
+        }
+        return NewArrowSink(output, buffered, batch), nil
+    }
+    output.Close()
+    return nil, fmt.Errorf("unknown format %s, expected json or arrow", query.Get("format"))
+}
+
+/*
+ Returns the schema of float64 columns with the given names
+ */
+func arrowSchemaTable(names []string) flatTable {
+    fields := flatTables{}
+    for _, name := range names {
+        fields = append(fields, flatTable{
+            0: {child: flatString(name)},
+            2: flatScalar(arrowFloatingPoint, 1),
+            3: {child: flatTable{0: flatScalar(arrowDouble, 2)}},
+            5: {child: flatTables{}},
+        })
+    }
+    return flatTable{1: {child: fields}}
+}
+
+/*
+ A minimal writer of flatbuffers, which lays the objects out from the front: a table is followed by the objects that
+ it points to, since offsets can only point forward. Every scalar is aligned to its size, relative to the start of
+ the buffer.
+ */
+type flatObject interface {
+    writeFlat(data []byte) ([]byte, int)
+}
+
+/*
+ A field of a table, either a scalar or an offset to another object. The zero value is a field that is left out.
+ */
+type flatField struct {
+    scalar []byte
+    child flatObject
+}
+
+/*
+ A table, whose fields are indexed by their id
+ */
+type flatTable map[int]flatField
+
+type flatTables []flatTable
+
+/*
+ A vector of structs, which are given as their encoded bytes and aligned to 8
+ */
+type flatStructs struct {
+    count int
+    data []byte
+}
+
+type flatString string
+
+func flatScalar(value uint64, size int) flatField {
+    data := binary.LittleEndian.AppendUint64(nil, value)
+    return flatField{scalar: data[:size]}
+}
+
+/*
+ Returns the buffer with the given root table
+ */
+func flatFinish(root flatTable) []byte {
+    data, position := root.writeFlat(make([]byte, 4))
+    binary.LittleEndian.PutUint32(data, uint32(position))
+    return data
+}
+
+func flatAlign(data []byte, alignment int) []byte {
+    for len(data) % alignment != 0 {
+        data = append(data, 0)
+    }
+    return data
+}
+
+func (t flatTable) writeFlat(data []byte) ([]byte, int) {
+    fields := 0
+    for id := range t {
+        fields = max(fields, id + 1)
+    }
+
+    // The vtable comes first, the table starts at 8 bytes, so the offsets of the fields can be aligned to it
+    data = flatAlign(data, 2)
+    vtable := len(data)
+    data = append(data, make([]byte, 4 + 2 * fields)...)
+    data = flatAlign(data, 8)
+    table := len(data)
+    data = binary.LittleEndian.AppendUint32(data, uint32(table - vtable))
+    offsets := map[int]int{}
+    for id := 0; id < fields; id++ {
+        field, ok := t[id]
+        if !ok {
+            continue
+        }
+        size := len(field.scalar)
+        if field.child != nil {
+            size = 4
+        }
+        data = flatAlign(data, size)
+        offsets[id] = len(data)
+        binary.LittleEndian.PutUint16(data[vtable + 4 + 2 * id:], uint16(len(data) - table))
+        if field.child != nil {
+            data = append(data, 0, 0, 0, 0)
+        } else {
+            data = append(data, field.scalar...)
+        }
+    }
+    binary.LittleEndian.PutUint16(data[vtable:], uint16(4 + 2 * fields))
+    binary.LittleEndian.PutUint16(data[vtable + 2:], uint16(len(data) - table))
+    for id := 0; id < fields; id++ {
+        if field, ok := t[id]; ok && field.child != nil {
+            var position int
+            data, position = field.child.writeFlat(data)
+            binary.LittleEndian.PutUint32(data[offsets[id]:], uint32(position - offsets[id]))
+        }
+    }
+    return data, table
+}
+
+func (v flatTables) writeFlat(data []byte) ([]byte, int) {
+    data = flatAlign(data, 4)
+    vector := len(data)
+    data = binary.LittleEndian.AppendUint32(data, uint32(len(v)))
+    data = append(data, make([]byte, 4 * len(v))...)
+    for i, table := range v {
+        var position int
+        data, position = table.writeFlat(data)
+        element := vector + 4 + 4 * i
+        binary.LittleEndian.PutUint32(data[element:], uint32(position - element))
+    }
+    return data, vector
+}
+
+func (v flatStructs) writeFlat(data []byte) ([]byte, int) {
+    for (len(data) + 4) % 8 != 0 {
+        data = append(data, 0)
+    }
+    vector := len(data)
+    data = binary.LittleEndian.AppendUint32(data, uint32(v.count))
+    return append(data, v.data...), vector
+}
+
+func (s flatString) writeFlat(data []byte) ([]byte, int) {
+    data = flatAlign(data, 4)
+    position := len(data)
+    data = binary.LittleEndian.AppendUint32(data, uint32(len(s)))
+    data = append(data, s...)
+    return append(data, 0), position
+}
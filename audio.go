@@ -0,0 +1,130 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "bufio"
+    "os/exec"
+    "strings"
+    "strconv"
+    "net/url"
+    "encoding/binary"
+)
+
+func init() {
+    Sources["audio"] = grabDataFromAudio
+    SourceBackends["audio"] = Backend{
+        Usage: "audio://<device>?rate=8000&channels=1&channel=1&scale=1",
+        Description: "An amplifier connected to a sound card, captured with arecord",
+        Probe: probeAudio,
+    }
+}
+
+/*
+ This function captures a sound card using arecord from alsa-utils, for EMG amplifiers that output their signal to an
+ audio interface. The source is given as
+    audio://<device>?rate=<rate>&channels=<channels>&channel=<channel>&scale=<scale>
+ where the device is an ALSA device like default or plughw:1,0 (which can be given as audio:plughw:1,0 too). The rate
+ defaults to 8000 samples per second, since most sound cards don't support less, and overrides --interval. Of several
+ channels, the given one (starting at 1) is the muscle sensor. The scale is the voltage of a full scale signal, which
+ depends on the amplifier and the gain of the input.
+ */
+func grabDataFromAudio(pipeline *Pipeline, target *url.URL) error {
+    query := target.Query()
+    device := target.Host
+    if target.Opaque != "" {
+        device = target.Opaque
+    }
+    if device == "" {
+        device = "default"
+    }
+    rate, err := queryInt(query, "rate", 8000)
+    if err != nil {
+        return err
+    }
+    channels, err := queryInt(query, "channels", 1)
+    if err != nil {
+        return err
+    }
+    channel, err := queryInt(query, "channel", 1)
+    if err != nil {
+        return err
+    }
+    if channel < 1 || channel > channels {
+        return fmt.Errorf("the sound card has no channel %d", channel)
+    }
+    scale := float64(1)
+    if query.Get("scale") != "" {
+        scale, err = strconv.ParseFloat(query.Get("scale"), 64)
+        if err != nil {
+            return err
+        }
+    }
+
+    // Capture signed 16 bit samples, interleaved by channel
+    capture := exec.Command("arecord", "-q", "-D", device, "-t", "raw", "-f", "S16_LE",
+        "-r", strconv.Itoa(rate), "-c", strconv.Itoa(channels))
+    stdout, err := capture.StdoutPipe()
+    if err != nil {
+        return err
+    }
+    err = capture.Start()
+    if err != nil {
+        return err
+    }
+    defer capture.Process.Kill()
+
+    // The clock of the sound card defines the timing
+    Settings.Interval = 1 / float64(rate)
+    startRecording(pipeline, "hardware")
+    if Settings.Watchdog > 0 {
+        go RunWatchdog(pipeline, watchdogTimeout())
+    }
+
+    reader := bufio.NewReader(stdout)
+    frame := make([]byte, 2 * channels)
+    for x := 0; true; x++ {
+        _, err := io.ReadFull(reader, frame)
+        if err == io.EOF || err == io.ErrUnexpectedEOF {
+            return capture.Wait()
+        }
+        if err != nil {
+            return err
+        }
+        raw := int16(binary.LittleEndian.Uint16(frame[2 * (channel - 1):]))
+        pipeline.Push(Sample{Index: x, Time: float64(x) * Settings.Interval, Value: float64(raw) / 32768 * scale})
+    }
+    return nil
+}
+
+/*
+ Reads an integer parameter of a source, or returns the default if it wasn't given
+ */
+func queryInt(query url.Values, name string, fallback int) (int, error) {
+    if query.Get(name) == "" {
+        return fallback, nil
+    }
+    return strconv.Atoi(query.Get(name))
+}
+
+/*
+ Checks whether arecord is installed and the system has a sound card
+ */
+func probeAudio(target *url.URL) error {
+    err := probeProgram("arecord")
+    if err != nil {
+        return err
+    }
+    cards, err := os.ReadFile("/proc/asound/cards")
+    if err != nil || strings.Contains(string(cards), "no soundcards") {
+        return fmt.Errorf("no sound card found")
+    }
+    return nil
+}
@@ -0,0 +1,135 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "math"
+    "sort"
+    "time"
+    "strings"
+    "strconv"
+)
+
+/*
+ Chooses the range of the Y axis. Instead of the minimum and maximum of the plotted values, percentiles are used, so a
+ single artifact doesn't squash the rest of the signal into a flat line; values outside of the range are clipped to
+ its edges. The range grows immediately, but is held for a while before it shrinks again, which keeps the chart from
+ jumping around. It can be switched to a fixed range at any time.
+ */
+type Autoscale struct {
+
+    /*
+     The percentage of values that is clipped at either end, e.g. 1 for the 1st to the 99th percentile
+     */
+    Clip float64
+
+    /*
+     How long the range is held before it shrinks. The range then shrinks by half of the difference every hold time.
+     */
+    Hold time.Duration
+
+    /*
+     Whether the range is fixed to Min and Max
+     */
+    Fixed bool
+    Min float64
+    Max float64
+
+    low float64
+    high float64
+    held time.Time
+    updated time.Time
+    ready bool
+}
+
+/*
+ Creates the autoscaling. If a range like -0.002:0.002 (in volts) is given, the range starts out as fixed.
+ */
+func NewAutoscale(clip float64, hold float64, fixed string) (*Autoscale, error) {
+    if clip < 0 || clip >= 50 {
+        return nil, fmt.Errorf("cannot clip %g%% at both ends", clip)
+    }
+    a := &Autoscale{Clip: clip, Hold: time.Duration(hold * 1000 * 1000 * 1000)}
+    if fixed == "" {
+        return a, nil
+    }
+    parts := strings.Split(fixed, ":")
+    if len(parts) != 2 {
+        return nil, fmt.Errorf("invalid range %s, expected min:max", fixed)
+    }
+    min, err := strconv.ParseFloat(parts[0], 64)
+    if err != nil {
+        return nil, err
+    }
+    max, err := strconv.ParseFloat(parts[1], 64)
+    if err != nil {
+        return nil, err
+    }
+    if min >= max {
+        return nil, fmt.Errorf("invalid range %s, the minimum is not below the maximum", fixed)
+    }
+    a.Fixed = true
+    a.Min = min
+    a.Max = max
+    return a, nil
+}
+
+/*
+ Returns the range that the given values should be displayed in
+ */
+func (a *Autoscale) Range(values []float64) (float64, float64) {
+    if a.Fixed || len(values) == 0 {
+        return a.Min, a.Max
+    }
+    sorted := append([]float64{}, values...)
+    sort.Float64s(sorted)
+    low := percentile(sorted, a.Clip)
+    high := percentile(sorted, 100 - a.Clip)
+
+    now := time.Now()
+    if !a.ready || a.Hold == 0 {
+        a.low = low
+        a.high = high
+        a.held = now
+        a.ready = true
+    } else if low < a.low || high > a.high {
+        a.low = math.Min(a.low, low)
+        a.high = math.Max(a.high, high)
+        a.held = now
+    } else if now.Sub(a.held) > a.Hold {
+        factor := 1 - math.Pow(0.5, now.Sub(a.updated).Seconds() / a.Hold.Seconds())
+        a.low += (low - a.low) * factor
+        a.high += (high - a.high) * factor
+    }
+    a.updated = now
+
+    // Remember the range, so switching to a fixed range keeps what is currently displayed
+    a.Min = a.low
+    a.Max = a.high
+    return a.low, a.high
+}
+
+/*
+ Switches between the automatic and the fixed range
+ */
+func (a *Autoscale) Toggle() {
+    a.Fixed = !a.Fixed
+    a.ready = false
+}
+
+/*
+ Returns the value at the given percentile of sorted values, interpolating between neighbours
+ */
+func percentile(sorted []float64, p float64) float64 {
+    position := p / 100 * float64(len(sorted) - 1)
+    i := int(position)
+    if i >= len(sorted) - 1 {
+        return sorted[len(sorted) - 1]
+    }
+    return sorted[i] + (sorted[i + 1] - sorted[i]) * (position - float64(i))
+}
@@ -0,0 +1,146 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "strings"
+    "strconv"
+)
+
+/*
+ An additional sensor that is recorded together with the muscle activity, like a force sensor, a goniometer or an
+ analog accelerometer. The voltage of the sensor is converted into its own unit using a linear calibration:
+    value = voltage * Scale + Offset
+ */
+type AuxChannel struct {
+
+    /*
+     The channel of the ADCPi where the sensor is connected
+     */
+    Channel int
+
+    /*
+     The name of the sensor, and the unit of its values (for example "force" and "N")
+     */
+    Name string
+    Unit string
+
+    Scale float64
+    Offset float64
+
+    /*
+     Where the values come from. Empty for a channel of the ADCPi, "imu" for a channel of the IMU, "hx711" for a load
+     cell.
+     */
+    Sensor string `json:",omitempty"`
+}
+
+/*
+ Converts a voltage into the unit of the sensor
+ */
+func (a AuxChannel) Convert(volts float64) float64 {
+    return volts * a.Scale + a.Offset
+}
+
+/*
+ The name of the column of the sensor, e.g. "force [N]"
+ */
+func (a AuxChannel) Column() string {
+    return fmt.Sprintf("%s [%s]", a.Name, a.Unit)
+}
+
+/*
+ A list of auxiliary sensors that can be given on the command line. Every sensor is described as
+    channel:name:unit[:scale[:offset]]
+ for example --aux=2:force:N:250 --aux=3:angle:deg:90:-45
+ */
+type AuxChannels []AuxChannel
+
+func (a *AuxChannels) String() string {
+    descriptions := []string{}
+    for _, aux := range *a {
+        if aux.Sensor != "" {
+            continue
+        }
+        descriptions = append(descriptions, fmt.Sprintf("%d:%s:%s:%g:%g", aux.Channel, aux.Name, aux.Unit, aux.Scale,
+            aux.Offset))
+    }
+    return strings.Join(descriptions, ",")
+}
+
+func (a *AuxChannels) Set(description string) error {
+    // Several sensors can be given at once, separated by commas, which is how String returns them
+    if strings.Contains(description, ",") {
+        for _, single := range strings.Split(description, ",") {
+            err := a.Set(single)
+            if err != nil {
+                return err
+            }
+        }
+        return nil
+    }
+    parts := strings.Split(description, ":")
+    if len(parts) < 3 || len(parts) > 5 {
+        return fmt.Errorf("expected channel:name:unit[:scale[:offset]], got %s", description)
+    }
+    aux := AuxChannel{Name: parts[1], Unit: parts[2], Scale: 1}
+    channel, err := strconv.Atoi(parts[0])
+    if err != nil {
+        return err
+    }
+    aux.Channel = channel
+    if len(parts) > 3 {
+        aux.Scale, err = strconv.ParseFloat(parts[3], 64)
+        if err != nil {
+            return err
+        }
+    }
+    if len(parts) > 4 {
+        aux.Offset, err = strconv.ParseFloat(parts[4], 64)
+        if err != nil {
+            return err
+        }
+    }
+    *a = append(*a, aux)
+    return nil
+}
+
+/*
+ Returns the sensors that are drawn on the right axis of the chart, given by their names separated by commas. By
+ default, the first sensor is drawn. Since the sensors share the scale of the axis, they need to have the same unit.
+ */
+func (a AuxChannels) Plotted(names string) ([]int, error) {
+    if names == "" {
+        if len(a) == 0 {
+            return nil, nil
+        }
+        return []int{0}, nil
+    }
+    if names == "none" {
+        return nil, nil
+    }
+    plotted := []int{}
+    for _, name := range strings.Split(names, ",") {
+        found := false
+        for i, aux := range a {
+            if aux.Name == name {
+                plotted = append(plotted, i)
+                found = true
+            }
+        }
+        if !found {
+            return nil, fmt.Errorf("there is no auxiliary sensor called %s", name)
+        }
+    }
+    for _, i := range plotted {
+        if a[i].Unit != a[plotted[0]].Unit {
+            return nil, fmt.Errorf("%s and %s can't share an axis, their units differ", a[plotted[0]].Name, a[i].Name)
+        }
+    }
+    return plotted, nil
+}
@@ -0,0 +1,219 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "fmt"
+    "net"
+    "flag"
+    "sort"
+    "time"
+    "strings"
+    "net/url"
+    "os/exec"
+    "path/filepath"
+)
+
+func init() {
+    Commands["sources"] = sourcesCommand
+    Commands["sinks"] = sinksCommand
+}
+
+/*
+ How long a probe waits for a host to answer
+ */
+const probeTimeout = 3 * time.Second
+
+/*
+ Describes a source or a sink for "plot sources" and "plot sinks": how its URL is written, what it does, and how to
+ find out whether it can be used on this device. The probe gets the URL that the user wants to check, or nil, in
+ which case it only checks what the backend needs in general (a bus, an adapter, a network). A backend without a
+ probe is always available.
+ */
+type Backend struct {
+    Usage string
+    Description string
+    Probe func(target *url.URL) error
+}
+
+/*
+ The descriptions of the sources and sinks, registered next to them. The ADCPi isn't selected with --source, so it is
+ described here.
+ */
+var SourceBackends = map[string]Backend{
+    "adcpi": {
+        Usage: "--bus=/dev/i2c-1 --address=0x68 --channel=1 (without --source)",
+        Description: "The ADCPi or another MCP3424 on the I2C bus of the Pi",
+        Probe: func(target *url.URL) error {
+            return probeI2C(Settings.Bus, Settings.Address)
+        },
+    },
+}
+var SinkBackends = map[string]Backend{}
+
+/*
+ Lists the sources this build supports, and whether they can be used on this device. With URLs, only those are
+ probed, which for network sources means connecting to the host:
+    $ plot sources
+    $ plot sources tcp://pi4.local:9000
+ */
+func sourcesCommand(args []string) {
+    names := append([]string{"adcpi"}, SourceNames()...)
+    listBackends("sources", args, names, SourceBackends)
+    ports := SerialPorts()
+    if len(ports) == 0 {
+        ports = []string{"none"}
+    }
+    fmt.Printf("\nSerial ports: %s\n", strings.Join(ports, ", "))
+}
+
+/*
+ Lists the sinks this build supports, and whether they can be used on this device. With URLs, only those are probed:
+    $ plot sinks mqtt://broker.local/lab/emg
+ */
+func sinksCommand(args []string) {
+    listBackends("sinks", args, SinkNames(), SinkBackends)
+}
+
+func listBackends(kind string, args []string, names []string, backends map[string]Backend) {
+    flags := flag.NewFlagSet(kind, flag.ExitOnError)
+    flags.StringVar(&(Settings.Bus), "bus", "/dev/i2c-1", "The I2C bus that is probed")
+    flags.IntVar(&(Settings.Address), "address", 0x68, "The I2C address of the ADCPi that is probed")
+    flags.Parse(args)
+
+    // Without URLs, every backend is probed in general
+    targets := []*url.URL{}
+    for _, arg := range flags.Args() {
+        target, err := url.Parse(arg)
+        if err != nil {
+            fail("%v", err)
+        }
+        if _, ok := backends[target.Scheme]; !ok {
+            fail("unknown %s %s, expected one of %s", strings.TrimSuffix(kind, "s"), target.Scheme,
+                strings.Join(names, ", "))
+        }
+        targets = append(targets, target)
+    }
+    if len(targets) == 0 {
+        for _, name := range names {
+            targets = append(targets, &url.URL{Scheme: name})
+        }
+    }
+
+    if len(flags.Args()) == 0 && len(Omitted) > 0 {
+        fmt.Printf("This build leaves out %s\n\n", strings.Join(Omitted, ", "))
+    }
+    ok := true
+    for _, target := range targets {
+        backend := backends[target.Scheme]
+        probed := target
+        if target.String() == target.Scheme + ":" {
+            probed = nil
+        }
+        state := "available"
+        if backend.Probe != nil {
+            err := backend.Probe(probed)
+            if err != nil {
+                state = fmt.Sprintf("unavailable (%v)", err)
+                ok = false
+            }
+        }
+        if probed != nil {
+            fmt.Printf("%s: %s\n", probed, state)
+            continue
+        }
+        fmt.Printf("%-8s %s\n", target.Scheme, backend.Description)
+        fmt.Printf("%-8s %s\n", "", backend.Usage)
+        fmt.Printf("%-8s %s\n", "", state)
+    }
+    if !ok && len(flags.Args()) > 0 {
+        os.Exit(1)
+    }
+}
+
+/*
+ Checks whether a device answers on an I2C bus
+ */
+func probeI2C(bus string, address int) error {
+    device, err := OpenI2C(bus, address)
+    if err != nil {
+        return err
+    }
+    defer device.Close()
+    _, err = device.Read(make([]byte, 1))
+    if err != nil {
+        return fmt.Errorf("no device at 0x%02x on %s", address, bus)
+    }
+    return nil
+}
+
+/*
+ Checks whether the host of a URL accepts connections, using the port of the URL or the given default. Without a URL,
+ this only checks whether the device is connected to a network at all.
+ */
+func probeHost(target *url.URL, port string) error {
+    if target == nil {
+        return probeNetwork()
+    }
+    host := target.Host
+    if target.Port() == "" {
+        if port == "" {
+            return fmt.Errorf("no port given")
+        }
+        host = net.JoinHostPort(target.Hostname(), port)
+    }
+    connection, err := net.DialTimeout("tcp", host, probeTimeout)
+    if err != nil {
+        return err
+    }
+    return connection.Close()
+}
+
+/*
+ Checks whether a network interface other than the loopback is up and has an address
+ */
+func probeNetwork() error {
+    interfaces, err := net.Interfaces()
+    if err != nil {
+        return err
+    }
+    for _, i := range interfaces {
+        if i.Flags & net.FlagUp == 0 || i.Flags & net.FlagLoopback != 0 {
+            continue
+        }
+        addresses, err := i.Addrs()
+        if err == nil && len(addresses) > 0 {
+            return nil
+        }
+    }
+    return fmt.Errorf("not connected to a network")
+}
+
+/*
+ Checks whether a program is installed
+ */
+func probeProgram(name string) error {
+    _, err := exec.LookPath(name)
+    if err != nil {
+        return fmt.Errorf("%s is not installed", name)
+    }
+    return nil
+}
+
+/*
+ Returns the serial ports of the device: USB adapters, boards with a USB modem interface and the UART of the Pi
+ */
+func SerialPorts() []string {
+    ports := []string{}
+    for _, pattern := range []string{"/dev/ttyUSB*", "/dev/ttyACM*", "/dev/serial0", "/dev/rfcomm*"} {
+        matches, _ := filepath.Glob(pattern)
+        ports = append(ports, matches...)
+    }
+    sort.Strings(ports)
+    return ports
+}
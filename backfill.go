@@ -0,0 +1,117 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "flag"
+    "strings"
+)
+
+func init() {
+    Commands["backfill"] = backfillCommand
+}
+
+/*
+ Streams existing recordings to sinks, so old sessions show up in the same dashboards as new ones. The samples are
+ pushed through the pipeline as fast as the sinks accept them, with their original timestamps. The processing stages
+ of the session are applied again, and its events are streamed at their times, so the stream is the same as if the
+ session was streamed live.
+ Example:
+    $ plot backfill --sink=influx://server:8086/emg data.csv
+ */
+func backfillCommand(args []string) {
+    flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+    sinks := SinkList{}
+    flags.Var(&sinks, "sink", "A sink that the recordings are streamed to, given as URL: " +
+        strings.Join(SinkNames(), ", ") + ". Can be given several times. Add ?rate=hz&aggregate=" +
+        strings.Join(AggregateNames(), "|") + " to send it fewer samples.")
+    flags.StringVar(&(Settings.KeyFile), "key", "", "The key file for encrypted recordings")
+    flags.Parse(args)
+    if flags.NArg() == 0 || len(sinks) == 0 {
+        fail("Usage: plot backfill --sink=url... [--key=file] <file>...")
+    }
+    for _, file := range flags.Args() {
+        count, err := Backfill(file, sinks)
+        if err != nil {
+            fail("%s: %v", file, err)
+        }
+        fmt.Printf("%s: streamed %d samples\n", file, count)
+    }
+}
+
+/*
+ Streams a recording to the sinks and returns the amount of samples
+ */
+func Backfill(file string, urls []string) (int, error) {
+    meta, err := LoadMetadata(file)
+    if err != nil {
+        return 0, err
+    }
+    scanner, err := ScanRecording(file)
+    if err != nil {
+        return 0, err
+    }
+    defer scanner.Close()
+    if len(scanner.Header) < 2 {
+        return 0, fmt.Errorf("the recording has no signal")
+    }
+    events, err := ReadAnnotatedEvents(file)
+    if err != nil {
+        return 0, err
+    }
+
+    // Process the samples like the session did
+    Settings.Filter = meta.Filters
+    Settings.Interval = meta.Interval
+    Settings.ECG = meta.Mode == "ecg"
+    Settings.Aux = meta.Aux
+    Settings.Reference = meta.Reference
+    Settings.CoContraction = meta.CoContraction
+    pipeline := NewPipeline()
+    setupFilters(pipeline)
+    sinks, err := OpenSinks(urls)
+    if err != nil {
+        return 0, err
+    }
+    stream, err := NewStream(sinks, meta, pipeline.Filters.Names, false)
+    if err != nil {
+        stream.Close()
+        return 0, err
+    }
+    pipeline.StreamTo(stream)
+
+    _, unit := ParseColumn(scanner.Header[1])
+    first := len(scanner.Header) - len(meta.Aux)
+    count := 0
+    for scanner.Scan() {
+        values := scanner.Values()
+        t := values[0]
+        for len(events) > 0 && events[0].Time <= t {
+            pipeline.EventAt(eventSample(events[0], count), events[0].Time, events[0].Event)
+            if segment, ok := EventSegment(events[0].Event); ok {
+                pipeline.Segments.Split(segment)
+            }
+            events = events[1:]
+        }
+        sample := Sample{Index: count, Time: t, Value: values[1] / unit.Scale}
+        sample.Aux = append(sample.Aux, values[first:]...)
+        pipeline.Push(sample)
+        count++
+        if stream.Err() != nil {
+            break
+        }
+    }
+    for _, event := range events {
+        pipeline.EventAt(eventSample(event, count), event.Time, event.Event)
+    }
+    pipeline.Close()
+    if scanner.Err() != nil {
+        return count, scanner.Err()
+    }
+    return count, stream.Err()
+}
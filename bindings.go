@@ -0,0 +1,164 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "strings"
+    "strconv"
+    "unicode/utf8"
+)
+
+/*
+ A key of the display and the action that it carries out
+ */
+type Binding struct {
+    Key rune
+    Action string
+    Description string
+}
+
+/*
+ The keys of the display. The display looks up the action of a key here, and the help (the ? key) lists this table,
+ so it is always what the keys really do. The keys can be changed in the configuration (see RemapKeys).
+ */
+var Bindings = []Binding{
+    {'a', "autoscale", "Switch between the automatic and the fixed range"},
+    {'s', "smoothing", "Switch the kind of smoothing"},
+    {'+', "smooth-more", "Double the window of the smoothing"},
+    {'-', "smooth-less", "Halve the window of the smoothing"},
+    {'p', "pause", "Pause and resume the recording"},
+    {'r', "playback", "Play back what was recorded so far, and return to the acquisition"},
+    {'t', "trigger", "Fire the trigger"},
+    {'m', "marker", "Set a marker that starts a new segment"},
+    {'f', "freeze", "Freeze the display and read out the values under a cursor, and unfreeze it"},
+    {KeyLeft, "cursor-back", "Move the cursor one sample back (frozen)"},
+    {KeyRight, "cursor-forward", "Move the cursor one sample forward (frozen)"},
+    {KeyDown, "cursor-back-10", "Move the cursor ten samples back (frozen)"},
+    {KeyUp, "cursor-forward-10", "Move the cursor ten samples forward (frozen)"},
+    {'v', "select", "Start a selection at the cursor, and clear it (frozen)"},
+    {'x', "export", "Export the selected samples as CSV (frozen)"},
+    {'l', "loop", "Set the start of a loop, then its end, and clear it, or loop the selection (playback)"},
+    {'?', "help", "Show this help and its next page"},
+}
+
+/*
+ Returns the action of a key, or an empty string if the key isn't bound
+ */
+func KeyAction(key rune) string {
+    for _, binding := range Bindings {
+        if binding.Key == key {
+            return binding.Action
+        }
+    }
+    return ""
+}
+
+/*
+ The names of the keys that aren't written as the character they type
+ */
+var keyNames = map[rune]string{KeyUp: "Up", KeyDown: "Down", KeyRight: "Right", KeyLeft: "Left", KeyHome: "Home",
+    KeyEnd: "End", KeyInsert: "Insert", KeyDelete: "Delete", KeyPageUp: "PageUp", KeyPageDown: "PageDown",
+    ' ': "Space", ',': "Comma", 0x1B: "Esc", '\t': "Tab", '\n': "Enter", 0x7F: "Backspace"}
+
+/*
+ Returns how a key is written in the help and in the configuration
+ */
+func KeyName(key rune) string {
+    if name, ok := keyNames[key]; ok {
+        return name
+    } else if key >= KeyF1 && key < KeyF1 + 20 {
+        return fmt.Sprintf("F%d", key - KeyF1 + 1)
+    } else if key < ' ' {
+        return fmt.Sprintf("Ctrl+%c", key + '@')
+    }
+    return string(key)
+}
+
+/*
+ Parses the name of a key, as returned by KeyName: a character like m, a special key like Space, PageDown or F13,
+ Ctrl+<letter>, or the code of any other key as U+<hex>
+ */
+func ParseKey(name string) (rune, error) {
+    if utf8.RuneCountInString(name) == 1 {
+        key, _ := utf8.DecodeRuneInString(name)
+        return key, nil
+    }
+    for key, n := range keyNames {
+        if strings.EqualFold(n, name) {
+            return key, nil
+        }
+    }
+    upper := strings.ToUpper(name)
+    switch {
+    case strings.HasPrefix(upper, "F"):
+        n, err := strconv.Atoi(upper[1:])
+        if err == nil && n >= 1 && n <= 20 {
+            return KeyF1 + rune(n - 1), nil
+        }
+    case strings.HasPrefix(upper, "CTRL+"):
+        letter := upper[len("CTRL+"):]
+        if len(letter) == 1 && letter[0] >= '@' && letter[0] <= '_' {
+            return rune(letter[0] - '@'), nil
+        }
+    case strings.HasPrefix(upper, "U+"):
+        code, err := strconv.ParseInt(upper[2:], 16, 32)
+        if err == nil {
+            return rune(code), nil
+        }
+    }
+    return 0, fmt.Errorf("unknown key %q", name)
+}
+
+/*
+ Changes the keys of actions, given as the action and its keys, separated by commas:
+    {"marker": "F13", "pause": "F14,p"}
+ The new keys replace all keys of the action. A key that ends up with two actions is an error, since one of them
+ couldn't be used anymore.
+ */
+func RemapKeys(keys map[string]string) error {
+    remapped := []Binding{}
+    done := map[string]bool{}
+    for _, binding := range Bindings {
+        names, ok := keys[binding.Action]
+        if !ok {
+            remapped = append(remapped, binding)
+            continue
+        } else if done[binding.Action] {
+            continue
+        }
+
+        // The new keys take the place of the first key of the action, so the help keeps its order
+        if names == "" {
+            return fmt.Errorf("the action %s has no keys", binding.Action)
+        }
+        for _, name := range strings.Split(names, ",") {
+            key, err := ParseKey(strings.TrimSpace(name))
+            if err != nil {
+                return fmt.Errorf("%s: %v", binding.Action, err)
+            }
+            remapped = append(remapped, Binding{key, binding.Action, binding.Description})
+        }
+        done[binding.Action] = true
+    }
+    for action := range keys {
+        if !done[action] {
+            return fmt.Errorf("unknown action %s", action)
+        }
+    }
+
+    // Two actions on one key are a conflict, the same key twice for one action is harmless
+    actions := map[rune]string{}
+    for _, binding := range remapped {
+        if action, ok := actions[binding.Key]; ok && action != binding.Action {
+            return fmt.Errorf("the key %s is bound to both %s and %s", KeyName(binding.Key), action, binding.Action)
+        }
+        actions[binding.Key] = binding.Action
+    }
+    Bindings = remapped
+    return nil
+}
@@ -0,0 +1,350 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "fmt"
+    "time"
+    "bufio"
+    "unsafe"
+    "strings"
+    "strconv"
+    "syscall"
+    "net/url"
+)
+
+func init() {
+    Sources["bitalino"] = grabDataFromBITalino
+    SourceChannels["bitalino"] = describeBITalino
+    SourceBackends["bitalino"] = Backend{
+        Usage: "bitalino://<address>?channel=1&channels=1,2&emg=2&rate=1000 or bitalino:///dev/rfcomm0",
+        Description: "A BITalino (r)evolution board over Bluetooth",
+        Probe: probeBITalino,
+    }
+}
+
+// Constants of the RFCOMM sockets of the kernel, and of the protocol of the BITalino
+const (
+    btprotoRFCOMM = 3
+    bitalinoChannel = 1
+
+    bitalinoIdle = 0x00
+    bitalinoVersion = 0x07
+)
+
+/*
+ How long the board may take to answer
+ */
+const bitalinoTimeout = 5 * time.Second
+
+/*
+ The codes of the sample rates of the BITalino
+ */
+var bitalinoRates = map[int]byte{1: 0, 10: 1, 100: 2, 1000: 3}
+
+/*
+ The address of an RFCOMM socket (struct sockaddr_rc)
+ */
+type sockaddrRC struct {
+    family uint16
+    bdaddr [6]byte
+    channel uint8
+    _ uint8
+}
+
+/*
+ This function reads a BITalino (r)evolution board, the EMG kit that many courses use, over the Bluetooth serial port
+ that it offers. The source is given as
+    bitalino://<address>?channel=<channel>&channels=<channels>&emg=<channels>&rate=<rate>
+ to connect to the board directly, or with the serial port that the board was bound to (bitalino:///dev/rfcomm0).
+ Either way the board has to be paired first, its PIN is 1234. An address that ends with a letter has to be written
+ with dashes (98-D3-31-B2-BB-3C). Of the analog inputs A1 to A6, the given channel is the EMG sensor, and channels
+ lists the other ones that are recorded as auxiliary channels (A1, A2 ...). The board can't tell which sensor is
+ connected to an input, so the auxiliary channels are recorded as raw readings of the ADC unless emg lists them; EMG
+ is converted into volts. The rate is 1, 10, 100 or 1000 samples per second. The inputs A5 and A6 only have 6 bits
+ when more than four inputs are recorded.
+ */
+func grabDataFromBITalino(pipeline *Pipeline, target *url.URL) error {
+    query := target.Query()
+    channel, inputs, emg, err := bitalinoLayout(query)
+    if err != nil {
+        return err
+    }
+    rate, err := queryInt(query, "rate", 1000)
+    if err != nil {
+        return err
+    }
+    if _, ok := bitalinoRates[rate]; !ok {
+        return fmt.Errorf("the BITalino doesn't support %d samples per second", rate)
+    }
+
+    // Stop a stream that is still running, and make sure the board answers
+    port, err := dialBITalino(target)
+    if err != nil {
+        return err
+    }
+    defer port.Close()
+    reader := bufio.NewReader(port)
+    port.Write([]byte{bitalinoIdle})
+    time.Sleep(100 * time.Millisecond)
+    reader.Discard(reader.Buffered())
+    port.Write([]byte{bitalinoVersion})
+    port.SetReadDeadline(time.Now().Add(bitalinoTimeout))
+    version, err := reader.ReadString('\n')
+    if os.IsTimeout(err) {
+        return fmt.Errorf("the BITalino doesn't answer, is the board switched on?")
+    }
+    if err != nil {
+        return err
+    }
+    if !strings.Contains(version, "BITalino") {
+        return fmt.Errorf("the device isn't a BITalino, it answered %q", strings.TrimSpace(version))
+    }
+    port.SetReadDeadline(time.Time{})
+
+    // The inputs that are recorded are sent in ascending order, whichever they are
+    mask := byte(0)
+    columns := []int{}
+    for i, recorded := range inputs {
+        if recorded {
+            mask |= 1 << uint(i)
+            columns = append(columns, i)
+        }
+    }
+
+    // The clock of the board defines the timing
+    Settings.Interval = 1 / float64(rate)
+    startRecording(pipeline, "hardware")
+    if Settings.Watchdog > 0 {
+        go RunWatchdog(pipeline, watchdogTimeout())
+    }
+    _, err = port.Write([]byte{bitalinoRates[rate] << 6 | 0x03, mask << 2 | 0x01})
+    if err != nil {
+        return err
+    }
+    defer port.Write([]byte{bitalinoIdle})
+
+    size := bitalinoFrameSize(len(columns))
+    x := -1
+    last := 0
+    for true {
+        head, err := reader.Peek(size)
+        if err != nil {
+            return err
+        }
+
+        // A frame that doesn't match its checksum means a byte got lost, so the stream is searched for the next one
+        if !bitalinoChecksum(head) {
+            reader.Discard(1)
+            continue
+        }
+        readings := decodeBITalino(head, len(columns))
+        sequence := int(head[size - 1] >> 4)
+        reader.Discard(size)
+
+        // The sequence number wraps around after 15
+        step := 1
+        if x >= 0 {
+            step = (sequence - last + 16) % 16
+        }
+        last = sequence
+        x += max(step, 1)
+        sample := Sample{Index: x, Time: float64(x) * Settings.Interval}
+        for i, input := range columns {
+            value := float64(readings[i])
+            if emg[input] {
+                levels := float64(1 << 10)
+                if i >= 4 {
+                    levels = 1 << 6
+                }
+                value = (value / levels - 0.5) * bitalinoVCC / bitalinoGain
+            }
+            if input == channel - 1 {
+                sample.Value = value
+            } else {
+                sample.Aux = append(sample.Aux, value)
+            }
+        }
+        pipeline.Push(sample)
+    }
+    return nil
+}
+
+/*
+ Parses which of the analog inputs are recorded, and which of them are EMG, including the given channel
+ */
+func bitalinoLayout(query url.Values) (int, []bool, []bool, error) {
+    channel, err := queryInt(query, "channel", 1)
+    if err != nil {
+        return 0, nil, nil, err
+    }
+    inputs, err := bitalinoInputs(query.Get("channels"))
+    if err != nil {
+        return 0, nil, nil, err
+    }
+    emg, err := bitalinoInputs(query.Get("emg"))
+    if err != nil {
+        return 0, nil, nil, err
+    }
+    if channel < 1 || channel > 6 {
+        return 0, nil, nil, fmt.Errorf("the BITalino has no channel A%d", channel)
+    }
+    inputs[channel - 1] = true
+    emg[channel - 1] = true
+    return channel, inputs, emg, nil
+}
+
+/*
+ Describes the inputs that are recorded besides the channel, in ascending order like the board sends them
+ */
+func describeBITalino(target *url.URL) (AuxChannels, error) {
+    channel, inputs, emg, err := bitalinoLayout(target.Query())
+    if err != nil {
+        return nil, err
+    }
+    aux := AuxChannels{}
+    for i, recorded := range inputs {
+        if !recorded || i == channel - 1 {
+            continue
+        }
+        unit := "raw"
+        if emg[i] {
+            unit = "V"
+        }
+        aux = append(aux, AuxChannel{Name: "A" + strconv.Itoa(i + 1), Unit: unit, Sensor: "bitalino"})
+    }
+    return aux, nil
+}
+
+/*
+ Parses a list of analog inputs like 1,2,5 into which of A1 to A6 are selected
+ */
+func bitalinoInputs(list string) ([]bool, error) {
+    inputs := make([]bool, 6)
+    if list == "" {
+        return inputs, nil
+    }
+    for _, field := range strings.Split(list, ",") {
+        input, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(field), "A"))
+        if err != nil || input < 1 || input > 6 {
+            return nil, fmt.Errorf("the BITalino has no channel %s", field)
+        }
+        inputs[input - 1] = true
+    }
+    return inputs, nil
+}
+
+/*
+ Connects to a BITalino through an RFCOMM socket if the URL has an address, otherwise through its serial port
+ */
+func dialBITalino(target *url.URL) (*os.File, error) {
+    if target.Host == "" {
+        device := serialDevice(target)
+        if device == "" {
+            return nil, fmt.Errorf("no serial port found for the BITalino")
+        }
+        err := stty("-F", device, "raw", "-echo")
+        if err != nil {
+            return nil, fmt.Errorf("failed to configure %s: %v", device, err)
+        }
+        return os.OpenFile(device, os.O_RDWR, 0)
+    }
+    bdaddr, err := parseBluetoothAddress(target.Host)
+    if err != nil {
+        return nil, err
+    }
+    addr := sockaddrRC{family: afBluetooth, bdaddr: bdaddr, channel: bitalinoChannel}
+    fd, err := syscall.Socket(afBluetooth, syscall.SOCK_STREAM, btprotoRFCOMM)
+    if err != nil {
+        return nil, err
+    }
+    _, _, errno := syscall.Syscall(syscall.SYS_CONNECT, uintptr(fd), uintptr(unsafe.Pointer(&addr)),
+        unsafe.Sizeof(addr))
+    if errno != 0 {
+        syscall.Close(fd)
+        return nil, errno
+    }
+
+    // A non-blocking socket lets the file time out while waiting for an answer
+    syscall.SetNonblock(fd, true)
+    return os.NewFile(uintptr(fd), target.Host), nil
+}
+
+/*
+ Returns how many bytes a frame with the given number of analog inputs has. A1 to A4 have 10 bits, A5 and A6 have 6,
+ and every frame has the digital inputs and outputs, a sequence number and a checksum.
+ */
+func bitalinoFrameSize(inputs int) int {
+    if inputs <= 4 {
+        return (12 + 10 * inputs + 7) / 8
+    }
+    return (52 + 6 * (inputs - 4) + 7) / 8
+}
+
+/*
+ Checks the CRC-4 of a frame, which is in the lowest bits of its last byte
+ */
+func bitalinoChecksum(frame []byte) bool {
+    crc := byte(0)
+    for i, b := range frame {
+        if i == len(frame) - 1 {
+            b &= 0xF0
+        }
+        for bit := 7; bit >= 0; bit-- {
+            crc <<= 1
+            if crc & 0x10 != 0 {
+                crc ^= 0x03
+            }
+            crc ^= b >> uint(bit) & 0x01
+        }
+    }
+    return crc & 0x0F == frame[len(frame) - 1] & 0x0F
+}
+
+/*
+ Decodes the readings of the analog inputs of a frame, which are packed from its end
+ */
+func decodeBITalino(frame []byte, inputs int) []int {
+    at := func(i int) int {
+        return int(frame[len(frame) - i])
+    }
+    readings := []int{(at(2) & 0x0F) << 6 | at(3) >> 2}
+    if inputs > 1 {
+        readings = append(readings, (at(3) & 0x03) << 8 | at(4))
+    }
+    if inputs > 2 {
+        readings = append(readings, at(5) << 2 | at(6) >> 6)
+    }
+    if inputs > 3 {
+        readings = append(readings, (at(6) & 0x3F) << 4 | at(7) >> 4)
+    }
+    if inputs > 4 {
+        readings = append(readings, (at(7) & 0x0F) << 2 | at(8) >> 6)
+    }
+    if inputs > 5 {
+        readings = append(readings, at(8) & 0x3F)
+    }
+    return readings
+}
+
+/*
+ Checks whether the BITalino can be reached: over Bluetooth if the URL has an address, otherwise over its serial port
+ */
+func probeBITalino(target *url.URL) error {
+    if target == nil {
+        return probeBluetooth(nil)
+    }
+    if target.Host == "" {
+        return probeSerial(target)
+    }
+    port, err := dialBITalino(target)
+    if err != nil {
+        return err
+    }
+    return port.Close()
+}
@@ -0,0 +1,393 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "math"
+    "time"
+    "errors"
+    "unsafe"
+    "strings"
+    "strconv"
+    "syscall"
+    "net/url"
+    "path/filepath"
+    "encoding/hex"
+    "encoding/binary"
+)
+
+func init() {
+    Sources["ble"] = grabDataFromBLE
+    SourceBackends["ble"] = Backend{
+        Usage: "ble://<address>/<characteristic>?format=int16le&scale=1&random=1",
+        Description: "A Bluetooth LE sensor, like a wireless EMG patch or a heart rate strap",
+        Probe: probeBluetooth,
+    }
+}
+
+// Constants of the Linux Bluetooth stack and the Attribute Protocol (see bluetooth.h, l2cap.h and the Core Spec)
+const (
+    afBluetooth = 31
+    btprotoL2CAP = 0
+    attCID = 4
+    bdaddrLEPublic = 1
+    bdaddrLERandom = 2
+
+    attErrorResponse = 0x01
+    attExchangeMTURequest = 0x02
+    attFindInformationRequest = 0x04
+    attReadByTypeRequest = 0x08
+    attWriteRequest = 0x12
+    attNotification = 0x1B
+    attIndication = 0x1D
+    attConfirmation = 0x1E
+
+    gattCharacteristic = 0x2803
+    gattClientConfiguration = 0x2902
+)
+
+/*
+ The address of an L2CAP socket (struct sockaddr_l2)
+ */
+type sockaddrL2 struct {
+    family uint16
+    psm uint16
+    bdaddr [6]byte
+    cid uint16
+    bdaddrType uint8
+    _ uint8
+}
+
+/*
+ This function subscribes to a GATT characteristic of a Bluetooth LE sensor, like a wireless EMG patch or a heart rate
+ strap, and writes the notified values into the pipeline. The connection uses the Attribute Protocol directly over an
+ L2CAP socket of the kernel, so BlueZ doesn't have to be running. The source is given as
+    ble://<address>/<characteristic>?format=<format>&scale=<scale>&random=1
+ The characteristic is a 16 bit UUID (2a37) or a full 128 bit UUID. Every notification can contain several samples,
+ which are decoded using the format: int8, uint8, int16le, uint16le, int32le or float32le, multiplied with the scale
+ to get volts. The format hrm decodes the Heart Rate Measurement of heart rate straps, whose value is the heart rate
+ in beats per minute. Devices with a random address need random=1.
+ */
+func grabDataFromBLE(pipeline *Pipeline, target *url.URL) error {
+    query := target.Query()
+    format := query.Get("format")
+    if format == "" {
+        format = "int16le"
+    }
+    scale := float64(1)
+    if query.Get("scale") != "" {
+        s, err := strconv.ParseFloat(query.Get("scale"), 64)
+        if err != nil {
+            return err
+        }
+        scale = s
+    }
+    uuid, err := parseUUID(strings.Trim(target.Path, "/"))
+    if err != nil {
+        return err
+    }
+
+    // Connect to the device and subscribe to the characteristic
+    att, err := dialATT(target.Host, query.Get("random") == "1")
+    if err != nil {
+        return err
+    }
+    defer syscall.Close(att)
+    handle, err := subscribeATT(att, uuid)
+    if err != nil {
+        return err
+    }
+
+    startRecording(pipeline, "hardware")
+    if Settings.Watchdog > 0 {
+        go RunWatchdog(pipeline, watchdogTimeout())
+    }
+
+    // Decode the notifications
+    x := 0
+    start := time.Now()
+    buffer := make([]byte, 512)
+    for {
+        n, err := syscall.Read(att, buffer)
+        if err != nil {
+            return err
+        }
+        pdu := buffer[:n]
+        if len(pdu) < 3 || (pdu[0] != attNotification && pdu[0] != attIndication) {
+            continue
+        }
+        if pdu[0] == attIndication {
+            syscall.Write(att, []byte{attConfirmation})
+        }
+        if binary.LittleEndian.Uint16(pdu[1:]) != handle {
+            continue
+        }
+        values, err := decodeBLE(format, pdu[3:])
+        if err != nil {
+            return err
+        }
+        for _, value := range values {
+            t := float64(x) * Settings.Interval
+            if format == "hrm" {
+                // Heart rate straps notify roughly once per second, so the time comes from the clock
+                t = time.Since(start).Seconds()
+                pipeline.Push(Sample{Index: x, Time: t, Value: value})
+            } else {
+                pipeline.Push(Sample{Index: x, Time: t, Value: value * scale})
+            }
+            x++
+        }
+    }
+}
+
+/*
+ Parses a 16 bit or 128 bit UUID into the little endian byte order of the Attribute Protocol
+ */
+func parseUUID(text string) ([]byte, error) {
+    raw, err := hex.DecodeString(strings.Replace(text, "-", "", -1))
+    if err != nil || (len(raw) != 2 && len(raw) != 16) {
+        return nil, fmt.Errorf("invalid UUID %q", text)
+    }
+    for i, j := 0, len(raw) - 1; i < j; i, j = i + 1, j - 1 {
+        raw[i], raw[j] = raw[j], raw[i]
+    }
+    return raw, nil
+}
+
+/*
+ Parses a Bluetooth address like 00:11:22:33:44:55 into the byte order of the kernel, which is reversed. In URLs, an
+ address that ends with a letter looks like an invalid port, so it can be written as 00-11-22-33-44-5A too.
+ */
+func parseBluetoothAddress(address string) ([6]byte, error) {
+    bdaddr := [6]byte{}
+    parts := strings.Split(strings.Replace(address, "-", ":", -1), ":")
+    if len(parts) != 6 {
+        return bdaddr, fmt.Errorf("invalid Bluetooth address %q", address)
+    }
+    for i, part := range parts {
+        b, err := strconv.ParseUint(part, 16, 8)
+        if err != nil {
+            return bdaddr, fmt.Errorf("invalid Bluetooth address %q", address)
+        }
+        bdaddr[5 - i] = byte(b)
+    }
+    return bdaddr, nil
+}
+
+/*
+ Opens an L2CAP socket for the Attribute Protocol to the device with the given address
+ */
+func dialATT(address string, random bool) (int, error) {
+    bdaddr, err := parseBluetoothAddress(address)
+    if err != nil {
+        return -1, err
+    }
+    addr := sockaddrL2{family: afBluetooth, cid: attCID, bdaddr: bdaddr, bdaddrType: bdaddrLEPublic}
+    if random {
+        addr.bdaddrType = bdaddrLERandom
+    }
+
+    fd, err := syscall.Socket(afBluetooth, syscall.SOCK_SEQPACKET, btprotoL2CAP)
+    if err != nil {
+        return -1, err
+    }
+    local := sockaddrL2{family: afBluetooth, cid: attCID, bdaddrType: bdaddrLEPublic}
+    _, _, errno := syscall.Syscall(syscall.SYS_BIND, uintptr(fd), uintptr(unsafe.Pointer(&local)),
+        unsafe.Sizeof(local))
+    if errno == 0 {
+        _, _, errno = syscall.Syscall(syscall.SYS_CONNECT, uintptr(fd), uintptr(unsafe.Pointer(&addr)),
+            unsafe.Sizeof(addr))
+    }
+    if errno != 0 {
+        syscall.Close(fd)
+        return -1, errno
+    }
+    return fd, nil
+}
+
+/*
+ Sends a request and waits for its response, ignoring notifications that arrive in between
+ */
+func requestATT(fd int, request []byte) ([]byte, error) {
+    _, err := syscall.Write(fd, request)
+    if err != nil {
+        return nil, err
+    }
+    buffer := make([]byte, 512)
+    for {
+        n, err := syscall.Read(fd, buffer)
+        if err != nil {
+            return nil, err
+        }
+        if n == 0 || buffer[0] == attNotification || buffer[0] == attIndication {
+            continue
+        }
+        if buffer[0] == attErrorResponse && n >= 5 {
+            return nil, attError(buffer[4])
+        }
+        return buffer[:n], nil
+    }
+}
+
+/*
+ An error code of the Attribute Protocol
+ */
+type attError byte
+
+func (e attError) Error() string {
+    return fmt.Sprintf("the Bluetooth device answered with ATT error 0x%02x", byte(e))
+}
+
+/*
+ Finds the characteristic with the given UUID, and returns the handle of its value and the last handle that belongs to
+ it
+ */
+func findATT(fd int, uuid []byte) (uint16, uint16, error) {
+    // Walk through the characteristic declarations
+    value := uint16(0)
+    end := uint16(0xFFFF)
+    start := uint16(1)
+    for start != 0 && value == 0 {
+        request := []byte{attReadByTypeRequest, 0, 0, 0xFF, 0xFF, 0, 0}
+        binary.LittleEndian.PutUint16(request[1:], start)
+        binary.LittleEndian.PutUint16(request[5:], gattCharacteristic)
+        response, err := requestATT(fd, request)
+        if err == attError(0x0A) {
+            break
+        }
+        if err != nil {
+            return 0, 0, err
+        }
+        length := int(response[1])
+        if length < 7 {
+            return 0, 0, errors.New("invalid response while discovering characteristics")
+        }
+        for record := response[2:]; len(record) >= length; record = record[length:] {
+            declaration := binary.LittleEndian.Uint16(record)
+            start = declaration + 1
+            if value != 0 && end == 0xFFFF {
+                end = declaration - 1
+            }
+            if value == 0 && string(record[5:length]) == string(uuid) {
+                value = binary.LittleEndian.Uint16(record[3:])
+            }
+        }
+    }
+    if value == 0 {
+        return 0, 0, errors.New("the Bluetooth device has no such characteristic")
+    }
+    return value, end, nil
+}
+
+/*
+ Finds the characteristic with the given UUID, enables its notifications, and returns the handle of its value
+ */
+func subscribeATT(fd int, uuid []byte) (uint16, error) {
+    // Ask for a larger MTU, so notifications can carry more samples. Devices that don't support it use 23 bytes.
+    requestATT(fd, []byte{attExchangeMTURequest, 0xF7, 0x00})
+    value, end, err := findATT(fd, uuid)
+    if err != nil {
+        return 0, err
+    }
+
+    // Find the client configuration descriptor, which usually follows the value directly
+    configuration := value + 1
+    request := []byte{attFindInformationRequest, 0, 0, 0, 0}
+    binary.LittleEndian.PutUint16(request[1:], value + 1)
+    binary.LittleEndian.PutUint16(request[3:], end)
+    response, err := requestATT(fd, request)
+    if err == nil && len(response) > 2 && response[1] == 1 {
+        for pair := response[2:]; len(pair) >= 4; pair = pair[4:] {
+            if binary.LittleEndian.Uint16(pair[2:]) == gattClientConfiguration {
+                configuration = binary.LittleEndian.Uint16(pair)
+                break
+            }
+        }
+    }
+
+    // Enable notifications, or indications if the device only supports those
+    request = []byte{attWriteRequest, 0, 0, 0x01, 0x00}
+    binary.LittleEndian.PutUint16(request[1:], configuration)
+    _, err = requestATT(fd, request)
+    if err != nil {
+        request[3] = 0x02
+        _, err = requestATT(fd, request)
+    }
+    return value, err
+}
+
+/*
+ Decodes the payload of a notification into samples
+ */
+func decodeBLE(format string, payload []byte) ([]float64, error) {
+    values := []float64{}
+    switch format {
+    case "hrm":
+        // The flags say whether the heart rate has 8 or 16 bits
+        if len(payload) < 2 {
+            return nil, nil
+        }
+        if payload[0] & 0x01 == 0 {
+            return []float64{float64(payload[1])}, nil
+        }
+        if len(payload) < 3 {
+            return nil, nil
+        }
+        return []float64{float64(binary.LittleEndian.Uint16(payload[1:]))}, nil
+    case "int8":
+        for _, b := range payload {
+            values = append(values, float64(int8(b)))
+        }
+    case "uint8":
+        for _, b := range payload {
+            values = append(values, float64(b))
+        }
+    case "int16le":
+        for ; len(payload) >= 2; payload = payload[2:] {
+            values = append(values, float64(int16(binary.LittleEndian.Uint16(payload))))
+        }
+    case "uint16le":
+        for ; len(payload) >= 2; payload = payload[2:] {
+            values = append(values, float64(binary.LittleEndian.Uint16(payload)))
+        }
+    case "int32le":
+        for ; len(payload) >= 4; payload = payload[4:] {
+            values = append(values, float64(int32(binary.LittleEndian.Uint32(payload))))
+        }
+    case "float32le":
+        for ; len(payload) >= 4; payload = payload[4:] {
+            values = append(values, float64(math.Float32frombits(binary.LittleEndian.Uint32(payload))))
+        }
+    default:
+        return nil, fmt.Errorf("unknown BLE sample format %s", format)
+    }
+    return values, nil
+}
+
+/*
+ Checks whether the kernel supports Bluetooth and an adapter is present. With a URL, this connects to the sensor.
+ */
+func probeBluetooth(target *url.URL) error {
+    if target != nil {
+        att, err := dialATT(target.Host, target.Query().Get("random") == "1")
+        if err != nil {
+            return err
+        }
+        return syscall.Close(att)
+    }
+    fd, err := syscall.Socket(afBluetooth, syscall.SOCK_SEQPACKET, btprotoL2CAP)
+    if err != nil {
+        return fmt.Errorf("the kernel doesn't support Bluetooth")
+    }
+    syscall.Close(fd)
+    adapters, _ := filepath.Glob("/sys/class/bluetooth/hci*")
+    if len(adapters) == 0 {
+        return fmt.Errorf("no Bluetooth adapter found")
+    }
+    return nil
+}
@@ -0,0 +1,30 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+/*
+ The application can be built without the parts that a device doesn't need, like a Pi Zero that only records:
+    $ go build -tags "nogui nonet"
+    nogui   Leaves out the terminal display and goterm. The acquisition runs headless until it ends or is interrupted.
+    nonet   Leaves out the server, the viewer, the discovery, and the sources and sinks that use the network
+    noedf   Leaves out the import of EDF files (see ImportFormats)
+ Every tag that leaves something out adds itself in here from an init function, so "plot sources" and "plot sinks"
+ can tell what the build is missing.
+ */
+var Omitted = []string{}
+
+/*
+ Whether the build was made with the given tag
+ */
+func Omits(tag string) bool {
+    for _, omitted := range Omitted {
+        if omitted == tag {
+            return true
+        }
+    }
+    return false
+}
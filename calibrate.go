@@ -0,0 +1,350 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "fmt"
+    "math"
+    "sort"
+    "time"
+    "strings"
+    "net/url"
+    "encoding/json"
+)
+
+func init() {
+    Models["lda"] = openTrainedModel
+    Models["knn"] = openTrainedModel
+}
+
+/*
+ How many seconds at the start of every gesture are left out of the training, while the subject changes over to it
+ */
+const calibrationSettle = 1.0
+
+/*
+ How many neighbours the k-NN model asks by default
+ */
+const calibrationNeighbours = 5
+
+/*
+ How much the covariance of the LDA model is pulled towards the identity, so that features that hardly vary, or
+ vary together, don't make it singular
+ */
+const calibrationShrinkage = 0.05
+
+/*
+ Calibrates a classifier for the subject: the gestures are prompted one after the other like the phases of a
+ protocol, for several rounds, and the windows of every gesture are collected, without the first second of it. When
+ the session ends, a model is trained on the windows and saved for --classify. The features, the window and the step
+ are those of --classify-features, --classify-window and --classify-step.
+ */
+type Calibration struct {
+    Protocol *Protocol
+    kind string
+    features []Feature
+    window float64
+    step float64
+    interval float64
+    windows *SlidingWindow
+    gesture string
+    since float64
+    samples [][]float64
+    labels []string
+}
+
+/*
+ Creates the calibration for the gestures, separated by commas, which are held for the given seconds in every round
+ */
+func NewCalibration(gestures string, rounds int, hold float64, kind string, features []Feature, window float64,
+    step float64) (*Calibration, error) {
+    if kind != "lda" && kind != "knn" {
+        return nil, fmt.Errorf("unknown model %s, expected lda or knn", kind)
+    }
+    if hold <= calibrationSettle + window {
+        return nil, fmt.Errorf("every gesture has to be held for more than %.1fs", calibrationSettle + window)
+    }
+    names := strings.Split(gestures, ",")
+    if len(names) < 2 {
+        return nil, fmt.Errorf("at least two gestures are needed, like rest,fist")
+    }
+    protocol := &Protocol{}
+    for i := 0; i < rounds; i++ {
+        for _, name := range names {
+            protocol.Phases = append(protocol.Phases, Phase{Name: strings.TrimSpace(name), Duration: hold})
+        }
+    }
+    return &Calibration{Protocol: protocol, kind: kind, features: features, window: window, step: step}, nil
+}
+
+/*
+ Sets the interval between two samples, once the source knows it
+ */
+func (c *Calibration) Start(interval float64) {
+    c.interval = interval
+    c.windows = NewSlidingWindow(c.window, c.step, interval)
+}
+
+/*
+ Adds the next sample, and keeps the features of its window if it is due and the subject holds a gesture
+ */
+func (c *Calibration) Process(sample Sample) {
+    gesture := c.Protocol.Current()
+    if gesture != c.gesture {
+        c.gesture, c.since = gesture, sample.Time
+        c.windows = NewSlidingWindow(c.window, c.step, c.interval)
+    }
+    if !c.windows.Add(sample.Processed()) || gesture == "" || gesture == "done" ||
+        sample.Time - c.since < calibrationSettle + c.window {
+        return
+    }
+    c.samples = append(c.samples, ComputeFeatures(c.features, c.windows.Values, c.interval))
+    c.labels = append(c.labels, gesture)
+}
+
+/*
+ Trains the model on the windows that were collected, and saves it into a file
+ */
+func (c *Calibration) Save(file string) (*TrainedModel, error) {
+    model, err := TrainModel(c.kind, c.features, c.samples, c.labels)
+    if err != nil {
+        return nil, err
+    }
+    model.Window, model.Step = c.window, c.step
+    data, err := json.MarshalIndent(model, "", "    ")
+    if err != nil {
+        return nil, err
+    }
+    return model, os.WriteFile(file, append(data, '\n'), 0644)
+}
+
+/*
+ A model that was trained by a calibration, as it is saved. The features are standardized with the mean and the
+ scale of the training first.
+    lda  Linear discriminant analysis: every class gets a linear score of the features, Weights[class] · x + Bias,
+         from the means of the classes and the covariance that they share. The confidence is the softmax of the
+         scores.
+    knn  The k nearest neighbours: the class that most of the K closest windows of the training have. The confidence
+         is the share of the neighbours that have it.
+ The model is used with --classify lda:model.json or knn:model.json, and the classifier uses the window and the step
+ of the training.
+ */
+type TrainedModel struct {
+    Kind string
+    Trained time.Time
+    Features []string
+    Window float64
+    Step float64
+    Labels []string
+    Mean []float64
+    Scale []float64
+    Weights [][]float64 `json:",omitempty"`
+    Bias []float64 `json:",omitempty"`
+    K int `json:",omitempty"`
+    Points [][]float64 `json:",omitempty"`
+    Classes []int `json:",omitempty"`
+}
+
+func openTrainedModel(target *url.URL, features []Feature) (Model, error) {
+    path := target.Opaque
+    if path == "" {
+        path = target.Path
+    }
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    model := &TrainedModel{}
+    err = json.Unmarshal(data, model)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", path, err)
+    }
+    if model.Kind != target.Scheme {
+        return nil, fmt.Errorf("%s is a model of the kind %s, not %s", path, model.Kind, target.Scheme)
+    }
+    names := []string{}
+    for _, feature := range features {
+        names = append(names, feature.Name)
+    }
+    if strings.Join(names, ",") != strings.Join(model.Features, ",") {
+        return nil, fmt.Errorf("%s was trained with --classify-features %s", path, strings.Join(model.Features, ","))
+    }
+    return model, nil
+}
+
+/*
+ Trains a model of the given kind on the features of windows and their labels
+ */
+func TrainModel(kind string, features []Feature, samples [][]float64, labels []string) (*TrainedModel, error) {
+    model := &TrainedModel{Kind: kind, Trained: time.Now().UTC().Truncate(time.Second)}
+    for _, feature := range features {
+        model.Features = append(model.Features, feature.Name)
+    }
+    classes := []int{}
+    counts := map[int]int{}
+    for _, label := range labels {
+        class := -1
+        for i, known := range model.Labels {
+            if known == label {
+                class = i
+            }
+        }
+        if class < 0 {
+            class = len(model.Labels)
+            model.Labels = append(model.Labels, label)
+        }
+        classes = append(classes, class)
+        counts[class]++
+    }
+    if len(model.Labels) < 2 {
+        return nil, fmt.Errorf("at least two gestures need windows, got %d", len(model.Labels))
+    }
+    for class, label := range model.Labels {
+        if counts[class] < 2 {
+            return nil, fmt.Errorf("the gesture %s has too few windows", label)
+        }
+    }
+
+    // Standardize the features, so that counts and voltages weigh the same
+    width := len(features)
+    model.Mean = make([]float64, width)
+    model.Scale = make([]float64, width)
+    columns := transpose(samples)
+    for i, column := range columns {
+        stats := Calculate(column)
+        model.Mean[i] = stats.Mean
+        model.Scale[i] = math.Sqrt(math.Max(0, stats.RMS * stats.RMS - stats.Mean * stats.Mean))
+        if model.Scale[i] == 0 {
+            model.Scale[i] = 1
+        }
+    }
+    standardized := make([][]float64, len(samples))
+    for i, sample := range samples {
+        standardized[i] = model.standardize(sample)
+    }
+
+    if kind == "knn" {
+        model.K = min(calibrationNeighbours, len(samples))
+        model.Points = standardized
+        model.Classes = classes
+        return model, nil
+    }
+
+    // The means of the classes, and the covariance around them that all classes share
+    means := make([][]float64, len(model.Labels))
+    for class := range means {
+        means[class] = make([]float64, width)
+    }
+    for i, sample := range standardized {
+        for j, v := range sample {
+            means[classes[i]][j] += v / float64(counts[classes[i]])
+        }
+    }
+    shared := make([][]float64, width)
+    for j := range shared {
+        shared[j] = make([]float64, width)
+    }
+    for i, sample := range standardized {
+        for j := range sample {
+            for k := range sample {
+                shared[j][k] += (sample[j] - means[classes[i]][j]) * (sample[k] - means[classes[i]][k]) /
+                    float64(len(samples) - len(model.Labels))
+            }
+        }
+    }
+    for j := range shared {
+        for k := range shared[j] {
+            shared[j][k] *= 1 - calibrationShrinkage
+        }
+        shared[j][j] += calibrationShrinkage
+    }
+
+    // Invert the covariance through its eigenvectors: Σ^-1 = E D^-1 E^T
+    values, vectors := symmetricEigen(shared)
+    inverse := make([][]float64, width)
+    for j := range inverse {
+        inverse[j] = make([]float64, width)
+        for k := range inverse[j] {
+            for l, value := range values {
+                inverse[j][k] += vectors[j][l] * vectors[k][l] / math.Max(value, 1e-12)
+            }
+        }
+    }
+    for class, mean := range means {
+        weights := make([]float64, width)
+        bias := math.Log(float64(counts[class]) / float64(len(samples)))
+        for j := range weights {
+            for k := range mean {
+                weights[j] += inverse[j][k] * mean[k]
+            }
+            bias -= weights[j] * mean[j] / 2
+        }
+        model.Weights = append(model.Weights, weights)
+        model.Bias = append(model.Bias, bias)
+    }
+    return model, nil
+}
+
+func (m *TrainedModel) Classify(features []float64) (string, float64) {
+    x := m.standardize(features)
+    if m.Kind == "knn" {
+        return m.nearest(x)
+    }
+    scores := make([]float64, len(m.Labels))
+    for class := range scores {
+        scores[class] = m.Bias[class]
+        for j, v := range x {
+            scores[class] += m.Weights[class][j] * v
+        }
+    }
+    probabilities := softmax(scores)
+    best := 0
+    for class, p := range probabilities {
+        if p > probabilities[best] {
+            best = class
+        }
+    }
+    return m.Labels[best], probabilities[best]
+}
+
+/*
+ Returns the class that most of the nearest windows of the training have, and their share
+ */
+func (m *TrainedModel) nearest(x []float64) (string, float64) {
+    type neighbour struct {
+        distance float64
+        class int
+    }
+    neighbours := make([]neighbour, len(m.Points))
+    for i, point := range m.Points {
+        for j, v := range point {
+            neighbours[i].distance += (v - x[j]) * (v - x[j])
+        }
+        neighbours[i].class = m.Classes[i]
+    }
+    sort.Slice(neighbours, func(i int, j int) bool {
+        return neighbours[i].distance < neighbours[j].distance
+    })
+    votes := make([]int, len(m.Labels))
+    best := neighbours[0].class
+    for _, n := range neighbours[:m.K] {
+        votes[n.class]++
+        if votes[n.class] > votes[best] {
+            best = n.class
+        }
+    }
+    return m.Labels[best], float64(votes[best]) / float64(m.K)
+}
+
+func (m *TrainedModel) standardize(features []float64) []float64 {
+    x := make([]float64, len(features))
+    for i, v := range features {
+        x[i] = (v - m.Mean[i]) / m.Scale[i]
+    }
+    return x
+}
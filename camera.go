@@ -0,0 +1,212 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "fmt"
+    "time"
+    "bytes"
+    "os/exec"
+    "strings"
+    "strconv"
+    "sync"
+    "syscall"
+    "path/filepath"
+)
+
+/*
+ How often the video is checked for its first frame
+ */
+const cameraPoll = 50 * time.Millisecond
+
+/*
+ Returns the path of the directory with the frames of the camera that belong to a recording
+ */
+func FramesDirectory(file string) string {
+    return file + ".frames"
+}
+
+/*
+ Captures what the subject is doing with the camera of the Pi, next to the recording, so every burst can be matched
+ with a picture of it. The camera apps of the Pi (rpicam-still and rpicam-vid, or the older libcamera-still and
+ libcamera-vid) do the capturing, into the directory data.csv.frames:
+    stills  A frame every few seconds, as 000001.jpg and so on. Every frame is noted in the events ("frame
+            000001.jpg") at the sample at which it was taken.
+    video   A low-rate video, as video.h264, with the time of every frame in milliseconds since the first one in
+            video.pts. The first frame is noted in the events ("video started") at the sample at which it arrived.
+ The metadata of the recording tells which of them were captured. The camera apps write the frames as they are, so
+ encrypted recordings can't have a camera.
+ */
+type Camera struct {
+    mode string
+    directory string
+    interval float64
+    fps float64
+    width int
+    height int
+    program string
+    stop chan bool
+    stopping sync.Once
+    done chan bool
+}
+
+/*
+ Prepares the camera for a recording. The size is given like 1280x720.
+ */
+func NewCamera(mode string, file string, interval float64, fps float64, size string) (*Camera, error) {
+    camera := &Camera{mode: mode, directory: FramesDirectory(file), interval: interval, fps: fps,
+        stop: make(chan bool), done: make(chan bool)}
+    width, height, ok := strings.Cut(size, "x")
+    w, err := strconv.Atoi(width)
+    if err == nil {
+        camera.height, err = strconv.Atoi(height)
+    }
+    if !ok || err != nil || w <= 0 || camera.height <= 0 {
+        return nil, fmt.Errorf("invalid size %q, expected something like 1280x720", size)
+    }
+    camera.width = w
+    suffix := map[string]string{"stills": "still", "video": "vid"}[mode]
+    if suffix == "" {
+        return nil, fmt.Errorf("unknown mode %s, expected stills or video", mode)
+    }
+    for _, prefix := range []string{"rpicam-", "libcamera-"} {
+        if probeProgram(prefix + suffix) == nil {
+            camera.program = prefix + suffix
+            break
+        }
+    }
+    if camera.program == "" {
+        return nil, fmt.Errorf("rpicam-%s is not installed", suffix)
+    }
+    return camera, os.MkdirAll(camera.directory, 0755)
+}
+
+/*
+ Describes what the camera captures, for the metadata
+ */
+func (c *Camera) String() string {
+    if c.mode == "stills" {
+        return fmt.Sprintf("stills every %gs, %dx%d", c.interval, c.width, c.height)
+    }
+    return fmt.Sprintf("video at %g fps, %dx%d", c.fps, c.width, c.height)
+}
+
+/*
+ Captures until the camera is stopped. Failures of the camera are noted in the events, and end the capturing.
+ */
+func (c *Camera) Run(pipeline *Pipeline) {
+    defer close(c.done)
+    var err error
+    if c.mode == "stills" {
+        err = c.stills(pipeline)
+    } else {
+        err = c.video(pipeline)
+    }
+    if err != nil {
+        _, t := pipeline.LastSample()
+        pipeline.Event(t, fmt.Sprintf("camera failed: %v", err))
+    }
+}
+
+/*
+ Stops capturing, and waits until the last frame was saved. The pipeline does this when it is closed.
+ */
+func (c *Camera) Stop() {
+    c.stopping.Do(func() {
+        close(c.stop)
+    })
+    <-c.done
+}
+
+/*
+ Takes a frame at every interval. The event is noted when the capture starts, since the camera apps take the frame
+ right away with --immediate and only need the rest of the time to save it.
+ */
+func (c *Camera) stills(pipeline *Pipeline) error {
+    size := []string{"--width", strconv.Itoa(c.width), "--height", strconv.Itoa(c.height)}
+    next := time.Now()
+    for n := 1; true; n++ {
+        name := fmt.Sprintf("%06d.jpg", n)
+        _, t := pipeline.LastSample()
+        pipeline.Event(t, "frame " + name)
+        arguments := append([]string{"-n", "--immediate", "-o", filepath.Join(c.directory, name)}, size...)
+        output, err := exec.Command(c.program, arguments...).CombinedOutput()
+        if err != nil {
+            return cameraError(err, output)
+        }
+        next = next.Add(time.Duration(c.interval * float64(time.Second)))
+        select {
+        case <-c.stop:
+            return nil
+        case <-time.After(time.Until(next)):
+        }
+    }
+    return nil
+}
+
+/*
+ Records the video until the session has ended, and notes when its first frame arrived
+ */
+func (c *Camera) video(pipeline *Pipeline) error {
+    pts := filepath.Join(c.directory, "video.pts")
+    command := exec.Command(c.program, "-n", "-t", "0", "--framerate", strconv.FormatFloat(c.fps, 'g', -1, 64),
+        "--width", strconv.Itoa(c.width), "--height", strconv.Itoa(c.height), "--codec", "h264",
+        "-o", filepath.Join(c.directory, "video.h264"), "--save-pts", pts)
+    var output bytes.Buffer
+    command.Stderr = &output
+    err := command.Start()
+    if err != nil {
+        return err
+    }
+    exited := make(chan error, 1)
+    go func() {
+        exited <- command.Wait()
+    }()
+
+    // The file with the times starts with a header, the first frame is the line after it
+    started := false
+    for stopped := false; !stopped; {
+        select {
+        case err := <-exited:
+            return cameraError(fmt.Errorf("%s exited: %v", c.program, err), output.Bytes())
+        case <-c.stop:
+            stopped = true
+        case <-time.After(cameraPoll):
+        }
+        if started {
+            continue
+        }
+        if data, _ := os.ReadFile(pts); strings.Count(string(data), "\n") >= 2 {
+            started = true
+            _, t := pipeline.LastSample()
+            pipeline.Event(t, "video started")
+        }
+    }
+
+    // The camera app finishes the video when it is interrupted
+    command.Process.Signal(syscall.SIGINT)
+    select {
+    case <-exited:
+    case <-time.After(5 * time.Second):
+        command.Process.Kill()
+    }
+    _, t := pipeline.LastSample()
+    pipeline.Event(t, "video ended")
+    return nil
+}
+
+/*
+ Adds the last line of what a camera app printed to its error, which is where it says what went wrong
+ */
+func cameraError(err error, output []byte) error {
+    lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+    if last := strings.TrimSpace(lines[len(lines) - 1]); last != "" {
+        return fmt.Errorf("%v: %s", err, last)
+    }
+    return err
+}
@@ -0,0 +1,351 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "math"
+    "time"
+    "strconv"
+    "strings"
+    "unicode/utf8"
+)
+
+/*
+ The two Y axes of a chart
+ */
+const (
+    LeftAxis = 0
+    RightAxis = 1
+)
+
+/*
+ The colors of the terminal, in the order of their escape codes
+ */
+const (
+    colorBlack = iota
+    colorRed
+    colorGreen
+    colorYellow
+    colorBlue
+    colorMagenta
+    colorCyan
+    colorWhite
+)
+
+/*
+ A line chart for the terminal. Unlike the chart of goterm, it can draw any number of series on two Y axes with
+ independent scales, and the range of an axis can be given instead of being taken from the data.
+ */
+type Chart struct {
+    Width int
+    Height int
+
+    /*
+     The label of the X axis, the X value of every point, and how the ticks of the X axis are formatted. The ticks
+     are formatted like those of the Y axes if XFormat is nil.
+     */
+    XLabel string
+    Keys []float64
+    XFormat func(float64) string
+
+    /*
+     Moves the X axis beyond the keys, so the chart can scroll on between two samples. Points that are moved out on
+     the left aren't drawn.
+     */
+    Shift float64
+
+    /*
+     The index of the key that is marked with a crosshair, through the value of the first series, or -1. If Anchor
+     isn't -1 either, the range between both is selected, and its other end is marked as well.
+     */
+    Cursor int
+    Anchor int
+
+    /*
+     The indexes of keys that are marked with a line across the chart, like the markers of a recording
+     */
+    Marks []int
+
+    /*
+     The left and the right Y axis
+     */
+    Axes [2]ChartAxis
+
+    series []chartSeries
+}
+
+/*
+ A Y axis of a chart. If Min is not below Max, the range is taken from the data.
+ */
+type ChartAxis struct {
+    Label string
+    Min float64
+    Max float64
+}
+
+type chartSeries struct {
+    axis int
+    values []float64
+}
+
+func NewChart(width int, height int) *Chart {
+    // The size of the terminal is unknown if the output isn't one, so the chart keeps a minimum size
+    return &Chart{Width: max(width, 20), Height: max(height, 5), Cursor: -1, Anchor: -1}
+}
+
+/*
+ Adds a series with one value per key. Values that are NaN leave a gap.
+ */
+func (c *Chart) AddSeries(axis int, values []float64) {
+    c.series = append(c.series, chartSeries{axis: axis, values: values})
+}
+
+/*
+ Renders the chart. The first line holds the labels of the Y axes, the last one the X axis.
+ */
+func (c *Chart) Draw() string {
+    buffer := make([][]string, c.Height)
+    for y := range buffer {
+        buffer[y] = strings.Split(strings.Repeat(" ", c.Width), "")
+    }
+    write := func(text string, x int, y int) {
+        for _, char := range text {
+            if x >= 0 && x < c.Width && y >= 0 && y < c.Height {
+                buffer[y][x] = string(char)
+            }
+            x++
+        }
+    }
+
+    // Find the range and the width of the labels of both axes
+    var minY, maxY [2]float64
+    var labels [2][2]string
+    used := [2]bool{}
+    padding := [2]int{1, 0}
+    for axis := range c.Axes {
+        minY[axis], maxY[axis], used[axis] = c.axisRange(axis)
+        if !used[axis] {
+            continue
+        }
+        labels[axis] = [2]string{formatTick(maxY[axis]), formatTick(minY[axis])}
+        padding[axis] = max(utf8.RuneCountInString(labels[axis][0]), utf8.RuneCountInString(labels[axis][1])) + 1
+    }
+    minX, maxX := math.Inf(1), math.Inf(-1)
+    for _, key := range c.Keys {
+        minX = math.Min(minX, key)
+        maxX = math.Max(maxX, key)
+    }
+    if maxX <= minX {
+        maxX = minX + 1
+    }
+    minX += c.Shift
+    maxX += c.Shift
+
+    // The area that the lines are drawn into
+    top := 1
+    bottom := c.Height - 2
+    left := padding[LeftAxis]
+    right := c.Width - padding[RightAxis] - 1
+    if bottom <= top || right <= left {
+        return ""
+    }
+
+    for i, series := range c.series {
+        symbol := colored("•", i % 7 + 1)
+        scale := float64(bottom - top) / (maxY[series.axis] - minY[series.axis])
+        previous := [2]int{-1, -1}
+        for j, value := range series.values {
+            if j >= len(c.Keys) || c.Keys[j] < minX || math.IsNaN(value) {
+                previous[0] = -1
+                continue
+            }
+            x := left + int(math.Round((c.Keys[j] - minX) / (maxX - minX) * float64(right - left)))
+            y := bottom - int(math.Round((value - minY[series.axis]) * scale))
+            y = min(bottom, max(top, y))
+            if previous[0] == -1 {
+                previous = [2]int{x, y}
+            }
+            drawLine(previous[0], previous[1], x, y, func(x int, y int) {
+                buffer[y][x] = symbol
+            })
+            previous = [2]int{x, y}
+        }
+    }
+
+    // The crosshair and the selection stay behind the lines, so they don't hide them
+    mark := func(key int, symbol string) {
+        if c.Keys[key] < minX {
+            return
+        }
+        x := left + int(math.Round((c.Keys[key] - minX) / (maxX - minX) * float64(right - left)))
+        for y := top; y <= bottom; y++ {
+            if buffer[y][x] == " " {
+                buffer[y][x] = symbol
+            }
+        }
+    }
+    for _, key := range c.Marks {
+        if key >= 0 && key < len(c.Keys) {
+            mark(key, colored("│", colorYellow))
+        }
+    }
+    if c.Anchor >= 0 && c.Anchor < len(c.Keys) && c.Cursor >= 0 && c.Cursor < len(c.Keys) {
+        for key := min(c.Anchor, c.Cursor) + 1; key < max(c.Anchor, c.Cursor); key++ {
+            mark(key, "░")
+        }
+        mark(c.Anchor, "┊")
+    }
+    if c.Cursor >= 0 && c.Cursor < len(c.Keys) && len(c.series) > 0 && c.Keys[c.Cursor] >= minX {
+        mark(c.Cursor, "┊")
+        series := c.series[0]
+        if c.Cursor < len(series.values) && !math.IsNaN(series.values[c.Cursor]) {
+            scale := float64(bottom - top) / (maxY[series.axis] - minY[series.axis])
+            y := bottom - int(math.Round((series.values[c.Cursor] - minY[series.axis]) * scale))
+            y = min(bottom, max(top, y))
+            for x := left; x <= right; x++ {
+                if buffer[y][x] == " " {
+                    buffer[y][x] = "┈"
+                }
+            }
+        }
+    }
+
+    // Draw the axes and their labels
+    for y := top; y <= bottom; y++ {
+        buffer[y][left - 1] = "│"
+        if used[RightAxis] {
+            buffer[y][right + 1] = "│"
+        }
+    }
+    write(labels[LeftAxis][0], 0, top)
+    write(labels[LeftAxis][1], 0, bottom)
+    write(c.Axes[LeftAxis].Label, 0, 0)
+    if used[RightAxis] {
+        write(labels[RightAxis][0], right + 2, top)
+        write(labels[RightAxis][1], right + 2, bottom)
+        write(c.Axes[RightAxis].Label, c.Width - utf8.RuneCountInString(c.Axes[RightAxis].Label), 0)
+    }
+    if len(c.Keys) > 0 {
+        format := c.XFormat
+        if format == nil {
+            format = formatTick
+        }
+        write(format(minX), left, c.Height - 1)
+        write(c.XLabel, (c.Width - utf8.RuneCountInString(c.XLabel)) / 2, c.Height - 1)
+        write(format(maxX), right - utf8.RuneCountInString(format(maxX)) + 1, c.Height - 1)
+    }
+
+    lines := make([]string, c.Height)
+    for y := range buffer {
+        lines[y] = strings.Join(buffer[y], "")
+    }
+    return strings.Join(lines, "\n")
+}
+
+/*
+ Returns the range of an axis, and whether any series is drawn on it
+ */
+func (c *Chart) axisRange(axis int) (float64, float64, bool) {
+    low, high := math.Inf(1), math.Inf(-1)
+    used := false
+    for _, series := range c.series {
+        if series.axis != axis {
+            continue
+        }
+        used = true
+        for _, value := range series.values {
+            if !math.IsNaN(value) {
+                low = math.Min(low, value)
+                high = math.Max(high, value)
+            }
+        }
+    }
+    if !used {
+        return 0, 0, false
+    }
+    if c.Axes[axis].Min < c.Axes[axis].Max {
+        return c.Axes[axis].Min, c.Axes[axis].Max, true
+    }
+    if math.IsInf(low, 0) {
+        return 0, 1, true
+    }
+
+    // Leave some room above and below the data, like the chart of goterm
+    margin := (high - low) * 0.05
+    if margin == 0 {
+        margin = math.Max(math.Abs(high) * 0.1, 1e-9)
+    }
+    return low - margin, high + margin, true
+}
+
+/*
+ Formats the value of a tick with four significant digits
+ */
+func formatTick(value float64) string {
+    return strconv.FormatFloat(value, 'g', 4, 64)
+}
+
+/*
+ Returns the label and the format of the ticks of a time axis in seconds since the start of a session (see
+ --time-axis): the seconds themselves, the elapsed time as [h:]mm:ss.s, or the time of day
+ */
+func TimeAxis(kind string, started time.Time) (string, func(float64) string) {
+    switch kind {
+    case "elapsed":
+        return "Elapsed time", func(seconds float64) string {
+            tenths := int(math.Round(math.Max(0, seconds) * 10))
+            text := fmt.Sprintf("%02d:%02d.%d", tenths / 600 % 60, tenths / 10 % 60, tenths % 10)
+            if tenths >= 36000 {
+                text = fmt.Sprintf("%d:%s", tenths / 36000, text)
+            }
+            return text
+        }
+    case "clock":
+        return "Time of day", func(seconds float64) string {
+            return started.Add(time.Duration(seconds * float64(time.Second))).Format("15:04:05.0")
+        }
+    }
+    return "Time [s]", formatTick
+}
+
+/*
+ Calls plot for every point of a line between two points, using the algorithm of Bresenham
+ */
+func drawLine(x0 int, y0 int, x1 int, y1 int, plot func(int, int)) {
+    dx := x1 - x0
+    if dx < 0 {
+        dx = -dx
+    }
+    dy := y1 - y0
+    if dy > 0 {
+        dy = -dy
+    }
+    sx, sy := 1, 1
+    if x0 > x1 {
+        sx = -1
+    }
+    if y0 > y1 {
+        sy = -1
+    }
+    e := dx + dy
+    for true {
+        plot(x0, y0)
+        if x0 == x1 && y0 == y1 {
+            return
+        }
+        e2 := 2 * e
+        if e2 >= dy {
+            e += dy
+            x0 += sx
+        }
+        if e2 <= dx {
+            e += dx
+            y0 += sy
+        }
+    }
+}
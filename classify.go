@@ -0,0 +1,400 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "math"
+    "sort"
+    "strings"
+    "strconv"
+    "net/url"
+    "sync/atomic"
+)
+
+func init() {
+    Models["threshold"] = openThresholdModel
+}
+
+/*
+ A feature of a window of the signal, which is what the classifiers work with instead of the samples themselves
+ */
+type Feature struct {
+    Name string
+    Description string
+    Compute func(values []float64, interval float64) float64
+}
+
+/*
+ The features that are known, in the order in which they are passed to a model by default
+ */
+var Features = []Feature{
+    {"rms", "The root mean square", func(values []float64, interval float64) float64 {
+        return Calculate(values).RMS
+    }},
+    {"mav", "The mean absolute value", func(values []float64, interval float64) float64 {
+        sum := 0.0
+        for _, v := range values {
+            sum += math.Abs(v)
+        }
+        return sum / float64(max(1, len(values)))
+    }},
+    {"zc", "How often the signal crosses its mean", func(values []float64, interval float64) float64 {
+        mean := Calculate(values).Mean
+        crossings := 0
+        for i := 1; i < len(values); i++ {
+            if (values[i - 1] - mean) * (values[i] - mean) < 0 {
+                crossings++
+            }
+        }
+        return float64(crossings)
+    }},
+    {"wl", "The waveform length, the summed change from sample to sample", func(values []float64,
+        interval float64) float64 {
+        length := 0.0
+        for i := 1; i < len(values); i++ {
+            length += math.Abs(values[i] - values[i - 1])
+        }
+        return length
+    }},
+    {"var", "The variance", func(values []float64, interval float64) float64 {
+        stats := Calculate(values)
+        return math.Max(0, stats.RMS * stats.RMS - stats.Mean * stats.Mean)
+    }},
+    {"ssc", "How often the slope changes its sign", func(values []float64, interval float64) float64 {
+        changes := 0
+        for i := 2; i < len(values); i++ {
+            if (values[i - 1] - values[i - 2]) * (values[i] - values[i - 1]) < 0 {
+                changes++
+            }
+        }
+        return float64(changes)
+    }},
+    {"iemg", "The integrated EMG, in volt seconds", func(values []float64, interval float64) float64 {
+        sum := 0.0
+        for _, v := range values {
+            sum += math.Abs(v)
+        }
+        return sum * interval
+    }},
+    {"mnf", "The mean frequency of the power spectrum, in Hz", func(values []float64, interval float64) float64 {
+        mean, _ := windowFrequencies(values, interval)
+        return mean
+    }},
+    {"mdf", "The median frequency of the power spectrum, in Hz", func(values []float64, interval float64) float64 {
+        _, median := windowFrequencies(values, interval)
+        return median
+    }},
+}
+
+/*
+ The features that the classifier gets by default, the time domain features of Hudgins, which are cheap enough to
+ be computed for every window
+ */
+const defaultFeatures = "rms,mav,zc,wl"
+
+/*
+ Returns the mean and the median frequency of a window, without its mean
+ */
+func windowFrequencies(values []float64, interval float64) (float64, float64) {
+    mean := Calculate(values).Mean
+    spectrum := NewSpectrum(interval)
+    for _, v := range values {
+        spectrum.Add(v - mean)
+    }
+    frequencies, power := spectrum.Result()
+    if frequencies == nil {
+        return 0, 0
+    }
+    return SpectrumFrequencies(frequencies, power)
+}
+
+/*
+ Returns the features with the given names, separated by commas
+ */
+func FindFeatures(names string) ([]Feature, error) {
+    features := []Feature{}
+    for _, name := range strings.Split(names, ",") {
+        found := false
+        for _, feature := range Features {
+            if feature.Name == strings.TrimSpace(name) {
+                features = append(features, feature)
+                found = true
+            }
+        }
+        if !found {
+            return nil, fmt.Errorf("unknown feature %s, expected one of %s", name, strings.Join(FeatureNames(), ", "))
+        }
+    }
+    return features, nil
+}
+
+func FeatureNames() []string {
+    names := []string{}
+    for _, feature := range Features {
+        names = append(names, feature.Name)
+    }
+    return names
+}
+
+/*
+ Computes the features of a window of the signal
+ */
+func ComputeFeatures(features []Feature, values []float64, interval float64) []float64 {
+    result := make([]float64, len(features))
+    for i, feature := range features {
+        result[i] = feature.Compute(values, interval)
+    }
+    return result
+}
+
+/*
+ Decides what the subject is doing from the features of a window, like which gesture is held. It returns the class
+ and how confident it is about it, from 0 to 1. Models are called from the acquisition, so they have to be quick.
+ */
+type Model interface {
+    Classify(features []float64) (string, float64)
+}
+
+/*
+ Opens a model for the given URL, which gets the features that it is passed, in their order
+ */
+type ModelFactory func(target *url.URL, features []Feature) (Model, error)
+
+/*
+ All models that are available, by the scheme of their URL. Every model registers itself in here from an init
+ function in its own file.
+ */
+var Models = map[string]ModelFactory{}
+
+func ModelNames() []string {
+    names := []string{}
+    for name := range Models {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+/*
+ Opens the model of a URL, like threshold:rms?levels=0.05 or onnx:gestures.onnx?labels=rest,fist
+ */
+func OpenModel(description string, features []Feature) (Model, error) {
+    target, err := url.Parse(description)
+    if err != nil {
+        return nil, err
+    }
+    factory, ok := Models[target.Scheme]
+    if !ok {
+        return nil, fmt.Errorf("unknown model %s, expected one of %s", target.Scheme,
+            strings.Join(ModelNames(), ", "))
+    }
+    return factory(target, features)
+}
+
+/*
+ The class that a window was assigned to, at the time of its last sample
+ */
+type Prediction struct {
+    Time float64
+    Class string
+    Confidence float64
+}
+
+/*
+ The last samples of a signal, whose features are due every few samples
+ */
+type SlidingWindow struct {
+    Values []float64
+    size int
+    step int
+    count int
+}
+
+/*
+ Creates a window of the given seconds, that is due every step seconds once it is full
+ */
+func NewSlidingWindow(window float64, step float64, interval float64) *SlidingWindow {
+    return &SlidingWindow{size: max(2, int(math.Round(window / interval))),
+        step: max(1, int(math.Round(step / interval)))}
+}
+
+/*
+ Adds the next value, and returns whether the window is due
+ */
+func (w *SlidingWindow) Add(value float64) bool {
+    w.Values = append(w.Values, value)
+    if len(w.Values) > w.size {
+        w.Values = w.Values[len(w.Values) - w.size:]
+    }
+    w.count++
+    if len(w.Values) < w.size || w.count < w.step {
+        return false
+    }
+    w.count = 0
+    return true
+}
+
+/*
+ Classifies the processed signal in a sliding window while it is acquired: every step, the features of the last
+ window are passed to the model. The predictions of single windows jitter, so they are smoothed before they are used:
+ a window whose confidence is below the threshold is assigned to the rejection class instead, and the class is the
+ one that most of the last windows voted for. Its confidence is the mean confidence of the windows that voted for it.
+ The class is noted in the events ("class fist") whenever it changes, and the last prediction is shown on the display
+ and the server.
+ */
+type Classifier struct {
+    model Model
+    features []Feature
+    interval float64
+    window *SlidingWindow
+    class string
+    current atomic.Value
+
+    /*
+     How many windows vote for the class, 1 to use every window as it is
+     */
+    Votes int
+
+    /*
+     The confidence that a window needs, below which it is assigned to the rejection class
+     */
+    Threshold float64
+    Reject string
+
+    // The last windows, which vote for the class
+    history []Prediction
+}
+
+/*
+ Creates a classifier for windows of the given seconds, that are classified every step seconds
+ */
+func NewClassifier(model Model, features []Feature, window float64, step float64, interval float64) *Classifier {
+    return &Classifier{model: model, features: features, interval: interval,
+        window: NewSlidingWindow(window, step, interval), Votes: 1, Reject: "unknown"}
+}
+
+/*
+ Adds the next sample, and returns the new prediction if the class changed
+ */
+func (c *Classifier) Process(sample Sample) (Prediction, bool) {
+    if !c.window.Add(sample.Processed()) {
+        return Prediction{}, false
+    }
+    class, confidence := c.model.Classify(ComputeFeatures(c.features, c.window.Values, c.interval))
+    if confidence < c.Threshold {
+        class = c.Reject
+    }
+    c.history = append(c.history, Prediction{Time: sample.Time, Class: class, Confidence: confidence})
+    if len(c.history) > max(1, c.Votes) {
+        c.history = c.history[1:]
+    }
+    prediction := c.vote()
+    c.current.Store(prediction)
+    if prediction.Class == c.class {
+        return prediction, false
+    }
+    c.class = prediction.Class
+    return prediction, true
+}
+
+/*
+ Returns the class that most of the last windows voted for. A tie keeps the current class if it is among them, so
+ the class doesn't flicker between two, and otherwise goes to the class of the latest window.
+ */
+func (c *Classifier) vote() Prediction {
+    votes := map[string]int{}
+    confidences := map[string]float64{}
+    for _, prediction := range c.history {
+        votes[prediction.Class]++
+        confidences[prediction.Class] += prediction.Confidence
+    }
+    latest := c.history[len(c.history) - 1]
+    best := latest.Class
+    for class, count := range votes {
+        if count > votes[best] || (count == votes[best] && class == c.class) {
+            best = class
+        }
+    }
+    return Prediction{Time: latest.Time, Class: best, Confidence: confidences[best] / float64(votes[best])}
+}
+
+/*
+ Returns the event that notes a prediction in the recording
+ */
+func (p Prediction) Event() string {
+    return "class " + p.Class
+}
+
+/*
+ Returns the last prediction, and false if there is none yet. This can be called from any goroutine.
+ */
+func (c *Classifier) Current() (Prediction, bool) {
+    if c == nil {
+        return Prediction{}, false
+    }
+    prediction, ok := c.current.Load().(Prediction)
+    return prediction, ok
+}
+
+/*
+ The baseline model, which compares one feature with fixed levels:
+    threshold:<feature>?levels=0.02,0.1&labels=rest,weak,strong
+ The class is the label after the highest level that the feature reaches, so there is one label more than levels.
+ Without labels, one level separates rest and active. The confidence grows with the distance to the nearest level,
+ and is 1 once the feature is as far from it as the level is from zero.
+ */
+type ThresholdModel struct {
+    feature int
+    levels []float64
+    labels []string
+}
+
+func openThresholdModel(target *url.URL, features []Feature) (Model, error) {
+    name := target.Opaque
+    model := &ThresholdModel{feature: -1}
+    for i, feature := range features {
+        if feature.Name == name {
+            model.feature = i
+        }
+    }
+    if model.feature < 0 {
+        return nil, fmt.Errorf("the threshold model needs the feature %q among the features of the classifier", name)
+    }
+    query := target.Query()
+    for _, level := range strings.Split(query.Get("levels"), ",") {
+        value, err := strconv.ParseFloat(strings.TrimSpace(level), 64)
+        if err != nil {
+            return nil, fmt.Errorf("invalid levels %q, expected numbers separated by commas", query.Get("levels"))
+        }
+        model.levels = append(model.levels, value)
+    }
+    sort.Float64s(model.levels)
+    model.labels = []string{"rest", "active"}
+    if labels := query.Get("labels"); labels != "" {
+        model.labels = strings.Split(labels, ",")
+    }
+    if len(model.labels) != len(model.levels) + 1 {
+        return nil, fmt.Errorf("%d levels need %d labels", len(model.levels), len(model.levels) + 1)
+    }
+    return model, nil
+}
+
+func (m *ThresholdModel) Classify(features []float64) (string, float64) {
+    value := features[m.feature]
+    class := sort.SearchFloat64s(m.levels, value)
+    if class < len(m.levels) && m.levels[class] == value {
+        class++
+    }
+    distance := math.Inf(1)
+    for _, level := range m.levels {
+        if level != 0 {
+            distance = math.Min(distance, math.Abs(value - level) / math.Abs(level))
+        }
+    }
+    return m.labels[class], math.Min(1, distance)
+}
@@ -0,0 +1,198 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "sync"
+    "time"
+)
+
+/*
+ The time that playback and protocol timers run on. Live acquisitions always use the real time, but playback and the
+ debug mode can run faster or slower (--speed), or on a simulated clock that advances as fast as the samples can be
+ processed, which makes time based logic testable. Triggers work on the time of the samples and need no clock.
+ */
+type Clock interface {
+    Now() time.Time
+    Sleep(duration time.Duration)
+}
+
+/*
+ The clock that is used by the session
+ */
+var SystemClock Clock = RealClock{}
+
+/*
+ Returns the clock for a speed: 1 is the real time, 0 is a simulated clock, anything else scales the real time
+ */
+func NewClock(speed float64) Clock {
+    if speed == 0 {
+        return NewSimulatedClock(time.Now())
+    }
+    if speed == 1 {
+        return RealClock{}
+    }
+    return &ScaledClock{Speed: speed, origin: time.Now()}
+}
+
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+    return time.Now()
+}
+
+func (RealClock) Sleep(duration time.Duration) {
+    time.Sleep(duration)
+}
+
+/*
+ A clock that runs faster (speed > 1) or slower than the real time, starting at the time it was created
+ */
+type ScaledClock struct {
+    Speed float64
+    origin time.Time
+}
+
+func (c *ScaledClock) Now() time.Time {
+    return c.origin.Add(time.Duration(float64(time.Since(c.origin)) * c.Speed))
+}
+
+func (c *ScaledClock) Sleep(duration time.Duration) {
+    time.Sleep(time.Duration(float64(duration) / c.Speed))
+}
+
+/*
+ A clock that only moves when it is advanced, by a test or by the playback. Sleeping blocks until the clock was
+ advanced past the end of the sleep. The goroutines that sleep on the clock join it (see RunOnClock), and the clock
+ is only advanced while all of them are asleep, so everything happens at the same simulated time on every run.
+ */
+type SimulatedClock struct {
+    lock sync.Mutex
+    asleep *sync.Cond
+    now time.Time
+    sleepers []simulatedSleeper
+    awake int
+}
+
+type simulatedSleeper struct {
+    until time.Time
+    wake chan bool
+}
+
+func NewSimulatedClock(start time.Time) *SimulatedClock {
+    clock := &SimulatedClock{now: start}
+    clock.asleep = sync.NewCond(&clock.lock)
+    return clock
+}
+
+func (c *SimulatedClock) Now() time.Time {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    return c.now
+}
+
+func (c *SimulatedClock) Sleep(duration time.Duration) {
+    if duration <= 0 {
+        return
+    }
+    c.lock.Lock()
+    sleeper := simulatedSleeper{until: c.now.Add(duration), wake: make(chan bool)}
+    c.sleepers = append(c.sleepers, sleeper)
+    c.leave()
+    c.lock.Unlock()
+    <-sleeper.wake
+}
+
+/*
+ Moves the clock forward and wakes everything whose sleep has ended. It waits for the goroutines on the clock to fall
+ asleep before and after, so they never fall behind the one that advances the clock.
+ */
+func (c *SimulatedClock) Advance(duration time.Duration) {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    c.settle()
+    c.now = c.now.Add(duration)
+    waiting := []simulatedSleeper{}
+    for _, sleeper := range c.sleepers {
+        if sleeper.until.After(c.now) {
+            waiting = append(waiting, sleeper)
+        } else {
+            c.awake++
+            close(sleeper.wake)
+        }
+    }
+    c.sleepers = waiting
+    c.settle()
+}
+
+/*
+ Waits until all goroutines on the clock are asleep, the lock has to be held
+ */
+func (c *SimulatedClock) settle() {
+    for c.awake > 0 {
+        c.asleep.Wait()
+    }
+}
+
+/*
+ Registers a goroutine that sleeps on the clock, and unregisters it when it is done
+ */
+func (c *SimulatedClock) Join() {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    c.awake++
+}
+
+func (c *SimulatedClock) Leave() {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    c.leave()
+}
+
+func (c *SimulatedClock) leave() {
+    c.awake--
+    c.asleep.Broadcast()
+}
+
+/*
+ Runs a function in the background that sleeps on the clock of the session, like the timers of the protocol. On a
+ simulated clock, this returns once the function is asleep, so it sees the session from the start on every run.
+ */
+func RunOnClock(f func()) {
+    simulated, ok := SystemClock.(*SimulatedClock)
+    if !ok {
+        go f()
+        return
+    }
+    simulated.Join()
+    go func() {
+        defer simulated.Leave()
+        f()
+    }()
+    simulated.lock.Lock()
+    defer simulated.lock.Unlock()
+    simulated.settle()
+}
+
+/*
+ Waits for the given time, or if the clock is simulated, advances it instead. This is used by sources that set the
+ pace of the session, like the playback.
+ */
+func Pace(duration time.Duration) {
+    if simulated, ok := SystemClock.(*SimulatedClock); ok {
+        simulated.Advance(duration)
+        return
+    }
+    SystemClock.Sleep(duration)
+}
+
+/*
+ Returns the time until the given moment on the clock of the session
+ */
+func Until(moment time.Time) time.Duration {
+    return moment.Sub(SystemClock.Now())
+}
@@ -0,0 +1,113 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "math"
+    "strings"
+)
+
+/*
+ The name of the muscle signal when choosing the channels of the co-contraction index. Every other name is an
+ auxiliary sensor.
+ */
+const muscleChannel = "emg"
+
+/*
+ Compares the activity of an agonist and its antagonist. The co-contraction index (after Falconer and Winter) is the
+ activity that both muscles share, relative to their total activity:
+    CCI = 2 * ∫min(|agonist|, |antagonist|) / ∫(|agonist| + |antagonist|) * 100%
+ It is 100% if both muscles are equally active, and 0% if only one of them is. The channels should carry comparable
+ signals, so an antagonist on an auxiliary sensor should be measured in volts like the muscle signal.
+ */
+type CoContraction struct {
+
+    /*
+     The channels of both muscles, either the index of an auxiliary sensor or -1 for the muscle signal
+     */
+    Agonist int
+    Antagonist int
+}
+
+/*
+ Finds the channels of the agonist and the antagonist, given as two names separated by a comma (e.g. "emg,triceps").
+ Returns nil if no channels are given.
+ */
+func ParseCoContraction(names string, aux AuxChannels) (*CoContraction, error) {
+    if names == "" {
+        return nil, nil
+    }
+    parts := strings.Split(names, ",")
+    if len(parts) != 2 {
+        return nil, fmt.Errorf("expected agonist,antagonist for the co-contraction index, got %s", names)
+    }
+    channels := [2]int{}
+    for i, name := range parts {
+        channels[i] = -1
+        if name == muscleChannel {
+            continue
+        }
+        found := false
+        for j, channel := range aux {
+            if channel.Name == name {
+                channels[i] = j
+                found = true
+            }
+        }
+        if !found {
+            return nil, fmt.Errorf("there is no auxiliary sensor called %s", name)
+        }
+    }
+    if channels[0] == channels[1] {
+        return nil, fmt.Errorf("the agonist and the antagonist need to be different channels")
+    }
+    return &CoContraction{Agonist: channels[0], Antagonist: channels[1]}, nil
+}
+
+/*
+ Returns the rectified activity of both muscles in a sample
+ */
+func (c *CoContraction) Values(value float64, aux []float64) (float64, float64) {
+    return math.Abs(c.channel(value, aux, c.Agonist)), math.Abs(c.channel(value, aux, c.Antagonist))
+}
+
+func (c *CoContraction) channel(value float64, aux []float64, channel int) float64 {
+    if channel < 0 {
+        return value
+    }
+    if channel >= len(aux) {
+        return math.NaN()
+    }
+    return aux[channel]
+}
+
+/*
+ Computes the co-contraction index over the last seconds of the signal
+ */
+func (c *CoContraction) Window(keys []float64, values []float64, aux [][]float64, seconds float64) float64 {
+    common, total := float64(0), float64(0)
+    for i := len(keys) - 1; i >= 0 && keys[len(keys)-1] - keys[i] <= seconds; i-- {
+        agonist, antagonist := c.Values(values[i], aux[i])
+        if math.IsNaN(agonist + antagonist) {
+            continue
+        }
+        common += math.Min(agonist, antagonist)
+        total += agonist + antagonist
+    }
+    return CoContractionIndex(common, total)
+}
+
+/*
+ Computes the co-contraction index in percent from the integral of the shared activity and the total activity
+ */
+func CoContractionIndex(common float64, total float64) float64 {
+    if total == 0 {
+        return 0
+    }
+    return 2 * common / total * 100
+}
@@ -0,0 +1,61 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "fmt"
+    "sort"
+)
+
+/*
+ A subcommand of the application. Subcommands are selected by the first command line argument, for example:
+    $ plot verify data.csv
+ The function receives all arguments that follow the name of the subcommand.
+ */
+type Command func(args []string)
+
+/*
+ All subcommands that are available. Every subcommand registers itself in here from an init function in its own file.
+ */
+var Commands = map[string]Command{}
+
+/*
+ Checks whether the user wants to run a subcommand, and runs it. Returns false if the arguments don't start with the
+ name of a known subcommand, in which case the regular plotting mode should be started.
+ */
+func RunCommand(args []string) bool {
+    if len(args) == 0 {
+        return false
+    }
+    command, ok := Commands[args[0]]
+    if !ok {
+        return false
+    }
+    command(args[1:])
+    return true
+}
+
+/*
+ Returns the names of all registered subcommands in alphabetical order
+ */
+func CommandNames() []string {
+    names := []string{}
+    for name := range Commands {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+/*
+ Prints an error message for a failed subcommand and exits the application
+ */
+func fail(format string, args ...interface{}) {
+    fmt.Fprintf(os.Stderr, format + "\n", args...)
+    os.Exit(1)
+}
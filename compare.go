@@ -0,0 +1,346 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "fmt"
+    "flag"
+    "math"
+    "encoding/json"
+)
+
+func init() {
+    Commands["compare"] = compareCommand
+}
+
+/*
+ The measures that are compared between sessions
+ */
+var comparedMeasures = []string{"mean", "rms", "peak", "iemg"}
+
+/*
+ How two sessions differ: the measures of the whole recordings, and the segments that both have in common, paired by
+ their name and repetition
+ */
+type Comparison struct {
+    A string `json:"a"`
+    B string `json:"b"`
+    Signal string `json:"signal"`
+    Overall []Delta `json:"overall"`
+    Segments []SegmentComparison `json:"segments"`
+}
+
+/*
+ The change of a measure from session A to session B
+ */
+type Delta struct {
+    Measure string `json:"measure"`
+    A float64 `json:"a"`
+    B float64 `json:"b"`
+    Delta float64 `json:"delta"`
+    Percent *float64 `json:"percent"`
+}
+
+/*
+ The change of the measures over the repetitions of a segment that both sessions have
+ */
+type SegmentComparison struct {
+    Segment string `json:"segment"`
+    Pairs int `json:"pairs"`
+    Tests []PairedTest `json:"tests"`
+}
+
+/*
+ A paired t-test of a measure: the mean of both sessions, the mean difference, the t statistic, its two-sided p-value
+ and the effect size (Cohen's d of the differences, d_z). The test needs at least two pairs that don't all differ by
+ the same amount, otherwise the statistic, the p-value and the effect size are nil.
+ */
+type PairedTest struct {
+    Measure string `json:"measure"`
+    A float64 `json:"a"`
+    B float64 `json:"b"`
+    Delta float64 `json:"delta"`
+    T *float64 `json:"t"`
+    P *float64 `json:"p"`
+    EffectSize *float64 `json:"effect_size"`
+}
+
+/*
+ Compares two sessions, like the same exercise a week apart. The measures of the whole recordings are compared, and
+ the segments with the same name (phases of the protocol or markers) are paired by their repetition and tested for a
+ difference. The processed signal is compared if both recordings stored the same last processing stage, otherwise the
+ raw signal.
+ Example:
+    $ plot compare week1.csv week2.csv
+    $ plot compare --json week1.csv week2.csv > comparison.json
+ */
+func compareCommand(args []string) {
+    flags := flag.NewFlagSet("compare", flag.ExitOnError)
+    asJSON := flags.Bool("json", false, "Write the comparison as JSON")
+    flags.StringVar(&(Settings.KeyFile), "key", "", "The key file for encrypted recordings")
+    flags.BoolVar(&(Settings.MotionGate), "motion-gate", false, "Leave the samples during motion out, see " +
+        "plot analyze")
+    flags.Float64Var(&(Settings.MotionHold), "motion-hold", 0.5, "How many seconds around the motion are left out")
+    flags.Parse(args)
+    if flags.NArg() != 2 {
+        fail("Usage: plot compare [--json] [--motion-gate] <a.csv> <b.csv>")
+    }
+
+    overall := [2][]SegmentStatistics{}
+    segments := [2][]SegmentStatistics{}
+    for i, file := range flags.Args() {
+        meta, err := LoadMetadata(file)
+        if err != nil {
+            fail("%s: %v", file, err)
+        }
+        events, err := ReadAnnotatedEvents(file)
+        if err != nil {
+            fail("%s: %v", file, err)
+        }
+        overall[i], err = segmentStatistics(file, meta, nil)
+        if err != nil {
+            fail("%s: %v", file, err)
+        }
+        if len(overall[i]) == 0 {
+            fail("%s: the recording is empty", file)
+        }
+        segments[i], err = segmentStatistics(file, meta, events)
+        if err != nil {
+            fail("%s: %v", file, err)
+        }
+    }
+    comparison := Compare(flags.Arg(0), flags.Arg(1), overall, segments)
+
+    if *asJSON {
+        data, err := json.MarshalIndent(comparison, "", "    ")
+        if err != nil {
+            fail("%v", err)
+        }
+        os.Stdout.Write(append(data, '\n'))
+        return
+    }
+    fmt.Printf("Comparing %s (A) with %s (B), signal %s\n\n", comparison.A, comparison.B, comparison.Signal)
+    fmt.Println("Whole sessions:")
+    for _, delta := range comparison.Overall {
+        fmt.Printf("  %-5s A %-12s B %-12s %s\n", delta.Measure, formatMeasure(delta.Measure, delta.A),
+            formatMeasure(delta.Measure, delta.B), formatChange(delta.Measure, delta.Delta, delta.A))
+    }
+    for _, segment := range comparison.Segments {
+        fmt.Printf("\nSegment %s (%d pairs):\n", segment.Segment, segment.Pairs)
+        for _, test := range segment.Tests {
+            line := fmt.Sprintf("  %-5s A %-12s B %-12s %-22s", test.Measure, formatMeasure(test.Measure, test.A),
+                formatMeasure(test.Measure, test.B), formatChange(test.Measure, test.Delta, test.A))
+            if test.P != nil {
+                line += fmt.Sprintf("   t %.2f   p %.3f   d %.2f", *test.T, *test.P, *test.EffectSize)
+                if *test.P < 0.05 {
+                    line += " *"
+                }
+            }
+            fmt.Println(line)
+        }
+    }
+    if len(comparison.Segments) == 0 {
+        fmt.Println("\nThe sessions have no segments in common.")
+    }
+}
+
+/*
+ Compares the statistics of two sessions: the whole recordings, and the segments of both
+ */
+func Compare(a string, b string, overall [2][]SegmentStatistics, segments [2][]SegmentStatistics) Comparison {
+    comparison := Comparison{A: a, B: b, Signal: "raw", Overall: []Delta{}, Segments: []SegmentComparison{}}
+
+    // The last processing stage is compared if both sessions have the same, which is the last signal of a segment
+    last := [2]string{}
+    for i := range overall {
+        if len(overall[i]) > 0 {
+            last[i] = overall[i][len(overall[i]) - 1].Signal
+        }
+    }
+    if last[0] == last[1] && last[0] != "" {
+        comparison.Signal = last[0]
+    }
+    signal := func(statistics []SegmentStatistics) []SegmentStatistics {
+        selected := []SegmentStatistics{}
+        for _, s := range statistics {
+            if s.Signal == comparison.Signal {
+                selected = append(selected, s)
+            }
+        }
+        return selected
+    }
+
+    wholeA, wholeB := signal(overall[0]), signal(overall[1])
+    for _, measure := range comparedMeasures {
+        if len(wholeA) == 0 || len(wholeB) == 0 {
+            break
+        }
+        valueA, valueB := wholeA[0].Measure(measure), wholeB[0].Measure(measure)
+        comparison.Overall = append(comparison.Overall, Delta{Measure: measure, A: valueA, B: valueB,
+            Delta: valueB - valueA, Percent: percentChange(valueB - valueA, valueA)})
+    }
+
+    // Pair the repetitions of the segments in the order of the first session
+    repetitions := map[string]map[int]SegmentStatistics{}
+    for _, s := range signal(segments[1]) {
+        if repetitions[s.Name] == nil {
+            repetitions[s.Name] = map[int]SegmentStatistics{}
+        }
+        repetitions[s.Name][s.Repetition] = s
+    }
+    pairs := map[string][][2]SegmentStatistics{}
+    names := []string{}
+    for _, s := range signal(segments[0]) {
+        other, ok := repetitions[s.Name][s.Repetition]
+        if !ok {
+            continue
+        }
+        if _, seen := pairs[s.Name]; !seen {
+            names = append(names, s.Name)
+        }
+        pairs[s.Name] = append(pairs[s.Name], [2]SegmentStatistics{s, other})
+    }
+    for _, name := range names {
+        segment := SegmentComparison{Segment: name, Pairs: len(pairs[name])}
+        for _, measure := range comparedMeasures {
+            valuesA, valuesB := []float64{}, []float64{}
+            for _, pair := range pairs[name] {
+                valuesA = append(valuesA, pair[0].Measure(measure))
+                valuesB = append(valuesB, pair[1].Measure(measure))
+            }
+            test := PairedTTest(valuesA, valuesB)
+            test.Measure = measure
+            segment.Tests = append(segment.Tests, test)
+        }
+        comparison.Segments = append(comparison.Segments, segment)
+    }
+    return comparison
+}
+
+/*
+ Tests whether paired values differ on average, with a paired t-test
+ */
+func PairedTTest(a []float64, b []float64) PairedTest {
+    differences := make([]float64, len(a))
+    for i := range a {
+        differences[i] = b[i] - a[i]
+    }
+    test := PairedTest{A: Calculate(a).Mean, B: Calculate(b).Mean, Delta: Calculate(differences).Mean}
+    n := float64(len(differences))
+    if n < 2 {
+        return test
+    }
+    squares := 0.0
+    for _, d := range differences {
+        squares += (d - test.Delta) * (d - test.Delta)
+    }
+    deviation := math.Sqrt(squares / (n - 1))
+    if deviation == 0 {
+        return test
+    }
+    effect := test.Delta / deviation
+    t := effect * math.Sqrt(n)
+    p := studentP(t, n - 1)
+    test.T, test.P, test.EffectSize = &t, &p, &effect
+    return test
+}
+
+/*
+ Returns the two-sided p-value of a t statistic with the given degrees of freedom
+ */
+func studentP(t float64, df float64) float64 {
+    return incompleteBeta(df / 2, 0.5, df / (df + t * t))
+}
+
+/*
+ The regularized incomplete beta function I_x(a, b), evaluated with its continued fraction (Lentz's method)
+ */
+func incompleteBeta(a float64, b float64, x float64) float64 {
+    if x <= 0 {
+        return 0
+    } else if x >= 1 {
+        return 1
+    }
+
+    // The continued fraction converges quickly below this point, above it the symmetry I_x(a, b) = 1 - I_1-x(b, a)
+    // is used
+    if x > (a + 1) / (a + b + 2) {
+        return 1 - incompleteBeta(b, a, 1 - x)
+    }
+    lbeta, _ := math.Lgamma(a + b)
+    la, _ := math.Lgamma(a)
+    lb, _ := math.Lgamma(b)
+    front := math.Exp(lbeta - la - lb + a * math.Log(x) + b * math.Log(1 - x)) / a
+
+    const tiny = 1e-300
+    c, d := 1.0, 1 - (a + b) * x / (a + 1)
+    if math.Abs(d) < tiny {
+        d = tiny
+    }
+    d = 1 / d
+    f := d
+    for m := 1; m <= 200; m++ {
+        k := float64(m)
+        for _, numerator := range []float64{k * (b - k) * x / ((a + 2 * k - 1) * (a + 2 * k)),
+            -(a + k) * (a + b + k) * x / ((a + 2 * k) * (a + 2 * k + 1))} {
+            d = 1 + numerator * d
+            if math.Abs(d) < tiny {
+                d = tiny
+            }
+            c = 1 + numerator / c
+            if math.Abs(c) < tiny {
+                c = tiny
+            }
+            d = 1 / d
+            f *= c * d
+        }
+        if math.Abs(c * d - 1) < 1e-12 {
+            break
+        }
+    }
+    return front * f
+}
+
+/*
+ Formats the value of a measure with its unit
+ */
+func formatMeasure(measure string, value float64) string {
+    switch measure {
+    case "samples":
+        return fmt.Sprintf("%.0f", value)
+    case "iemg":
+        return AutoUnit.Format(value) + " s"
+    }
+    return AutoUnit.Format(value)
+}
+
+/*
+ Returns the change relative to the first value in percent, or nil if the first value is zero
+ */
+func percentChange(delta float64, from float64) *float64 {
+    if from == 0 {
+        return nil
+    }
+    percent := delta / math.Abs(from) * 100
+    return &percent
+}
+
+/*
+ Formats the change of a measure, with its sign and relative to the first value
+ */
+func formatChange(measure string, delta float64, from float64) string {
+    sign := "+"
+    if delta < 0 {
+        sign = "-"
+    }
+    change := sign + formatMeasure(measure, math.Abs(delta))
+    if percent := percentChange(delta, from); percent != nil {
+        change += fmt.Sprintf(" (%+.1f%%)", *percent)
+    }
+    return change
+}
@@ -0,0 +1,230 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "math"
+    "time"
+    "regexp"
+    "strings"
+    "strconv"
+)
+
+/*
+ A trigger that combines conditions on several channels, like the isolated activation of one muscle:
+    --trigger-when="rms > 30%MVC and rms(triceps, 0.1) < 10%MVC for 500ms"
+ Every condition compares a channel with a value: emg is the processed signal, raw the signal before the processing,
+ and every processing stage (like rms) and every auxiliary sensor is available by its name. rms(channel, seconds) is
+ the moving RMS of a channel, the envelope of a muscle on an auxiliary sensor. Values in volts can be given with a
+ unit (V, mV, µV or uV, and %MVC of --mvc), values of auxiliary sensors are in their unit. Conditions are combined
+ with and, which binds first, and or. The trigger fires once the combination holds for the time given after for,
+ or at once without it.
+ */
+type TriggerCondition struct {
+    Hold float64
+
+    // Any of the groups has to hold, and a group holds if all of its comparisons hold
+    groups [][]*comparison
+    holds bool
+}
+
+/*
+ A channel compared with a threshold. The envelope is the moving RMS for rms(channel, seconds), or nil.
+ */
+type comparison struct {
+    channel func(sample Sample) float64
+    envelope *MovingRMS
+    operator string
+    threshold float64
+}
+
+/*
+ The parts of a condition: names, numbers with their unit, operators and parentheses
+ */
+var conditionToken = regexp.MustCompile(`-?[0-9.]+(%MVC|[a-zA-Zµ]+)?|[a-zA-Z_][a-zA-Z0-9_.-]*|[<>]=?|[(),]`)
+
+/*
+ A value with its unit
+ */
+var conditionValue = regexp.MustCompile(`^(-?[0-9.]+)(.*)$`)
+
+/*
+ Parses a condition for the given processing stages and auxiliary sensors. The interval is the amount of seconds
+ between two samples, and mvc the voltage of the maximum voluntary contraction, or zero if it isn't known.
+ */
+func ParseTriggerCondition(description string, stages []string, aux AuxChannels, mvc float64,
+    interval float64) (*TriggerCondition, error) {
+    tokens := conditionToken.FindAllString(description, -1)
+    if strings.Join(tokens, "") != strings.Join(strings.Fields(description), "") {
+        return nil, fmt.Errorf("invalid condition %q", description)
+    }
+    condition := &TriggerCondition{groups: [][]*comparison{{}}}
+    for len(tokens) > 0 {
+        compared, rest, err := parseComparison(tokens, stages, aux, mvc, interval)
+        if err != nil {
+            return nil, err
+        }
+        group := len(condition.groups) - 1
+        condition.groups[group] = append(condition.groups[group], compared)
+        tokens = rest
+        if len(tokens) == 0 {
+            break
+        }
+        operator := strings.ToLower(tokens[0])
+        switch operator {
+        case "and":
+        case "or":
+            condition.groups = append(condition.groups, []*comparison{})
+        case "for":
+            if len(tokens) != 2 {
+                return nil, fmt.Errorf("expected a duration after for, like 500ms")
+            }
+            hold, err := time.ParseDuration(tokens[1])
+            if err != nil || hold < 0 {
+                return nil, fmt.Errorf("invalid duration %s, expected something like 500ms", tokens[1])
+            }
+            condition.Hold = hold.Seconds()
+            return condition, nil
+        default:
+            return nil, fmt.Errorf("expected and, or or for, got %s", tokens[0])
+        }
+        tokens = tokens[1:]
+        if len(tokens) == 0 {
+            return nil, fmt.Errorf("the condition ends with %s", operator)
+        }
+    }
+    if len(condition.groups[0]) == 0 {
+        return nil, fmt.Errorf("the condition is empty")
+    }
+    return condition, nil
+}
+
+/*
+ Parses a comparison at the start of the tokens, and returns the tokens after it
+ */
+func parseComparison(tokens []string, stages []string, aux AuxChannels, mvc float64,
+    interval float64) (*comparison, []string, error) {
+    compared := &comparison{}
+    name := tokens[0]
+    tokens = tokens[1:]
+    if name == "rms" && len(tokens) > 0 && tokens[0] == "(" {
+        if len(tokens) < 5 || tokens[2] != "," || tokens[4] != ")" {
+            return nil, nil, fmt.Errorf("expected rms(channel, seconds)")
+        }
+        seconds, err := strconv.ParseFloat(tokens[3], 64)
+        if err != nil || seconds < interval {
+            return nil, nil, fmt.Errorf("the window of rms(%s, %s) must be at least one interval long", tokens[1],
+                tokens[3])
+        }
+        name = tokens[1]
+        compared.envelope = NewMovingRMS(int(seconds / interval))
+        tokens = tokens[5:]
+    }
+    channel, volts, err := conditionChannel(name, stages, aux)
+    if err != nil {
+        return nil, nil, err
+    }
+    compared.channel = channel
+    value := []string{}
+    if len(tokens) >= 2 && strings.ContainsAny(tokens[0], "<>") {
+        value = conditionValue.FindStringSubmatch(tokens[1])
+    }
+    if value == nil {
+        return nil, nil, fmt.Errorf("expected a comparison like %s > 0.5mV", name)
+    }
+    compared.operator = tokens[0]
+
+    // The value, in the unit of the channel
+    compared.threshold, err = strconv.ParseFloat(value[1], 64)
+    if err != nil {
+        return nil, nil, fmt.Errorf("invalid value %s", tokens[1])
+    }
+    if unit := value[2]; unit != "" {
+        if !volts {
+            return nil, nil, fmt.Errorf("%s isn't measured in volts, its value is given without a unit", name)
+        }
+        scale, err := ParseUnit(unit, mvc)
+        if err != nil || scale == AutoUnit {
+            return nil, nil, fmt.Errorf("invalid unit of %s: %v", tokens[1], err)
+        }
+        compared.threshold /= scale.Scale
+    }
+    return compared, tokens[2:], nil
+}
+
+/*
+ Finds a channel by its name, and returns whether it is measured in volts
+ */
+func conditionChannel(name string, stages []string, aux AuxChannels) (func(sample Sample) float64, bool, error) {
+    switch name {
+    case muscleChannel:
+        return Sample.Processed, true, nil
+    case "raw":
+        return func(sample Sample) float64 {
+            return sample.Value
+        }, true, nil
+    }
+    for i, stage := range stages {
+        if stage == name {
+            return func(sample Sample) float64 {
+                if i >= len(sample.Stages) {
+                    return math.NaN()
+                }
+                return sample.Stages[i]
+            }, true, nil
+        }
+    }
+    for i, channel := range aux {
+        if channel.Name == name {
+            return func(sample Sample) float64 {
+                if i >= len(sample.Aux) {
+                    return math.NaN()
+                }
+                return sample.Aux[i]
+            }, channel.Unit == "V", nil
+        }
+    }
+    return nil, false, fmt.Errorf("unknown channel %s, expected emg, raw, a processing stage or an auxiliary sensor",
+        name)
+}
+
+/*
+ Takes the next sample and checks whether the condition holds for it. Every sample that the pipeline processes has
+ to be passed, also those that aren't recorded, so the envelopes are up to date.
+ */
+func (c *TriggerCondition) Update(sample Sample) {
+    result := false
+    for _, group := range c.groups {
+        holds := true
+        for _, compared := range group {
+            value := compared.channel(sample)
+            if compared.envelope != nil {
+                value = compared.envelope.Process(value)
+            }
+            switch compared.operator {
+            case "<":
+                holds = holds && value < compared.threshold
+            case "<=":
+                holds = holds && value <= compared.threshold
+            case ">":
+                holds = holds && value > compared.threshold
+            case ">=":
+                holds = holds && value >= compared.threshold
+            }
+        }
+        result = result || holds
+    }
+    c.holds = result
+}
+
+/*
+ Whether the condition holds for the last sample that was passed to Update
+ */
+func (c *TriggerCondition) Holds() bool {
+    return c.holds
+}
@@ -0,0 +1,121 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "fmt"
+    "flag"
+    "path/filepath"
+    "encoding/json"
+)
+
+func init() {
+    Commands["keys"] = keysCommand
+}
+
+/*
+ The configuration of the user, which applies to every session, unlike the profiles of subjects. It is a JSON file:
+    {"options": {"address": "0x68", "channel": "2"}, "keys": {"marker": "F13", "pause": "F14,p"}}
+ options are the defaults for the options of the command line, like in a profile (see Profile). The command line and
+ the profile take precedence. keys changes the keys of the display, see RemapKeys and "plot keys". "plot init" writes
+ the options for the hardware that it finds.
+ */
+type Config struct {
+    Options Profile `json:"options,omitempty"`
+    Keys map[string]string `json:"keys,omitempty"`
+}
+
+/*
+ Returns where the configuration is stored by default, ~/.config/plot/config.json on Linux
+ */
+func DefaultConfigFile() string {
+    dir, err := os.UserConfigDir()
+    if err != nil {
+        return ""
+    }
+    return filepath.Join(dir, "plot", "config.json")
+}
+
+/*
+ Loads the configuration and changes the keys of the display. A missing file is only an error if it was asked for,
+ since most users never write one.
+ */
+func LoadConfig(file string, required bool) (Config, error) {
+    config := Config{}
+    data, err := os.ReadFile(file)
+    if os.IsNotExist(err) && !required {
+        return config, nil
+    } else if err != nil {
+        return config, err
+    }
+    err = json.Unmarshal(data, &config)
+    if err != nil {
+        return config, err
+    }
+    err = RemapKeys(config.Keys)
+    if err != nil {
+        return config, fmt.Errorf("keys: %v", err)
+    }
+    return config, nil
+}
+
+func SaveConfig(file string, config Config) error {
+    data, err := json.MarshalIndent(config, "", "    ")
+    if err != nil {
+        return err
+    }
+    err = os.MkdirAll(filepath.Dir(file), 0700)
+    if err != nil {
+        return err
+    }
+    return os.WriteFile(file, data, 0600)
+}
+
+/*
+ Lists the keys of the display with the configuration applied, or shows the names of the keys that are pressed, to
+ find out what to write into the configuration for a foot pedal or another special keyboard.
+ Example:
+    $ plot keys --press
+    Press the keys to see their names, q quits
+    F13
+    F14
+ */
+func keysCommand(args []string) {
+    flags := flag.NewFlagSet("keys", flag.ExitOnError)
+    file := flags.String("config", DefaultConfigFile(), "The configuration file")
+    press := flags.Bool("press", false, "Show the names of the keys that are pressed")
+    flags.Parse(args)
+    given := false
+    flags.Visit(func(f *flag.Flag) {
+        given = given || f.Name == "config"
+    })
+    _, err := LoadConfig(*file, given)
+    if err != nil {
+        fail("%s: %v", *file, err)
+    }
+    if !*press {
+        for _, binding := range Bindings {
+            fmt.Printf("%-10s %-18s %s\n", KeyName(binding.Key), binding.Action, binding.Description)
+        }
+        return
+    }
+
+    fmt.Println("Press the keys to see their names, q quits")
+    input := ReadKeys()
+    defer RestoreTerminal()
+    for key := range input {
+        if key == 'q' {
+            return
+        }
+        line := KeyName(key)
+        if action := KeyAction(key); action != "" {
+            line += "   " + action
+        }
+        fmt.Println(line)
+    }
+}
@@ -0,0 +1,177 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "io"
+    "fmt"
+    "math"
+    "sort"
+    "strings"
+    "net/url"
+    "sync/atomic"
+    "encoding/binary"
+)
+
+/*
+ The size of a message of the control output in bytes, which fits into a cache line
+ */
+const ControlMessageSize = 64
+
+/*
+ How many bytes of the class fit into a message of the control output. Longer classes are cut off.
+ */
+const controlClassSize = 32
+
+/*
+ What the control output sends for every sample: the envelope, the processed signal, and the class of the classifier
+ with its confidence, if there is one. The sequence counts the messages from 1, so a receiver can tell whether it
+ missed some or got them out of order, and drop those that are older than the last one it used.
+ */
+type ControlMessage struct {
+    Sequence uint64
+    Time float64
+    Envelope float64
+    Confidence float64
+    Class string
+}
+
+/*
+ Encodes a message into its fixed layout, in little endian:
+    0   uint64      The sequence
+    8   float64     The time of the sample, in seconds since the start of the acquisition
+    16  float64     The envelope in volts
+    24  float64     The confidence of the class, from 0 to 1
+    32  [32]byte    The class, padded with zeros, empty without a classifier
+ */
+func (m ControlMessage) MarshalBinary() ([]byte, error) {
+    data := make([]byte, ControlMessageSize)
+    binary.LittleEndian.PutUint64(data[0:], m.Sequence)
+    binary.LittleEndian.PutUint64(data[8:], math.Float64bits(m.Time))
+    binary.LittleEndian.PutUint64(data[16:], math.Float64bits(m.Envelope))
+    binary.LittleEndian.PutUint64(data[24:], math.Float64bits(m.Confidence))
+    copy(data[32:32 + controlClassSize], m.Class)
+    return data, nil
+}
+
+/*
+ Opens the target of the control output for a URL
+ */
+type ControlFactory func(target *url.URL) (io.WriteCloser, error)
+
+/*
+ All targets of the control output, by the scheme of their URL. Every target registers itself in here from an init
+ function in its own file.
+ */
+var Controls = map[string]ControlFactory{}
+
+func ControlNames() []string {
+    names := []string{}
+    for name := range Controls {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+/*
+ A path for closed-loop control, like a prosthesis or a game, that is kept apart from the streams (see --sink). The
+ streams are best effort and share their queues with everything else, so a slow sink delays what follows. The control
+ output only carries the envelope and the class, with a short queue and a goroutine of its own: when the target falls
+ behind, the oldest messages are dropped, since a controller wants the latest state and not a backlog. The messages
+ are written one at a time in the order of the samples, so they never overtake each other.
+ */
+type ControlOutput struct {
+    target io.WriteCloser
+    name string
+    messages chan ControlMessage
+    done chan bool
+    sequence uint64
+    dropped uint64
+    failures uint64
+}
+
+/*
+ Opens the control output for a URL, like udp://host:port, with a queue for the given number of messages
+ */
+func OpenControl(description string, queue int) (*ControlOutput, error) {
+    target, err := url.Parse(description)
+    if err != nil {
+        return nil, err
+    }
+    factory, ok := Controls[target.Scheme]
+    if !ok {
+        return nil, fmt.Errorf("unknown control output %s, expected one of %s", target.Scheme,
+            strings.Join(ControlNames(), ", "))
+    }
+    writer, err := factory(target)
+    if err != nil {
+        return nil, err
+    }
+    control := &ControlOutput{target: writer, name: description, messages: make(chan ControlMessage, max(1, queue)),
+        done: make(chan bool)}
+    go control.run()
+    return control, nil
+}
+
+/*
+ Queues the message of a sample, and drops the oldest messages if the queue is full. This is called from Push, one
+ sample after the other, so the sequences are in order.
+ */
+func (c *ControlOutput) Send(sample Sample, classifier *Classifier) {
+    c.sequence++
+    message := ControlMessage{Sequence: c.sequence, Time: sample.Time, Envelope: sample.Processed()}
+    if prediction, ok := classifier.Current(); ok {
+        message.Class, message.Confidence = prediction.Class, prediction.Confidence
+    }
+    for true {
+        select {
+        case c.messages <- message:
+            return
+        default:
+        }
+        select {
+        case <-c.messages:
+            atomic.AddUint64(&c.dropped, 1)
+        default:
+        }
+    }
+}
+
+func (c *ControlOutput) run() {
+    defer close(c.done)
+    for message := range c.messages {
+        data, _ := message.MarshalBinary()
+        // A message that failed is not sent again, it would only be older than the next one
+        if _, err := c.target.Write(data); err != nil {
+            atomic.AddUint64(&c.failures, 1)
+        }
+    }
+}
+
+/*
+ Returns how many messages were dropped because the target fell behind, and how many it failed to take
+ */
+func (c *ControlOutput) Dropped() (uint64, uint64) {
+    if c == nil {
+        return 0, 0
+    }
+    return atomic.LoadUint64(&c.dropped), atomic.LoadUint64(&c.failures)
+}
+
+/*
+ Sends what is left in the queue and closes the target
+ */
+func (c *ControlOutput) Close() error {
+    close(c.messages)
+    <-c.done
+    return c.target.Close()
+}
+
+func (c *ControlOutput) String() string {
+    return c.name
+}
@@ -0,0 +1,33 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+//go:build !nonet
+
+package main
+
+import (
+    "io"
+    "net"
+    "net/url"
+)
+
+func init() {
+    Controls["udp"] = openControlDatagram
+    Controls["unixgram"] = openControlDatagram
+}
+
+/*
+ Sends every message of the control output as one datagram, which is never retransmitted or held back like a stream:
+    udp://host:port             To a controller on the network
+    unixgram:///run/ctrl.sock   To a controller on the same device, through a datagram socket
+ */
+func openControlDatagram(target *url.URL) (io.WriteCloser, error) {
+    address := target.Host
+    if target.Scheme == "unixgram" {
+        address = target.Path
+    }
+    return net.Dial(target.Scheme, address)
+}
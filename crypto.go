@@ -0,0 +1,431 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "io"
+    "fmt"
+    "bufio"
+    "errors"
+    "strings"
+    "crypto/aes"
+    "crypto/rand"
+    "crypto/cipher"
+    "crypto/sha256"
+    "crypto/pbkdf2"
+    "encoding/hex"
+    "encoding/binary"
+)
+
+/*
+ Encrypted recordings start with this sequence, followed by a random salt. After that the file consists of chunks that
+ were sealed using AES-256-GCM. Every chunk is stored as the length of the ciphertext (4 bytes, big endian), the nonce
+ and the ciphertext. The index of the chunk and whether it is the last one are used as additional data, so chunks
+ can't be reordered or dropped without being noticed: a file has to end with its final chunk, which may be empty.
+ */
+const encryptionMagic = "PLOTENC1"
+
+const saltSize = 16
+
+/*
+ The name of the environment variable that holds the passphrase for encrypted recordings. A passphrase is never
+ accepted as a command line argument, since those are visible to every user of the system.
+ */
+const passphraseVariable = "PLOT_PASSPHRASE"
+
+/*
+ The key material for encrypting and decrypting recordings. Either a key that was loaded from a key file, or a
+ passphrase that the actual key is derived from, using the salt that is stored in every file.
+ */
+type Secret struct {
+    Key []byte
+    Passphrase string
+}
+
+/*
+ Loads the secret that was configured by the user. A key file is a file with 32 random bytes, either raw or as hex:
+    $ head -c 32 /dev/urandom > plot.key
+ Returns nil if neither a key file nor a passphrase was configured.
+ */
+func LoadSecret() (*Secret, error) {
+    if Settings.KeyFile != "" {
+        data, err := os.ReadFile(Settings.KeyFile)
+        if err != nil {
+            return nil, err
+        }
+        if decoded, err := hex.DecodeString(strings.TrimSpace(string(data))); err == nil {
+            data = decoded
+        }
+        if len(data) != 32 {
+            return nil, fmt.Errorf("the key file %s must contain 32 bytes", Settings.KeyFile)
+        }
+        return &Secret{Key: data}, nil
+    }
+    if passphrase := os.Getenv(passphraseVariable); passphrase != "" {
+        return &Secret{Passphrase: passphrase}, nil
+    }
+    return nil, nil
+}
+
+/*
+ Creates the AES-GCM cipher for a file with the given salt
+ */
+func (s *Secret) cipher(salt []byte) (cipher.AEAD, error) {
+    key := s.Key
+    if key == nil {
+        derived, err := pbkdf2.Key(sha256.New, s.Passphrase, salt, 600000, 32)
+        if err != nil {
+            return nil, err
+        }
+        key = derived
+    }
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, err
+    }
+    return cipher.NewGCM(block)
+}
+
+/*
+ Encrypts everything that is written to it. The data is collected until Flush is called, and then sealed as one chunk.
+ Data that wasn't flushed yet is lost if the application crashes, so callers should flush in regular intervals. The
+ file is only complete once Finish or Close sealed its final chunk.
+ */
+type EncryptedWriter struct {
+    out io.WriteCloser
+    aead cipher.AEAD
+    buffer []byte
+    chunk uint64
+    finished bool
+}
+
+/*
+ Creates a new writer and writes the header of the encrypted file
+ */
+func NewEncryptedWriter(out io.WriteCloser, secret *Secret) (*EncryptedWriter, error) {
+    salt := make([]byte, saltSize)
+    _, err := rand.Read(salt)
+    if err != nil {
+        return nil, err
+    }
+    aead, err := secret.cipher(salt)
+    if err != nil {
+        return nil, err
+    }
+    _, err = out.Write(append([]byte(encryptionMagic), salt...))
+    if err != nil {
+        return nil, err
+    }
+    return &EncryptedWriter{out: out, aead: aead}, nil
+}
+
+func (w *EncryptedWriter) Write(p []byte) (int, error) {
+    w.buffer = append(w.buffer, p...)
+    return len(p), nil
+}
+
+/*
+ Seals all data that was written since the last call and writes it to the file
+ */
+func (w *EncryptedWriter) Flush() error {
+    if len(w.buffer) == 0 {
+        return nil
+    }
+    return w.seal(false)
+}
+
+/*
+ Seals the remaining data as the final chunk, even if there is none. Nothing can be written afterwards.
+ */
+func (w *EncryptedWriter) Finish() error {
+    if w.finished {
+        return nil
+    }
+    w.finished = true
+    return w.seal(true)
+}
+
+func (w *EncryptedWriter) seal(final bool) error {
+    chunk, err := sealChunk(w.aead, w.buffer, w.chunk, final)
+    if err != nil {
+        return err
+    }
+    _, err = w.out.Write(chunk)
+    w.buffer = w.buffer[:0]
+    w.chunk++
+    return err
+}
+
+func (w *EncryptedWriter) Close() error {
+    err := w.Finish()
+    if err != nil {
+        w.out.Close()
+        return err
+    }
+    return w.out.Close()
+}
+
+/*
+ Seals data as the chunk with the given index, and returns it the way it is stored in the file
+ */
+func sealChunk(aead cipher.AEAD, data []byte, index uint64, final bool) ([]byte, error) {
+    nonce := make([]byte, aead.NonceSize())
+    _, err := rand.Read(nonce)
+    if err != nil {
+        return nil, err
+    }
+    sealed := aead.Seal(nil, nonce, data, chunkData(index, final))
+    chunk := binary.BigEndian.AppendUint32(nil, uint32(len(sealed)))
+    chunk = append(chunk, nonce...)
+    return append(chunk, sealed...), nil
+}
+
+/*
+ Decrypts an encrypted file while it is read
+ */
+type decryptedReader struct {
+    in io.Reader
+    aead cipher.AEAD
+    plain []byte
+    chunk uint64
+    final bool
+}
+
+func (r *decryptedReader) Read(p []byte) (int, error) {
+    for len(r.plain) == 0 {
+        // Nothing may follow the final chunk, and the file may not end before it
+        if r.final {
+            n, _ := io.ReadFull(r.in, make([]byte, 1))
+            if n > 0 {
+                return 0, errors.New("the encrypted file continues after its final chunk")
+            }
+            return 0, io.EOF
+        }
+        header := make([]byte, 4 + r.aead.NonceSize())
+        _, err := io.ReadFull(r.in, header)
+        if err == io.EOF || err == io.ErrUnexpectedEOF {
+            return 0, errors.New("the encrypted file is truncated, its final chunk is missing")
+        }
+        if err != nil {
+            return 0, err
+        }
+        sealed := make([]byte, binary.BigEndian.Uint32(header))
+        _, err = io.ReadFull(r.in, sealed)
+        if err == io.EOF || err == io.ErrUnexpectedEOF {
+            return 0, errors.New("the encrypted file is truncated, its final chunk is missing")
+        }
+        if err != nil {
+            return 0, err
+        }
+        r.plain, err = r.aead.Open(nil, header[4:], sealed, chunkData(r.chunk, false))
+        if err != nil {
+            r.plain, err = r.aead.Open(nil, header[4:], sealed, chunkData(r.chunk, true))
+            r.final = err == nil
+        }
+        if err != nil {
+            return 0, fmt.Errorf("chunk %d of the encrypted file is damaged, or the key is wrong", r.chunk)
+        }
+        r.chunk++
+    }
+    n := copy(p, r.plain)
+    r.plain = r.plain[n:]
+    return n, nil
+}
+
+/*
+ The additional data that binds a ciphertext to its position in the file, and marks the final chunk
+ */
+func chunkData(chunk uint64, final bool) []byte {
+    data := binary.BigEndian.AppendUint64(nil, chunk)
+    if final {
+        return append(data, 1)
+    }
+    return append(data, 0)
+}
+
+/*
+ Wraps the two parts of an opened recording, so closing it closes the underlying file
+ */
+type recordingReader struct {
+    io.Reader
+    io.Closer
+}
+
+/*
+ Opens a recording for reading. Encrypted recordings are detected automatically, and decrypted using the configured
+ key file or passphrase.
+ */
+func OpenRecording(file string) (io.ReadCloser, error) {
+    return openRecordingAt(file, 0, 0, 0)
+}
+
+/*
+ Opens a recording at the start of a block: offset is where it starts in the unencrypted recording, stored where it
+ starts in the file and chunk its number. Encrypted recordings can only be opened at the start of a chunk.
+ */
+func openRecordingAt(file string, offset int64, stored int64, chunk uint64) (io.ReadCloser, error) {
+    f, err := os.Open(file)
+    if err != nil {
+        return nil, err
+    }
+    in := bufio.NewReader(f)
+    magic, _ := in.Peek(len(encryptionMagic))
+    if string(magic) != encryptionMagic {
+        if offset > 0 {
+            _, err = f.Seek(offset, io.SeekStart)
+            if err != nil {
+                f.Close()
+                return nil, err
+            }
+            in.Reset(f)
+        }
+        return recordingReader{in, f}, nil
+    }
+
+    // The file is encrypted
+    header := make([]byte, len(encryptionMagic) + saltSize)
+    _, err = io.ReadFull(in, header)
+    if err != nil {
+        f.Close()
+        return nil, err
+    }
+    secret, err := LoadSecret()
+    if err == nil && secret == nil {
+        err = fmt.Errorf("%s is encrypted, but neither --key nor %s was given", file, passphraseVariable)
+    }
+    if err != nil {
+        f.Close()
+        return nil, err
+    }
+    aead, err := secret.cipher(header[len(encryptionMagic):])
+    if err != nil {
+        f.Close()
+        return nil, err
+    }
+    if chunk > 0 {
+        _, err = f.Seek(stored, io.SeekStart)
+        if err != nil {
+            f.Close()
+            return nil, err
+        }
+        in.Reset(f)
+    }
+    return recordingReader{&decryptedReader{in: in, aead: aead, chunk: chunk}, f}, nil
+}
+
+/*
+ The sizes of the nonce and of the authentication tag of AES-GCM, which every chunk of an encrypted file stores
+ */
+const (
+    gcmNonceSize = 12
+    gcmTagSize = 16
+)
+
+/*
+ Returns where every chunk of an encrypted file starts in the file, and how long it is once it is decrypted. This
+ reads only the headers of the chunks, so it doesn't need the key.
+ */
+func encryptedChunks(file string) ([]int64, []int64, error) {
+    f, err := os.Open(file)
+    if err != nil {
+        return nil, nil, err
+    }
+    defer f.Close()
+    position := int64(len(encryptionMagic) + saltSize)
+    header := make([]byte, 4 + gcmNonceSize)
+    starts, lengths := []int64{}, []int64{}
+    for true {
+        _, err = f.ReadAt(header, position)
+        if err == io.EOF {
+            return starts, lengths, nil
+        }
+        if err != nil {
+            return nil, nil, err
+        }
+        sealed := int64(binary.BigEndian.Uint32(header))
+        starts = append(starts, position)
+        lengths = append(lengths, sealed - gcmTagSize)
+        position += int64(len(header)) + sealed
+    }
+    return starts, lengths, nil
+}
+
+/*
+ Finishes an encrypted file that was never closed, like after a crash: a chunk that was only partially written is cut
+ off, and data is sealed as the final chunk. If the file already ends with its final chunk, that chunk is sealed again
+ with data appended. Returns where the final chunk starts in the file, and the chunk as it is stored.
+ */
+func finishEncrypted(file string, data []byte) (int64, []byte, error) {
+    starts, lengths, err := encryptedChunks(file)
+    if err != nil {
+        return 0, nil, err
+    }
+    f, err := os.OpenFile(file, os.O_RDWR, 0)
+    if err != nil {
+        return 0, nil, err
+    }
+    defer f.Close()
+    info, err := f.Stat()
+    if err != nil {
+        return 0, nil, err
+    }
+    header := make([]byte, len(encryptionMagic) + saltSize)
+    _, err = io.ReadFull(f, header)
+    if err == nil && string(header[:len(encryptionMagic)]) != encryptionMagic {
+        err = fmt.Errorf("%s is not encrypted", file)
+    }
+    if err != nil {
+        return 0, nil, err
+    }
+    secret, err := LoadSecret()
+    if err == nil && secret == nil {
+        err = fmt.Errorf("%s is encrypted, but neither --key nor %s was given", file, passphraseVariable)
+    }
+    if err != nil {
+        return 0, nil, err
+    }
+    aead, err := secret.cipher(header[len(encryptionMagic):])
+    if err != nil {
+        return 0, nil, err
+    }
+
+    // Keep the chunks that were completely written
+    end, chunks := int64(len(header)), 0
+    for i := range starts {
+        next := starts[i] + 4 + gcmNonceSize + lengths[i] + gcmTagSize
+        if next > info.Size() {
+            break
+        }
+        end, chunks = next, i + 1
+    }
+    if chunks > 0 {
+        last := make([]byte, end - starts[chunks - 1])
+        _, err = f.ReadAt(last, starts[chunks - 1])
+        if err != nil {
+            return 0, nil, err
+        }
+        plain, err := aead.Open(nil, last[4:4 + gcmNonceSize], last[4 + gcmNonceSize:], chunkData(uint64(chunks - 1),
+            true))
+        if err == nil {
+            data = append(plain, data...)
+            end, chunks = starts[chunks - 1], chunks - 1
+        }
+    }
+    chunk, err := sealChunk(aead, data, uint64(chunks), true)
+    if err != nil {
+        return 0, nil, err
+    }
+    err = f.Truncate(end)
+    if err == nil {
+        _, err = f.WriteAt(chunk, end)
+    }
+    if err == nil {
+        err = f.Sync()
+    }
+    return end, chunk, err
+}
@@ -0,0 +1,316 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "math"
+    "sort"
+    "math/rand"
+)
+
+/*
+ The result of decomposing several channels into components. Every channel is a weighted sum of the components:
+    channel[i] = Mean[i] + sum over j of Mixing[i][j] * component[j]
+ */
+type Decomposition struct {
+    Components [][]float64
+    Mixing [][]float64
+    Mean []float64
+
+    /*
+     The share of the variance of the channels that every component explains, in percent. Only known for PCA.
+     */
+    Explained []float64
+}
+
+/*
+ The amount of iterations after which ICA gives up converging
+ */
+const icaIterations = 200
+
+/*
+ Decomposes the channels into uncorrelated components, ordered by their variance (principal component analysis)
+ */
+func PCA(channels [][]float64) Decomposition {
+    mean, centered := centerChannels(channels)
+    values, vectors := symmetricEigen(covariance(centered))
+    total := float64(0)
+    for _, v := range values {
+        total += math.Max(v, 0)
+    }
+    d := Decomposition{Mean: mean, Mixing: vectors}
+    for j := range values {
+        d.Components = append(d.Components, project(centered, vectors, j))
+        if total > 0 {
+            d.Explained = append(d.Explained, math.Max(values[j], 0) / total * 100)
+        }
+    }
+    return d
+}
+
+/*
+ Decomposes the channels into statistically independent components (FastICA with the tanh nonlinearity). This
+ separates the crosstalk of nearby muscles, which PCA only decorrelates. The order and the sign of the components are
+ arbitrary.
+ */
+func ICA(channels [][]float64) (Decomposition, error) {
+    mean, centered := centerChannels(channels)
+    m := len(centered)
+    n := len(centered[0])
+
+    // Whiten the channels: z = D^-1/2 E^T x
+    values, vectors := symmetricEigen(covariance(centered))
+    whitened := make([][]float64, m)
+    scale := make([]float64, m)
+    for j := range values {
+        scale[j] = math.Sqrt(math.Max(values[j], 1e-18))
+        whitened[j] = project(centered, vectors, j)
+        for t := range whitened[j] {
+            whitened[j][t] /= scale[j]
+        }
+    }
+
+    // Find an orthogonal unmixing matrix for the whitened channels
+    random := rand.New(rand.NewSource(1))
+    w := make([][]float64, m)
+    for i := range w {
+        w[i] = make([]float64, m)
+        for j := range w[i] {
+            w[i][j] = random.NormFloat64()
+        }
+    }
+    w = decorrelate(w)
+    converged := false
+    for iteration := 0; iteration < icaIterations && !converged; iteration++ {
+        next := make([][]float64, m)
+        for i := range w {
+            next[i] = make([]float64, m)
+            derivative := float64(0)
+            for t := 0; t < n; t++ {
+                y := float64(0)
+                for j := range w[i] {
+                    y += w[i][j] * whitened[j][t]
+                }
+                g := math.Tanh(y)
+                derivative += 1 - g * g
+                for j := range next[i] {
+                    next[i][j] += g * whitened[j][t]
+                }
+            }
+            for j := range next[i] {
+                next[i][j] = (next[i][j] - derivative * w[i][j]) / float64(n)
+            }
+        }
+        next = decorrelate(next)
+
+        // The rows converged when they point into the same directions as before
+        converged = true
+        for i := range w {
+            dot := float64(0)
+            for j := range w[i] {
+                dot += w[i][j] * next[i][j]
+            }
+            if math.Abs(math.Abs(dot) - 1) > 1e-6 {
+                converged = false
+            }
+        }
+        w = next
+    }
+    if !converged {
+        return Decomposition{}, fmt.Errorf("ICA did not converge after %d iterations", icaIterations)
+    }
+
+    // The components are W z, and since W is orthogonal, the channels are E D^1/2 W^T s
+    d := Decomposition{Mean: mean, Mixing: make([][]float64, m)}
+    for i := range w {
+        d.Components = append(d.Components, project(whitened, transpose(w), i))
+    }
+    for i := range d.Mixing {
+        d.Mixing[i] = make([]float64, m)
+        for j := range d.Mixing[i] {
+            for k := range vectors {
+                d.Mixing[i][j] += vectors[i][k] * scale[k] * w[j][k]
+            }
+        }
+    }
+    return d, nil
+}
+
+/*
+ Puts the channels back together from the components, leaving out the ones that are removed
+ */
+func (d Decomposition) Reconstruct(removed map[int]bool) [][]float64 {
+    channels := make([][]float64, len(d.Mixing))
+    for i := range channels {
+        channels[i] = make([]float64, len(d.Components[0]))
+        for t := range channels[i] {
+            channels[i][t] = d.Mean[i]
+            for j, component := range d.Components {
+                if !removed[j] {
+                    channels[i][t] += d.Mixing[i][j] * component[t]
+                }
+            }
+        }
+    }
+    return channels
+}
+
+func centerChannels(channels [][]float64) ([]float64, [][]float64) {
+    mean := make([]float64, len(channels))
+    centered := make([][]float64, len(channels))
+    for i, channel := range channels {
+        mean[i] = Calculate(channel).Mean
+        centered[i] = make([]float64, len(channel))
+        for t, v := range channel {
+            centered[i][t] = v - mean[i]
+        }
+    }
+    return mean, centered
+}
+
+func covariance(centered [][]float64) [][]float64 {
+    m := len(centered)
+    result := make([][]float64, m)
+    for i := range result {
+        result[i] = make([]float64, m)
+        for j := 0; j <= i; j++ {
+            sum := float64(0)
+            for t := range centered[i] {
+                sum += centered[i][t] * centered[j][t]
+            }
+            result[i][j] = sum / float64(len(centered[i]))
+            result[j][i] = result[i][j]
+        }
+    }
+    return result
+}
+
+/*
+ Returns the channels projected onto one column of a matrix
+ */
+func project(channels [][]float64, vectors [][]float64, column int) []float64 {
+    result := make([]float64, len(channels[0]))
+    for i, channel := range channels {
+        for t, v := range channel {
+            result[t] += vectors[i][column] * v
+        }
+    }
+    return result
+}
+
+func transpose(a [][]float64) [][]float64 {
+    result := make([][]float64, len(a[0]))
+    for i := range result {
+        result[i] = make([]float64, len(a))
+        for j := range a {
+            result[i][j] = a[j][i]
+        }
+    }
+    return result
+}
+
+/*
+ Makes the rows of a matrix orthonormal without preferring any of them: W = (W W^T)^-1/2 W
+ */
+func decorrelate(w [][]float64) [][]float64 {
+    m := len(w)
+    product := make([][]float64, m)
+    for i := range product {
+        product[i] = make([]float64, m)
+        for j := range product[i] {
+            for k := range w[i] {
+                product[i][j] += w[i][k] * w[j][k]
+            }
+        }
+    }
+    values, vectors := symmetricEigen(product)
+    result := make([][]float64, m)
+    for i := range result {
+        result[i] = make([]float64, len(w[0]))
+        for j := range result[i] {
+            for k := range values {
+                // (E D^-1/2 E^T W)[i][j]
+                factor := float64(0)
+                for l := range values {
+                    factor += vectors[i][l] / math.Sqrt(math.Max(values[l], 1e-18)) * vectors[k][l]
+                }
+                result[i][j] += factor * w[k][j]
+            }
+        }
+    }
+    return result
+}
+
+/*
+ Returns the eigenvalues of a symmetric matrix in descending order, and the eigenvectors as the columns of a matrix,
+ using the Jacobi method
+ */
+func symmetricEigen(matrix [][]float64) ([]float64, [][]float64) {
+    m := len(matrix)
+    a := make([][]float64, m)
+    v := make([][]float64, m)
+    for i := range a {
+        a[i] = append([]float64{}, matrix[i]...)
+        v[i] = make([]float64, m)
+        v[i][i] = 1
+    }
+    for sweep := 0; sweep < 100; sweep++ {
+        off := float64(0)
+        for i := range a {
+            for j := i + 1; j < m; j++ {
+                off += a[i][j] * a[i][j]
+            }
+        }
+        if off < 1e-30 {
+            break
+        }
+        for p := 0; p < m; p++ {
+            for q := p + 1; q < m; q++ {
+                if a[p][q] == 0 {
+                    continue
+                }
+                theta := (a[q][q] - a[p][p]) / (2 * a[p][q])
+                t := math.Copysign(1, theta) / (math.Abs(theta) + math.Sqrt(theta * theta + 1))
+                c := 1 / math.Sqrt(t * t + 1)
+                s := t * c
+                for k := 0; k < m; k++ {
+                    akp, akq := a[k][p], a[k][q]
+                    a[k][p], a[k][q] = c * akp - s * akq, s * akp + c * akq
+                }
+                for k := 0; k < m; k++ {
+                    apk, aqk := a[p][k], a[q][k]
+                    a[p][k], a[q][k] = c * apk - s * aqk, s * apk + c * aqk
+                }
+                for k := 0; k < m; k++ {
+                    vkp, vkq := v[k][p], v[k][q]
+                    v[k][p], v[k][q] = c * vkp - s * vkq, s * vkp + c * vkq
+                }
+            }
+        }
+    }
+
+    order := make([]int, m)
+    for i := range order {
+        order[i] = i
+    }
+    sort.Slice(order, func(i int, j int) bool {
+        return a[order[i]][order[i]] > a[order[j]][order[j]]
+    })
+    values := make([]float64, m)
+    vectors := make([][]float64, m)
+    for i := range vectors {
+        vectors[i] = make([]float64, m)
+    }
+    for j, k := range order {
+        values[j] = a[k][k]
+        for i := range vectors {
+            vectors[i][j] = v[i][k]
+        }
+    }
+    return values, vectors
+}
@@ -0,0 +1,223 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "fmt"
+    "math"
+    "time"
+    "strings"
+    "net/url"
+    "math/rand"
+    "sync/atomic"
+    "path/filepath"
+)
+
+func init() {
+    Commands["demo"] = demoCommand
+    Sources["demo"] = grabSyntheticEMG
+    SourceBackends["demo"] = Backend{
+        Usage: "demo://?rate=500",
+        Description: "A synthetic muscle signal, for demonstrations without hardware",
+    }
+}
+
+/*
+ How many columns the narration of the tour takes next to the chart
+ */
+const tourWidth = 38
+
+/*
+ A step of the tour: the narration that is shown from a time of the session on, and what it demonstrates
+ */
+type TourStep struct {
+    At float64
+    Text string
+    Action func(pipeline *Pipeline)
+}
+
+/*
+ A guided tour through the features of the program, which is narrated next to the chart while the steps carry out
+ what they explain on the running session
+ */
+type Tour struct {
+    Steps []TourStep
+    current int32
+}
+
+/*
+ Runs through the steps on the clock of the session
+ */
+func (t *Tour) Run(pipeline *Pipeline) {
+    for pipeline.Pushed() == 0 {
+        SystemClock.Sleep(100 * time.Millisecond)
+    }
+    start := SystemClock.Now()
+    for i, step := range t.Steps {
+        SystemClock.Sleep(Until(start.Add(time.Duration(step.At * float64(time.Second)))))
+        if pipeline.Closed() {
+            return
+        }
+        if step.Action != nil {
+            step.Action(pipeline)
+        }
+        atomic.StoreInt32(&t.current, int32(i))
+    }
+}
+
+/*
+ Returns the narration of the current step
+ */
+func (t *Tour) Narration() string {
+    return t.Steps[atomic.LoadInt32(&t.current)].Text
+}
+
+/*
+ The tour of plot demo. The session is recorded in triggered mode into the given file.
+ */
+func NewDemoTour(file string) *Tour {
+    filters := func(description string) func(pipeline *Pipeline) {
+        return func(pipeline *Pipeline) {
+            chain, err := ParseFilters(description, Settings.Interval)
+            if err != nil {
+                panic(err)
+            }
+            pipeline.SetFilters(chain)
+        }
+    }
+    control := func(command string) func(pipeline *Pipeline) {
+        return func(pipeline *Pipeline) {
+            pipeline.Control(command)
+        }
+    }
+    return &Tour{Steps: []TourStep{
+        {0, "Welcome to plot! This is a synthetic muscle signal, made like the one of a real sensor: bursts of " +
+            "activity while the muscle contracts, on top of a slow drift and mains hum at 50 Hz.", nil},
+        {12, "Mains hum is removed with a notch filter at 50 Hz (--filter=notch:50). The trace gets thinner " +
+            "between the contractions.", filters("notch:50")},
+        {24, "A high-pass filter at 20 Hz removes the drift and the artifacts of movements, so the signal is " +
+            "centered on zero (notch:50,highpass:20).", filters("notch:50,highpass:20")},
+        {36, "Rectifying folds the negative half of the signal up (rectify).",
+            filters("notch:50,highpass:20,rectify")},
+        {46, "The RMS over 100 ms is the envelope of the signal, the usual measure of muscle activity (rms:0.1).",
+            filters("notch:50,highpass:20,rectify,rms:0.1")},
+        {58, "Markers split the session into segments, like one per exercise. The m key sets one, and the iEMG " +
+            "below the chart starts over for the new segment.", control("marker")},
+        {70, "In triggered mode, only the time around a trigger is recorded (--pretrigger, --posttrigger). The t " +
+            "key fires it by hand, --trigger=volts when the signal gets strong. The trigger just fired.",
+            control("trigger")},
+        {82, "The f key freezes the display. The arrow keys move a cursor, and v selects a range to see its " +
+            "statistics. Press ? for all keys.", nil},
+        {96, fmt.Sprintf("That's the tour! The triggered recording is written into %s. Press Ctrl+C to end, or " +
+            "keep exploring.", file), nil},
+    }}
+}
+
+/*
+ Runs the synthetic muscle signal through the whole pipeline, with a guided tour that is narrated next to the chart.
+ This needs no hardware, for teaching and for trying the program out. All options of the display can be given.
+ */
+func demoCommand(args []string) {
+    os.Args = append([]string{os.Args[0]}, args...)
+    LoadSettings()
+    if Settings.Playback || Settings.Debug || Settings.Source != "" {
+        fail("Usage: plot demo [display options]")
+    }
+    file := filepath.Join(os.TempDir(), "plot-demo.csv")
+    Settings.Source = "demo://"
+    Settings.Interval = 0.002
+    Settings.Scale = 1500
+    Settings.File = file
+    Settings.PreTrigger = 2
+    Settings.PostTrigger = 3
+    Settings.RecordStages = "raw"
+    Settings.Tour = NewDemoTour(file)
+    RunDisplay()
+}
+
+/*
+ Generates a synthetic muscle signal: noise whose amplitude follows contractions of a few seconds, on top of the
+ offset of the sensor, a slow drift, mains hum and the noise of the electrodes. The rate is given in Hz:
+    demo://?rate=500
+ */
+func grabSyntheticEMG(pipeline *Pipeline, target *url.URL) error {
+    rate := 500.0
+    if value := target.Query().Get("rate"); value != "" {
+        _, err := fmt.Sscanf(value, "%g", &rate)
+        if err != nil || rate <= 0 {
+            return fmt.Errorf("invalid rate %q", value)
+        }
+    }
+    Settings.Interval = 1 / rate
+    startRecording(pipeline, "synthetic")
+
+    signal := NewSyntheticEMG(Settings.Interval, time.Now().UnixNano())
+    start := SystemClock.Now()
+    for x := 0; !pipeline.Closed(); x++ {
+        pipeline.Push(Sample{Index: x, Time: float64(x) * Settings.Interval, Value: signal.Next()})
+
+        // Follow the clock instead of sleeping for every sample, which would add up the delays of the scheduler
+        Pace(Until(start.Add(time.Duration(float64(x + 1) * Settings.Interval * float64(time.Second)))))
+    }
+    return nil
+}
+
+/*
+ The synthetic muscle signal of the demo source. Every contraction ramps up and down within its part of a cycle of 6
+ seconds, and is a bit stronger or weaker than the last one. The same seed always generates the same signal.
+ */
+type SyntheticEMG struct {
+    random *rand.Rand
+    interval float64
+    strength float64
+    x int
+}
+
+func NewSyntheticEMG(interval float64, seed int64) *SyntheticEMG {
+    return &SyntheticEMG{random: rand.New(rand.NewSource(seed)), interval: interval, strength: 1}
+}
+
+/*
+ Returns the voltage of the next sample
+ */
+func (s *SyntheticEMG) Next() float64 {
+    t := float64(s.x) * s.interval
+    s.x++
+    phase := math.Mod(t, 6)
+    if phase < s.interval {
+        s.strength = 0.6 + s.random.Float64() * 0.8
+    }
+    activity := 0.0
+    if phase >= 2 && phase < 5 {
+        activity = s.strength * math.Pow(math.Sin(math.Pi * (phase - 2) / 3), 2)
+    }
+    return 1.5 + 0.2 * math.Sin(2 * math.Pi * 0.1 * t) + 0.05 * math.Sin(2 * math.Pi * 50 * t) +
+        0.01 * s.random.NormFloat64() + 0.4 * activity * s.random.NormFloat64()
+}
+
+/*
+ Breaks text into lines of at most the given width, between words
+ */
+func wrapText(text string, width int) []string {
+    lines := []string{}
+    line := ""
+    for _, word := range strings.Fields(text) {
+        if line != "" && len([]rune(line)) + 1 + len([]rune(word)) > width {
+            lines = append(lines, line)
+            line = ""
+        }
+        if line != "" {
+            line += " "
+        }
+        line += word
+    }
+    if line != "" {
+        lines = append(lines, line)
+    }
+    return lines
+}
@@ -0,0 +1,95 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "time"
+    "sync/atomic"
+)
+
+/*
+ How often the free space is checked
+ */
+const diskCheckInterval = 5 * time.Second
+
+/*
+ In the downsampled recording mode, only every nth sample is recorded
+ */
+const lowSpaceDecimation = 10
+
+/*
+ Watches the free space of the filesystem that is recorded on, so the recording doesn't fill it up. Below WarnFree, the
+ display warns. Below MinFree, the session either ends (--low-space=stop), or switches to a downsampled recording
+ (--low-space=downsample) that only keeps every tenth sample, and ends once half of MinFree is left.
+ */
+type DiskMonitor struct {
+    path string
+    free uint64
+    checked int32
+}
+
+func NewDiskMonitor(path string) *DiskMonitor {
+    return &DiskMonitor{path: path}
+}
+
+/*
+ Returns the free space as of the last check
+ */
+func (d *DiskMonitor) Free() uint64 {
+    return atomic.LoadUint64(&d.free)
+}
+
+/*
+ Whether the free space is below the warning threshold
+ */
+func (d *DiskMonitor) Low() bool {
+    return atomic.LoadInt32(&d.checked) != 0 && d.Free() < megabytes(Settings.WarnFree)
+}
+
+/*
+ Checks the free space regularly until the session has ended
+ */
+func (d *DiskMonitor) Run(pipeline *Pipeline) {
+    for !pipeline.Closed() {
+        free, err := FreeSpace(d.path)
+        if err == nil {
+            atomic.StoreUint64(&d.free, free)
+            atomic.StoreInt32(&d.checked, 1)
+            d.check(pipeline, free)
+        }
+        time.Sleep(diskCheckInterval)
+    }
+}
+
+func (d *DiskMonitor) check(pipeline *Pipeline, free uint64) {
+    minimum := megabytes(Settings.MinFree)
+    if free >= minimum {
+        return
+    }
+    _, t := pipeline.LastSample()
+    if Settings.LowSpace == "downsample" && pipeline.Decimation() == 1 {
+        pipeline.SetDecimation(lowSpaceDecimation)
+        pipeline.Event(t, fmt.Sprintf("low disk space (%d MB free), recording every %dth sample", free >> 20,
+            lowSpaceDecimation))
+        return
+    }
+    if Settings.LowSpace == "downsample" && free >= minimum / 2 {
+        return
+    }
+
+    // End the session while the recording can still be closed properly
+    pipeline.Event(t, fmt.Sprintf("low disk space (%d MB free), recording stopped", free >> 20))
+    pipeline.Modes.Transition(ModeEnded)
+}
+
+/*
+ Converts megabytes to bytes
+ */
+func megabytes(n int) uint64 {
+    return uint64(n) << 20
+}
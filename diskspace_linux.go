@@ -0,0 +1,23 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "syscall"
+)
+
+/*
+ Returns how many bytes the filesystem has left for the recording
+ */
+func FreeSpace(path string) (uint64, error) {
+    stat := syscall.Statfs_t{}
+    err := syscall.Statfs(path, &stat)
+    if err != nil {
+        return 0, err
+    }
+    return stat.Bavail * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,20 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+//go:build !linux
+
+package main
+
+import (
+    "errors"
+)
+
+/*
+ The free space is only checked on Linux, elsewhere the recording isn't watched
+ */
+func FreeSpace(path string) (uint64, error) {
+    return 0, errors.New("the free space is only checked on Linux")
+}
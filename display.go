@@ -0,0 +1,549 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+//go:build !nogui
+
+package main
+
+import (
+    "github.com/buger/goterm"
+    "os"
+    "fmt"
+    "time"
+    "math"
+    "strings"
+    "syscall"
+    "os/signal"
+    "path/filepath"
+)
+
+/*
+ The size of the terminal, which is the default size of the charts
+ */
+func terminalWidth() int {
+    return goterm.Width()
+}
+
+func terminalHeight() int {
+    return goterm.Height()
+}
+
+/*
+ Formats text for the terminal: in bold, in one of the colors of the terminal (see chart.go), or in bold on a colored
+ background
+ */
+func bold(text string) string {
+    return goterm.Bold(text)
+}
+
+func colored(text string, color int) string {
+    return goterm.Color(text, color)
+}
+
+func highlighted(text string, color int, background int) string {
+    return goterm.Background(goterm.Color(goterm.Bold(text), color), background)
+}
+
+/*
+ Shows the session on the terminal until it ends. The chart follows the pipeline that the mode machine shows, and the
+ keys control the session.
+ */
+func ShowSession(pipeline *Pipeline, modes *ModeMachine, protocol *Protocol) {
+    goterm.Clear()
+
+    // Keep rendering from interfering with the acquisition
+    err := LowerPriority(Settings.RenderNice)
+    if err != nil {
+        panic(err)
+    }
+
+    // The range of the chart adapts to the signal unless it is fixed, which can be toggled with the a key
+    autoscale, err := NewAutoscale(Settings.Clip, Settings.Hold, Settings.Range)
+    if err != nil {
+        fail("%v", err)
+    }
+    cocontraction, err := ParseCoContraction(Settings.CoContraction, Settings.Aux)
+    if err != nil {
+        fail("%v", err)
+    }
+    input := ReadKeys()
+    status := &Status{}
+    defer RestoreTerminal()
+
+    // Interrupting the program ends the acquisition cleanly, so the recording is complete
+    interrupt := make(chan os.Signal, 1)
+    signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+
+    // Receive the data from the background thread. The chart is redrawn with every frame, independent of the rate
+    // of the samples, so an outage of the acquisition can be shown, and fast sources don't hammer the terminal.
+    keys := []float64{}
+    values := []float64{}
+    aux := [][]float64{}
+
+    // The output of the stages that the processing is compared with, if any (see --compare-filter)
+    compared := []float64{}
+    frame := time.NewTicker(time.Duration(float64(time.Second) / Settings.FPS))
+    displayed := pipeline
+    var acquired time.Time
+    var arrived time.Time
+
+    // A frozen display keeps showing the samples up to the moment it was frozen, while the new ones are collected in
+    // the background, and a cursor can be moved along the trace. The cursor can select a range from an anchor.
+    frozen := 0
+    cursor := 0
+    anchor := -1
+    notice := ""
+
+    // The help is shown instead of the chart, one page at a time. 0 means it is closed.
+    help := 0
+
+    // A playback shows an overview of the whole recording above the chart, once its index is loaded
+    var overview []IndexBlock
+    var loading chan []IndexBlock
+    if Settings.Overview && modes.Mode() == ModePlayback {
+        loading = LoadOverview(Settings.File)
+    }
+    for {
+        select {
+        case sample, ok := <-displayed.Display.Samples:
+            if !ok && displayed != pipeline {
+                // The playback has ended, return to the acquisition
+                modes.Back()
+                break
+            }
+            if !ok {
+                RestoreTerminal()
+                printSummary(pipeline, keys, values)
+                return
+            }
+
+            // A loop of the playback starts over at its beginning, and so does the display
+            if len(keys) > 0 && sample.Time < keys[len(keys) - 1] {
+                keys, values, aux, compared = []float64{}, []float64{}, [][]float64{}, []float64{}
+                frozen = 0
+                anchor = -1
+            }
+
+            // Append the new values to the general collection. If more samples are waiting, take them as well, so a
+            // slow terminal only has to draw the latest state.
+            keys = append(keys, sample.Time)
+            values = append(values, sample.Processed())
+            aux = append(aux, sample.Aux)
+            compared = append(compared, sample.Compared)
+            for waiting := len(displayed.Display.Samples); waiting > 0; waiting-- {
+                sample = <-displayed.Display.Samples
+                keys = append(keys, sample.Time)
+                values = append(values, sample.Processed())
+                aux = append(aux, sample.Aux)
+                compared = append(compared, sample.Compared)
+            }
+            acquired = sample.Acquired
+            arrived = time.Now()
+            continue
+        case <-interrupt:
+            modes.Transition(ModeEnded)
+            continue
+        case overview = <-loading:
+            loading = nil
+            goterm.Clear()
+            continue
+        case key := <-input:
+            // The actions of the keys are described in Bindings. While the help is open, ? turns its pages and any
+            // other key closes it.
+            notice = ""
+            action := KeyAction(key)
+            if help > 0 && action != "help" {
+                help = 0
+                action = ""
+                goterm.Clear()
+            }
+            switch action {
+            case "help":
+                help++
+                goterm.Clear()
+            case "freeze":
+                if frozen > 0 {
+                    frozen = 0
+                } else {
+                    frozen = len(keys)
+                    cursor = min(frozen, Settings.Scale) - 1
+                }
+                anchor = -1
+            case "select":
+                if frozen > 0 && anchor < 0 {
+                    anchor = cursor
+                } else {
+                    anchor = -1
+                }
+            case "export":
+                if frozen > 0 && anchor >= 0 {
+                    first := frozen - min(frozen, Settings.Scale) + min(anchor, cursor)
+                    last := frozen - min(frozen, Settings.Scale) + max(anchor, cursor)
+                    file, err := exportSelection(keys[first:last + 1], values[first:last + 1], aux[first:last + 1])
+                    notice = fmt.Sprintf("Exported %d samples to %s", last - first + 1, file)
+                    if err != nil {
+                        notice = fmt.Sprintf("The export failed: %v", err)
+                    }
+                }
+            case "cursor-back", "cursor-forward", "cursor-back-10", "cursor-forward-10":
+                steps := map[string]int{"cursor-back": -1, "cursor-forward": 1, "cursor-back-10": -10,
+                    "cursor-forward-10": 10}
+                cursor = max(0, min(min(frozen, Settings.Scale) - 1, cursor + steps[action]))
+            case "loop":
+                if modes.Mode() != ModePlayback || Settings.Reprocess || len(keys) == 0 {
+                    break
+                }
+                if frozen > 0 && anchor >= 0 {
+                    first := frozen - min(frozen, Settings.Scale) + min(anchor, cursor)
+                    last := frozen - min(frozen, Settings.Scale) + max(anchor, cursor)
+                    if keys[last] > keys[first] {
+                        displayed.Loop.Set(keys[first], keys[last])
+                    }
+                } else if frozen > 0 {
+                    displayed.Loop.Mark(keys[frozen - min(frozen, Settings.Scale) + cursor])
+                } else {
+                    displayed.Loop.Mark(keys[len(keys) - 1])
+                }
+            case "autoscale":
+                autoscale.Toggle()
+            case "smoothing":
+                Settings.Smoothing.Next()
+            case "smooth-more":
+                Settings.Smoothing.Resize(2)
+            case "smooth-less":
+                Settings.Smoothing.Resize(0.5)
+            case "pause":
+                if modes.Mode() == ModePaused {
+                    pipeline.Control("resume")
+                } else {
+                    pipeline.Control("pause")
+                }
+            case "playback":
+                if modes.Mode() == ModePlayback {
+                    pipeline.Control("back")
+                } else {
+                    pipeline.Control("playback")
+                }
+            case "trigger":
+                pipeline.Control("trigger")
+            case "marker":
+                pipeline.Control("marker")
+            }
+            if len(keys) == 0 && help == 0 {
+                continue
+            }
+        case <-frame.C:
+            if len(keys) == 0 && help == 0 {
+                continue
+            }
+        }
+
+        // The help replaces the chart until it is closed, or its last page was turned
+        if help > 0 {
+            pages := helpPages(modes, autoscale, Settings.Height - 2)
+            if help > len(pages) {
+                help = 0
+                goterm.Clear()
+                continue
+            }
+            goterm.MoveCursor(0, 0)
+            fmt.Println(goterm.Bold(fmt.Sprintf("Help (page %d of %d)", help, len(pages))))
+            fmt.Println(strings.Join(pages[help - 1], "\n"))
+            fmt.Print("Press ? for the next page, any other key to close the help")
+            goterm.Flush()
+            continue
+        }
+
+        // The display starts over when it switches between the acquisition and the playback
+        if next := modes.Shown(); next != displayed {
+            displayed = next
+            keys = []float64{}
+            values = []float64{}
+            aux = [][]float64{}
+            compared = []float64{}
+            frozen = 0
+            anchor = -1
+            overview, loading = nil, nil
+            if Settings.Overview && modes.Mode() == ModePlayback {
+                loading = LoadOverview(Settings.File)
+            }
+            goterm.Clear()
+            continue
+        }
+
+        // The samples only ever get appended, so the frozen display is the beginning of them
+        keys, values, aux, compared := keys, values, aux, compared
+        if frozen > 0 {
+            keys, values, aux, compared = keys[:frozen], values[:frozen], aux[:frozen], compared[:frozen]
+        }
+
+        // Choose the unit that fits the last x values best
+        i := min(len(keys), Settings.Scale)
+        shown := Settings.Smoothing.Last(values, i, Settings.Interval)
+        stats := Calculate(shown)
+        unit := Settings.DisplayUnit.For(stats.Peak())
+        low, high := 0.0, 0.0
+        var other []float64
+        if Settings.CompareFilter != "" {
+            // Both outputs share the left axis, so its range fits both of them
+            other = Settings.Smoothing.Last(compared, i, Settings.Interval)
+            low, high = autoscale.Range(append(append([]float64{}, shown...), other...))
+        } else {
+            low, high = autoscale.Range(shown)
+        }
+
+        // Prepare a chart for the last x values, leaving room for the lines below it. The muscle sensor is drawn on
+        // the left axis, the auxiliary sensors with their own units on the right one.
+        // The tour of plot demo is narrated next to the chart
+        width := Settings.Width
+        if Settings.Tour != nil {
+            width -= tourWidth + 2
+        }
+        height := Settings.Height - infoLines
+        if overview != nil {
+            height -= overviewLines
+        }
+        chart := NewChart(width, height)
+        chart.XLabel, chart.XFormat = TimeAxis(Settings.TimeAxis, displayed.Started())
+        chart.Keys = keys[len(keys)-i:]
+
+        // Until the next sample arrives, the chart scrolls on for at most the interval between two samples
+        if frozen > 0 {
+            chart.Cursor = cursor
+            chart.Anchor = anchor
+        } else if !displayed.Stalled() {
+            chart.Shift = math.Min(time.Since(arrived).Seconds() * Settings.Speed, Settings.Interval)
+        }
+        chart.Axes[LeftAxis].Label = Settings.YScale.Column("Voltage", unit)
+        chart.Axes[LeftAxis].Min = Settings.YScale.Apply(low, unit)
+        chart.Axes[LeftAxis].Max = Settings.YScale.Apply(high, unit)
+
+        // Add the last x values from the value arrays, clipped to the range of the chart
+        voltages := make([]float64, i)
+        for j := range voltages {
+            voltages[j] = Settings.YScale.Apply(math.Max(low, math.Min(high, shown[j])), unit)
+        }
+        chart.AddSeries(LeftAxis, voltages)
+        if other != nil {
+            series := make([]float64, i)
+            for j := range series {
+                series[j] = Settings.YScale.Apply(math.Max(low, math.Min(high, other[j])), unit)
+            }
+            chart.AddSeries(LeftAxis, series)
+        }
+        plotted, _ := Settings.Aux.Plotted(rightAxis())
+        labels := []string{}
+        for _, channel := range plotted {
+            series := make([]float64, i)
+            for j := range series {
+                series[j] = auxValue(aux[len(aux)-i+j], channel)
+            }
+            chart.AddSeries(RightAxis, series)
+            labels = append(labels, Settings.Aux[channel].Column())
+        }
+        if Settings.Derivative {
+            slope := Derivative(chart.Keys, shown)
+            for j := range slope {
+                slope[j] *= unit.Scale
+            }
+            chart.AddSeries(RightAxis, slope)
+            labels = append(labels, fmt.Sprintf("Slope [%s/s]", unit.Name))
+        }
+        chart.Axes[RightAxis].Label = strings.Join(labels, ", ")
+
+        // Move the cursor to the beginning so we clear the console
+        goterm.MoveCursor(0, 0)
+
+        // Draw the chart, below the overview of the recording
+        drawn := chart.Draw()
+        if overview != nil {
+            drawn = DrawOverview(overview, width, chart.XFormat, chart.Keys[0], chart.Keys[len(chart.Keys) - 1]) +
+                "\n" + drawn
+        }
+        if Settings.Tour != nil {
+            lines := strings.Split(drawn, "\n")
+            narration := append([]string{bold("plot demo"), ""}, wrapText(Settings.Tour.Narration(), tourWidth)...)
+            for j := range lines {
+                text := ""
+                if j < len(narration) {
+                    text = narration[j]
+                }
+                // Clear the rest of the line, which may hold a longer line of the last step
+                lines[j] += "  " + text + "\033[K"
+            }
+            drawn = strings.Join(lines, "\n")
+        }
+        fmt.Println(drawn)
+        info := stats.Format(unit)
+        if displayed.ECG != nil {
+            info = fmt.Sprintf("Heart rate %.0f bpm   %s", displayed.ECG.HeartRate(), info)
+        }
+        if protocol != nil {
+            info = goterm.Bold(strings.ToUpper(protocol.Current())) + "   " + info
+        }
+        if Settings.Derivative {
+            info += fmt.Sprintf("   Peak slope %s/s", unit.Format(PeakSlope(chart.Keys, shown)))
+        }
+        if cocontraction != nil {
+            info += fmt.Sprintf("   CCI %.0f%%", cocontraction.Window(keys, values, aux, Settings.CoContractionWindow))
+        }
+        segment := displayed.Segments.Current()
+        info += fmt.Sprintf("   iEMG %s s (%s)", unit.Format(segment.IEMG), segment.Name)
+        if autoscale.Fixed {
+            info += "   Fixed range"
+        }
+        if Settings.Smoothing.Kind != "none" {
+            info += "   " + Settings.Smoothing.String()
+        }
+        if other != nil {
+            info += fmt.Sprintf("   %s RMS %s   %s RMS %s", colored(stagesName(Settings.Filter), colorRed),
+                unit.Format(stats.RMS), colored(stagesName(Settings.CompareFilter), colorGreen),
+                unit.Format(Calculate(other).RMS))
+        }
+        if loop := displayed.Loop.String(); loop != "" {
+            info += "   " + loop
+        }
+        for j, channel := range Settings.Aux {
+            info += fmt.Sprintf("   %s %.2f %s", channel.Name, auxValue(aux[len(aux)-1], j), channel.Unit)
+        }
+
+        // The frozen display reads out the values under the cursor instead
+        if frozen > 0 {
+            j := len(keys) - i + cursor
+            info = fmt.Sprintf("%s   %.3f s", goterm.Bold("FROZEN"), keys[j])
+            if Settings.TimeAxis != "seconds" {
+                info += fmt.Sprintf(" (%s)", chart.XFormat(keys[j]))
+            }
+            info += "   " + unit.Format(shown[cursor])
+            for k, channel := range Settings.Aux {
+                info += fmt.Sprintf("   %s %.2f %s", channel.Name, auxValue(aux[j], k), channel.Unit)
+            }
+
+            // The statistics of a selection are taken from the signal, without the smoothing of the display
+            if anchor >= 0 {
+                first := len(keys) - i + min(anchor, cursor)
+                last := len(keys) - i + max(anchor, cursor)
+                selected := Calculate(values[first:last + 1])
+                info += fmt.Sprintf("   Selection %.3f s   Mean %s   RMS %s   Peak %s   iEMG %s s",
+                    keys[last] - keys[first], unit.Format(selected.Mean), unit.Format(selected.RMS),
+                    unit.Format(selected.Peak()), unit.Format(IEMG(keys[first:last + 1], values[first:last + 1])))
+            }
+            if notice != "" {
+                info += "   " + notice
+            }
+        }
+        fmt.Println(goterm.RESET_LINE + info)
+        fmt.Println(goterm.RESET_LINE + status.Format(displayed))
+        if pipeline.Stalled() {
+            last, _ := pipeline.LastSample()
+            fmt.Println(goterm.Background(goterm.Color(goterm.Bold(fmt.Sprintf(" NO DATA FOR %.0fs - " +
+                "RECONNECTING TO THE SENSOR ", time.Since(last).Seconds())), goterm.WHITE), goterm.RED))
+        } else if err := pipeline.RecordingError(); err != nil {
+            fmt.Println(goterm.Background(goterm.Color(goterm.Bold(fmt.Sprintf(" RECORDING FAILED: %v ", err)),
+                goterm.WHITE), goterm.RED))
+        } else if reasons := pipeline.Telemetry.Current().Throttling(); len(reasons) > 0 {
+            fmt.Println(goterm.Background(goterm.Color(goterm.Bold(fmt.Sprintf(" THROTTLED (%s) - SAMPLING MAY " +
+                "BE AFFECTED ", strings.ToUpper(strings.Join(reasons, ", ")))), goterm.WHITE), goterm.RED))
+        } else if pipeline.Disk != nil && pipeline.Disk.Low() {
+            fmt.Println(goterm.Background(goterm.Color(goterm.Bold(fmt.Sprintf(" LOW DISK SPACE: %d MB FREE ",
+                pipeline.Disk.Free() >> 20)), goterm.BLACK), goterm.YELLOW))
+        } else {
+            fmt.Print(goterm.RESET_LINE)
+        }
+        goterm.Flush()
+
+        // The latency of the display is measured once the newest sample is on the screen
+        if displayed == pipeline {
+            pipeline.Latency.Observe(LatencyDisplay, acquired)
+        }
+        acquired = time.Time{}
+    }
+}
+
+/*
+ Returns how a set of processing stages is named in the display
+ */
+func stagesName(description string) string {
+    if description == "" {
+        return "raw"
+    }
+    return description
+}
+
+/*
+ Writes selected samples into a CSV file next to the recording, named after the range, like data-12.300-14.800.csv
+ */
+func exportSelection(keys []float64, values []float64, aux [][]float64) (string, error) {
+    base := "selection"
+    if Settings.File != "" {
+        base = strings.TrimSuffix(Settings.File, filepath.Ext(Settings.File))
+    }
+    file := fmt.Sprintf("%s-%.3f-%.3f.csv", base, keys[0], keys[len(keys) - 1])
+    columns := []string{"Time [s]", "Voltage [V]"}
+    for _, channel := range Settings.Aux {
+        columns = append(columns, channel.Column())
+    }
+    lines := []string{strings.Join(columns, ";")}
+    for j := range keys {
+        line := fmt.Sprintf("%f;%f", keys[j], values[j])
+        for k := range Settings.Aux {
+            line += fmt.Sprintf(";%f", auxValue(aux[j], k))
+        }
+        lines = append(lines, line)
+    }
+    return file, os.WriteFile(file, []byte(strings.Join(lines, "\n") + "\n"), 0644)
+}
+
+/*
+ Returns the pages of the help: the keys and the settings of the display, with as many lines per page as fit on it
+ */
+func helpPages(modes *ModeMachine, autoscale *Autoscale, height int) [][]string {
+    lines := []string{"Keys:"}
+    for _, binding := range Bindings {
+        lines = append(lines, fmt.Sprintf("  %-9s %s", KeyName(binding.Key), binding.Description))
+    }
+    source := Settings.Source
+    if Settings.Debug {
+        source = "generated samples (debug)"
+    } else if source == "" {
+        source = "ADCPi"
+    }
+    chartRange := "automatic"
+    if autoscale.Fixed {
+        chartRange = "fixed " + Settings.Range
+    }
+    settings := [][2]string{
+        {"Mode", string(modes.Mode())},
+        {"Source", source},
+        {"Recording", Settings.File},
+        {"Interval", fmt.Sprintf("%g s", Settings.Interval)},
+        {"Samples shown", fmt.Sprint(Settings.Scale)},
+        {"Frame rate", fmt.Sprintf("%g fps", Settings.FPS)},
+        {"Time axis", Settings.TimeAxis},
+        {"Unit", Settings.Unit},
+        {"Y scale", string(Settings.YScale)},
+        {"Range", chartRange},
+        {"Smoothing", Settings.Smoothing.String()},
+        {"Filters", Settings.Filter},
+        {"Auxiliary sensors", Settings.Aux.String()},
+        {"Served on", Settings.Serve},
+    }
+    lines = append(lines, "", "Settings:")
+    for _, setting := range settings {
+        if setting[1] != "" {
+            lines = append(lines, fmt.Sprintf("  %-18s %s", setting[0], setting[1]))
+        }
+    }
+
+    // Every page has at least one line, however small the terminal is
+    pages := [][]string{}
+    height = max(1, height)
+    for len(lines) > height {
+        pages = append(pages, lines[:height])
+        lines = lines[height:]
+    }
+    return append(pages, lines)
+}
@@ -0,0 +1,101 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+//go:build nogui
+
+package main
+
+import (
+    "os"
+    "fmt"
+    "time"
+    "syscall"
+    "os/signal"
+)
+
+func init() {
+    Omitted = append(Omitted, "nogui")
+}
+
+/*
+ How often the headless acquisition prints its status
+ */
+const headlessStatusInterval = time.Minute
+
+/*
+ Without a display, there is no terminal to fit into, and the output is written as plain text, like into a log
+ */
+func terminalWidth() int {
+    return 80
+}
+
+func terminalHeight() int {
+    return 24
+}
+
+func bold(text string) string {
+    return text
+}
+
+func colored(text string, color int) string {
+    return text
+}
+
+func highlighted(text string, color int, background int) string {
+    return text
+}
+
+/*
+ Runs the session without a display until it ends, printing its status every minute. The session is controlled with
+ --duration, the signals and the server (see Pipeline.Control). A playback that was started from the server is
+ followed until it ends.
+ */
+func ShowSession(pipeline *Pipeline, modes *ModeMachine, protocol *Protocol) {
+    interrupt := make(chan os.Signal, 1)
+    signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+    status := &Status{}
+    keys := []float64{}
+    values := []float64{}
+    refresh := time.NewTicker(headlessStatusInterval)
+    narrated := ""
+    for true {
+        displayed := modes.Shown()
+        select {
+        case sample, ok := <-displayed.Display.Samples:
+            if !ok && displayed != pipeline {
+                modes.Back()
+                continue
+            }
+            if !ok {
+                printSummary(pipeline, keys, values)
+                return
+            }
+            if displayed != pipeline {
+                continue
+            }
+            keys = append(keys, sample.Time)
+            values = append(values, sample.Processed())
+
+            // The tour of plot demo is narrated as it goes on
+            if Settings.Tour != nil && Settings.Tour.Narration() != narrated {
+                narrated = Settings.Tour.Narration()
+                fmt.Println(narrated)
+            }
+        case <-interrupt:
+            modes.Transition(ModeEnded)
+        case <-refresh.C:
+            fmt.Println(status.Format(pipeline))
+        }
+    }
+}
+
+/*
+ Annotating needs the interactive view of the display
+ */
+func annotateRecording(file string, creator string, times []float64, values []float64,
+    annotations []Annotation) error {
+    return fmt.Errorf("this build has no display (nogui)")
+}
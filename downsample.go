@@ -0,0 +1,301 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "math"
+)
+
+/*
+ The ways of reducing a series to fewer points for drawing it. They return the rows that are kept, in order, so every
+ column of a table can be reduced like the one that was looked at. Keeping every nth sample isn't one of them, since it
+ misses the short bursts that matter in EMG.
+    lttb    Largest-Triangle-Three-Buckets: a point per bucket, the one that makes the largest triangle with the point
+            before and the average of the bucket after it. The line looks like the whole series, with half the points
+            of minmax.
+    minmax  The minimum and the maximum of every bucket, so every peak is kept exactly
+ */
+var Downsamplers = map[string]func(keys []float64, values []float64, points int) []int{
+    "lttb": LTTB,
+    "minmax": MinMax,
+}
+
+/*
+ Selects at most the given number of points with Largest-Triangle-Three-Buckets (Steinarsson 2013). The first and the
+ last point are always kept.
+ */
+func LTTB(keys []float64, values []float64, points int) []int {
+    n := len(values)
+    if points >= n || points <= 0 {
+        return allRows(n)
+    }
+    if points < 3 {
+        return []int{0, n - 1}[:points]
+    }
+
+    // The points between the first and the last one are split into buckets, one point is kept from each
+    every := float64(n - 2) / float64(points - 2)
+    rows := make([]int, 0, points)
+    rows = append(rows, 0)
+    previous := 0
+    for i := 0; i < points - 2; i++ {
+        // The average of the next bucket, or the last point for the last bucket
+        start := int(float64(i + 1) * every) + 1
+        end := min(int(float64(i + 2) * every) + 1, n)
+        if start >= n - 1 {
+            start, end = n - 1, n
+        }
+        avgX, avgY := 0.0, 0.0
+        for j := start; j < end; j++ {
+            avgX += keys[j]
+            avgY += values[j]
+        }
+        avgX /= float64(end - start)
+        avgY /= float64(end - start)
+
+        // The point of this bucket with the largest triangle
+        first, last := int(float64(i) * every) + 1, int(float64(i + 1) * every) + 1
+        best, area := first, -1.0
+        x, y := keys[previous], values[previous]
+        for j := first; j < last; j++ {
+            current := math.Abs((x - avgX) * (values[j] - y) - (x - keys[j]) * (avgY - y))
+            if current > area {
+                best, area = j, current
+            }
+        }
+        rows = append(rows, best)
+        previous = best
+    }
+    return append(rows, n - 1)
+}
+
+/*
+ Selects the minimum and the maximum of half as many buckets as there are points, in the order they appear
+ */
+func MinMax(keys []float64, values []float64, points int) []int {
+    n := len(values)
+    if points >= n || points <= 0 {
+        return allRows(n)
+    }
+    buckets := max(1, points / 2)
+    rows := make([]int, 0, buckets * 2)
+    for i := 0; i < buckets; i++ {
+        first, last := i * n / buckets, (i + 1) * n / buckets
+        low, high := first, first
+        for j := first; j < last; j++ {
+            if values[j] < values[low] {
+                low = j
+            }
+            if values[j] > values[high] {
+                high = j
+            }
+        }
+        rows = append(rows, min(low, high))
+        if low != high {
+            rows = append(rows, max(low, high))
+        }
+    }
+    return rows
+}
+
+func allRows(n int) []int {
+    rows := make([]int, n)
+    for i := range rows {
+        rows[i] = i
+    }
+    return rows
+}
+
+/*
+ How many slices every bucket of a StreamDownsampler is split into
+ */
+const downsampleSlices = 32
+
+/*
+ Downsamples rows while they are read, so a range of any length only takes the memory of the rows that are kept. The
+ range is split into buckets of equal time, as many as Downsamplers would use for the points, and every bucket into
+ slices that keep their lowest and their highest row. minmax keeps the lowest and the highest of them for every
+ bucket. lttb keeps the first and the last row, and for every bucket the one with the largest triangle, once the
+ average of the next bucket is known. A bucket that has fewer rows than slices chooses among all of them, a longer
+ one among the peaks of its slices. Without points, every row is kept.
+ */
+type StreamDownsampler struct {
+    method string
+    start float64
+    width float64
+    buckets int
+    rows [][]float64
+
+    // The bucket that rows are added to, and in lttb the one that waits for its average
+    bucket int
+    current *downsampleBucket
+    waiting *downsampleBucket
+    first []float64
+    last []float64
+}
+
+/*
+ The rows of a bucket that can be kept, the peaks of its slices, and the average of all of its rows
+ */
+type downsampleBucket struct {
+    low [][]float64
+    high [][]float64
+    sumX float64
+    sumY float64
+    count int
+}
+
+/*
+ Prepares to downsample the rows from one time to another to at most the given number of points with one of
+ Downsamplers
+ */
+func NewStreamDownsampler(method string, from float64, to float64, points int) *StreamDownsampler {
+    d := &StreamDownsampler{method: method, start: from, bucket: -1}
+    switch {
+    case points <= 0:
+        return d
+    case method == "minmax":
+        d.buckets = max(1, points / 2)
+    default:
+        d.buckets = max(0, points - 2)
+    }
+    d.width = (to - from) / float64(max(1, d.buckets))
+    if d.width <= 0 {
+        d.width = math.SmallestNonzeroFloat64
+    }
+    return d
+}
+
+/*
+ Returns how long every bucket is, in seconds, or zero if every row is kept
+ */
+func (d *StreamDownsampler) Bucket() float64 {
+    return d.width
+}
+
+/*
+ Takes the next row, which starts with the time and continues with the signal. The row is copied if it is kept.
+ */
+func (d *StreamDownsampler) Add(row []float64) {
+    if len(row) < 2 {
+        return
+    }
+    if d.width == 0 {
+        d.rows = append(d.rows, append([]float64{}, row...))
+        return
+    }
+
+    // lttb keeps the first row as it is, and the last row once it knows which one it is
+    if d.method != "minmax" {
+        if d.first == nil {
+            d.first = append([]float64{}, row...)
+            d.rows = append(d.rows, d.first)
+            return
+        }
+        d.last = append(d.last[:0], row...)
+        if d.buckets == 0 {
+            return
+        }
+    }
+    position := (row[0] - d.start) / d.width
+    bucket := min(d.buckets - 1, max(0, int(position)))
+    if bucket != d.bucket {
+        d.finish()
+        d.bucket = bucket
+        d.current = &downsampleBucket{low: make([][]float64, downsampleSlices),
+            high: make([][]float64, downsampleSlices)}
+    }
+    slice := min(downsampleSlices - 1, max(0, int((position - float64(bucket)) * downsampleSlices)))
+    b := d.current
+    var kept []float64
+    if b.low[slice] == nil || row[1] < b.low[slice][1] {
+        kept = append([]float64{}, row...)
+        b.low[slice] = kept
+    }
+    if b.high[slice] == nil || row[1] > b.high[slice][1] {
+        if kept == nil {
+            kept = append([]float64{}, row...)
+        }
+        b.high[slice] = kept
+    }
+    b.sumX += row[0]
+    b.sumY += row[1]
+    b.count++
+}
+
+/*
+ Returns the rows that are kept, in the order they were added
+ */
+func (d *StreamDownsampler) Rows() [][]float64 {
+    d.finish()
+    if d.waiting != nil && d.last != nil {
+        d.choose(d.waiting, d.last[0], d.last[1])
+        d.waiting = nil
+    }
+    if kept := d.rows[len(d.rows) - 1]; d.last != nil && (kept[0] != d.last[0] || kept[1] != d.last[1]) {
+        d.rows = append(d.rows, d.last)
+    }
+    d.last = nil
+    return d.rows
+}
+
+/*
+ Ends the current bucket. minmax keeps its peaks right away, lttb keeps a row of the bucket before, now that the
+ average of this one is known.
+ */
+func (d *StreamDownsampler) finish() {
+    b := d.current
+    if b == nil {
+        return
+    }
+    d.current = nil
+    if d.method == "minmax" {
+        var low, high []float64
+        for i := range b.low {
+            if b.low[i] != nil && (low == nil || b.low[i][1] < low[1]) {
+                low = b.low[i]
+            }
+            if b.high[i] != nil && (high == nil || b.high[i][1] > high[1]) {
+                high = b.high[i]
+            }
+        }
+        if high[0] < low[0] {
+            low, high = high, low
+        }
+        d.rows = append(d.rows, low)
+        if &high[0] != &low[0] {
+            d.rows = append(d.rows, high)
+        }
+        return
+    }
+    if d.waiting != nil {
+        d.choose(d.waiting, b.sumX / float64(b.count), b.sumY / float64(b.count))
+    }
+    d.waiting = b
+}
+
+/*
+ Keeps the row of a bucket that makes the largest triangle with the last row that was kept and the given average
+ */
+func (d *StreamDownsampler) choose(b *downsampleBucket, avgX float64, avgY float64) {
+    previous := d.rows[len(d.rows) - 1]
+    x, y := previous[0], previous[1]
+    var best []float64
+    area := -1.0
+    for i := range b.low {
+        for _, row := range [][]float64{b.low[i], b.high[i]} {
+            if row == nil {
+                continue
+            }
+            current := math.Abs((x - avgX) * (row[1] - y) - (x - row[0]) * (avgY - y))
+            if current > area {
+                best, area = row, current
+            }
+        }
+    }
+    d.rows = append(d.rows, best)
+}
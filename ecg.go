@@ -0,0 +1,145 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "math"
+    "sync/atomic"
+)
+
+/*
+ A heartbeat that was detected in an ECG
+ */
+type Beat struct {
+
+    /*
+     The time of the R peak, in seconds since the start of the acquisition
+     */
+    Time float64
+
+    /*
+     The amount of seconds since the previous beat, or zero for the first beat
+     */
+    RR float64
+}
+
+/*
+ Returns the instantaneous heart rate in beats per minute
+ */
+func (b Beat) HeartRate() float64 {
+    if b.RR == 0 {
+        return 0
+    }
+    return 60 / b.RR
+}
+
+/*
+ Detects QRS complexes in an ECG, following the algorithm of Pan and Tompkins: the signal is band-pass filtered to the
+ frequencies of the QRS complex, differentiated, squared and integrated over a moving window. Peaks of the integrated
+ signal are classified as QRS complexes or noise using adaptive thresholds, and a refractory period suppresses double
+ detections. The thresholds are initialised from the first two seconds of the signal.
+ */
+type QRSDetector struct {
+    interval float64
+    highpass *Biquad
+    lowpass *Biquad
+    integration []float64
+    position int
+    sum float64
+    previous float64
+
+    // The adaptive levels of signal and noise peaks, which are learned during the first two seconds
+    signal float64
+    noise float64
+    learning float64
+    learned int
+
+    // The peak that is currently being tracked, and the last beat that was detected
+    peak float64
+    peakTime float64
+    inPeak bool
+    last float64
+    heartRate uint64
+}
+
+/*
+ Creates a detector for an ECG that is sampled with the given interval. The band-pass needs at least 40 samples per
+ second to be meaningful.
+ */
+func NewQRSDetector(interval float64) *QRSDetector {
+    rate := 1 / interval
+    return &QRSDetector{
+        interval: interval,
+        highpass: NewBiquad("highpass", 5, rate),
+        lowpass: NewBiquad("lowpass", math.Min(15, rate / 2.5), rate),
+        integration: make([]float64, int(math.Max(1, 0.15 / interval))),
+        last: math.Inf(-1),
+        learning: math.NaN(),
+    }
+}
+
+/*
+ Processes the next sample of the ECG. If a heartbeat was detected, it is returned.
+ */
+func (d *QRSDetector) Process(time float64, value float64) *Beat {
+
+    // Band-pass, derivative, square and moving window integration
+    filtered := d.lowpass.Process(d.highpass.Process(value))
+    slope := (filtered - d.previous) / d.interval
+    d.previous = filtered
+    d.sum += slope * slope - d.integration[d.position]
+    d.integration[d.position] = slope * slope
+    d.position = (d.position + 1) % len(d.integration)
+    integrated := d.sum / float64(len(d.integration))
+
+    // Learn the initial levels from the maximum and the mean of the first two seconds
+    if math.IsNaN(d.learning) {
+        d.learning = time
+    }
+    if time - d.learning < 2 {
+        d.signal = math.Max(d.signal, integrated)
+        d.noise += (integrated - d.noise) / float64(d.learned + 1)
+        d.learned++
+        return nil
+    }
+
+    // Track the maximum while the signal is above the threshold
+    threshold := d.noise + 0.25 * (d.signal - d.noise)
+    if integrated > threshold {
+        if !d.inPeak || integrated > d.peak {
+            d.peak = integrated
+            d.peakTime = time
+        }
+        d.inPeak = true
+        return nil
+    }
+    if !d.inPeak {
+        return nil
+    }
+    d.inPeak = false
+
+    // The peak has ended. Peaks inside the refractory period of 200ms are noise.
+    if d.peakTime - d.last < 0.2 {
+        d.noise = 0.875 * d.noise + 0.125 * d.peak
+        return nil
+    }
+    d.signal = 0.875 * d.signal + 0.125 * d.peak
+    beat := &Beat{Time: d.peakTime}
+    if !math.IsInf(d.last, -1) {
+        beat.RR = d.peakTime - d.last
+    }
+    d.last = d.peakTime
+    atomic.StoreUint64(&d.heartRate, math.Float64bits(beat.HeartRate()))
+    return beat
+}
+
+/*
+ Returns the heart rate of the last beat in beats per minute. This can be called from any goroutine.
+ */
+func (d *QRSDetector) HeartRate() float64 {
+    return math.Float64frombits(atomic.LoadUint64(&d.heartRate))
+}
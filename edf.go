@@ -0,0 +1,203 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+//go:build !noedf
+
+package main
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "time"
+    "bufio"
+    "bytes"
+    "strings"
+    "strconv"
+    "encoding/binary"
+)
+
+func init() {
+    ImportFormats["edf"] = ImportFormat{"European Data Format (EDF and EDF+), as written by most clinical systems",
+        detectEDF, openEDF}
+}
+
+/*
+ How long the fields of an EDF header are, first those of the file, then those of every signal
+ */
+var (
+    edfFields = []int{8, 80, 80, 8, 8, 8, 44, 8, 8, 4}
+    edfSignalFields = []int{16, 80, 8, 8, 8, 8, 8, 80, 8, 32}
+)
+
+func detectEDF(head []byte) bool {
+    return bytes.HasPrefix(head, []byte("0       "))
+}
+
+/*
+ An EDF file is a header in ASCII followed by data records of a fixed duration, which hold the samples of every
+ signal one signal after the other, as 16 bit integers that are scaled into the physical range of the signal.
+ Signals can have different rates; those with the highest rate are imported, and the annotations of EDF+ are left out.
+ */
+type edfRecording struct {
+    file *os.File
+    reader *bufio.Reader
+    channels []ForeignChannel
+    interval float64
+    created time.Time
+
+    // The signals that are imported, and where their samples are in a data record
+    signals []int
+    offsets []int
+    counts []int
+    gains []float64
+    bases []float64
+    rate int
+    size int
+
+    // The samples of the current data record, and the next one that is returned
+    record []int16
+    next int
+}
+
+func openEDF(file string) (ForeignRecording, error) {
+    f, err := os.Open(file)
+    if err != nil {
+        return nil, err
+    }
+    r := &edfRecording{file: f, reader: bufio.NewReader(f), next: -1}
+    err = r.readHeader()
+    if err != nil {
+        f.Close()
+        return nil, fmt.Errorf("invalid EDF header: %v", err)
+    }
+    return r, nil
+}
+
+func (r *edfRecording) readHeader() error {
+    read := func(sizes []int, repeat int) ([][]string, error) {
+        fields := make([][]string, len(sizes))
+        for i, size := range sizes {
+            for j := 0; j < repeat; j++ {
+                field := make([]byte, size)
+                _, err := io.ReadFull(r.reader, field)
+                if err != nil {
+                    return nil, err
+                }
+                fields[i] = append(fields[i], strings.TrimSpace(string(field)))
+            }
+        }
+        return fields, nil
+    }
+    header, err := read(edfFields, 1)
+    if err != nil {
+        return err
+    }
+    duration, err := strconv.ParseFloat(header[8][0], 64)
+    if err != nil || duration <= 0 {
+        return fmt.Errorf("invalid duration of the data records %q", header[8][0])
+    }
+    count, err := strconv.Atoi(header[9][0])
+    if err != nil || count < 1 {
+        return fmt.Errorf("invalid number of signals %q", header[9][0])
+    }
+
+    // The date is dd.mm.yy, with the years from 1985 to 2084
+    created, err := time.ParseInLocation("02.01.06 15.04.05", header[3][0] + " " + header[4][0], time.Local)
+    if err == nil {
+        if created.Year() < 1985 {
+            created = created.AddDate(100, 0, 0)
+        } else if created.Year() > 2084 {
+            created = created.AddDate(-100, 0, 0)
+        }
+        r.created = created
+    }
+
+    signals, err := read(edfSignalFields, count)
+    if err != nil {
+        return err
+    }
+    offset := 0
+    for i := 0; i < count; i++ {
+        samples, err := strconv.Atoi(signals[8][i])
+        if err != nil || samples < 1 {
+            return fmt.Errorf("invalid number of samples %q of signal %s", signals[8][i], signals[0][i])
+        }
+        r.offsets = append(r.offsets, offset)
+        r.counts = append(r.counts, samples)
+        offset += samples
+        if signals[0][i] != "EDF Annotations" {
+            r.rate = max(r.rate, samples)
+        }
+    }
+    r.size = offset
+    r.interval = duration / float64(r.rate)
+    for i := 0; i < count; i++ {
+        if signals[0][i] == "EDF Annotations" || r.counts[i] != r.rate {
+            continue
+        }
+        numbers := make([]float64, 4)
+        for j := range numbers {
+            numbers[j], err = strconv.ParseFloat(signals[3 + j][i], 64)
+            if err != nil {
+                return fmt.Errorf("invalid range of signal %s", signals[0][i])
+            }
+        }
+        if numbers[3] == numbers[2] {
+            return fmt.Errorf("invalid digital range of signal %s", signals[0][i])
+        }
+
+        // Physical = (digital - digital minimum) * gain + physical minimum
+        gain := (numbers[1] - numbers[0]) / (numbers[3] - numbers[2])
+        base := numbers[0] - numbers[2] * gain
+        unit := signals[2][i]
+        if scale := voltageScale(unit); scale != 0 {
+            gain, base, unit = gain * scale, base * scale, "V"
+        }
+        r.channels = append(r.channels, ForeignChannel{signals[0][i], unit})
+        r.signals = append(r.signals, i)
+        r.gains = append(r.gains, gain)
+        r.bases = append(r.bases, base)
+    }
+    r.record = make([]int16, r.size)
+    return nil
+}
+
+func (r *edfRecording) Channels() []ForeignChannel {
+    return r.channels
+}
+
+func (r *edfRecording) Interval() float64 {
+    return r.interval
+}
+
+func (r *edfRecording) Created() time.Time {
+    return r.created
+}
+
+func (r *edfRecording) Next() ([]float64, error) {
+    if r.next == -1 || r.next == r.rate {
+        err := binary.Read(r.reader, binary.LittleEndian, r.record)
+        if err == io.ErrUnexpectedEOF {
+            // A data record that wasn't completely written is left out
+            return nil, io.EOF
+        }
+        if err != nil {
+            return nil, err
+        }
+        r.next = 0
+    }
+    values := make([]float64, len(r.signals))
+    for i, signal := range r.signals {
+        values[i] = float64(r.record[r.offsets[signal] + r.next]) * r.gains[i] + r.bases[i]
+    }
+    r.next++
+    return values, nil
+}
+
+func (r *edfRecording) Close() error {
+    return r.file.Close()
+}
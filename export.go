@@ -0,0 +1,113 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "fmt"
+    "flag"
+    "math"
+    "strings"
+)
+
+func init() {
+    Commands["export"] = exportCommand
+}
+
+/*
+ How many rows of a recording go into one record batch of an Arrow file by default
+ */
+const exportBatch = 65536
+
+/*
+ Converts recordings into Arrow files (Feather), which pandas and Polars read much faster than the CSV of the
+ recording, since the columns are stored as they are in memory. The columns are those of the recording, named like
+ its header. The recording is read in batches, so it doesn't have to fit into memory.
+ Example:
+    $ plot export session.csv
+    session.arrow: 1500000 samples
+    >>> pandas.read_feather("session.arrow")
+ */
+func exportCommand(args []string) {
+    flags := flag.NewFlagSet("export", flag.ExitOnError)
+    output := flags.String("o", "", "The file that is written, for a single recording. Default: the recording " +
+        "with .arrow instead of .csv.")
+    batch := flags.Int("batch", exportBatch, "How many rows go into one record batch")
+    flags.StringVar(&(Settings.KeyFile), "key", "", "The key file for encrypted recordings")
+    flags.Parse(args)
+    if flags.NArg() == 0 {
+        fail("Usage: plot export [-o file] [--batch=rows] [--key=file] <file>...")
+    }
+    if *output != "" && flags.NArg() > 1 {
+        fail("-o only works with a single recording")
+    }
+    if *batch < 1 {
+        fail("--batch must be at least 1")
+    }
+    for _, file := range flags.Args() {
+        path := *output
+        if path == "" {
+            path = strings.TrimSuffix(file, ".csv") + ".arrow"
+        }
+        rows, err := ExportArrow(file, path, *batch)
+        if err != nil {
+            fail("%s: %v", file, err)
+        }
+        fmt.Printf("%s: %d samples\n", path, rows)
+    }
+}
+
+/*
+ Writes the samples of a recording into an Arrow file, and returns how many there were
+ */
+func ExportArrow(file string, path string, batch int) (int, error) {
+    scanner, err := ScanRecording(file)
+    if err != nil {
+        return 0, err
+    }
+    defer scanner.Close()
+    out, err := os.Create(path)
+    if err != nil {
+        return 0, err
+    }
+    defer out.Close()
+    if len(scanner.Header) == 0 {
+        return 0, fmt.Errorf("the recording has no columns")
+    }
+    writer := NewArrowWriter(out, scanner.Header, true)
+    columns := make([][]float64, len(scanner.Header))
+    rows := 0
+    for scanner.Scan() {
+        // Every column needs a value in every row, a missing one is NaN
+        values := scanner.Values()
+        for i := range columns {
+            value := math.NaN()
+            if i < len(values) {
+                value = values[i]
+            }
+            columns[i] = append(columns[i], value)
+        }
+        rows++
+        if len(columns[0]) == batch {
+            writer.Write(columns)
+            for i := range columns {
+                columns[i] = columns[i][:0]
+            }
+        }
+    }
+    if scanner.Err() != nil {
+        return rows, scanner.Err()
+    }
+    if len(columns[0]) > 0 {
+        writer.Write(columns)
+    }
+    err = writer.Close()
+    if err != nil {
+        return rows, err
+    }
+    return rows, out.Close()
+}
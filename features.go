@@ -0,0 +1,150 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "fmt"
+    "flag"
+    "time"
+    "strings"
+    "strconv"
+    "encoding/csv"
+)
+
+func init() {
+    Commands["features"] = featuresCommand
+}
+
+/*
+ Exports the features of sliding windows of recordings as CSV, to train a classifier with them elsewhere, like with
+ scikit-learn. Every row is one window, with the recording, where the window starts and ends, its label and the
+ features (see Features). The label is the segment that the end of the window is in, named by the last marker before
+ it (without "marker ") or the phase of the protocol, and "start" before the first one, so a session where every
+ gesture was marked yields labeled windows right away. Annotations (see plot annotate) replace the markers that
+ were set during the recording. The signal is processed with the stages of the recording first, like during the
+ session, so the features match those of --classify.
+ Example:
+    $ plot features --window=200ms --step=50ms -o features.csv session.csv
+    $ head -2 features.csv
+    File;Start [s];End [s];Label;rms;mav;zc;wl;var;ssc;iemg;mnf;mdf
+    session.csv;0.000000;0.200000;start;0.0123;0.0098;31;0.412;0.000151;52;0.00196;88.4;71.5
+ */
+func featuresCommand(args []string) {
+    flags := flag.NewFlagSet("features", flag.ExitOnError)
+    window := flags.Duration("window", 200 * time.Millisecond, "The length of every window")
+    step := flags.Duration("step", 50 * time.Millisecond, "The time between the starts of two windows")
+    names := flags.String("features", strings.Join(FeatureNames(), ","), "The features that are exported, in " +
+        "this order")
+    filter := flags.String("filter", "", "The processing stages that are applied before the features are " +
+        "computed, like with --filter. Default: the stages of the recording. none uses the raw signal.")
+    output := flags.String("o", "", "The file that the features are written into. Default: the standard output.")
+    flags.StringVar(&(Settings.KeyFile), "key", "", "The key file for encrypted recordings")
+    flags.Parse(args)
+    if flags.NArg() == 0 {
+        fail("Usage: plot features [--window=duration] [--step=duration] [--features=names] [--filter=stages] " +
+            "[--key=file] [-o file] <file>...")
+    }
+    if *window <= 0 || *step <= 0 {
+        fail("--window and --step must be positive")
+    }
+    features, err := FindFeatures(*names)
+    if err != nil {
+        fail("%v", err)
+    }
+
+    out := os.Stdout
+    if *output != "" {
+        f, err := os.Create(*output)
+        if err != nil {
+            fail("%v", err)
+        }
+        defer f.Close()
+        out = f
+    }
+    table := csv.NewWriter(out)
+    table.Comma = ';'
+    header := []string{"File", "Start [s]", "End [s]", "Label"}
+    for _, feature := range features {
+        header = append(header, feature.Name)
+    }
+    table.Write(header)
+    for _, file := range flags.Args() {
+        err = ExportFeatures(table, file, features, window.Seconds(), step.Seconds(), *filter)
+        if err != nil {
+            fail("%s: %v", file, err)
+        }
+    }
+    table.Flush()
+    if err = table.Error(); err != nil {
+        fail("%v", err)
+    }
+}
+
+/*
+ Writes the features of the windows of a recording into a table. The stages are those of the recording if they are
+ empty.
+ */
+func ExportFeatures(table *csv.Writer, file string, features []Feature, window float64, step float64,
+    stages string) error {
+    meta, err := LoadMetadata(file)
+    if err != nil {
+        return err
+    }
+    if stages == "" {
+        stages = meta.Filters
+    } else if stages == "none" {
+        stages = ""
+    }
+    chain, err := ParseFilters(stages, meta.Interval)
+    if err != nil {
+        return err
+    }
+    events, err := ReadAnnotatedEvents(file)
+    if err != nil {
+        return err
+    }
+    scanner, err := ScanRecording(file)
+    if err != nil {
+        return err
+    }
+    defer scanner.Close()
+    if len(scanner.Header) < 2 {
+        return fmt.Errorf("the recording has no signal")
+    }
+    _, unit := ParseColumn(scanner.Header[1])
+
+    // The windows are taken from the samples as they arrive, like the classifier does, and their label is the
+    // segment at their last sample
+    values := NewSlidingWindow(window, step, meta.Interval)
+    times := NewSlidingWindow(window, step, meta.Interval)
+    label := "start"
+    for scanner.Scan() {
+        row := scanner.Values()
+        for len(events) > 0 && events[0].Time <= row[0] {
+            if segment, ok := EventSegment(events[0].Event); ok {
+                label = strings.TrimPrefix(segment, "marker ")
+            }
+            events = events[1:]
+        }
+        value := row[1] / unit.Scale
+        if stages := chain.Process(value, nil); len(stages) > 0 {
+            value = stages[len(stages) - 1]
+        }
+        times.Add(row[0])
+        if !values.Add(value) {
+            continue
+        }
+        start, end := times.Values[0], times.Values[len(times.Values) - 1]
+        line := []string{file, fmt.Sprintf("%f", start), fmt.Sprintf("%f", end), label}
+        for _, v := range ComputeFeatures(features, values.Values, meta.Interval) {
+            line = append(line, strconv.FormatFloat(v, 'g', -1, 64))
+        }
+        table.Write(line)
+    }
+    return scanner.Err()
+}
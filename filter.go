@@ -0,0 +1,174 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "math"
+    "strings"
+    "strconv"
+)
+
+/*
+ One stage of the signal processing. A filter receives every sample in order and returns the processed value.
+ */
+type Filter interface {
+    Process(value float64) float64
+}
+
+/*
+ The processing stages that are applied to the signal, in order. The output of every stage is kept, so the raw signal
+ and every intermediate step can be recorded.
+ */
+type FilterChain struct {
+    Names []string
+    Filters []Filter
+}
+
+/*
+ Creates the filters from a description like "highpass:20,rectify,rms:0.1". The arguments are frequencies in Hz for
+ highpass, lowpass and notch, and window lengths in seconds for rms. wavelet takes the name of the wavelet and the
+ level instead, like wavelet:db4:4. The interval is the amount of seconds between two samples. An empty description
+ creates an empty chain.
+ */
+func ParseFilters(description string, interval float64) (*FilterChain, error) {
+    chain := &FilterChain{}
+    if description == "" {
+        return chain, nil
+    }
+    rate := 1 / interval
+    for _, stage := range strings.Split(description, ",") {
+        parts := strings.SplitN(strings.TrimSpace(stage), ":", 2)
+        if parts[0] == "wavelet" {
+            filter, err := ParseWaveletFilter(strings.TrimSpace(stage))
+            if err != nil {
+                return nil, err
+            }
+            chain.Names = append(chain.Names, parts[0])
+            chain.Filters = append(chain.Filters, filter)
+            continue
+        }
+        argument := float64(0)
+        if len(parts) == 2 {
+            a, err := strconv.ParseFloat(parts[1], 64)
+            if err != nil {
+                return nil, fmt.Errorf("invalid argument for the filter %s: %v", parts[0], err)
+            }
+            argument = a
+        }
+        if (parts[0] == "highpass" || parts[0] == "lowpass" || parts[0] == "notch") &&
+            (argument <= 0 || argument >= rate / 2) {
+            return nil, fmt.Errorf("the frequency of %s must be between 0 and %gHz", parts[0], rate / 2)
+        }
+
+        var filter Filter
+        switch parts[0] {
+        case "highpass":
+            filter = NewBiquad("highpass", argument, rate)
+        case "lowpass":
+            filter = NewBiquad("lowpass", argument, rate)
+        case "notch":
+            filter = NewBiquad("notch", argument, rate)
+        case "rectify":
+            filter = Rectify{}
+        case "rms":
+            if argument < interval {
+                return nil, fmt.Errorf("the window of rms must be at least one interval long")
+            }
+            filter = NewMovingRMS(int(argument / interval))
+        default:
+            return nil, fmt.Errorf("unknown filter %s", parts[0])
+        }
+        chain.Names = append(chain.Names, parts[0])
+        chain.Filters = append(chain.Filters, filter)
+    }
+    return chain, nil
+}
+
+/*
+ Runs a value through all stages and returns the output of every stage. The auxiliary sensors of the sample are passed
+ to the stages that need them.
+ */
+func (c *FilterChain) Process(value float64, aux []float64) []float64 {
+    if len(c.Filters) == 0 {
+        return nil
+    }
+    stages := make([]float64, len(c.Filters))
+    for i, filter := range c.Filters {
+        if reference, ok := filter.(ReferenceFilter); ok {
+            reference.SetReference(aux)
+        }
+        value = filter.Process(value)
+        stages[i] = value
+    }
+    return stages
+}
+
+/*
+ A second order IIR filter, using the formulas from the Audio EQ Cookbook by Robert Bristow-Johnson
+ */
+type Biquad struct {
+    b0, b1, b2, a1, a2 float64
+    x1, x2, y1, y2 float64
+}
+
+func NewBiquad(kind string, frequency float64, rate float64) *Biquad {
+    w := 2 * math.Pi * frequency / rate
+    q := 1 / math.Sqrt2
+    if kind == "notch" {
+        q = 10
+    }
+    alpha := math.Sin(w) / (2 * q)
+    cos := math.Cos(w)
+    b0, b1, b2 := float64(0), float64(0), float64(0)
+    switch kind {
+    case "lowpass":
+        b0, b1, b2 = (1 - cos) / 2, 1 - cos, (1 - cos) / 2
+    case "highpass":
+        b0, b1, b2 = (1 + cos) / 2, -(1 + cos), (1 + cos) / 2
+    case "notch":
+        b0, b1, b2 = 1, -2 * cos, 1
+    }
+    a0 := 1 + alpha
+    return &Biquad{b0: b0 / a0, b1: b1 / a0, b2: b2 / a0, a1: -2 * cos / a0, a2: (1 - alpha) / a0}
+}
+
+func (b *Biquad) Process(x float64) float64 {
+    y := b.b0 * x + b.b1 * b.x1 + b.b2 * b.x2 - b.a1 * b.y1 - b.a2 * b.y2
+    b.x2, b.x1 = b.x1, x
+    b.y2, b.y1 = b.y1, y
+    return y
+}
+
+/*
+ Returns the absolute value of the signal
+ */
+type Rectify struct{}
+
+func (Rectify) Process(x float64) float64 {
+    return math.Abs(x)
+}
+
+/*
+ The root mean square of the last samples, which is the usual envelope of an EMG signal
+ */
+type MovingRMS struct {
+    window []float64
+    position int
+    sum float64
+}
+
+func NewMovingRMS(length int) *MovingRMS {
+    return &MovingRMS{window: make([]float64, length)}
+}
+
+func (m *MovingRMS) Process(x float64) float64 {
+    m.sum += x * x - m.window[m.position]
+    m.window[m.position] = x * x
+    m.position = (m.position + 1) % len(m.window)
+    return math.Sqrt(math.Max(m.sum, 0) / float64(len(m.window)))
+}
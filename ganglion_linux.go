@@ -0,0 +1,200 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "syscall"
+    "net/url"
+    "encoding/binary"
+)
+
+func init() {
+    Sources["ganglion"] = grabDataFromGanglion
+    SourceChannels["ganglion"] = describeGanglion
+    SourceBackends["ganglion"] = Backend{
+        Usage: "ganglion://<address>?channel=1&random=1",
+        Description: "An OpenBCI Ganglion board over Bluetooth LE",
+        Probe: probeBluetooth,
+    }
+}
+
+// The characteristics of the Ganglion, and the properties of its samples
+const (
+    ganglionReceive = "2d30c082-f39f-4ce6-923f-3484ea480596"
+    ganglionSend = "2d30c083-f39f-4ce6-923f-3484ea480596"
+    ganglionChannels = 4
+    ganglionRate = 200
+
+    // Volts per count: the reference voltage of the MCP3912, its largest value, and the gains of the inputs
+    ganglionScale = 1.2 / (8388607 * 1.5 * 51)
+)
+
+/*
+ This function reads an OpenBCI Ganglion board directly over Bluetooth LE, like the ble source, without the dongle
+ of OpenBCI. The source is given as
+    ganglion://<address>?channel=<channel>&random=1
+ The board streams 4 channels at 200 samples per second. The given channel (starting at 1) is the muscle sensor, the
+ others are recorded as auxiliary channels in volts, named after their number (ch1, ch2 ...). To fit into the
+ notifications, the board sends the samples compressed as the differences to the ones before them, so the values
+ after a lost notification can be offset, which the high pass of the filters removes again. Lost notifications leave
+ a gap in the time.
+ */
+func grabDataFromGanglion(pipeline *Pipeline, target *url.URL) error {
+    query := target.Query()
+    channel, err := queryInt(query, "channel", 1)
+    if err != nil {
+        return err
+    }
+    if channel < 1 || channel > ganglionChannels {
+        return fmt.Errorf("the Ganglion has no channel %d", channel)
+    }
+    receive, _ := parseUUID(ganglionReceive)
+    send, _ := parseUUID(ganglionSend)
+
+    // Connect to the board, subscribe to the samples and start the stream
+    att, err := dialATT(target.Host, query.Get("random") == "1")
+    if err != nil {
+        return err
+    }
+    defer syscall.Close(att)
+    command, _, err := findATT(att, send)
+    if err != nil {
+        return err
+    }
+    handle, err := subscribeATT(att, receive)
+    if err != nil {
+        return err
+    }
+    request := []byte{attWriteRequest, 0, 0, 'b'}
+    binary.LittleEndian.PutUint16(request[1:], command)
+    _, err = requestATT(att, request)
+    if err != nil {
+        return err
+    }
+    defer func() {
+        request[3] = 's'
+        syscall.Write(att, request)
+    }()
+
+    // The clock of the board defines the timing
+    Settings.Interval = 1 / float64(ganglionRate)
+    startRecording(pipeline, "hardware")
+    if Settings.Watchdog > 0 {
+        go RunWatchdog(pipeline, watchdogTimeout())
+    }
+
+    last := make([]int32, ganglionChannels)
+    x := -1
+    previous := -1
+    buffer := make([]byte, 512)
+    for true {
+        n, err := syscall.Read(att, buffer)
+        if err != nil {
+            return err
+        }
+        pdu := buffer[:n]
+        if len(pdu) < 4 || pdu[0] != attNotification || binary.LittleEndian.Uint16(pdu[1:]) != handle {
+            continue
+        }
+
+        // The differences need the uncompressed sample that starts the stream
+        if x < 0 && pdu[3] != 0 {
+            continue
+        }
+        samples, ok := decodeGanglion(pdu[3:], last)
+        if !ok {
+            continue
+        }
+
+        // The compressed packets count from 1 to 100 (18 bits) or from 101 to 200 (19 bits)
+        id := int(pdu[3])
+        if id != 0 && previous > 0 {
+            x += 2 * ((id - previous + 99) % 100)
+        }
+        previous = id
+        for _, sample := range samples {
+            values := make([]float64, ganglionChannels)
+            for i, count := range sample {
+                values[i] = float64(count) * ganglionScale
+            }
+            x++
+            pushOpenBCI(pipeline, x, values, channel)
+        }
+    }
+    return nil
+}
+
+/*
+ Describes the channels of the Ganglion, which always streams all four
+ */
+func describeGanglion(target *url.URL) (AuxChannels, error) {
+    channel, err := queryInt(target.Query(), "channel", 1)
+    if err != nil {
+        return nil, err
+    }
+    if channel < 1 || channel > ganglionChannels {
+        return nil, fmt.Errorf("the Ganglion has no channel %d", channel)
+    }
+    return openBCIChannels(ganglionChannels, channel), nil
+}
+
+/*
+ Decodes a packet of the Ganglion into its samples, in counts of the ADC. The first packet of a stream holds one
+ sample as 24 bit integers, the others hold two samples as 18 or 19 bit differences to the sample before, which are
+ updated in last. Packets that don't hold samples (impedances and messages) return false.
+ */
+func decodeGanglion(packet []byte, last []int32) ([][]int32, bool) {
+    id := int(packet[0])
+    bits := 0
+    switch {
+    case id == 0:
+        if len(packet) < 1 + 3 * ganglionChannels {
+            return nil, false
+        }
+        for i := range last {
+            last[i] = int24(packet[1 + 3 * i:])
+        }
+        return [][]int32{append([]int32{}, last...)}, true
+    case id >= 1 && id <= 100:
+        bits = 18
+    case id >= 101 && id <= 200:
+        bits = 19
+    default:
+        return nil, false
+    }
+    if len(packet) < 1 + (2 * ganglionChannels * bits + 7) / 8 {
+        return nil, false
+    }
+
+    // The sign of a difference is its lowest bit
+    samples := [][]int32{}
+    offset := 8
+    for s := 0; s < 2; s++ {
+        for i := range last {
+            delta := readBits(packet, offset, bits)
+            if delta & 1 != 0 {
+                delta |= ^uint32(0) << uint(bits)
+            }
+            last[i] -= int32(delta)
+            offset += bits
+        }
+        samples = append(samples, append([]int32{}, last...))
+    }
+    return samples, true
+}
+
+/*
+ Reads an unsigned integer of the given number of bits from a bit offset of the data, most significant bit first
+ */
+func readBits(data []byte, offset int, bits int) uint32 {
+    value := uint32(0)
+    for i := offset; i < offset + bits; i++ {
+        value = value << 1 | uint32(data[i / 8] >> uint(7 - i % 8) & 1)
+    }
+    return value
+}
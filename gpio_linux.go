@@ -0,0 +1,107 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "unsafe"
+    "syscall"
+)
+
+/*
+ The ioctl requests and flags of the GPIO character device (see linux/gpio.h, version 1 of the interface)
+ */
+const (
+    gpioGetLineHandle = 0xC16CB403
+    gpioGetLineValues = 0xC040B408
+    gpioSetLineValues = 0xC040B409
+    gpioHandleInput = 1 << 0
+    gpioHandleOutput = 1 << 1
+)
+
+/*
+ The request for a handle to GPIO lines (struct gpiohandle_request)
+ */
+type gpioHandleRequest struct {
+    offsets [64]uint32
+    flags uint32
+    defaults [64]uint8
+    consumer [32]byte
+    lines uint32
+    fd int32
+}
+
+/*
+ One GPIO pin of the Pi that is used as an output or an input. The pins are numbered like the GPIOs of the SoC (BCM
+ numbering), which are the lines of /dev/gpiochip0 (/dev/gpiochip4 on the Pi 5).
+ */
+type GPIO struct {
+    handle int
+}
+
+/*
+ Requests a GPIO line as an output and sets it low
+ */
+func OpenGPIO(chip string, line int) (*GPIO, error) {
+    return openGPIO(chip, line, gpioHandleOutput)
+}
+
+/*
+ Requests a GPIO line as an input
+ */
+func OpenGPIOInput(chip string, line int) (*GPIO, error) {
+    return openGPIO(chip, line, gpioHandleInput)
+}
+
+func openGPIO(chip string, line int, flags uint32) (*GPIO, error) {
+    file, err := os.Open(chip)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    request := gpioHandleRequest{flags: flags, lines: 1}
+    request.offsets[0] = uint32(line)
+    copy(request.consumer[:], "plot")
+    _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), gpioGetLineHandle, uintptr(unsafe.Pointer(&request)))
+    if errno != 0 {
+        return nil, errno
+    }
+    return &GPIO{handle: int(request.fd)}, nil
+}
+
+/*
+ Sets the pin high or low
+ */
+func (g *GPIO) Set(high bool) error {
+    values := [64]uint8{}
+    if high {
+        values[0] = 1
+    }
+    _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(g.handle), gpioSetLineValues,
+        uintptr(unsafe.Pointer(&values)))
+    if errno != 0 {
+        return errno
+    }
+    return nil
+}
+
+/*
+ Returns whether the pin is high
+ */
+func (g *GPIO) Get() (bool, error) {
+    values := [64]uint8{}
+    _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(g.handle), gpioGetLineValues,
+        uintptr(unsafe.Pointer(&values)))
+    if errno != 0 {
+        return false, errno
+    }
+    return values[0] == 1, nil
+}
+
+func (g *GPIO) Close() error {
+    return syscall.Close(g.handle)
+}
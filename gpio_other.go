@@ -0,0 +1,39 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+//go:build !linux
+
+package main
+
+import (
+    "errors"
+)
+
+/*
+ GPIO pins are only supported on Linux, through its GPIO character device
+ */
+type GPIO struct {
+}
+
+func OpenGPIO(chip string, line int) (*GPIO, error) {
+    return nil, errors.New("GPIO pins are only supported on Linux")
+}
+
+func OpenGPIOInput(chip string, line int) (*GPIO, error) {
+    return nil, errors.New("GPIO pins are only supported on Linux")
+}
+
+func (g *GPIO) Set(high bool) error {
+    return errors.New("GPIO pins are only supported on Linux")
+}
+
+func (g *GPIO) Get() (bool, error) {
+    return false, errors.New("GPIO pins are only supported on Linux")
+}
+
+func (g *GPIO) Close() error {
+    return nil
+}
@@ -0,0 +1,393 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+//go:build !nonet
+
+package main
+
+import (
+    "fmt"
+    "flag"
+    "math"
+    "time"
+    "strconv"
+    "net/url"
+    "net/http"
+    "path/filepath"
+    "encoding/json"
+)
+
+func init() {
+    Commands["history"] = historyCommand
+}
+
+/*
+ How many points a range query returns at most by default
+ */
+const historyPoints = 2000
+
+/*
+ A session, as listed by the history API
+ */
+type HistorySession struct {
+    ID string `json:"id"`
+    Created time.Time `json:"created"`
+    Subject string `json:"subject,omitempty"`
+    Tags []string `json:"tags,omitempty"`
+    Interval float64 `json:"interval"`
+    Filters string `json:"filters,omitempty"`
+    Duration float64 `json:"duration,omitempty"`
+    Pruned *time.Time `json:"pruned,omitempty"`
+}
+
+/*
+ Serves the recordings of the session store (see "plot sessions") over HTTP, for dashboards that show any range of
+ the past sessions. The ID of a session is the path of its recording below the directory.
+    GET /api/sessions               The sessions as JSON, ordered by the time they were recorded
+    GET /api/sessions/{id}/samples  The samples from one time to another, see historySamples
+    GET /api/sessions/{id}/rollups  The rollups of a session from one time to another, see historyRollups
+    GET /api/rollups                The rollups of all sessions from one date to another, see historyTrend
+ The server is secured like --serve, with --tls-cert and --token (see Listen and Authenticated). It only listens on
+ the device itself by default. The sessions are health data, so on other addresses, and when it decrypts them with
+ --key, it refuses to start unless clients need a token, a user or a certificate.
+ Example:
+    $ plot history --dir=/data --listen=:8081 --token=secret
+    $ curl -H "Authorization: Bearer secret" \
+        "pi:8081/api/sessions/2024%2Fsession.csv/samples?from=60&to=120&downsample=500&method=minmax"
+ */
+func historyCommand(args []string) {
+    flags := flag.NewFlagSet("history", flag.ExitOnError)
+    dir := flags.String("dir", ".", "The directory of the session store")
+    listen := flags.String("listen", "127.0.0.1:8081", "The address that the API is served on")
+    flags.StringVar(&(Settings.KeyFile), "key", "", "The key file for encrypted recordings")
+    flags.StringVar(&(Settings.TLSCert), "tls-cert", "", "The certificate of the server (PEM)")
+    flags.StringVar(&(Settings.TLSKey), "tls-key", "", "The private key of the certificate of the server (PEM)")
+    flags.StringVar(&(Settings.TLSClientCA), "tls-ca", "", "Only let clients connect that have a certificate of " +
+        "this authority (PEM)")
+    flags.StringVar(&(Settings.Token), "token", "", "The token that clients need. Prefer the PLOT_TOKEN environment " +
+        "variable.")
+    flags.StringVar(&(Settings.BasicAuth), "basic-auth", "", "The user and password that clients can use instead " +
+        "of the token, as user:password")
+    flags.Parse(args)
+    if flags.NArg() > 0 {
+        fail("Usage: plot history [--dir=dir] [--listen=address] [--key=file] [--tls-cert=file --tls-key=file] " +
+            "[--token=token]")
+    }
+    if (Settings.KeyFile != "" || !LoopbackAddress(*listen)) && !Guarded() {
+        fail("plot history serves health data, with --key or on %s it needs --token, --basic-auth or --tls-ca",
+            *listen)
+    }
+    listener, err := Listen(*listen)
+    if err != nil {
+        fail("%v", err)
+    }
+    mux := http.NewServeMux()
+    mux.HandleFunc("GET /api/sessions", func(w http.ResponseWriter, r *http.Request) {
+        historySessions(w, *dir)
+    })
+    mux.HandleFunc("GET /api/sessions/{id}/samples", func(w http.ResponseWriter, r *http.Request) {
+        historySamples(w, r, *dir)
+    })
+    mux.HandleFunc("GET /api/sessions/{id}/rollups", func(w http.ResponseWriter, r *http.Request) {
+        historyRollups(w, r, *dir)
+    })
+    mux.HandleFunc("GET /api/rollups", func(w http.ResponseWriter, r *http.Request) {
+        historyTrend(w, r, *dir)
+    })
+    fmt.Printf("Serving the sessions of %s on %s\n", *dir, listener.Addr())
+    err = http.Serve(listener, Authenticated(mux))
+    if err != nil {
+        fail("%v", err)
+    }
+}
+
+func historySessions(w http.ResponseWriter, dir string) {
+    sessions, err := FindSessions(dir)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    list := []HistorySession{}
+    for _, session := range sessions {
+        id, _ := filepath.Rel(dir, session.File)
+        entry := HistorySession{ID: filepath.ToSlash(id), Created: session.Meta.Created,
+            Subject: session.Meta.Subject, Tags: session.Meta.Tags, Interval: session.Meta.Interval,
+            Filters: session.Meta.Filters, Pruned: session.Meta.Pruned}
+        if index, err := ReadIndex(session.File); err == nil && len(index) > 0 {
+            entry.Duration = index[len(index) - 1].End
+        }
+        list = append(list, entry)
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(list)
+}
+
+/*
+ Returns the samples of a session from one time to another, in seconds since its start, as a table with the columns
+ of the recording (see SampleTable):
+    from, to        The range, the whole recording by default
+    downsample      How many rows are returned at most, 2000 by default and 0 for all of them
+    method          How longer ranges are reduced to these rows, lttb (the default) or minmax, see Downsamplers
+    format          csv, json (the default) or arrow
+ The index of the recording is used to start reading at the range, so a short range of a long recording is quick,
+ and long ranges are read from the rollups, see ReadRange.
+ */
+func historySamples(w http.ResponseWriter, r *http.Request, dir string) {
+    file, err := historyFile(dir, r.PathValue("id"))
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    }
+    query := r.URL.Query()
+    from, to, points := math.Inf(-1), math.Inf(1), historyPoints
+    format, method := "json", "lttb"
+    if value := query.Get("from"); value != "" {
+        from, err = strconv.ParseFloat(value, 64)
+    }
+    if value := query.Get("to"); value != "" && err == nil {
+        to, err = strconv.ParseFloat(value, 64)
+    }
+    if value := query.Get("downsample"); value != "" && err == nil {
+        points, err = strconv.Atoi(value)
+    }
+    if value := query.Get("format"); value != "" {
+        format = value
+    }
+    if value := query.Get("method"); value != "" {
+        method = value
+    }
+    _, known := Downsamplers[method]
+    if _, ok := TableFormats[format]; err != nil || !ok || !known || points < 0 || to < from {
+        http.Error(w, "expected from and to in seconds, downsample as a number of rows, the method lttb or minmax " +
+            "and the format csv, json or arrow", http.StatusBadRequest)
+        return
+    }
+    if meta, _ := LoadMetadata(file); meta.Pruned != nil {
+        http.Error(w, "the samples of the session were removed by the retention, only its rollups are left",
+            http.StatusGone)
+        return
+    }
+    table, err := ReadRange(file, from, to, method, points)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    w.Header().Set("Content-Type", TableFormats[format])
+    table.Write(w, format)
+}
+
+/*
+ Returns the rollups of a session (see Rollup) from one time to another, in seconds since its start, as a table with
+ the columns time, samples, mean, rms, min and max:
+    from, to        The range, the whole recording by default
+    resolution      1s (the default) or 1min
+    format          csv, json (the default) or arrow
+ Recordings without rollups have none, "plot index" creates them.
+ */
+func historyRollups(w http.ResponseWriter, r *http.Request, dir string) {
+    file, err := historyFile(dir, r.PathValue("id"))
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    }
+    query := r.URL.Query()
+    from, to := math.Inf(-1), math.Inf(1)
+    if value := query.Get("from"); value != "" {
+        from, err = strconv.ParseFloat(value, 64)
+    }
+    if value := query.Get("to"); value != "" && err == nil {
+        to, err = strconv.ParseFloat(value, 64)
+    }
+    resolution, format, ok := historyTable(query, "1s")
+    if err != nil || !ok || to < from {
+        http.Error(w, "expected from and to in seconds, the resolution 1s or 1min and the format csv, json or " +
+            "arrow", http.StatusBadRequest)
+        return
+    }
+    rollups, err := ReadRollups(file, resolution)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    selected := []Rollup{}
+    for _, rollup := range rollups {
+        if rollup.Start >= from && rollup.Start <= to {
+            selected = append(selected, rollup)
+        }
+    }
+    w.Header().Set("Content-Type", TableFormats[format])
+    NewRollupTable(selected).Write(w, format)
+}
+
+/*
+ Returns the rollups of every session from one date to another as one table, for trends over weeks and months. The
+ time is in seconds since 1970, so the gaps between the sessions stay visible:
+    from, to        The range as RFC 3339 dates, all sessions by default
+    resolution      1min (the default) or 1s
+    format          csv, json (the default) or arrow
+ */
+func historyTrend(w http.ResponseWriter, r *http.Request, dir string) {
+    query := r.URL.Query()
+    from, to := time.Time{}, time.Now()
+    var err error
+    if value := query.Get("from"); value != "" {
+        from, err = time.Parse(time.RFC3339, value)
+    }
+    if value := query.Get("to"); value != "" && err == nil {
+        to, err = time.Parse(time.RFC3339, value)
+    }
+    resolution, format, ok := historyTable(query, "1min")
+    if err != nil || !ok || to.Before(from) {
+        http.Error(w, "expected from and to as RFC 3339 dates, the resolution 1s or 1min and the format csv, " +
+            "json or arrow", http.StatusBadRequest)
+        return
+    }
+    sessions, err := FindSessions(dir)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    selected := []Rollup{}
+    for _, session := range sessions {
+        rollups, err := ReadRollups(session.File, resolution)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        started := float64(session.Meta.Created.UnixNano()) / 1e9
+        for _, rollup := range rollups {
+            rollup.Start += started
+            if rollup.Start >= float64(from.Unix()) && rollup.Start <= float64(to.Unix()) {
+                selected = append(selected, rollup)
+            }
+        }
+    }
+    w.Header().Set("Content-Type", TableFormats[format])
+    NewRollupTable(selected).Write(w, format)
+}
+
+/*
+ Returns the resolution and the format that a query asks for, and whether both are known
+ */
+func historyTable(query url.Values, resolution string) (string, string, bool) {
+    format := "json"
+    if value := query.Get("resolution"); value != "" {
+        resolution = value
+    }
+    if value := query.Get("format"); value != "" {
+        format = value
+    }
+    _, known := RollupResolutions[resolution]
+    _, ok := TableFormats[format]
+    return resolution, format, known && ok
+}
+
+/*
+ Returns the recording of a session ID, which has to be below the directory
+ */
+func historyFile(dir string, id string) (string, error) {
+    file := filepath.Join(dir, filepath.FromSlash(id))
+    relative, err := filepath.Rel(dir, file)
+    if err != nil || !filepath.IsLocal(relative) {
+        return "", fmt.Errorf("unknown session %s", url.PathEscape(id))
+    }
+    if _, err := LoadMetadata(file); err != nil {
+        return "", fmt.Errorf("unknown session %s", url.PathEscape(id))
+    }
+    return file, nil
+}
+
+/*
+ Reads the samples of a recording from one time to another into a table, reduced to at most the given number of rows
+ with one of Downsamplers while they are read (see StreamDownsampler). Long ranges are read from the rollups, if the
+ buckets are at least as long as one of their resolutions: every rollup is a row at its start with its minimum, and
+ one with its maximum. The rollups only summarize the signal, so the other columns are NaN then.
+ */
+func ReadRange(file string, from float64, to float64, method string, points int) (SampleTable, error) {
+    scanner, err := ScanRecording(file)
+    if err != nil {
+        return SampleTable{}, err
+    }
+    defer scanner.Close()
+    index, _ := ReadIndex(file)
+    start, end, err := recordingSpan(file, index)
+    if err != nil {
+        return SampleTable{}, err
+    }
+    downsampler := NewStreamDownsampler(method, math.Max(from, start), math.Min(to, end), points)
+
+    // The longest resolution that fits into a bucket
+    resolution := ""
+    for name, seconds := range RollupResolutions {
+        if downsampler.Bucket() >= seconds && (resolution == "" || seconds > RollupResolutions[resolution]) {
+            resolution = name
+        }
+    }
+    if resolution != "" {
+        rollups, err := ReadRollups(file, resolution)
+        if err != nil {
+            return SampleTable{}, err
+        }
+        if len(rollups) > 0 {
+            for _, rollup := range rollups {
+                if rollup.Start < from || rollup.Start > to {
+                    continue
+                }
+                for _, value := range []float64{rollup.Min, rollup.Max} {
+                    row := make([]float64, len(scanner.Header))
+                    for i := range row {
+                        row[i] = math.NaN()
+                    }
+                    row[0], row[1] = rollup.Start, value
+                    downsampler.Add(row)
+                }
+            }
+            return NewRowTable(scanner.Header, downsampler.Rows()), nil
+        }
+    }
+
+    // Without an index, the recording is read from the start
+    if len(index) > 0 && !math.IsInf(from, -1) {
+        err = scanner.Seek(index, from)
+        if err != nil {
+            return SampleTable{}, err
+        }
+    }
+    for scanner.Scan() {
+        values := scanner.Values()
+        if len(values) == 0 || values[0] < from {
+            continue
+        }
+        if values[0] > to {
+            break
+        }
+        downsampler.Add(values)
+    }
+    return NewRowTable(scanner.Header, downsampler.Rows()), scanner.Err()
+}
+
+/*
+ Returns the time of the first and the last sample of a recording, from its index, or by reading its times if it has
+ none
+ */
+func recordingSpan(file string, index []IndexBlock) (float64, float64, error) {
+    if len(index) > 0 {
+        return index[0].Start, index[len(index) - 1].End, nil
+    }
+    scanner, err := ScanRecording(file)
+    if err != nil {
+        return 0, 0, err
+    }
+    defer scanner.Close()
+    start, end := math.NaN(), math.NaN()
+    for scanner.Scan() {
+        if math.IsNaN(start) {
+            start = scanner.Values()[0]
+        }
+        end = scanner.Values()[0]
+    }
+    return start, end, scanner.Err()
+}
@@ -0,0 +1,241 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "math"
+    "time"
+    "runtime"
+    "strings"
+    "strconv"
+    "sync/atomic"
+)
+
+/*
+ The channel of a load cell, which is recorded like an auxiliary sensor. Without a scale, the load cell isn't
+ calibrated and records the counts of the ADC.
+ */
+func HX711Channel(scale float64) AuxChannel {
+    if scale == 0 {
+        return AuxChannel{Name: "force", Unit: "counts", Scale: 1, Sensor: "hx711"}
+    }
+    return AuxChannel{Name: "force", Unit: "N", Scale: 1, Sensor: "hx711"}
+}
+
+const (
+    // How long the HX711 may take to finish a conversion, it makes 10 or 80 per second
+    hx711Timeout = time.Second
+
+    // After how long without a conversion the force counts as lost
+    hx711Stale = 500 * time.Millisecond
+
+    // How many conversions are averaged to tare the load cell
+    hx711Tare = 10
+
+    // How long the clock pin may stay high before the HX711 powers down, and how long it is held high to reset it
+    hx711Pulse = 60 * time.Microsecond
+    hx711Reset = 100 * time.Microsecond
+)
+
+/*
+ The HX711, a 24 bit ADC for load cells. It has no bus of its own, its serial interface is driven through two GPIO
+ pins: the data pin goes low when a conversion is ready, and every pulse of the clock pin shifts out one bit, most
+ significant first. Channel A with a gain of 128 is used. The HX711 converts much more slowly than the ADC of the
+ muscle sensor, so it is read on its own (see Run), and every sample takes the last force that was converted. The
+ clock pin must not stay high for more than 60 µs, or the HX711 powers down, which every pulse takes two system
+ calls for, so readings with a longer pulse are discarded, and the HX711 is reset, since it stopped in the middle of
+ the reading.
+ */
+type HX711 struct {
+    data *GPIO
+    clock *GPIO
+    last atomic.Value
+
+    /*
+     Converts the readings into newtons: force = (reading - offset) * scale. A scale of zero keeps the counts. Unless
+     the offset was given, the load cell is tared when it starts.
+     */
+    Scale float64
+    Offset float64
+    Tared bool
+}
+
+/*
+ A force and when it was converted
+ */
+type hx711Force struct {
+    force float64
+    at time.Time
+}
+
+/*
+ Connects to an HX711 on the GPIO pins given as <data>:<clock>
+ */
+func OpenHX711(chip string, pins string) (*HX711, error) {
+    parts := strings.Split(pins, ":")
+    if len(parts) != 2 {
+        return nil, fmt.Errorf("invalid pins %q, expected <data>:<clock>", pins)
+    }
+    data, err := strconv.Atoi(parts[0])
+    if err != nil {
+        return nil, fmt.Errorf("invalid data pin %q", parts[0])
+    }
+    clock, err := strconv.Atoi(parts[1])
+    if err != nil {
+        return nil, fmt.Errorf("invalid clock pin %q", parts[1])
+    }
+    h := &HX711{}
+    h.data, err = OpenGPIOInput(chip, data)
+    if err != nil {
+        return nil, err
+    }
+    h.clock, err = OpenGPIO(chip, clock)
+    if err != nil {
+        h.data.Close()
+        return nil, err
+    }
+    h.last.Store(hx711Force{math.NaN(), time.Time{}})
+    return h, nil
+}
+
+/*
+ Waits for the next conversion and returns it. The second value is false if the clock stayed high for too long, in
+ which case the reading is discarded and the HX711 was reset. The first conversion after a reset hasn't settled yet.
+ */
+func (h *HX711) Read() (int32, bool, error) {
+    err := h.wait()
+    if err != nil {
+        return 0, false, err
+    }
+
+    // The 25th pulse selects channel A with a gain of 128 for the next conversion. The thread mustn't change
+    // between the two calls of a pulse.
+    runtime.LockOSThread()
+    defer runtime.UnlockOSThread()
+    value := uint32(0)
+    for i := 0; i < 25; i++ {
+        err = h.clock.Set(true)
+        if err != nil {
+            return 0, false, err
+        }
+        pulse := time.Now()
+        err = h.clock.Set(false)
+        if err != nil {
+            return 0, false, err
+        }
+        if time.Since(pulse) > hx711Pulse {
+            return 0, false, h.reset()
+        }
+        if i == 24 {
+            break
+        }
+        high, err := h.data.Get()
+        if err != nil {
+            return 0, false, err
+        }
+        value <<= 1
+        if high {
+            value |= 1
+        }
+    }
+    return int32(value << 8) >> 8, true, nil
+}
+
+/*
+ Waits until the HX711 has finished a conversion
+ */
+func (h *HX711) wait() error {
+    start := time.Now()
+    for true {
+        high, err := h.data.Get()
+        if err != nil {
+            return err
+        }
+        if !high {
+            return nil
+        }
+        if time.Since(start) > hx711Timeout {
+            return fmt.Errorf("the HX711 doesn't convert, is it connected?")
+        }
+        time.Sleep(time.Millisecond)
+    }
+    return nil
+}
+
+/*
+ Resets the HX711 after a reading was cut off: holding the clock high powers it down, and pulling it low powers it up
+ again with channel A and a gain of 128. Returns once it has finished its first conversion.
+ */
+func (h *HX711) reset() error {
+    err := h.clock.Set(true)
+    if err != nil {
+        return err
+    }
+    time.Sleep(hx711Reset)
+    err = h.clock.Set(false)
+    if err != nil {
+        return err
+    }
+    return h.wait()
+}
+
+/*
+ Reads the load cell until the session ends. Unless it is tared already, the first conversions tare it.
+ */
+func (h *HX711) Run(pipeline *Pipeline) {
+    tare := 0.0
+    readings := 0
+    settling := false
+    for !pipeline.Closed() {
+        reading, ok, err := h.Read()
+        if err != nil {
+            // A failing load cell must not stop the acquisition, the force is lost until it works again
+            time.Sleep(hx711Timeout)
+            settling = true
+            continue
+        }
+
+        // The first conversion after a reset hasn't settled yet
+        if !ok || settling {
+            settling = !ok
+            continue
+        }
+        if !h.Tared {
+            tare += float64(reading)
+            readings++
+            if readings < hx711Tare {
+                continue
+            }
+            h.Offset = tare / hx711Tare
+            h.Tared = true
+            _, t := pipeline.LastSample()
+            pipeline.Event(t, fmt.Sprintf("tared the load cell at %.0f", h.Offset))
+        }
+        force := float64(reading) - h.Offset
+        if h.Scale != 0 {
+            force *= h.Scale
+        }
+        h.last.Store(hx711Force{force, time.Now()})
+    }
+}
+
+/*
+ Returns the last force in newtons, or in counts without a scale. It is NaN if there is none or it is too old.
+ */
+func (h *HX711) Force() float64 {
+    last := h.last.Load().(hx711Force)
+    if time.Since(last.at) > hx711Stale {
+        return math.NaN()
+    }
+    return last.force
+}
+
+func (h *HX711) Close() error {
+    h.clock.Close()
+    return h.data.Close()
+}
@@ -0,0 +1,66 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+)
+
+/*
+ A connection to one device on an I2C bus, using the Linux i2c-dev interface
+ */
+type I2C struct {
+    file *os.File
+}
+
+/*
+ Opens the I2C bus (usually /dev/i2c-1 on a Raspberry Pi) and selects the device with the given address
+ */
+func OpenI2C(bus string, address int) (*I2C, error) {
+    file, err := os.OpenFile(bus, os.O_RDWR, 0)
+    if err != nil {
+        return nil, err
+    }
+    err = selectI2CAddress(file, address)
+    if err != nil {
+        file.Close()
+        return nil, err
+    }
+    return &I2C{file: file}, nil
+}
+
+func (i *I2C) Read(p []byte) (int, error) {
+    return i.file.Read(p)
+}
+
+func (i *I2C) Write(p []byte) (int, error) {
+    return i.file.Write(p)
+}
+
+/*
+ Writes the address of a register and reads the following bytes, which is how most sensors are accessed
+ */
+func (i *I2C) ReadRegister(register byte, p []byte) error {
+    _, err := i.file.Write([]byte{register})
+    if err != nil {
+        return err
+    }
+    _, err = i.file.Read(p)
+    return err
+}
+
+/*
+ Writes a value into a register of the device
+ */
+func (i *I2C) WriteRegister(register byte, value byte) error {
+    _, err := i.file.Write([]byte{register, value})
+    return err
+}
+
+func (i *I2C) Close() error {
+    return i.file.Close()
+}
@@ -0,0 +1,28 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "syscall"
+)
+
+/*
+ The ioctl request that selects the address of the device on the I2C bus (see linux/i2c-dev.h)
+ */
+const i2cSlave = 0x0703
+
+/*
+ Selects the device that the reads and writes of the bus go to
+ */
+func selectI2CAddress(file *os.File, address int) error {
+    _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), i2cSlave, uintptr(address))
+    if errno != 0 {
+        return errno
+    }
+    return nil
+}
@@ -0,0 +1,21 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+//go:build !linux
+
+package main
+
+import (
+    "os"
+    "errors"
+)
+
+/*
+ The I2C bus is only supported on Linux, through its i2c-dev interface
+ */
+func selectI2CAddress(file *os.File, address int) error {
+    return errors.New("the I2C bus is only supported on Linux")
+}
@@ -0,0 +1,587 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "flag"
+    "math"
+    "sort"
+    "time"
+    "bufio"
+    "bytes"
+    "strings"
+    "strconv"
+    "encoding/csv"
+    "encoding/json"
+    "path/filepath"
+)
+
+func init() {
+    Commands["import"] = importCommand
+}
+
+/*
+ A channel of a recording of another system. Channels that measure a voltage are converted into volts and have the
+ unit V, the others keep their own unit.
+ */
+type ForeignChannel struct {
+    Name string
+    Unit string
+}
+
+/*
+ A recording of another system, read one sample after the other
+ */
+type ForeignRecording interface {
+    /*
+     The channels, the seconds between two samples, and when the recording was started (zero if the file doesn't
+     tell)
+     */
+    Channels() []ForeignChannel
+    Interval() float64
+    Created() time.Time
+
+    /*
+     Returns the values of the next sample, one per channel, or io.EOF after the last one. Values that are missing
+     in a sample are NaN.
+     */
+    Next() ([]float64, error)
+    Close() error
+}
+
+/*
+ A format that recordings can be imported from. Detect looks at the start of a file and tells whether it has the
+ format.
+ */
+type ImportFormat struct {
+    Description string
+    Detect func(head []byte) bool
+    Open func(file string) (ForeignRecording, error)
+}
+
+/*
+ The formats that "plot import" reads, by their names. EDF adds itself unless the build leaves it out (noedf).
+ */
+var ImportFormats = map[string]ImportFormat{
+    "delsys": {"CSV exports of Delsys EMGworks, with a time column (X[s]) before every channel", detectDelsys,
+        openDelsys},
+    "openbci": {"TXT recordings of the OpenBCI GUI, in µV", detectOpenBCI, openOpenBCI},
+    "bitalino": {"TXT recordings of OpenSignals (BITalino), EMG channels are converted into volts", detectBITalino,
+        openBITalino},
+}
+
+/*
+ Returns the names of the formats, sorted
+ */
+func ImportFormatNames() []string {
+    names := []string{}
+    for name := range ImportFormats {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+/*
+ Converts recordings of other systems into recordings of plot, so they can be played, analyzed, reported and found in
+ the session store like any other session. One channel becomes the signal (the first one in volts by default), the
+ others are recorded as auxiliary sensors. The recording is written into the directory with the name of the file and
+ the extension .plot.csv, so a foreign CSV file can be imported next to itself, with its index, rollups and
+ checksums; existing files are never overwritten.
+ Example:
+    $ plot import --dir=sessions --tag=trigno --channel="EMG 1" trial.csv
+    sessions/trial.plot.csv: 96300 samples of delsys at 1926 Hz, 4 channels
+ */
+func importCommand(args []string) {
+    flags := flag.NewFlagSet("import", flag.ExitOnError)
+    format := flags.String("format", "auto", "The format of the files: auto, " +
+        strings.Join(ImportFormatNames(), ", "))
+    channel := flags.String("channel", "", "The name or the number (from 1) of the channel that becomes the signal. " +
+        "Default: the first one in volts.")
+    dir := flags.String("dir", ".", "The directory that the recordings are written into")
+    tags := Tags{}
+    flags.Var(&tags, "tag", "A tag that the sessions can be found by, see plot sessions. Can be given several times.")
+    flags.StringVar(&(Settings.Subject), "subject", "", "The name of the subject, which is only stored as pseudonym")
+    flags.StringVar(&(Settings.KeyFile), "key", "", "The key file for encrypting the recordings")
+    encrypt := flags.Bool("encrypt", false, "Whether the recordings are encrypted")
+    jobs := flags.Int("jobs", defaultJobs, "How many files are imported at the same time")
+    flags.Parse(args)
+    if flags.NArg() == 0 {
+        fail("Usage: plot import [--format=name] [--channel=name] [--dir=dir] [--tag=tag] [--subject=name] " +
+            "[--encrypt --key=file] [--jobs=n] <file>...")
+    }
+    if _, ok := ImportFormats[*format]; !ok && *format != "auto" {
+        fail("unknown --format %s, expected auto or one of %s", *format, strings.Join(ImportFormatNames(), ", "))
+    }
+    subject := ""
+    if Settings.Subject != "" {
+        pseudonym, err := Pseudonym(Settings.Subject)
+        if err != nil {
+            fail("%v", err)
+        }
+        subject = pseudonym
+    }
+    files := flags.Args()
+    err := ProcessFiles(files, *jobs, "Imported", func(i int) (string, error) {
+        name := *format
+        if name == "auto" {
+            detected, err := DetectImportFormat(files[i])
+            if err != nil {
+                return "", err
+            }
+            name = detected
+        }
+        target := filepath.Join(*dir, strings.TrimSuffix(filepath.Base(files[i]), filepath.Ext(files[i])) +
+            ".plot.csv")
+        meta := Metadata{Timing: "imported", Source: "import:" + name, Subject: subject, Tags: tags,
+            Encrypted: *encrypt}
+        recording, err := ImportFormats[name].Open(files[i])
+        if err != nil {
+            return "", err
+        }
+        defer recording.Close()
+        count, err := ImportRecording(recording, target, meta, *channel)
+        if err != nil {
+            return "", err
+        }
+        return fmt.Sprintf("%s: %d samples of %s at %g Hz, %d channels\n", target, count, name,
+            math.Round(1 / recording.Interval()), len(recording.Channels())), nil
+    })
+    if err != nil {
+        fail("%v", err)
+    }
+}
+
+/*
+ Returns the format of a file, from the start of it
+ */
+func DetectImportFormat(file string) (string, error) {
+    f, err := os.Open(file)
+    if err != nil {
+        return "", err
+    }
+    defer f.Close()
+    head := make([]byte, 4096)
+    n, err := io.ReadFull(f, head)
+    if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+        return "", err
+    }
+    for _, name := range ImportFormatNames() {
+        if ImportFormats[name].Detect(head[:n]) {
+            return name, nil
+        }
+    }
+    return "", fmt.Errorf("unknown format, give one with --format")
+}
+
+/*
+ Writes a foreign recording as a recording of plot, and returns how many samples it had. The metadata is completed
+ from the foreign recording. The channel is chosen by its name or its number, or is the first one in volts if it is
+ empty.
+ */
+func ImportRecording(recording ForeignRecording, target string, meta Metadata, channel string) (int, error) {
+    if _, err := os.Stat(target); err == nil {
+        return 0, fmt.Errorf("%s already exists", target)
+    }
+    channels := recording.Channels()
+    signal := -1
+    for i, c := range channels {
+        if (channel == "" && c.Unit == "V") || c.Name == channel || strconv.Itoa(i + 1) == channel {
+            signal = i
+            break
+        }
+    }
+    if signal == -1 && channel == "" {
+        return 0, fmt.Errorf("no channel is in volts")
+    }
+    if signal == -1 {
+        return 0, fmt.Errorf("there is no channel %s", channel)
+    }
+    if channels[signal].Unit != "V" {
+        return 0, fmt.Errorf("the channel %s isn't in volts", channels[signal].Name)
+    }
+    if recording.Interval() <= 0 {
+        return 0, fmt.Errorf("the recording has no sample rate")
+    }
+
+    // The other channels are auxiliary sensors of the recording
+    columns := []string{voltageUnits[0].Column("Voltage")}
+    for i, c := range channels {
+        if i != signal {
+            aux := AuxChannel{Channel: i + 1, Name: c.Name, Unit: c.Unit, Scale: 1, Sensor: "import"}
+            meta.Aux = append(meta.Aux, aux)
+            columns = append(columns, aux.Column())
+        }
+    }
+    meta.Interval = recording.Interval()
+    meta.Created = recording.Created()
+    meta.Mode = "emg"
+    if meta.Created.IsZero() {
+        meta.Created = time.Now()
+    }
+    recorder, err := NewRecorder(target, meta, columns)
+    if err != nil {
+        return 0, err
+    }
+    count := 0
+    row := make([]float64, len(channels))
+    for true {
+        values, err := recording.Next()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            recorder.Close()
+            return count, err
+        }
+        row = append(row[:0], values[signal])
+        for i, value := range values {
+            if i != signal {
+                row = append(row, value)
+            }
+        }
+        err = recorder.Write(float64(count) * meta.Interval, row...)
+        if err != nil {
+            recorder.Close()
+            return count, err
+        }
+        count++
+    }
+    return count, recorder.Close()
+}
+
+/*
+ Returns the factor that converts a voltage in a unit into volts, or 0 if the unit isn't a voltage
+ */
+func voltageScale(unit string) float64 {
+    switch strings.TrimSpace(unit) {
+    case "V":
+        return 1
+    case "mV":
+        return 1e-3
+    case "uV", "µV":
+        return 1e-6
+    case "nV":
+        return 1e-9
+    }
+    return 0
+}
+
+/*
+ A foreign recording that is read from rows of text, which parse turns into the values of a sample
+ */
+type textRecording struct {
+    file *os.File
+    reader *csv.Reader
+    channels []ForeignChannel
+    interval float64
+    created time.Time
+
+    // Rows that were read ahead to find the sample rate
+    held [][]string
+    parse func(row []string) ([]float64, error)
+}
+
+func (r *textRecording) Channels() []ForeignChannel {
+    return r.channels
+}
+
+func (r *textRecording) Interval() float64 {
+    return r.interval
+}
+
+func (r *textRecording) Created() time.Time {
+    return r.created
+}
+
+func (r *textRecording) Next() ([]float64, error) {
+    var row []string
+    if len(r.held) > 0 {
+        row, r.held = r.held[0], r.held[1:]
+    } else {
+        var err error
+        row, err = r.reader.Read()
+        if err != nil {
+            return nil, err
+        }
+    }
+    return r.parse(row)
+}
+
+func (r *textRecording) Close() error {
+    return r.file.Close()
+}
+
+/*
+ Opens a text file and skips the lines of its header, up to the one that header returns true for. That line is
+ returned, and the rest of the file is read by the CSV reader, without the lines that start with %.
+ */
+func openText(file string, comma rune, header func(line string) bool) (*textRecording, string, error) {
+    f, err := os.Open(file)
+    if err != nil {
+        return nil, "", err
+    }
+    buffered := bufio.NewReader(f)
+    for true {
+        line, err := buffered.ReadString('\n')
+        if err != nil {
+            f.Close()
+            if err == io.EOF {
+                return nil, "", fmt.Errorf("the file has no data")
+            }
+            return nil, "", err
+        }
+        line = strings.TrimRight(line, "\r\n")
+        if header(line) {
+            reader := csv.NewReader(buffered)
+            reader.Comma = comma
+            reader.FieldsPerRecord = -1
+            reader.TrimLeadingSpace = true
+            reader.Comment = '%'
+            return &textRecording{file: f, reader: reader}, line, nil
+        }
+    }
+    return nil, "", nil
+}
+
+/*
+ Parses a number of a row, and returns NaN for an empty or missing field
+ */
+func parseField(row []string, i int) (float64, error) {
+    if i >= len(row) || strings.TrimSpace(row[i]) == "" {
+        return math.NaN(), nil
+    }
+    return strconv.ParseFloat(strings.TrimSpace(row[i]), 64)
+}
+
+func detectDelsys(head []byte) bool {
+    return bytes.Contains(head, []byte("X[s]"))
+}
+
+/*
+ Delsys EMGworks exports every channel as two columns, its time (X[s]) and its values ("Sensor 1: EMG 1 [V]").
+ Channels with a lower rate than the first one leave their fields empty in the rows in between, and are NaN there.
+ */
+func openDelsys(file string) (ForeignRecording, error) {
+    recording, header, err := openText(file, ',', func(line string) bool {
+        return strings.HasPrefix(strings.Trim(line, "\" "), "X[s]")
+    })
+    if err != nil {
+        return nil, err
+    }
+    fields, err := csv.NewReader(strings.NewReader(header)).Read()
+    if err != nil {
+        recording.Close()
+        return nil, err
+    }
+    scales := []float64{}
+    indices := []int{}
+    for i := 1; i < len(fields); i += 2 {
+        name, unit := fields[i], ""
+        if open := strings.LastIndex(name, "["); open >= 0 && strings.HasSuffix(name, "]") {
+            name, unit = strings.TrimSpace(name[:open]), name[open + 1:len(name) - 1]
+        }
+        scale := voltageScale(unit)
+        if scale != 0 {
+            unit = "V"
+        } else {
+            scale = 1
+        }
+        recording.channels = append(recording.channels, ForeignChannel{name, unit})
+        scales = append(scales, scale)
+        indices = append(indices, i)
+    }
+    recording.parse = func(row []string) ([]float64, error) {
+        values := make([]float64, len(indices))
+        for i, index := range indices {
+            value, err := parseField(row, index)
+            if err != nil {
+                return nil, fmt.Errorf("invalid value %q", row[index])
+            }
+            values[i] = value * scales[i]
+        }
+        return values, nil
+    }
+
+    // The sample rate is the one of the first channel
+    for len(recording.held) < 2 {
+        row, err := recording.reader.Read()
+        if err != nil {
+            break
+        }
+        recording.held = append(recording.held, row)
+    }
+    if len(recording.held) == 2 {
+        first, _ := parseField(recording.held[0], 0)
+        second, _ := parseField(recording.held[1], 0)
+        recording.interval = second - first
+    }
+    return recording, nil
+}
+
+func detectOpenBCI(head []byte) bool {
+    return bytes.HasPrefix(head, []byte("%OpenBCI"))
+}
+
+/*
+ The OpenBCI GUI starts its recordings with comments (%) that tell the sample rate, followed by a header with the
+ columns. The EXG channels are in µV, the accelerometer in g. The timestamps of the samples are in seconds since 1970.
+ */
+func openOpenBCI(file string) (ForeignRecording, error) {
+    rate := 0.0
+    recording, header, err := openText(file, ',', func(line string) bool {
+        if value, ok := strings.CutPrefix(line, "%Sample Rate = "); ok {
+            rate, _ = strconv.ParseFloat(strings.TrimSuffix(value, " Hz"), 64)
+        }
+        return !strings.HasPrefix(line, "%")
+    })
+    if err != nil {
+        return nil, err
+    }
+    if rate <= 0 {
+        recording.Close()
+        return nil, fmt.Errorf("the file doesn't tell its sample rate")
+    }
+    recording.interval = 1 / rate
+    indices := []int{}
+    scales := []float64{}
+    timestamp := -1
+    for i, field := range strings.Split(header, ",") {
+        name := strings.TrimSpace(field)
+        switch {
+        case strings.HasPrefix(name, "EXG Channel"):
+            recording.channels = append(recording.channels, ForeignChannel{name, "V"})
+            indices, scales = append(indices, i), append(scales, 1e-6)
+        case strings.HasPrefix(name, "Accel Channel"):
+            recording.channels = append(recording.channels, ForeignChannel{name, "g"})
+            indices, scales = append(indices, i), append(scales, 1)
+        case name == "Timestamp":
+            timestamp = i
+        }
+    }
+    if len(indices) == 0 {
+        recording.Close()
+        return nil, fmt.Errorf("the file has no EXG channels")
+    }
+    recording.parse = func(row []string) ([]float64, error) {
+        values := make([]float64, len(indices))
+        for i, index := range indices {
+            value, err := parseField(row, index)
+            if err != nil {
+                return nil, fmt.Errorf("invalid value %q", row[index])
+            }
+            values[i] = value * scales[i]
+        }
+        return values, nil
+    }
+    if row, err := recording.reader.Read(); err == nil {
+        recording.held = append(recording.held, row)
+        if timestamp >= 0 {
+            if seconds, err := parseField(row, timestamp); err == nil && !math.IsNaN(seconds) {
+                recording.created = time.Unix(0, int64(seconds * 1e9))
+            }
+        }
+    }
+    return recording, nil
+}
+
+func detectBITalino(head []byte) bool {
+    return bytes.HasPrefix(head, []byte("# OpenSignals Text File Format"))
+}
+
+/*
+ The supply voltage and the gain of the EMG sensor of BITalino, which convert its readings into volts:
+    EMG = (ADC / 2^n - 1/2) * VCC / G
+ */
+const (
+    bitalinoVCC = 3.3
+    bitalinoGain = 1009
+)
+
+/*
+ OpenSignals describes the recording in a line of JSON in its header, with an entry per device. The channels of the
+ first device are imported: the analog ones by their sensor and their label ("EMG A1"), EMG in volts and the others
+ as raw readings.
+ */
+func openBITalino(file string) (ForeignRecording, error) {
+    description := ""
+    recording, _, err := openText(file, '\t', func(line string) bool {
+        if strings.HasPrefix(line, "# {") {
+            description = strings.TrimPrefix(line, "# ")
+        }
+        return line == "# EndOfHeader"
+    })
+    if err != nil {
+        return nil, err
+    }
+    devices := map[string]struct {
+        Rate float64 `json:"sampling rate"`
+        Columns []string `json:"column"`
+        Labels []string `json:"label"`
+        Sensors []string `json:"sensor"`
+        Resolution []int `json:"resolution"`
+        Date string `json:"date"`
+        Time string `json:"time"`
+    }{}
+    err = json.Unmarshal([]byte(description), &devices)
+    if err != nil || len(devices) == 0 {
+        recording.Close()
+        return nil, fmt.Errorf("the header doesn't describe the device")
+    }
+    names := []string{}
+    for name := range devices {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    device := devices[names[0]]
+    if device.Rate <= 0 {
+        recording.Close()
+        return nil, fmt.Errorf("the header doesn't tell the sample rate")
+    }
+    recording.interval = 1 / device.Rate
+    recording.created, _ = time.ParseInLocation("2006-1-2 15:04:05.000", device.Date + " " + device.Time, time.Local)
+
+    // The labels and the sensors are those of the analog channels, which are the last columns
+    indices := []int{}
+    converters := []func(float64) float64{}
+    for i, label := range device.Labels {
+        column := len(device.Columns) - len(device.Labels) + i
+        if column < 0 || column >= len(device.Resolution) || i >= len(device.Sensors) {
+            recording.Close()
+            return nil, fmt.Errorf("the header doesn't match its channels")
+        }
+        sensor := device.Sensors[i]
+        channel := ForeignChannel{sensor + " " + label, "raw"}
+        convert := func(value float64) float64 {
+            return value
+        }
+        if sensor == "EMG" {
+            channel.Unit = "V"
+            levels := math.Pow(2, float64(device.Resolution[column]))
+            convert = func(value float64) float64 {
+                return (value / levels - 0.5) * bitalinoVCC / bitalinoGain
+            }
+        }
+        recording.channels = append(recording.channels, channel)
+        indices, converters = append(indices, column), append(converters, convert)
+    }
+    recording.parse = func(row []string) ([]float64, error) {
+        values := make([]float64, len(indices))
+        for i, index := range indices {
+            value, err := parseField(row, index)
+            if err != nil {
+                return nil, fmt.Errorf("invalid value %q", row[index])
+            }
+            values[i] = converters[i](value)
+        }
+        return values, nil
+    }
+    return recording, nil
+}
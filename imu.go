@@ -0,0 +1,147 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "encoding/binary"
+)
+
+/*
+ An inertial measurement unit on the I2C bus. It is read once per sample, right after the ADC, so its values are
+ aligned with the muscle activity. Read returns the acceleration (x, y, z in g) followed by the angular velocity
+ (x, y, z in degrees per second).
+ */
+type IMU interface {
+    Read() ([]float64, error)
+    Close() error
+}
+
+/*
+ The channels of an IMU. They are recorded like auxiliary sensors.
+ */
+var IMUChannels = []AuxChannel{
+    {Name: "accel_x", Unit: "g", Scale: 1, Sensor: "imu"},
+    {Name: "accel_y", Unit: "g", Scale: 1, Sensor: "imu"},
+    {Name: "accel_z", Unit: "g", Scale: 1, Sensor: "imu"},
+    {Name: "gyro_x", Unit: "deg/s", Scale: 1, Sensor: "imu"},
+    {Name: "gyro_y", Unit: "deg/s", Scale: 1, Sensor: "imu"},
+    {Name: "gyro_z", Unit: "deg/s", Scale: 1, Sensor: "imu"},
+}
+
+/*
+ Connects to an IMU. Supported are the MPU6050 (default address 0x68) and the LSM6DS3 (default address 0x6A). An
+ address of zero uses the default. Since the ADCPi uses 0x68 and 0x69 by default as well, an MPU6050 requires the
+ address jumpers of the ADCPi to be changed.
+ */
+func OpenIMU(kind string, bus string, address int) (IMU, error) {
+    switch kind {
+    case "mpu6050":
+        if address == 0 {
+            address = 0x68
+        }
+        device, err := OpenI2C(bus, address)
+        if err != nil {
+            return nil, err
+        }
+        imu := &MPU6050{device: device}
+        return imu, imu.init()
+    case "lsm6ds3":
+        if address == 0 {
+            address = 0x6A
+        }
+        device, err := OpenI2C(bus, address)
+        if err != nil {
+            return nil, err
+        }
+        imu := &LSM6DS3{device: device}
+        return imu, imu.init()
+    }
+    return nil, fmt.Errorf("unknown IMU %s", kind)
+}
+
+/*
+ The MPU6050 from InvenSense, configured for a range of ±2g and ±250°/s
+ */
+type MPU6050 struct {
+    device *I2C
+}
+
+func (m *MPU6050) init() error {
+    // Wake up the chip (PWR_MGMT_1), then select the ranges (ACCEL_CONFIG, GYRO_CONFIG)
+    for _, command := range [][2]byte{{0x6B, 0x00}, {0x1C, 0x00}, {0x1B, 0x00}} {
+        err := m.device.WriteRegister(command[0], command[1])
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (m *MPU6050) Read() ([]float64, error) {
+    // Acceleration, temperature and rotation, as big endian values starting at ACCEL_XOUT_H
+    buffer := make([]byte, 14)
+    err := m.device.ReadRegister(0x3B, buffer)
+    if err != nil {
+        return nil, err
+    }
+    values := make([]float64, 6)
+    for i := 0; i < 3; i++ {
+        values[i] = float64(int16(binary.BigEndian.Uint16(buffer[i * 2:]))) / 16384
+        values[i + 3] = float64(int16(binary.BigEndian.Uint16(buffer[8 + i * 2:]))) / 131
+    }
+    return values, nil
+}
+
+func (m *MPU6050) Close() error {
+    return m.device.Close()
+}
+
+/*
+ The LSM6DS3 from STMicroelectronics, configured for 104 Hz, ±2g and ±245°/s
+ */
+type LSM6DS3 struct {
+    device *I2C
+}
+
+func (l *LSM6DS3) init() error {
+    who := make([]byte, 1)
+    err := l.device.ReadRegister(0x0F, who)
+    if err != nil {
+        return err
+    }
+    if who[0] != 0x69 {
+        return fmt.Errorf("the device is not an LSM6DS3 (WHO_AM_I is 0x%02x)", who[0])
+    }
+    // CTRL1_XL and CTRL2_G
+    for _, command := range [][2]byte{{0x10, 0x40}, {0x11, 0x40}} {
+        err := l.device.WriteRegister(command[0], command[1])
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (l *LSM6DS3) Read() ([]float64, error) {
+    // Rotation and acceleration, as little endian values starting at OUTX_L_G
+    buffer := make([]byte, 12)
+    err := l.device.ReadRegister(0x22, buffer)
+    if err != nil {
+        return nil, err
+    }
+    values := make([]float64, 6)
+    for i := 0; i < 3; i++ {
+        values[i + 3] = float64(int16(binary.LittleEndian.Uint16(buffer[i * 2:]))) * 0.00875
+        values[i] = float64(int16(binary.LittleEndian.Uint16(buffer[6 + i * 2:]))) * 0.000061
+    }
+    return values, nil
+}
+
+func (l *LSM6DS3) Close() error {
+    return l.device.Close()
+}
@@ -0,0 +1,222 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "fmt"
+    "flag"
+    "math"
+    "sort"
+    "strings"
+    "strconv"
+)
+
+func init() {
+    Commands["index"] = indexCommand
+}
+
+/*
+ The index of a recording tells where every block of ChecksumBlock rows starts, and the range of the signal in it,
+ so playback can start anywhere in a long recording at once, and an overview of it can be drawn without reading it.
+ The recorder writes it next to the recording (extension .index appended) while recording, "plot index" creates it
+ for older recordings. The index starts with a header, and every block adds a line with where it starts in the
+ unencrypted recording and in the file, the number of its first row, the time of its first and its last row, and the
+ minimum and the maximum of the first column after the time. Blocks of encrypted recordings are the chunks they are
+ sealed in, and have no range, which would reveal the signal; the overview reads it from the decrypted recording.
+ */
+type IndexBlock struct {
+    Offset int64
+    Stored int64
+    Row int
+    Start float64
+    End float64
+    Min float64
+    Max float64
+}
+
+const indexHeader = "Offset;Stored;Row;Start [s];End [s];Min;Max\n"
+
+/*
+ Returns the path of the index of a recording
+ */
+func IndexFile(file string) string {
+    return file + ".index"
+}
+
+/*
+ Formats a block as a line of the index. Blocks without a number in the first column have no range, which is NaN.
+ */
+func (b IndexBlock) String() string {
+    return fmt.Sprintf("%d;%d;%d;%f;%f;%g;%g\n", b.Offset, b.Stored, b.Row, b.Start, b.End, b.Min, b.Max)
+}
+
+/*
+ Extends the range of the block by a value
+ */
+func (b *IndexBlock) Add(value float64) {
+    if math.IsNaN(b.Min) || value < b.Min {
+        b.Min = value
+    }
+    if math.IsNaN(b.Max) || value > b.Max {
+        b.Max = value
+    }
+}
+
+/*
+ Reads the index of a recording. Recordings without an index return none, and are read from the start.
+ */
+func ReadIndex(file string) ([]IndexBlock, error) {
+    data, err := os.ReadFile(IndexFile(file))
+    if os.IsNotExist(err) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    blocks := []IndexBlock{}
+    for _, line := range strings.Split(string(data), "\n")[1:] {
+        fields := strings.Split(line, ";")
+        if len(fields) < 7 {
+            continue
+        }
+        block := IndexBlock{}
+        block.Offset, err = strconv.ParseInt(fields[0], 10, 64)
+        if err == nil {
+            block.Stored, err = strconv.ParseInt(fields[1], 10, 64)
+        }
+        if err == nil {
+            block.Row, err = strconv.Atoi(fields[2])
+        }
+        numbers := []*float64{&block.Start, &block.End, &block.Min, &block.Max}
+        for i := 0; i < len(numbers) && err == nil; i++ {
+            *numbers[i], err = strconv.ParseFloat(fields[3 + i], 64)
+        }
+        if err != nil {
+            return nil, fmt.Errorf("invalid line %q in the index", line)
+        }
+        blocks = append(blocks, block)
+    }
+    return blocks, nil
+}
+
+/*
+ Returns the block that contains the given time: the last one that starts before it, or the first one
+ */
+func FindBlock(index []IndexBlock, time float64) int {
+    i := sort.Search(len(index), func(i int) bool {
+        return index[i].Start > time
+    })
+    if i == 0 {
+        return 0
+    }
+    return i - 1
+}
+
+/*
+ Creates the index of a recording by reading it. Blocks of unencrypted recordings have ChecksumBlock rows, like the
+ recorder writes them, and those of encrypted recordings are their chunks.
+ */
+func BuildIndex(file string) ([]IndexBlock, error) {
+    meta, err := LoadMetadata(file)
+    if err != nil {
+        return nil, err
+    }
+    var starts, lengths []int64
+    if meta.Encrypted {
+        starts, lengths, err = encryptedChunks(file)
+        if err != nil {
+            return nil, err
+        }
+    }
+    scanner, err := ScanRecording(file)
+    if err != nil {
+        return nil, err
+    }
+    defer scanner.Close()
+
+    blocks := []IndexBlock{}
+    chunk, end := -1, int64(0)
+    for scanner.Scan() {
+        // Rows start with a newline, which decides the block they belong to
+        newline := scanner.Offset() - 1
+        offset, stored := newline, newline
+        next := len(blocks) == 0
+        if meta.Encrypted {
+            for chunk < len(lengths) - 1 && newline >= end {
+                chunk++
+                end += lengths[chunk]
+            }
+            if chunk > len(blocks) {
+                return nil, fmt.Errorf("chunk %d of the recording has no rows, it can't be indexed", len(blocks))
+            }
+            next = chunk == len(blocks)
+            offset, stored = end - lengths[chunk], starts[chunk]
+        } else if next {
+            offset, stored = 0, 0
+        } else {
+            next = scanner.Row() - blocks[len(blocks) - 1].Row == ChecksumBlock
+        }
+        t := scanner.Values()[0]
+        if next {
+            blocks = append(blocks, IndexBlock{offset, stored, scanner.Row(), t, t, math.NaN(), math.NaN()})
+        }
+        block := &blocks[len(blocks) - 1]
+        block.End = t
+        if len(scanner.Header) > 1 && !meta.Encrypted {
+            block.Add(scanner.Values()[1])
+        }
+    }
+    return blocks, scanner.Err()
+}
+
+/*
+ Writes the index of a recording
+ */
+func WriteIndex(file string, blocks []IndexBlock) error {
+    lines := []string{indexHeader}
+    for _, block := range blocks {
+        lines = append(lines, block.String())
+    }
+    return os.WriteFile(IndexFile(file), []byte(strings.Join(lines, "")), 0644)
+}
+
+/*
+ Creates the index and the rollups (see Rollup) of recordings that were made before the recorder wrote them, or whose
+ index was lost. Recordings that are played from a later point (plot play --start=...) start there at once with an
+ index, and are read up to it without one.
+ Example:
+    $ plot index data.csv
+ */
+func indexCommand(args []string) {
+    flags := flag.NewFlagSet("index", flag.ExitOnError)
+    flags.StringVar(&(Settings.KeyFile), "key", "", "The key file for encrypted recordings")
+    jobs := flags.Int("jobs", defaultJobs, "How many recordings are indexed at the same time")
+    flags.Parse(args)
+    if flags.NArg() == 0 {
+        fail("Usage: plot index [--key=file] [--jobs=n] <file>...")
+    }
+    files := flags.Args()
+    err := ProcessFiles(files, *jobs, "Indexed", func(i int) (string, error) {
+        blocks, err := BuildIndex(files[i])
+        if err != nil {
+            return "", err
+        }
+        err = WriteIndex(files[i], blocks)
+        if err != nil {
+            return "", err
+        }
+        err = WriteRollups(files[i])
+        if err != nil {
+            return "", err
+        }
+        return fmt.Sprintf("%s: %d blocks indexed into %s\n", files[i], len(blocks), IndexFile(files[i])), nil
+    })
+    if err != nil {
+        fail("%v", err)
+    }
+}
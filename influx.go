@@ -0,0 +1,166 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+//go:build !nonet
+
+package main
+
+import (
+    "io"
+    "fmt"
+    "time"
+    "bytes"
+    "strings"
+    "strconv"
+    "net/url"
+    "net/http"
+)
+
+func init() {
+    Sinks["influx"] = openInfluxSink
+    Sinks["influxs"] = openInfluxSink
+    SinkBackends["influx"] = Backend{
+        Usage: "influx://[user:password@]<host>:8086/<database>?measurement=emg",
+        Description: "Writes the session into InfluxDB",
+        Probe: func(target *url.URL) error { return probeHost(target, "8086") },
+    }
+    SinkBackends["influxs"] = Backend{
+        Usage: "influxs://[user:password@]<host>:8086/<database>?measurement=emg&ca=ca.pem",
+        Description: "Writes the session into InfluxDB over HTTPS",
+        Probe: func(target *url.URL) error { return probeHost(target, "8086") },
+    }
+}
+
+/*
+ How many lines are sent to InfluxDB at once, and how long they may wait
+ */
+const influxBatch = 5000
+const influxDelay = time.Second
+
+/*
+ Writes the session into InfluxDB (the HTTP API of version 1), so it can be shown in dashboards like Grafana:
+    influx://[user:password@]host:8086/database[?measurement=emg]
+ The samples, events and segments are written into the measurements emg, emg_events and emg_segments, tagged with
+ the subject. Their timestamps are the start of the session plus the time of the sample, so old recordings end up at
+ the time they were recorded. influxs:// uses HTTPS, see DialURL for the certificates.
+ */
+type InfluxSink struct {
+    endpoint string
+    measurement string
+    tags string
+    created time.Time
+    lines bytes.Buffer
+    count int
+    flushed time.Time
+    client *http.Client
+}
+
+func openInfluxSink(target *url.URL) (Sink, error) {
+    database := strings.Trim(target.Path, "/")
+    if database == "" {
+        return nil, fmt.Errorf("expected influx://host:port/database")
+    }
+    query := url.Values{"db": {database}, "precision": {"ns"}}
+    if target.User != nil {
+        query.Set("u", target.User.Username())
+        password, _ := target.User.Password()
+        query.Set("p", password)
+    }
+    measurement := target.Query().Get("measurement")
+    if measurement == "" {
+        measurement = "emg"
+    }
+    scheme := "http"
+    client := &http.Client{Timeout: 10 * time.Second}
+    if target.Scheme == "influxs" {
+        config, err := ClientTLS(target)
+        if err != nil {
+            return nil, err
+        }
+        scheme = "https"
+        client.Transport = &http.Transport{TLSClientConfig: config}
+    }
+    return &InfluxSink{endpoint: scheme + "://" + target.Host + "/write?" + query.Encode(), measurement: measurement,
+        flushed: time.Now(), client: client}, nil
+}
+
+func (s *InfluxSink) Write(message interface{}) error {
+    switch m := message.(type) {
+    case SessionMessage:
+        s.created = m.Created
+        if m.Subject != "" {
+            s.tags = ",subject=" + influxEscape(m.Subject)
+        }
+        return nil
+    case SampleMessage:
+        fields := []string{"value=" + influxFloat(m.Value)}
+        for name, value := range m.Stages {
+            fields = append(fields, influxEscape(name) + "=" + influxFloat(value))
+        }
+        for name, value := range m.Aux {
+            fields = append(fields, influxEscape(name) + "=" + influxFloat(value))
+        }
+        if m.HeartRate > 0 {
+            fields = append(fields, "heart_rate=" + influxFloat(m.HeartRate))
+        }
+        s.line(s.measurement + s.tags, fields, m.Time)
+    case EventMessage:
+        s.line(s.measurement + "_events" + s.tags, []string{"event=" + strconv.Quote(m.Event)}, m.Time)
+    case SegmentMessage:
+        fields := []string{"iemg=" + influxFloat(m.IEMG), "duration=" + influxFloat(m.End - m.Start)}
+        if m.CoContraction != 0 {
+            fields = append(fields, "cocontraction=" + influxFloat(m.CoContraction))
+        }
+        s.line(s.measurement + "_segments" + s.tags + ",segment=" + influxEscape(m.Name), fields, m.Start)
+    }
+    if s.count >= influxBatch || time.Since(s.flushed) > influxDelay {
+        return s.flush()
+    }
+    return nil
+}
+
+func (s *InfluxSink) Close() error {
+    return s.flush()
+}
+
+func (s *InfluxSink) line(series string, fields []string, seconds float64) {
+    timestamp := s.created.Add(time.Duration(seconds * 1000 * 1000 * 1000)).UnixNano()
+    fmt.Fprintf(&s.lines, "%s %s %d\n", series, strings.Join(fields, ","), timestamp)
+    s.count++
+}
+
+/*
+ Sends the waiting lines to the database
+ */
+func (s *InfluxSink) flush() error {
+    s.flushed = time.Now()
+    if s.count == 0 {
+        return nil
+    }
+    response, err := s.client.Post(s.endpoint, "text/plain", bytes.NewReader(s.lines.Bytes()))
+    s.lines.Reset()
+    s.count = 0
+    if err != nil {
+        return err
+    }
+    defer response.Body.Close()
+    if response.StatusCode / 100 != 2 {
+        body, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+        return fmt.Errorf("influx: %s: %s", response.Status, strings.TrimSpace(string(body)))
+    }
+    return nil
+}
+
+func influxFloat(value float64) string {
+    return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+/*
+ Escapes the characters that separate tags and fields in the line protocol
+ */
+func influxEscape(s string) string {
+    return strings.NewReplacer(",", "\\,", "=", "\\=", " ", "\\ ").Replace(s)
+}
@@ -0,0 +1,239 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "fmt"
+    "flag"
+    "time"
+    "bufio"
+    "strings"
+    "strconv"
+)
+
+func init() {
+    Commands["init"] = initCommand
+}
+
+/*
+ The sample rates that are offered, which the ADCPi can reach with one channel
+ */
+var initRates = []float64{10, 20, 60, 120, 240}
+
+/*
+ Walks through setting up a device: finds the ADCPi, helps choosing the channel of the muscle sensor and the sample
+ rate, tests the quality of the signal and writes the result into the configuration (see Config), so "plot" works
+ without any options afterwards. Every question has a default, which is taken by pressing enter.
+ Example:
+    $ sudo plot init
+    Looking for the ADCPi on /dev/i2c-1 ...
+    Found a device at 0x68, 0x69.
+    Which address belongs to the ADCPi? [0x68]
+    ...
+    The settings were saved in /root/.config/plot/config.json. Start plot to see the muscle activity.
+ */
+func initCommand(args []string) {
+    flags := flag.NewFlagSet("init", flag.ExitOnError)
+    file := flags.String("config", DefaultConfigFile(), "The configuration file that is written")
+    bus := flags.String("bus", "/dev/i2c-1", "The I2C bus that the ADCPi is connected to")
+    seconds := flags.Float64("test", 10, "How many seconds the quality of the signal is tested")
+    flags.Parse(args)
+    input := bufio.NewReader(os.Stdin)
+
+    // The two chips of an ADCPi answer on two addresses, which are set by its jumpers
+    fmt.Printf("Looking for the ADCPi on %s ...\n", *bus)
+    found := []int{}
+    for address := 0x68; address <= 0x6F; address++ {
+        if probeI2C(*bus, address) == nil {
+            found = append(found, address)
+        }
+    }
+    if len(found) == 0 {
+        fail("No ADCPi was found. Check that it sits firmly on the pins of the Pi, that I2C is enabled (sudo " +
+            "raspi-config, Interface Options) and that plot runs as root or as a member of the group i2c.")
+    }
+    names := []string{}
+    for _, address := range found {
+        names = append(names, fmt.Sprintf("0x%02x", address))
+    }
+    fmt.Printf("Found a device at %s.\n", strings.Join(names, ", "))
+    address := found[0]
+    if len(found) > 1 {
+        answer := ask(input, "Which address belongs to the ADCPi", names[0])
+        a, err := strconv.ParseInt(answer, 0, 32)
+        if err != nil {
+            fail("invalid address %q", answer)
+        }
+        address = int(a)
+    }
+
+    // Show how much every channel moves, so the one with the electrodes stands out when the muscle is tensed
+    fmt.Println()
+    fmt.Println("Attach the electrodes, and tense and relax the muscle a few times while the channels are measured.")
+    ask(input, "Press enter to start", "")
+    channels := []int{1, 2, 3, 4}
+    if probeI2C(*bus, address + 1) == nil {
+        channels = append(channels, 5, 6, 7, 8)
+    }
+    means, deviations, err := initScan(*bus, address, channels, 5 * time.Second)
+    if err != nil {
+        fail("%v", err)
+    }
+    busiest := 0
+    for i, channel := range channels {
+        fmt.Printf("Channel %d: %s on average, varies by %s\n", channel, AutoUnit.Format(means[i]),
+            AutoUnit.Format(deviations[i]))
+        if deviations[i] > deviations[busiest] {
+            busiest = i
+        }
+    }
+    answer := ask(input, "Which channel is the muscle sensor connected to", strconv.Itoa(channels[busiest]))
+    channel, err := strconv.Atoi(answer)
+    if err != nil || channel < 1 || channel > channels[len(channels) - 1] {
+        fail("invalid channel %q", answer)
+    }
+
+    // Faster rates show more detail, slower ones have a higher resolution and less noise
+    fmt.Println()
+    fmt.Println("How many samples per second should be taken? More show faster changes, fewer have less noise.")
+    rates := []string{}
+    for _, rate := range initRates {
+        rates = append(rates, fmt.Sprintf("%g", rate))
+    }
+    answer = ask(input, "Samples per second (" + strings.Join(rates, ", ") + ")", "10")
+    rate, err := strconv.ParseFloat(answer, 64)
+    if err != nil || rate <= 0 {
+        fail("invalid sample rate %q", answer)
+    }
+    adc, err := NewMCP3424(*bus, address, []int{channel}, 1 / rate)
+    if err != nil {
+        fail("%v", err)
+    }
+    interval := adc.Interval
+    fmt.Printf("The ADCPi takes %g samples per second with %d bit.\n", 1 / interval, adc.resolution)
+
+    // Test the signal like a recording is tested when it is finished
+    fmt.Println()
+    fmt.Printf("Now the quality of the signal is tested for %g seconds. Relax the muscle and tense it once.\n",
+        *seconds)
+    ask(input, "Press enter to start", "")
+    meter := NewQualityMeter(interval, 2.048 * adcpiDivider * 0.999, false)
+    adc.Timeout = time.Second
+    for i := 0; float64(i) * interval < *seconds; i++ {
+        values, err := adc.Read()
+        if err != nil {
+            fail("%v", err)
+        }
+        meter.Add(float64(i) * interval, values[0])
+    }
+    adc.Close()
+    quality := meter.Result()
+    fmt.Printf("Quality: %s\n", quality)
+    for _, advice := range initAdvice(quality) {
+        fmt.Println("  " + advice)
+    }
+    if quality.Low() && !strings.HasPrefix(strings.ToLower(ask(input, "Save the settings anyway (y/n)", "n")),
+        "y") {
+        fmt.Println("Nothing was saved. Fix the setup and run plot init again.")
+        os.Exit(1)
+    }
+
+    // Keep the rest of the configuration, like the keys
+    config, err := LoadConfig(*file, false)
+    if err != nil {
+        fail("%s: %v", *file, err)
+    }
+    if config.Options == nil {
+        config.Options = Profile{}
+    }
+    config.Options["bus"] = *bus
+    config.Options["address"] = fmt.Sprintf("0x%02x", address)
+    config.Options["channel"] = strconv.Itoa(channel)
+    config.Options["interval"] = strconv.FormatFloat(interval, 'g', -1, 64)
+    err = SaveConfig(*file, config)
+    if err != nil {
+        fail("%v", err)
+    }
+    fmt.Println()
+    fmt.Printf("The settings were saved in %s. Start plot to see the muscle activity.\n", *file)
+}
+
+/*
+ Asks a question and returns the answer, or the default if the answer is empty
+ */
+func ask(input *bufio.Reader, question string, defaultAnswer string) string {
+    if defaultAnswer != "" {
+        fmt.Printf("%s? [%s] ", question, defaultAnswer)
+    } else {
+        fmt.Printf("%s ", question)
+    }
+    line, err := input.ReadString('\n')
+    if err != nil && line == "" {
+        fail("\nThe setup was cancelled.")
+    }
+    line = strings.TrimSpace(line)
+    if line == "" {
+        return defaultAnswer
+    }
+    return line
+}
+
+/*
+ Measures the channels as fast as possible for a while, and returns the mean of each of them and how much it varies
+ around it (the RMS without the mean)
+ */
+func initScan(bus string, address int, channels []int, duration time.Duration) ([]float64, []float64, error) {
+    adc, err := NewMCP3424(bus, address, channels, float64(len(channels)) / mcp3424Rates[12])
+    if err != nil {
+        return nil, nil, err
+    }
+    defer adc.Close()
+    adc.Timeout = time.Second
+    values := make([][]float64, len(channels))
+    for start := time.Now(); time.Since(start) < duration; {
+        voltages, err := adc.Read()
+        if err != nil {
+            return nil, nil, err
+        }
+        for i, v := range voltages {
+            values[i] = append(values[i], v)
+        }
+    }
+    means := make([]float64, len(channels))
+    deviations := make([]float64, len(channels))
+    for i := range channels {
+        means[i] = Calculate(values[i]).Mean
+        deviations[i] = Calculate(removeMean(values[i])).RMS
+    }
+    return means, deviations, nil
+}
+
+/*
+ Explains what the problems of the signal mean and how they can be fixed
+ */
+func initAdvice(quality Quality) []string {
+    advice := []string{}
+    if quality.Clipping > 0 {
+        advice = append(advice, "The signal reaches the limits of the ADC. Lower the gain of the muscle sensor.")
+    }
+    if quality.Dropped > 0 {
+        advice = append(advice, "Samples were missing. Choose fewer samples per second.")
+    }
+    if quality.Hum > 0 && quality.Hum > quality.NoiseFloor {
+        advice = append(advice, "There is mains hum. Check the reference electrode, and keep the cables away " +
+            "from power cords.")
+    }
+    if quality.Score < 100 && len(advice) == 0 {
+        advice = append(advice, "The signal hardly rises above the noise. Check that the electrodes stick well " +
+            "and sit on the belly of the muscle.")
+    }
+    if quality.Low() {
+        advice = append(advice, "The quality is too low for reliable measurements.")
+    }
+    return advice
+}
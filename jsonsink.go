@@ -0,0 +1,116 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "io"
+    "os"
+    "bufio"
+    "net/url"
+    "path/filepath"
+    "encoding/json"
+)
+
+func init() {
+    Sinks["file"] = openFileSink
+    SinkBackends["file"] = Backend{
+        Usage: "file:<path>[?format=arrow], file:- for the standard output",
+        Description: "Appends the session to a file as lines of JSON",
+        Probe: probeFile,
+    }
+}
+
+/*
+ Writes every message as one line of JSON. This is the format of the file sink, and of the network sinks (see
+ openNetworkSink):
+    file:stream.jsonl   Appends to a file, file:- writes to the standard output
+ With ?format=arrow, the samples are written in the Arrow stream format instead (see ArrowSink).
+ */
+type JSONSink struct {
+    output io.WriteCloser
+    buffer *bufio.Writer
+    encoder *json.Encoder
+}
+
+func NewJSONSink(output io.WriteCloser, buffered bool) *JSONSink {
+    sink := &JSONSink{output: output}
+    if buffered {
+        sink.buffer = bufio.NewWriter(output)
+        sink.encoder = json.NewEncoder(sink.buffer)
+    } else {
+        sink.encoder = json.NewEncoder(output)
+    }
+    return sink
+}
+
+func (s *JSONSink) Write(message interface{}) error {
+    return s.encoder.Encode(message)
+}
+
+func (s *JSONSink) Close() error {
+    if s.buffer != nil {
+        err := s.buffer.Flush()
+        if err != nil {
+            s.output.Close()
+            return err
+        }
+    }
+    return s.output.Close()
+}
+
+func openFileSink(target *url.URL) (Sink, error) {
+    path := target.Opaque
+    if path == "" {
+        path = target.Path
+    }
+    if path == "-" {
+        return NewFormatSink(nopCloser{os.Stdout}, false, target)
+    }
+    file, err := os.OpenFile(path, os.O_CREATE | os.O_WRONLY | os.O_APPEND, 0600)
+    if err != nil {
+        return nil, err
+    }
+    return NewFormatSink(file, true, target)
+}
+
+/*
+ A writer that is never closed, like the standard output
+ */
+type nopCloser struct {
+    io.Writer
+}
+
+func (nopCloser) Close() error {
+    return nil
+}
+
+/*
+ Checks whether the file of a file sink can be written
+ */
+func probeFile(target *url.URL) error {
+    if target == nil {
+        return nil
+    }
+    path := target.Opaque
+    if path == "" {
+        path = target.Path
+    }
+    if path == "-" {
+        return nil
+    }
+    file, err := os.OpenFile(path, os.O_WRONLY | os.O_APPEND, 0)
+    if os.IsNotExist(err) {
+        file, err = os.CreateTemp(filepath.Dir(path), ".probe")
+        if err == nil {
+            defer os.Remove(file.Name())
+        }
+    }
+    if err != nil {
+        return err
+    }
+    return file.Close()
+}
@@ -0,0 +1,113 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "bufio"
+    "os/exec"
+)
+
+/*
+ The special keys, which the terminal sends as escape sequences (like ESC [ A), are returned as these runes from the
+ private use area of Unicode
+ */
+const (
+    KeyUp = 0xE000 + iota
+    KeyDown
+    KeyRight
+    KeyLeft
+    KeyHome
+    KeyEnd
+    KeyInsert
+    KeyDelete
+    KeyPageUp
+    KeyPageDown
+    KeyF1
+)
+
+/*
+ The escape sequences of the special keys (after the ESC), as sent by xterm and the Linux console. Foot pedals and
+ other programmable keyboards often send the function keys above F12.
+ */
+var escapeSequences = map[string]rune{
+    "[A": KeyUp, "[B": KeyDown, "[C": KeyRight, "[D": KeyLeft, "[H": KeyHome, "[F": KeyEnd, "OH": KeyHome,
+    "OF": KeyEnd, "[1~": KeyHome, "[2~": KeyInsert, "[3~": KeyDelete, "[4~": KeyEnd, "[5~": KeyPageUp,
+    "[6~": KeyPageDown, "OP": KeyF1, "OQ": KeyF1 + 1, "OR": KeyF1 + 2, "OS": KeyF1 + 3, "[[A": KeyF1,
+    "[[B": KeyF1 + 1, "[[C": KeyF1 + 2, "[[D": KeyF1 + 3, "[[E": KeyF1 + 4, "[11~": KeyF1, "[12~": KeyF1 + 1,
+    "[13~": KeyF1 + 2, "[14~": KeyF1 + 3, "[15~": KeyF1 + 4, "[17~": KeyF1 + 5, "[18~": KeyF1 + 6,
+    "[19~": KeyF1 + 7, "[20~": KeyF1 + 8, "[21~": KeyF1 + 9, "[23~": KeyF1 + 10, "[24~": KeyF1 + 11,
+    "[25~": KeyF1 + 12, "[26~": KeyF1 + 13, "[28~": KeyF1 + 14, "[29~": KeyF1 + 15, "[31~": KeyF1 + 16,
+    "[32~": KeyF1 + 17, "[33~": KeyF1 + 18, "[34~": KeyF1 + 19,
+}
+
+/*
+ Switches the terminal into a mode where key presses are delivered immediately instead of line by line, and without
+ echoing them, and returns the keys that are pressed. If the input is not a terminal, no keys are ever returned. The
+ terminal has to be restored with RestoreTerminal before the program exits.
+ */
+func ReadKeys() <-chan rune {
+    keys := make(chan rune, 16)
+    err := stty("-icanon", "-echo", "min", "1")
+    if err != nil {
+        return keys
+    }
+
+    go func() {
+        reader := bufio.NewReader(os.Stdin)
+        for true {
+            key, _, err := reader.ReadRune()
+            if err != nil {
+                return
+            }
+
+            // The terminal sends the sequence of a special key at once, so a lone escape stays a key of its own
+            if key == 0x1B && reader.Buffered() >= 2 {
+                key = readSequence(reader)
+            }
+            keys <- key
+        }
+    }()
+    return keys
+}
+
+/*
+ Reads the escape sequence of a special key that follows an ESC. An unknown sequence is left alone and returned as
+ the keys it consists of.
+ */
+func readSequence(reader *bufio.Reader) rune {
+    buffered, _ := reader.Peek(reader.Buffered())
+    if buffered[0] != '[' && buffered[0] != 'O' {
+        return 0x1B
+    }
+
+    // The sequence ends with a letter or ~, after any digits, semicolons and the [ of the Linux console
+    for i := 1; i < len(buffered); i++ {
+        if (buffered[i] >= '0' && buffered[i] <= '9') || buffered[i] == ';' || (i == 1 && buffered[i] == '[') {
+            continue
+        }
+        if key, ok := escapeSequences[string(buffered[:i + 1])]; ok {
+            reader.Discard(i + 1)
+            return key
+        }
+        break
+    }
+    return 0x1B
+}
+
+/*
+ Switches the terminal back into line mode
+ */
+func RestoreTerminal() {
+    stty("icanon", "echo")
+}
+
+func stty(args ...string) error {
+    command := exec.Command("stty", args...)
+    command.Stdin = os.Stdin
+    return command.Run()
+}
@@ -0,0 +1,115 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "sort"
+    "sync"
+    "time"
+)
+
+/*
+ How many of the latest latencies of every stage are kept for the percentiles
+ */
+const latencyWindow = 1024
+
+/*
+ For biofeedback, a delay above this becomes perceptible
+ */
+const perceptibleLatency = 100 * time.Millisecond
+
+/*
+ The stages of the pipeline whose latency is measured, from the acquisition of a sample to the time when
+    process   its processing stages were applied
+    record    it was written into the recording
+    sink      it was written into the sinks of a stream
+    display   it was drawn on the display
+ */
+const (
+    LatencyProcess = "process"
+    LatencyRecord = "record"
+    LatencySink = "sink"
+    LatencyDisplay = "display"
+)
+
+var LatencyStages = []string{LatencyProcess, LatencyRecord, LatencySink, LatencyDisplay}
+
+/*
+ The percentiles of the latency of a stage
+ */
+type LatencyPercentiles struct {
+    P50 time.Duration
+    P95 time.Duration
+    P99 time.Duration
+    Max time.Duration
+}
+
+/*
+ Measures how long samples take from their acquisition through the stages of the pipeline. Every stage keeps its
+ latest latencies, so the percentiles follow the current state of the system. This can be used from any goroutine.
+ */
+type LatencyTracker struct {
+    lock sync.Mutex
+    stages map[string]*latencyRing
+}
+
+type latencyRing struct {
+    values []time.Duration
+    next int
+}
+
+func NewLatencyTracker() *LatencyTracker {
+    return &LatencyTracker{stages: map[string]*latencyRing{}}
+}
+
+/*
+ Notes that a sample that was acquired at the given time has passed a stage
+ */
+func (l *LatencyTracker) Observe(stage string, acquired time.Time) {
+    if l == nil || acquired.IsZero() {
+        return
+    }
+    latency := time.Since(acquired)
+    l.lock.Lock()
+    defer l.lock.Unlock()
+    ring, ok := l.stages[stage]
+    if !ok {
+        ring = &latencyRing{values: make([]time.Duration, 0, latencyWindow)}
+        l.stages[stage] = ring
+    }
+    if len(ring.values) < latencyWindow {
+        ring.values = append(ring.values, latency)
+        return
+    }
+    ring.values[ring.next] = latency
+    ring.next = (ring.next + 1) % latencyWindow
+}
+
+/*
+ Returns the percentiles of the latest latencies of a stage, and whether any sample has passed it yet
+ */
+func (l *LatencyTracker) Percentiles(stage string) (LatencyPercentiles, bool) {
+    l.lock.Lock()
+    ring, ok := l.stages[stage]
+    sorted := []float64{}
+    if ok {
+        for _, value := range ring.values {
+            sorted = append(sorted, float64(value))
+        }
+    }
+    l.lock.Unlock()
+    if len(sorted) == 0 {
+        return LatencyPercentiles{}, false
+    }
+    sort.Float64s(sorted)
+    return LatencyPercentiles{
+        P50: time.Duration(percentile(sorted, 50)),
+        P95: time.Duration(percentile(sorted, 95)),
+        P99: time.Duration(percentile(sorted, 99)),
+        Max: time.Duration(sorted[len(sorted) - 1]),
+    }, true
+}
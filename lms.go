@@ -0,0 +1,123 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "math"
+    "strings"
+    "strconv"
+)
+
+/*
+ A filter that needs the auxiliary sensors of the sample, in addition to the value
+ */
+type ReferenceFilter interface {
+    Filter
+    SetReference(aux []float64)
+}
+
+/*
+ Removes interference that can't be notched out, like mains hum with changing harmonics or the pickup of nearby
+ machines. An auxiliary channel records only the interference (an electrode on bone, or a pickup loop), and a
+ normalized LMS filter continuously learns how the interference reaches the muscle signal and subtracts it. The
+ muscle activity doesn't correlate with the reference, so it stays in the signal.
+ */
+type LMSCanceller struct {
+
+    /*
+     The auxiliary sensor with the reference
+     */
+    Channel int
+
+    /*
+     How fast the filter adapts, between 0 and 2. Faster adaption follows changing interference, but is noisier.
+     */
+    Step float64
+
+    // The weights, and the last samples of the reference without their mean
+    weights []float64
+    history []float64
+    mean float64
+    started bool
+}
+
+/*
+ How quickly the mean of the reference is followed
+ */
+const lmsMeanRate = 0.001
+
+/*
+ Creates the canceller from a description like "pickup:32:0.05", which is the name of the auxiliary sensor with the
+ reference, the amount of weights (taps) and the step size. Returns nil if no reference is given.
+ */
+func ParseCanceller(description string, aux AuxChannels) (*LMSCanceller, error) {
+    if description == "" {
+        return nil, nil
+    }
+    parts := strings.Split(description, ":")
+    if len(parts) > 3 {
+        return nil, fmt.Errorf("expected name[:taps[:step]] for the reference, got %s", description)
+    }
+    canceller := &LMSCanceller{Channel: -1, Step: 0.05}
+    for i, channel := range aux {
+        if channel.Name == parts[0] {
+            canceller.Channel = i
+        }
+    }
+    if canceller.Channel < 0 {
+        return nil, fmt.Errorf("there is no auxiliary sensor called %s", parts[0])
+    }
+    taps := 32
+    if len(parts) > 1 {
+        t, err := strconv.Atoi(parts[1])
+        if err != nil || t < 1 {
+            return nil, fmt.Errorf("invalid amount of taps for the reference: %s", parts[1])
+        }
+        taps = t
+    }
+    if len(parts) > 2 {
+        step, err := strconv.ParseFloat(parts[2], 64)
+        if err != nil || step <= 0 || step >= 2 {
+            return nil, fmt.Errorf("the step of the reference must be between 0 and 2, got %s", parts[2])
+        }
+        canceller.Step = step
+    }
+    canceller.weights = make([]float64, taps)
+    canceller.history = make([]float64, taps)
+    return canceller, nil
+}
+
+func (l *LMSCanceller) SetReference(aux []float64) {
+    reference := l.mean
+    if l.Channel < len(aux) && !math.IsNaN(aux[l.Channel]) {
+        reference = aux[l.Channel]
+    }
+    if !l.started {
+        l.mean = reference
+        l.started = true
+    }
+    l.mean += lmsMeanRate * (reference - l.mean)
+    copy(l.history[1:], l.history)
+    l.history[0] = reference - l.mean
+}
+
+func (l *LMSCanceller) Process(x float64) float64 {
+    estimate := float64(0)
+    power := float64(0)
+    for i, r := range l.history {
+        estimate += l.weights[i] * r
+        power += r * r
+    }
+    e := x - estimate
+    if power > 0 {
+        for i, r := range l.history {
+            l.weights[i] += l.Step * e * r / power
+        }
+    }
+    return e
+}
@@ -0,0 +1,104 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "math"
+    "sync"
+    "strings"
+    "strconv"
+)
+
+/*
+ A region of a recording that the playback repeats, for tuning the processing against one artifact. It is set with
+ --loop or with the l key, which marks its start and then its end at the newest sample (or loops the selection of the
+ frozen display), and clears it. Every repetition is processed like the first one: the processing stages start over
+ at the start of the region, which starts a new segment named "loop". This can be called from any goroutine.
+ */
+type PlaybackLoop struct {
+    lock sync.Mutex
+    in float64
+    out float64
+    marked int
+}
+
+/*
+ Parses a region given as from:to in seconds of the recording
+ */
+func ParseLoop(description string) (float64, float64, error) {
+    parts := strings.Split(description, ":")
+    if len(parts) != 2 {
+        return 0, 0, fmt.Errorf("expected from:to in seconds, got %s", description)
+    }
+    in, err := strconv.ParseFloat(parts[0], 64)
+    if err != nil {
+        return 0, 0, fmt.Errorf("invalid start of the loop: %v", err)
+    }
+    out, err := strconv.ParseFloat(parts[1], 64)
+    if err != nil {
+        return 0, 0, fmt.Errorf("invalid end of the loop: %v", err)
+    }
+    if out <= in {
+        return 0, 0, fmt.Errorf("the loop has to end after it starts")
+    }
+    return in, out, nil
+}
+
+/*
+ Repeats the region from in to out
+ */
+func (l *PlaybackLoop) Set(in float64, out float64) {
+    l.lock.Lock()
+    defer l.lock.Unlock()
+    l.in, l.out, l.marked = in, out, 2
+}
+
+/*
+ Marks the start of the region, then its end, and clears it, one after the other. An end before the start swaps them.
+ */
+func (l *PlaybackLoop) Mark(time float64) {
+    l.lock.Lock()
+    defer l.lock.Unlock()
+    switch l.marked {
+    case 0:
+        l.in = time
+        l.marked = 1
+    case 1:
+        l.in, l.out = math.Min(l.in, time), math.Max(l.in, time)
+        l.marked = 2
+        if l.out == l.in {
+            l.marked = 1
+        }
+    default:
+        l.marked = 0
+    }
+}
+
+/*
+ Returns the region that is repeated, and false if there is none
+ */
+func (l *PlaybackLoop) Region() (float64, float64, bool) {
+    l.lock.Lock()
+    defer l.lock.Unlock()
+    return l.in, l.out, l.marked == 2
+}
+
+/*
+ Describes the loop for the display, or returns an empty string if there is none
+ */
+func (l *PlaybackLoop) String() string {
+    l.lock.Lock()
+    defer l.lock.Unlock()
+    switch l.marked {
+    case 1:
+        return fmt.Sprintf("Loop from %.3f s", l.in)
+    case 2:
+        return fmt.Sprintf("Loop %.3f-%.3f s", l.in, l.out)
+    }
+    return ""
+}
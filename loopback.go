@@ -0,0 +1,258 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "fmt"
+    "flag"
+    "sort"
+    "time"
+    "strings"
+    "strconv"
+)
+
+func init() {
+    Commands["loopback"] = loopbackCommand
+}
+
+/*
+ The levels below this difference between high and low can't be told apart from noise
+ */
+const loopbackMinimumSwing = 0.2
+
+/*
+ An output that generates the test pattern: a GPIO pin or a DAC that switches between a low and a high level
+ */
+type PatternOutput interface {
+    Set(high bool) error
+    Close() error
+}
+
+/*
+ Opens the output of the test pattern:
+    gpio:<pin>               A GPIO pin of the Pi (BCM numbering), switching between 0 V and 3.3 V
+    mcp4725[:<address>]      An MCP4725 DAC on the I2C bus (default address 0x60), switching between 0 V and 2 V
+ */
+func OpenPatternOutput(description string, chip string, bus string) (PatternOutput, error) {
+    kind, argument, _ := strings.Cut(description, ":")
+    switch kind {
+    case "gpio":
+        pin, err := strconv.Atoi(argument)
+        if err != nil {
+            return nil, fmt.Errorf("invalid GPIO pin %q", argument)
+        }
+        return OpenGPIO(chip, pin)
+    case "mcp4725":
+        address := int64(0x60)
+        if argument != "" {
+            a, err := strconv.ParseInt(argument, 0, 32)
+            if err != nil {
+                return nil, fmt.Errorf("invalid I2C address %q", argument)
+            }
+            address = a
+        }
+        dac, err := OpenMCP4725(bus, int(address), 3.3)
+        if err != nil {
+            return nil, err
+        }
+
+        // The DAC centers its output (see MCP4725.Write), so 0 V is written as half the reference below zero
+        pattern := &DACPattern{dac: dac, low: -1.65, high: 2.0 - 1.65}
+        return pattern, pattern.Set(false)
+    }
+    return nil, fmt.Errorf("unknown output %s, expected gpio:<pin> or mcp4725[:<address>]", kind)
+}
+
+/*
+ A test pattern on a DAC, which switches between two voltages
+ */
+type DACPattern struct {
+    dac DAC
+    low float64
+    high float64
+}
+
+func (d *DACPattern) Set(high bool) error {
+    if high {
+        return d.dac.Write(d.high)
+    }
+    return d.dac.Write(d.low)
+}
+
+func (d *DACPattern) Close() error {
+    return d.dac.Close()
+}
+
+/*
+ Switches a GPIO pin or a DAC between two levels while the ADC reads channels that it is wired to, and checks that
+ every edge arrives on every channel. The time from switching the output to the first conversion that is past the
+ middle between the levels is the latency of the whole rig, including the conversion of the ADC. The differences
+ between the channels are their skew, since the MCP3424 converts its channels one after another.
+ Example:
+    $ plot loopback --output=gpio:17 --channels=1,2 --edges=20
+    Channel 1: low 0.004 V, high 3.287 V, threshold 1.646 V
+    Channel 2: low 0.003 V, high 3.285 V, threshold 1.644 V
+    Channel 1: 20 of 20 edges, latency 6.3 ms (median), 4.4 ms to 8.6 ms
+    Channel 2: 20 of 20 edges, latency 10.5 ms (median), 8.5 ms to 12.8 ms, skew 4.2 ms to channel 1
+ */
+func loopbackCommand(args []string) {
+    flags := flag.NewFlagSet("loopback", flag.ExitOnError)
+    output := flags.String("output", "", "The output of the test pattern, gpio:<pin> or mcp4725[:<address>]")
+    chip := flags.String("chip", "/dev/gpiochip0", "The GPIO chip of the pin")
+    channels := flags.String("channels", "1", "The channels of the ADCPi that the output is wired to")
+    edges := flags.Int("edges", 20, "How many edges are measured")
+    period := flags.Duration("period", 500 * time.Millisecond, "How long the pattern stays at each level")
+    flags.StringVar(&(Settings.Bus), "bus", "/dev/i2c-1", "The I2C bus that the ADCPi is connected to")
+    flags.IntVar(&(Settings.Address), "address", 0x68, "The I2C address of the ADCPi")
+    flags.Parse(args)
+    if *output == "" || *edges < 1 {
+        fail("Usage: plot loopback --output=gpio:<pin>|mcp4725[:<address>] [--channels=1,2] [--edges=20]")
+    }
+    list := []int{}
+    for _, channel := range strings.Split(*channels, ",") {
+        n, err := strconv.Atoi(channel)
+        if err != nil {
+            fail("invalid channel %q", channel)
+        }
+        list = append(list, n)
+    }
+
+    // The fastest resolution, so every channel is converted as often as possible
+    adc, err := NewMCP3424(Settings.Bus, Settings.Address, list, float64(len(list)) / mcp3424Rates[12])
+    if err != nil {
+        fail("%v", err)
+    }
+    defer adc.Close()
+    adc.Timeout = time.Second
+    pattern, err := OpenPatternOutput(*output, *chip, Settings.Bus)
+    if err != nil {
+        fail("%v", err)
+    }
+    defer pattern.Close()
+
+    // Measure both levels, to find the threshold of the edges
+    low, err := loopbackLevels(adc, pattern, list, false, *period)
+    if err != nil {
+        fail("%v", err)
+    }
+    high, err := loopbackLevels(adc, pattern, list, true, *period)
+    if err != nil {
+        fail("%v", err)
+    }
+    thresholds := make([]float64, len(list))
+    for i, channel := range list {
+        if high[i] - low[i] < loopbackMinimumSwing {
+            fail("the test pattern doesn't arrive at channel %d (low %.3f V, high %.3f V)", channel, low[i], high[i])
+        }
+        thresholds[i] = (low[i] + high[i]) / 2
+        fmt.Printf("Channel %d: low %.3f V, high %.3f V, threshold %.3f V\n", channel, low[i], high[i], thresholds[i])
+    }
+
+    // The output was left high, so the first edge falls
+    latencies := make([][]float64, len(list))
+    level := true
+    for edge := 0; edge < *edges; edge++ {
+        level = !level
+        measured, err := loopbackEdge(adc, pattern, list, thresholds, level, *period)
+        if err != nil {
+            fail("%v", err)
+        }
+        for i, latency := range measured {
+            if latency >= 0 {
+                latencies[i] = append(latencies[i], latency)
+            }
+        }
+    }
+
+    // Report the latency of every channel, and its skew to the first one
+    ok := true
+    medians := make([]float64, len(list))
+    for i, channel := range list {
+        sorted := latencies[i]
+        sort.Float64s(sorted)
+        line := fmt.Sprintf("Channel %d: %d of %d edges", channel, len(sorted), *edges)
+        if len(sorted) < *edges {
+            ok = false
+        }
+        if len(sorted) > 0 {
+            medians[i] = percentile(sorted, 50)
+            line += fmt.Sprintf(", latency %.1f ms (median), %.1f ms to %.1f ms", medians[i] * 1000,
+                sorted[0] * 1000, sorted[len(sorted) - 1] * 1000)
+        }
+        if i > 0 && len(sorted) > 0 && len(latencies[0]) > 0 {
+            line += fmt.Sprintf(", skew %.1f ms to channel %d", (medians[i] - medians[0]) * 1000, list[0])
+        }
+        fmt.Println(line)
+    }
+    if !ok {
+        fmt.Println("Some edges didn't arrive, check the wiring and the period")
+        os.Exit(1)
+    }
+}
+
+/*
+ Sets the output to a level, lets it settle for the period and returns the mean voltage of every channel during the
+ second half of it
+ */
+func loopbackLevels(adc *MCP3424, pattern PatternOutput, channels []int, high bool,
+    period time.Duration) ([]float64, error) {
+    err := pattern.Set(high)
+    if err != nil {
+        return nil, err
+    }
+    time.Sleep(period / 2)
+    sums := make([]float64, len(channels))
+    count := 0
+    for start := time.Now(); time.Since(start) < period / 2; count++ {
+        values, err := adc.Read()
+        if err != nil {
+            return nil, err
+        }
+        for i, v := range values {
+            sums[i] += v
+        }
+    }
+    for i := range sums {
+        sums[i] /= float64(count)
+    }
+    return sums, nil
+}
+
+/*
+ Switches the output and returns how long it took every channel to cross its threshold, or -1 for the channels that
+ didn't within the period. The channels are converted one by one, so every conversion has its own time.
+ */
+func loopbackEdge(adc *MCP3424, pattern PatternOutput, channels []int, thresholds []float64, high bool,
+    period time.Duration) ([]float64, error) {
+    latencies := make([]float64, len(channels))
+    for i := range latencies {
+        latencies[i] = -1
+    }
+    err := pattern.Set(high)
+    switched := time.Now()
+    if err != nil {
+        return nil, err
+    }
+    remaining := len(channels)
+    for remaining > 0 && time.Since(switched) < period {
+        for i, channel := range channels {
+            v, err := adc.convert(channel)
+            if err != nil {
+                return nil, err
+            }
+            if latencies[i] < 0 && (v > thresholds[i]) == high {
+                latencies[i] = time.Since(switched).Seconds()
+                remaining--
+            }
+        }
+    }
+
+    // Keep the level for the rest of the period, so every edge starts from a settled signal
+    time.Sleep(period - time.Since(switched))
+    return latencies, nil
+}
@@ -0,0 +1,212 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "math"
+    "time"
+    "errors"
+)
+
+/*
+ The ADCPi board has a voltage divider in front of every input, so the voltage that the MCP3424 measures has to be
+ scaled back up. The value is taken from the reference library of AB Electronics.
+ */
+const adcpiDivider = 2.448579823702253
+
+/*
+ The amount of samples per second that the MCP3424 produces for every resolution
+ */
+var mcp3424Rates = map[int]float64{12: 240, 14: 60, 16: 15, 18: 3.75}
+
+/*
+ Reads channels of the two MCP3424 chips on the ADCPi in continuous conversion mode. Instead of sleeping a fixed amount
+ of time between two measurements, the ready bit in the configuration register is polled. The chip has no interrupt
+ pin, but polling much faster than the conversion rate means that the timing of the samples is defined by the clock
+ of the ADC, not by the scheduler of the Pi.
+ The first channel is the muscle sensor. If the interval between two samples is longer than one conversion, several
+ conversions of it are averaged into one sample. Every further (auxiliary) channel is converted once per sample.
+ */
+type MCP3424 struct {
+    bus string
+    address int
+    devices [2]*I2C
+    resolution int
+    channels []int
+
+    // The channel that each chip is currently converting
+    current [2]int
+
+    /*
+     How many conversions of the muscle sensor are averaged into one sample
+     */
+    Conversions int
+
+    /*
+     The amount of seconds between two samples, as defined by the clock of the ADC
+     */
+    Interval float64
+
+    /*
+     How long to wait for a conversion before giving up. Zero waits forever.
+     */
+    Timeout time.Duration
+}
+
+/*
+ Connects to the ADCPi. Channels 1 to 4 belong to the chip at the given address, channels 5 to 8 to the chip at the
+ next address. The resolution is chosen automatically: the highest one whose conversion rate fits a whole number of
+ conversions into the requested interval.
+ */
+func NewMCP3424(bus string, address int, channels []int, interval float64) (*MCP3424, error) {
+    for _, channel := range channels {
+        if channel < 1 || channel > 8 {
+            return nil, fmt.Errorf("the ADCPi has no channel %d", channel)
+        }
+    }
+
+    // Choose the resolution. Every auxiliary channel needs one conversion.
+    resolution := 0
+    conversions := 0
+    needed := float64(len(channels))
+    for _, bits := range []int{18, 16, 14, 12} {
+        n := interval * mcp3424Rates[bits]
+        if n >= needed && math.Abs(n - math.Round(n)) < 1e-6 {
+            resolution = bits
+            conversions = int(math.Round(n))
+            break
+        }
+        if n >= needed && resolution == 0 {
+            resolution = bits
+            conversions = int(n)
+        }
+    }
+    if resolution == 0 {
+        return nil, fmt.Errorf("an interval of %gs is too short to convert %d channels", interval, len(channels))
+    }
+    m := &MCP3424{bus: bus, address: address, resolution: resolution, channels: channels,
+        Conversions: conversions - len(channels) + 1,
+        Interval: float64(conversions) / mcp3424Rates[resolution]}
+
+    // Start converting the muscle sensor
+    _, err := m.selectChannel(channels[0])
+    if err != nil {
+        m.Close()
+        return nil, err
+    }
+    return m, nil
+}
+
+/*
+ Waits for the next sample and returns the voltage of every channel
+ */
+func (m *MCP3424) Read() ([]float64, error) {
+    values := make([]float64, len(m.channels))
+    for i, channel := range m.channels {
+        count := 1
+        if i == 0 {
+            count = m.Conversions
+        }
+        sum := float64(0)
+        for j := 0; j < count; j++ {
+            v, err := m.convert(channel)
+            if err != nil {
+                return nil, err
+            }
+            sum += v
+        }
+        values[i] = sum / float64(count)
+    }
+    return values, nil
+}
+
+/*
+ Switches the chip of a channel to that channel and starts the continuous conversion
+ */
+func (m *MCP3424) selectChannel(channel int) (*I2C, error) {
+    chip := (channel - 1) / 4
+    if m.devices[chip] == nil {
+        device, err := OpenI2C(m.bus, m.address + chip)
+        if err != nil {
+            return nil, err
+        }
+        m.devices[chip] = device
+    }
+    if m.current[chip] == channel {
+        return m.devices[chip], nil
+    }
+
+    // Continuous conversion (bit 4), the channel (bits 5-6), the sample rate (bits 2-3) and a gain of 1
+    config := byte(0x10) | byte((channel - 1) % 4) << 5 | byte((m.resolution - 12) / 2) << 2
+    _, err := m.devices[chip].Write([]byte{config})
+    if err != nil {
+        return nil, err
+    }
+    m.current[chip] = channel
+    return m.devices[chip], nil
+}
+
+/*
+ Waits until the ADC has finished a conversion of the channel and returns it
+ */
+func (m *MCP3424) convert(channel int) (float64, error) {
+    device, err := m.selectChannel(channel)
+    if err != nil {
+        return 0, err
+    }
+    buffer := make([]byte, 4)
+    poll := time.Duration(float64(time.Second) / mcp3424Rates[m.resolution] / 20)
+    start := time.Now()
+    for {
+        if m.Timeout > 0 && time.Since(start) > m.Timeout {
+            return 0, errors.New("the ADC stopped converting")
+        }
+        _, err := device.Read(buffer)
+        if err != nil {
+            return 0, err
+        }
+
+        // The configuration byte follows the data. A cleared ready bit means that the data is new.
+        status := buffer[2]
+        if m.resolution == 18 {
+            status = buffer[3]
+        }
+        if status & 0x80 == 0 {
+            break
+        }
+        time.Sleep(poll)
+    }
+
+    // Assemble the raw value and apply the sign
+    raw := int32(0)
+    if m.resolution == 18 {
+        raw = int32(buffer[0] & 0x03) << 16 | int32(buffer[1]) << 8 | int32(buffer[2])
+    } else {
+        raw = int32(buffer[0]) << 8 | int32(buffer[1])
+        raw &= 1 << uint(m.resolution) - 1
+    }
+    if raw & (1 << uint(m.resolution - 1)) != 0 {
+        raw -= 1 << uint(m.resolution)
+    }
+    lsb := 4.096 / float64(int32(1) << uint(m.resolution))
+    return float64(raw) * lsb * adcpiDivider, nil
+}
+
+func (m *MCP3424) Close() error {
+    var result error
+    for _, device := range m.devices {
+        if device == nil {
+            continue
+        }
+        err := device.Close()
+        if err != nil && result == nil {
+            result = err
+        }
+    }
+    return result
+}
@@ -0,0 +1,481 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+//go:build !nonet
+
+package main
+
+import (
+    "os"
+    "fmt"
+    "net"
+    "flag"
+    "sort"
+    "time"
+    "strings"
+    "strconv"
+    "encoding/binary"
+)
+
+func init() {
+    Commands["discover"] = discoverCommand
+}
+
+/*
+ The multicast group of mDNS, and the service that running instances advertise in it (DNS-SD)
+ */
+const mdnsAddress = "224.0.0.251:5353"
+const mdnsService = "_symnatec-plot._tcp.local."
+
+/*
+ How long other computers may remember the records of an instance, in seconds
+ */
+const mdnsTTL = 120
+
+/*
+ The types of DNS records that are used
+ */
+const (
+    dnsTypeA = 1
+    dnsTypePTR = 12
+    dnsTypeTXT = 16
+    dnsTypeSRV = 33
+    dnsTypeANY = 255
+)
+
+/*
+ A question of a DNS message
+ */
+type dnsQuestion struct {
+    Name string
+    Type uint16
+}
+
+/*
+ A record of a DNS message. Only the fields of its type are set.
+ */
+type dnsRecord struct {
+    Name string
+    Type uint16
+    TTL uint32
+    Target string
+    Port uint16
+    Text []string
+    IP net.IP
+}
+
+type dnsMessage struct {
+    ID uint16
+    Response bool
+    Questions []dnsQuestion
+    Records []dnsRecord
+}
+
+/*
+ A running instance that was found on the network
+ */
+type Instance struct {
+    Name string
+    Host string
+    Address string
+    Port int
+    Status map[string]string
+}
+
+/*
+ Answers mDNS queries for the service of plot, so the instance can be found with "plot discover", avahi-browse or
+ similar tools. The instance is named after the host, and its TXT record carries the status of the session. Discovery
+ is a convenience, so the instance keeps running without it if the network doesn't allow multicast.
+ */
+func Advertise(pipeline *Pipeline) {
+    group, err := net.ResolveUDPAddr("udp4", mdnsAddress)
+    if err != nil {
+        return
+    }
+    connection, err := net.ListenMulticastUDP("udp4", nil, group)
+    if err != nil {
+        return
+    }
+    host := mdnsHost()
+    instance := host + "." + mdnsService
+
+    // Announce the instance once, so browsers that are already running see it
+    connection.WriteToUDP(encodeDNS(mdnsAnswer(pipeline, host)), group)
+    buffer := make([]byte, 9000)
+    for true {
+        count, from, err := connection.ReadFromUDP(buffer)
+        if err != nil {
+            return
+        }
+        query, err := parseDNS(buffer[:count])
+        if err != nil || query.Response || !asksFor(query, instance) {
+            continue
+        }
+
+        // Queries that don't come from the mDNS port are answered directly (legacy unicast)
+        answer := mdnsAnswer(pipeline, host)
+        if from.Port != group.Port {
+            answer.ID = query.ID
+            answer.Questions = query.Questions
+            connection.WriteToUDP(encodeDNS(answer), from)
+        } else {
+            connection.WriteToUDP(encodeDNS(answer), group)
+        }
+    }
+}
+
+/*
+ Returns the name of this computer without a domain
+ */
+func mdnsHost() string {
+    host, err := os.Hostname()
+    if err != nil || host == "" {
+        host = "plot"
+    }
+    return strings.ToLower(strings.Split(host, ".")[0])
+}
+
+/*
+ Whether a query asks for the service or for the instance
+ */
+func asksFor(query dnsMessage, instance string) bool {
+    for _, question := range query.Questions {
+        name := strings.ToLower(question.Name)
+        if name == mdnsService && (question.Type == dnsTypePTR || question.Type == dnsTypeANY) {
+            return true
+        }
+        if name == instance && question.Type != dnsTypeA {
+            return true
+        }
+    }
+    return false
+}
+
+/*
+ Describes the instance: which service it offers (PTR), on which host (SRV), its status (TXT) and the addresses of the
+ host (A)
+ */
+func mdnsAnswer(pipeline *Pipeline, host string) dnsMessage {
+    instance := host + "." + mdnsService
+    target := host + ".local."
+
+    // Only the kind of the source is told, its URL can carry credentials, and the name of the recording often the
+    // name of the subject
+    source, _, _ := strings.Cut(Settings.Source, ":")
+    if source == "" {
+        source = "adcpi"
+    }
+    if Settings.Debug {
+        source = "debug"
+    }
+    _, elapsed := pipeline.LastSample()
+    signal := "ok"
+    if pipeline.Stalled() {
+        signal = "lost"
+    }
+    message := dnsMessage{Response: true, Records: []dnsRecord{
+        {Name: mdnsService, Type: dnsTypePTR, TTL: mdnsTTL, Target: instance},
+        {Name: instance, Type: dnsTypeSRV, TTL: mdnsTTL, Target: target, Port: servedPort()},
+        {Name: instance, Type: dnsTypeTXT, TTL: mdnsTTL, Text: []string{"state=" + SessionState(pipeline),
+            "source=" + source, "elapsed=" + formatDuration(elapsed), "signal=" + signal}},
+    }}
+    addresses, _ := net.InterfaceAddrs()
+    for _, address := range addresses {
+        network, ok := address.(*net.IPNet)
+        if ok && !network.IP.IsLoopback() && network.IP.To4() != nil {
+            message.Records = append(message.Records, dnsRecord{Name: target, Type: dnsTypeA, TTL: mdnsTTL,
+                IP: network.IP.To4()})
+        }
+    }
+    return message
+}
+
+/*
+ Returns the port that the session is served on, or zero if it isn't served
+ */
+func servedPort() uint16 {
+    _, port, err := net.SplitHostPort(Settings.Serve)
+    if err != nil {
+        return 0
+    }
+    number, _ := strconv.Atoi(port)
+    return uint16(number)
+}
+
+/*
+ Asks the network for running instances and collects the answers until the timeout is over
+ */
+func Discover(timeout time.Duration) ([]Instance, error) {
+    group, err := net.ResolveUDPAddr("udp4", mdnsAddress)
+    if err != nil {
+        return nil, err
+    }
+    connection, err := net.ListenUDP("udp4", nil)
+    if err != nil {
+        return nil, err
+    }
+    defer connection.Close()
+    query := dnsMessage{Questions: []dnsQuestion{{Name: mdnsService, Type: dnsTypePTR}}}
+    _, err = connection.WriteToUDP(encodeDNS(query), group)
+    if err != nil {
+        return nil, err
+    }
+
+    found := map[string]*Instance{}
+    buffer := make([]byte, 9000)
+    connection.SetReadDeadline(time.Now().Add(timeout))
+    for true {
+        count, from, err := connection.ReadFromUDP(buffer)
+        if err != nil {
+            // The deadline ends the search
+            break
+        }
+        answer, err := parseDNS(buffer[:count])
+        if err != nil || !answer.Response {
+            continue
+        }
+        addresses := map[string]string{}
+        for _, record := range answer.Records {
+            if record.Type == dnsTypeA {
+                addresses[strings.ToLower(record.Name)] = record.IP.String()
+            }
+        }
+        for _, record := range answer.Records {
+            name := strings.ToLower(record.Name)
+            if !strings.HasSuffix(name, "." + mdnsService) || record.Type == dnsTypePTR {
+                continue
+            }
+            instance, ok := found[name]
+            if !ok {
+                instance = &Instance{Name: strings.TrimSuffix(record.Name, "." + mdnsService),
+                    Address: from.IP.String(), Status: map[string]string{}}
+                found[name] = instance
+            }
+            switch record.Type {
+            case dnsTypeSRV:
+                instance.Host = strings.TrimSuffix(record.Target, ".")
+                instance.Port = int(record.Port)
+                if address, ok := addresses[strings.ToLower(record.Target)]; ok {
+                    instance.Address = address
+                }
+            case dnsTypeTXT:
+                for _, text := range record.Text {
+                    pair := strings.SplitN(text, "=", 2)
+                    if len(pair) == 2 {
+                        instance.Status[pair[0]] = pair[1]
+                    }
+                }
+            }
+        }
+    }
+
+    instances := []Instance{}
+    for _, instance := range found {
+        instances = append(instances, *instance)
+    }
+    sort.Slice(instances, func(i, j int) bool { return instances[i].Name < instances[j].Name })
+    return instances, nil
+}
+
+/*
+ Lists the instances of plot that are running on the local network with --advertise, with the status of their
+ sessions
+ Example:
+    $ plot discover
+    NAME        ADDRESS          STATE    SIGNAL   ELAPSED    SOURCE
+    pi-lab-03   192.168.1.23     rec      ok       00:12:40   adcpi
+ */
+func discoverCommand(args []string) {
+    flags := flag.NewFlagSet("discover", flag.ExitOnError)
+    timeout := flags.Duration("timeout", 2 * time.Second, "How long to wait for answers")
+    flags.Parse(args)
+    if flags.NArg() > 0 {
+        fail("Usage: plot discover [--timeout=2s]")
+    }
+    instances, err := Discover(*timeout)
+    if err != nil {
+        fail("%v", err)
+    }
+    if len(instances) == 0 {
+        fmt.Println("No instances found")
+        return
+    }
+    format := "%-12s %-16s %-8s %-8s %-10s %s\n"
+    fmt.Printf(format, "NAME", "ADDRESS", "STATE", "SIGNAL", "ELAPSED", "SOURCE")
+    for _, instance := range instances {
+        field := func(key string) string {
+            if value, ok := instance.Status[key]; ok {
+                return value
+            }
+            return "-"
+        }
+        fmt.Printf(format, instance.Name, instance.Address, field("state"), field("signal"), field("elapsed"),
+            field("source"))
+    }
+}
+
+/*
+ Encodes a DNS message. Names aren't compressed, the messages are small enough.
+ */
+func encodeDNS(message dnsMessage) []byte {
+    data := make([]byte, 12)
+    binary.BigEndian.PutUint16(data[0:], message.ID)
+    if message.Response {
+        // An authoritative answer
+        binary.BigEndian.PutUint16(data[2:], 0x8400)
+    }
+    binary.BigEndian.PutUint16(data[4:], uint16(len(message.Questions)))
+    binary.BigEndian.PutUint16(data[6:], uint16(len(message.Records)))
+    for _, question := range message.Questions {
+        data = appendName(data, question.Name)
+        data = binary.BigEndian.AppendUint16(data, question.Type)
+        data = binary.BigEndian.AppendUint16(data, 1)
+    }
+    for _, record := range message.Records {
+        var content []byte
+        switch record.Type {
+        case dnsTypeA:
+            content = record.IP.To4()
+        case dnsTypePTR:
+            content = appendName(nil, record.Target)
+        case dnsTypeSRV:
+            content = []byte{0, 0, 0, 0, byte(record.Port >> 8), byte(record.Port)}
+            content = appendName(content, record.Target)
+        case dnsTypeTXT:
+            // Every string of a TXT record has at most 255 bytes
+            for _, text := range record.Text {
+                text = text[:min(len(text), 255)]
+                content = append(content, byte(len(text)))
+                content = append(content, text...)
+            }
+        }
+        data = appendName(data, record.Name)
+        data = binary.BigEndian.AppendUint16(data, record.Type)
+
+        // Records of only this instance flush the caches of other computers
+        class := uint16(1)
+        if record.Type != dnsTypePTR {
+            class |= 0x8000
+        }
+        data = binary.BigEndian.AppendUint16(data, class)
+        data = binary.BigEndian.AppendUint32(data, record.TTL)
+        data = binary.BigEndian.AppendUint16(data, uint16(len(content)))
+        data = append(data, content...)
+    }
+    return data
+}
+
+func appendName(data []byte, name string) []byte {
+    for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+        data = append(data, byte(len(label)))
+        data = append(data, label...)
+    }
+    return append(data, 0)
+}
+
+/*
+ Decodes a DNS message. The records of all sections are returned together, since mDNS answers often put the records
+ that belong together into different sections.
+ */
+func parseDNS(data []byte) (dnsMessage, error) {
+    message := dnsMessage{}
+    if len(data) < 12 {
+        return message, fmt.Errorf("message too short")
+    }
+    message.ID = binary.BigEndian.Uint16(data[0:])
+    message.Response = data[2] & 0x80 != 0
+    questions := int(binary.BigEndian.Uint16(data[4:]))
+    records := 0
+    for i := 6; i < 12; i += 2 {
+        records += int(binary.BigEndian.Uint16(data[i:]))
+    }
+    offset := 12
+    for i := 0; i < questions; i++ {
+        name, next, err := readName(data, offset)
+        if err != nil || next + 4 > len(data) {
+            return message, fmt.Errorf("invalid question")
+        }
+        message.Questions = append(message.Questions, dnsQuestion{Name: name,
+            Type: binary.BigEndian.Uint16(data[next:])})
+        offset = next + 4
+    }
+    for i := 0; i < records; i++ {
+        name, next, err := readName(data, offset)
+        if err != nil || next + 10 > len(data) {
+            return message, fmt.Errorf("invalid record")
+        }
+        record := dnsRecord{Name: name, Type: binary.BigEndian.Uint16(data[next:]),
+            TTL: binary.BigEndian.Uint32(data[next + 4:])}
+        length := int(binary.BigEndian.Uint16(data[next + 8:]))
+        start := next + 10
+        if start + length > len(data) {
+            return message, fmt.Errorf("invalid record")
+        }
+        content := data[start:start + length]
+        switch record.Type {
+        case dnsTypeA:
+            if length == 4 {
+                record.IP = net.IP(append([]byte{}, content...))
+            }
+        case dnsTypePTR:
+            record.Target, _, err = readName(data, start)
+        case dnsTypeSRV:
+            if length >= 6 {
+                record.Port = binary.BigEndian.Uint16(content[4:])
+                record.Target, _, err = readName(data, start + 6)
+            }
+        case dnsTypeTXT:
+            for j := 0; j < len(content); j += int(content[j]) + 1 {
+                if j + 1 + int(content[j]) <= len(content) {
+                    record.Text = append(record.Text, string(content[j + 1:j + 1 + int(content[j])]))
+                }
+            }
+        }
+        if err != nil {
+            return message, err
+        }
+        message.Records = append(message.Records, record)
+        offset = start + length
+    }
+    return message, nil
+}
+
+/*
+ Reads a name at an offset of a message, following compression pointers. Returns the name and the offset after it.
+ */
+func readName(data []byte, offset int) (string, int, error) {
+    labels := []string{}
+    next := -1
+    for jumps := 0; jumps < 16; {
+        if offset >= len(data) {
+            return "", 0, fmt.Errorf("invalid name")
+        }
+        length := int(data[offset])
+        if length == 0 {
+            if next < 0 {
+                next = offset + 1
+            }
+            return strings.Join(labels, ".") + ".", next, nil
+        }
+        if length & 0xc0 == 0xc0 {
+            if offset + 1 >= len(data) {
+                return "", 0, fmt.Errorf("invalid name")
+            }
+            if next < 0 {
+                next = offset + 2
+            }
+            offset = int(binary.BigEndian.Uint16(data[offset:]) & 0x3fff)
+            jumps++
+            continue
+        }
+        if offset + 1 + length > len(data) {
+            return "", 0, fmt.Errorf("invalid name")
+        }
+        labels = append(labels, string(data[offset + 1:offset + 1 + length]))
+        offset += 1 + length
+    }
+    return "", 0, fmt.Errorf("too many compression pointers")
+}
@@ -0,0 +1,187 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "time"
+    "encoding/json"
+)
+
+/*
+ Information about a recording that doesn't fit into the CSV file itself. It is stored next to the recording, in a
+ file with the same name and the extension .meta appended (data.csv -> data.csv.meta).
+ */
+type Metadata struct {
+
+    /*
+     The moment when the recording was started
+     */
+    Created time.Time
+
+    /*
+     The amount of seconds that passed between two measurements
+     */
+    Interval float64
+
+    /*
+     The I2C address of the interface the data was recorded from
+     */
+    Address int
+
+    /*
+     The channel of the analog pin where the muscle sensor was connected
+     */
+    Channel int
+
+    /*
+     The source of the samples, if they weren't measured with the ADCPi
+     */
+    Source string `json:",omitempty"`
+
+    /*
+     Whether the samples were timed by the clock of the ADC ("hardware"), by sleeping ("software") or by a sample
+     server that plot connected to ("remote")
+     */
+    Timing string
+
+    /*
+     The algorithm that was used to create the checksums of the recording, and the amount of samples that are
+     covered by one checksum. The checksums themselves are stored in a file with the extension .sum appended.
+     */
+    Checksum string
+    ChecksumBlock int
+
+    /*
+     The auxiliary sensors that were recorded. Their values are stored in the last columns of the recording.
+     */
+    Aux []AuxChannel
+
+    /*
+     The auxiliary sensor that interference was cancelled with (see --reference). The cancellation is the stage lms.
+     */
+    Reference string `json:",omitempty"`
+
+    /*
+     The agonist and the antagonist of the co-contraction index, if it was computed (see --cocontraction)
+     */
+    CoContraction string `json:",omitempty"`
+
+    /*
+     The threshold of the motion detection in g, if an accelerometer was recorded (see --motion), and whether the
+     signal during motion was left out of the iEMG of the segments
+     */
+    Motion float64 `json:",omitempty"`
+    MotionGate bool `json:",omitempty"`
+
+    /*
+     The kind of signal that was recorded: "emg", or "ecg" if heartbeats were detected
+     */
+    Mode string
+
+    /*
+     The processing stages that were applied to the signal, as given to --filter. If the stages were recorded into
+     separate files, Stage is the name of the stage that is stored in this file.
+     */
+    Filters string
+    Stage string
+
+    /*
+     The recording that this one was reprocessed from with other stages (see --reprocess)
+     */
+    Original string `json:",omitempty"`
+
+    /*
+     In triggered mode, the threshold in volts or the condition that fired the trigger (neither if it was fired by
+     hand), and how many seconds before and after every trigger were recorded. The recording has gaps between the
+     triggers.
+     */
+    Trigger float64 `json:",omitempty"`
+    TriggerWhen string `json:",omitempty"`
+    PreTrigger float64 `json:",omitempty"`
+    PostTrigger float64 `json:",omitempty"`
+
+    /*
+     How the threshold of the trigger was debounced, see LevelTrigger
+     */
+    TriggerHysteresis float64 `json:",omitempty"`
+    TriggerHold float64 `json:",omitempty"`
+    TriggerRefractory float64 `json:",omitempty"`
+
+    /*
+     What the camera captured next to the recording (see Camera), into the directory with .frames appended
+     */
+    Camera string `json:",omitempty"`
+
+    /*
+     The pseudonym of the subject that was recorded. The real name is never stored in a recording, see Subjects.
+     */
+    Subject string
+
+    /*
+     Free text notes about the session. Notes can contain identifying information, so "plot scrub" removes them.
+     */
+    Notes string
+
+    /*
+     The quality of the raw signal, which is computed when the recording is finished
+     */
+    Quality *Quality `json:",omitempty"`
+
+    /*
+     Tags that the session can be found by, see "plot sessions"
+     */
+    Tags []string `json:",omitempty"`
+
+    /*
+     When the retention removed the samples of the session (see Retention). Only the metadata and the rollups are left.
+     */
+    Pruned *time.Time `json:",omitempty"`
+
+    /*
+     Whether the recording was encrypted. The metadata itself is never encrypted.
+     */
+    Encrypted bool
+}
+
+/*
+ Returns the path of the metadata file that belongs to a recording
+ */
+func MetadataFile(file string) string {
+    return file + ".meta"
+}
+
+/*
+ Loads the metadata of a recording
+ */
+func LoadMetadata(file string) (Metadata, error) {
+    meta := Metadata{}
+    data, err := os.ReadFile(MetadataFile(file))
+    if err != nil {
+        return meta, err
+    }
+    err = json.Unmarshal(data, &meta)
+    return meta, err
+}
+
+/*
+ Stores the metadata of a recording
+ */
+func SaveMetadata(file string, meta Metadata) error {
+    data, err := json.MarshalIndent(meta, "", "    ")
+    if err != nil {
+        return err
+    }
+    return os.WriteFile(MetadataFile(file), data, 0644)
+}
+
+/*
+ Whether only the samples around triggers were recorded
+ */
+func (m Metadata) Triggered() bool {
+    return m.Trigger > 0 || m.TriggerWhen != "" || m.PreTrigger > 0
+}
@@ -0,0 +1,179 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "sync"
+)
+
+/*
+ The modes of the application. A session starts in the mode that was selected by the settings, and changes between
+ them while it runs, without interrupting the acquisition:
+    live      Shows and records the samples of the source
+    paused    Shows the samples of the source, but doesn't record them
+    playback  Shows a recording. During an acquisition, this plays what was recorded so far while the source keeps
+              running in the background, and returns to the mode it was started from.
+    debug     Shows generated samples
+    ended     The session is over
+ */
+type AppMode string
+
+const (
+    ModeLive AppMode = "live"
+    ModePaused AppMode = "paused"
+    ModePlayback AppMode = "playback"
+    ModeDebug AppMode = "debug"
+    ModeEnded AppMode = "ended"
+)
+
+/*
+ The modes that can follow each mode. Playback returns to the mode it was started from with Back.
+ */
+var modeTransitions = map[AppMode][]AppMode{
+    ModeLive: {ModePaused, ModePlayback, ModeEnded},
+    ModePaused: {ModeLive, ModePlayback, ModeEnded},
+    ModePlayback: {ModeEnded},
+    ModeDebug: {ModeEnded},
+    ModeEnded: {},
+}
+
+/*
+ Tracks the mode of the application and carries out the transitions between the modes. The acquisition runs in its
+ own pipeline for the whole session. A playback during the acquisition gets a pipeline of its own, which is shown on
+ the display while it lasts.
+ */
+type ModeMachine struct {
+    lock sync.Mutex
+    mode AppMode
+    previous AppMode
+    acquisition *Pipeline
+    playback *Pipeline
+}
+
+/*
+ Returns the mode that was selected by the settings
+ */
+func InitialMode() AppMode {
+    if Settings.Debug {
+        return ModeDebug
+    } else if Settings.Playback {
+        return ModePlayback
+    }
+    return ModeLive
+}
+
+/*
+ Creates the state machine of the session that runs in the pipeline, starting in the given mode
+ */
+func NewModeMachine(pipeline *Pipeline, mode AppMode) *ModeMachine {
+    machine := &ModeMachine{mode: mode, acquisition: pipeline}
+    pipeline.Modes = machine
+    return machine
+}
+
+/*
+ Returns the current mode
+ */
+func (m *ModeMachine) Mode() AppMode {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+    return m.mode
+}
+
+/*
+ Returns the pipeline that is shown on the display: the playback while it lasts, the acquisition otherwise
+ */
+func (m *ModeMachine) Shown() *Pipeline {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+    if m.playback != nil {
+        return m.playback
+    }
+    return m.acquisition
+}
+
+/*
+ Switches to another mode, or returns an error if it can't follow the current one. Switching to the current mode
+ does nothing. This can be called from any goroutine.
+ */
+func (m *ModeMachine) Transition(mode AppMode) error {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+    return m.transition(mode)
+}
+
+/*
+ Ends the playback and returns to the mode it was started from
+ */
+func (m *ModeMachine) Back() error {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+    if m.mode != ModePlayback || m.previous == "" {
+        return fmt.Errorf("there is nothing to return to from %s", m.mode)
+    }
+    m.playback.Close()
+    m.playback = nil
+    m.mode = m.previous
+    return nil
+}
+
+/*
+ Adopts the mode of another instance whose session is shown (see "plot view"), or of the acquisition, which pauses
+ itself while the signal is lost. The transition was carried out already, so only the pause of the pipeline is
+ followed.
+ */
+func (m *ModeMachine) Follow(mode AppMode) {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+    if m.mode == ModeLive || m.mode == ModePaused {
+        m.mode = mode
+        m.acquisition.markPaused(mode == ModePaused)
+    }
+}
+
+func (m *ModeMachine) transition(mode AppMode) error {
+    if mode == m.mode {
+        return nil
+    }
+    if !m.allowed(mode) {
+        return fmt.Errorf("can't switch from %s to %s", m.mode, mode)
+    }
+    switch {
+    case mode == ModeEnded:
+        if m.playback != nil {
+            m.playback.Close()
+            m.playback = nil
+        }
+        m.acquisition.Close()
+    case mode == ModePlayback:
+        // The recording is played from the file, so it includes everything that was recorded up to now
+        if m.acquisition.Recording() == nil {
+            return fmt.Errorf("nothing has been recorded yet")
+        }
+        m.playback = NewPipeline()
+        m.playback.Modes = m
+        go grabDataFromFile(m.playback)
+        m.previous = m.mode
+    default:
+        m.acquisition.SetPaused(mode == ModePaused)
+    }
+    m.mode = mode
+    return nil
+}
+
+/*
+ Whether the mode can follow the current one
+ */
+func (m *ModeMachine) allowed(mode AppMode) bool {
+    for _, next := range modeTransitions[m.mode] {
+        if next == mode {
+            return true
+        }
+    }
+    return false
+}
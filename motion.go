@@ -0,0 +1,227 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "math"
+    "strings"
+    "sync/atomic"
+)
+
+/*
+ The standard gravity, to convert accelerometers in m/s² into g
+ */
+const standardGravity = 9.80665
+
+/*
+ The time constant of the baseline of the acceleration in seconds. Gravity and the slow changes of the posture end up
+ in the baseline, movements stand out from it.
+ */
+const motionBaseline = 1.0
+
+/*
+ Detects movements of the subject with an accelerometer, which is an auxiliary sensor in g or m/s² (like an ADXL335
+ on a channel of the ADCPi, with one to three axes) or the IMU. Movements cause artifacts in the muscle signal, so the
+ samples during large movements are flagged: the magnitude of the acceleration is compared to its baseline, and a
+ difference above the threshold counts as motion for the hold time after it. Live, the motion starts with the first
+ sample above the threshold; the analysis of recordings extends it by the hold time before that as well.
+ */
+type MotionDetector struct {
+    axes []int
+    scale float64
+    threshold float64
+    hold float64
+    alpha float64
+    baseline float64
+    started bool
+    until float64
+    moving int32
+}
+
+/*
+ Returns the indices of the axes of the accelerometer among the auxiliary sensors, and the scale that converts them
+ into g. The IMU takes precedence over an analog accelerometer.
+ */
+func AccelerometerAxes(channels AuxChannels) ([]int, float64) {
+    for _, sensor := range []string{"imu", ""} {
+        axes := []int{}
+        scale := 1.0
+        for i, channel := range channels {
+            if channel.Sensor != sensor {
+                continue
+            }
+            switch strings.ToLower(channel.Unit) {
+            case "g":
+                axes = append(axes, i)
+            case "m/s2", "m/s²", "m/s^2":
+                axes = append(axes, i)
+                scale = 1 / standardGravity
+            }
+        }
+        if len(axes) > 0 {
+            return axes, scale
+        }
+    }
+    return nil, 0
+}
+
+/*
+ Creates a detector for the accelerometer among the auxiliary sensors, or returns nil if there is none or the
+ threshold (in g) is zero
+ */
+func NewMotionDetector(channels AuxChannels, interval float64, threshold float64, hold float64) *MotionDetector {
+    axes, scale := AccelerometerAxes(channels)
+    if len(axes) == 0 || threshold <= 0 {
+        return nil
+    }
+    return &MotionDetector{axes: axes, scale: scale, threshold: threshold, hold: hold,
+        alpha: 1 - math.Exp(-interval / motionBaseline)}
+}
+
+/*
+ Adds the values of the auxiliary sensors of the next sample, and returns whether the subject is moving, and whether
+ this changed with the sample
+ */
+func (m *MotionDetector) Process(time float64, aux []float64) (bool, bool) {
+    squares := 0.0
+    for _, axis := range m.axes {
+        v := auxValue(aux, axis) * m.scale
+        squares += v * v
+    }
+    magnitude := math.Sqrt(squares)
+    if !m.started {
+        m.baseline = magnitude
+        m.started = true
+    }
+    if math.Abs(magnitude - m.baseline) > m.threshold {
+        m.until = time + m.hold
+    } else {
+        // Movements don't pull the baseline along
+        m.baseline += (magnitude - m.baseline) * m.alpha
+    }
+    moving := time < m.until
+    changed := moving != m.Moving()
+    if changed && moving {
+        atomic.StoreInt32(&m.moving, 1)
+    } else if changed {
+        atomic.StoreInt32(&m.moving, 0)
+    }
+    return moving, changed
+}
+
+/*
+ Returns the threshold of the detector, or zero if there is none
+ */
+func (m *MotionDetector) Threshold() float64 {
+    if m == nil {
+        return 0
+    }
+    return m.threshold
+}
+
+/*
+ Whether the subject is moving at the moment. This can be called from any goroutine.
+ */
+func (m *MotionDetector) Moving() bool {
+    return m != nil && atomic.LoadInt32(&m.moving) != 0
+}
+
+/*
+ Finds the motion in a recording, given the times of the samples and the values of the auxiliary sensors per
+ channel. The motion is extended by the hold time before it as well, since the artifacts start with the movement.
+ */
+func DetectMotion(times []float64, channels AuxChannels, aux [][]float64, interval float64, threshold float64,
+    hold float64) []bool {
+    stream := NewMotionStream(channels, interval, threshold, hold)
+    if !stream.Detecting() {
+        return nil
+    }
+    motion := make([]bool, 0, len(times))
+    values := make([]float64, len(channels))
+    for i, t := range times {
+        for j := range channels {
+            values[j] = aux[j][i]
+        }
+        for _, sample := range stream.Add(t, nil, values) {
+            motion = append(motion, sample.Moving)
+        }
+    }
+    for _, sample := range stream.Flush() {
+        motion = append(motion, sample.Moving)
+    }
+    return motion
+}
+
+/*
+ A row of a recording and whether the subject was moving
+ */
+type MotionSample struct {
+    Time float64
+    Values []float64
+    Moving bool
+}
+
+/*
+ Finds the motion in a recording that is read one row after the other, like DetectMotion. Since the motion is
+ extended by the hold time before it, the rows are held back for the hold time until it is known whether they are
+ part of the motion; at most the rows of the hold time are kept in memory.
+ */
+type MotionStream struct {
+    detector *MotionDetector
+    hold float64
+    pending []MotionSample
+}
+
+/*
+ Creates the stream. Without an accelerometer or with a threshold of zero, no row is moving and none is held back.
+ */
+func NewMotionStream(channels AuxChannels, interval float64, threshold float64, hold float64) *MotionStream {
+    return &MotionStream{detector: NewMotionDetector(channels, interval, threshold, hold), hold: hold}
+}
+
+/*
+ Whether the stream detects motion, which needs an accelerometer
+ */
+func (m *MotionStream) Detecting() bool {
+    return m.detector != nil
+}
+
+/*
+ Adds the next row, given its time, its values and the values of the auxiliary sensors, and returns the rows whose
+ motion is known now. The values are copied.
+ */
+func (m *MotionStream) Add(time float64, values []float64, aux []float64) []MotionSample {
+    sample := MotionSample{Time: time, Values: append([]float64(nil), values...)}
+    if m.detector == nil {
+        return []MotionSample{sample}
+    }
+    sample.Moving, _ = m.detector.Process(time, aux)
+    if sample.Moving {
+        for i := len(m.pending) - 1; i >= 0 && time - m.pending[i].Time <= m.hold; i-- {
+            m.pending[i].Moving = true
+        }
+    }
+    m.pending = append(m.pending, sample)
+
+    // Later motion can't reach back to rows that are older than the hold time
+    done := 0
+    for done < len(m.pending) && time - m.pending[done].Time > m.hold {
+        done++
+    }
+    released := append([]MotionSample(nil), m.pending[:done]...)
+    m.pending = append(m.pending[:0], m.pending[done:]...)
+    return released
+}
+
+/*
+ Returns the rows that are still held back, at the end of the recording
+ */
+func (m *MotionStream) Flush() []MotionSample {
+    released := m.pending
+    m.pending = nil
+    return released
+}
@@ -0,0 +1,202 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+//go:build !nonet
+
+package main
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "net"
+    "time"
+    "bufio"
+    "bytes"
+    "strings"
+    "net/url"
+    "encoding/json"
+)
+
+func init() {
+    Sinks["mqtt"] = openMQTTSink
+    Sinks["mqtts"] = openMQTTSink
+    SinkBackends["mqtt"] = Backend{
+        Usage: "mqtt://[user:password@]<broker>:1883/<topic>?client=plot",
+        Description: "Publishes the session to an MQTT broker",
+        Probe: func(target *url.URL) error { return probeHost(target, "1883") },
+    }
+    SinkBackends["mqtts"] = Backend{
+        Usage: "mqtts://[user:password@]<broker>:8883/<topic>?client=plot&ca=ca.pem",
+        Description: "Publishes the session to an MQTT broker, connected with TLS",
+        Probe: func(target *url.URL) error { return probeHost(target, "8883") },
+    }
+}
+
+/*
+ Publishes the session to an MQTT broker (version 3.1.1, QoS 0). Every message is published as JSON to a topic below
+ the path of the URL, named after the type of the message:
+    mqtt://[user:password@]broker:1883/lab/emg?client=plot   publishes to lab/emg/session, lab/emg/sample, ...
+ mqtts:// connects with TLS (port 8883 by default), see DialURL for the certificates.
+ Brokers don't cope well with a message for every sample, so this sink is usually given a rate, like ?rate=5.
+ */
+type MQTTSink struct {
+    connection net.Conn
+    output *bufio.Writer
+    topic string
+}
+
+/*
+ The types of the MQTT control packets that are used
+ */
+const (
+    mqttConnect = 0x10
+    mqttConnack = 0x20
+    mqttPublish = 0x30
+    mqttDisconnect = 0xe0
+)
+
+func openMQTTSink(target *url.URL) (Sink, error) {
+    topic := strings.Trim(target.Path, "/")
+    if topic == "" {
+        return nil, fmt.Errorf("expected mqtt://broker:port/topic")
+    }
+    secure := target.Scheme == "mqtts"
+    host := target.Host
+    if target.Port() == "" && secure {
+        host = net.JoinHostPort(target.Hostname(), "8883")
+    } else if target.Port() == "" {
+        host = net.JoinHostPort(target.Hostname(), "1883")
+    }
+    client := target.Query().Get("client")
+    if client == "" {
+        client = fmt.Sprintf("plot-%d", os.Getpid())
+    }
+    connection, err := DialURL(target, "tcp", host, secure)
+    if err != nil {
+        return nil, err
+    }
+    sink := &MQTTSink{connection: connection, output: bufio.NewWriter(connection), topic: topic}
+    err = sink.connect(client, target.User)
+    if err != nil {
+        connection.Close()
+        return nil, err
+    }
+    return sink, nil
+}
+
+func (s *MQTTSink) Write(message interface{}) error {
+    payload, err := json.Marshal(message)
+    if err != nil {
+        return err
+    }
+    var header StreamHeader
+    switch m := message.(type) {
+    case SessionMessage:
+        header = m.StreamHeader
+    case SampleMessage:
+        header = m.StreamHeader
+    case EventMessage:
+        header = m.StreamHeader
+    case SegmentMessage:
+        header = m.StreamHeader
+    }
+    packet := bytes.Buffer{}
+    mqttString(&packet, s.topic + "/" + header.Type)
+    packet.Write(payload)
+    err = s.packet(mqttPublish, packet.Bytes())
+    if err != nil {
+        return err
+    }
+    return s.output.Flush()
+}
+
+func (s *MQTTSink) Close() error {
+    err := s.packet(mqttDisconnect, nil)
+    if err == nil {
+        err = s.output.Flush()
+    }
+    if err != nil {
+        s.connection.Close()
+        return err
+    }
+    return s.connection.Close()
+}
+
+/*
+ Opens the session with the broker. It is a clean session without keep alive, because messages are only published.
+ */
+func (s *MQTTSink) connect(client string, user *url.Userinfo) error {
+    packet := bytes.Buffer{}
+    mqttString(&packet, "MQTT")
+    flags := byte(0x02)
+    if user != nil {
+        flags |= 0x80
+        if _, ok := user.Password(); ok {
+            flags |= 0x40
+        }
+    }
+    packet.Write([]byte{4, flags, 0, 0})
+    mqttString(&packet, client)
+    if user != nil {
+        mqttString(&packet, user.Username())
+        if password, ok := user.Password(); ok {
+            mqttString(&packet, password)
+        }
+    }
+    err := s.packet(mqttConnect, packet.Bytes())
+    if err == nil {
+        err = s.output.Flush()
+    }
+    if err != nil {
+        return err
+    }
+
+    // The broker answers with 0x20 0x02 <flags> <return code>
+    s.connection.SetReadDeadline(time.Now().Add(10 * time.Second))
+    answer := make([]byte, 4)
+    _, err = io.ReadFull(s.connection, answer)
+    if err != nil {
+        return err
+    }
+    if answer[0] != mqttConnack {
+        return fmt.Errorf("mqtt: unexpected answer %#x", answer[0])
+    }
+    if answer[3] != 0 {
+        return fmt.Errorf("mqtt: connection refused by the broker (code %d)", answer[3])
+    }
+    return nil
+}
+
+/*
+ Writes a control packet with the length of its content encoded as variable length integer
+ */
+func (s *MQTTSink) packet(kind byte, content []byte) error {
+    header := []byte{kind}
+    length := len(content)
+    for {
+        digit := byte(length % 128)
+        length /= 128
+        if length > 0 {
+            digit |= 0x80
+        }
+        header = append(header, digit)
+        if length == 0 {
+            break
+        }
+    }
+    _, err := s.output.Write(header)
+    if err != nil {
+        return err
+    }
+    _, err = s.output.Write(content)
+    return err
+}
+
+func mqttString(buffer *bytes.Buffer, s string) {
+    buffer.Write([]byte{byte(len(s) >> 8), byte(len(s))})
+    buffer.WriteString(s)
+}
@@ -0,0 +1,77 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+//go:build !nonet
+
+package main
+
+import (
+    "fmt"
+    "net"
+    "net/url"
+)
+
+func init() {
+    Sinks["tcp"] = openNetworkSink
+    Sinks["udp"] = openNetworkSink
+    Sinks["tls"] = openNetworkSink
+    SinkBackends["tcp"] = Backend{
+        Usage: "tcp://<host>:<port>[?format=arrow]",
+        Description: "Sends the session to a server as lines of JSON",
+        Probe: func(target *url.URL) error { return probeHost(target, "") },
+    }
+    SinkBackends["udp"] = Backend{
+        Usage: "udp://<host>:<port>",
+        Description: "Sends every message of the session as a datagram of JSON",
+        Probe: probeDatagram,
+    }
+    SinkBackends["tls"] = Backend{
+        Usage: "tls://<host>:<port>?ca=ca.pem[&format=arrow]",
+        Description: "Sends the session to a server as lines of JSON, connected with TLS",
+        Probe: func(target *url.URL) error { return probeHost(target, "") },
+    }
+}
+
+/*
+ Sends the session as lines of JSON (see JSONSink) over the network:
+    tcp://host:port     Connects to a server
+    udp://host:port     Sends every message as one datagram
+    tls://host:port     Connects to a server with TLS, see DialURL for the certificates
+ The streams of tcp and tls can be in the Arrow stream format instead, with ?format=arrow (see ArrowSink).
+ */
+func openNetworkSink(target *url.URL) (Sink, error) {
+    if target.Scheme == "tls" {
+        connection, err := DialURL(target, "tcp", target.Host, true)
+        if err != nil {
+            return nil, err
+        }
+        return NewFormatSink(connection, false, target)
+    }
+
+    // A stream of Arrow can't be cut into datagrams
+    if target.Scheme == "udp" && target.Query().Get("format") != "" {
+        return nil, fmt.Errorf("udp only sends JSON, use tcp for the format %s", target.Query().Get("format"))
+    }
+    connection, err := net.Dial(target.Scheme, target.Host)
+    if err != nil {
+        return nil, err
+    }
+    return NewFormatSink(connection, false, target)
+}
+
+/*
+ UDP doesn't answer, so a datagram sink can only check whether the host can be resolved and routed to
+ */
+func probeDatagram(target *url.URL) error {
+    if target == nil {
+        return probeNetwork()
+    }
+    connection, err := net.Dial("udp", target.Host)
+    if err != nil {
+        return err
+    }
+    return connection.Close()
+}
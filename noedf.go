@@ -0,0 +1,13 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+//go:build noedf
+
+package main
+
+func init() {
+    Omitted = append(Omitted, "noedf")
+}
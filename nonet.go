@@ -0,0 +1,50 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+//go:build nonet
+
+package main
+
+import (
+    "errors"
+)
+
+func init() {
+    Omitted = append(Omitted, "nonet")
+}
+
+/*
+ Without the network, the session can't be served. RunDisplay refuses --serve, so this is never created.
+ */
+type Server struct{}
+
+func NewServer(pipeline *Pipeline, address string) (*Server, error) {
+    return nil, errors.New("this build has no network support (nonet)")
+}
+
+func (s *Server) Write(message interface{}) error {
+    return nil
+}
+
+func (s *Server) Close() error {
+    return nil
+}
+
+/*
+ Without the network, the instance can't be found, so it isn't advertised
+ */
+func Advertise(pipeline *Pipeline) {
+}
+
+/*
+ Without the network, there are no notifications. RunDisplay refuses --notify, so this is never called.
+ */
+func OpenNotifications(urls []string, pipeline *Pipeline, file string) ([]Sink, error) {
+    if len(urls) == 0 {
+        return nil, nil
+    }
+    return nil, errors.New("this build has no network support (nonet)")
+}
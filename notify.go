@@ -0,0 +1,426 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+//go:build !nonet
+
+package main
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "sort"
+    "time"
+    "bytes"
+    "strings"
+    "net/url"
+    "net/http"
+    "net/smtp"
+    "mime"
+    "path/filepath"
+)
+
+/*
+ Tells someone who isn't watching about the session, like on the phone during a recording overnight
+ */
+type Notifier interface {
+    Notify(title string, text string, urgent bool) error
+}
+
+/*
+ Creates a notifier for a URL
+ */
+type NotifierFactory func(target *url.URL) (Notifier, error)
+
+/*
+ All kinds of notifications, by the scheme of their URL
+ */
+var Notifiers = map[string]NotifierFactory{
+    "ntfy": openNtfy,
+    "ntfys": openNtfy,
+    "telegram": openTelegram,
+    "mailto": openMail,
+}
+
+/*
+ The environment variables with the secrets of the notifications, which aren't accepted on the command line
+ */
+const (
+    ntfyTokenVariable = "PLOT_NTFY_TOKEN"
+    telegramTokenVariable = "PLOT_TELEGRAM_TOKEN"
+    smtpPasswordVariable = "PLOT_SMTP_PASSWORD"
+)
+
+/*
+ The events that are notified unless the URL asks for others with ?events=, by the start of their names
+ */
+var notifiedEvents = []string{"triggered", "acquisition", "signal", "low disk space", "battery", "throttled"}
+
+/*
+ How long the same kind of event isn't notified again by default, so a trigger that fires every few seconds doesn't
+ flood the phone. The events in between are counted, and the count is part of the next notification.
+ */
+const notifyQuiet = 5 * time.Minute
+
+/*
+ How often the recording and the sinks are checked for failures
+ */
+const notifyCheck = 5 * time.Second
+
+/*
+ Opens the notifications of several URLs, each as a sink of its own, so one that fails is retried without sending
+ the others twice:
+    ntfy://ntfy.sh/topic            A push notification through ntfy, ntfys:// uses HTTPS
+    telegram://<chat id>            A message of the bot whose token is in PLOT_TELEGRAM_TOKEN
+    mailto:someone@example.org?smtp=mail.example.org:587[&from=plot@example.org][&user=name]
+                                    An email, the password of the server is in PLOT_SMTP_PASSWORD
+ Every URL takes ?events= (the events that are notified, by the start of their names, separated by commas) and
+ ?quiet= (how long the same event isn't notified again, like 10m).
+ */
+func OpenNotifications(urls []string, pipeline *Pipeline, file string) ([]Sink, error) {
+    sinks := []Sink{}
+    for _, u := range urls {
+        sink, err := openNotification(u, pipeline, file)
+        if err != nil {
+            for _, opened := range sinks {
+                opened.Close()
+            }
+            return nil, fmt.Errorf("--notify %s: %v", u, err)
+        }
+        sinks = append(sinks, sink)
+    }
+    return sinks, nil
+}
+
+func openNotification(description string, pipeline *Pipeline, file string) (Sink, error) {
+    target, err := url.Parse(description)
+    if err != nil {
+        return nil, err
+    }
+    factory, ok := Notifiers[target.Scheme]
+    if !ok {
+        names := []string{}
+        for name := range Notifiers {
+            names = append(names, name)
+        }
+        sort.Strings(names)
+        return nil, fmt.Errorf("unknown notification %s, expected one of %s", target.Scheme,
+            strings.Join(names, ", "))
+    }
+    query := target.Query()
+    sink := &NotificationSink{pipeline: pipeline, file: file, events: notifiedEvents, quiet: notifyQuiet,
+        last: map[string]time.Time{}, missed: map[string]int{}, failing: map[string]bool{}}
+    if events := query.Get("events"); events != "" {
+        sink.events = strings.Split(events, ",")
+    }
+    if quiet := query.Get("quiet"); quiet != "" {
+        sink.quiet, err = time.ParseDuration(quiet)
+        if err != nil || sink.quiet < 0 {
+            return nil, fmt.Errorf("invalid quiet %q, expected something like 10m", quiet)
+        }
+    }
+    query.Del("events")
+    query.Del("quiet")
+    target.RawQuery = query.Encode()
+    sink.notifier, err = factory(target)
+    if err != nil {
+        return nil, err
+    }
+    sink.host, _ = os.Hostname()
+    sink.name = "notify " + target.Redacted()
+    return sink, nil
+}
+
+/*
+ Turns a session into notifications: when it starts and ends, when one of the events happens, and when the
+ recording or a sink fails. A notification that can't be sent fails the write, so the stream tries it again.
+ */
+type NotificationSink struct {
+    notifier Notifier
+    name string
+    pipeline *Pipeline
+    file string
+    host string
+    events []string
+    quiet time.Duration
+    started time.Time
+
+    // When every kind of event was notified the last time, and how often it happened since then
+    last map[string]time.Time
+    missed map[string]int
+
+    // The failures that were notified, so they are notified only once
+    checked time.Time
+    recordingFailed bool
+    failing map[string]bool
+}
+
+func (s *NotificationSink) Write(message interface{}) error {
+    switch m := message.(type) {
+    case SessionMessage:
+        s.started = time.Now()
+        text := "The acquisition started"
+        if s.file != "" {
+            text = fmt.Sprintf("Recording into %s", filepath.Base(s.file))
+        }
+        if m.Subject != "" {
+            text += ", subject " + m.Subject
+        }
+        return s.notify("session started", text, false)
+    case EventMessage:
+        return s.event(m)
+    }
+    if time.Since(s.checked) < notifyCheck {
+        return nil
+    }
+    err := s.check()
+    if err == nil {
+        s.checked = time.Now()
+    }
+    return err
+}
+
+func (s *NotificationSink) String() string {
+    return s.name
+}
+
+/*
+ Notifies that the session ended, and whether the recording survived it
+ */
+func (s *NotificationSink) Close() error {
+    if s.started.IsZero() {
+        return nil
+    }
+    text := fmt.Sprintf("The session ended after %s", formatDuration(time.Since(s.started).Seconds()))
+    err := s.pipeline.RecordingError()
+    if err != nil {
+        text += fmt.Sprintf(", the recording failed: %v", err)
+    }
+    return s.notify("session ended", text, err != nil)
+}
+
+/*
+ Notifies an event, unless the same kind of event was notified a moment ago
+ */
+func (s *NotificationSink) event(event EventMessage) error {
+    kind := ""
+    for _, name := range s.events {
+        if name = strings.TrimSpace(name); name != "" && strings.HasPrefix(event.Event, name) {
+            kind = name
+            break
+        }
+    }
+    if kind == "" {
+        return nil
+    }
+
+    // Everything but triggers and the end of a problem means that the acquisition is in trouble. The end of a
+    // problem is notified apart from its start, so it isn't held back by it.
+    urgent := kind != "triggered" && !strings.Contains(event.Event, "resumed") &&
+        !strings.HasSuffix(event.Event, "ended")
+    if !urgent {
+        kind += " (over)"
+    }
+    if time.Since(s.last[kind]) < s.quiet {
+        s.missed[kind]++
+        return nil
+    }
+    text := fmt.Sprintf("%s at %s", event.Event, formatDuration(event.Time))
+    if missed := s.missed[kind]; missed > 0 {
+        text += fmt.Sprintf(", %d more since the last notification", missed)
+    }
+    err := s.notify(event.Event, text, urgent)
+    if err != nil {
+        return err
+    }
+    s.last[kind] = time.Now()
+    s.missed[kind] = 0
+    return nil
+}
+
+/*
+ Notifies failures of the recording and of the sinks when they start, and when the sinks recover. Failing
+ notifications aren't notified, they would have to be sent through themselves.
+ */
+func (s *NotificationSink) check() error {
+    if err := s.pipeline.RecordingError(); err != nil && !s.recordingFailed {
+        if err := s.notify("recording failed", err.Error(), true); err != nil {
+            return err
+        }
+        s.recordingFailed = true
+    }
+    for _, health := range s.pipeline.SinkHealth() {
+        if strings.HasPrefix(health.Name, "notify ") || health.Healthy == !s.failing[health.Name] {
+            continue
+        }
+        var err error
+        if health.Healthy {
+            err = s.notify("sink recovered", fmt.Sprintf("%s works again", health.Name), false)
+        } else {
+            err = s.notify("sink failing", fmt.Sprintf("%s: %v", health.Name, health.Err), true)
+        }
+        if err != nil {
+            return err
+        }
+        s.failing[health.Name] = !health.Healthy
+    }
+    return nil
+}
+
+func (s *NotificationSink) notify(title string, text string, urgent bool) error {
+    if s.host != "" {
+        title = fmt.Sprintf("plot on %s: %s", s.host, title)
+    } else {
+        title = "plot: " + title
+    }
+    return s.notifier.Notify(title, text, urgent)
+}
+
+/*
+ Sends notifications through ntfy (https://ntfy.sh), to a topic that the phone subscribed to. Urgent ones get a
+ high priority. A token for protected topics is taken from PLOT_NTFY_TOKEN.
+ */
+type ntfyNotifier struct {
+    endpoint string
+    token string
+    client *http.Client
+}
+
+func openNtfy(target *url.URL) (Notifier, error) {
+    topic := strings.Trim(target.Path, "/")
+    if target.Host == "" || topic == "" {
+        return nil, fmt.Errorf("expected ntfy://host/topic")
+    }
+    scheme := "http"
+    if target.Scheme == "ntfys" {
+        scheme = "https"
+    }
+    return &ntfyNotifier{endpoint: scheme + "://" + target.Host + "/" + topic, token: os.Getenv(ntfyTokenVariable),
+        client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+func (n *ntfyNotifier) Notify(title string, text string, urgent bool) error {
+    request, err := http.NewRequest("POST", n.endpoint, strings.NewReader(text))
+    if err != nil {
+        return err
+    }
+    request.Header.Set("Title", title)
+    if urgent {
+        request.Header.Set("Priority", "high")
+        request.Header.Set("Tags", "warning")
+    }
+    if n.token != "" {
+        request.Header.Set("Authorization", "Bearer " + n.token)
+    }
+    return notifyRequest(n.client, request, "ntfy")
+}
+
+/*
+ Sends notifications as messages of a Telegram bot to a chat. The token of the bot is taken from
+ PLOT_TELEGRAM_TOKEN, and the chat has to have talked to the bot before.
+ */
+type telegramNotifier struct {
+    chat string
+    token string
+    client *http.Client
+}
+
+func openTelegram(target *url.URL) (Notifier, error) {
+    chat := target.Host
+    if chat == "" {
+        chat = target.Opaque
+    }
+    if chat == "" {
+        return nil, fmt.Errorf("expected telegram://<chat id>")
+    }
+    token := os.Getenv(telegramTokenVariable)
+    if token == "" {
+        return nil, fmt.Errorf("the token of the bot is missing, set %s", telegramTokenVariable)
+    }
+    return &telegramNotifier{chat: chat, token: token, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+func (n *telegramNotifier) Notify(title string, text string, urgent bool) error {
+    if urgent {
+        title = "⚠ " + title
+    }
+    form := url.Values{"chat_id": {n.chat}, "text": {title + "\n" + text}}
+    endpoint := "https://api.telegram.org/bot" + n.token + "/sendMessage"
+    request, err := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+    if err != nil {
+        return err
+    }
+    request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    return notifyRequest(n.client, request, "telegram")
+}
+
+/*
+ Sends notifications as emails through an SMTP server, which is asked for STARTTLS if it offers it. The server
+ authenticates the sender with the user (the sender by default) and the password from PLOT_SMTP_PASSWORD, or not at
+ all without a password.
+ */
+type mailNotifier struct {
+    to string
+    from string
+    server string
+    auth smtp.Auth
+}
+
+func openMail(target *url.URL) (Notifier, error) {
+    query := target.Query()
+    to, server := target.Opaque, query.Get("smtp")
+    if to == "" || server == "" {
+        return nil, fmt.Errorf("expected mailto:someone@example.org?smtp=host:port")
+    }
+    if !strings.Contains(server, ":") {
+        server += ":587"
+    }
+    from := query.Get("from")
+    if from == "" {
+        from = "plot@" + strings.SplitN(server, ":", 2)[0]
+    }
+    notifier := &mailNotifier{to: to, from: from, server: server}
+    if password := os.Getenv(smtpPasswordVariable); password != "" {
+        user := query.Get("user")
+        if user == "" {
+            user = from
+        }
+        notifier.auth = smtp.PlainAuth("", user, password, strings.SplitN(server, ":", 2)[0])
+    }
+    return notifier, nil
+}
+
+func (n *mailNotifier) Notify(title string, text string, urgent bool) error {
+    var mail bytes.Buffer
+    fmt.Fprintf(&mail, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n", n.from, n.to,
+        mime.QEncoding.Encode("utf-8", title),
+        time.Now().Format(time.RFC1123Z))
+    if urgent {
+        mail.WriteString("X-Priority: 1\r\n")
+    }
+    fmt.Fprintf(&mail, "Content-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n", text)
+    return smtp.SendMail(n.server, n.auth, n.from, []string{n.to}, mail.Bytes())
+}
+
+/*
+ Sends the request of a notification, and turns a status that isn't 2xx into an error
+ */
+func notifyRequest(client *http.Client, request *http.Request, service string) error {
+    response, err := client.Do(request)
+    if err != nil {
+        // The error contains the URL, which contains the token of Telegram
+        if failure, ok := err.(*url.Error); ok {
+            return fmt.Errorf("%s: %v", service, failure.Err)
+        }
+        return err
+    }
+    defer response.Body.Close()
+    if response.StatusCode / 100 != 2 {
+        body, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+        return fmt.Errorf("%s: %s: %s", service, response.Status, strings.TrimSpace(string(body)))
+    }
+    return nil
+}
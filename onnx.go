@@ -0,0 +1,696 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "fmt"
+    "math"
+    "strings"
+    "net/url"
+    "encoding/binary"
+)
+
+func init() {
+    Models["onnx"] = openONNXModel
+}
+
+/*
+ A model that was trained elsewhere, like with PyTorch or scikit-learn, and exported as ONNX:
+    onnx:<path>?labels=rest,fist,open
+ The model gets the features as a float tensor of the shape [1, features], and its first output is either a score
+ for every label, which is turned into probabilities with a softmax unless it already is, or the number of the class.
+ Without labels, the classes are named by their number. Only the operators that small networks and linear models are
+ made of are supported (see onnxOperators), which is checked when the model is loaded, and the model is evaluated
+ without any library.
+ */
+type ONNXModel struct {
+    graph onnxGraph
+    labels []string
+}
+
+func openONNXModel(target *url.URL, features []Feature) (Model, error) {
+    path := target.Opaque
+    if path == "" {
+        path = target.Path
+    }
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    graph, err := parseONNX(data)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", path, err)
+    }
+    if graph.width > 0 && graph.width != len(features) {
+        return nil, fmt.Errorf("%s: the model takes %d features, but the classifier has %d", path, graph.width,
+            len(features))
+    }
+    model := &ONNXModel{graph: graph}
+    if labels := target.Query().Get("labels"); labels != "" {
+        model.labels = strings.Split(labels, ",")
+    }
+
+    // Try the model once, so it doesn't fail during the acquisition
+    _, err = graph.run(make([]float64, len(features)))
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", path, err)
+    }
+    return model, nil
+}
+
+func (m *ONNXModel) Classify(features []float64) (string, float64) {
+    output, err := m.graph.run(features)
+    if err != nil || len(output.data) == 0 {
+        return "", 0
+    }
+    if len(output.data) == 1 {
+        return m.label(int(math.Round(output.data[0]))), 1
+    }
+
+    // Scores that aren't probabilities yet go through a softmax
+    sum := 0.0
+    negative := false
+    for _, v := range output.data {
+        sum += v
+        negative = negative || v < 0
+    }
+    probabilities := output.data
+    if negative || math.Abs(sum - 1) > 1e-3 {
+        probabilities = softmax(output.data)
+    }
+    best := 0
+    for i, p := range probabilities {
+        if p > probabilities[best] {
+            best = i
+        }
+    }
+    return m.label(best), probabilities[best]
+}
+
+func (m *ONNXModel) label(class int) string {
+    if class >= 0 && class < len(m.labels) {
+        return m.labels[class]
+    }
+    return fmt.Sprint(class)
+}
+
+/*
+ A tensor while the graph is evaluated. Every type of number is kept as float64.
+ */
+type onnxTensor struct {
+    shape []int
+    data []float64
+}
+
+type onnxNode struct {
+    operator string
+    inputs []string
+    outputs []string
+    ints map[string][]int
+    floats map[string]float64
+}
+
+/*
+ The graph of a model, with its weights, the name of its input and the number of features it takes, zero if the
+ model doesn't tell
+ */
+type onnxGraph struct {
+    nodes []onnxNode
+    initializers map[string]onnxTensor
+    input string
+    output string
+    width int
+}
+
+/*
+ The operators that are supported, with how they compute their first output from their inputs
+ */
+var onnxOperators = map[string]func(node onnxNode, inputs []onnxTensor) (onnxTensor, error){
+    "Gemm": onnxGemm,
+    "MatMul": func(node onnxNode, inputs []onnxTensor) (onnxTensor, error) {
+        return matmul(inputs[0], inputs[1], false, false)
+    },
+    "Add": func(node onnxNode, inputs []onnxTensor) (onnxTensor, error) {
+        return broadcast(inputs[0], inputs[1], func(a float64, b float64) float64 { return a + b })
+    },
+    "Sub": func(node onnxNode, inputs []onnxTensor) (onnxTensor, error) {
+        return broadcast(inputs[0], inputs[1], func(a float64, b float64) float64 { return a - b })
+    },
+    "Mul": func(node onnxNode, inputs []onnxTensor) (onnxTensor, error) {
+        return broadcast(inputs[0], inputs[1], func(a float64, b float64) float64 { return a * b })
+    },
+    "Div": func(node onnxNode, inputs []onnxTensor) (onnxTensor, error) {
+        return broadcast(inputs[0], inputs[1], func(a float64, b float64) float64 { return a / b })
+    },
+    "Relu": func(node onnxNode, inputs []onnxTensor) (onnxTensor, error) {
+        return elementwise(inputs[0], func(v float64) float64 { return math.Max(0, v) }), nil
+    },
+    "LeakyRelu": func(node onnxNode, inputs []onnxTensor) (onnxTensor, error) {
+        alpha := node.float("alpha", 0.01)
+        return elementwise(inputs[0], func(v float64) float64 { return math.Max(v, v * alpha) }), nil
+    },
+    "Sigmoid": func(node onnxNode, inputs []onnxTensor) (onnxTensor, error) {
+        return elementwise(inputs[0], func(v float64) float64 { return 1 / (1 + math.Exp(-v)) }), nil
+    },
+    "Tanh": func(node onnxNode, inputs []onnxTensor) (onnxTensor, error) {
+        return elementwise(inputs[0], math.Tanh), nil
+    },
+    "Softmax": func(node onnxNode, inputs []onnxTensor) (onnxTensor, error) {
+        return lastAxis(inputs[0], softmax), nil
+    },
+    "ArgMax": func(node onnxNode, inputs []onnxTensor) (onnxTensor, error) {
+        return lastAxis(inputs[0], func(values []float64) []float64 {
+            best := 0
+            for i, v := range values {
+                if v > values[best] {
+                    best = i
+                }
+            }
+            return []float64{float64(best)}
+        }), nil
+    },
+    "Identity": reshaped,
+    "Dropout": reshaped,
+    "Cast": reshaped,
+    "Flatten": reshaped,
+    "Squeeze": reshaped,
+    "Unsqueeze": reshaped,
+    "Reshape": reshaped,
+}
+
+/*
+ The operators that combine two inputs
+ */
+var onnxBinary = map[string]bool{"Gemm": true, "MatMul": true, "Add": true, "Sub": true, "Mul": true, "Div": true}
+
+/*
+ Evaluates the graph for the features, and returns its first output
+ */
+func (g onnxGraph) run(features []float64) (onnxTensor, error) {
+    values := map[string]onnxTensor{g.input: {shape: []int{1, len(features)}, data: features}}
+    for name, tensor := range g.initializers {
+        values[name] = tensor
+    }
+    for _, node := range g.nodes {
+        inputs := []onnxTensor{}
+        for _, name := range node.inputs {
+            if name == "" {
+                continue
+            }
+            tensor, ok := values[name]
+            if !ok {
+                return onnxTensor{}, fmt.Errorf("%s: unknown input %s", node.operator, name)
+            }
+            inputs = append(inputs, tensor)
+        }
+        if len(inputs) == 0 || len(node.outputs) == 0 {
+            return onnxTensor{}, fmt.Errorf("%s without inputs or outputs", node.operator)
+        }
+        if len(inputs) < 2 && onnxBinary[node.operator] {
+            return onnxTensor{}, fmt.Errorf("%s needs two inputs", node.operator)
+        }
+        output, err := onnxOperators[node.operator](node, inputs)
+        if err != nil {
+            return onnxTensor{}, fmt.Errorf("%s: %v", node.operator, err)
+        }
+        values[node.outputs[0]] = output
+    }
+    output, ok := values[g.output]
+    if !ok {
+        return onnxTensor{}, fmt.Errorf("the output %s is never computed", g.output)
+    }
+    return output, nil
+}
+
+func (n onnxNode) float(name string, fallback float64) float64 {
+    if value, ok := n.floats[name]; ok {
+        return value
+    }
+    return fallback
+}
+
+func (n onnxNode) int(name string, fallback int) int {
+    if value, ok := n.ints[name]; ok && len(value) > 0 {
+        return value[0]
+    }
+    return fallback
+}
+
+func onnxGemm(node onnxNode, inputs []onnxTensor) (onnxTensor, error) {
+    product, err := matmul(inputs[0], inputs[1], node.int("transA", 0) != 0, node.int("transB", 0) != 0)
+    if err != nil {
+        return product, err
+    }
+    alpha := node.float("alpha", 1)
+    product = elementwise(product, func(v float64) float64 { return v * alpha })
+    if len(inputs) < 3 {
+        return product, nil
+    }
+    beta := node.float("beta", 1)
+    return broadcast(product, elementwise(inputs[2], func(v float64) float64 { return v * beta }),
+        func(a float64, b float64) float64 { return a + b })
+}
+
+/*
+ Multiplies two matrices. A vector on the left is a row, one on the right a column.
+ */
+func matmul(a onnxTensor, b onnxTensor, transposeA bool, transposeB bool) (onnxTensor, error) {
+    rows, inner := matrixShape(a, true)
+    other, columns := matrixShape(b, false)
+    if transposeA {
+        rows, inner = inner, rows
+    }
+    if transposeB {
+        other, columns = columns, other
+    }
+    if inner != other {
+        return onnxTensor{}, fmt.Errorf("can't multiply %v with %v", a.shape, b.shape)
+    }
+    at := func(t onnxTensor, transposed bool, width int, i int, j int) float64 {
+        if transposed {
+            i, j = j, i
+        }
+        return t.data[i * width + j]
+    }
+    widthA, widthB := inner, columns
+    if transposeA {
+        widthA = rows
+    }
+    if transposeB {
+        widthB = inner
+    }
+    result := onnxTensor{shape: []int{rows, columns}, data: make([]float64, rows * columns)}
+    for i := 0; i < rows; i++ {
+        for j := 0; j < columns; j++ {
+            sum := 0.0
+            for k := 0; k < inner; k++ {
+                sum += at(a, transposeA, widthA, i, k) * at(b, transposeB, widthB, k, j)
+            }
+            result.data[i * columns + j] = sum
+        }
+    }
+    return result, nil
+}
+
+/*
+ Returns the rows and the columns of a tensor as a matrix, the leading dimensions are folded into the rows
+ */
+func matrixShape(t onnxTensor, row bool) (int, int) {
+    if len(t.shape) == 0 {
+        return 1, 1
+    }
+    if len(t.shape) == 1 {
+        if row {
+            return 1, t.shape[0]
+        }
+        return t.shape[0], 1
+    }
+    columns := t.shape[len(t.shape) - 1]
+    return len(t.data) / max(1, columns), columns
+}
+
+/*
+ Combines two tensors element by element. The smaller one is repeated if the larger one is a multiple of it, which
+ covers the broadcasting of a bias or a scalar.
+ */
+func broadcast(a onnxTensor, b onnxTensor, combine func(float64, float64) float64) (onnxTensor, error) {
+    swapped := len(b.data) > len(a.data)
+    if swapped {
+        a, b = b, a
+    }
+    if len(b.data) == 0 || len(a.data) % len(b.data) != 0 {
+        return onnxTensor{}, fmt.Errorf("can't broadcast %v to %v", b.shape, a.shape)
+    }
+    result := onnxTensor{shape: a.shape, data: make([]float64, len(a.data))}
+    for i, v := range a.data {
+        if swapped {
+            result.data[i] = combine(b.data[i % len(b.data)], v)
+        } else {
+            result.data[i] = combine(v, b.data[i % len(b.data)])
+        }
+    }
+    return result, nil
+}
+
+func elementwise(t onnxTensor, apply func(float64) float64) onnxTensor {
+    result := onnxTensor{shape: t.shape, data: make([]float64, len(t.data))}
+    for i, v := range t.data {
+        result.data[i] = apply(v)
+    }
+    return result
+}
+
+/*
+ Applies a function to every row along the last axis of a tensor
+ */
+func lastAxis(t onnxTensor, apply func([]float64) []float64) onnxTensor {
+    _, columns := matrixShape(t, true)
+    result := onnxTensor{}
+    for i := 0; i + columns <= len(t.data); i += columns {
+        result.data = append(result.data, apply(t.data[i:i + columns])...)
+    }
+    rows := max(1, len(t.data) / max(1, columns))
+    result.shape = []int{rows, len(result.data) / rows}
+    return result
+}
+
+/*
+ Operators that only change the shape or the type of a tensor, which doesn't matter for the single window that the
+ graph is evaluated for. The result is a row.
+ */
+func reshaped(node onnxNode, inputs []onnxTensor) (onnxTensor, error) {
+    return onnxTensor{shape: []int{1, len(inputs[0].data)}, data: inputs[0].data}, nil
+}
+
+func softmax(values []float64) []float64 {
+    highest := math.Inf(-1)
+    for _, v := range values {
+        highest = math.Max(highest, v)
+    }
+    sum := 0.0
+    result := make([]float64, len(values))
+    for i, v := range values {
+        result[i] = math.Exp(v - highest)
+        sum += result[i]
+    }
+    for i := range result {
+        result[i] /= sum
+    }
+    return result
+}
+
+/*
+ Reads the fields of a protocol buffer message. Varints and fixed numbers are passed as value, everything that is
+ delimited by its length as data.
+ */
+func readProtobuf(data []byte, field func(number int, value uint64, data []byte) error) error {
+    for len(data) > 0 {
+        key, n := binary.Uvarint(data)
+        if n <= 0 {
+            return fmt.Errorf("invalid protocol buffer")
+        }
+        data = data[n:]
+        var value uint64
+        var content []byte
+        switch key & 7 {
+        case 0:
+            value, n = binary.Uvarint(data)
+            if n <= 0 {
+                return fmt.Errorf("invalid protocol buffer")
+            }
+            data = data[n:]
+        case 1:
+            if len(data) < 8 {
+                return fmt.Errorf("invalid protocol buffer")
+            }
+            value, data = binary.LittleEndian.Uint64(data), data[8:]
+        case 2:
+            length, n := binary.Uvarint(data)
+            if n <= 0 || uint64(len(data) - n) < length {
+                return fmt.Errorf("invalid protocol buffer")
+            }
+            content, data = data[n:n + int(length)], data[n + int(length):]
+        case 5:
+            if len(data) < 4 {
+                return fmt.Errorf("invalid protocol buffer")
+            }
+            value, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+        default:
+            return fmt.Errorf("unsupported wire type %d", key & 7)
+        }
+        err := field(int(key >> 3), value, content)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+/*
+ Reads the numbers of a repeated field, which are either packed into one field, or one field each
+ */
+func readRepeated(value uint64, data []byte, fixed int) ([]uint64, error) {
+    if data == nil {
+        return []uint64{value}, nil
+    }
+    values := []uint64{}
+    for len(data) > 0 {
+        switch fixed {
+        case 4:
+            if len(data) < 4 {
+                return nil, fmt.Errorf("invalid packed field")
+            }
+            values, data = append(values, uint64(binary.LittleEndian.Uint32(data))), data[4:]
+        case 8:
+            if len(data) < 8 {
+                return nil, fmt.Errorf("invalid packed field")
+            }
+            values, data = append(values, binary.LittleEndian.Uint64(data)), data[8:]
+        default:
+            v, n := binary.Uvarint(data)
+            if n <= 0 {
+                return nil, fmt.Errorf("invalid packed field")
+            }
+            values, data = append(values, v), data[n:]
+        }
+    }
+    return values, nil
+}
+
+/*
+ Reads the graph of a model (ModelProto in onnx.proto)
+ */
+func parseONNX(data []byte) (onnxGraph, error) {
+    var graph onnxGraph
+    found := false
+    err := readProtobuf(data, func(number int, value uint64, content []byte) error {
+        if number != 7 {
+            return nil
+        }
+        found = true
+        var err error
+        graph, err = parseONNXGraph(content)
+        return err
+    })
+    if err == nil && !found {
+        err = fmt.Errorf("not an ONNX model")
+    }
+    return graph, err
+}
+
+func parseONNXGraph(data []byte) (onnxGraph, error) {
+    graph := onnxGraph{initializers: map[string]onnxTensor{}}
+    inputs := [][]byte{}
+    err := readProtobuf(data, func(number int, value uint64, content []byte) error {
+        switch number {
+        case 1:
+            node, err := parseONNXNode(content)
+            graph.nodes = append(graph.nodes, node)
+            return err
+        case 5:
+            name, tensor, err := parseONNXTensor(content)
+            graph.initializers[name] = tensor
+            return err
+        case 11:
+            inputs = append(inputs, content)
+        case 12:
+            if graph.output == "" {
+                graph.output, _ = parseONNXValue(content)
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return graph, err
+    }
+
+    // The inputs list the initializers as well in older models
+    for _, input := range inputs {
+        name, width := parseONNXValue(input)
+        if _, ok := graph.initializers[name]; !ok && graph.input == "" {
+            graph.input, graph.width = name, width
+        }
+    }
+    if graph.input == "" || graph.output == "" {
+        return graph, fmt.Errorf("the model has no input or no output")
+    }
+    for _, node := range graph.nodes {
+        if _, ok := onnxOperators[node.operator]; !ok {
+            return graph, fmt.Errorf("the operator %s is not supported", node.operator)
+        }
+    }
+    return graph, nil
+}
+
+func parseONNXNode(data []byte) (onnxNode, error) {
+    node := onnxNode{ints: map[string][]int{}, floats: map[string]float64{}}
+    err := readProtobuf(data, func(number int, value uint64, content []byte) error {
+        switch number {
+        case 1:
+            node.inputs = append(node.inputs, string(content))
+        case 2:
+            node.outputs = append(node.outputs, string(content))
+        case 4:
+            node.operator = string(content)
+        case 5:
+            return parseONNXAttribute(content, node)
+        }
+        return nil
+    })
+    return node, err
+}
+
+/*
+ Reads the numbers of an attribute of a node into the node. Attributes of other types aren't needed by any of the
+ supported operators.
+ */
+func parseONNXAttribute(data []byte, node onnxNode) error {
+    name := ""
+    var floats []float64
+    var ints []int
+    err := readProtobuf(data, func(number int, value uint64, content []byte) error {
+        switch number {
+        case 1:
+            name = string(content)
+        case 2:
+            floats = append(floats, float64(math.Float32frombits(uint32(value))))
+        case 3:
+            ints = append(ints, int(int64(value)))
+        case 8:
+            values, err := readRepeated(value, content, 0)
+            for _, v := range values {
+                ints = append(ints, int(int64(v)))
+            }
+            return err
+        }
+        return nil
+    })
+    if len(floats) > 0 {
+        node.floats[name] = floats[0]
+    }
+    if len(ints) > 0 {
+        node.ints[name] = ints
+    }
+    return err
+}
+
+/*
+ Reads a tensor (TensorProto) with its name. Floats, doubles and integers are supported, in their fields or as raw
+ data.
+ */
+func parseONNXTensor(data []byte) (string, onnxTensor, error) {
+    name := ""
+    kind := 0
+    var raw []byte
+    tensor := onnxTensor{}
+    err := readProtobuf(data, func(number int, value uint64, content []byte) error {
+        var values []uint64
+        var err error
+        switch number {
+        case 1:
+            values, err = readRepeated(value, content, 0)
+            for _, v := range values {
+                tensor.shape = append(tensor.shape, int(int64(v)))
+            }
+        case 2:
+            kind = int(value)
+        case 4:
+            values, err = readRepeated(value, content, 4)
+            for _, v := range values {
+                tensor.data = append(tensor.data, float64(math.Float32frombits(uint32(v))))
+            }
+        case 5, 7:
+            values, err = readRepeated(value, content, 0)
+            for _, v := range values {
+                tensor.data = append(tensor.data, float64(int64(v)))
+            }
+        case 8:
+            name = string(content)
+        case 9:
+            raw = content
+        case 10:
+            values, err = readRepeated(value, content, 8)
+            for _, v := range values {
+                tensor.data = append(tensor.data, math.Float64frombits(v))
+            }
+        }
+        return err
+    })
+    if err != nil || raw == nil {
+        return name, tensor, err
+    }
+
+    // The raw data is little endian, in the size of the type
+    switch kind {
+    case 1:
+        for i := 0; i + 4 <= len(raw); i += 4 {
+            tensor.data = append(tensor.data, float64(math.Float32frombits(binary.LittleEndian.Uint32(raw[i:]))))
+        }
+    case 6:
+        for i := 0; i + 4 <= len(raw); i += 4 {
+            tensor.data = append(tensor.data, float64(int32(binary.LittleEndian.Uint32(raw[i:]))))
+        }
+    case 7:
+        for i := 0; i + 8 <= len(raw); i += 8 {
+            tensor.data = append(tensor.data, float64(int64(binary.LittleEndian.Uint64(raw[i:]))))
+        }
+    case 11:
+        for i := 0; i + 8 <= len(raw); i += 8 {
+            tensor.data = append(tensor.data, math.Float64frombits(binary.LittleEndian.Uint64(raw[i:])))
+        }
+    default:
+        return name, tensor, fmt.Errorf("the tensor %s has the unsupported type %d", name, kind)
+    }
+    return name, tensor, nil
+}
+
+/*
+ Reads the name of an input or an output (ValueInfoProto), and the size of its last dimension, zero if it isn't known
+ */
+func parseONNXValue(data []byte) (string, int) {
+    name := ""
+    width := 0
+    readProtobuf(data, func(number int, value uint64, content []byte) error {
+        if number == 1 {
+            name = string(content)
+        }
+        if number != 2 {
+            return nil
+        }
+
+        // The type holds the type of the tensor, which holds the shape, which holds the dimensions
+        shape := protobufField(protobufField(content, 1), 2)
+        return readProtobuf(shape, func(number int, value uint64, dimension []byte) error {
+            if number == 1 {
+                width = 0
+                readProtobuf(dimension, func(number int, value uint64, content []byte) error {
+                    if number == 1 {
+                        width = int(value)
+                    }
+                    return nil
+                })
+            }
+            return nil
+        })
+    })
+    return name, width
+}
+
+/*
+ Returns the content of the last field of a message with the given number, or nil if it has none
+ */
+func protobufField(data []byte, wanted int) []byte {
+    var found []byte
+    readProtobuf(data, func(number int, value uint64, content []byte) error {
+        if number == wanted {
+            found = content
+        }
+        return nil
+    })
+    return found
+}
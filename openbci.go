@@ -0,0 +1,348 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "fmt"
+    "time"
+    "bufio"
+    "bytes"
+    "strconv"
+    "strings"
+    "net/url"
+)
+
+func init() {
+    Sources["cyton"] = grabDataFromCyton
+    SourceChannels["cyton"] = describeCyton
+    SourceBackends["cyton"] = Backend{
+        Usage: "cyton:///dev/ttyUSB0?channel=1&gain=24&daisy=1",
+        Description: "An OpenBCI Cyton board, with or without the Daisy module, through its USB dongle",
+        Probe: probeSerial,
+    }
+}
+
+// Constants of the serial protocol of the Cyton (see the OpenBCI Cyton Data Format)
+const (
+    cytonBaud = "115200"
+    cytonPacket = 33
+    cytonHeader = 0xA0
+    cytonFooter = 0xC0
+    cytonChannels = 8
+    cytonRate = 250
+
+    // The reference voltage of the ADS1299, and the largest value of its 24 bit samples
+    cytonReference = 4.5
+    ads1299Maximum = 1 << 23 - 1
+)
+
+/*
+ How long the board may take to answer a command. A reset of the Cyton takes about a second.
+ */
+const cytonTimeout = 5 * time.Second
+
+/*
+ The codes of the gains of the ADS1299 in the channel settings of the Cyton
+ */
+var cytonGains = map[int]byte{1: '0', 2: '1', 4: '2', 6: '3', 8: '4', 12: '5', 24: '6'}
+
+/*
+ The keys that select the channels in the channel settings, first those of the board, then those of the Daisy
+ */
+const cytonChannelKeys = "12345678QWERTYUI"
+
+/*
+ This function reads an OpenBCI Cyton board through its USB dongle, for labs that already use the board for EEG or
+ EMG and don't want to bridge it through the Python tools of OpenBCI. The source is given as
+    cyton://<port>?channel=<channel>&gain=<gain>&daisy=<0|1>
+ where the port is the serial port of the dongle (the first one that is found if it is left out). The board streams
+ 8 channels at 250 samples per second, or 16 channels at 125 samples per second with the Daisy module, which is used
+ if it is present unless daisy=0 is given. The given channel (starting at 1) is the muscle sensor, the others are
+ recorded as auxiliary channels in volts, named after their number (ch1, ch2 ...). If a gain is given, every channel
+ is set to it (1, 2, 4, 6, 8, 12 or 24), otherwise the board has to use its default gain of 24. Samples that the
+ dongle lost leave a gap in the time instead of shifting everything after them.
+ */
+func grabDataFromCyton(pipeline *Pipeline, target *url.URL) error {
+    query := target.Query()
+    channel, err := queryInt(query, "channel", 1)
+    if err != nil {
+        return err
+    }
+    gain, err := queryInt(query, "gain", 24)
+    if err != nil {
+        return err
+    }
+    if _, ok := cytonGains[gain]; !ok {
+        return fmt.Errorf("the Cyton doesn't support a gain of %d", gain)
+    }
+    port, reader, daisy, err := connectCyton(target)
+    if err != nil {
+        return err
+    }
+    defer port.Close()
+    channels := cytonChannels
+    rate := cytonRate
+    if daisy {
+        channels, rate = 2 * cytonChannels, cytonRate / 2
+    }
+    if channel < 1 || channel > channels {
+        return fmt.Errorf("the Cyton has no channel %d", channel)
+    }
+
+    // Power up every channel with the gain, as a normal input that is referenced to SRB2 and part of the bias
+    if query.Get("gain") != "" {
+        for i := 0; i < channels; i++ {
+            port.Write([]byte{'x', cytonChannelKeys[i], '0', cytonGains[gain], '0', '1', '1', '0', 'X'})
+            _, err = readCytonReply(port, reader)
+            if err != nil {
+                return err
+            }
+        }
+    }
+
+    // The clock of the board defines the timing
+    Settings.Interval = 1 / float64(rate)
+    startRecording(pipeline, "hardware")
+    if Settings.Watchdog > 0 {
+        go RunWatchdog(pipeline, watchdogTimeout())
+    }
+    _, err = port.Write([]byte("b"))
+    if err != nil {
+        return err
+    }
+    defer port.Write([]byte("s"))
+
+    // With the Daisy, a packet of the board (odd sample number) is followed by one of the Daisy (even sample number)
+    scale := cytonReference / float64(gain) / ads1299Maximum
+    values := make([]float64, channels)
+    x := -1
+    last := 0
+    board := -1
+    for true {
+        packet, err := readCytonPacket(reader)
+        if err != nil {
+            return err
+        }
+        number := int(packet[1])
+        if daisy && number % 2 == 1 {
+            decodeCyton(packet, values[:cytonChannels], scale)
+            board = number
+            continue
+        }
+        if daisy {
+            if board == -1 || (board + 1) % 256 != number {
+                board = -1
+                continue
+            }
+            decodeCyton(packet, values[cytonChannels:], scale)
+            board = -1
+        } else {
+            decodeCyton(packet, values, scale)
+        }
+
+        // The sample number wraps around after 255
+        step := 1
+        if x >= 0 {
+            step = (number - last + 256) % 256
+            if daisy {
+                step /= 2
+            }
+        }
+        last = number
+        x += max(step, 1)
+        pushOpenBCI(pipeline, x, values, channel)
+    }
+    return nil
+}
+
+/*
+ Describes the channels of the Cyton. Only the board can tell whether the Daisy is attached, so it is connected once
+ before the acquisition connects to it again.
+ */
+func describeCyton(target *url.URL) (AuxChannels, error) {
+    channel, err := queryInt(target.Query(), "channel", 1)
+    if err != nil {
+        return nil, err
+    }
+    port, _, daisy, err := connectCyton(target)
+    if err != nil {
+        return nil, err
+    }
+    port.Close()
+    channels := cytonChannels
+    if daisy {
+        channels = 2 * cytonChannels
+    }
+    if channel < 1 || channel > channels {
+        return nil, fmt.Errorf("the Cyton has no channel %d", channel)
+    }
+    return openBCIChannels(channels, channel), nil
+}
+
+/*
+ Opens the serial port of the Cyton, stops a stream that is still running and resets the board. Returns whether the
+ Daisy is used, which it is if it is attached, unless daisy=0 is given.
+ */
+func connectCyton(target *url.URL) (*os.File, *bufio.Reader, bool, error) {
+    device := serialDevice(target)
+    if device == "" {
+        return nil, nil, false, fmt.Errorf("no serial port found for the Cyton")
+    }
+
+    // Switch the port into raw mode, since the samples are binary
+    err := stty("-F", device, cytonBaud, "raw", "-echo")
+    if err != nil {
+        return nil, nil, false, fmt.Errorf("failed to configure %s: %v", device, err)
+    }
+    port, err := os.OpenFile(device, os.O_RDWR, 0)
+    if err != nil {
+        return nil, nil, false, err
+    }
+    reader := bufio.NewReader(port)
+
+    // The reply to the reset says whether a Daisy is attached
+    port.Write([]byte("s"))
+    time.Sleep(100 * time.Millisecond)
+    reader.Discard(reader.Buffered())
+    port.Write([]byte("v"))
+    reply, err := readCytonReply(port, reader)
+    if err != nil {
+        port.Close()
+        return nil, nil, false, err
+    }
+    daisy := strings.Contains(reply, "Daisy")
+    if daisy && target.Query().Get("daisy") == "0" {
+        port.Write([]byte("c"))
+        _, err = readCytonReply(port, reader)
+        if err != nil {
+            port.Close()
+            return nil, nil, false, err
+        }
+        daisy = false
+    }
+    return port, reader, daisy, nil
+}
+
+/*
+ Reads what the Cyton answers to a command, which ends with $$$
+ */
+func readCytonReply(port *os.File, reader *bufio.Reader) (string, error) {
+    port.SetReadDeadline(time.Now().Add(cytonTimeout))
+    defer port.SetReadDeadline(time.Time{})
+    reply := []byte{}
+    for !bytes.HasSuffix(reply, []byte("$$$")) {
+        b, err := reader.ReadByte()
+        if os.IsTimeout(err) {
+            return "", fmt.Errorf("the Cyton doesn't answer, is the board switched on?")
+        }
+        if err != nil {
+            return "", err
+        }
+        reply = append(reply, b)
+    }
+    return string(reply), nil
+}
+
+/*
+ Reads the next packet of the stream. If a byte got lost, the stream is searched for the start of the next packet.
+ */
+func readCytonPacket(reader *bufio.Reader) ([]byte, error) {
+    for true {
+        head, err := reader.Peek(cytonPacket)
+        if err != nil {
+            return nil, err
+        }
+        if head[0] != cytonHeader || head[cytonPacket - 1] & 0xF0 != cytonFooter {
+            reader.Discard(1)
+            continue
+        }
+        packet := make([]byte, cytonPacket)
+        copy(packet, head)
+        reader.Discard(cytonPacket)
+        return packet, nil
+    }
+    return nil, nil
+}
+
+/*
+ Converts the 24 bit samples of the channels in a packet into volts
+ */
+func decodeCyton(packet []byte, values []float64, scale float64) {
+    for i := range values {
+        values[i] = float64(int24(packet[2 + 3 * i:])) * scale
+    }
+}
+
+/*
+ Decodes a signed 24 bit integer in big endian byte order
+ */
+func int24(data []byte) int32 {
+    return int32(uint32(data[0]) << 24 | uint32(data[1]) << 16 | uint32(data[2]) << 8) >> 8
+}
+
+/*
+ Describes the channels of an OpenBCI board that aren't the muscle sensor as auxiliary channels
+ */
+func openBCIChannels(channels int, channel int) AuxChannels {
+    aux := AuxChannels{}
+    for i := 1; i <= channels; i++ {
+        if i != channel {
+            aux = append(aux, AuxChannel{Name: "ch" + strconv.Itoa(i), Unit: "V", Sensor: "openbci"})
+        }
+    }
+    return aux
+}
+
+/*
+ Writes the values of every channel of an OpenBCI board into the pipeline, the given one as the muscle sensor
+ */
+func pushOpenBCI(pipeline *Pipeline, x int, values []float64, channel int) {
+    sample := Sample{Index: x, Time: float64(x) * Settings.Interval, Value: values[channel - 1]}
+    for i, value := range values {
+        if i != channel - 1 {
+            sample.Aux = append(sample.Aux, value)
+        }
+    }
+    pipeline.Push(sample)
+}
+
+/*
+ Returns the serial port of a source URL, or the first one of the device if the URL doesn't name one
+ */
+func serialDevice(target *url.URL) string {
+    if target.Path != "" {
+        return target.Path
+    }
+    if target.Opaque != "" {
+        return target.Opaque
+    }
+    ports := SerialPorts()
+    if len(ports) == 0 {
+        return ""
+    }
+    return ports[0]
+}
+
+/*
+ Checks whether stty is installed and a serial port is present. With a URL, the port has to exist.
+ */
+func probeSerial(target *url.URL) error {
+    err := probeProgram("stty")
+    if err != nil {
+        return err
+    }
+    if target == nil {
+        target = &url.URL{}
+    }
+    device := serialDevice(target)
+    if device == "" {
+        return fmt.Errorf("no serial port found")
+    }
+    _, err = os.Stat(device)
+    return err
+}
@@ -0,0 +1,180 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "math"
+    "strings"
+    "unicode/utf8"
+)
+
+/*
+ How many lines the overview of a recording takes above the chart: the strip with the signal, and the times below it
+ */
+const overviewLines = 3
+
+/*
+ How many parts the overview of a recording has at least. A block of the index is 2 seconds at 500 Hz, which is wider
+ than a column for recordings of a few minutes and would smear a short burst over the columns of the whole block.
+ */
+const overviewDetail = 1024
+
+/*
+ Loads the index of a recording for its overview in the background, since recordings without one have to be read to
+ create it. Recordings with fewer blocks than overviewDetail are short enough to be read for finer blocks, see
+ refineOverview. The index of an encrypted recording has no ranges, so they are always read from the decrypted
+ recording. The channel receives nil if the recording can't be indexed.
+ */
+func LoadOverview(file string) chan []IndexBlock {
+    loaded := make(chan []IndexBlock, 1)
+    go func() {
+        index, err := ReadIndex(file)
+        if err == nil && index == nil {
+            index, err = BuildIndex(file)
+        }
+        meta, _ := LoadMetadata(file)
+        if err == nil && len(index) > 0 && (len(index) < overviewDetail || meta.Encrypted) {
+            index, err = refineOverview(file, index)
+        }
+        if err != nil {
+            index = nil
+        }
+        loaded <- index
+    }()
+    return loaded
+}
+
+/*
+ Splits a recording into overviewDetail blocks of the same duration with the minimum and the maximum of the signal in
+ them, like MinMax does for a series. Only the times and the ranges of these blocks are set, they can't be used to
+ seek.
+ */
+func refineOverview(file string, index []IndexBlock) ([]IndexBlock, error) {
+    start, end := index[0].Start, index[len(index) - 1].End
+    if end <= start {
+        return index, nil
+    }
+    span := (end - start) / overviewDetail
+    blocks := make([]IndexBlock, overviewDetail)
+    for i := range blocks {
+        blocks[i] = IndexBlock{Start: start + float64(i) * span, End: start + float64(i + 1) * span,
+            Min: math.NaN(), Max: math.NaN()}
+    }
+    scanner, err := ScanRecording(file)
+    if err != nil {
+        return nil, err
+    }
+    defer scanner.Close()
+    for scanner.Scan() {
+        values := scanner.Values()
+        if len(values) < 2 {
+            continue
+        }
+        i := min(overviewDetail - 1, max(0, int((values[0] - start) / span)))
+        blocks[i].Add(values[1])
+    }
+    return blocks, scanner.Err()
+}
+
+/*
+ Draws the overview of a whole recording from its index, as a strip of the given width above a line with the times.
+ Every column shows the range of the signal in its part of the recording, in half lines, so an hour of signal fits on
+ the screen and artifacts and contractions stand out. The part that the chart shows, from and to in seconds, is
+ highlighted.
+ */
+func DrawOverview(index []IndexBlock, width int, format func(float64) string, from float64, to float64) string {
+    rows := overviewLines - 1
+    if len(index) == 0 || width < 2 {
+        return strings.Repeat("\n", rows)
+    }
+    start, end := index[0].Start, index[len(index) - 1].End
+    if end <= start {
+        end = start + 1
+    }
+    span := (end - start) / float64(width)
+
+    // The range of every column, from the blocks that overlap it
+    low, high := make([]float64, width), make([]float64, width)
+    minY, maxY := math.Inf(1), math.Inf(-1)
+    for x := range low {
+        low[x], high[x] = math.NaN(), math.NaN()
+        t := start + float64(x) * span
+        first := FindBlock(index, t)
+        for j := first; j < len(index) && (j == first || index[j].Start < t + span); j++ {
+            block := index[j]
+            if math.IsNaN(block.Min) {
+                continue
+            }
+            if math.IsNaN(low[x]) || block.Min < low[x] {
+                low[x] = block.Min
+            }
+            if math.IsNaN(high[x]) || block.Max > high[x] {
+                high[x] = block.Max
+            }
+        }
+        if !math.IsNaN(low[x]) {
+            minY, maxY = math.Min(minY, low[x]), math.Max(maxY, high[x])
+        }
+    }
+    if maxY <= minY {
+        maxY = minY + 1
+    }
+
+    lines := make([]string, rows + 1)
+    levels := float64(rows * 2 - 1)
+    for x := range low {
+        top := int(math.Round((maxY - high[x]) / (maxY - minY) * levels))
+        bottom := int(math.Round((maxY - low[x]) / (maxY - minY) * levels))
+        shown := start + float64(x + 1) * span > from && start + float64(x) * span <= to
+        for y := 0; y < rows; y++ {
+            upper := !math.IsNaN(low[x]) && top <= y * 2 && y * 2 <= bottom
+            lower := !math.IsNaN(low[x]) && top <= y * 2 + 1 && y * 2 + 1 <= bottom
+            char := " "
+            if upper && lower {
+                char = "█"
+            } else if upper {
+                char = "▀"
+            } else if lower {
+                char = "▄"
+            }
+            if shown {
+                char = highlighted(char, colorYellow, colorBlue)
+            } else {
+                char = colored(char, colorCyan)
+            }
+            lines[y] += char
+        }
+    }
+
+    // The times of the recording at both ends, and of the start of the chart below it
+    axis := strings.Split(strings.Repeat(" ", width), "")
+    write := func(text string, x int) {
+        x = max(0, min(width - utf8.RuneCountInString(text), x))
+        for _, char := range text {
+            if x < width {
+                axis[x] = string(char)
+            }
+            x++
+        }
+    }
+    position := int((from - start) / span)
+    marker := "▲ " + format(from)
+    outside := position < 0 || position >= width
+
+    // The marker takes precedence over the labels that it would overlap, if the chart starts within the recording
+    if outside || position > utf8.RuneCountInString(format(start)) {
+        write(format(start), 0)
+    }
+    if outside || position + utf8.RuneCountInString(marker) < width - utf8.RuneCountInString(format(end)) {
+        write(format(end), width)
+    }
+    if !outside {
+        write(marker, position)
+    }
+    lines[rows] = strings.Join(axis, "")
+    return strings.Join(lines, "\n")
+}
@@ -0,0 +1,42 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "strings"
+    "testing"
+)
+
+/*
+ The times at both ends of the overview are drawn wherever the chart starts, and the marker only within the recording
+ */
+func TestDrawOverviewLabels(t *testing.T) {
+    index := []IndexBlock{}
+    for i := 0; i < 100; i++ {
+        index = append(index, IndexBlock{Start: float64(i), End: float64(i + 1), Min: -float64(i), Max: float64(i)})
+    }
+    format := func(seconds float64) string {
+        return fmt.Sprintf("%.0fs", seconds)
+    }
+    for _, test := range []struct {
+        from float64
+        marker bool
+    }{{-50, false}, {50, true}, {200, false}} {
+        lines := strings.Split(DrawOverview(index, 60, format, test.from, test.from + 10), "\n")
+        axis := lines[len(lines) - 1]
+        if !strings.HasPrefix(axis, "0s") {
+            t.Errorf("from %.0f: the start of the recording is missing: %q", test.from, axis)
+        }
+        if !strings.HasSuffix(axis, "100s") {
+            t.Errorf("from %.0f: the end of the recording is missing: %q", test.from, axis)
+        }
+        if strings.Contains(axis, "▲") != test.marker {
+            t.Errorf("from %.0f: expected the marker %v: %q", test.from, test.marker, axis)
+        }
+    }
+}
@@ -0,0 +1,92 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "fmt"
+    "sync"
+    "runtime"
+)
+
+/*
+ The default amount of files that the offline commands process at the same time
+ */
+var defaultJobs = runtime.NumCPU()
+
+/*
+ Processes the files with a pool of workers, the given amount at the same time. work is called with the index of
+ the file and returns what is printed for it; the output is printed in the order of the files, not in the order they
+ finish. The progress is shown on the standard error if it is a terminal. The first error stops the processing: the
+ files that are being processed are finished, but no new ones are started.
+ */
+func ProcessFiles(files []string, jobs int, verb string, work func(i int) (string, error)) error {
+    if jobs < 1 {
+        jobs = 1
+    }
+    type result struct {
+        output string
+        err error
+        done bool
+    }
+    results := make([]result, len(files))
+    progress := isTerminal(os.Stderr) && len(files) > 1
+
+    var mutex sync.Mutex
+    next, printed, finished := 0, 0, 0
+    var failure error
+    var wait sync.WaitGroup
+    for w := 0; w < jobs && w < len(files); w++ {
+        wait.Add(1)
+        go func() {
+            defer wait.Done()
+            for true {
+                mutex.Lock()
+                if next == len(files) || failure != nil {
+                    mutex.Unlock()
+                    return
+                }
+                i := next
+                next++
+                mutex.Unlock()
+
+                output, err := work(i)
+
+                mutex.Lock()
+                results[i] = result{output, err, true}
+                finished++
+                if err != nil && failure == nil {
+                    failure = fmt.Errorf("%s: %v", files[i], err)
+                }
+
+                // Print everything that is finished and not waiting for an earlier file
+                if progress {
+                    fmt.Fprint(os.Stderr, "\r\033[K")
+                }
+                for printed < len(files) && results[printed].done && results[printed].err == nil {
+                    fmt.Print(results[printed].output)
+                    results[printed].output = ""
+                    printed++
+                }
+                if progress && finished < len(files) && failure == nil {
+                    fmt.Fprintf(os.Stderr, "%s %d of %d files ...", verb, finished, len(files))
+                }
+                mutex.Unlock()
+            }
+        }()
+    }
+    wait.Wait()
+    return failure
+}
+
+/*
+ Whether a file is a terminal, as opposed to a file or a pipe
+ */
+func isTerminal(file *os.File) bool {
+    info, err := file.Stat()
+    return err == nil && info.Mode() & os.ModeCharDevice != 0
+}
@@ -0,0 +1,795 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "math"
+    "sync"
+    "time"
+    "sync/atomic"
+)
+
+/*
+ How many samples can wait for the renderer before the oldest ones are dropped
+ */
+const displayBuffer = 1024
+
+/*
+ How many samples can wait for the recorder before the acquisition has to wait. The recorder must never lose data, so
+ this buffer is large enough to survive long stalls of the storage.
+ */
+const recordBuffer = 1 << 16
+
+/*
+ One measurement of the muscle sensor
+ */
+type Sample struct {
+
+    /*
+     The number of the sample, counted from the start of the acquisition
+     */
+    Index int
+
+    /*
+     The amount of seconds since the start of the acquisition
+     */
+    Time float64
+
+    /*
+     The measured voltage
+     */
+    Value float64
+
+    /*
+     The output of every processing stage, in the order of the filter chain
+     */
+    Stages []float64
+
+    /*
+     The output of the last of the stages that the processing is compared with (see --compare-filter), zero without
+     them
+     */
+    Compared float64
+
+    /*
+     The values of the auxiliary sensors, in their own units
+     */
+    Aux []float64
+
+    /*
+     The heartbeat that was detected with this sample in ECG mode, if any
+     */
+    Beat *Beat
+
+    /*
+     When the sample was acquired, by the clock of the system. Push sets it for sources that don't.
+     */
+    Acquired time.Time
+
+    /*
+     Whether the subject was moving, as detected by the accelerometer (see MotionDetector)
+     */
+    Motion bool
+}
+
+/*
+ Returns the value after all processing stages, which is what gets displayed
+ */
+func (s Sample) Processed() float64 {
+    if len(s.Stages) == 0 {
+        return s.Value
+    }
+    return s.Stages[len(s.Stages) - 1]
+}
+
+/*
+ Connects a source of samples to its consumers, so that a slow consumer can never block the acquisition. Every
+ subscription, like the display, gets a bounded buffer, and if its consumer can't keep up, the oldest samples are
+ dropped. Every stream has its own buffer and goroutine as well. The recorder gets every sample through a large buffer
+ that is emptied by its own goroutine.
+ */
+type Pipeline struct {
+
+    /*
+     The processing stages that are applied to every sample before it is handed to the consumers
+     */
+    Filters *FilterChain
+
+    /*
+     Other processing stages that every sample is processed with as well, to compare them with Filters. Nil if
+     nothing is compared.
+     */
+    Compare *FilterChain
+
+    /*
+     Detects heartbeats in ECG mode. Nil in every other mode.
+     */
+    ECG *QRSDetector
+
+    /*
+     Flags the samples during movements if an accelerometer is connected, nil otherwise
+     */
+    Motion *MotionDetector
+
+    /*
+     Notices when the electrodes lost contact, and pauses the recording meanwhile if it should, nil otherwise
+     */
+    Signal *SignalMonitor
+
+    /*
+     Notes the markers that a video of the session is aligned with, nil if there are none
+     */
+    Sync *SyncMarkers
+
+    /*
+     Classifies the signal while it is acquired, nil without a model
+     */
+    Classifier *Classifier
+
+    /*
+     Sends the envelope and the class to a controller, nil without one
+     */
+    ControlOutput *ControlOutput
+
+    /*
+     Shares the samples with processes on the same device, nil if they aren't shared
+     */
+    Shared *SharedRing
+
+    /*
+     Collects the windows of the gestures that a calibration prompts, nil without one
+     */
+    Calibration *Calibration
+
+    /*
+     Splits the session into segments, like repetitions of an exercise, and measures them
+     */
+    Segments *Segmenter
+
+    /*
+     In triggered mode, decides which samples are recorded. Nil records every sample.
+     */
+    Trigger *Gate
+
+    /*
+     Watches the free space for the recording. Nil if nothing is recorded.
+     */
+    Disk *DiskMonitor
+
+    /*
+     Reads the battery of the UPS. Nil without a UPS.
+     */
+    Battery *BatteryMonitor
+
+    /*
+     Samples the CPU load, the temperature and the throttling of the system. Nil if it isn't watched.
+     */
+    Telemetry *TelemetryMonitor
+
+    /*
+     Captures what the subject is doing next to the recording, nil without a camera. It is stopped when the pipeline
+     is closed.
+     */
+    Camera *Camera
+
+    /*
+     Measures how long the samples take through the pipeline
+     */
+    Latency *LatencyTracker
+
+    /*
+     The samples for the display. The subscription is closed when the source has finished.
+     */
+    Display *Subscription
+
+    /*
+     Sends the commands of Control to another instance, if the samples come from there. Nil controls this pipeline.
+     */
+    Remote func(command string) error
+
+    /*
+     The mode of the application that the pipeline belongs to
+     */
+    Modes *ModeMachine
+
+    /*
+     The region that a playback repeats, if any
+     */
+    Loop *PlaybackLoop
+
+    /*
+     After how many seconds of the signal the session ends, or zero if it doesn't end on its own
+     */
+    Duration float64
+
+    record chan Sample
+    recorded chan bool
+    recording atomic.Value
+    failure atomic.Value
+    decimation int32
+    streams []*Stream
+    subscriptions []*Subscription
+    pushed uint64
+    paused int32
+    markers int32
+
+    // Protects the channels, the streams and the subscriptions against being changed while a sample is pushed. The
+    // events that wait for the next sample, and the number of the last one, are protected as well.
+    lock sync.Mutex
+    closed bool
+    pending []string
+    lastIndex int
+    lossPaused bool
+
+    // When the session started by the clock of the system, when the last sample was pushed, its time in the recording,
+    // and whether the watchdog considers the source stalled
+    started int64
+    lastPush int64
+    lastTime uint64
+    stalled int32
+}
+
+func NewPipeline() *Pipeline {
+    pipeline := &Pipeline{Filters: &FilterChain{}, Segments: NewSegmenter(), Latency: NewLatencyTracker(),
+        Loop: &PlaybackLoop{}}
+    pipeline.Display = pipeline.Subscribe(displayBuffer)
+    return pipeline
+}
+
+/*
+ A consumer of the samples that has its own buffer, like the display. A consumer that can't keep up loses the oldest
+ samples of its buffer, without holding up the acquisition, the recording or the other consumers.
+ */
+type Subscription struct {
+    /*
+     The samples, which is closed when the source has finished
+     */
+    Samples chan Sample
+
+    dropped uint64
+}
+
+/*
+ Returns how many samples the consumer lost because it couldn't keep up
+ */
+func (s *Subscription) Dropped() uint64 {
+    return atomic.LoadUint64(&s.dropped)
+}
+
+/*
+ Hands a sample to the consumer, making room by dropping the oldest sample if its buffer is full
+ */
+func (s *Subscription) offer(sample Sample) {
+    for {
+        select {
+        case s.Samples <- sample:
+            return
+        default:
+        }
+        select {
+        case <-s.Samples:
+            atomic.AddUint64(&s.dropped, 1)
+        default:
+        }
+    }
+}
+
+/*
+ Adds a consumer that gets every sample that is pushed from now on, with a buffer of the given size. If the source has
+ already finished, the samples are closed right away.
+ */
+func (p *Pipeline) Subscribe(buffer int) *Subscription {
+    p.lock.Lock()
+    defer p.lock.Unlock()
+    subscription := &Subscription{Samples: make(chan Sample, buffer)}
+    if p.closed {
+        close(subscription.Samples)
+    } else {
+        p.subscriptions = append(p.subscriptions, subscription)
+    }
+    return subscription
+}
+
+/*
+ Removes a consumer and closes its samples
+ */
+func (p *Pipeline) Unsubscribe(subscription *Subscription) {
+    p.lock.Lock()
+    defer p.lock.Unlock()
+    for i, s := range p.subscriptions {
+        if s == subscription {
+            p.subscriptions = append(p.subscriptions[:i:i], p.subscriptions[i + 1:]...)
+            close(s.Samples)
+            return
+        }
+    }
+}
+
+/*
+ Writes every sample that is pushed from now on into the recording. The recording is closed together with the
+ pipeline.
+ */
+func (p *Pipeline) Record(recorder *Recording) {
+    p.lock.Lock()
+    defer p.lock.Unlock()
+    p.recording.Store(recorder)
+    p.record = make(chan Sample, recordBuffer)
+    p.recorded = make(chan bool)
+    go func() {
+        // A full storage must not crash the session. The recording ends, and the display goes on.
+        var failed error
+        for sample := range p.record {
+            if failed == nil {
+                failed = recorder.Write(sample)
+                p.Latency.Observe(LatencyRecord, sample.Acquired)
+            }
+            if failed != nil {
+                p.failure.Store(recordingFailure{failed})
+            }
+        }
+        err := recorder.Close()
+        if err != nil && failed == nil {
+            p.failure.Store(recordingFailure{err})
+        }
+        close(p.recorded)
+    }()
+}
+
+type recordingFailure struct {
+    err error
+}
+
+/*
+ Returns why the recording failed, or nil if it works
+ */
+func (p *Pipeline) RecordingError() error {
+    failure, _ := p.failure.Load().(recordingFailure)
+    return failure.err
+}
+
+/*
+ Records only every nth sample from now on, to save space. 1 records every sample.
+ */
+func (p *Pipeline) SetDecimation(n int) {
+    atomic.StoreInt32(&p.decimation, int32(n))
+}
+
+func (p *Pipeline) Decimation() int {
+    n := atomic.LoadInt32(&p.decimation)
+    if n < 1 {
+        return 1
+    }
+    return int(n)
+}
+
+/*
+ Streams every sample that is pushed from now on to sinks. There can be several streams, which don't hold up each
+ other. The streams are closed together with the pipeline.
+ */
+func (p *Pipeline) StreamTo(stream *Stream) {
+    p.lock.Lock()
+    defer p.lock.Unlock()
+    stream.latency = p.Latency
+    p.streams = append(p.streams, stream)
+}
+
+/*
+ Notes that something happened at the given time, in the events of the recording and in the stream, at the last
+ sample that was pushed. This can be called from any goroutine.
+ */
+func (p *Pipeline) Event(time float64, event string) {
+    p.lock.Lock()
+    defer p.lock.Unlock()
+    if !p.closed {
+        p.event(p.lastIndex, time, event)
+    }
+}
+
+/*
+ Notes that something happened at the given time and sample, like the events of a recording that is played back
+ */
+func (p *Pipeline) EventAt(sample int, time float64, event string) {
+    p.lock.Lock()
+    defer p.lock.Unlock()
+    if !p.closed {
+        p.event(sample, time, event)
+    }
+}
+
+/*
+ Notes that something happened with the next sample, at its time, and starts a new segment with it unless the name of
+ the segment is empty. This is how events that are noticed outside of the goroutine of the source, like a key press
+ or a phase of the protocol, get the time of the sample that they belong to, instead of the time they were handled.
+ This can be called from any goroutine.
+ */
+func (p *Pipeline) EventWithNext(event string, segment string) {
+    p.lock.Lock()
+    defer p.lock.Unlock()
+    p.pending = append(p.pending, event)
+    if segment != "" {
+        p.Segments.Split(segment)
+    }
+}
+
+func (p *Pipeline) event(sample int, time float64, event string) {
+    if recording := p.Recording(); recording != nil {
+        recording.Event(time, sample, event)
+    }
+    for _, stream := range p.streams {
+        stream.Event(time, sample, event)
+    }
+}
+
+/*
+ Notes a new class of the classifier in the recording, and publishes it with its confidence on the streams
+ */
+func (p *Pipeline) classified(sample int, prediction Prediction) {
+    if recording := p.Recording(); recording != nil {
+        recording.Event(prediction.Time, sample, prediction.Event())
+    }
+    for _, stream := range p.streams {
+        stream.Prediction(sample, prediction)
+    }
+}
+
+/*
+ Hands a finished segment to the recording and the stream
+ */
+func (p *Pipeline) segment(segment *Segment) {
+    if segment == nil {
+        return
+    }
+    if recording := p.Recording(); recording != nil {
+        recording.Segment(*segment)
+    }
+    for _, stream := range p.streams {
+        stream.Segment(*segment)
+    }
+}
+
+/*
+ Replaces the processing stages between two samples, like the tour of plot demo does. Recordings and streams keep
+ the stages that they started with, so this only works for sessions that record the raw signal.
+ */
+func (p *Pipeline) SetFilters(chain *FilterChain) {
+    p.lock.Lock()
+    defer p.lock.Unlock()
+    p.Filters = chain
+}
+
+/*
+ Hands a new sample to all consumers. This must only be called from the goroutine of the source.
+ */
+func (p *Pipeline) Push(sample Sample) {
+    // The session ends at its duration however far behind the display is, which needs the lock itself
+    if p.Duration > 0 && sample.Time >= p.Duration {
+        if p.Modes != nil {
+            p.Modes.Transition(ModeEnded)
+        } else {
+            p.Close()
+        }
+        return
+    }
+    p.lock.Lock()
+    defer p.lock.Unlock()
+    if p.closed {
+        return
+    }
+    now := time.Now()
+    if sample.Acquired.IsZero() {
+        sample.Acquired = now
+    }
+    atomic.CompareAndSwapInt64(&p.started, 0, now.Add(-time.Duration(sample.Time * float64(time.Second))).UnixNano())
+    atomic.StoreInt64(&p.lastPush, now.UnixNano())
+    atomic.AddUint64(&p.pushed, 1)
+    atomic.StoreUint64(&p.lastTime, math.Float64bits(sample.Time))
+    p.lastIndex = sample.Index
+    // Samples of another instance arrive already processed
+    if sample.Stages == nil {
+        sample.Stages = p.Filters.Process(sample.Value, sample.Aux)
+        if p.Compare != nil {
+            if stages := p.Compare.Process(sample.Value, sample.Aux); len(stages) > 0 {
+                sample.Compared = stages[len(stages) - 1]
+            } else {
+                sample.Compared = sample.Value
+            }
+        }
+    }
+    if p.ECG != nil {
+        sample.Beat = p.ECG.Process(sample.Time, sample.Value)
+    }
+    if p.Motion != nil {
+        moving, changed := p.Motion.Process(sample.Time, sample.Aux)
+        sample.Motion = moving
+        if changed && moving {
+            p.event(sample.Index, sample.Time, "motion")
+        } else if changed {
+            p.event(sample.Index, sample.Time, "motion end")
+        }
+    }
+    if p.Signal != nil {
+        if lost, changed, event := p.Signal.Process(sample.Time, sample.Value); changed {
+            p.event(sample.Index, sample.Time, event)
+            p.pauseOnLoss(sample, lost)
+        }
+    }
+    if p.Sync != nil {
+        if marker := p.Sync.Process(sample); marker != "" {
+            p.event(sample.Index, sample.Time, marker)
+        }
+    }
+    if p.Classifier != nil {
+        if prediction, changed := p.Classifier.Process(sample); changed {
+            p.classified(sample.Index, prediction)
+        }
+    }
+    if p.Calibration != nil {
+        p.Calibration.Process(sample)
+    }
+    if p.ControlOutput != nil {
+        p.ControlOutput.Send(sample, p.Classifier)
+    }
+    if p.Shared != nil {
+        p.Shared.Write(sample)
+    }
+    p.Latency.Observe(LatencyProcess, sample.Acquired)
+    for _, event := range p.pending {
+        p.event(sample.Index, sample.Time, event)
+    }
+    p.pending = nil
+    p.segment(p.Segments.Add(sample))
+
+    // The envelopes of a trigger condition need every sample, also those that are paused or decimated away
+    if p.Trigger != nil && p.Trigger.When != nil {
+        p.Trigger.When.Update(sample)
+    }
+    if p.record != nil && !p.Paused() {
+        p.recordSample(sample)
+    }
+    for _, stream := range p.streams {
+        stream.Sample(sample)
+    }
+    for _, subscription := range p.subscriptions {
+        subscription.offer(sample)
+    }
+}
+
+/*
+ Hands a sample to the recorder, or in triggered mode, the samples that the trigger lets through. A downsampled
+ recording only gets every nth sample.
+ */
+func (p *Pipeline) recordSample(sample Sample) {
+    if sample.Index % p.Decimation() != 0 {
+        return
+    }
+    if p.Trigger == nil {
+        p.record <- sample
+        return
+    }
+    samples, fired := p.Trigger.Process(sample)
+    if fired {
+        p.event(sample.Index, sample.Time, "triggered")
+    }
+    for _, s := range samples {
+        p.record <- s
+    }
+}
+
+/*
+ Returns the health of the sinks of every stream
+ */
+func (p *Pipeline) SinkHealth() []SinkHealth {
+    p.lock.Lock()
+    defer p.lock.Unlock()
+    health := []SinkHealth{}
+    for _, stream := range p.streams {
+        health = append(health, stream.Health()...)
+    }
+    return health
+}
+
+/*
+ Returns how many samples were dropped because the display couldn't keep up
+ */
+func (p *Pipeline) Dropped() uint64 {
+    return p.Display.Dropped()
+}
+
+/*
+ Returns how many samples were pushed since the start of the acquisition
+ */
+func (p *Pipeline) Pushed() uint64 {
+    return atomic.LoadUint64(&p.pushed)
+}
+
+/*
+ Returns the recording that the samples are written into, or nil if nothing is recorded
+ */
+func (p *Pipeline) Recording() *Recording {
+    recording, _ := p.recording.Load().(*Recording)
+    return recording
+}
+
+/*
+ Whether the recording is paused. The samples are still displayed while the recording is paused.
+ */
+func (p *Pipeline) Paused() bool {
+    return atomic.LoadInt32(&p.paused) != 0
+}
+
+/*
+ Pauses or resumes the recording, and notes it in the events of the recording
+ */
+func (p *Pipeline) SetPaused(paused bool) {
+    if p.Recording() == nil || paused == p.Paused() {
+        return
+    }
+    event := "recording resumed"
+    if paused {
+        event = "recording paused"
+    }
+    p.markPaused(paused)
+    _, t := p.LastSample()
+    p.Event(t, event)
+}
+
+/*
+ Pauses the recording when the signal was lost, and resumes it when the signal is back, unless it was resumed by hand
+ in between. The mode follows from another goroutine, since the lock of the pipeline is held here.
+ */
+func (p *Pipeline) pauseOnLoss(sample Sample, lost bool) {
+    if !p.Signal.Pause || p.Recording() == nil {
+        return
+    }
+    if lost && !p.Paused() {
+        p.lossPaused = true
+        p.markPaused(true)
+        p.event(sample.Index, sample.Time, "recording paused")
+    } else if !lost && p.lossPaused {
+        p.lossPaused = false
+        if !p.Paused() {
+            return
+        }
+        p.markPaused(false)
+        p.event(sample.Index, sample.Time, "recording resumed")
+    } else {
+        return
+    }
+    if p.Modes != nil {
+        mode := ModeLive
+        if lost {
+            mode = ModePaused
+        }
+        go p.Modes.Follow(mode)
+    }
+}
+
+func (p *Pipeline) markPaused(paused bool) {
+    value := int32(0)
+    if paused {
+        value = 1
+    }
+    atomic.StoreInt32(&p.paused, value)
+}
+
+/*
+ Carries out a command of the keyboard or of a remote viewer:
+    pause, resume   Pauses or resumes the recording
+    playback        Plays back what was recorded so far, while the acquisition continues
+    back            Ends the playback and returns to the acquisition
+    trigger         Fires the trigger by hand
+    marker          Notes a numbered marker, which starts a new segment
+ This can be called from any goroutine.
+ */
+func (p *Pipeline) Control(command string) error {
+    if p.Remote != nil {
+        return p.Remote(command)
+    }
+    switch command {
+    case "pause":
+        return p.Modes.Transition(ModePaused)
+    case "resume":
+        return p.Modes.Transition(ModeLive)
+    case "playback":
+        return p.Modes.Transition(ModePlayback)
+    case "back":
+        return p.Modes.Back()
+    case "trigger":
+        if p.Trigger != nil {
+            p.Trigger.Fire()
+        }
+    case "marker":
+        marker := fmt.Sprintf("marker %d", atomic.AddInt32(&p.markers, 1))
+        p.EventWithNext(marker, marker)
+    default:
+        return fmt.Errorf("unknown command %s, expected pause, resume, playback, back, trigger or marker", command)
+    }
+    return nil
+}
+
+/*
+ Returns when the last sample was pushed, and its time in the recording
+ */
+func (p *Pipeline) LastSample() (time.Time, float64) {
+    return time.Unix(0, atomic.LoadInt64(&p.lastPush)), math.Float64frombits(atomic.LoadUint64(&p.lastTime))
+}
+
+/*
+ Returns when the session started by the clock of the system. Recordings and the sessions of other instances know
+ when they started, otherwise it is taken from the first sample.
+ */
+func (p *Pipeline) Started() time.Time {
+    return time.Unix(0, atomic.LoadInt64(&p.started))
+}
+
+func (p *Pipeline) SetStarted(started time.Time) {
+    atomic.StoreInt64(&p.started, started.UnixNano())
+}
+
+/*
+ Whether the source has stopped delivering samples
+ */
+func (p *Pipeline) Stalled() bool {
+    return atomic.LoadInt32(&p.stalled) != 0
+}
+
+func (p *Pipeline) SetStalled(stalled bool) {
+    value := int32(0)
+    if stalled {
+        value = 1
+    }
+    atomic.StoreInt32(&p.stalled, value)
+}
+
+/*
+ Whether the pipeline was closed, after which the source can stop
+ */
+func (p *Pipeline) Closed() bool {
+    p.lock.Lock()
+    defer p.lock.Unlock()
+    return p.closed
+}
+
+/*
+ Waits until all samples were recorded and streamed, and then signals the consumers that the source has finished, so
+ the program can exit once they see it. This can be called by the consumers as well, to stop the acquisition before
+ the source has finished. Samples that are pushed afterwards are discarded.
+ */
+func (p *Pipeline) Close() {
+    // The camera notes its last events while it stops, which needs the lock
+    if p.Camera != nil {
+        p.Camera.Stop()
+    }
+    p.lock.Lock()
+    defer p.lock.Unlock()
+    if p.closed {
+        return
+    }
+    p.closed = true
+    p.segment(p.Segments.Finish())
+    if p.record != nil {
+        close(p.record)
+        <-p.recorded
+    }
+
+    if p.ControlOutput != nil {
+        p.ControlOutput.Close()
+    }
+    if p.Shared != nil {
+        p.Shared.Close()
+    }
+
+    // The sinks are best effort, the session is complete once it is recorded
+    for _, stream := range p.streams {
+        stream.Close()
+    }
+    for _, subscription := range p.subscriptions {
+        close(subscription.Samples)
+    }
+    p.subscriptions = nil
+}
@@ -0,0 +1,39 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "flag"
+)
+
+func init() {
+    Commands["play"] = playCommand
+}
+
+/*
+ Plays a recording back on the display, which is a shorthand for --playback --file=<file>. With --reprocess, the
+ recording is processed with new stages while it is played, and written into a new recording:
+    $ plot play --reprocess --filter=highpass:20,rectify,rms:0.1 -o clean.csv data.csv
+ With --start, the playback starts later in the recording, at once if it has an index:
+    $ plot play --start=3600 data.csv
+ All options of the display can be given.
+ */
+func playCommand(args []string) {
+    output := flag.String("o", "", "Shorthand for --output")
+    os.Args = append([]string{os.Args[0]}, args...)
+    LoadSettings()
+    if flag.NArg() != 1 {
+        fail("Usage: plot play [--start=seconds] [--reprocess --filter=stages -o output] [display options] <file>")
+    }
+    Settings.Playback = true
+    Settings.File = flag.Arg(0)
+    if *output != "" {
+        Settings.Output = *output
+    }
+    RunDisplay()
+}
@@ -8,15 +8,14 @@ package main
 
 import (
     "github.com/SymnaTEC/go-adcpi"
-    "github.com/buger/goterm"
     "os"
     "fmt"
     "time"
-    "bufio"
     "strings"
     "flag"
-    "strconv"
+    "math"
     "math/rand"
+    "path/filepath"
 )
 
 /*
@@ -25,58 +24,315 @@ import (
  */
 func main() {
 
-    // Clear the terminal
-    goterm.Clear()
+    // Run a subcommand if one was requested
+    if RunCommand(os.Args[1:]) {
+        return
+    }
+
+    // The self test isn't a command, see selftestCommand
+    if len(os.Args) > 1 && (os.Args[1] == "--selftest" || os.Args[1] == "-selftest") {
+        selftestCommand(os.Args[2:])
+        return
+    }
 
     // Load the settings from the command line
     LoadSettings()
+    RunDisplay()
+}
+
+/*
+ Starts the acquisition that was selected by the settings and shows it until it ends
+ */
+func RunDisplay() {
+    if Settings.Reprocess && (!Settings.Playback || Settings.Output == "") {
+        fail("--reprocess needs --playback and --output")
+    }
+    if Settings.Reprocess && Settings.Output == Settings.File {
+        fail("--output must not overwrite the recording that is reprocessed")
+    }
+    if Settings.Start != 0 && (!Settings.Playback || Settings.Reprocess) {
+        fail("--start only works for playback, and recordings are always reprocessed as a whole")
+    }
+    if Settings.Loop != "" {
+        if !Settings.Playback || Settings.Reprocess {
+            fail("--loop only works for playback, and recordings are always reprocessed as a whole")
+        }
+        _, _, err := ParseLoop(Settings.Loop)
+        if err != nil {
+            fail("--loop: %v", err)
+        }
+    }
+    if Settings.Speed != 1 && !Settings.Playback && !Settings.Debug {
+        fail("--speed only works for playback and the debug mode, live sources run in real time")
+    }
+    if Settings.TimeAxis != "seconds" && Settings.TimeAxis != "elapsed" && Settings.TimeAxis != "clock" {
+        fail("unknown --time-axis %s, expected seconds, elapsed or clock", Settings.TimeAxis)
+    }
+    if Settings.Trigger > 0 && Settings.TriggerWhen != "" {
+        fail("--trigger and --trigger-when can't be combined, the condition can compare emg with the threshold")
+    }
+    if Settings.TriggerHysteresis > 0 && Settings.TriggerWhen != "" {
+        fail("--trigger-hysteresis only works with --trigger, the condition holds or doesn't")
+    }
+    if Settings.TriggerHysteresis < 0 || Settings.TriggerHold < 0 || Settings.TriggerRefractory < 0 {
+        fail("--trigger-hysteresis, --trigger-hold and --trigger-refractory can't be negative")
+    }
+    if Settings.TriggerHysteresis >= Settings.Trigger && Settings.TriggerHysteresis > 0 {
+        fail("--trigger-hysteresis must be below the threshold of --trigger, or the trigger never fires again")
+    }
+    if Settings.FPS <= 0 {
+        fail("--fps must be positive")
+    }
+    if Settings.Speed < 0 {
+        fail("--speed can't be negative")
+    }
+    if Settings.Serve != "" && Omits("nonet") {
+        fail("--serve isn't supported by this build (nonet)")
+    }
+    if len(Settings.Notify) > 0 && Omits("nonet") {
+        fail("--notify isn't supported by this build (nonet)")
+    }
+    if Settings.SignalLoss < 0 || Settings.Flatline < 0 {
+        fail("--signal-loss and --flatline can't be negative")
+    }
+    if Settings.PauseOnLoss && Settings.SignalLoss == 0 {
+        fail("--pause-on-loss needs --signal-loss, after which the signal counts as lost")
+    }
+    if Settings.SyncMarkers < 0 {
+        fail("--sync-markers can't be negative")
+    }
+    if Settings.SyncOutput != "" && Settings.SyncMarkers == 0 {
+        fail("--sync-output needs --sync-markers, which set how often it flashes")
+    }
+    if Settings.IMU != "" && (Settings.Debug || Settings.Playback || Settings.Source != "") {
+        fail("--imu is read together with the ADCPi, it doesn't work with other sources")
+    }
+    if Settings.HX711 != "" && (Settings.Debug || Settings.Playback || Settings.Source != "") {
+        fail("--hx711 is read together with the ADCPi, it doesn't work with other sources")
+    }
+    if Settings.Camera != "" && Settings.File == "" {
+        fail("--camera needs --file, the frames are stored next to the recording")
+    }
+    if Settings.Camera != "" && Settings.Encrypt {
+        fail("--camera can't be used with --encrypt, the frames would be stored unencrypted")
+    }
+    if Settings.Camera != "" && (Settings.CameraInterval <= 0 || Settings.CameraFPS <= 0) {
+        fail("--camera-interval and --camera-fps must be positive")
+    }
+    if Settings.Classify != "" && (Settings.ClassifyWindow <= 0 || Settings.ClassifyStep <= 0) {
+        fail("--classify-window and --classify-step must be positive")
+    }
+    if Settings.ClassifyVotes < 1 {
+        fail("--classify-votes must be at least 1")
+    }
+    if Settings.ClassifyConfidence < 0 || Settings.ClassifyConfidence > 1 {
+        fail("--classify-confidence must be between 0 and 1")
+    }
+    if Settings.ControlQueue < 1 {
+        fail("--control-queue must be at least 1")
+    }
+    if Settings.Snapshot < 0 {
+        fail("--snapshot can't be negative")
+    }
+    if Settings.SharedSlots < 1 {
+        fail("--shm-slots must be at least 1")
+    }
+    if strings.Contains(Settings.SharedMemory, "/") {
+        fail("--shm is the name of the shared memory, without a path")
+    }
+    if Settings.Calibrate != "" && Settings.Protocol != "" {
+        fail("--calibrate prompts the gestures itself, it can't be used with --protocol")
+    }
+    if Settings.Calibrate != "" && Settings.CalibrateRounds < 1 {
+        fail("--calibrate-rounds must be at least 1")
+    }
+    if Settings.LowSpace != "stop" && Settings.LowSpace != "downsample" {
+        fail("unknown --low-space %s, expected stop or downsample", Settings.LowSpace)
+    }
+    if _, err := NewRetention(Settings.RetainRaw, Settings.RetainRollups); err != nil {
+        fail("--retain-raw, --retain-rollups: %v", err)
+    }
+    SystemClock = NewClock(Settings.Speed)
+
+    // Create a pipeline to connect the two threads, the data thread and the display thread. The mode of the
+    // application can change while it runs, see AppMode.
+    pipeline := NewPipeline()
+    pipeline.Duration = Settings.Duration.Seconds()
+    modes := NewModeMachine(pipeline, InitialMode())
+
+    // Announce the phases of the exercise protocol. The timers start first, so they see the first sample.
+    var protocol *Protocol
+    var err error
+    if Settings.Protocol != "" {
+        protocol, err = LoadProtocol(Settings.Protocol)
+        if err != nil {
+            fail("%v", err)
+        }
+        RunOnClock(func() { protocol.Run(pipeline) })
+    }
+
+    // A calibration prompts its gestures like a protocol, which ends the session after the last one
+    if Settings.Calibrate != "" {
+        features, err := FindFeatures(Settings.ClassifyFeatures)
+        if err != nil {
+            fail("--classify-features: %v", err)
+        }
+        pipeline.Calibration, err = NewCalibration(Settings.Calibrate, Settings.CalibrateRounds,
+            Settings.CalibrateHold, Settings.CalibrateModel, features, Settings.ClassifyWindow, Settings.ClassifyStep)
+        if err != nil {
+            fail("--calibrate: %v", err)
+        }
+        protocol = pipeline.Calibration.Protocol
+        RunOnClock(func() { protocol.Run(pipeline) })
+    }
+    if Settings.Tour != nil {
+        RunOnClock(func() { Settings.Tour.Run(pipeline) })
+    }
 
-    // Create a channel to connect the two threads, the data thread and the display thread
-    channel := make(chan float64)
+    // Closed-loop control gets its own path, before the first sample
+    if Settings.Control != "" {
+        pipeline.ControlOutput, err = OpenControl(Settings.Control, Settings.ControlQueue)
+        if err != nil {
+            fail("--control: %v", err)
+        }
+    }
+
+    // The channels are complete before the acquisition starts, everything reads them from then on
+    setupChannels()
+
+    // Processes on the same device read the samples from the shared memory
+    if Settings.SharedMemory != "" {
+        pipeline.Shared, err = NewSharedRing(Settings.SharedMemory, Settings.SharedSlots, len(Settings.Aux))
+        if err != nil {
+            fail("--shm: %v", err)
+        }
+    }
 
     // Start the background thread that reads the voltage data
     if Settings.Debug {
-        go grabRandomData(channel)
+        go grabRandomData(pipeline)
     } else if Settings.Playback {
-        go grabDataFromFile(channel)
+        go grabDataFromFile(pipeline)
+    } else if Settings.Source != "" {
+        go grabDataFromSource(pipeline)
     } else {
-        go grabDataFromADCPI(channel)
+        go grabDataFromADCPI(pipeline)
     }
 
-    // Receive the data from the background thread
-    keys := []float64{}
-    values := []float64{}
-    x := 0
-    for v := range channel {
+    // Watch for the system slowing the acquisition down
+    pipeline.Telemetry = NewTelemetryMonitor()
+    go pipeline.Telemetry.Run(pipeline)
 
-        // Append the new values to the general collection
-        keys = append(keys, float64(x) * Settings.Interval)
-        values = append(values, v)
+    // Keep an eye on the battery in the field
+    if Settings.UPS != "" {
+        ups, err := OpenUPS(Settings.UPS, Settings.Bus, Settings.UPSAddress)
+        if err != nil {
+            fail("%v", err)
+        }
+        pipeline.Battery = NewBatteryMonitor(ups)
+        go pipeline.Battery.Run(pipeline)
+    }
+
+    // Let the instance be found on the network
+    if Settings.Advertise {
+        go Advertise(pipeline)
+    }
 
-        // Prepare a Table for the last x values
-        data := &goterm.DataTable{}
-        data.AddColumn("Time")
-        data.AddColumn("Voltage")
+    // Check the settings of the auxiliary sensors before anything is shown
+    _, err = Settings.Aux.Plotted(rightAxis())
+    if err != nil {
+        fail("%v", err)
+    }
+    _, err = ParseCanceller(Settings.Reference, Settings.Aux)
+    if err != nil {
+        fail("%v", err)
+    }
+    if Settings.Derivative && Settings.Right != "" && Settings.Right != "none" {
+        fail("--right can't be used with --derivative, which is drawn on the right axis")
+    }
+    ShowSession(pipeline, modes, protocol)
 
-        // Add the last x values from the value arrays to the table
-        i := min(len(keys), Settings.Scale)
-        for i > 0 {
-            data.AddRow(keys[len(keys)-i], values[len(values)-i])
-            i--
+    // Train the model of the calibration on what was collected
+    if pipeline.Calibration != nil {
+        model, err := pipeline.Calibration.Save(Settings.CalibrateOutput)
+        if err != nil {
+            fail("The calibration failed: %v", err)
         }
+        fmt.Printf("Trained %s on %d windows of %s into %s, use it with --classify %s:%s\n", model.Kind,
+            len(pipeline.Calibration.samples), strings.Join(model.Labels, ", "), Settings.CalibrateOutput, model.Kind,
+            Settings.CalibrateOutput)
+    }
+}
 
-        // Move the cursor to the beginning so we clear the console
-        goterm.MoveCursor(0, 0)
+/*
+ Prints what was recorded once the acquisition has ended
+ */
+func printSummary(pipeline *Pipeline, keys []float64, values []float64) {
+    _, elapsed := pipeline.LastSample()
+    fmt.Printf("\nAcquisition finished after %s: %d samples, %d dropped by the display\n", formatDuration(elapsed),
+        pipeline.Pushed(), pipeline.Dropped())
+    if pipeline.Recording() != nil {
+        fmt.Printf("Recorded into %s\n", pipeline.Recording().File())
+    }
+    if err := pipeline.RecordingError(); err != nil {
+        fmt.Printf("The recording failed: %v\n", err)
+    }
+    stats := Calculate(values)
+    fmt.Println(stats.Format(Settings.DisplayUnit))
+    if Settings.Derivative {
+        fmt.Printf("Peak slope %s/s\n", Settings.DisplayUnit.Format(PeakSlope(keys, values)))
+    }
+    if dropped, failures := pipeline.ControlOutput.Dropped(); dropped + failures > 0 {
+        fmt.Printf("The control output dropped %d messages, and %d failed\n", dropped, failures)
+    }
+}
 
-        // Create a new chart
-        chart := goterm.NewLineChart(Settings.Width, Settings.Height)
-        chart.Flags = goterm.DRAW_RELATIVE
+/*
+ Returns the auxiliary sensors that are drawn on the right axis. If the derivative is shown, it takes the right axis.
+ */
+func rightAxis() string {
+    if Settings.Derivative {
+        return "none"
+    }
+    return Settings.Right
+}
 
-        // Draw the table using the chart
-        fmt.Println(chart.Draw(data))
-        goterm.Flush()
-        x++
+/*
+ Completes the auxiliary sensors with the channels of the source, or those of the IMU and the load cell of the ADCPi
+ */
+func setupChannels() {
+    if Settings.Debug || Settings.Playback {
+        return
     }
+    if Settings.Source != "" {
+        channels, err := sourceChannels()
+        if err != nil {
+            fail("--source: %v", err)
+        }
+        Settings.Aux = channels
+        return
+    }
+    if Settings.IMU != "" {
+        Settings.Aux = append(Settings.Aux, IMUChannels...)
+    }
+    if Settings.HX711 != "" {
+        Settings.Aux = append(Settings.Aux, HX711Channel(Settings.HX711Scale))
+    }
+}
+
+/*
+ How many lines are printed below the chart
+ */
+const infoLines = 3
+
+/*
+ Returns the value of an auxiliary sensor, or zero if the sample has no value for it
+ */
+func auxValue(values []float64, index int) float64 {
+    if index < len(values) {
+        return values[index]
+    }
+    return 0
 }
 
 /*
@@ -90,89 +346,499 @@ func min(x int, y int) int {
 }
 
 /*
- This function queries the ADCPi extension board, and writes the voltage readout into the channel between this
+ This function queries the ADCPi extension board, and writes the voltage readout into the pipeline between this
  function and the plotting logic
  */
-func grabDataFromADCPI(channel chan float64) {
+func grabDataFromADCPI(pipeline *Pipeline) {
+
+    // Give the acquisition priority over everything else
+    err := RaisePriority(Settings.Priority, Settings.CPU)
+    if err != nil {
+        panic(err)
+    }
+
+    // The muscle sensor is read together with the auxiliary sensors
+    channels := []int{Settings.Channel}
+    for _, aux := range Settings.Aux {
+        if aux.Sensor == "" {
+            channels = append(channels, aux.Channel)
+        }
+    }
 
     // Connect to the ADCPi
-    adc := adcpi.ADCPI(byte(Settings.Address), 18)
+    var read func() []float64
+    timing := "hardware"
+    if Settings.SoftwareTiming {
+        timing = "software"
+        adc := adcpi.ADCPI(byte(Settings.Address), 18)
+        read = func() []float64 {
+            // Converts our decimal value in seconds to an integer value in nanoseconds
+            time.Sleep(time.Duration(Settings.Interval * 1000 * 1000 * 1000))
+            voltages := make([]float64, len(channels))
+            for i, channel := range channels {
+                voltages[i] = adc.ReadVoltage(byte(channel))
+            }
+            return voltages
+        }
+    } else {
+        adc, err := NewMCP3424(Settings.Bus, Settings.Address, channels, Settings.Interval)
+        if err != nil {
+            panic(err)
+        }
+        adc.Timeout = watchdogTimeout()
+        defer func() {
+            adc.Close()
+        }()
+
+        // The timestamps have to follow the clock of the ADC
+        Settings.Interval = adc.Interval
+        read = func() []float64 {
+            for {
+                voltages, err := adc.Read()
+                if err == nil {
+                    return voltages
+                }
+
+                // The ADC stopped responding, try to connect to it again until it works
+                adc.Close()
+                for {
+                    time.Sleep(watchdogTimeout())
+                    adc, err = NewMCP3424(Settings.Bus, Settings.Address, channels, Settings.Interval)
+                    if err == nil {
+                        adc.Timeout = watchdogTimeout()
+                        break
+                    }
+                }
+            }
+        }
+    }
+
+    // The IMU is read right after the ADC, so its values are aligned with the samples
+    var imu IMU
+    if Settings.IMU != "" {
+        imu, err = OpenIMU(Settings.IMU, Settings.Bus, Settings.IMUAddress)
+        if err != nil {
+            panic(err)
+        }
+        defer imu.Close()
+    }
+
+    // The load cell converts more slowly than the ADC, so it is read on its own and every sample takes its last force
+    var loadCell *HX711
+    if Settings.HX711 != "" {
+        loadCell, err = OpenHX711(Settings.GPIOChip, Settings.HX711)
+        if err != nil {
+            panic(err)
+        }
+        defer loadCell.Close()
+        loadCell.Scale = Settings.HX711Scale
+        loadCell.Offset = Settings.HX711Offset
+        loadCell.Tared = Settings.HX711Offset != 0
+    }
 
     // Create the CSV file
-    csv,err := os.Create(Settings.File)
-    if err != nil {
-        panic(err)
+    startRecording(pipeline, timing)
+    defer pipeline.Close()
+
+    // Watch for outages of the ADC
+    if Settings.Watchdog > 0 {
+        go RunWatchdog(pipeline, watchdogTimeout())
+    }
+    if loadCell != nil {
+        go loadCell.Run(pipeline)
     }
-    csv.WriteString("Time;Voltage")
-    defer csv.Close()
-    defer close(channel)
 
     // Counter
     x := 0
-    voltage := float64(0)
+    last := time.Now()
 
     // Create an infinite loop
     for true {
-        voltage = adc.ReadVoltage(byte(Settings.Channel))
-        channel <- voltage
-        csv.WriteString(fmt.Sprintf("\n%f;%f", float64(x) * Settings.Interval, voltage))
+        voltages := read()
+
+        // After an outage, skip the samples that were missed, so the time of the following samples stays correct
+        if Settings.Watchdog > 0 && time.Since(last) > watchdogTimeout() {
+            x += int(time.Since(last).Seconds() / Settings.Interval) - 1
+        }
+        last = time.Now()
+
+        sample := Sample{Index: x, Time: float64(x) * Settings.Interval, Value: voltages[0], Acquired: last}
+        motion := []float64{}
+        if imu != nil {
+            motion, err = imu.Read()
+            if err != nil {
+                // A failing IMU must not stop the acquisition, the gap is visible in the recording
+                motion = []float64{math.NaN(), math.NaN(), math.NaN(), math.NaN(), math.NaN(), math.NaN()}
+            }
+        }
+        for _, aux := range Settings.Aux {
+            if aux.Sensor == "imu" {
+                sample.Aux = append(sample.Aux, motion[0])
+                motion = motion[1:]
+            } else if aux.Sensor == "hx711" {
+                sample.Aux = append(sample.Aux, loadCell.Force())
+            } else {
+                sample.Aux = append(sample.Aux, aux.Convert(voltages[1]))
+                voltages = voltages[1:]
+            }
+        }
+        pipeline.Push(sample)
         x++
-        // Converts our decimal value in seconds to an integer value in nanoseconds
-        time.Sleep(time.Duration(Settings.Interval * 1000 * 1000 * 1000))
     }
 }
 
 /*
- This function queries a previously created file, and writes the voltage readout into the channel between this
+ Creates the recording of a live source and connects it to the pipeline. This has to be called by every live source
+ once the interval between two samples and the auxiliary sensors are known.
+ */
+func startRecording(pipeline *Pipeline, timing string) *Recording {
+
+    // Look up the pseudonym of the subject
+    subject := ""
+    if Settings.Subject != "" {
+        pseudonym, err := Pseudonym(Settings.Subject)
+        if err != nil {
+            panic(err)
+        }
+        subject = pseudonym
+    }
+
+    setupFilters(pipeline)
+    if Settings.Triggered() {
+        var level *LevelTrigger
+        var when *TriggerCondition
+        if Settings.Trigger > 0 {
+            level = NewLevelTrigger(Settings.Trigger, Settings.TriggerHysteresis, Settings.TriggerHold,
+                Settings.TriggerRefractory)
+        } else if Settings.TriggerWhen != "" {
+            condition, err := ParseTriggerCondition(Settings.TriggerWhen, pipeline.Filters.Names, Settings.Aux,
+                Settings.MVC, Settings.Interval)
+            if err != nil {
+                panic(fmt.Errorf("--trigger-when: %v", err))
+            }
+            when = condition
+            level = NewLevelTrigger(1, 0, math.Max(condition.Hold, Settings.TriggerHold), Settings.TriggerRefractory)
+        }
+        pipeline.Trigger = NewGate(Settings.PreTrigger, Settings.PostTrigger, level)
+        pipeline.Trigger.When = when
+    }
+    if Settings.SyncMarkers > 0 {
+        var output PatternOutput
+        if Settings.SyncOutput != "" {
+            var err error
+            output, err = OpenPatternOutput(Settings.SyncOutput, Settings.GPIOChip, Settings.Bus)
+            if err != nil {
+                panic(fmt.Errorf("--sync-output: %v", err))
+            }
+        }
+        pipeline.Sync = NewSyncMarkers(Settings.SyncMarkers, output)
+    }
+    var camera *Camera
+    captured := ""
+    if Settings.Camera != "" {
+        var err error
+        camera, err = NewCamera(Settings.Camera, Settings.File, Settings.CameraInterval, Settings.CameraFPS,
+            Settings.CameraSize)
+        if err != nil {
+            panic(fmt.Errorf("--camera: %v", err))
+        }
+        captured = camera.String()
+    }
+    meta := Metadata{Created: time.Now(), Interval: Settings.Interval, Address: Settings.Address,
+        Channel: Settings.Channel, Encrypted: Settings.Encrypt, Subject: subject, Notes: Settings.Notes,
+        Tags: Settings.Tags, Timing: timing, Filters: Settings.Filter, Mode: Settings.Mode(), Aux: Settings.Aux,
+        Source: Settings.Source, Trigger: Settings.Trigger, TriggerWhen: Settings.TriggerWhen,
+        PreTrigger: Settings.PreTrigger,
+        PostTrigger: Settings.PostTrigger, TriggerHysteresis: Settings.TriggerHysteresis,
+        TriggerHold: Settings.TriggerHold, TriggerRefractory: Settings.TriggerRefractory,
+        CoContraction: Settings.CoContraction, Reference: Settings.Reference, Motion: pipeline.Motion.Threshold(),
+        MotionGate: Settings.MotionGate, Camera: captured}
+    pipeline.SetStarted(meta.Created)
+    csv,err := NewRecording(Settings.File, meta, pipeline.Filters, Settings.RecordStages)
+    if err != nil {
+        panic(err)
+    }
+    pipeline.Record(csv)
+
+    // Keep the recording from filling up the storage
+    pipeline.Disk = NewDiskMonitor(filepath.Dir(Settings.File))
+    go pipeline.Disk.Run(pipeline)
+    if Settings.RetainRaw > 0 || Settings.RetainRollups > 0 {
+        retention, _ := NewRetention(Settings.RetainRaw, Settings.RetainRollups)
+        go RunRetention(pipeline, filepath.Dir(Settings.File), retention)
+    }
+
+    // Stream the session to the sinks and to remote viewers as well
+    sinks, err := OpenSinks(Settings.Sinks)
+    if err != nil {
+        panic(err)
+    }
+    if len(sinks) > 0 {
+        stream, err := NewStream(sinks, meta, pipeline.Filters.Names, true)
+        if err != nil {
+            panic(err)
+        }
+        pipeline.StreamTo(stream)
+    }
+
+    // Tell whoever isn't watching how the session goes, from a stream of its own as well
+    notifications, err := OpenNotifications(Settings.Notify, pipeline, Settings.File)
+    if err != nil {
+        panic(err)
+    }
+    if len(notifications) > 0 {
+        stream, err := NewStream(notifications, meta, pipeline.Filters.Names, true)
+        if err != nil {
+            panic(err)
+        }
+        pipeline.StreamTo(stream)
+    }
+
+    // The viewers get a stream of their own, so slow sinks don't hold them up
+    if Settings.Serve != "" {
+        server, err := NewServer(pipeline, Settings.Serve)
+        if err != nil {
+            panic(err)
+        }
+        stream, err := NewStream([]Sink{server}, meta, pipeline.Filters.Names, true)
+        if err != nil {
+            panic(err)
+        }
+        pipeline.StreamTo(stream)
+    }
+
+    // The camera starts once the events of its frames reach the recording and the sinks
+    if camera != nil {
+        pipeline.Camera = camera
+        go camera.Run(pipeline)
+    }
+    return csv
+}
+
+/*
+ Returns how long the acquisition may stay silent before it is considered stalled
+ */
+func watchdogTimeout() time.Duration {
+    return time.Duration(float64(Settings.Watchdog) * Settings.Interval * 1000 * 1000 * 1000)
+}
+
+/*
+ Creates the processing stages that were requested by the user. This has to be called by every source once the
+ interval between two samples is known.
+ */
+func setupFilters(pipeline *Pipeline) {
+    pipeline.Filters = processingStages(Settings.Filter)
+    if Settings.CompareFilter != "" {
+        pipeline.Compare = processingStages(Settings.CompareFilter)
+    }
+    if Settings.ECG {
+        pipeline.ECG = NewQRSDetector(Settings.Interval)
+    }
+    pipeline.Motion = NewMotionDetector(Settings.Aux, Settings.Interval, Settings.Motion, Settings.MotionHold)
+    pipeline.Segments.GateMotion = Settings.MotionGate
+    clip := clipVoltage(Metadata{Source: Settings.Source})
+    pipeline.Signal = NewSignalMonitor(Settings.SignalLoss, Settings.Flatline, clip)
+    if pipeline.Signal != nil {
+        pipeline.Signal.Pause = Settings.PauseOnLoss
+    }
+    cocontraction, err := ParseCoContraction(Settings.CoContraction, Settings.Aux)
+    if err != nil {
+        panic(err)
+    }
+    pipeline.Segments.CoContraction = cocontraction
+    if Settings.Classify != "" {
+        features, err := FindFeatures(Settings.ClassifyFeatures)
+        if err != nil {
+            panic(fmt.Errorf("--classify-features: %v", err))
+        }
+        model, err := OpenModel(Settings.Classify, features)
+        if err != nil {
+            panic(fmt.Errorf("--classify: %v", err))
+        }
+        // A trained model knows the window that it was trained with
+        window, step := Settings.ClassifyWindow, Settings.ClassifyStep
+        if trained, ok := model.(*TrainedModel); ok {
+            window, step = trained.Window, trained.Step
+        }
+        pipeline.Classifier = NewClassifier(model, features, window, step, Settings.Interval)
+        pipeline.Classifier.Votes = Settings.ClassifyVotes
+        pipeline.Classifier.Threshold = Settings.ClassifyConfidence
+        pipeline.Classifier.Reject = Settings.ClassifyReject
+    }
+    if pipeline.Calibration != nil {
+        pipeline.Calibration.Start(Settings.Interval)
+    }
+    if pipeline.Shared != nil {
+        pipeline.Shared.Start(Settings.Interval)
+    }
+}
+
+/*
+ Creates processing stages like --filter in their initial state, including the cancelling of the interference
+ */
+func processingStages(description string) *FilterChain {
+    chain, err := ParseFilters(description, Settings.Interval)
+    if err != nil {
+        panic(err)
+    }
+
+    // The interference is cancelled before any other processing
+    canceller, err := ParseCanceller(Settings.Reference, Settings.Aux)
+    if err != nil {
+        panic(err)
+    }
+    if canceller != nil {
+        chain.Names = append([]string{"lms"}, chain.Names...)
+        chain.Filters = append([]Filter{canceller}, chain.Filters...)
+    }
+    return chain
+}
+
+/*
+ This function queries a previously created file, and writes the voltage readout into the pipeline between this
  function and the plotting logic
  */
-func grabDataFromFile(channel chan float64) {
+func grabDataFromFile(pipeline *Pipeline) {
+    // Load the file, decrypting it if necessary, and read the CSV declaration to find the unit of the voltages
+    scanner, err := ScanRecording(Settings.File)
+    if err != nil {
+        panic(err)
+    }
+    defer scanner.Close()
+    defer pipeline.Close()
+    header := scanner.Header
+    _, unit := ParseColumn(header[1])
 
-    // Load the file
-    csv,err := os.Open(Settings.File)
+    // The auxiliary sensors are stored in the last columns
+    meta, err := LoadMetadata(Settings.File)
+    if err == nil {
+        Settings.Aux = meta.Aux
+        pipeline.SetStarted(meta.Created)
+    } else if Settings.Reprocess {
+        panic(err)
+    }
+    first := len(header) - len(Settings.Aux)
+
+    // The new stages of a reprocessed recording are designed for its interval
+    if Settings.Reprocess {
+        Settings.Interval = meta.Interval
+    }
+    setupFilters(pipeline)
+
+    // When reprocessing, the signal is recorded again with the new stages, including the events of the session
+    var events []RecordedEvent
+    if Settings.Reprocess {
+        events, err = ReadEvents(Settings.File)
+        if err != nil {
+            panic(err)
+        }
+        meta.Original = filepath.Base(Settings.File)
+        meta.Filters = Settings.Filter
+        meta.Reference = Settings.Reference
+        meta.CoContraction = Settings.CoContraction
+        meta.Encrypted = meta.Encrypted || Settings.Encrypt
+        recording, err := NewRecording(Settings.Output, meta, pipeline.Filters, Settings.RecordStages)
+        if err != nil {
+            panic(err)
+        }
+
+        // The annotations are kept next to the recording, so the reprocessed one gets a copy of them
+        err = CopyAnnotations(Settings.File, Settings.Output)
+        if err != nil {
+            panic(err)
+        }
+        pipeline.Record(recording)
+    }
+
+    // Jump to where the playback starts, or to the loop. A playback during the acquisition always starts at the
+    // beginning.
+    index, err := ReadIndex(Settings.File)
     if err != nil {
         panic(err)
     }
-    scan := bufio.NewReader(csv)
-    defer close(channel)
+    start := 0.0
+    if Settings.Playback {
+        start = Settings.Start
+        if Settings.Loop != "" {
+            in, out, _ := ParseLoop(Settings.Loop)
+            pipeline.Loop.Set(in, out)
+            start = in
+        }
+    }
+    if start > 0 {
+        err = scanner.Seek(index, start)
+        if err != nil {
+            panic(err)
+        }
+    }
 
-    // Counter
-    x := 0
-    voltage := float64(0)
-    line := ""
-    scan.ReadString(10) // Skip CSV declaration
+    // Create an infinite loop, which ends when the playback is ended during an acquisition
+    for !pipeline.Closed() {
+        if scanner.Scan() {
+            values := scanner.Values()
+            t := values[0]
+            sample := Sample{Index: scanner.Row(), Time: t, Value: values[1] / unit.Scale}
+            sample.Aux = append(sample.Aux, values[first:]...)
+            for len(events) > 0 && events[0].Time <= t {
+                pipeline.EventAt(eventSample(events[0], sample.Index), events[0].Time, events[0].Event)
+                if segment, ok := EventSegment(events[0].Event); ok {
+                    pipeline.Segments.Split(segment)
+                }
+                events = events[1:]
+            }
+            pipeline.Push(sample)
 
-    // Create an infinite loop
-    for true {
-        line, err = scan.ReadString(10)
-        if line != "" {
-            voltage, err = strconv.ParseFloat(strings.Replace(strings.Split(line, ";")[1],
-                "\n", "", -1), 64)
-            if err != nil {
-                panic(err)
+            // At the end of the loop, the playback returns to its start, and the processing starts over
+            if in, out, ok := pipeline.Loop.Region(); ok && !Settings.Reprocess && t >= out {
+                if index == nil {
+                    // Without an index, the recording would be read from its start every time
+                    index, _ = BuildIndex(Settings.File)
+                }
+                err = scanner.Seek(index, in)
+                if err != nil {
+                    panic(err)
+                }
+                pipeline.Filters = processingStages(Settings.Filter)
+                if pipeline.Compare != nil {
+                    pipeline.Compare = processingStages(Settings.CompareFilter)
+                }
+                pipeline.Segments.Split("loop")
             }
-            channel <- voltage
-            x++
+        } else if scanner.Err() != nil {
+            panic(scanner.Err())
+        } else if Settings.Reprocess || Settings.Speed == 0 {
+            // A reprocessed recording is complete at the end of the file, and so is a simulated playback
+            return
         }
+
         // Converts our decimal value in seconds to an integer value in nanoseconds
-        time.Sleep(time.Duration(Settings.Interval * 1000 * 1000 * 1000))
+        Pace(time.Duration(Settings.Interval * 1000 * 1000 * 1000))
     }
 }
 
 /*
- This function generates random voltage data and writes it into the channel between this function
+ This function generates random voltage data and writes it into the pipeline between this function
  and the plotting logic
  */
-func grabRandomData(channel chan float64) {
+func grabRandomData(pipeline *Pipeline) {
+    setupFilters(pipeline)
+
+    // Counter
+    x := 0
 
     // Create an infinite loop
     for true {
 
-        // Random value between 0 and 5
-        channel <- rand.Float64() * 5
+        // Random value between 0 and 5, for the auxiliary sensors as well
+        sample := Sample{Index: x, Time: float64(x) * Settings.Interval, Value: rand.Float64() * 5}
+        for _, aux := range Settings.Aux {
+            sample.Aux = append(sample.Aux, aux.Convert(rand.Float64() * 5))
+        }
+        pipeline.Push(sample)
+        x++
 
         // Converts our decimal value in seconds to an integer value in nanoseconds
-        time.Sleep(time.Duration(Settings.Interval * 1000 * 1000 * 1000))
+        Pace(time.Duration(Settings.Interval * 1000 * 1000 * 1000))
     }
 }
 
@@ -206,6 +872,30 @@ type SettingsData struct {
      */
     Playback bool
 
+    /*
+     Whether the playback is processed with the stages given on the command line and recorded into Output, for
+     re-filtering old sessions with new parameters
+     */
+    Reprocess bool
+    Output string
+
+    /*
+     How fast playback and the debug mode run, including the timers of the protocol: 1 is the real time, 2 twice as
+     fast, and 0 as fast as the samples can be processed
+     */
+    Speed float64
+
+    /*
+     The second of the recording that the playback starts at. With an index (see IndexBlock), the playback jumps
+     there at once, otherwise the recording is read up to it.
+     */
+    Start float64
+
+    /*
+     A region of the recording that the playback repeats, as from:to in seconds (see PlaybackLoop)
+     */
+    Loop string
+
     /*
      The amount of seconds that passes between two measurements
      */
@@ -221,6 +911,48 @@ type SettingsData struct {
      */
     Scale int
 
+    /*
+     The percentage of values that is clipped at either end when the range of the chart is chosen, how many seconds
+     the range is held before it shrinks, and a fixed range (min:max in volts) instead of the automatic one
+     */
+    Clip float64
+    Hold float64
+    Range string
+
+    /*
+     How long the acquisition runs before it stops on its own. Zero runs until the program is interrupted.
+     */
+    Duration time.Duration
+
+    /*
+     In triggered mode, only the samples around a trigger are recorded: PreTrigger seconds before it, and PostTrigger
+     seconds after it. The trigger fires when the processed signal reaches the threshold in volts, or by hand.
+     */
+    Trigger float64
+    PreTrigger float64
+    PostTrigger float64
+
+    /*
+     A condition on several channels that fires the trigger instead of the threshold, see TriggerCondition
+     */
+    TriggerWhen string
+
+    /*
+     How the threshold of the trigger is debounced, see LevelTrigger: how many volts the signal has to fall below it
+     before the trigger fires again, how many seconds it has to stay at it before the trigger fires, and how many
+     seconds have to pass between two triggers
+     */
+    TriggerHysteresis float64
+    TriggerHold float64
+    TriggerRefractory float64
+
+    /*
+     A file with the phases of an exercise protocol that are announced while recording, and a directory with
+     recorded cues for the announcements
+     */
+    Protocol string
+    Cues string
+
     /*
      The width of the command line plot
      */
@@ -230,6 +962,344 @@ type SettingsData struct {
      The height of the command line plot
      */
     Height int
+
+    /*
+     How many times per second the chart is redrawn. The samples that arrive between two frames are drawn together,
+     and between two samples, the chart scrolls on with the time.
+     */
+    FPS float64
+
+    /*
+     How the time axis of the chart is labelled: seconds, elapsed (the time since the start, as mm:ss) or clock (the
+     time of day)
+     */
+    TimeAxis string
+
+    /*
+     Whether a playback shows an overview of the whole recording above the chart, with the part that the chart shows
+     highlighted
+     */
+    Overview bool
+
+    /*
+     Where the samples come from, if not from the ADCPi. Sources are given as URLs, like ble://AA:BB:CC:DD:EE:FF/2a37
+     */
+    Source string
+
+    /*
+     The I2C bus that the ADCPi is connected to
+     */
+    Bus string
+
+    /*
+     By default, the clock of the ADC defines when a sample is taken. With software timing, the application sleeps
+     for the interval between two measurements instead, which is less precise on a busy system.
+     */
+    SoftwareTiming bool
+
+    /*
+     The realtime priority (1 to 99) of the thread that reads the ADC, and the core it is pinned to. A priority of 0
+     and a negative core keep the defaults.
+     */
+    Priority int
+    CPU int
+
+    /*
+     The nice value of the thread that renders the chart. Positive values lower its priority.
+     */
+    RenderNice int
+
+    /*
+     How many intervals may pass without a sample before the acquisition is considered stalled. Zero disables the
+     watchdog.
+     */
+    Watchdog int
+
+    /*
+     How much space the filesystem of the recording should have left, in MB. Below WarnFree, the display warns, and
+     below MinFree, the session reacts as chosen by LowSpace: stop ends it, downsample only records every tenth sample
+     from then on. See DiskMonitor.
+     */
+    WarnFree int
+    MinFree int
+    LowSpace string
+
+    /*
+     After how many days the samples of the sessions in the directory of the recording are removed, and after how
+     many the sessions are removed completely. Zero keeps them forever. See Retention.
+     */
+    RetainRaw int
+    RetainRollups int
+
+    /*
+     The processing stages that are applied to the signal, for example "highpass:20,rectify,rms:0.1"
+     */
+    Filter string
+
+    /*
+     Other processing stages that the signal is processed with as well, for choosing between two sets of stages. The
+     display draws their output over the one of Filter in another color.
+     */
+    CompareFilter string
+
+    /*
+     How the output of the processing stages is recorded next to the raw signal: "raw" records only the raw signal,
+     "columns" adds a column for every stage and "files" writes every stage into its own file.
+     */
+    RecordStages string
+
+    /*
+     In ECG mode, heartbeats are detected in the signal, the heart rate is displayed and the intervals between the
+     beats are recorded
+     */
+    ECG bool
+
+    /*
+     Additional sensors that are recorded together with the muscle sensor, like force sensors or goniometers
+     */
+    Aux AuxChannels
+
+    /*
+     The auxiliary sensors that are drawn on the right axis of the chart, separated by commas. They need to have the
+     same unit.
+     */
+    Right string
+
+    /*
+     Whether the first derivative of the signal is drawn on the right axis, and its steepest rise is shown
+     */
+    Derivative bool
+
+    /*
+     An auxiliary sensor that only picks up interference, as name[:taps[:step]]. The interference is adaptively
+     removed from the signal.
+     */
+    Reference string
+
+    /*
+     The URLs of the sinks that the session is streamed to
+     */
+    Sinks SinkList
+
+    /*
+     The URLs of the notifications about the session, see OpenNotifications
+     */
+    Notify SinkList
+
+    /*
+     The certificate and the private key of the servers, which enables TLS for them, and the authority whose client
+     certificates the servers require
+     */
+    TLSCert string
+    TLSKey string
+    TLSClientCA string
+
+    /*
+     The token, and the user and password (as user:password), that clients of the servers need. The token can also be
+     given in the PLOT_TOKEN environment variable.
+     */
+    Token string
+    BasicAuth string
+
+    /*
+     Whether the instance and the status of its session are advertised over mDNS, see "plot discover"
+     */
+    Advertise bool
+
+    /*
+     The address that the session is served on for remote viewers, like :8080. Empty doesn't serve it.
+     */
+    Serve string
+
+    /*
+     How many seconds of the signal the server keeps for /snapshot
+     */
+    Snapshot float64
+
+    /*
+     The agonist and the antagonist whose co-contraction index is shown, separated by a comma, and the seconds over
+     which it is shown. emg is the muscle signal, other names are auxiliary sensors.
+     */
+    CoContraction string
+    CoContractionWindow float64
+
+    /*
+     The acceleration in g, without gravity, above which the accelerometer counts as motion, and how many seconds the
+     motion lasts after it (see MotionDetector). With MotionGate, the signal during motion is left out of the iEMG
+     of the segments and of the analysis.
+     */
+    Motion float64
+    MotionHold float64
+    MotionGate bool
+
+    /*
+     After how many seconds at the limits of the ADC or in a flat line the signal counts as lost (see SignalMonitor),
+     zero to not watch for it, the voltage that the signal varies by less in a flat line, and whether the recording
+     is paused while the signal is lost
+     */
+    SignalLoss float64
+    Flatline float64
+    PauseOnLoss bool
+
+    /*
+     The seconds between the sync markers for aligning a video (see SyncMarkers), zero for none, the output that
+     flashes with every marker (see OpenPatternOutput), and the GPIO chip of its pin
+     */
+    SyncMarkers float64
+    SyncOutput string
+    GPIOChip string
+
+    /*
+     Whether the camera of the Pi captures stills or a video next to the recording (see Camera), empty for neither,
+     the seconds between the stills, the frames per second of the video and the size of the frames
+     */
+    Camera string
+    CameraInterval float64
+    CameraFPS float64
+    CameraSize string
+
+    /*
+     The model that classifies the signal while it is acquired (see OpenModel), empty for none, the features that it
+     is given, and the seconds of the window that they are computed over and between two windows
+     */
+    Classify string
+    ClassifyFeatures string
+    ClassifyWindow float64
+    ClassifyStep float64
+
+    /*
+     How the predictions of the windows are smoothed: how many windows vote for the class, the confidence that a
+     window needs, and the class of the windows below it
+     */
+    ClassifyVotes int
+    ClassifyConfidence float64
+    ClassifyReject string
+
+    /*
+     The control output for closed-loop control (see ControlOutput), empty for none, and how many messages it queues
+     */
+    Control string
+    ControlQueue int
+
+    /*
+     The name of the shared memory that the samples are written into (see SharedRing), empty for none, and how many
+     samples it holds
+     */
+    SharedMemory string
+    SharedSlots int
+
+    /*
+     The gestures that a calibration prompts (see Calibration), separated by commas, empty for none, how often they
+     are repeated, the seconds that every one is held, the model that is trained and the file it is saved into
+     */
+    Calibrate string
+    CalibrateRounds int
+    CalibrateHold float64
+    CalibrateModel string
+    CalibrateOutput string
+
+    /*
+     An IMU (mpu6050 or lsm6ds3) whose acceleration and rotation are recorded as additional channels, and its I2C
+     address
+     */
+    IMU string
+    IMUAddress int
+
+    /*
+     The GPIO pins of an HX711 with a load cell (<data>:<clock>), whose force is recorded as an additional channel,
+     the newtons per count of the HX711, zero to record the counts, and its reading without load, zero to tare the
+     load cell when it starts
+     */
+    HX711 string
+    HX711Scale float64
+    HX711Offset float64
+
+    /*
+     The UPS HAT that powers the Pi (max17040, cw2015 or ina219), its I2C address, and the charge in percent below
+     which the session is ended, so the recording is closed before the battery runs out
+     */
+    UPS string
+    UPSAddress int
+    BatteryCritical float64
+
+    /*
+     The unit that voltages are displayed in: auto, V, mV, µV or %MVC. Recordings are always stored in volts.
+     */
+    Unit string
+    DisplayUnit Unit
+
+    /*
+     How amplitudes are scaled on the Y axis: linear, log or db
+     */
+    YScale YScale
+
+    /*
+     How the signal on the chart is smoothed. This can be changed at runtime.
+     */
+    Smoothing *Smoothing
+
+    /*
+     The voltage of the maximum voluntary contraction, which is the reference for %MVC
+     */
+    MVC float64
+
+    /*
+     Whether new recordings should be encrypted. The key is loaded from KeyFile, or derived from the passphrase in the
+     PLOT_PASSPHRASE environment variable.
+     */
+    Encrypt bool
+
+    /*
+     A file with the key that is used to encrypt new recordings and to decrypt existing ones
+     */
+    KeyFile string
+
+    /*
+     The name of the subject that is recorded. Only a pseudonym is stored in the recording, the mapping between names
+     and pseudonyms is kept in SubjectsFile.
+     */
+    Subject string
+    SubjectsFile string
+
+    /*
+     Free text notes that are stored in the metadata of the recording
+     */
+    Notes string
+
+    /*
+     Tags that the session can be found by later
+     */
+    Tags Tags
+
+    /*
+     The profile of a returning subject, which provides the settings that aren't given on the command line. With
+     SaveProfile, the settings that are given are stored in the profile. Profiles are kept in the directory Profiles.
+     */
+    Profile string
+    SaveProfile bool
+    Profiles string
+
+    /*
+     The guided tour of plot demo, which is narrated next to the chart, or nil
+     */
+    Tour *Tour
+}
+
+/*
+ Returns the kind of signal that is measured
+ */
+func (s SettingsData) Mode() string {
+    if s.ECG {
+        return "ecg"
+    }
+    return "emg"
+}
+
+/*
+ Whether only the samples around a trigger are recorded
+ */
+func (s SettingsData) Triggered() bool {
+    return s.Trigger > 0 || s.TriggerWhen != "" || s.PreTrigger > 0
 }
 
 /*
@@ -248,14 +1318,267 @@ func LoadSettings() {
     flag.BoolVar(&(Settings.Playback), "playback", false, "Whether the playback mode should be " +
         "enabled. In playback mode, the applications won't connect to the muscle sensor but load existing data and " +
         "display it again.")
+    flag.BoolVar(&(Settings.Reprocess), "reprocess", false, "In playback mode, process the recording with the " +
+        "stages of --filter and record it into --output, together with its events")
+    flag.Float64Var(&(Settings.Speed), "speed", 1, "How fast playback and the debug mode run, including the " +
+        "timers of the protocol, e.g. 4 for demos. 0 runs as fast as possible on a simulated clock.")
+    flag.Float64Var(&(Settings.Start), "start", 0, "The second of the recording that the playback starts at")
+    flag.StringVar(&(Settings.Loop), "loop", "", "A region of the recording that the playback repeats, as from:to " +
+        "in seconds. The l key sets it during the playback.")
+    flag.StringVar(&(Settings.Output), "output", "", "The file that a reprocessed recording is written into")
     flag.Float64Var(&(Settings.Interval), "interval", 0.1, "The amount of seconds that passes " +
         "between two measurements")
     flag.BoolVar(&(Settings.Debug), "debug", false, "In debug mode, the program generates " +
         "random data and plots that")
     flag.IntVar(&(Settings.Scale), "scale", 20, "Defines how many values should get plotted " +
         "at the same time")
-    flag.IntVar(&(Settings.Width), "width", goterm.Width(), "The width of the command line plot")
-    flag.IntVar(&(Settings.Height), "height", goterm.Height(), "The height of the command line plot")
+    flag.DurationVar(&(Settings.Duration), "duration", 0, "How long to record before stopping automatically, " +
+        "e.g. 5m. 0 records until the program is interrupted.")
+    flag.Float64Var(&(Settings.Trigger), "trigger", 0, "Only record around triggers, which fire when the " +
+        "processed signal reaches this many volts. The t key fires the trigger by hand.")
+    flag.Float64Var(&(Settings.PreTrigger), "pretrigger", 0, "In triggered mode, how many seconds before the " +
+        "trigger are recorded. Enables triggered mode if given without --trigger.")
+    flag.Float64Var(&(Settings.PostTrigger), "posttrigger", 10, "In triggered mode, how many seconds after the " +
+        "trigger are recorded")
+    flag.StringVar(&(Settings.TriggerWhen), "trigger-when", "", "Only record around triggers, which fire when a " +
+        "condition on several channels holds, e.g. \"rms > 30%MVC and rms(triceps, 0.1) < 10%MVC for 500ms\". " +
+        "Channels are emg, raw, the processing stages and the auxiliary sensors.")
+    flag.Float64Var(&(Settings.TriggerHysteresis), "trigger-hysteresis", 0, "How many volts the signal has to fall " +
+        "below the threshold of --trigger before the trigger can fire again")
+    flag.Float64Var(&(Settings.TriggerHold), "trigger-hold", 0, "How many seconds the signal has to stay at the " +
+        "threshold of --trigger before the trigger fires, which ignores short spikes")
+    flag.Float64Var(&(Settings.TriggerRefractory), "trigger-refractory", 0, "How many seconds have to pass after " +
+        "the trigger fired before it fires again")
+    flag.StringVar(&(Settings.Protocol), "protocol", "", "A file with the phases of an exercise protocol, one " +
+        "seconds;name per line. The phases are announced and the acquisition stops after the last one.")
+    flag.StringVar(&(Settings.Cues), "cues", "", "A directory with recorded cues for the protocol (contract.wav, " +
+        "3.wav, ...). Cues without a file are spoken by espeak.")
+    flag.Float64Var(&(Settings.Clip), "clip", 1, "The percentage of values that is clipped at either end when " +
+        "the range of the chart is chosen, so single artifacts don't squash the signal")
+    flag.Float64Var(&(Settings.Hold), "hold", 2, "How many seconds the range of the chart is held before it shrinks")
+    flag.StringVar(&(Settings.Range), "range", "", "A fixed range for the chart as min:max in volts. The a key " +
+        "switches between the fixed and the automatic range.")
+    flag.IntVar(&(Settings.Width), "width", terminalWidth(), "The width of the command line plot")
+    flag.IntVar(&(Settings.Height), "height", terminalHeight(), "The height of the command line plot")
+    flag.Float64Var(&(Settings.FPS), "fps", 10, "How many times per second the chart is redrawn")
+    flag.StringVar(&(Settings.TimeAxis), "time-axis", "elapsed", "How the time axis is labelled: seconds, " +
+        "elapsed (mm:ss since the start) or clock (the time of day)")
+    flag.BoolVar(&(Settings.Overview), "overview", true, "In playback, show an overview of the whole recording " +
+        "above the chart. Recordings without an index (see plot index) are read once for it.")
+    flag.StringVar(&(Settings.Source), "source", "", "Where the samples come from, if not from the ADCPi, e.g. " +
+        "ble://AA:BB:CC:DD:EE:FF/2a37?format=hrm. Available: " + strings.Join(SourceNames(), ", "))
+    flag.StringVar(&(Settings.Bus), "bus", "/dev/i2c-1", "The I2C bus that the ADCPi is connected to")
+    flag.BoolVar(&(Settings.SoftwareTiming), "software-timing", false, "Sleep for the interval between two " +
+        "measurements instead of using the clock of the ADC")
+    flag.IntVar(&(Settings.Priority), "priority", 0, "The realtime priority (1-99) of the acquisition thread. " +
+        "Requires root.")
+    flag.IntVar(&(Settings.CPU), "cpu", -1, "The CPU core the acquisition thread is pinned to")
+    flag.IntVar(&(Settings.RenderNice), "render-nice", 0, "The nice value of the rendering thread. Positive " +
+        "values lower its priority.")
+    flag.IntVar(&(Settings.Watchdog), "watchdog", 10, "How many intervals may pass without a sample before " +
+        "the acquisition is considered stalled. 0 disables the watchdog.")
+    flag.IntVar(&(Settings.WarnFree), "warn-free", 1024, "Below how many MB of free space for the recording the " +
+        "display warns")
+    flag.IntVar(&(Settings.MinFree), "min-free", 100, "Below how many MB of free space the session reacts as " +
+        "chosen by --low-space")
+    flag.StringVar(&(Settings.LowSpace), "low-space", "stop", "What happens when the space for the recording runs " +
+        "out: stop ends the session, downsample only records every tenth sample until it is really scarce")
+    flag.IntVar(&(Settings.RetainRaw), "retain-raw", 0, "After how many days the samples of the sessions in the " +
+        "directory of the recording are removed, keeping their metadata and rollups. 0 keeps them forever.")
+    flag.IntVar(&(Settings.RetainRollups), "retain-rollups", 0, "After how many days the sessions in the directory " +
+        "of the recording are removed completely. 0 keeps them forever.")
+    flag.StringVar(&(Settings.Filter), "filter", "", "The processing stages that are applied to the signal, " +
+        "e.g. highpass:20,rectify,rms:0.1. Available: highpass:Hz, lowpass:Hz, notch:Hz, rectify, rms:seconds")
+    flag.StringVar(&(Settings.CompareFilter), "compare-filter", "", "Other processing stages that the signal is " +
+        "processed with as well, drawn over the output of --filter in another color to compare them")
+    flag.StringVar(&(Settings.RecordStages), "record-stages", "columns", "How the processed signal is recorded " +
+        "next to the raw one: raw, columns or files")
+    flag.BoolVar(&(Settings.ECG), "ecg", false, "Detect heartbeats in an ECG, display the heart rate and record " +
+        "the RR intervals")
+    flag.Var(&(Settings.Aux), "aux", "An auxiliary sensor, as channel:name:unit[:scale[:offset]]. The voltage is " +
+        "converted using value = voltage * scale + offset. Can be given several times.")
+    flag.StringVar(&(Settings.Right), "right", "", "The auxiliary sensors that are drawn on the right axis of the " +
+        "chart, separated by commas, or none. They need to have the same unit. Default: the first one.")
+    flag.BoolVar(&(Settings.Derivative), "derivative", false, "Draw the first derivative of the signal on the " +
+        "right axis and show its peak slope, as a proxy for the rate of force development")
+    flag.Var(&(Settings.Sinks), "sink", "Stream the session to a sink, given as URL: " +
+        strings.Join(SinkNames(), ", ") + ". Can be given several times. Add ?rate=hz&aggregate=" +
+        strings.Join(AggregateNames(), "|") + " to send it fewer samples.")
+    flag.Var(&(Settings.Notify), "notify", "Send notifications when the session starts and ends, on triggers and " +
+        "on failures, given as URL: ntfy://host/topic, telegram://chat, mailto:address?smtp=host:port. Can be " +
+        "given several times.")
+    flag.StringVar(&(Settings.TLSCert), "tls-cert", "", "The certificate of the servers (PEM). Together with " +
+        "--tls-key, the servers only accept TLS connections.")
+    flag.StringVar(&(Settings.TLSKey), "tls-key", "", "The private key of the certificate of the servers (PEM)")
+    flag.StringVar(&(Settings.TLSClientCA), "tls-ca", "", "Only let clients of the servers connect that have a " +
+        "certificate of this authority (PEM)")
+    flag.StringVar(&(Settings.Token), "token", "", "The token that clients of the servers need, as bearer token or " +
+        "?token=. Prefer the PLOT_TOKEN environment variable, the command line is visible to other users.")
+    flag.StringVar(&(Settings.Serve), "serve", "", "Serve the session for remote viewers (plot view) and other " +
+        "programs on this address, like :8080. See --tls-cert and --token to secure it.")
+    flag.Float64Var(&(Settings.Snapshot), "snapshot", 60, "How many seconds of the signal the server of --serve " +
+        "keeps for /snapshot")
+    flag.BoolVar(&(Settings.Advertise), "advertise", false, "Advertise the instance and the status of the session " +
+        "on the local network (mDNS), so it can be found with plot discover")
+    flag.StringVar(&(Settings.BasicAuth), "basic-auth", "", "The user and password that clients of the servers can " +
+        "use instead, as user:password")
+    flag.StringVar(&(Settings.Reference), "reference", "", "An auxiliary sensor that only picks up interference, " +
+        "like an electrode on bone or a pickup loop, as name[:taps[:step]] (default 32 taps, step 0.05). The " +
+        "interference is adaptively removed from the signal (LMS) as the first processing stage, lms.")
+    flag.StringVar(&(Settings.CoContraction), "cocontraction", "", "Show the co-contraction index of an agonist " +
+        "and its antagonist, given as two names separated by a comma. emg is the muscle signal, other names are " +
+        "auxiliary sensors that measure EMG in volts. The index is exported per segment.")
+    flag.Float64Var(&(Settings.CoContractionWindow), "cci-window", 1, "The seconds over which the co-contraction " +
+        "index is shown")
+    flag.Float64Var(&(Settings.Motion), "motion", 0.5, "The acceleration in g (without gravity) above which an " +
+        "accelerometer among the auxiliary sensors (in g or m/s2) or the IMU counts as motion. Motion is flagged " +
+        "in the events and the segments. 0 disables this.")
+    flag.Float64Var(&(Settings.MotionHold), "motion-hold", 0.5, "How many seconds the motion lasts after the " +
+        "acceleration was above --motion, since the artifacts outlast the movement")
+    flag.BoolVar(&(Settings.MotionGate), "motion-gate", false, "Leave the signal during motion out of the iEMG " +
+        "of the segments and of the analysis")
+    flag.Float64Var(&(Settings.SignalLoss), "signal-loss", 0, "After how many seconds of lead-off (the signal " +
+        "at the limits of the ADC) or of a flat line the signal counts as lost, which is noted in the events. 0 " +
+        "disables this.")
+    flag.Float64Var(&(Settings.Flatline), "flatline", 1e-6, "The voltage by which the raw signal varies less " +
+        "during a flat line")
+    flag.BoolVar(&(Settings.PauseOnLoss), "pause-on-loss", false, "Pause the recording while the signal is lost, " +
+        "and resume it when it is back, see --signal-loss")
+    flag.Float64Var(&(Settings.SyncMarkers), "sync-markers", 0, "Note a sync marker in the events every this many " +
+        "seconds, to align a video of the session with the signal. 0 disables this.")
+    flag.StringVar(&(Settings.SyncOutput), "sync-output", "", "An output that flashes with every sync marker, like " +
+        "an LED in view of the camera: gpio:<pin> or mcp4725[:<address>]")
+    flag.StringVar(&(Settings.GPIOChip), "gpio-chip", "/dev/gpiochip0", "The GPIO chip of the pins, " +
+        "/dev/gpiochip4 on the Pi 5")
+    flag.StringVar(&(Settings.Camera), "camera", "", "Capture what the subject is doing with the camera of the Pi, " +
+        "next to the recording: stills or video")
+    flag.Float64Var(&(Settings.CameraInterval), "camera-interval", 5, "The seconds between two stills of --camera")
+    flag.Float64Var(&(Settings.CameraFPS), "camera-fps", 5, "The frames per second of the video of --camera")
+    flag.StringVar(&(Settings.CameraSize), "camera-size", "1280x720", "The size of the frames of --camera")
+    flag.StringVar(&(Settings.Classify), "classify", "", "Classify the signal in a sliding window with a model, " +
+        "and note the class in the events: " + strings.Join(ModelNames(), ", ") + ", like " +
+        "threshold:rms?levels=0.05 or onnx:model.onnx?labels=rest,fist")
+    flag.StringVar(&(Settings.ClassifyFeatures), "classify-features", defaultFeatures, "The features of the " +
+        "window that --classify passes to the model, in this order: " + strings.Join(FeatureNames(), ", "))
+    flag.Float64Var(&(Settings.ClassifyWindow), "classify-window", 0.2, "The seconds of the window of --classify")
+    flag.Float64Var(&(Settings.ClassifyStep), "classify-step", 0.05, "The seconds between two windows of --classify")
+    flag.IntVar(&(Settings.ClassifyVotes), "classify-votes", 1, "How many of the last windows vote for the class " +
+        "of --classify, by majority. 1 uses every window as it is.")
+    flag.Float64Var(&(Settings.ClassifyConfidence), "classify-confidence", 0, "The confidence from 0 to 1 that a " +
+        "window of --classify needs, or it is assigned to --classify-reject")
+    flag.StringVar(&(Settings.ClassifyReject), "classify-reject", "unknown", "The class of the windows of " +
+        "--classify whose confidence is too low")
+    flag.StringVar(&(Settings.Control), "control", "", "Send the envelope and the class of --classify for every " +
+        "sample to a controller, apart from the streams: " + strings.Join(ControlNames(), ", ") + ", like " +
+        "udp://host:port")
+    flag.IntVar(&(Settings.ControlQueue), "control-queue", 4, "How many messages of --control wait for the " +
+        "controller, before the oldest are dropped")
+    flag.StringVar(&(Settings.SharedMemory), "shm", "", "Share the samples with processes on the same device " +
+        "through a ring buffer in the shared memory /dev/shm/<name>")
+    flag.IntVar(&(Settings.SharedSlots), "shm-slots", sharedCapacity, "How many samples the ring buffer of --shm holds")
+    flag.StringVar(&(Settings.Calibrate), "calibrate", "", "Calibrate a classifier: prompt the gestures, like " +
+        "rest,fist,open, collect their windows and train a model for --classify")
+    flag.IntVar(&(Settings.CalibrateRounds), "calibrate-rounds", 3, "How often every gesture of --calibrate is " +
+        "prompted")
+    flag.Float64Var(&(Settings.CalibrateHold), "calibrate-hold", 5, "The seconds that every gesture of --calibrate " +
+        "is held")
+    flag.StringVar(&(Settings.CalibrateModel), "calibrate-model", "lda", "The model that --calibrate trains: lda " +
+        "or knn")
+    flag.StringVar(&(Settings.CalibrateOutput), "calibrate-output", "model.json", "The file that --calibrate saves " +
+        "the model into")
+    flag.StringVar(&(Settings.IMU), "imu", "", "An IMU on the I2C bus that is recorded together with the muscle " +
+        "sensor: mpu6050 or lsm6ds3")
+    flag.IntVar(&(Settings.IMUAddress), "imu-address", 0, "The I2C address of the IMU. 0 uses the default of the chip.")
+    flag.StringVar(&(Settings.HX711), "hx711", "", "An HX711 with a load cell whose force is recorded together " +
+        "with the muscle sensor, as the GPIO pins <data>:<clock>, see --gpio-chip")
+    flag.Float64Var(&(Settings.HX711Scale), "hx711-scale", 0, "The newtons per count of the --hx711, which depend " +
+        "on the load cell. Find it by loading the cell with a known weight. 0 records the counts.")
+    flag.Float64Var(&(Settings.HX711Offset), "hx711-offset", 0, "The reading of the --hx711 without load. 0 tares " +
+        "the load cell when the acquisition starts, so it must not be loaded then.")
+    flag.StringVar(&(Settings.UPS), "ups", "", "The UPS HAT whose battery is shown in the status bar: max17040 " +
+        "(X728, X708), cw2015 (UPS-Lite) or ina219 (Waveshare)")
+    flag.IntVar(&(Settings.UPSAddress), "ups-address", 0, "The I2C address of the UPS. 0 uses the default of the chip.")
+    flag.Float64Var(&(Settings.BatteryCritical), "battery-critical", 10, "The charge of the battery in percent " +
+        "below which the session is ended")
+    flag.StringVar(&(Settings.Unit), "unit", "auto", "The unit that voltages are displayed in: auto, V, mV, uV " +
+        "or %MVC")
+    yscale := flag.String("yscale", "linear", "How amplitudes are scaled on the Y axis: linear, log (base 10 " +
+        "logarithm of the magnitude) or db (decibels relative to one unit)")
+    smoothing := flag.String("smooth", "", "How the signal on the chart is smoothed, as kind:seconds: sma " +
+        "(moving average), ema (exponential moving average) or median, e.g. sma:0.1. Press s to switch the kind and " +
+        "+ or - to change the window while the chart is shown.")
+    flag.Float64Var(&(Settings.MVC), "mvc", 0, "The voltage of the maximum voluntary contraction, used for %MVC")
+    flag.BoolVar(&(Settings.Encrypt), "encrypt", false, "Whether the recording should be encrypted. Requires " +
+        "--key or the PLOT_PASSPHRASE environment variable.")
+    flag.StringVar(&(Settings.KeyFile), "key", "", "A file with the 32 byte key that is used to encrypt and " +
+        "decrypt recordings")
+    flag.StringVar(&(Settings.Subject), "subject", "", "The name of the subject that is recorded. Only a " +
+        "pseudonym is stored in the recording.")
+    flag.StringVar(&(Settings.SubjectsFile), "subjects", "subjects.csv", "The file that maps the names of " +
+        "subjects to their pseudonyms. It is encrypted if --encrypt is given.")
+    flag.StringVar(&(Settings.Notes), "notes", "", "Free text notes that are stored with the recording")
+    flag.Var(&(Settings.Tags), "tag", "A tag that the session can be found by with \"plot sessions find\". Can " +
+        "be given several times.")
+    flag.StringVar(&(Settings.Profile), "profile", "", "The profile of a returning subject, which provides the " +
+        "settings that aren't given on the command line, like the MVC, calibrations and thresholds")
+    flag.BoolVar(&(Settings.SaveProfile), "save-profile", false, "Store the settings that are given on the " +
+        "command line in the profile")
+    flag.StringVar(&(Settings.Profiles), "profiles", "profiles", "The directory where profiles are stored")
+    flag.Usage = func() {
+        fmt.Fprintf(flag.CommandLine.Output(), "Usage: plot [options]\n       plot <command> [arguments]\n\n" +
+            "Commands: %s\n\nOptions:\n", strings.Join(CommandNames(), ", "))
+        flag.PrintDefaults()
+    }
+    config := flag.String("config", DefaultConfigFile(), "The configuration file of the user, see plot keys")
     flag.Parse()
+
+    // The configuration of the user is only required if its file was given
+    given := false
+    flag.Visit(func(f *flag.Flag) {
+        given = given || f.Name == "config"
+    })
+    configuration, err := LoadConfig(*config, given)
+    if err != nil {
+        fail("%s: %v", *config, err)
+    }
+
+    // Fill in the settings of the subject, and remember the ones that were given for the next session
+    if Settings.Profile != "" {
+        profile, err := LoadProfile(Settings.Profile)
+        if err != nil && !(os.IsNotExist(err) && Settings.SaveProfile) {
+            fail("profile %s: %v", Settings.Profile, err)
+        }
+        err = profile.Apply(flag.CommandLine)
+        if err != nil {
+            fail("profile %s: %v", Settings.Profile, err)
+        }
+        if Settings.SaveProfile {
+            profile.Update(flag.CommandLine)
+            err = SaveProfile(Settings.Profile, profile)
+            if err != nil {
+                fail("profile %s: %v", Settings.Profile, err)
+            }
+        }
+    }
+
+    // The configuration only fills in what neither the command line nor the profile set
+    err = configuration.Options.Apply(flag.CommandLine)
+    if err != nil {
+        fail("%s: %v", *config, err)
+    }
+
+    unit, err := ParseUnit(Settings.Unit, Settings.MVC)
+    if err != nil {
+        fail("%v", err)
+    }
+    Settings.DisplayUnit = unit
+    Settings.YScale, err = ParseYScale(*yscale)
+    if err != nil {
+        fail("%v", err)
+    }
+    Settings.Smoothing, err = ParseSmoothing(*smoothing)
+    if err != nil {
+        fail("%v", err)
+    }
 }
 
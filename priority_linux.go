@@ -0,0 +1,56 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "unsafe"
+    "runtime"
+    "syscall"
+)
+
+/*
+ The scheduling policy for realtime threads (see sched.h)
+ */
+const schedFIFO = 1
+
+/*
+ Binds the calling goroutine to its OS thread and gives that thread realtime priority using SCHED_FIFO. Priorities go
+ from 1 to 99, and 0 keeps the default scheduling. If cpu isn't negative, the thread is also pinned to that core.
+ Raising the priority requires root or CAP_SYS_NICE.
+ */
+func RaisePriority(priority int, cpu int) error {
+    runtime.LockOSThread()
+    if priority > 0 {
+        param := struct{ priority int32 }{int32(priority)}
+        _, _, errno := syscall.Syscall(syscall.SYS_SCHED_SETSCHEDULER, 0, schedFIFO, uintptr(unsafe.Pointer(&param)))
+        if errno != 0 {
+            return errno
+        }
+    }
+    if cpu >= 0 {
+        mask := make([]uint64, cpu / 64 + 1)
+        mask[cpu / 64] = 1 << uint(cpu % 64)
+        _, _, errno := syscall.Syscall(syscall.SYS_SCHED_SETAFFINITY, 0, uintptr(len(mask) * 8),
+            uintptr(unsafe.Pointer(&mask[0])))
+        if errno != 0 {
+            return errno
+        }
+    }
+    return nil
+}
+
+/*
+ Binds the calling goroutine to its OS thread and lowers the priority of that thread, so that bursts of work in it
+ (like rendering the chart) don't delay the acquisition
+ */
+func LowerPriority(nice int) error {
+    runtime.LockOSThread()
+    if nice == 0 {
+        return nil
+    }
+    return syscall.Setpriority(syscall.PRIO_PROCESS, syscall.Gettid(), nice)
+}
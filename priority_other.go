@@ -0,0 +1,30 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+//go:build !linux
+
+package main
+
+import (
+    "errors"
+)
+
+/*
+ Thread priorities and CPU pinning are only supported on Linux
+ */
+func RaisePriority(priority int, cpu int) error {
+    if priority > 0 || cpu >= 0 {
+        return errors.New("thread priorities are only supported on Linux")
+    }
+    return nil
+}
+
+func LowerPriority(nice int) error {
+    if nice != 0 {
+        return errors.New("thread priorities are only supported on Linux")
+    }
+    return nil
+}
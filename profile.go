@@ -0,0 +1,89 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "flag"
+    "path/filepath"
+    "encoding/json"
+)
+
+/*
+ The settings of a returning subject, like the MVC, the calibration of the auxiliary sensors, the thresholds and the
+ layout of the chart. A profile stores options by their name on the command line, as they would be given there:
+    {"mvc": "0.0021", "unit": "%MVC", "aux": "2:force:N:250:0", "trigger": "0.001"}
+ Options that are given on the command line take precedence over the profile.
+ */
+type Profile map[string]string
+
+/*
+ Options that only apply to one session and are never stored in a profile
+ */
+var sessionOptions = map[string]bool{"file": true, "notes": true, "profile": true, "save-profile": true,
+    "profiles": true, "playback": true, "debug": true, "duration": true, "width": true, "height": true,
+    "config": true}
+
+/*
+ Returns the file of a profile in the directory of profiles
+ */
+func ProfileFile(name string) string {
+    return filepath.Join(Settings.Profiles, name + ".json")
+}
+
+func LoadProfile(name string) (Profile, error) {
+    profile := Profile{}
+    data, err := os.ReadFile(ProfileFile(name))
+    if err != nil {
+        return profile, err
+    }
+    err = json.Unmarshal(data, &profile)
+    return profile, err
+}
+
+func SaveProfile(name string, profile Profile) error {
+    data, err := json.MarshalIndent(profile, "", "    ")
+    if err != nil {
+        return err
+    }
+    err = os.MkdirAll(Settings.Profiles, 0700)
+    if err != nil {
+        return err
+    }
+    return os.WriteFile(ProfileFile(name), data, 0600)
+}
+
+/*
+ Sets the options of the profile that weren't given on the command line
+ */
+func (p Profile) Apply(flags *flag.FlagSet) error {
+    given := map[string]bool{}
+    flags.Visit(func(f *flag.Flag) {
+        given[f.Name] = true
+    })
+    for name, value := range p {
+        if given[name] || sessionOptions[name] {
+            continue
+        }
+        err := flags.Set(name, value)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+/*
+ Stores the options that were given on the command line in the profile
+ */
+func (p Profile) Update(flags *flag.FlagSet) {
+    flags.Visit(func(f *flag.Flag) {
+        if !sessionOptions[f.Name] {
+            p[f.Name] = f.Value.String()
+        }
+    })
+}
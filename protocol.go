@@ -0,0 +1,137 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "fmt"
+    "time"
+    "bufio"
+    "strings"
+    "strconv"
+    "os/exec"
+    "path/filepath"
+    "sync/atomic"
+)
+
+/*
+ A phase of an exercise protocol, like contracting or relaxing a muscle for a few seconds
+ */
+type Phase struct {
+    Name string
+    Duration float64
+}
+
+/*
+ An exercise protocol that is announced while recording, so the subject doesn't have to watch the terminal. Every
+ phase is announced when it begins, and the last three seconds before the next phase are counted down. The beginning
+ of every phase is written into the events of the recording, and every phase is its own segment. Once all phases are
+ done, the acquisition stops.
+ */
+type Protocol struct {
+    Phases []Phase
+    current atomic.Value
+}
+
+/*
+ Loads a protocol from a file with one phase per line, given as seconds and name, e.g.
+    5;relax
+    3;contract
+ Empty lines and lines starting with # are ignored.
+ */
+func LoadProtocol(file string) (*Protocol, error) {
+    f, err := os.Open(file)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    protocol := &Protocol{}
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        parts := strings.SplitN(line, ";", 2)
+        if len(parts) != 2 {
+            return nil, fmt.Errorf("%s: expected seconds;name, got %s", file, line)
+        }
+        duration, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %v", file, err)
+        }
+        protocol.Phases = append(protocol.Phases, Phase{Name: strings.TrimSpace(parts[1]), Duration: duration})
+    }
+    if len(protocol.Phases) == 0 {
+        return nil, fmt.Errorf("%s: the protocol has no phases", file)
+    }
+    return protocol, scanner.Err()
+}
+
+/*
+ Runs through the phases on the clock of the session. Announcements are played with the cue player, see Announce.
+ */
+func (p *Protocol) Run(pipeline *Pipeline) {
+
+    // Wait for the first sample, so the first phase is part of the recording
+    for pipeline.Pushed() == 0 {
+        SystemClock.Sleep(100 * time.Millisecond)
+    }
+
+    // Every phase starts where the last one ended, so late wakeups don't add up
+    end := SystemClock.Now()
+    for i, phase := range p.Phases {
+        p.current.Store(phase.Name)
+        pipeline.EventWithNext("phase " + phase.Name, phase.Name)
+        Announce(phase.Name)
+
+        // Count down the last three seconds before the next phase
+        start := end
+        end = start.Add(time.Duration(phase.Duration * 1000 * 1000 * 1000))
+        if i < len(p.Phases) - 1 {
+            for n := 3; n > 0; n-- {
+                at := end.Add(-time.Duration(n) * time.Second)
+                if at.After(start) {
+                    SystemClock.Sleep(Until(at))
+                    Announce(strconv.Itoa(n))
+                }
+            }
+        }
+        SystemClock.Sleep(Until(end))
+    }
+    p.current.Store("done")
+    Announce("done")
+    pipeline.Close()
+}
+
+/*
+ Returns the name of the current phase, or an empty string if the protocol hasn't started yet
+ */
+func (p *Protocol) Current() string {
+    name, _ := p.current.Load().(string)
+    return name
+}
+
+/*
+ Plays a cue without waiting for it to finish. If a directory of recorded cues was given with --cues and it contains
+ a file named after the cue (contract.wav), that file is played with aplay. Otherwise the cue is spoken by espeak. If
+ neither is available, the cue is only shown on the screen.
+ */
+func Announce(cue string) {
+    command := exec.Command("espeak", cue)
+    if Settings.Cues != "" {
+        file := filepath.Join(Settings.Cues, cue + ".wav")
+        _, err := os.Stat(file)
+        if err == nil {
+            command = exec.Command("aplay", "-q", file)
+        }
+    }
+    err := command.Start()
+    if err == nil {
+        go command.Wait()
+    }
+}
@@ -0,0 +1,102 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "io"
+    "fmt"
+    "bufio"
+    "strings"
+    "crypto/rand"
+    "encoding/hex"
+)
+
+/*
+ Recordings never contain the name of the subject. Instead, every subject gets a random pseudonym, and the mapping
+ between names and pseudonyms is stored in a separate file. That file stays with the clinic, while the recordings can
+ be shared. If encryption is enabled, the mapping file is encrypted using the same key as the recordings.
+ The file contains one subject per line:
+    S-3fa9c2;Jane Doe
+ */
+type Subjects map[string]string
+
+/*
+ Loads the mapping between names and pseudonyms. A missing file is treated like an empty one.
+ */
+func LoadSubjects(file string) (Subjects, error) {
+    subjects := Subjects{}
+    in, err := OpenRecording(file)
+    if os.IsNotExist(err) {
+        return subjects, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    defer in.Close()
+    scan := bufio.NewScanner(in)
+    for scan.Scan() {
+        parts := strings.SplitN(scan.Text(), ";", 2)
+        if len(parts) != 2 {
+            continue
+        }
+        subjects[parts[1]] = parts[0]
+    }
+    return subjects, scan.Err()
+}
+
+/*
+ Stores the mapping between names and pseudonyms
+ */
+func SaveSubjects(file string, subjects Subjects) error {
+    f, err := os.OpenFile(file, os.O_WRONLY | os.O_CREATE | os.O_TRUNC, 0600)
+    if err != nil {
+        return err
+    }
+    var out io.WriteCloser = f
+    if Settings.Encrypt {
+        secret, err := LoadSecret()
+        if err == nil && secret == nil {
+            err = fmt.Errorf("encrypting %s requires --key or %s", file, passphraseVariable)
+        }
+        if err == nil {
+            out, err = NewEncryptedWriter(f, secret)
+        }
+        if err != nil {
+            f.Close()
+            return err
+        }
+    }
+    for name, pseudonym := range subjects {
+        _, err = fmt.Fprintf(out, "%s;%s\n", pseudonym, name)
+        if err != nil {
+            out.Close()
+            return err
+        }
+    }
+    return out.Close()
+}
+
+/*
+ Returns the pseudonym of a subject. If the subject is new, a pseudonym is created and added to the mapping file.
+ */
+func Pseudonym(name string) (string, error) {
+    subjects, err := LoadSubjects(Settings.SubjectsFile)
+    if err != nil {
+        return "", err
+    }
+    if pseudonym, ok := subjects[name]; ok {
+        return pseudonym, nil
+    }
+    id := make([]byte, 3)
+    _, err = rand.Read(id)
+    if err != nil {
+        return "", err
+    }
+    subjects[name] = "S-" + hex.EncodeToString(id)
+    return subjects[name], SaveSubjects(Settings.SubjectsFile, subjects)
+}
@@ -0,0 +1,273 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "time"
+    "reflect"
+    "strings"
+)
+
+/*
+ Generates a Python module that reads the outputs of plot, with nothing but the standard library: the stream of a
+ server (--serve), decoded into a dataclass per message, the shared memory (--shm) and the control output (--control).
+ The dataclasses are generated from the structs of the messages like the JSON schema, so the client can't drift from
+ what is actually sent. Fields that are added later are ignored by older clients, and unknown messages are returned as
+ they are. In a notebook:
+    import plot_client
+    for message in plot_client.subscribe("http://raspberrypi:8080", token="..."):
+        print(message)
+ */
+func PythonClient() string {
+    var code strings.Builder
+    fmt.Fprintf(&code, pythonHeader, SchemaVersion, SchemaVersion, sharedMagic, sharedVersion, ControlMessageSize,
+        controlClassSize)
+
+    // The structs that the messages contain come before them
+    classes := []reflect.Type{}
+    seen := map[reflect.Type]bool{}
+    var collect func(t reflect.Type)
+    collect = func(t reflect.Type) {
+        switch t.Kind() {
+        case reflect.Slice, reflect.Map:
+            collect(t.Elem())
+        case reflect.Struct:
+            if t == reflect.TypeOf(time.Time{}) || seen[t] {
+                return
+            }
+            seen[t] = true
+            for _, field := range pythonFields(t) {
+                collect(field.Type)
+            }
+            classes = append(classes, t)
+        }
+    }
+    for _, message := range streamMessages {
+        collect(reflect.TypeOf(message.Message))
+    }
+    for _, class := range classes {
+        code.WriteString(pythonClass(class))
+    }
+    code.WriteString("\nMESSAGES = {\n")
+    for _, message := range streamMessages {
+        fmt.Fprintf(&code, "    %q: %s,\n", message.Type, reflect.TypeOf(message.Message).Name())
+    }
+    code.WriteString("}\n")
+    code.WriteString(pythonFunctions)
+    return code.String()
+}
+
+/*
+ Returns the fields of a struct with those of the embedded structs, like the header of the messages
+ */
+func pythonFields(t reflect.Type) []reflect.StructField {
+    fields := []reflect.StructField{}
+    for i := 0; i < t.NumField(); i++ {
+        field := t.Field(i)
+        if field.Anonymous {
+            fields = append(fields, pythonFields(field.Type)...)
+        } else {
+            fields = append(fields, field)
+        }
+    }
+    return fields
+}
+
+/*
+ Generates the dataclass of a struct. Optional fields (omitempty) are None if they are missing, and come last, since
+ Python wants the fields with defaults after the others.
+ */
+func pythonClass(t reflect.Type) string {
+    required := []string{}
+    optional := []string{}
+    arguments := []string{}
+    for _, field := range pythonFields(t) {
+        tag := strings.Split(field.Tag.Get("json"), ",")
+        name := tag[0]
+        attribute := name
+        if pythonKeywords[name] {
+            attribute = name + "_"
+        }
+        line := fmt.Sprintf("    %s: %s", attribute, pythonType(field.Type))
+        value := pythonValue(field.Type, fmt.Sprintf("data[%q]", name), 0)
+        omitted := len(tag) > 1 && tag[1] == "omitempty"
+        if omitted {
+            line = fmt.Sprintf("    %s: Optional[%s] = None", attribute, pythonType(field.Type))
+            if value == fmt.Sprintf("data[%q]", name) {
+                value = fmt.Sprintf("data.get(%q)", name)
+            } else {
+                value = fmt.Sprintf("None if data.get(%q) is None else %s", name, value)
+            }
+        }
+        if description := field.Tag.Get("description"); description != "" {
+            line += "\n    \"\"\"" + description + "\"\"\""
+        }
+        if omitted {
+            optional = append(optional, line)
+        } else {
+            required = append(required, line)
+        }
+        arguments = append(arguments, fmt.Sprintf("            %s=%s,", attribute, value))
+    }
+    return fmt.Sprintf("\n\n@dataclass\nclass %s:\n%s\n\n    @classmethod\n    def from_dict(cls, data):\n" +
+        "        return cls(\n%s\n        )\n", t.Name(), strings.Join(append(required, optional...), "\n"),
+        strings.Join(arguments, "\n"))
+}
+
+/*
+ The keywords of Python, which can't name an attribute. Fields with their name get an underscore, like class_.
+ */
+var pythonKeywords = map[string]bool{"False": true, "None": true, "True": true, "and": true, "as": true,
+    "assert": true, "async": true, "await": true, "break": true, "class": true, "continue": true, "def": true,
+    "del": true, "elif": true, "else": true, "except": true, "finally": true, "for": true, "from": true,
+    "global": true, "if": true, "import": true, "in": true, "is": true, "lambda": true, "nonlocal": true,
+    "not": true, "or": true, "pass": true, "raise": true, "return": true, "try": true, "while": true, "with": true,
+    "yield": true}
+
+/*
+ Returns the type hint of a Go type
+ */
+func pythonType(t reflect.Type) string {
+    switch t.Kind() {
+    case reflect.Struct:
+        if t == reflect.TypeOf(time.Time{}) {
+            return "str"
+        }
+        return t.Name()
+    case reflect.Slice:
+        return "List[" + pythonType(t.Elem()) + "]"
+    case reflect.Map:
+        return "Dict[str, " + pythonType(t.Elem()) + "]"
+    case reflect.String:
+        return "str"
+    case reflect.Int:
+        return "int"
+    case reflect.Float64:
+        return "float"
+    case reflect.Bool:
+        return "bool"
+    }
+    panic("no Python type for " + t.String())
+}
+
+/*
+ Returns the expression that converts a decoded JSON value into the type hint of a Go type
+ */
+func pythonValue(t reflect.Type, expression string, depth int) string {
+    item := fmt.Sprintf("v%d", depth)
+    switch t.Kind() {
+    case reflect.Struct:
+        if t != reflect.TypeOf(time.Time{}) {
+            return t.Name() + ".from_dict(" + expression + ")"
+        }
+    case reflect.Slice:
+        if value := pythonValue(t.Elem(), item, depth + 1); value != item {
+            return fmt.Sprintf("[%s for %s in %s]", value, item, expression)
+        }
+    case reflect.Map:
+        if value := pythonValue(t.Elem(), item, depth + 1); value != item {
+            return fmt.Sprintf("{k%d: %s for k%d, %s in %s.items()}", depth, value, depth, item, expression)
+        }
+    }
+    return expression
+}
+
+const pythonHeader = `"""
+Reads the outputs of SymnaTEC plot: the stream of a server (--serve), the shared memory (--shm) and the control
+output (--control). Generated by "plot schema --python" for version %d of the stream schema, do not edit.
+
+    import plot_client
+    for message in plot_client.subscribe("http://raspberrypi:8080"):
+        print(message)
+"""
+
+import json
+import mmap
+import time
+import socket
+import struct
+import urllib.request
+from dataclasses import dataclass
+from typing import Dict, List, Optional
+
+SCHEMA_VERSION = %d
+SHARED_MAGIC = b"%s"
+SHARED_VERSION = %d
+CONTROL_SIZE = %d
+CONTROL_CLASS_SIZE = %d
+`
+
+const pythonFunctions = `
+
+def parse(line):
+    """Decodes one line of a stream into its message, or a dict for messages that this client doesn't know"""
+    data = json.loads(line)
+    if data.get("schema") != SCHEMA_VERSION:
+        raise ValueError("unsupported schema %r, expected %d" % (data.get("schema"), SCHEMA_VERSION))
+    message = MESSAGES.get(data.get("type"))
+    return message.from_dict(data) if message else data
+
+
+def subscribe(url, token=None, context=None):
+    """Yields the messages that a server streams, starting with the SessionMessage. The token is the one of --token,
+    and the context an ssl.SSLContext for servers with a certificate that the system doesn't trust."""
+    request = urllib.request.Request(url.rstrip("/") + "/stream")
+    if token:
+        request.add_header("Authorization", "Bearer " + token)
+    with urllib.request.urlopen(request, context=context) as response:
+        for line in response:
+            if line.strip():
+                yield parse(line)
+
+
+def samples(url, token=None, context=None):
+    """Yields only the samples that a server streams"""
+    for message in subscribe(url, token, context):
+        if isinstance(message, SampleMessage):
+            yield message
+
+
+def read_shared(name, poll=0.001):
+    """Yields (time, value, processed, aux) for every sample that plot --shm=<name> shares on the same device, from
+    the next one on, until the session ends. Samples that were overwritten before they were read are skipped."""
+    with open("/dev/shm/" + name, "rb") as file, mmap.mmap(file.fileno(), 0, access=mmap.ACCESS_READ) as memory:
+        magic, version, header, size, slots, aux = struct.unpack_from("<8sIIIII", memory, 0)
+        if magic != SHARED_MAGIC or version != SHARED_VERSION:
+            raise ValueError("/dev/shm/%s is no ring buffer of version %d" % (name, SHARED_VERSION))
+        slot = struct.Struct("<Qddd%dd" % aux)
+        following = struct.unpack_from("<Q", memory, 40)[0]
+        while True:
+            ended = struct.unpack_from("<I", memory, 28)[0] & 1
+            written = struct.unpack_from("<Q", memory, 40)[0]
+            following = max(following, written - slots)
+            while following < written:
+                offset = header + (following % slots) * size
+                values = slot.unpack_from(memory, offset)
+                if values[0] == following + 1 == struct.unpack_from("<Q", memory, offset)[0]:
+                    yield values[1], values[2], values[3], values[4:]
+                following += 1
+            if ended:
+                return
+            time.sleep(poll)
+
+
+def receive_control(port, host="0.0.0.0"):
+    """Yields (time, envelope, class, confidence) for the messages of plot --control=udp://<this host>:<port>.
+    Messages that arrive after a newer one of the same session are dropped."""
+    connection = socket.socket(socket.AF_INET, socket.SOCK_DGRAM)
+    connection.bind((host, port))
+    last = 0
+    with connection:
+        while True:
+            data = connection.recv(CONTROL_SIZE)
+            sequence, timestamp, envelope, confidence, name = struct.unpack("<Qddd%ds" % CONTROL_CLASS_SIZE, data)
+            # A new session starts counting again
+            if sequence > last or sequence == 1:
+                last = sequence
+                yield timestamp, envelope, name.rstrip(b"\0").decode(), confidence
+`
@@ -0,0 +1,283 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "io"
+    "fmt"
+    "flag"
+    "math"
+    "sort"
+    "bufio"
+    "strings"
+    "strconv"
+)
+
+func init() {
+    Commands["quality"] = qualityCommand
+}
+
+/*
+ Recordings whose score is below this are flagged as low quality
+ */
+const lowQuality = 60
+
+/*
+ How good a recording is for analysis. It is computed when the recording is finished, so unusable sessions are
+ noticed right away instead of weeks later.
+ */
+type Quality struct {
+
+    /*
+     From 0 (unusable) to 100. Every problem below lowers the score.
+     */
+    Score float64
+
+    /*
+     The RMS voltage of the quietest parts of the signal, which is the noise of the sensor and the electrodes
+     */
+    NoiseFloor float64
+
+    /*
+     The percentage of samples at the limits of the ADC
+     */
+    Clipping float64
+
+    /*
+     The percentage of samples that are missing from the recording, for example because of outages of the sensor
+     */
+    Dropped float64
+
+    /*
+     The RMS voltage of mains hum (50 or 60 Hz). Zero if the sample rate is too low to see it.
+     */
+    Hum float64
+}
+
+/*
+ Whether the recording should be looked at before it is used
+ */
+func (q Quality) Low() bool {
+    return q.Score < lowQuality
+}
+
+func (q Quality) String() string {
+    return fmt.Sprintf("score %.0f, noise floor %s, %.2f%% clipped, %.2f%% dropped, hum %s", q.Score,
+        AutoUnit.Format(q.NoiseFloor), q.Clipping, q.Dropped, AutoUnit.Format(q.Hum))
+}
+
+/*
+ Collects what is needed for the quality while a recording is written
+ */
+type QualityMeter struct {
+    interval float64
+    clip float64
+    gaps bool
+
+    count int
+    clipped int
+    first float64
+    last float64
+    sum float64
+    squares float64
+
+    // The RMS of every window of 100ms, and the window that is being filled
+    windows []float64
+    window []float64
+
+    // The amplitude of the mains frequencies in every block of one second, and the block that is being filled
+    hum [2][]float64
+    block []float64
+}
+
+/*
+ The frequencies of mains hum, in Europe and in America
+ */
+var mainsFrequencies = [2]float64{50, 60}
+
+/*
+ Creates a meter for a signal with the given interval. Samples above the clipping voltage are counted as clipped,
+ zero disables this. If the recording has gaps on purpose (like in triggered mode), missing samples aren't counted.
+ */
+func NewQualityMeter(interval float64, clip float64, gaps bool) *QualityMeter {
+    return &QualityMeter{interval: interval, clip: clip, gaps: gaps, first: math.NaN()}
+}
+
+func (q *QualityMeter) Add(time float64, value float64) {
+    if math.IsNaN(q.first) {
+        q.first = time
+    }
+    q.last = time
+    q.count++
+    q.sum += value
+    q.squares += value * value
+    if q.clip > 0 && math.Abs(value) >= q.clip {
+        q.clipped++
+    }
+
+    q.window = append(q.window, value)
+    if float64(len(q.window)) * q.interval >= 0.1 {
+        q.windows = append(q.windows, Calculate(removeMean(q.window)).RMS)
+        q.window = q.window[:0]
+    }
+    q.block = append(q.block, value)
+    if float64(len(q.block)) * q.interval >= 1 {
+        for i, frequency := range mainsFrequencies {
+            if 1 / q.interval > 2.5 * frequency {
+                q.hum[i] = append(q.hum[i], goertzel(removeMean(q.block), frequency * q.interval))
+            }
+        }
+        q.block = q.block[:0]
+    }
+}
+
+/*
+ Computes the quality of everything that was added
+ */
+func (q *QualityMeter) Result() Quality {
+    result := Quality{Score: 100}
+    if q.count == 0 {
+        result.Score = 0
+        return result
+    }
+
+    // The noise floor is the 10th percentile of the RMS of short windows
+    if len(q.windows) > 0 {
+        sorted := append([]float64{}, q.windows...)
+        sort.Float64s(sorted)
+        result.NoiseFloor = percentile(sorted, 10)
+    }
+    result.Clipping = float64(q.clipped) / float64(q.count) * 100
+    expected := math.Round((q.last - q.first) / q.interval) + 1
+    if !q.gaps && expected > float64(q.count) {
+        result.Dropped = (expected - float64(q.count)) / expected * 100
+    }
+    for _, amplitudes := range q.hum {
+        if len(amplitudes) > 0 {
+            result.Hum = math.Max(result.Hum, Calculate(amplitudes).Mean)
+        }
+    }
+
+    // Every percent of clipped or missing samples costs points, as does hum that makes up a large part of the
+    // signal, and a signal that hardly rises above the noise
+    mean := q.sum / float64(q.count)
+    variance := q.squares / float64(q.count) - mean * mean
+    result.Score -= result.Clipping * 10
+    result.Score -= result.Dropped * 5
+    if variance > 0 {
+        result.Score -= math.Min(1, result.Hum * result.Hum / variance) * 50
+        if result.NoiseFloor > 0 && math.Sqrt(variance) / result.NoiseFloor < 2 {
+            result.Score -= 20
+        }
+    }
+    result.Score = math.Max(0, math.Min(100, result.Score))
+    return result
+}
+
+/*
+ Returns the values without their mean
+ */
+func removeMean(values []float64) []float64 {
+    mean := Calculate(values).Mean
+    result := make([]float64, len(values))
+    for i, v := range values {
+        result[i] = v - mean
+    }
+    return result
+}
+
+/*
+ Returns the RMS amplitude of one frequency (in cycles per sample) in the values, using the algorithm of Goertzel
+ */
+func goertzel(values []float64, frequency float64) float64 {
+    coefficient := 2 * math.Cos(2 * math.Pi * frequency)
+    s1, s2 := float64(0), float64(0)
+    for _, v := range values {
+        s1, s2 = v + coefficient * s1 - s2, s1
+    }
+    power := s1 * s1 + s2 * s2 - coefficient * s1 * s2
+    return math.Sqrt(math.Max(0, power)) * 2 / float64(len(values)) / math.Sqrt2
+}
+
+/*
+ The voltage at which the source of a recording clips, or zero if it is unknown
+ */
+func clipVoltage(meta Metadata) float64 {
+    if meta.Source == "" {
+        // The full scale of the MCP3424, behind the voltage divider of the ADCPi
+        return 2.048 * adcpiDivider * 0.999
+    }
+    return 0
+}
+
+/*
+ Computes the quality of existing recordings and stores it in their metadata
+ Example:
+    $ plot quality data.csv
+ */
+func qualityCommand(args []string) {
+    flags := flag.NewFlagSet("quality", flag.ExitOnError)
+    flags.StringVar(&(Settings.KeyFile), "key", "", "The key file for encrypted recordings")
+    jobs := flags.Int("jobs", defaultJobs, "How many recordings are measured at the same time")
+    flags.Parse(args)
+    if flags.NArg() == 0 {
+        fail("Usage: plot quality [--key=file] [--jobs=n] <file>...")
+    }
+    files := flags.Args()
+    err := ProcessFiles(files, *jobs, "Measured", func(i int) (string, error) {
+        quality, err := MeasureQuality(files[i])
+        if err != nil {
+            return "", err
+        }
+        return fmt.Sprintf("%s: %s\n", files[i], quality), nil
+    })
+    if err != nil {
+        fail("%v", err)
+    }
+}
+
+/*
+ Computes the quality of a recording from its raw signal and stores it in the metadata
+ */
+func MeasureQuality(file string) (Quality, error) {
+    meta, err := LoadMetadata(file)
+    if err != nil {
+        return Quality{}, err
+    }
+    csv, err := OpenRecording(file)
+    if err != nil {
+        return Quality{}, err
+    }
+    defer csv.Close()
+
+    reader := bufio.NewReader(csv)
+    line, err := reader.ReadString('\n')
+    if err != nil {
+        return Quality{}, err
+    }
+    _, unit := ParseColumn(strings.Split(strings.TrimSpace(line), ";")[1])
+    meter := NewQualityMeter(meta.Interval, clipVoltage(meta), meta.Triggered())
+    for err != io.EOF {
+        line, err = reader.ReadString('\n')
+        if err != nil && err != io.EOF {
+            return Quality{}, err
+        }
+        columns := strings.Split(strings.TrimSpace(line), ";")
+        if len(columns) < 2 {
+            continue
+        }
+        t, e1 := strconv.ParseFloat(columns[0], 64)
+        v, e2 := strconv.ParseFloat(columns[1], 64)
+        if e1 != nil || e2 != nil {
+            return Quality{}, fmt.Errorf("invalid line %q", line)
+        }
+        meter.Add(t, v / unit.Scale)
+    }
+    quality := meter.Result()
+    meta.Quality = &quality
+    return quality, SaveMetadata(file, meta)
+}
@@ -0,0 +1,153 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "strconv"
+    "net/url"
+)
+
+/*
+ The functions that combine the samples of one period of a rate limited sink into one value
+ */
+var Aggregates = map[string]func(values []float64) float64{
+    "mean": func(values []float64) float64 { return Calculate(values).Mean },
+    "rms": func(values []float64) float64 { return Calculate(values).RMS },
+    "min": func(values []float64) float64 { return Calculate(values).Min },
+    "max": func(values []float64) float64 { return Calculate(values).Max },
+    "peak": func(values []float64) float64 { return Calculate(values).Peak() },
+    "last": func(values []float64) float64 { return values[len(values) - 1] },
+}
+
+func AggregateNames() []string {
+    names := []string{}
+    for name := range Aggregates {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+/*
+ Sends a sink at most one sample per period, which combines all samples of that period. Every sink can have its own
+ rate, given with the query parameters rate (in Hz) and aggregate (mean by default) of its URL:
+    --sink=mqtt://broker/emg?rate=5&aggregate=rms --sink=file:full.jsonl
+ Events and segments are passed through as they are. An aggregated sample has the time of the last sample of its
+ period, and the session message announces the period as interval.
+ */
+type RateSink struct {
+    Sink
+    period float64
+    aggregate func(values []float64) float64
+    window []SampleMessage
+}
+
+/*
+ Reads the period and the aggregate from the query of a sink URL. The parameters are removed from the query, so the
+ sink itself doesn't see them. A period of zero means that the sink gets every sample.
+ */
+func parseRate(query url.Values) (float64, func(values []float64) float64, error) {
+    rate := query.Get("rate")
+    name := query.Get("aggregate")
+    query.Del("rate")
+    query.Del("aggregate")
+    if rate == "" {
+        if name != "" {
+            return 0, nil, fmt.Errorf("aggregate needs a rate")
+        }
+        return 0, nil, nil
+    }
+    hz, err := strconv.ParseFloat(rate, 64)
+    if err != nil || hz <= 0 {
+        return 0, nil, fmt.Errorf("invalid rate %s, expected a frequency in Hz", rate)
+    }
+    if name == "" {
+        name = "mean"
+    }
+    aggregate, ok := Aggregates[name]
+    if !ok {
+        return 0, nil, fmt.Errorf("unknown aggregate %s, expected one of %s", name,
+            strings.Join(AggregateNames(), ", "))
+    }
+    return 1 / hz, aggregate, nil
+}
+
+func NewRateSink(sink Sink, period float64, aggregate func(values []float64) float64) *RateSink {
+    return &RateSink{Sink: sink, period: period, aggregate: aggregate}
+}
+
+func (s *RateSink) Write(message interface{}) error {
+    switch m := message.(type) {
+    case SessionMessage:
+        if m.Interval < s.period {
+            m.Interval = s.period
+        }
+        return s.Sink.Write(m)
+    case SampleMessage:
+        var err error
+        if len(s.window) > 0 && m.Time - s.window[0].Time >= s.period {
+            err = s.flush()
+        }
+        s.window = append(s.window, m)
+        return err
+    }
+    return s.Sink.Write(message)
+}
+
+func (s *RateSink) Close() error {
+    err := s.flush()
+    if err != nil {
+        s.Sink.Close()
+        return err
+    }
+    return s.Sink.Close()
+}
+
+/*
+ Combines the samples of the current period and writes them
+ */
+func (s *RateSink) flush() error {
+    if len(s.window) == 0 {
+        return nil
+    }
+    last := s.window[len(s.window) - 1]
+    values := []float64{}
+    stages := map[string][]float64{}
+    aux := map[string][]float64{}
+    for _, sample := range s.window {
+        values = append(values, sample.Value)
+        for name, value := range sample.Stages {
+            stages[name] = append(stages[name], value)
+        }
+        // Sensors without a value are missing from the sample and don't count
+        for name, value := range sample.Aux {
+            aux[name] = append(aux[name], value)
+        }
+        if sample.HeartRate > 0 {
+            last.HeartRate = sample.HeartRate
+        }
+    }
+    last.Value = s.aggregate(values)
+    last.Stages = s.combine(stages)
+    last.Aux = s.combine(aux)
+    s.window = s.window[:0]
+    return s.Sink.Write(last)
+}
+
+func (s *RateSink) combine(series map[string][]float64) map[string]float64 {
+    if len(series) == 0 {
+        return nil
+    }
+    result := map[string]float64{}
+    for name, values := range series {
+        result[name] = s.aggregate(values)
+    }
+    return result
+}
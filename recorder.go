@@ -0,0 +1,473 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "io"
+    "fmt"
+    "math"
+    "errors"
+    "sync"
+    "strings"
+    "strconv"
+    "hash"
+    "hash/crc32"
+)
+
+/*
+ The amount of samples that are covered by one checksum
+ */
+const ChecksumBlock = 1000
+
+/*
+ Writes measurements into a CSV file. While writing, the file is split into blocks of ChecksumBlock samples, and a
+ CRC32 checksum of every finished block is appended to a second file. This way damaged or truncated recordings can be
+ detected later using "plot verify", even if the application never got the chance to close the file.
+ If the recording is encrypted, every block is sealed as one encrypted chunk, and its checksum is calculated from the
+ chunk as it is stored, so the checksums reveal nothing about the signal. Closing the recording seals the final chunk.
+ Things that happened during the recording (like outages of the acquisition) are written into a third file with the
+ extension .events appended, which is encrypted as well.
+ Every finished block is synced to the storage before its checksum, and noted in a journal (extension .journal), which
+ tells how much of the recording is safely stored, and whether it was closed. After a crash or a power loss, "plot
+ recover" uses it to repair the recording.
+ Every finished block is also added to the index of the recording (extension .index, see IndexBlock), for seeking,
+ and every second and minute to its rollups (see Rollup), for views of long ranges.
+ */
+type Recorder struct {
+    file io.WriteCloser
+    stored *os.File
+    sums *os.File
+    journal *os.File
+    index *os.File
+    rollups []*RollupWriter
+    encrypted *EncryptedWriter
+
+    // Events can be written from any goroutine. Every event of an encrypted recording is sealed as one chunk.
+    events io.WriteCloser
+    sealedEvents *EncryptedWriter
+    eventsLock sync.Mutex
+
+    // The checksum and the position of the block that is currently being written. The checksum of an encrypted
+    // recording covers what is stored, see checksummedFile.
+    crc hash.Hash32
+    offset int64
+    length int64
+    samples int
+
+    // How many rows were written in total, and the time of the last one
+    rows int
+    last float64
+
+    // The block as it is added to the index, and where the next one starts in the stored file
+    block IndexBlock
+    storedOffset int64
+}
+
+/*
+ Creates a new recording, and writes the CSV header and the metadata. The columns are the names of the values that
+ follow the time in every row, including their unit.
+ */
+func NewRecorder(file string, meta Metadata, columns []string) (*Recorder, error) {
+    var secret *Secret
+    if meta.Encrypted {
+        s, err := LoadSecret()
+        if err != nil {
+            return nil, err
+        }
+        if s == nil {
+            return nil, errors.New("encryption requires --key or " + passphraseVariable)
+        }
+        secret = s
+    }
+    csv, err := os.Create(file)
+    if err != nil {
+        return nil, err
+    }
+    sums, err := os.Create(file + ".sum")
+    if err != nil {
+        csv.Close()
+        return nil, err
+    }
+    events, err := os.Create(EventsFile(file))
+    if err != nil {
+        csv.Close()
+        sums.Close()
+        return nil, err
+    }
+    journal, err := os.Create(JournalFile(file))
+    if err != nil {
+        csv.Close()
+        sums.Close()
+        events.Close()
+        return nil, err
+    }
+    journal.WriteString(journalHeader)
+    index, err := os.Create(IndexFile(file))
+    if err != nil {
+        csv.Close()
+        sums.Close()
+        events.Close()
+        journal.Close()
+        return nil, err
+    }
+    index.WriteString(indexHeader)
+
+    // The rollups would reveal the signal of an encrypted recording
+    rollups := []*RollupWriter{}
+    if secret == nil {
+        rollups, err = NewRollupWriters(file)
+    }
+    if err != nil {
+        csv.Close()
+        sums.Close()
+        events.Close()
+        journal.Close()
+        index.Close()
+        return nil, err
+    }
+    meta.Checksum = "crc32"
+    meta.ChecksumBlock = ChecksumBlock
+    err = SaveMetadata(file, meta)
+    if err != nil {
+        csv.Close()
+        sums.Close()
+        events.Close()
+        journal.Close()
+        index.Close()
+        CloseRollups(rollups)
+        return nil, err
+    }
+    recorder := &Recorder{file: csv, stored: csv, sums: sums, journal: journal, index: index, rollups: rollups,
+        events: events, crc: crc32.NewIEEE()}
+    if secret != nil {
+        recorder.encrypted, err = NewEncryptedWriter(checksummedFile{csv, recorder.crc}, secret)
+        if err != nil {
+            csv.Close()
+            sums.Close()
+            events.Close()
+            journal.Close()
+            index.Close()
+            CloseRollups(rollups)
+            return nil, err
+        }
+        recorder.file = recorder.encrypted
+        recorder.storedOffset = int64(len(encryptionMagic) + saltSize)
+        recorder.crc.Reset()
+        recorder.sealedEvents, err = NewEncryptedWriter(events, secret)
+        if err != nil {
+            recorder.encrypted.Close()
+            sums.Close()
+            events.Close()
+            journal.Close()
+            index.Close()
+            CloseRollups(rollups)
+            return nil, err
+        }
+        recorder.events = recorder.sealedEvents
+    }
+    err = recorder.writeEvent(eventsHeader)
+    if err != nil {
+        recorder.file.Close()
+        sums.Close()
+        recorder.events.Close()
+        journal.Close()
+        index.Close()
+        CloseRollups(rollups)
+        return nil, err
+    }
+    recorder.write("Time [s];" + strings.Join(columns, ";"))
+    return recorder, nil
+}
+
+/*
+ Appends a measurement to the recording
+ */
+func (r *Recorder) Write(time float64, values ...float64) error {
+    fields := make([]string, len(values))
+    for i, value := range values {
+        fields[i] = fmt.Sprintf("%f", value)
+    }
+    first := math.NaN()
+    if len(values) > 0 {
+        first = values[0]
+    }
+    return r.writeRow(time, first, fields)
+}
+
+/*
+ Appends a row to the recording whose columns are not all numbers
+ */
+func (r *Recorder) WriteFields(time float64, fields ...string) error {
+    return r.writeRow(time, math.NaN(), fields)
+}
+
+/*
+ Appends a row, and adds it to the block of the index and the rollups. first is the value of the first column, or NaN
+ if it isn't a number.
+ */
+func (r *Recorder) writeRow(time float64, first float64, fields []string) error {
+    if r.samples == 0 {
+        r.block = IndexBlock{Offset: r.offset, Stored: r.storedOffset, Row: r.rows, Start: time, Min: math.NaN(),
+            Max: math.NaN()}
+    }
+    r.block.End = time
+    if !math.IsNaN(first) {
+        // The range of the signal would reveal an encrypted recording, so its index only tells where the blocks are
+        if r.encrypted == nil {
+            r.block.Add(first)
+        }
+        for _, rollup := range r.rollups {
+            err := rollup.Add(time, first)
+            if err != nil {
+                return err
+            }
+        }
+    }
+    row := fmt.Sprintf("\n%f", time)
+    for _, field := range fields {
+        row += ";" + field
+    }
+    err := r.write(row)
+    if err != nil {
+        return err
+    }
+    r.rows++
+    r.last = time
+    r.samples++
+    if r.samples == ChecksumBlock {
+        return r.flush(false)
+    }
+    return nil
+}
+
+/*
+ Notes that something happened at the given time of the recording. This can be called from any goroutine.
+ */
+func (r *Recorder) Event(time float64, sample int, event string) error {
+    r.eventsLock.Lock()
+    defer r.eventsLock.Unlock()
+    return r.writeEvent(RecordedEvent{time, sample, event}.String())
+}
+
+func (r *Recorder) writeEvent(s string) error {
+    _, err := io.WriteString(r.events, s)
+    if err == nil && r.sealedEvents != nil {
+        err = r.sealedEvents.Flush()
+    }
+    return err
+}
+
+/*
+ Writes the remaining checksum and closes the recording. The events are closed first, so a journal that says the
+ recording was closed means that they are complete as well.
+ */
+func (r *Recorder) Close() error {
+    r.eventsLock.Lock()
+    err := r.events.Close()
+    r.eventsLock.Unlock()
+    flushed := r.flush(true)
+    if err == nil {
+        err = flushed
+    }
+    if err == nil {
+        _, err = r.journal.WriteString(journalClosed + "\n")
+    }
+    r.journal.Close()
+    r.sums.Close()
+    r.index.Close()
+    closed := CloseRollups(r.rollups)
+    if err == nil {
+        err = closed
+    }
+    if err != nil {
+        r.file.Close()
+        return err
+    }
+    return r.file.Close()
+}
+
+func (r *Recorder) write(s string) error {
+    n, err := io.WriteString(r.file, s)
+    if r.encrypted == nil {
+        r.crc.Write([]byte(s[:n]))
+    }
+    r.length += int64(n)
+    return err
+}
+
+/*
+ Passes everything that is stored in an encrypted recording through the checksum of the current block
+ */
+type checksummedFile struct {
+    *os.File
+    crc hash.Hash32
+}
+
+func (f checksummedFile) Write(p []byte) (int, error) {
+    n, err := f.File.Write(p)
+    f.crc.Write(p[:n])
+    return n, err
+}
+
+/*
+ Finishes the current block by storing its checksum. The block is on the storage before the checksum and the journal
+ mention it, so they never claim more than survives a power loss. The last block of an encrypted recording is its
+ final chunk, which is stored even if it is empty.
+ */
+func (r *Recorder) flush(last bool) error {
+    if r.length == 0 && (r.encrypted == nil || !last) {
+        return nil
+    }
+    if r.encrypted != nil {
+        seal := r.encrypted.Flush
+        if last {
+            seal = r.encrypted.Finish
+        }
+        err := seal()
+        if err != nil {
+            return err
+        }
+    }
+    err := r.stored.Sync()
+    if err != nil {
+        return err
+    }
+    size, err := r.stored.Seek(0, io.SeekCurrent)
+    if err != nil {
+        return err
+    }
+    sum := fmt.Sprintf("%d;%d;%08x\n", r.offset, r.length, r.crc.Sum32())
+    if r.encrypted != nil {
+        sum = fmt.Sprintf("%d;%d;%08x\n", r.storedOffset, size - r.storedOffset, r.crc.Sum32())
+    }
+    _, err = r.sums.WriteString(sum)
+    if err != nil {
+        return err
+    }
+    _, err = r.journal.WriteString(fmt.Sprintf("%d;%d;%d;%d;%f\n", r.offset, r.length, size, r.rows, r.last))
+    if err != nil {
+        return err
+    }
+    if r.samples > 0 {
+        _, err = r.index.WriteString(r.block.String())
+        if err != nil {
+            return err
+        }
+    }
+    r.storedOffset = size
+    r.offset += r.length
+    r.length = 0
+    r.samples = 0
+    r.crc.Reset()
+    err = r.sums.Sync()
+    if err != nil {
+        return err
+    }
+    return r.journal.Sync()
+}
+
+/*
+ Returns the path of the file with the events that belong to a recording
+ */
+func EventsFile(file string) string {
+    return file + ".events"
+}
+
+/*
+ Returns the path of the journal of a recording
+ */
+func JournalFile(file string) string {
+    return file + ".journal"
+}
+
+/*
+ The journal starts with a header, and every finished block adds a line with its offset and length in the
+ unencrypted recording, the size of the stored file after it, how many rows were written up to then and the time of
+ the last one. A recording that was closed ends with journalClosed, one that was repaired with journalRecovered.
+ */
+const journalHeader = "Offset;Length;Stored;Rows;Time [s]\n"
+const journalClosed = "closed"
+const journalRecovered = "recovered"
+
+/*
+ Something that happened during a recording, as stored in its events: the time and the number of the sample at which
+ it happened, counted from the start of the acquisition, and what happened. The number is -1 if it isn't known, like
+ in recordings from before it was stored.
+ */
+type RecordedEvent struct {
+    Time float64
+    Sample int
+    Event string
+}
+
+/*
+ The events of a recording start with a header, and every event adds a line. Older recordings don't have the column
+ with the sample.
+ */
+const eventsHeader = "Time;Sample;Event"
+
+/*
+ Formats an event as a line of the events, which starts with a newline. Unknown samples are left empty.
+ */
+func (e RecordedEvent) String() string {
+    if e.Sample < 0 {
+        return fmt.Sprintf("\n%f;;%s", e.Time, e.Event)
+    }
+    return fmt.Sprintf("\n%f;%d;%s", e.Time, e.Sample, e.Event)
+}
+
+/*
+ Returns the sample of an event that is played back, or the next one if the recording doesn't know it
+ */
+func eventSample(event RecordedEvent, next int) int {
+    if event.Sample < 0 {
+        return next
+    }
+    return event.Sample
+}
+
+/*
+ Reads the events of a recording, and decrypts them if the recording is encrypted. Recordings from before events were
+ stored have none.
+ */
+func ReadEvents(file string) ([]RecordedEvent, error) {
+    in, err := OpenRecording(EventsFile(file))
+    if os.IsNotExist(err) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    data, err := io.ReadAll(in)
+    in.Close()
+    if err != nil {
+        return nil, err
+    }
+    events := []RecordedEvent{}
+    lines := strings.Split(string(data), "\n")
+    columns := 2
+    if lines[0] == eventsHeader {
+        columns = 3
+    }
+    for _, line := range lines[1:] {
+        parts := strings.SplitN(line, ";", columns)
+        if len(parts) < columns {
+            continue
+        }
+        event := RecordedEvent{Sample: -1, Event: parts[columns - 1]}
+        event.Time, err = strconv.ParseFloat(parts[0], 64)
+        if err == nil && columns == 3 && parts[1] != "" {
+            event.Sample, err = strconv.Atoi(parts[1])
+        }
+        if err != nil {
+            return nil, fmt.Errorf("invalid event %q", line)
+        }
+        events = append(events, event)
+    }
+    return events, nil
+}
@@ -0,0 +1,396 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "io"
+    "fmt"
+    "bufio"
+    "strings"
+    "strconv"
+    "path/filepath"
+)
+
+/*
+ The files that a session is recorded into. Voltages are always recorded in volts. The raw signal and the auxiliary
+ sensors are always recorded. The output of the processing stages is
+ recorded depending on the layout:
+    raw      Only the raw signal is recorded
+    columns  Every stage is an additional column in the recording
+    files    Every stage is recorded into its own file, named after the stage (data.csv -> data.rms.csv)
+ */
+type Recording struct {
+    recorders []*Recorder
+    layout string
+    file string
+
+    // Measures the quality of the raw signal, which is stored in the metadata once the recording is finished
+    quality *QualityMeter
+
+    // The intervals between heartbeats in ECG mode
+    rr *Recorder
+
+    // The measures of every segment of the session, and whether they include the co-contraction index
+    segments *Recorder
+    cocontraction bool
+    motion bool
+}
+
+/*
+ Returns the file of the raw signal
+ */
+func (r *Recording) File() string {
+    return r.file
+}
+
+/*
+ Creates the files for a new session
+ */
+func NewRecording(file string, meta Metadata, chain *FilterChain, layout string) (*Recording, error) {
+    recording := &Recording{layout: layout, file: file,
+        quality: NewQualityMeter(meta.Interval, clipVoltage(meta), meta.Triggered())}
+    volts := voltageUnits[0]
+    columns := []string{volts.Column("Voltage")}
+    switch layout {
+    case "raw", "files":
+    case "columns":
+        for _, name := range chain.Names {
+            columns = append(columns, volts.Column(name))
+        }
+    default:
+        return nil, fmt.Errorf("unknown layout %s for recording the processing stages", layout)
+    }
+    for _, aux := range meta.Aux {
+        columns = append(columns, aux.Column())
+    }
+    recorder, err := NewRecorder(file, meta, columns)
+    if err != nil {
+        return nil, err
+    }
+    recording.recorders = append(recording.recorders, recorder)
+
+    if layout == "files" {
+        extension := filepath.Ext(file)
+        for _, name := range chain.Names {
+            meta.Stage = name
+            meta.Aux = nil
+            recorder, err := NewRecorder(strings.TrimSuffix(file, extension) + "." + name + extension, meta,
+                []string{volts.Column("Voltage")})
+            if err != nil {
+                recording.Close()
+                return nil, err
+            }
+            recording.recorders = append(recording.recorders, recorder)
+        }
+    }
+
+    // The segments are exported into a separate file (data.csv -> data.segments.csv)
+    meta.Stage = "segments"
+    meta.Aux = nil
+    columns = []string{"End [s]", "Segment", "iEMG [V s]"}
+    if meta.CoContraction != "" {
+        columns = append(columns, "Co-contraction [%]")
+        recording.cocontraction = true
+    }
+    if meta.Motion > 0 {
+        columns = append(columns, "Motion [s]")
+        recording.motion = true
+    }
+    recording.segments, err = NewRecorder(strings.TrimSuffix(file, filepath.Ext(file)) + ".segments" +
+        filepath.Ext(file), meta, columns)
+    if err != nil {
+        recording.Close()
+        return nil, err
+    }
+
+    // In ECG mode, the RR intervals are exported into a separate file (data.csv -> data.rr.csv)
+    if meta.Mode == "ecg" {
+        meta.Stage = "rr"
+        meta.Aux = nil
+        recording.rr, err = NewRecorder(strings.TrimSuffix(file, filepath.Ext(file)) + ".rr" + filepath.Ext(file),
+            meta, []string{"RR [s]", "Heart rate [bpm]"})
+        if err != nil {
+            recording.Close()
+            return nil, err
+        }
+    }
+    return recording, nil
+}
+
+/*
+ Writes a sample into the files of the recording
+ */
+func (r *Recording) Write(sample Sample) error {
+    if r.rr != nil && sample.Beat != nil && sample.Beat.RR > 0 {
+        err := r.rr.Write(sample.Beat.Time, sample.Beat.RR, sample.Beat.HeartRate())
+        if err != nil {
+            return err
+        }
+    }
+    r.quality.Add(sample.Time, sample.Value)
+    values := []float64{sample.Value}
+    if r.layout == "columns" {
+        values = append(values, sample.Stages...)
+    }
+    err := r.recorders[0].Write(sample.Time, append(values, sample.Aux...)...)
+    if err != nil {
+        return err
+    }
+    if r.layout != "files" {
+        return nil
+    }
+    for i, recorder := range r.recorders[1:] {
+        err = recorder.Write(sample.Time, sample.Stages[i])
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+/*
+ Writes the measures of a finished segment into the export of the segments. Names can't contain semicolons.
+ */
+func (r *Recording) Segment(segment Segment) error {
+    fields := []string{fmt.Sprintf("%f", segment.End), strings.Replace(segment.Name, ";", ",", -1),
+        fmt.Sprintf("%f", segment.IEMG)}
+    if r.cocontraction {
+        fields = append(fields, fmt.Sprintf("%f", segment.CoContraction))
+    }
+    if r.motion {
+        fields = append(fields, fmt.Sprintf("%f", segment.Motion))
+    }
+    return r.segments.WriteFields(segment.Start, fields...)
+}
+
+/*
+ Notes that something happened at the given time and sample. Events are stored next to the recording of the raw
+ signal.
+ */
+func (r *Recording) Event(time float64, sample int, event string) error {
+    return r.recorders[0].Event(time, sample, event)
+}
+
+func (r *Recording) Close() error {
+    var result error
+    recorders := r.recorders
+    if r.rr != nil {
+        recorders = append(recorders, r.rr)
+    }
+    if r.segments != nil {
+        recorders = append(recorders, r.segments)
+    }
+    for _, recorder := range recorders {
+        err := recorder.Close()
+        if err != nil && result == nil {
+            result = err
+        }
+    }
+    if result != nil {
+        return result
+    }
+
+    // Store the quality of the session
+    meta, err := LoadMetadata(r.file)
+    if err != nil {
+        return err
+    }
+    quality := r.quality.Result()
+    meta.Quality = &quality
+    return SaveMetadata(r.file, meta)
+}
+
+/*
+ Reads the times and the voltages of the raw signal of a recording
+ */
+func ReadSignal(file string) ([]float64, []float64, error) {
+    header, columns, err := ReadColumns(file)
+    if err != nil {
+        return nil, nil, err
+    }
+    if len(header) < 2 {
+        return nil, nil, fmt.Errorf("%s has no signal", file)
+    }
+    _, unit := ParseColumn(header[1])
+    values := make([]float64, len(columns[1]))
+    for i, v := range columns[1] {
+        values[i] = v / unit.Scale
+    }
+    return columns[0], values, nil
+}
+
+/*
+ Reads every column of a recording, as they are stored. The first column is the time. This keeps the whole recording
+ in memory; analyses that can work sample by sample should use a RecordingScanner instead.
+ */
+func ReadColumns(file string) ([]string, [][]float64, error) {
+    scanner, err := ScanRecording(file)
+    if err != nil {
+        return nil, nil, err
+    }
+    defer scanner.Close()
+    columns := make([][]float64, len(scanner.Header))
+    for scanner.Scan() {
+        for i, value := range scanner.Values() {
+            columns[i] = append(columns[i], value)
+        }
+    }
+    return scanner.Header, columns, scanner.Err()
+}
+
+/*
+ Reads a recording one row after the other, so recordings of any size can be analyzed with little memory:
+    scanner, err := ScanRecording(file)
+    ...
+    defer scanner.Close()
+    for scanner.Scan() {
+        values := scanner.Values()
+    }
+    err = scanner.Err()
+ Incomplete rows, like the last one of a recording that was cut off, are skipped. At the end of a recording that is
+ still being written, Scan can be called again once more rows arrived.
+ */
+type RecordingScanner struct {
+    Header []string
+    name string
+    file io.ReadCloser
+    reader *bufio.Reader
+    values []float64
+    err error
+
+    // Where the current row starts in the unencrypted recording, where the next one starts, and the number of the
+    // current row. After a seek, the row that was found is held back for the next call of Scan.
+    start int64
+    offset int64
+    row int
+    held bool
+}
+
+/*
+ Opens a recording and reads its header
+ */
+func ScanRecording(file string) (*RecordingScanner, error) {
+    csv, err := OpenRecording(file)
+    if err != nil {
+        return nil, err
+    }
+    reader := bufio.NewReaderSize(csv, 1 << 16)
+    line, err := reader.ReadString('\n')
+    if err != nil {
+        csv.Close()
+        return nil, err
+    }
+    header := strings.Split(strings.TrimSpace(line), ";")
+    return &RecordingScanner{Header: header, name: file, file: csv, reader: reader,
+        values: make([]float64, len(header)), offset: int64(len(line)), row: -1}, nil
+}
+
+/*
+ Reads the next row, and returns false at the end of the recording or on an error
+ */
+func (s *RecordingScanner) Scan() bool {
+    if s.held {
+        s.held = false
+        return true
+    }
+    for s.err == nil {
+        line, err := s.reader.ReadString('\n')
+        if err != nil && err != io.EOF {
+            s.err = err
+            return false
+        }
+        start := s.offset
+        s.offset += int64(len(line))
+        fields := strings.Split(strings.TrimSpace(line), ";")
+        if len(fields) >= len(s.Header) {
+            for i := range s.Header {
+                value, e := strconv.ParseFloat(fields[i], 64)
+                if e != nil {
+                    s.err = fmt.Errorf("invalid line %q", line)
+                    return false
+                }
+                s.values[i] = value
+            }
+            s.start = start
+            s.row++
+            return true
+        }
+        if err == io.EOF {
+            return false
+        }
+    }
+    return false
+}
+
+/*
+ Returns the values of the current row, starting with the time. The slice is reused for the next row.
+ */
+func (s *RecordingScanner) Values() []float64 {
+    return s.values
+}
+
+/*
+ Returns the number of the current row, counting from zero
+ */
+func (s *RecordingScanner) Row() int {
+    return s.row
+}
+
+/*
+ Returns where the current row starts in the unencrypted recording, in bytes
+ */
+func (s *RecordingScanner) Offset() int64 {
+    return s.start
+}
+
+/*
+ Moves the scanner to the first row at or after the given time, so the next call of Scan returns it. With the index
+ of the recording (see ReadIndex), the scanner jumps to the block of the time and only reads the rows of that block.
+ Without an index, the recording is read from the start.
+ */
+func (s *RecordingScanner) Seek(index []IndexBlock, time float64) error {
+    block := FindBlock(index, time)
+    offset, stored, row := int64(0), int64(0), -1
+    if block > 0 {
+        offset, stored, row = index[block].Offset, index[block].Stored, index[block].Row - 1
+    }
+    csv, err := openRecordingAt(s.name, offset, stored, uint64(block))
+    if err != nil {
+        return err
+    }
+    reader := bufio.NewReaderSize(csv, 1 << 16)
+
+    // The first block starts with the header
+    if block == 0 {
+        line, err := reader.ReadString('\n')
+        if err != nil && err != io.EOF {
+            csv.Close()
+            return err
+        }
+        offset = int64(len(line))
+    }
+    s.file.Close()
+    s.file, s.reader, s.offset, s.row, s.err, s.held = csv, reader, offset, row, nil, false
+    for s.Scan() {
+        if s.values[0] >= time {
+            s.held = true
+            return nil
+        }
+    }
+    return s.err
+}
+
+/*
+ Returns the error that stopped the scanner, if any
+ */
+func (s *RecordingScanner) Err() error {
+    return s.err
+}
+
+func (s *RecordingScanner) Close() error {
+    return s.file.Close()
+}
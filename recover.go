@@ -0,0 +1,436 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "io"
+    "fmt"
+    "flag"
+    "math"
+    "bytes"
+    "strings"
+    "strconv"
+    "hash/crc32"
+    "path/filepath"
+)
+
+func init() {
+    Commands["recover"] = recoverCommand
+}
+
+/*
+ Repairs recordings that were never closed, because the application crashed or the Pi lost power. Every block that
+ matches its checksum is kept, and so are the complete rows that were written after the last checksum, if the
+ recording isn't encrypted. Everything behind them was only partially written and is cut off. The files of the
+ processing stages, the segments and the RR intervals are repaired as well. With --dry-run, the recording is only
+ checked.
+ Example:
+    $ plot recover data.csv
+    data.csv: 5412 rows survived, up to 54.110 s (the journal reached 5000 rows, up to 49.990 s), 412 of them after
+    the last checksum, 17 bytes were cut off
+ */
+func recoverCommand(args []string) {
+    flags := flag.NewFlagSet("recover", flag.ExitOnError)
+    dryRun := flags.Bool("dry-run", false, "Only report what would be recovered")
+    flags.StringVar(&(Settings.KeyFile), "key", "", "The key file for encrypted recordings")
+    flags.Parse(args)
+    if flags.NArg() == 0 {
+        fail("Usage: plot recover [--dry-run] [--key=file] <file>...")
+    }
+    ok := true
+    for _, file := range flags.Args() {
+        for _, f := range append([]string{file}, recordingParts(file)...) {
+            recovery, err := RecoverRecording(f, *dryRun)
+            if err != nil {
+                fmt.Printf("%s: %v\n", f, err)
+                ok = false
+                continue
+            }
+            fmt.Printf("%s: %s\n", f, recovery)
+        }
+    }
+    if !ok {
+        os.Exit(1)
+    }
+}
+
+/*
+ What was found when a recording was recovered
+ */
+type Recovery struct {
+
+    /*
+     Whether the recording is complete, because it was closed or already recovered, so there was nothing to do
+     */
+    Closed bool
+
+    /*
+     How many rows survived, and the time of the last one
+     */
+    Rows int
+    Time float64
+
+    /*
+     How many rows and up to which time the journal had confirmed before the recording ended
+     */
+    JournalRows int
+    JournalTime float64
+
+    /*
+     How many rows were written after the last checksum and could be kept
+     */
+    Unconfirmed int
+
+    /*
+     How many bytes of the stored file were kept and how many were cut off
+     */
+    Kept int64
+    Cut int64
+}
+
+func (r Recovery) String() string {
+    if r.Closed {
+        return fmt.Sprintf("complete, %d rows up to %.3f s", r.Rows, r.Time)
+    }
+    s := fmt.Sprintf("%d rows survived, up to %.3f s (the journal reached %d rows, up to %.3f s)", r.Rows, r.Time,
+        r.JournalRows, r.JournalTime)
+    if r.Unconfirmed > 0 {
+        s += fmt.Sprintf(", %d of them after the last checksum", r.Unconfirmed)
+    }
+    if r.Cut > 0 {
+        s += fmt.Sprintf(", %d bytes were cut off", r.Cut)
+    }
+    return s
+}
+
+/*
+ Returns the other files that belong to a recording and have checksums of their own: the processing stages, the
+ segments and the RR intervals (data.csv -> data.rms.csv). A part is named exactly after its stage and was created
+ with the recording, so the parts of another session whose name starts the same (data.retest.segments.csv) aren't
+ mistaken for those of this one.
+ */
+func recordingParts(file string) []string {
+    extension := filepath.Ext(file)
+    base := strings.TrimSuffix(file, extension)
+    parent, parentErr := LoadMetadata(file)
+    matches, _ := filepath.Glob(base + ".*" + extension)
+    parts := []string{}
+    for _, match := range matches {
+        meta, err := LoadMetadata(match)
+        if err != nil || meta.Stage == "" || match != base + "." + meta.Stage + extension {
+            continue
+        }
+        if parentErr == nil && !meta.Created.Equal(parent.Created) {
+            continue
+        }
+        parts = append(parts, match)
+    }
+    return parts
+}
+
+/*
+ A block of the recording as noted in the journal
+ */
+type journalEntry struct {
+    offset int64
+    length int64
+    stored int64
+    rows int
+    time float64
+}
+
+/*
+ Reads the journal of a recording, up to the first line that wasn't completely written. Returns whether the recording
+ was closed or recovered.
+ */
+func readJournal(file string) ([]journalEntry, bool, error) {
+    data, err := os.ReadFile(JournalFile(file))
+    if err != nil {
+        return nil, false, err
+    }
+
+    // A line is complete once it is followed by a newline
+    lines := strings.Split(string(data), "\n")
+    entries := []journalEntry{}
+    if len(lines) < 2 {
+        return entries, false, nil
+    }
+    for _, line := range lines[1:len(lines) - 1] {
+        if line == journalClosed || line == journalRecovered {
+            return entries, true, nil
+        }
+        parts := strings.Split(line, ";")
+        if len(parts) != 5 {
+            break
+        }
+        entry := journalEntry{}
+        var err [5]error
+        entry.offset, err[0] = strconv.ParseInt(parts[0], 10, 64)
+        entry.length, err[1] = strconv.ParseInt(parts[1], 10, 64)
+        entry.stored, err[2] = strconv.ParseInt(parts[2], 10, 64)
+        entry.rows, err[3] = strconv.Atoi(parts[3])
+        entry.time, err[4] = strconv.ParseFloat(parts[4], 64)
+        if err != [5]error{} {
+            break
+        }
+        entries = append(entries, entry)
+    }
+    return entries, false, nil
+}
+
+/*
+ Reads the checksums of a recording, up to the first line that wasn't completely written
+ */
+func readChecksums(file string) ([]uint32, error) {
+    data, err := os.ReadFile(file + ".sum")
+    if err != nil {
+        return nil, err
+    }
+    lines := strings.Split(string(data), "\n")
+    sums := []uint32{}
+    for _, line := range lines[:len(lines) - 1] {
+        parts := strings.Split(line, ";")
+        if len(parts) != 3 || len(parts[2]) != 8 {
+            break
+        }
+        sum, err := strconv.ParseUint(parts[2], 16, 32)
+        if err != nil {
+            break
+        }
+        sums = append(sums, uint32(sum))
+    }
+    return sums, nil
+}
+
+/*
+ Checks a recording that might not have been closed, and unless it is a dry run, cuts off everything that wasn't
+ completely written, so the recording can be used and verified again. Damage in the middle of a recording can't be
+ repaired this way and is reported as an error.
+ */
+func RecoverRecording(file string, dryRun bool) (Recovery, error) {
+    recovery := Recovery{}
+    journal, closed, err := readJournal(file)
+    if err != nil {
+        return recovery, err
+    }
+    sums, err := readChecksums(file)
+    if err != nil {
+        return recovery, err
+    }
+    info, err := os.Stat(file)
+    if err != nil {
+        return recovery, err
+    }
+    meta, err := LoadMetadata(file)
+    if err != nil {
+        return recovery, err
+    }
+
+    // The checksums of an encrypted recording cover its chunks as they are stored, so it is read without decrypting it
+    var csv io.ReadCloser
+    if meta.Encrypted {
+        f, err := os.Open(file)
+        if err != nil {
+            return recovery, err
+        }
+        recovery.Kept, err = f.Seek(int64(len(encryptionMagic) + saltSize), io.SeekStart)
+        if err != nil {
+            f.Close()
+            return recovery, err
+        }
+        csv = f
+    } else {
+        csv, err = OpenRecording(file)
+        if err != nil {
+            return recovery, err
+        }
+    }
+    defer csv.Close()
+
+    // Keep every block that the journal and the checksums confirm. The last block of an encrypted recording that was
+    // closed is its empty final chunk.
+    end := int64(0)
+    blocks := 0
+    final := int64(0)
+    for i, entry := range journal {
+        if i >= len(sums) || entry.offset != end {
+            break
+        }
+        start, length := entry.offset, entry.length
+        if meta.Encrypted {
+            start, length = recovery.Kept, entry.stored - recovery.Kept
+        }
+        block := make([]byte, length)
+        _, err := io.ReadFull(csv, block)
+        if err != nil {
+            // The block is in the journal but not on the storage, which is treated like a crash before the journal
+            break
+        }
+        if crc32.ChecksumIEEE(block) != sums[i] {
+            return recovery, fmt.Errorf("checksum mismatch in bytes %d to %d, which can't be recovered", start,
+                start + length)
+        }
+        final = 0
+        if meta.Encrypted && entry.length == 0 {
+            final = length
+        }
+        end = entry.offset + entry.length
+        blocks++
+        recovery.Kept = entry.stored
+        recovery.Rows = entry.rows
+        recovery.Time = entry.time
+    }
+    if len(journal) > 0 {
+        recovery.JournalRows = journal[len(journal) - 1].rows
+        recovery.JournalTime = journal[len(journal) - 1].time
+    }
+    if closed && blocks == len(journal) && recovery.Kept == info.Size() {
+        recovery.Closed = true
+        return recovery, nil
+    }
+
+    // An encrypted recording gets a new final chunk after the blocks, since the crash might have happened after the
+    // old one was written, but before the journal noted that the recording was closed
+    if final > 0 {
+        blocks--
+        recovery.Kept -= final
+    }
+
+    // The rows behind the last block are kept if they are complete. A row can only be trusted if another one was
+    // started after it, and the storage might have left garbage after the crash. Rows start with a newline, so what
+    // comes before the first one is the header, which was written when the recording was created, if no block
+    // survived. Encrypted recordings only store whole blocks.
+    tail := []byte{}
+    block := IndexBlock{Offset: end, Stored: end, Row: recovery.Rows, Min: math.NaN(), Max: math.NaN()}
+    if !meta.Encrypted {
+        rest, _ := io.ReadAll(csv)
+        if i := bytes.IndexByte(rest, 0); i >= 0 {
+            rest = rest[:i]
+        }
+        rows := bytes.Split(rest, []byte("\n"))
+        if end == 0 {
+            tail = append(tail, rows[0]...)
+        }
+        for j := 1; j < len(rows) - 1; j++ {
+            fields := strings.Split(string(rows[j]), ";")
+            t, err := strconv.ParseFloat(fields[0], 64)
+            if err != nil || len(fields) < 2 {
+                break
+            }
+            tail = append(tail, '\n')
+            tail = append(tail, rows[j]...)
+            if recovery.Unconfirmed == 0 {
+                block.Start = t
+            }
+            block.End = t
+            if v, err := strconv.ParseFloat(fields[1], 64); err == nil {
+                block.Add(v)
+            }
+            recovery.Unconfirmed++
+            recovery.Rows++
+            recovery.Time = t
+        }
+        recovery.Kept = end + int64(len(tail))
+    }
+    recovery.Cut = info.Size() - recovery.Kept
+    if dryRun {
+        return recovery, nil
+    }
+
+    // Cut off the rest, and cover the rows after the last block with a checksum of their own
+    err = os.Truncate(file, recovery.Kept)
+    if err != nil {
+        return recovery, err
+    }
+    entries := fmt.Sprintf("%d;%d;%08x\n", end, len(tail), crc32.ChecksumIEEE(tail))
+    notes := fmt.Sprintf("%d;%d;%d;%d;%f\n%s\n", end, len(tail), recovery.Kept, recovery.Rows, recovery.Time,
+        journalRecovered)
+    if meta.Encrypted {
+        _, chunk, err := finishEncrypted(file, nil)
+        if err != nil {
+            return recovery, err
+        }
+        entries = fmt.Sprintf("%d;%d;%08x\n", recovery.Kept, len(chunk), crc32.ChecksumIEEE(chunk))
+        notes = fmt.Sprintf("%d;0;%d;%d;%f\n%s\n", end, recovery.Kept + int64(len(chunk)), recovery.Rows,
+            recovery.Time, journalRecovered)
+    } else if len(tail) == 0 {
+        entries = ""
+        notes = journalRecovered + "\n"
+    }
+    err = rewriteLines(file + ".sum", blocks, entries)
+    if err != nil {
+        return recovery, err
+    }
+    err = rewriteLines(JournalFile(file), blocks + 1, notes)
+    if err != nil {
+        return recovery, err
+    }
+    indexed := ""
+    if recovery.Unconfirmed > 0 {
+        indexed = block.String()
+    }
+    err = rewriteIndex(file, blocks, indexed)
+    if err != nil {
+        return recovery, err
+    }
+
+    // The rollups aren't synced either, so they are created again from the rows that were kept. If the recording
+    // can't be read for that, they are removed, and "plot index" creates them later.
+    if WriteRollups(file) != nil {
+        RemoveRollups(file)
+    }
+    // The sample of the crash isn't known, and older recordings don't store samples with their events. The events of
+    // an encrypted recording end with the event as their final chunk.
+    line := RecordedEvent{recovery.Time, -1, "recovered after a crash"}.String()
+    if meta.Encrypted {
+        _, _, err = finishEncrypted(EventsFile(file), []byte(line))
+        return recovery, err
+    }
+    if header, _ := os.ReadFile(EventsFile(file)); !strings.HasPrefix(string(header), eventsHeader) {
+        line = fmt.Sprintf("\n%f;recovered after a crash", recovery.Time)
+    }
+    events, err := os.OpenFile(EventsFile(file), os.O_WRONLY|os.O_APPEND, 0644)
+    if err == nil {
+        _, err = events.WriteString(line)
+        events.Close()
+    }
+    return recovery, err
+}
+
+/*
+ Keeps the blocks of the index that were kept, and replaces the rest. The index isn't synced while recording, so it
+ can miss blocks after a crash; then it is removed, and "plot index" creates it again.
+ */
+func rewriteIndex(file string, blocks int, rest string) error {
+    data, err := os.ReadFile(IndexFile(file))
+    if os.IsNotExist(err) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    if strings.Count(string(data), "\n") < blocks + 1 {
+        return os.Remove(IndexFile(file))
+    }
+    return rewriteLines(IndexFile(file), blocks + 1, rest)
+}
+
+/*
+ Keeps the first lines of a file that were completely written and replaces the rest
+ */
+func rewriteLines(file string, keep int, rest string) error {
+    data, err := os.ReadFile(file)
+    if err != nil {
+        return err
+    }
+    lines := strings.SplitAfter(string(data), "\n")
+    if keep < len(lines) {
+        lines = lines[:keep]
+    }
+    return os.WriteFile(file, []byte(strings.Join(lines, "") + rest), 0644)
+}
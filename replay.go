@@ -0,0 +1,202 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "io"
+    "fmt"
+    "flag"
+    "math"
+    "time"
+    "bufio"
+    "os/exec"
+    "strings"
+    "strconv"
+    "encoding/binary"
+)
+
+func init() {
+    Commands["replay"] = replayCommand
+}
+
+/*
+ Replays a recording through a DAC, so recorded muscle activity can be fed into other lab instruments. The MCP4725
+ on the I2C bus outputs 0V to the reference voltage, so the signal is centered on half of it. A sound card (pcm)
+ outputs an AC coupled signal that reaches its full scale at the reference voltage. The recorded voltage is
+ multiplied with the gain and the offset is added before it is written.
+ Example:
+    $ plot replay --dac=mcp4725 --gain=100 data.csv
+ */
+func replayCommand(args []string) {
+    flags := flag.NewFlagSet("replay", flag.ExitOnError)
+    kind := flags.String("dac", "mcp4725", "The DAC that is used: mcp4725 or pcm")
+    bus := flags.String("bus", "/dev/i2c-1", "The I2C bus of the MCP4725")
+    address := flags.Int("address", 0x60, "The I2C address of the MCP4725")
+    device := flags.String("device", "default", "The ALSA device of the sound card")
+    reference := flags.Float64("reference", 3.3, "The voltage that corresponds to the full scale of the DAC")
+    gain := flags.Float64("gain", 1, "The factor that the recorded voltages are multiplied with")
+    offset := flags.Float64("offset", 0, "A voltage that is added to the output")
+    flags.StringVar(&(Settings.KeyFile), "key", "", "The key file for encrypted recordings")
+    flags.Parse(args)
+    if flags.NArg() != 1 {
+        fail("Usage: plot replay [--dac=mcp4725|pcm] [--gain=factor] [--offset=volts] [--reference=volts] <file>")
+    }
+    file := flags.Arg(0)
+    meta, err := LoadMetadata(file)
+    if err != nil {
+        fail("%s: %v", file, err)
+    }
+
+    var dac DAC
+    switch *kind {
+    case "mcp4725":
+        dac, err = OpenMCP4725(*bus, *address, *reference)
+    case "pcm":
+        dac, err = OpenPCM(*device, int(math.Round(1 / meta.Interval)), *reference)
+    default:
+        err = fmt.Errorf("unknown DAC %s", *kind)
+    }
+    if err != nil {
+        fail("%v", err)
+    }
+    defer dac.Close()
+
+    csv, err := OpenRecording(file)
+    if err != nil {
+        fail("%s: %v", file, err)
+    }
+    defer csv.Close()
+    scan := bufio.NewReader(csv)
+    line, err := scan.ReadString(10)
+    if err != nil {
+        fail("%s: %v", file, err)
+    }
+    _, unit := ParseColumn(strings.Split(strings.TrimSpace(line), ";")[1])
+
+    // Output every sample at the time it was recorded. A sound card has its own clock and only needs to be fed.
+    start := time.Now()
+    for true {
+        line, err = scan.ReadString(10)
+        if line == "" && err == io.EOF {
+            break
+        }
+        if err != nil && err != io.EOF {
+            fail("%s: %v", file, err)
+        }
+        columns := strings.Split(strings.TrimSpace(line), ";")
+        if len(columns) < 2 {
+            continue
+        }
+        t, err := strconv.ParseFloat(columns[0], 64)
+        if err != nil {
+            fail("%s: %v", file, err)
+        }
+        voltage, err := strconv.ParseFloat(columns[1], 64)
+        if err != nil {
+            fail("%s: %v", file, err)
+        }
+        if !dac.Clocked() {
+            time.Sleep(time.Until(start.Add(time.Duration(t * 1000 * 1000 * 1000))))
+        }
+        err = dac.Write(voltage / unit.Scale * *gain + *offset)
+        if err != nil {
+            fail("%v", err)
+        }
+    }
+}
+
+/*
+ An analog output that replays voltages
+ */
+type DAC interface {
+    Write(voltage float64) error
+
+    /*
+     Whether the DAC outputs samples with its own clock. Otherwise every sample has to be written at the right time.
+     */
+    Clocked() bool
+    Close() error
+}
+
+/*
+ The 12 bit MCP4725 from Microchip, whose output ranges from 0V to its supply voltage
+ */
+type MCP4725 struct {
+    device *I2C
+    reference float64
+}
+
+func OpenMCP4725(bus string, address int, reference float64) (*MCP4725, error) {
+    device, err := OpenI2C(bus, address)
+    if err != nil {
+        return nil, err
+    }
+    return &MCP4725{device: device, reference: reference}, nil
+}
+
+func (m *MCP4725) Write(voltage float64) error {
+    // Center the signal and use the fast mode write command, which only needs two bytes
+    code := int(math.Round((voltage / m.reference + 0.5) * 4095))
+    code = int(math.Max(0, math.Min(4095, float64(code))))
+    _, err := m.device.Write([]byte{byte(code >> 8), byte(code)})
+    return err
+}
+
+func (m *MCP4725) Clocked() bool {
+    return false
+}
+
+func (m *MCP4725) Close() error {
+    return m.device.Close()
+}
+
+/*
+ A sound card, which is fed with signed 16 bit samples through aplay from alsa-utils. ALSA converts the sample rate
+ of the recording to one that the card supports.
+ */
+type PCM struct {
+    play *exec.Cmd
+    pipe io.WriteCloser
+    buffer *bufio.Writer
+    reference float64
+}
+
+func OpenPCM(device string, rate int, reference float64) (*PCM, error) {
+    play := exec.Command("aplay", "-q", "-D", device, "-t", "raw", "-f", "S16_LE", "-r", strconv.Itoa(rate),
+        "-c", "1")
+    pipe, err := play.StdinPipe()
+    if err != nil {
+        return nil, err
+    }
+    err = play.Start()
+    if err != nil {
+        return nil, err
+    }
+    return &PCM{play: play, pipe: pipe, buffer: bufio.NewWriter(pipe), reference: reference}, nil
+}
+
+func (p *PCM) Write(voltage float64) error {
+    code := math.Max(-32768, math.Min(32767, math.Round(voltage / p.reference * 32767)))
+    sample := make([]byte, 2)
+    binary.LittleEndian.PutUint16(sample, uint16(int16(code)))
+    _, err := p.buffer.Write(sample)
+    return err
+}
+
+func (p *PCM) Clocked() bool {
+    return true
+}
+
+func (p *PCM) Close() error {
+    // Let the sound card play what is left
+    err := p.buffer.Flush()
+    p.pipe.Close()
+    if err != nil {
+        return err
+    }
+    return p.play.Wait()
+}
@@ -0,0 +1,352 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "fmt"
+    "flag"
+    "math"
+    "mime"
+    "bytes"
+    "time"
+    "strings"
+    "html/template"
+    "path/filepath"
+    "encoding/base64"
+)
+
+func init() {
+    Commands["report"] = reportCommand
+}
+
+/*
+ What a report of a session contains, which is the data of the template of the report. The charts are SVG images,
+ embedded as data URIs (envelope, spectrum if the recording is long enough, and segments if it has segments).
+ */
+type Report struct {
+    File string
+    Generated time.Time
+    Meta Metadata
+    Duration float64
+    Samples int
+    Summary []Measure
+    MeanFrequency float64
+    MedianFrequency float64
+    Segments []SegmentStatistics
+    Charts map[string]template.URL
+}
+
+/*
+ The names of the measures in reports
+ */
+var measureLabels = map[string]string{"samples": "Samples", "mean": "Mean", "rms": "RMS", "min": "Min",
+    "max": "Max", "peak": "Peak", "iemg": "iEMG"}
+
+/*
+ Writes a report of a recording as a single HTML file, with the metadata of the session, the statistics of the
+ signal and of every segment, and charts of the envelope, the spectrum and the segments. The report has no external
+ resources, so it can be archived or mailed as it is, and printed into a PDF from the browser.
+ Example:
+    $ plot report session.csv -o report.html
+ The layout can be replaced with a Go template (see html/template), which gets the Report as its data and the
+ functions of reportFunctions. Images like the logo of the clinic are embedded with {{embed "logo.png"}}, relative
+ to the template. The built-in layout is a starting point:
+    $ plot report --print-template > clinic.html
+    $ plot report --template=clinic.html session.csv
+ */
+func reportCommand(args []string) {
+    flags := flag.NewFlagSet("report", flag.ExitOnError)
+    output := flags.String("o", "", "The file that the report is written into. Default: the recording with the " +
+        "extension .html (data.csv -> data.html).")
+    window := flags.Float64("envelope", 0.1, "The window of the RMS envelope in seconds")
+    layout := flags.String("template", "", "A Go template that replaces the layout of the report")
+    printTemplate := flags.Bool("print-template", false, "Print the built-in template and exit")
+    flags.StringVar(&(Settings.KeyFile), "key", "", "The key file for encrypted recordings")
+    flags.BoolVar(&(Settings.MotionGate), "motion-gate", false, "Leave the samples during motion out of the " +
+        "statistics of the segments, see plot analyze")
+    flags.Float64Var(&(Settings.MotionHold), "motion-hold", 0.5, "How many seconds around the motion are left out")
+
+    // Allow the options after the recording, like in the example
+    files := []string{}
+    for flags.Parse(args); flags.NArg() > 0; flags.Parse(args) {
+        files = append(files, flags.Arg(0))
+        args = flags.Args()[1:]
+    }
+    if *printTemplate {
+        fmt.Print(defaultReportTemplate)
+        return
+    }
+    if len(files) != 1 {
+        fail("Usage: plot report [-o report.html] [--template=file] [--envelope=seconds] [--key=file] <file>")
+    }
+    file := files[0]
+    if *output == "" {
+        *output = strings.TrimSuffix(file, filepath.Ext(file)) + ".html"
+    }
+
+    // Check the template before the recording is read, which takes a while
+    var layoutTemplate *template.Template
+    var err error
+    if *layout != "" {
+        layoutTemplate, err = ReportTemplate(*layout)
+    } else {
+        layoutTemplate, err = template.New("report").Funcs(reportFunctions("")).Parse(defaultReportTemplate)
+    }
+    if err != nil {
+        fail("%v", err)
+    }
+    report, err := NewReport(file, *window)
+    if err != nil {
+        fail("%s: %v", file, err)
+    }
+    // A template that fails halfway doesn't leave half a report behind
+    var buffer bytes.Buffer
+    err = layoutTemplate.Execute(&buffer, report)
+    if err != nil {
+        fail("%v", err)
+    }
+    err = os.WriteFile(*output, buffer.Bytes(), 0644)
+    if err != nil {
+        fail("%v", err)
+    }
+    fmt.Printf("Wrote the report of %s into %s\n", file, *output)
+}
+
+/*
+ Collects the report of a recording. The envelope is the RMS of the raw signal around its mean over the window in
+ seconds. The recording is read one row after the other, so it can be of any size.
+ */
+func NewReport(file string, window float64) (Report, error) {
+    meta, err := LoadMetadata(file)
+    if err != nil {
+        return Report{}, err
+    }
+    events, err := ReadAnnotatedEvents(file)
+    if err != nil {
+        return Report{}, err
+    }
+    if window < meta.Interval {
+        return Report{}, fmt.Errorf("the window of the envelope must be at least one interval long")
+    }
+    whole, err := segmentStatistics(file, meta, nil)
+    if err != nil {
+        return Report{}, err
+    }
+    if len(whole) == 0 {
+        return Report{}, fmt.Errorf("the recording is empty")
+    }
+    report := Report{File: filepath.Base(file), Generated: time.Now(), Meta: meta, Summary: whole[0].Measures,
+        Samples: int(whole[0].Measure("samples")), Duration: whole[0].End - whole[0].Start,
+        Charts: map[string]template.URL{}}
+    report.Segments, err = segmentStatistics(file, meta, events)
+    if err != nil {
+        return Report{}, err
+    }
+
+    scanner, err := ScanRecording(file)
+    if err != nil {
+        return Report{}, err
+    }
+    defer scanner.Close()
+    _, unit := ParseColumn(scanner.Header[1])
+    envelope := NewSeriesReducer(svgWidth - svgLeft - svgRight)
+    spectrum := NewSpectrum(meta.Interval)
+    length := int(window / meta.Interval)
+    recent := make([]float64, 0, length)
+    sum, squares := 0.0, 0.0
+    for scanner.Scan() {
+        value := scanner.Values()[1] / unit.Scale
+        spectrum.Add(value)
+        if len(recent) == length {
+            sum -= recent[0]
+            squares -= recent[0] * recent[0]
+            recent = append(recent[:0], recent[1:]...)
+        }
+        recent = append(recent, value)
+        sum += value
+        squares += value * value
+        mean := sum / float64(len(recent))
+        envelope.Add(scanner.Values()[0], math.Sqrt(math.Max(0, squares / float64(len(recent)) - mean * mean)))
+    }
+    if scanner.Err() != nil {
+        return Report{}, scanner.Err()
+    }
+    markers := []SVGMarker{}
+    for _, event := range events {
+        if name, ok := EventSegment(event.Event); ok {
+            markers = append(markers, SVGMarker{event.Time, name})
+        }
+    }
+    keys, values := envelope.Series()
+    report.Charts["envelope"] = svgURL(LineSVG(keys, values, "Time [s]", formatTick, AutoUnit.Format, markers))
+
+    frequencies, power := spectrum.Result()
+    if frequencies != nil {
+        report.MeanFrequency, report.MedianFrequency = SpectrumFrequencies(frequencies, power)
+        report.Charts["spectrum"] = svgURL(LineSVG(frequencies, power, "Frequency [Hz]", formatTick,
+            func(v float64) string {
+                return fmt.Sprintf("%.3g V²/Hz", v)
+            }, []SVGMarker{{report.MedianFrequency, "median"}}))
+    }
+
+    // The RMS of every segment of the raw signal, if the session has segments
+    if len(markers) > 0 {
+        labels, values := []string{}, []float64{}
+        for _, segment := range report.Segments {
+            if segment.Signal == "raw" {
+                labels = append(labels, fmt.Sprintf("%s %d", segment.Name, segment.Repetition))
+                values = append(values, segment.Measure("rms"))
+            }
+        }
+        report.Charts["segments"] = svgURL(BarSVG(labels, values, AutoUnit.Format))
+    }
+    return report, nil
+}
+
+/*
+ Embeds an SVG image as a data URI
+ */
+func svgURL(svg string) template.URL {
+    return template.URL("data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString([]byte(svg)))
+}
+
+/*
+ Loads a template for reports from a file
+ */
+func ReportTemplate(file string) (*template.Template, error) {
+    data, err := os.ReadFile(file)
+    if err != nil {
+        return nil, err
+    }
+    return template.New(filepath.Base(file)).Funcs(reportFunctions(filepath.Dir(file))).Parse(string(data))
+}
+
+/*
+ The functions that the template of a report can use:
+    measure <name> <value>   Formats a measure of the statistics with its unit
+    label <name>             The name of a measure for people, like RMS for rms
+    duration <seconds>       Formats a duration as hh:mm:ss
+    seconds <seconds>        Formats seconds with two decimals
+    hz <frequency>           Formats a frequency
+    volts <volts>            Formats a voltage with a fitting unit
+    embed <file>             Embeds a file, like a logo, as a data URI. Paths are relative to the template.
+ */
+func reportFunctions(directory string) template.FuncMap {
+    return template.FuncMap{
+        "measure": formatMeasure,
+        "label": func(name string) string {
+            if label, ok := measureLabels[name]; ok {
+                return label
+            }
+            return name
+        },
+        "duration": formatDuration,
+        "seconds": func(seconds float64) string {
+            return fmt.Sprintf("%.2f s", seconds)
+        },
+        "hz": func(frequency float64) string {
+            return fmt.Sprintf("%.1f Hz", frequency)
+        },
+        "volts": AutoUnit.Format,
+        "embed": func(file string) (template.URL, error) {
+            if !filepath.IsAbs(file) {
+                file = filepath.Join(directory, file)
+            }
+            data, err := os.ReadFile(file)
+            if err != nil {
+                return "", err
+            }
+            kind := mime.TypeByExtension(filepath.Ext(file))
+            if kind == "" {
+                kind = "application/octet-stream"
+            }
+            return template.URL("data:" + kind + ";base64," + base64.StdEncoding.EncodeToString(data)), nil
+        },
+    }
+}
+
+/*
+ The built-in layout of reports
+ */
+const defaultReportTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Report of {{.File}}</title>
+<style>
+    @page { size: A4; margin: 15mm; }
+    body { font-family: sans-serif; font-size: 10pt; color: #222; max-width: 760px; margin: 2em auto; }
+    h1 { font-size: 16pt; margin-bottom: 0; }
+    h2 { font-size: 12pt; margin-top: 1.5em; border-bottom: 1px solid #ccc; }
+    .generated { color: #777; }
+    table { border-collapse: collapse; }
+    th, td { text-align: left; padding: 2px 10px 2px 0; }
+    td.number, th.number { text-align: right; }
+    img { max-width: 100%; page-break-inside: avoid; }
+    section { page-break-inside: avoid; }
+</style>
+</head>
+<body>
+<h1>Session {{.File}}</h1>
+<p class="generated">Generated {{.Generated.Format "2006-01-02 15:04"}}</p>
+
+<section>
+<h2>Session</h2>
+<table>
+    <tr><th>Recorded</th><td>{{.Meta.Created.Format "2006-01-02 15:04"}}</td></tr>
+    {{if .Meta.Subject}}<tr><th>Subject</th><td>{{.Meta.Subject}}</td></tr>{{end}}
+    <tr><th>Duration</th><td>{{duration .Duration}}</td></tr>
+    <tr><th>Samples</th><td>{{.Samples}}, every {{seconds .Meta.Interval}}</td></tr>
+    <tr><th>Source</th><td>{{if .Meta.Source}}{{.Meta.Source}}{{else}}ADCPi, address {{printf "0x%02x" .Meta.Address}},
+        channel {{.Meta.Channel}}{{end}}</td></tr>
+    <tr><th>Mode</th><td>{{.Meta.Mode}}</td></tr>
+    {{if .Meta.Filters}}<tr><th>Filters</th><td>{{.Meta.Filters}}</td></tr>{{end}}
+    {{if .Meta.PostTrigger}}<tr><th>Triggered</th><td>{{seconds .Meta.PreTrigger}} before and
+        {{seconds .Meta.PostTrigger}} after every trigger</td></tr>{{end}}
+    {{if .Meta.Quality}}<tr><th>Quality</th><td>{{.Meta.Quality}}</td></tr>{{end}}
+    {{if .Meta.Tags}}<tr><th>Tags</th><td>{{range $i, $tag := .Meta.Tags}}{{if $i}}, {{end}}{{$tag}}{{end}}</td>
+        </tr>{{end}}
+    {{if .Meta.Notes}}<tr><th>Notes</th><td>{{.Meta.Notes}}</td></tr>{{end}}
+</table>
+</section>
+
+<section>
+<h2>Signal</h2>
+<table>
+    {{range .Summary}}<tr><th>{{label .Name}}</th><td class="number">{{measure .Name .Value}}</td></tr>
+    {{end}}
+    {{if .MedianFrequency}}<tr><th>Mean frequency</th><td class="number">{{hz .MeanFrequency}}</td></tr>
+    <tr><th>Median frequency</th><td class="number">{{hz .MedianFrequency}}</td></tr>{{end}}
+</table>
+</section>
+
+<section>
+<h2>Envelope</h2>
+<img src="{{.Charts.envelope}}" alt="The RMS envelope of the signal">
+</section>
+
+{{with .Charts.spectrum}}<section>
+<h2>Spectrum</h2>
+<img src="{{.}}" alt="The power spectrum of the signal">
+</section>{{end}}
+
+<section>
+<h2>Segments</h2>
+{{with .Charts.segments}}<img src="{{.}}" alt="The RMS of every segment">{{end}}
+<table>
+    <tr><th>Segment</th><th>Signal</th><th class="number">Start</th><th class="number">End</th>
+        {{with index .Segments 0}}{{range .Measures}}<th class="number">{{label .Name}}</th>{{end}}{{end}}</tr>
+    {{range .Segments}}<tr><td>{{.Name}} {{.Repetition}}</td><td>{{.Signal}}</td>
+        <td class="number">{{seconds .Start}}</td><td class="number">{{seconds .End}}</td>
+        {{range .Measures}}<td class="number">{{measure .Name .Value}}</td>{{end}}</tr>
+    {{end}}
+</table>
+</section>
+</body>
+</html>
+`
@@ -0,0 +1,246 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "fmt"
+    "flag"
+    "time"
+    "path/filepath"
+)
+
+func init() {
+    Commands["prune"] = pruneCommand
+}
+
+/*
+ How often a long session prunes the session store again
+ */
+const retentionInterval = time.Hour
+
+/*
+ How long the sessions of a store are kept, so a device in the field doesn't fill up its storage. Zero keeps them
+ forever.
+    Raw         After this, the samples of a session are removed: the recording with its stages, segments, events,
+                annotations, checksums, journal, index and the frames of the camera. The metadata and the rollups
+                stay, so the session is still listed and shows up in trends (see Rollup).
+    Rollups     After this, the session is removed completely
+ The age of a session is measured from when it was recorded.
+ */
+type Retention struct {
+    Raw time.Duration
+    Rollups time.Duration
+}
+
+/*
+ What the retention removes of a session
+ */
+type Pruning struct {
+    Session Session
+
+    // Whether the whole session is removed, or only its samples
+    All bool
+    Files []string
+    Bytes int64
+}
+
+/*
+ Returns what the retention would remove from the sessions below a directory at the given moment
+ */
+func (r Retention) Plan(dir string, now time.Time) ([]Pruning, error) {
+    sessions, err := FindSessions(dir)
+    if err != nil {
+        return nil, err
+    }
+    prunings := []Pruning{}
+    for _, session := range sessions {
+        age := now.Sub(session.Meta.Created)
+        pruning := Pruning{Session: session}
+        if r.Rollups > 0 && age > r.Rollups {
+            pruning.All = true
+            pruning.Files = append(sessionSamples(session.File), sessionSummary(session.File)...)
+        } else if r.Raw > 0 && age > r.Raw && session.Meta.Pruned == nil {
+            pruning.Files = sessionSamples(session.File)
+        } else {
+            continue
+        }
+        for _, file := range pruning.Files {
+            pruning.Bytes += diskUsage(file)
+        }
+        prunings = append(prunings, pruning)
+    }
+    return prunings, nil
+}
+
+/*
+ Returns the files of a session that hold its samples, which are removed first
+ */
+func sessionSamples(file string) []string {
+    files := []string{}
+    for _, f := range append([]string{file}, recordingParts(file)...) {
+        files = append(files, f, f + ".sum", JournalFile(f), IndexFile(f), EventsFile(f))
+        if f != file {
+            files = append(files, sessionSummary(f)...)
+        }
+    }
+    files = append(files, file + ".annotations", file + ".annotations.json", FramesDirectory(file))
+    return existing(files)
+}
+
+/*
+ Returns the files of a session that stay after its samples were removed
+ */
+func sessionSummary(file string) []string {
+    files := []string{MetadataFile(file)}
+    for resolution := range RollupResolutions {
+        files = append(files, RollupFile(file, resolution))
+    }
+    return existing(files)
+}
+
+func existing(files []string) []string {
+    found := []string{}
+    for _, file := range files {
+        if _, err := os.Stat(file); err == nil {
+            found = append(found, file)
+        }
+    }
+    return found
+}
+
+/*
+ Returns how many bytes a file or a directory takes
+ */
+func diskUsage(path string) int64 {
+    size := int64(0)
+    filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+        if err == nil && !info.IsDir() {
+            size += info.Size()
+        }
+        return nil
+    })
+    return size
+}
+
+/*
+ Removes the files of the pruning. If only the samples are removed, the metadata notes when that happened.
+ */
+func (p Pruning) Apply() error {
+    for _, file := range p.Files {
+        err := os.RemoveAll(file)
+        if err != nil {
+            return err
+        }
+    }
+    if p.All {
+        return nil
+    }
+    meta := p.Session.Meta
+    now := time.Now()
+    meta.Pruned = &now
+    return SaveMetadata(p.Session.File, meta)
+}
+
+/*
+ Returns what is removed: "samples" or "session"
+ */
+func (p Pruning) Removed() string {
+    if p.All {
+        return "session"
+    }
+    return "samples"
+}
+
+func (p Pruning) String() string {
+    return fmt.Sprintf("%s   %s   %s (%d files, %.1f MB)", p.Session.Meta.Created.Format("2006-01-02 15:04"),
+        p.Session.File, p.Removed(), len(p.Files), float64(p.Bytes) / (1 << 20))
+}
+
+/*
+ Prunes the session store that a recording is made in, when it starts and then every retentionInterval until the
+ session ends. The sessions that were pruned are noted in the events of the recording.
+ */
+func RunRetention(pipeline *Pipeline, dir string, retention Retention) {
+    for !pipeline.Closed() {
+        prunings, err := retention.Plan(dir, time.Now())
+        _, t := pipeline.LastSample()
+        if err != nil {
+            pipeline.Event(t, fmt.Sprintf("retention failed: %v", err))
+        }
+        for _, pruning := range prunings {
+            err = pruning.Apply()
+            if err != nil {
+                pipeline.Event(t, fmt.Sprintf("retention failed: %v", err))
+                break
+            }
+            pipeline.Event(t, fmt.Sprintf("pruned the %s of %s (%.1f MB)", pruning.Removed(), pruning.Session.File,
+                float64(pruning.Bytes) / (1 << 20)))
+        }
+        for waited := time.Duration(0); waited < retentionInterval && !pipeline.Closed(); waited += diskCheckInterval {
+            time.Sleep(diskCheckInterval)
+        }
+    }
+}
+
+/*
+ Applies the retention to a session store by hand, or shows what it would remove with --dry-run. Recordings with
+ --retain-raw or --retain-rollups prune the directory they are recorded in on their own.
+ Example:
+    $ plot prune --dir=/data --raw=30 --rollups=365 --dry-run
+    2024-01-02 10:00   /data/2024-01-02.csv   samples (14 files, 52.1 MB)
+    Would free 52.1 MB in 1 session(s)
+ */
+func pruneCommand(args []string) {
+    flags := flag.NewFlagSet("prune", flag.ExitOnError)
+    dir := flags.String("dir", ".", "The directory of the session store")
+    raw := flags.Int("raw", 0, "After how many days the samples of a session are removed. 0 keeps them forever.")
+    rollups := flags.Int("rollups", 0, "After how many days a session is removed completely. 0 keeps it forever.")
+    dryRun := flags.Bool("dry-run", false, "Only show what would be removed")
+    flags.Parse(args)
+    if flags.NArg() > 0 || (*raw == 0 && *rollups == 0) {
+        fail("Usage: plot prune [--dir=dir] [--raw=days] [--rollups=days] [--dry-run]")
+    }
+    retention, err := NewRetention(*raw, *rollups)
+    if err != nil {
+        fail("%v", err)
+    }
+    prunings, err := retention.Plan(*dir, time.Now())
+    if err != nil {
+        fail("%v", err)
+    }
+    freed := int64(0)
+    for _, pruning := range prunings {
+        fmt.Println(pruning)
+        if !*dryRun {
+            err = pruning.Apply()
+            if err != nil {
+                fail("%s: %v", pruning.Session.File, err)
+            }
+        }
+        freed += pruning.Bytes
+    }
+    verb := "Freed"
+    if *dryRun {
+        verb = "Would free"
+    }
+    fmt.Printf("%s %.1f MB in %d session(s)\n", verb, float64(freed) / (1 << 20), len(prunings))
+}
+
+/*
+ Creates the retention from days, which have to be positive. The samples can't outlive the session.
+ */
+func NewRetention(raw int, rollups int) (Retention, error) {
+    if raw < 0 || rollups < 0 {
+        return Retention{}, fmt.Errorf("the retention must be a positive number of days")
+    }
+    if rollups > 0 && (raw == 0 || raw > rollups) {
+        return Retention{}, fmt.Errorf("the rollups must be kept at least as long as the samples")
+    }
+    day := 24 * time.Hour
+    return Retention{Raw: time.Duration(raw) * day, Rollups: time.Duration(rollups) * day}, nil
+}
@@ -0,0 +1,79 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "time"
+    "strings"
+    "testing"
+    "path/filepath"
+)
+
+/*
+ Two sessions whose names start the same: pruning the older one must leave the parts of the newer one alone
+ */
+func TestPlanSessionsWithSharedPrefix(t *testing.T) {
+    dir := t.TempDir()
+    now := time.Now()
+    old := Metadata{Created: now.Add(-48 * time.Hour)}
+    recent := Metadata{Created: now.Add(-time.Hour)}
+    write := func(name string, meta Metadata, stage string) string {
+        file := filepath.Join(dir, name)
+        meta.Stage = stage
+        for _, f := range []string{file, file + ".sum", IndexFile(file), JournalFile(file)} {
+            err := os.WriteFile(f, []byte("Time [s];Voltage [V]\n"), 0644)
+            if err != nil {
+                t.Fatal(err)
+            }
+        }
+        err := SaveMetadata(file, meta)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return file
+    }
+    write("s.csv", old, "")
+    write("s.segments.csv", old, "segments")
+    retest := write("s.retest.csv", recent, "")
+    retestSegments := write("s.retest.segments.csv", recent, "segments")
+
+    prunings, err := Retention{Raw: 24 * time.Hour}.Plan(dir, now)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(prunings) != 1 || prunings[0].Session.File != filepath.Join(dir, "s.csv") {
+        t.Fatalf("expected only s.csv to be pruned, got %v", prunings)
+    }
+    for _, file := range prunings[0].Files {
+        if strings.Contains(file, "retest") {
+            t.Errorf("the pruning of s.csv removes %s of the newer session", file)
+        }
+    }
+    if !contains(prunings[0].Files, filepath.Join(dir, "s.segments.csv")) {
+        t.Errorf("the pruning of s.csv keeps its segments: %v", prunings[0].Files)
+    }
+
+    err = prunings[0].Apply()
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, file := range []string{retest, retestSegments, MetadataFile(retestSegments), IndexFile(retestSegments)} {
+        if _, err := os.Stat(file); err != nil {
+            t.Errorf("%s of the newer session was removed", filepath.Base(file))
+        }
+    }
+}
+
+func contains(list []string, value string) bool {
+    for _, item := range list {
+        if item == value {
+            return true
+        }
+    }
+    return false
+}
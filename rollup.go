@@ -0,0 +1,250 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "fmt"
+    "math"
+    "strings"
+    "strconv"
+)
+
+/*
+ The summary of the first column after the time over a fixed interval of a recording, so views of weeks of sessions
+ and reports don't have to read every sample. The recorder writes them next to the recording while recording, one
+ file per resolution in RollupResolutions (extension .rollup-1s appended), "plot index" creates them for older
+ recordings. Every file starts with a header, and every interval with samples adds a line with its start, how many
+ samples it has, and their mean, RMS, minimum and maximum. The files aren't synced while recording, so the last lines
+ can be missing after a crash. Encrypted recordings have no rollups, since they would reveal the signal.
+ */
+type Rollup struct {
+    Start float64
+    Samples int
+    Mean float64
+    RMS float64
+    Min float64
+    Max float64
+}
+
+const rollupHeader = "Start [s];Samples;Mean;RMS;Min;Max\n"
+
+/*
+ The resolutions that rollups are kept in, by their names and their intervals in seconds
+ */
+var RollupResolutions = map[string]float64{
+    "1s": 1,
+    "1min": 60,
+}
+
+/*
+ Returns the path of the rollups of a recording in one of RollupResolutions
+ */
+func RollupFile(file string, resolution string) string {
+    return file + ".rollup-" + resolution
+}
+
+/*
+ Formats a rollup as a line of its file
+ */
+func (r Rollup) String() string {
+    return fmt.Sprintf("%f;%d;%g;%g;%g;%g\n", r.Start, r.Samples, r.Mean, r.RMS, r.Min, r.Max)
+}
+
+/*
+ Summarizes the values of a recording into the rollups of one resolution while they are written
+ */
+type RollupWriter struct {
+    file *os.File
+    seconds float64
+    current Rollup
+    sum float64
+    squares float64
+}
+
+/*
+ Creates the rollups of a recording in every resolution
+ */
+func NewRollupWriters(file string) ([]*RollupWriter, error) {
+    writers := []*RollupWriter{}
+    for resolution, seconds := range RollupResolutions {
+        f, err := os.Create(RollupFile(file, resolution))
+        if err != nil {
+            CloseRollups(writers)
+            return nil, err
+        }
+        f.WriteString(rollupHeader)
+        writers = append(writers, &RollupWriter{file: f, seconds: seconds})
+    }
+    return writers, nil
+}
+
+/*
+ Adds a value at a time of the recording. The rollup of the interval before it is written once a value of a later
+ interval arrives. Values that are NaN aren't summarized.
+ */
+func (w *RollupWriter) Add(time float64, value float64) error {
+    if math.IsNaN(value) {
+        return nil
+    }
+    start := math.Floor(time / w.seconds) * w.seconds
+    if w.current.Samples > 0 && start != w.current.Start {
+        err := w.flush()
+        if err != nil {
+            return err
+        }
+    }
+    if w.current.Samples == 0 {
+        w.current = Rollup{Start: start, Min: value, Max: value}
+    }
+    w.current.Samples++
+    w.sum += value
+    w.squares += value * value
+    w.current.Min = math.Min(w.current.Min, value)
+    w.current.Max = math.Max(w.current.Max, value)
+    return nil
+}
+
+func (w *RollupWriter) flush() error {
+    if w.current.Samples == 0 {
+        return nil
+    }
+    w.current.Mean = w.sum / float64(w.current.Samples)
+    w.current.RMS = math.Sqrt(w.squares / float64(w.current.Samples))
+    _, err := w.file.WriteString(w.current.String())
+    w.current, w.sum, w.squares = Rollup{}, 0, 0
+    return err
+}
+
+/*
+ Writes the rollup of the last interval and closes the file
+ */
+func (w *RollupWriter) Close() error {
+    err := w.flush()
+    if err != nil {
+        w.file.Close()
+        return err
+    }
+    return w.file.Close()
+}
+
+/*
+ Closes the rollups of every resolution, and returns the first error
+ */
+func CloseRollups(writers []*RollupWriter) error {
+    var first error
+    for _, writer := range writers {
+        err := writer.Close()
+        if first == nil {
+            first = err
+        }
+    }
+    return first
+}
+
+/*
+ Creates the rollups of a recording by reading it. Those of an encrypted recording are removed instead.
+ */
+func WriteRollups(file string) error {
+    if meta, err := LoadMetadata(file); err == nil && meta.Encrypted {
+        RemoveRollups(file)
+        return nil
+    }
+    scanner, err := ScanRecording(file)
+    if err != nil {
+        return err
+    }
+    defer scanner.Close()
+    writers, err := NewRollupWriters(file)
+    if err != nil {
+        return err
+    }
+    for scanner.Scan() && err == nil {
+        values := scanner.Values()
+        if len(values) < 2 {
+            continue
+        }
+        for _, writer := range writers {
+            err = writer.Add(values[0], values[1])
+            if err != nil {
+                break
+            }
+        }
+    }
+    if err == nil {
+        err = scanner.Err()
+    }
+    closed := CloseRollups(writers)
+    if err != nil {
+        return err
+    }
+    return closed
+}
+
+/*
+ Removes the rollups of a recording in every resolution
+ */
+func RemoveRollups(file string) {
+    for resolution := range RollupResolutions {
+        os.Remove(RollupFile(file, resolution))
+    }
+}
+
+/*
+ Reads the rollups of a recording in one of RollupResolutions. Recordings without them return none. A last line that
+ wasn't completely written is left out.
+ */
+func ReadRollups(file string, resolution string) ([]Rollup, error) {
+    data, err := os.ReadFile(RollupFile(file, resolution))
+    if os.IsNotExist(err) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    lines := strings.Split(string(data), "\n")
+    rollups := []Rollup{}
+    if len(lines) < 2 {
+        return rollups, nil
+    }
+    for _, line := range lines[1:len(lines) - 1] {
+        fields := strings.Split(line, ";")
+        if len(fields) < 6 {
+            return nil, fmt.Errorf("invalid line %q in the rollups", line)
+        }
+        rollup := Rollup{}
+        rollup.Start, err = strconv.ParseFloat(fields[0], 64)
+        if err == nil {
+            rollup.Samples, err = strconv.Atoi(fields[1])
+        }
+        numbers := []*float64{&rollup.Mean, &rollup.RMS, &rollup.Min, &rollup.Max}
+        for i := 0; i < len(numbers) && err == nil; i++ {
+            *numbers[i], err = strconv.ParseFloat(fields[2 + i], 64)
+        }
+        if err != nil {
+            return nil, fmt.Errorf("invalid line %q in the rollups", line)
+        }
+        rollups = append(rollups, rollup)
+    }
+    return rollups, nil
+}
+
+/*
+ Collects rollups into a table with the columns time, samples, mean, rms, min and max
+ */
+func NewRollupTable(rollups []Rollup) SampleTable {
+    table := SampleTable{Names: []string{"time", "samples", "mean", "rms", "min", "max"}}
+    table.Columns = make([][]float64, len(table.Names))
+    for _, rollup := range rollups {
+        values := []float64{rollup.Start, float64(rollup.Samples), rollup.Mean, rollup.RMS, rollup.Min,
+            rollup.Max}
+        for i, value := range values {
+            table.Columns[i] = append(table.Columns[i], value)
+        }
+    }
+    return table
+}
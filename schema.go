@@ -0,0 +1,266 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "fmt"
+    "flag"
+    "math"
+    "time"
+    "reflect"
+    "strings"
+    "encoding/json"
+)
+
+func init() {
+    Commands["schema"] = schemaCommand
+}
+
+/*
+ The version of the wire schema of all streaming outputs. Within a version, fields are only ever added, never renamed,
+ removed or given another meaning, so consumers can rely on them. Everything else requires a new version.
+ */
+const SchemaVersion = 1
+
+/*
+ The fields that start every message. Consumers should check the version and dispatch on the type.
+ */
+type StreamHeader struct {
+    Schema int `json:"schema" description:"The version of the schema, see SchemaVersion"`
+    Type string `json:"type" description:"The type of the message: session, sample, event or segment"`
+}
+
+/*
+ The first message of a stream, which describes the session
+ */
+type SessionMessage struct {
+    StreamHeader
+    Created time.Time `json:"created" description:"When the session was started (RFC 3339)"`
+    Interval float64 `json:"interval" description:"The seconds between two samples"`
+    Subject string `json:"subject,omitempty" description:"The pseudonym of the subject"`
+    Stages []string `json:"stages" description:"The keys of the processing stages in the samples, in their order"`
+    Aux []StreamChannel `json:"aux" description:"The auxiliary sensors"`
+}
+
+/*
+ An auxiliary sensor in the description of a session
+ */
+type StreamChannel struct {
+    Name string `json:"name" description:"The key of the sensor in the samples"`
+    Unit string `json:"unit" description:"The unit of its values"`
+}
+
+/*
+ One measurement
+ */
+type SampleMessage struct {
+    StreamHeader
+    Time float64 `json:"time" description:"The seconds since the start of the acquisition"`
+    Value float64 `json:"value" description:"The raw signal in volts"`
+    Stages map[string]float64 `json:"stages,omitempty" description:"The output of every processing stage in volts"`
+    Aux map[string]float64 `json:"aux,omitempty" description:"The auxiliary sensors that have a value, in their units"`
+    HeartRate float64 `json:"heart_rate,omitempty" description:"The heart rate of a beat at this sample, in bpm"`
+}
+
+/*
+ Something that happened during the session, like a marker, a pause or an outage of the sensor
+ */
+type EventMessage struct {
+    StreamHeader
+    Time float64 `json:"time" description:"The seconds since the start of the acquisition"`
+    Sample int `json:"sample" description:"The number of the sample at which it happened, -1 if it isn't known"`
+    Event string `json:"event" description:"What happened"`
+    Class string `json:"class,omitempty" description:"The new class of the classifier, for class events"`
+    Confidence float64 `json:"confidence,omitempty" description:"How confident the classifier is about it, 0 to 1"`
+}
+
+/*
+ A finished segment of the session, like a phase of the protocol
+ */
+type SegmentMessage struct {
+    StreamHeader
+    Name string `json:"name" description:"The name of the segment"`
+    Start float64 `json:"start" description:"The time of the first sample of the segment"`
+    End float64 `json:"end" description:"The time of the last sample of the segment"`
+    IEMG float64 `json:"iemg" description:"The integrated EMG in volt seconds"`
+    CoContraction float64 `json:"cocontraction,omitempty" description:"The co-contraction index in percent"`
+}
+
+/*
+ The message types and the structs that describe them
+ */
+var streamMessages = []struct {
+    Type string
+    Message interface{}
+}{
+    {"session", SessionMessage{}},
+    {"sample", SampleMessage{}},
+    {"event", EventMessage{}},
+    {"segment", SegmentMessage{}},
+}
+
+func NewSessionMessage(meta Metadata, stages []string) SessionMessage {
+    message := SessionMessage{StreamHeader: StreamHeader{SchemaVersion, "session"}, Created: meta.Created,
+        Interval: meta.Interval, Subject: meta.Subject, Stages: streamKeys(stages), Aux: []StreamChannel{}}
+    for _, aux := range meta.Aux {
+        message.Aux = append(message.Aux, StreamChannel{Name: aux.Name, Unit: aux.Unit})
+    }
+    return message
+}
+
+/*
+ Creates the message of a sample. The stages are named like the stages of the filter chain, and the auxiliary sensors
+ by their names.
+ */
+func NewSampleMessage(sample Sample, stages []string, aux []AuxChannel) SampleMessage {
+    message := SampleMessage{StreamHeader: StreamHeader{SchemaVersion, "sample"}, Time: sample.Time,
+        Value: sample.Value}
+    if len(sample.Stages) > 0 {
+        message.Stages = map[string]float64{}
+        for i, key := range streamKeys(stages) {
+            if i < len(sample.Stages) {
+                message.Stages[key] = sample.Stages[i]
+            }
+        }
+    }
+    if len(sample.Aux) > 0 {
+        message.Aux = map[string]float64{}
+        for i, channel := range aux {
+            // JSON has no NaN, sensors without a value are left out
+            if i < len(sample.Aux) && !math.IsNaN(sample.Aux[i]) {
+                message.Aux[channel.Name] = sample.Aux[i]
+            }
+        }
+    }
+    if sample.Beat != nil && sample.Beat.RR > 0 {
+        message.HeartRate = sample.Beat.HeartRate()
+    }
+    return message
+}
+
+func NewEventMessage(time float64, sample int, event string) EventMessage {
+    return EventMessage{StreamHeader: StreamHeader{SchemaVersion, "event"}, Time: time, Sample: sample, Event: event}
+}
+
+func NewSegmentMessage(segment Segment) SegmentMessage {
+    return SegmentMessage{StreamHeader: StreamHeader{SchemaVersion, "segment"}, Name: segment.Name,
+        Start: segment.Start, End: segment.End, IEMG: segment.IEMG, CoContraction: segment.CoContraction}
+}
+
+/*
+ Returns unique keys for the stages of the filter chain. A stage that appears several times gets a number from the
+ second time on, e.g. highpass, highpass_2.
+ */
+func streamKeys(names []string) []string {
+    keys := make([]string, len(names))
+    seen := map[string]int{}
+    for i, name := range names {
+        seen[name]++
+        keys[i] = name
+        if seen[name] > 1 {
+            keys[i] = fmt.Sprintf("%s_%d", name, seen[name])
+        }
+    }
+    return keys
+}
+
+/*
+ Returns the JSON schema of all messages. It is generated from the structs of the messages, so it can't drift from
+ what is actually sent.
+ */
+func StreamSchema() map[string]interface{} {
+    definitions := map[string]interface{}{}
+    messages := []interface{}{}
+    for _, message := range streamMessages {
+        definition := jsonSchema(reflect.TypeOf(message.Message))
+        properties := definition["properties"].(map[string]interface{})
+        properties["type"] = map[string]interface{}{"const": message.Type}
+        properties["schema"] = map[string]interface{}{"const": SchemaVersion}
+        definitions[message.Type] = definition
+        messages = append(messages, map[string]interface{}{"$ref": "#/$defs/" + message.Type})
+    }
+    return map[string]interface{}{
+        "$schema": "https://json-schema.org/draft/2020-12/schema",
+        "title": fmt.Sprintf("SymnaTEC plot stream, version %d", SchemaVersion),
+        "description": "Every message is one JSON object. A stream starts with a session message.",
+        "version": SchemaVersion,
+        "oneOf": messages,
+        "$defs": definitions,
+    }
+}
+
+/*
+ Describes a type as a JSON schema, using the json and description tags of its fields
+ */
+func jsonSchema(t reflect.Type) map[string]interface{} {
+    switch t.Kind() {
+    case reflect.Struct:
+        if t == reflect.TypeOf(time.Time{}) {
+            return map[string]interface{}{"type": "string", "format": "date-time"}
+        }
+        properties := map[string]interface{}{}
+        required := []string{}
+        var collect func(t reflect.Type)
+        collect = func(t reflect.Type) {
+            for i := 0; i < t.NumField(); i++ {
+                field := t.Field(i)
+                if field.Anonymous {
+                    collect(field.Type)
+                    continue
+                }
+                tag := strings.Split(field.Tag.Get("json"), ",")
+                property := jsonSchema(field.Type)
+                if description := field.Tag.Get("description"); description != "" {
+                    property["description"] = description
+                }
+                properties[tag[0]] = property
+                if len(tag) < 2 || tag[1] != "omitempty" {
+                    required = append(required, tag[0])
+                }
+            }
+        }
+        collect(t)
+        return map[string]interface{}{"type": "object", "properties": properties, "required": required}
+    case reflect.Slice:
+        return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem())}
+    case reflect.Map:
+        return map[string]interface{}{"type": "object", "additionalProperties": jsonSchema(t.Elem())}
+    case reflect.String:
+        return map[string]interface{}{"type": "string"}
+    case reflect.Int:
+        return map[string]interface{}{"type": "integer"}
+    case reflect.Float64:
+        return map[string]interface{}{"type": "number"}
+    }
+    panic("no JSON schema for " + t.String())
+}
+
+/*
+ Prints the JSON schema of the streaming outputs, or a Python client for them (see PythonClient)
+ Example:
+    $ plot schema > stream.schema.json
+    $ plot schema --python > plot_client.py
+ */
+func schemaCommand(args []string) {
+    flags := flag.NewFlagSet("schema", flag.ExitOnError)
+    python := flags.Bool("python", false, "Print a Python module that reads the streams, instead of the schema")
+    flags.Parse(args)
+    if flags.NArg() > 0 {
+        fail("Usage: plot schema [--python]")
+    }
+    if *python {
+        fmt.Print(PythonClient())
+        return
+    }
+    encoder := json.NewEncoder(os.Stdout)
+    encoder.SetIndent("", "    ")
+    err := encoder.Encode(StreamSchema())
+    if err != nil {
+        fail("%v", err)
+    }
+}
@@ -0,0 +1,97 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "io"
+    "fmt"
+    "flag"
+    "time"
+    "path/filepath"
+)
+
+func init() {
+    Commands["scrub"] = scrubCommand
+}
+
+/*
+ Removes identifying information from the metadata of recordings, so they can be shared with collaborators. The free
+ text notes are removed and the start time is reduced to the day of the recording. The pseudonym of the subject is
+ kept, unless --drop-subject is given. With --output, scrubbed copies are written into a directory and the originals
+ stay untouched.
+ Example:
+    $ plot scrub --output=shared/ data.csv
+ */
+func scrubCommand(args []string) {
+    flags := flag.NewFlagSet("scrub", flag.ExitOnError)
+    output := flags.String("output", "", "A directory for scrubbed copies. If empty, the recordings are scrubbed " +
+        "in place.")
+    dropSubject := flags.Bool("drop-subject", false, "Whether the pseudonym of the subject should be removed as well")
+    flags.Parse(args)
+    if flags.NArg() == 0 {
+        fail("Usage: plot scrub [--output=dir] [--drop-subject] <file>...")
+    }
+
+    for _, file := range flags.Args() {
+        meta, err := LoadMetadata(file)
+        if err != nil {
+            fail("%s: %v", file, err)
+        }
+        meta = ScrubMetadata(meta, *dropSubject)
+
+        target := file
+        if *output != "" {
+            target = filepath.Join(*output, filepath.Base(file))
+            for _, suffix := range []string{"", ".sum", ".index"} {
+                err = copyFile(file + suffix, target + suffix)
+                if err != nil && !os.IsNotExist(err) {
+                    fail("%s: %v", file, err)
+                }
+            }
+            err = CopyAnnotations(file, target)
+            if err != nil {
+                fail("%s: %v", file, err)
+            }
+        }
+        err = SaveMetadata(target, meta)
+        if err != nil {
+            fail("%s: %v", file, err)
+        }
+        fmt.Printf("%s: scrubbed\n", target)
+    }
+}
+
+/*
+ Returns a copy of the metadata without identifying information
+ */
+func ScrubMetadata(meta Metadata, dropSubject bool) Metadata {
+    meta.Notes = ""
+    meta.Created = meta.Created.UTC().Truncate(24 * time.Hour)
+    if dropSubject {
+        meta.Subject = ""
+    }
+    return meta
+}
+
+func copyFile(from string, to string) error {
+    in, err := os.Open(from)
+    if err != nil {
+        return err
+    }
+    defer in.Close()
+    out, err := os.Create(to)
+    if err != nil {
+        return err
+    }
+    _, err = io.Copy(out, in)
+    if err != nil {
+        out.Close()
+        return err
+    }
+    return out.Close()
+}
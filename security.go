@@ -0,0 +1,198 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+//go:build !nonet
+
+package main
+
+import (
+    "os"
+    "fmt"
+    "net"
+    "time"
+    "strings"
+    "net/url"
+    "net/http"
+    "crypto/tls"
+    "crypto/x509"
+    "crypto/subtle"
+)
+
+/*
+ The environment variable that the token of the servers can be given in, so it doesn't show up in the process list
+ */
+const tokenVariable = "PLOT_TOKEN"
+
+/*
+ Connects to the host of a URL, using TLS if secure is set. The certificates are given in the query of the URL:
+    ca=ca.pem          Only trust servers signed by this authority, instead of the authorities of the system
+    cert=client.pem    A client certificate for servers that require one, together with
+    key=client.key     its private key
+ */
+func DialURL(target *url.URL, network string, host string, secure bool) (net.Conn, error) {
+    dialer := &net.Dialer{Timeout: 10 * time.Second}
+    if !secure {
+        return dialer.Dial(network, host)
+    }
+    config, err := ClientTLS(target)
+    if err != nil {
+        return nil, err
+    }
+    return tls.DialWithDialer(dialer, network, host, config)
+}
+
+/*
+ Returns the TLS configuration for connecting to the host of a URL, see DialURL
+ */
+func ClientTLS(target *url.URL) (*tls.Config, error) {
+    query := target.Query()
+    config := &tls.Config{ServerName: target.Hostname(), MinVersion: tls.VersionTLS12}
+    if ca := query.Get("ca"); ca != "" {
+        pool, err := loadCertificates(ca)
+        if err != nil {
+            return nil, err
+        }
+        config.RootCAs = pool
+    }
+    cert, key := query.Get("cert"), query.Get("key")
+    if cert != "" || key != "" {
+        if cert == "" || key == "" {
+            return nil, fmt.Errorf("a client certificate needs both cert and key")
+        }
+        certificate, err := tls.LoadX509KeyPair(cert, key)
+        if err != nil {
+            return nil, err
+        }
+        config.Certificates = []tls.Certificate{certificate}
+    }
+    return config, nil
+}
+
+/*
+ Returns the TLS configuration of the servers, or nil if they aren't encrypted. If --tls-ca is given, clients need a
+ certificate of that authority.
+ */
+func ServerTLS() (*tls.Config, error) {
+    if Settings.TLSCert == "" && Settings.TLSKey == "" {
+        if Settings.TLSClientCA != "" {
+            return nil, fmt.Errorf("--tls-ca needs --tls-cert and --tls-key")
+        }
+        return nil, nil
+    }
+    if Settings.TLSCert == "" || Settings.TLSKey == "" {
+        return nil, fmt.Errorf("TLS needs both --tls-cert and --tls-key")
+    }
+    certificate, err := tls.LoadX509KeyPair(Settings.TLSCert, Settings.TLSKey)
+    if err != nil {
+        return nil, err
+    }
+    config := &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12}
+    if Settings.TLSClientCA != "" {
+        pool, err := loadCertificates(Settings.TLSClientCA)
+        if err != nil {
+            return nil, err
+        }
+        config.ClientCAs = pool
+        config.ClientAuth = tls.RequireAndVerifyClientCert
+    }
+    return config, nil
+}
+
+/*
+ Listens for connections of a server, encrypted if the servers have a certificate
+ */
+func Listen(address string) (net.Listener, error) {
+    config, err := ServerTLS()
+    if err != nil {
+        return nil, err
+    }
+    listener, err := net.Listen("tcp", address)
+    if err != nil || config == nil {
+        return listener, err
+    }
+    return tls.NewListener(listener, config), nil
+}
+
+/*
+ Only lets requests through that have the token of the servers (as bearer token or ?token=, for clients that can't
+ set headers, like browsers opening a WebSocket) or their user and password. Without a token and a user, every request
+ is let through.
+ */
+func Authenticated(handler http.Handler) http.Handler {
+    token := Settings.Token
+    if token == "" {
+        token = os.Getenv(tokenVariable)
+    }
+    if token == "" && Settings.BasicAuth == "" {
+        return handler
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if token != "" {
+            given := r.URL.Query().Get("token")
+            if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+                given = strings.TrimPrefix(header, "Bearer ")
+            }
+            if given != "" && equalSecrets(given, token) {
+                handler.ServeHTTP(w, r)
+                return
+            }
+        }
+        if Settings.BasicAuth != "" {
+            user, password, ok := r.BasicAuth()
+            if ok && equalSecrets(user + ":" + password, Settings.BasicAuth) {
+                handler.ServeHTTP(w, r)
+                return
+            }
+            w.Header().Set("WWW-Authenticate", `Basic realm="plot"`)
+        }
+        http.Error(w, "unauthorized", http.StatusUnauthorized)
+    })
+}
+
+/*
+ Whether the servers check who connects, with a token, a user or client certificates
+ */
+func Guarded() bool {
+    return Settings.Token != "" || os.Getenv(tokenVariable) != "" || Settings.BasicAuth != "" ||
+        Settings.TLSClientCA != ""
+}
+
+/*
+ Whether an address only accepts connections from the device itself
+ */
+func LoopbackAddress(address string) bool {
+    host, _, err := net.SplitHostPort(address)
+    if err != nil {
+        return false
+    }
+    if host == "localhost" {
+        return true
+    }
+    ip := net.ParseIP(host)
+    return ip != nil && ip.IsLoopback()
+}
+
+/*
+ Compares secrets in constant time, so their content can't be guessed from how long the comparison takes
+ */
+func equalSecrets(a string, b string) bool {
+    return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+/*
+ Loads the certificates of an authority from a PEM file
+ */
+func loadCertificates(file string) (*x509.CertPool, error) {
+    data, err := os.ReadFile(file)
+    if err != nil {
+        return nil, err
+    }
+    pool := x509.NewCertPool()
+    if !pool.AppendCertsFromPEM(data) {
+        return nil, fmt.Errorf("%s contains no certificates", file)
+    }
+    return pool, nil
+}
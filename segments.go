@@ -0,0 +1,168 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "math"
+    "sync"
+    "strings"
+)
+
+/*
+ A part of the session between two phases of the protocol or markers, for example one repetition of an exercise
+ */
+type Segment struct {
+    Name string
+
+    /*
+     The time of the first and the last sample of the segment
+     */
+    Start float64
+    End float64
+
+    /*
+     The integrated EMG: the integral of the rectified (processed) signal over the segment, in volt seconds
+     */
+    IEMG float64
+
+    /*
+     The co-contraction index of the agonist and the antagonist over the segment, in percent, if it is computed
+     */
+    CoContraction float64
+
+    /*
+     How many seconds of the segment the subject was moving, if an accelerometer is connected
+     */
+    Motion float64
+}
+
+/*
+ Splits the session into segments and accumulates their measures. The samples are added by the goroutine of the
+ source, but a new segment can be started from anywhere, like from a key press or the protocol.
+ */
+type Segmenter struct {
+
+    /*
+     The muscles of the co-contraction index, or nil if it isn't computed
+     */
+    CoContraction *CoContraction
+
+    /*
+     Whether the signal during motion is left out of the iEMG
+     */
+    GateMotion bool
+
+    current Segment
+    started bool
+    previous float64
+    common float64
+    total float64
+
+    // The name of the segment that starts with the next sample, and the measures of the current segment for the
+    // display
+    lock sync.Mutex
+    next *string
+    shown Segment
+}
+
+func NewSegmenter() *Segmenter {
+    return &Segmenter{current: Segment{Name: "start"}}
+}
+
+/*
+ Starts a new segment with the next sample
+ */
+func (s *Segmenter) Split(name string) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    s.next = &name
+}
+
+/*
+ Adds the next sample to the current segment. If this sample starts a new segment, the finished one is returned.
+ */
+func (s *Segmenter) Add(sample Sample) *Segment {
+    var finished *Segment
+    s.lock.Lock()
+    next := s.next
+    s.next = nil
+    s.lock.Unlock()
+    if next != nil {
+        if s.started {
+            done := s.current
+            finished = &done
+        }
+        s.current = Segment{Name: *next}
+        s.started = false
+        s.common = 0
+        s.total = 0
+    }
+
+    if !s.started {
+        s.current.Start = sample.Time
+        s.started = true
+    } else {
+        // Integrate using the trapezoidal rule. The motion is counted from one sample to the next.
+        if !sample.Motion || !s.GateMotion {
+            s.current.IEMG += (math.Abs(sample.Processed()) + math.Abs(s.previous)) / 2 *
+                (sample.Time - s.current.End)
+        }
+        if sample.Motion {
+            s.current.Motion += sample.Time - s.current.End
+        }
+    }
+    s.current.End = sample.Time
+    s.previous = sample.Processed()
+    if s.CoContraction != nil {
+        agonist, antagonist := s.CoContraction.Values(sample.Processed(), sample.Aux)
+        if !math.IsNaN(agonist + antagonist) {
+            s.common += math.Min(agonist, antagonist)
+            s.total += agonist + antagonist
+            s.current.CoContraction = CoContractionIndex(s.common, s.total)
+        }
+    }
+
+    s.lock.Lock()
+    s.shown = s.current
+    s.lock.Unlock()
+    return finished
+}
+
+/*
+ Ends the current segment and returns it, or nil if it has no samples
+ */
+func (s *Segmenter) Finish() *Segment {
+    if !s.started {
+        return nil
+    }
+    done := s.current
+    s.started = false
+    return &done
+}
+
+/*
+ Returns the current segment. This can be called from any goroutine.
+ */
+func (s *Segmenter) Current() Segment {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    return s.shown
+}
+
+/*
+ Returns the segment that an event starts: the phases of the protocol start a segment named like the phase, markers
+ one named like the marker. Returns false for other events.
+ */
+func EventSegment(event string) (string, bool) {
+    if strings.HasPrefix(event, "phase ") {
+        return strings.TrimPrefix(event, "phase "), true
+    }
+    if strings.HasPrefix(event, "marker ") {
+        return event, true
+    }
+    return "", false
+}
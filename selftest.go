@@ -0,0 +1,235 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "fmt"
+    "flag"
+    "math"
+    "sort"
+    "time"
+    "embed"
+    "regexp"
+    "strings"
+    "strconv"
+    "path/filepath"
+)
+
+/*
+ The outputs of the self test as they are expected, built into the program
+ */
+//go:embed selftest
+var goldenFiles embed.FS
+
+/*
+ The session of the self test: one cycle of the synthetic signal (6 seconds at 250 Hz), with a segment of rest and
+ one with the contraction
+ */
+const (
+    selftestRate = 250
+    selftestSamples = 6 * selftestRate
+    selftestSeed = 2017
+    selftestFilter = "highpass:20,notch:50,rectify,rms:0.1"
+)
+
+/*
+ Checks a build of the program from end to end, like after it was built on a new image of the Pi. The synthetic
+ signal is pushed through the processing stages into a recording and into the file sinks, the same way every time,
+ and every file that is written is compared with the golden file of the same name. The checksums of the recording
+ are verified instead. This isn't a command, since it is only of interest to whoever builds the program:
+    $ plot --selftest
+ Changes to the outputs are accepted by writing them into the golden files, and building the program again:
+    $ plot --selftest --update=selftest
+ */
+func selftestCommand(args []string) {
+    flags := flag.NewFlagSet("selftest", flag.ExitOnError)
+    update := flags.String("update", "", "Write the outputs into this directory as the new golden files instead of " +
+        "comparing them")
+    keep := flags.Bool("keep", false, "Keep the outputs and print where they are")
+    flags.Parse(args)
+    if flags.NArg() != 0 {
+        fail("Usage: plot --selftest [--update=directory] [--keep]")
+    }
+    directory, err := os.MkdirTemp("", "plot-selftest")
+    if err != nil {
+        fail("%v", err)
+    }
+    if *keep {
+        fmt.Printf("The outputs are kept in %s\n", directory)
+    } else {
+        defer os.RemoveAll(directory)
+    }
+    err = selftestSession(directory)
+    if err != nil {
+        fail("%v", err)
+    }
+
+    // The checksums of the recordings are verified, since they change with every digit
+    entries, err := os.ReadDir(directory)
+    if err != nil {
+        fail("%v", err)
+    }
+    problems, outputs := []string{}, []string{}
+    for _, entry := range entries {
+        name := entry.Name()
+        if strings.HasSuffix(name, ".sum") {
+            damaged, err := VerifyRecording(filepath.Join(directory, strings.TrimSuffix(name, ".sum")))
+            if err != nil {
+                fail("%v", err)
+            }
+            for _, problem := range damaged {
+                problems = append(problems, fmt.Sprintf("%s: %s", name, problem))
+            }
+            continue
+        }
+        outputs = append(outputs, name)
+    }
+    if *update != "" {
+        selftestUpdate(directory, outputs, *update)
+        return
+    }
+
+    for _, name := range outputs {
+        actual, err := os.ReadFile(filepath.Join(directory, name))
+        if err != nil {
+            fail("%v", err)
+        }
+        expected, err := goldenFiles.ReadFile("selftest/" + name)
+        if err != nil {
+            problems = append(problems, fmt.Sprintf("%s: there is no golden file", name))
+            continue
+        }
+        if difference := compareOutput(string(expected), string(actual)); difference != "" {
+            problems = append(problems, fmt.Sprintf("%s: %s", name, difference))
+        }
+    }
+    golden, _ := goldenFiles.ReadDir("selftest")
+    for _, entry := range golden {
+        if _, err := os.Stat(filepath.Join(directory, entry.Name())); os.IsNotExist(err) {
+            problems = append(problems, fmt.Sprintf("%s: wasn't written", entry.Name()))
+        }
+    }
+    if len(problems) > 0 {
+        for _, problem := range problems {
+            fmt.Fprintln(os.Stderr, problem)
+        }
+        fail("The self test failed: %d problems", len(problems))
+    }
+    fmt.Printf("The self test passed: %d outputs match, the checksums are intact\n", len(outputs))
+}
+
+/*
+ Records the session of the self test into the directory, with a sink that takes every message and one that takes
+ aggregated samples
+ */
+func selftestSession(directory string) error {
+    Settings.Interval = 1.0 / selftestRate
+    Settings.Filter = selftestFilter
+    Settings.RecordStages = "columns"
+    pipeline := NewPipeline()
+    setupFilters(pipeline)
+
+    // The time of the session is fixed, since it is written into the outputs
+    meta := Metadata{Created: time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC), Interval: Settings.Interval,
+        Subject: "selftest", Filters: Settings.Filter, Mode: "emg", Source: "demo://?rate=250", Timing: "synthetic"}
+    pipeline.SetStarted(meta.Created)
+    recording, err := NewRecording(filepath.Join(directory, "selftest.csv"), meta, pipeline.Filters,
+        Settings.RecordStages)
+    if err != nil {
+        return err
+    }
+    pipeline.Record(recording)
+    sinks, err := OpenSinks([]string{"file:" + filepath.Join(directory, "stream.jsonl"),
+        "file:" + filepath.Join(directory, "stream.rate.jsonl") + "?rate=10&aggregate=rms"})
+    if err != nil {
+        pipeline.Close()
+        return err
+    }
+    stream, err := NewStream(sinks, meta, pipeline.Filters.Names, false)
+    if err != nil {
+        stream.Close()
+        pipeline.Close()
+        return err
+    }
+    pipeline.StreamTo(stream)
+
+    signal := NewSyntheticEMG(Settings.Interval, selftestSeed)
+    for x := 0; x < selftestSamples; x++ {
+        t := float64(x) * Settings.Interval
+        if x % (selftestSamples / 2) == 0 {
+            name := []string{"rest", "contraction"}[x * 2 / selftestSamples]
+            pipeline.EventWithNext("phase " + name, name)
+        }
+        pipeline.Push(Sample{Index: x, Time: t, Value: signal.Next()})
+    }
+    pipeline.Close()
+    if pipeline.RecordingError() != nil {
+        return pipeline.RecordingError()
+    }
+    return stream.Err()
+}
+
+/*
+ Replaces the golden files with the outputs
+ */
+func selftestUpdate(directory string, outputs []string, golden string) {
+    old, err := filepath.Glob(filepath.Join(golden, "*"))
+    if err != nil {
+        fail("%v", err)
+    }
+    for _, file := range old {
+        os.Remove(file)
+    }
+    err = os.MkdirAll(golden, 0755)
+    if err != nil {
+        fail("%v", err)
+    }
+    sort.Strings(outputs)
+    for _, name := range outputs {
+        data, err := os.ReadFile(filepath.Join(directory, name))
+        if err == nil {
+            err = os.WriteFile(filepath.Join(golden, name), data, 0644)
+        }
+        if err != nil {
+            fail("%v", err)
+        }
+        fmt.Printf("Updated %s\n", filepath.Join(golden, name))
+    }
+}
+
+/*
+ The numbers in the outputs
+ */
+var outputNumber = regexp.MustCompile(`-?[0-9]+(\.[0-9]+)?([eE][-+]?[0-9]+)?`)
+
+/*
+ Compares an output with its golden file, and describes the first difference, or returns an empty string if they
+ match. Numbers only have to match up to their last digits, since processors that fuse multiplications and additions
+ (like the one of the Pi 4) round a bit differently, which can also move the offsets in the recording by a few bytes.
+ */
+func compareOutput(expected string, actual string) string {
+    expectedLines, actualLines := strings.Split(expected, "\n"), strings.Split(actual, "\n")
+    if len(expectedLines) != len(actualLines) {
+        return fmt.Sprintf("%d lines instead of %d", len(actualLines), len(expectedLines))
+    }
+    for i := range expectedLines {
+        want, got := expectedLines[i], actualLines[i]
+        wantNumbers, gotNumbers := outputNumber.FindAllString(want, -1), outputNumber.FindAllString(got, -1)
+        same := len(wantNumbers) == len(gotNumbers) &&
+            outputNumber.ReplaceAllString(want, "#") == outputNumber.ReplaceAllString(got, "#")
+        for j := 0; same && j < len(wantNumbers); j++ {
+            a, _ := strconv.ParseFloat(wantNumbers[j], 64)
+            b, _ := strconv.ParseFloat(gotNumbers[j], 64)
+            same = math.Abs(a - b) <= 1e-6 + 1e-4 * math.Max(math.Abs(a), math.Abs(b))
+        }
+        if !same {
+            return fmt.Sprintf("line %d is %q instead of %q", i + 1, got, want)
+        }
+    }
+    return ""
+}
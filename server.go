@@ -0,0 +1,342 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+//go:build !nonet
+
+package main
+
+import (
+    "io"
+    "fmt"
+    "net"
+    "math"
+    "sync"
+    "time"
+    "strconv"
+    "net/http"
+    "path/filepath"
+    "sync/atomic"
+    "encoding/json"
+)
+
+/*
+ Serves the session to remote viewers (see "plot view") and other programs over HTTP, enabled with --serve:
+    GET  /stream    The session as JSON lines in the wire schema (see "plot schema"), starting with the session
+    POST /control   Carries out a command like the keys of the display, see Pipeline.Control
+    GET  /status    The state of the session as JSON
+    GET  /metrics   The state of the session and of the system in the text format of Prometheus
+    GET  /snapshot  The last seconds of the signal in one response, see snapshot
+ The server is a sink of the stream, so it never holds up the acquisition. Every client has its own buffer, and a
+ client that can't keep up loses messages without affecting the others. The server is secured with the TLS and
+ authentication settings (see Listen and Authenticated).
+ */
+type Server struct {
+    pipeline *Pipeline
+    listener net.Listener
+    session interface{}
+    clients map[chan interface{}]bool
+    lock sync.Mutex
+    dropped uint64
+
+    // The samples of the last seconds, for /snapshot
+    history []SampleMessage
+}
+
+/*
+ The state of the session, as returned by /status
+ */
+type ServerStatus struct {
+    State string `json:"state"`
+    File string `json:"file,omitempty"`
+    Elapsed float64 `json:"elapsed"`
+    Stalled bool `json:"stalled"`
+    SignalLost bool `json:"signal_lost"`
+    Class string `json:"class,omitempty"`
+    Confidence float64 `json:"confidence,omitempty"`
+    Pushed uint64 `json:"pushed"`
+    Dropped uint64 `json:"dropped"`
+    CPU float64 `json:"cpu"`
+    Temperature *float64 `json:"temperature,omitempty"`
+    Throttled []string `json:"throttled,omitempty"`
+}
+
+/*
+ Starts serving the session of the pipeline on the given address, like :8080
+ */
+func NewServer(pipeline *Pipeline, address string) (*Server, error) {
+    listener, err := Listen(address)
+    if err != nil {
+        return nil, err
+    }
+    server := &Server{pipeline: pipeline, listener: listener, clients: map[chan interface{}]bool{}}
+    mux := http.NewServeMux()
+    mux.HandleFunc("/stream", server.stream)
+    mux.HandleFunc("/control", server.control)
+    mux.HandleFunc("/status", server.status)
+    mux.HandleFunc("/metrics", server.metrics)
+    mux.HandleFunc("/snapshot", server.snapshot)
+    go http.Serve(listener, Authenticated(mux))
+    return server, nil
+}
+
+/*
+ Hands a message of the stream to every client
+ */
+func (s *Server) Write(message interface{}) error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    if _, ok := message.(SessionMessage); ok {
+        s.session = message
+    }
+    if sample, ok := message.(SampleMessage); ok {
+        s.history = append(s.history, sample)
+        start := 0
+        for start < len(s.history) && s.history[start].Time < sample.Time - Settings.Snapshot {
+            start++
+        }
+        s.history = s.history[start:]
+    }
+    for client := range s.clients {
+        select {
+        case client <- message:
+        default:
+            atomic.AddUint64(&s.dropped, 1)
+        }
+    }
+    return nil
+}
+
+/*
+ Stops the server once the session has ended. The clients get the remaining messages.
+ */
+func (s *Server) Close() error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    for client := range s.clients {
+        close(client)
+        delete(s.clients, client)
+    }
+    return s.listener.Close()
+}
+
+func (s *Server) stream(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    // Clients that connect late start with the description of the session
+    client := make(chan interface{}, streamBuffer)
+    s.lock.Lock()
+    if s.session != nil {
+        client <- s.session
+    }
+    s.clients[client] = true
+    s.lock.Unlock()
+    defer s.unsubscribe(client)
+
+    w.Header().Set("Content-Type", "application/x-ndjson")
+    flusher, _ := w.(http.Flusher)
+    encoder := json.NewEncoder(w)
+    for {
+        select {
+        case message, ok := <-client:
+            if !ok {
+                return
+            }
+            err := encoder.Encode(message)
+            if err != nil {
+                return
+            }
+
+            // Send whatever is waiting at once
+            if flusher != nil && len(client) == 0 {
+                flusher.Flush()
+            }
+        case <-r.Context().Done():
+            return
+        }
+    }
+}
+
+func (s *Server) unsubscribe(client chan interface{}) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    if s.clients[client] {
+        delete(s.clients, client)
+        close(client)
+    }
+}
+
+func (s *Server) control(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    err := s.pipeline.Control(r.FormValue("command"))
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+    _, elapsed := s.pipeline.LastSample()
+    status := ServerStatus{State: SessionState(s.pipeline), Elapsed: elapsed, Stalled: s.pipeline.Stalled(),
+        SignalLost: s.pipeline.Signal.Lost(), Pushed: s.pipeline.Pushed(), Dropped: atomic.LoadUint64(&s.dropped)}
+    if Settings.File != "" {
+        status.File = filepath.Base(Settings.File)
+    }
+    if prediction, ok := s.pipeline.Classifier.Current(); ok {
+        status.Class, status.Confidence = prediction.Class, prediction.Confidence
+    }
+    if s.pipeline.Telemetry != nil {
+        telemetry := s.pipeline.Telemetry.Current()
+        status.CPU = telemetry.CPU
+        status.Throttled = telemetry.Throttling()
+        if !math.IsNaN(telemetry.Temperature) {
+            status.Temperature = &telemetry.Temperature
+        }
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(status)
+}
+
+/*
+ Returns the last seconds of the signal as a table (see SampleTable), for notebooks that want a quick look without
+ subscribing to the stream:
+    GET /snapshot?seconds=10&format=csv     The format is csv (the default), json or arrow
+ The server keeps the seconds of --snapshot, so a longer snapshot returns what is there.
+ */
+func (s *Server) snapshot(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    seconds := Settings.Snapshot
+    if value := r.URL.Query().Get("seconds"); value != "" {
+        parsed, err := strconv.ParseFloat(value, 64)
+        if err != nil || parsed <= 0 {
+            http.Error(w, "seconds must be a positive number", http.StatusBadRequest)
+            return
+        }
+        seconds = parsed
+    }
+    format := r.URL.Query().Get("format")
+    if format == "" {
+        format = "csv"
+    }
+    if _, ok := TableFormats[format]; !ok {
+        http.Error(w, "unknown format, expected csv, json or arrow", http.StatusBadRequest)
+        return
+    }
+
+    // The samples are copied, so the stream isn't held up while they are encoded
+    s.lock.Lock()
+    session, _ := s.session.(SessionMessage)
+    start := len(s.history)
+    for start > 0 && s.history[start - 1].Time >= s.history[len(s.history) - 1].Time - seconds {
+        start--
+    }
+    samples := append([]SampleMessage{}, s.history[start:]...)
+    s.lock.Unlock()
+    w.Header().Set("Content-Type", TableFormats[format])
+    NewSampleTable(session, samples).Write(w, format)
+}
+
+func (s *Server) metrics(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+    _, elapsed := s.pipeline.LastSample()
+    metric(w, "plot_samples_total", "counter", "Samples since the start of the session", float64(s.pipeline.Pushed()))
+    metric(w, "plot_display_dropped_total", "counter", "Samples the display couldn't keep up with",
+        float64(s.pipeline.Dropped()))
+    metric(w, "plot_stream_dropped_total", "counter", "Messages the viewers couldn't keep up with",
+        float64(atomic.LoadUint64(&s.dropped)))
+    metric(w, "plot_elapsed_seconds", "gauge", "The time of the last sample", elapsed)
+    metric(w, "plot_stalled", "gauge", "Whether the source stopped delivering samples",
+        boolMetric(s.pipeline.Stalled()))
+    metric(w, "plot_signal_lost", "gauge", "Whether the electrodes lost contact (see --signal-loss)",
+        boolMetric(s.pipeline.Signal.Lost()))
+    metric(w, "plot_recording_failed", "gauge", "Whether the recording failed",
+        boolMetric(s.pipeline.RecordingError() != nil))
+    fmt.Fprintf(w, "# HELP plot_state The state of the session\n# TYPE plot_state gauge\nplot_state{state=%q} 1\n",
+        SessionState(s.pipeline))
+    if s.pipeline.Telemetry != nil {
+        telemetry := s.pipeline.Telemetry.Current()
+        metric(w, "plot_cpu_percent", "gauge", "The load of the CPUs", telemetry.CPU)
+        if !math.IsNaN(telemetry.Temperature) {
+            metric(w, "plot_temperature_celsius", "gauge", "The temperature of the SoC", telemetry.Temperature)
+        }
+        metric(w, "plot_throttled", "gauge", "Whether the system is throttled",
+            boolMetric(len(telemetry.Throttling()) > 0))
+        metric(w, "plot_throttled_flags", "gauge", "The throttling flags of the firmware", float64(telemetry.Throttled))
+    }
+    fmt.Fprintf(w, "# HELP plot_latency_seconds The time from the acquisition of the latest samples through a stage\n")
+    fmt.Fprintf(w, "# TYPE plot_latency_seconds summary\n")
+    for _, stage := range LatencyStages {
+        if percentiles, ok := s.pipeline.Latency.Percentiles(stage); ok {
+            for _, quantile := range []struct {
+                name string
+                value time.Duration
+            }{{"0.5", percentiles.P50}, {"0.95", percentiles.P95}, {"0.99", percentiles.P99}, {"1", percentiles.Max}} {
+                fmt.Fprintf(w, "plot_latency_seconds{stage=%q,quantile=%q} %g\n", stage, quantile.name,
+                    quantile.value.Seconds())
+            }
+        }
+    }
+    sinks := s.pipeline.SinkHealth()
+    if len(sinks) > 0 {
+        fmt.Fprintf(w, "# HELP plot_sink_healthy Whether a sink takes the messages of the session\n")
+        fmt.Fprintf(w, "# TYPE plot_sink_healthy gauge\n")
+        for _, health := range sinks {
+            fmt.Fprintf(w, "plot_sink_healthy{sink=%q} %g\n", health.Name, boolMetric(health.Healthy))
+        }
+        fmt.Fprintf(w, "# HELP plot_sink_queued The messages that wait for a sink\n# TYPE plot_sink_queued gauge\n")
+        for _, health := range sinks {
+            fmt.Fprintf(w, "plot_sink_queued{sink=%q} %d\n", health.Name, health.Queued)
+        }
+        fmt.Fprintf(w, "# HELP plot_sink_dropped_total Messages a sink couldn't keep up with, or failed to take\n")
+        fmt.Fprintf(w, "# TYPE plot_sink_dropped_total counter\n")
+        for _, health := range sinks {
+            fmt.Fprintf(w, "plot_sink_dropped_total{sink=%q} %d\n", health.Name, health.Dropped)
+        }
+        fmt.Fprintf(w, "# HELP plot_sink_failures_total Failed attempts to write into a sink\n")
+        fmt.Fprintf(w, "# TYPE plot_sink_failures_total counter\n")
+        for _, health := range sinks {
+            fmt.Fprintf(w, "plot_sink_failures_total{sink=%q} %d\n", health.Name, health.Failures)
+        }
+    }
+    if s.pipeline.Disk != nil {
+        metric(w, "plot_disk_free_bytes", "gauge", "The free space for the recording", float64(s.pipeline.Disk.Free()))
+    }
+    if s.pipeline.Battery != nil {
+        if level, ok := s.pipeline.Battery.Level(); ok {
+            metric(w, "plot_battery_percent", "gauge", "The charge of the battery of the UPS", level)
+        }
+    }
+}
+
+/*
+ Writes a metric with its description
+ */
+func metric(w io.Writer, name string, kind string, help string, value float64) {
+    fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", name, help, name, kind, name, value)
+}
+
+func boolMetric(value bool) float64 {
+    if value {
+        return 1
+    }
+    return 0
+}
+
+/*
+ Names the viewers in the health of the sinks
+ */
+func (s *Server) String() string {
+    return "viewers"
+}
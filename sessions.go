@@ -0,0 +1,223 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "fmt"
+    "flag"
+    "sort"
+    "time"
+    "strings"
+    "path/filepath"
+)
+
+func init() {
+    Commands["sessions"] = sessionsCommand
+}
+
+/*
+ A recording, as found in the session store
+ */
+type Session struct {
+    File string
+    Meta Metadata
+}
+
+/*
+ Finds, lists and tags recorded sessions. The session store is the metadata of the recordings, so there is no
+ database that could get out of sync with the files; every recording below the directory is part of it.
+ Examples:
+    $ plot sessions list
+    $ plot sessions find --tag=biceps --after=2024-01-01
+    $ plot sessions find --text=surgery --dir=/data
+    $ plot sessions tag data.csv biceps post-surgery
+    $ plot sessions untag data.csv post-surgery
+ */
+func sessionsCommand(args []string) {
+    usage := "Usage: plot sessions list|find [--dir=dir] [--tag=tag] [--subject=pseudonym] [--text=query] " +
+        "[--after=date] [--before=date]\n       plot sessions tag|untag <file> <tag>..."
+    if len(args) == 0 {
+        fail(usage)
+    }
+    switch args[0] {
+    case "list", "find":
+        flags := flag.NewFlagSet("sessions", flag.ExitOnError)
+        dir := flags.String("dir", ".", "The directory that is searched for recordings")
+        tag := flags.String("tag", "", "Only sessions with this tag")
+        subject := flags.String("subject", "", "Only sessions of the subject with this pseudonym")
+        text := flags.String("text", "", "Only sessions whose file, subject, tags or notes contain the text")
+        after := flags.String("after", "", "Only sessions recorded on or after this date (YYYY-MM-DD)")
+        before := flags.String("before", "", "Only sessions recorded before this date (YYYY-MM-DD)")
+        flags.Parse(args[1:])
+
+        sessions, err := FindSessions(*dir)
+        if err != nil {
+            fail("%v", err)
+        }
+        filter := SessionFilter{Tag: *tag, Subject: *subject, Text: *text}
+        filter.After, err = parseDate(*after)
+        if err != nil {
+            fail("%v", err)
+        }
+        filter.Before, err = parseDate(*before)
+        if err != nil {
+            fail("%v", err)
+        }
+        for _, session := range sessions {
+            if !filter.Matches(session) {
+                continue
+            }
+            fmt.Printf("%s   %s   %-8s   %s", session.Meta.Created.Format("2006-01-02 15:04"), session.File,
+                session.Meta.Subject, strings.Join(session.Meta.Tags, ","))
+            if session.Meta.Quality != nil && session.Meta.Quality.Low() {
+                fmt.Printf("   LOW QUALITY (%s)", session.Meta.Quality)
+            }
+            if session.Meta.Pruned != nil {
+                fmt.Printf("   PRUNED")
+            }
+            fmt.Println()
+        }
+    case "tag", "untag":
+        if len(args) < 3 {
+            fail(usage)
+        }
+        meta, err := LoadMetadata(args[1])
+        if err != nil {
+            fail("%s: %v", args[1], err)
+        }
+        for _, tag := range args[2:] {
+            if args[0] == "tag" {
+                meta.Tags = addTag(meta.Tags, tag)
+            } else {
+                meta.Tags = removeTag(meta.Tags, tag)
+            }
+        }
+        err = SaveMetadata(args[1], meta)
+        if err != nil {
+            fail("%s: %v", args[1], err)
+        }
+        fmt.Printf("%s: %s\n", args[1], strings.Join(meta.Tags, ","))
+    default:
+        fail(usage)
+    }
+}
+
+/*
+ Finds every recording below a directory, ordered by the time it was recorded
+ */
+func FindSessions(dir string) ([]Session, error) {
+    sessions := []Session{}
+    err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+        if err != nil || info.IsDir() || !strings.HasSuffix(path, ".meta") {
+            return err
+        }
+        file := strings.TrimSuffix(path, ".meta")
+        meta, err := LoadMetadata(file)
+        if err != nil {
+            return fmt.Errorf("%s: %v", path, err)
+        }
+
+        // Stages that were recorded into their own files belong to the session of the raw signal
+        if meta.Stage != "" {
+            return nil
+        }
+        sessions = append(sessions, Session{File: file, Meta: meta})
+        return nil
+    })
+    sort.Slice(sessions, func(i int, j int) bool {
+        return sessions[i].Meta.Created.Before(sessions[j].Meta.Created)
+    })
+    return sessions, err
+}
+
+/*
+ The criteria of a search for sessions. Empty criteria match every session.
+ */
+type SessionFilter struct {
+    Tag string
+    Subject string
+    Text string
+    After time.Time
+    Before time.Time
+}
+
+func (f SessionFilter) Matches(session Session) bool {
+    meta := session.Meta
+    if f.Tag != "" && !hasTag(meta.Tags, f.Tag) {
+        return false
+    }
+    if f.Subject != "" && meta.Subject != f.Subject {
+        return false
+    }
+    if !f.After.IsZero() && meta.Created.Before(f.After) {
+        return false
+    }
+    if !f.Before.IsZero() && !meta.Created.Before(f.Before) {
+        return false
+    }
+    if f.Text != "" {
+        haystack := strings.ToLower(strings.Join(append([]string{session.File, meta.Subject, meta.Notes},
+            meta.Tags...), "\n"))
+        if !strings.Contains(haystack, strings.ToLower(f.Text)) {
+            return false
+        }
+    }
+    return true
+}
+
+/*
+ Parses a date in local time. An empty string is the zero time.
+ */
+func parseDate(date string) (time.Time, error) {
+    if date == "" {
+        return time.Time{}, nil
+    }
+    return time.ParseInLocation("2006-01-02", date, time.Local)
+}
+
+func hasTag(tags []string, tag string) bool {
+    for _, t := range tags {
+        if t == tag {
+            return true
+        }
+    }
+    return false
+}
+
+func addTag(tags []string, tag string) []string {
+    if hasTag(tags, tag) {
+        return tags
+    }
+    return append(tags, tag)
+}
+
+func removeTag(tags []string, tag string) []string {
+    result := []string{}
+    for _, t := range tags {
+        if t != tag {
+            result = append(result, t)
+        }
+    }
+    return result
+}
+
+/*
+ A list of tags that can be given on the command line, several times or separated by commas
+ */
+type Tags []string
+
+func (t *Tags) String() string {
+    return strings.Join(*t, ",")
+}
+
+func (t *Tags) Set(value string) error {
+    for _, tag := range strings.Split(value, ",") {
+        *t = addTag(*t, strings.TrimSpace(tag))
+    }
+    return nil
+}
@@ -0,0 +1,132 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "math"
+    "unsafe"
+    "sync/atomic"
+    "encoding/binary"
+)
+
+/*
+ The magic at the start of the shared memory, followed by the version of its layout
+ */
+const (
+    sharedMagic = "PLOTRING"
+    sharedVersion = 1
+    sharedHeaderSize = 64
+)
+
+/*
+ How many samples the shared memory holds by default, which is about two minutes at 500 Hz
+ */
+const sharedCapacity = 65536
+
+/*
+ The flags in the header of the shared memory
+ */
+const sharedEnded = 1
+
+/*
+ Shares the samples with processes on the same device through a ring buffer in POSIX shared memory (/dev/shm/<name>),
+ so they can read them at the full rate without a socket or JSON in between. Everything is little endian, and the
+ offsets are in bytes. The header:
+    0   [8]byte     "PLOTRING"
+    8   uint32      The version of the layout, 1
+    12  uint32      The size of the header, 64
+    16  uint32      The size of a slot
+    20  uint32      How many slots the ring has
+    24  uint32      How many auxiliary sensors every slot has
+    28  uint32      Flags: 1 once the session has ended
+    32  float64     The seconds between two samples, 0 until the source knows them
+    40  uint64      How many samples were written so far
+ The sample n, counted from 0, is in the slot n % slots, which starts at 64 + (n % slots) * size:
+    0   uint64      The sequence n + 1 once the slot is written, 0 while it is written
+    8   float64     The time in seconds since the start of the acquisition
+    16  float64     The raw signal in volts
+    24  float64     The processed signal in volts
+    32  float64...  The auxiliary sensors in their units, NaN without a value
+ A reader remembers the next sample it wants, and compares it with the count of the header. If the count is ahead by
+ more than the slots, the reader fell behind and the samples in between are lost. To read a slot, it reads the
+ sequence, the values and the sequence again. The values are valid if both sequences are n + 1, otherwise the slot
+ was overwritten meanwhile. Every field is written as a whole with an atomic store, and the count is increased after
+ the slot is complete.
+ The shared memory is left behind when the session ends, with the flag set, so the readers see the last samples. The
+ next session with the same name replaces it.
+ */
+type SharedRing struct {
+    data []byte
+    unmap func() error
+    slots uint64
+    size uint64
+    aux int
+    written uint64
+}
+
+/*
+ Creates the shared memory with the given name, for samples with the given number of auxiliary sensors
+ */
+func NewSharedRing(name string, slots int, aux int) (*SharedRing, error) {
+    size := 32 + 8 * aux
+    data, unmap, err := mapSharedMemory(name, sharedHeaderSize + slots * size)
+    if err != nil {
+        return nil, err
+    }
+    ring := &SharedRing{data: data, unmap: unmap, slots: uint64(slots), size: uint64(size), aux: aux}
+    copy(data, sharedMagic)
+    binary.LittleEndian.PutUint32(data[8:], sharedVersion)
+    binary.LittleEndian.PutUint32(data[12:], sharedHeaderSize)
+    binary.LittleEndian.PutUint32(data[16:], uint32(size))
+    binary.LittleEndian.PutUint32(data[20:], uint32(slots))
+    binary.LittleEndian.PutUint32(data[24:], uint32(aux))
+    return ring, nil
+}
+
+/*
+ Sets the interval between two samples, once the source knows it
+ */
+func (r *SharedRing) Start(interval float64) {
+    r.store(32, math.Float64bits(interval))
+}
+
+/*
+ Writes the next sample into its slot
+ */
+func (r *SharedRing) Write(sample Sample) {
+    slot := sharedHeaderSize + (r.written % r.slots) * r.size
+    r.store(slot, 0)
+    r.store(slot + 8, math.Float64bits(sample.Time))
+    r.store(slot + 16, math.Float64bits(sample.Value))
+    r.store(slot + 24, math.Float64bits(sample.Processed()))
+    for i := 0; i < r.aux; i++ {
+        value := math.NaN()
+        if i < len(sample.Aux) {
+            value = sample.Aux[i]
+        }
+        r.store(slot + 32 + uint64(i) * 8, math.Float64bits(value))
+    }
+    r.written++
+    r.store(slot, r.written)
+    r.store(40, r.written)
+}
+
+/*
+ Marks the session as ended, and unmaps the shared memory
+ */
+func (r *SharedRing) Close() error {
+    atomic.StoreUint32((*uint32)(unsafe.Pointer(&r.data[28])), sharedEnded)
+    return r.unmap()
+}
+
+/*
+ Stores a field of the shared memory with an atomic store, so the readers never see half of it. The offsets are
+ multiples of 8 and the mapping starts at a page, so the field is aligned.
+ */
+func (r *SharedRing) store(offset uint64, value uint64) {
+    atomic.StoreUint64((*uint64)(unsafe.Pointer(&r.data[offset])), value)
+}
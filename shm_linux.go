@@ -0,0 +1,33 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "syscall"
+    "path/filepath"
+)
+
+/*
+ Creates a file of the given size in /dev/shm, which is POSIX shared memory on Linux (shm_open), and maps it
+ */
+func mapSharedMemory(name string, size int) ([]byte, func() error, error) {
+    file, err := os.OpenFile(filepath.Join("/dev/shm", name), os.O_RDWR | os.O_CREATE | os.O_TRUNC, 0644)
+    if err != nil {
+        return nil, nil, err
+    }
+    defer file.Close()
+    err = file.Truncate(int64(size))
+    if err != nil {
+        return nil, nil, err
+    }
+    data, err := syscall.Mmap(int(file.Fd()), 0, size, syscall.PROT_READ | syscall.PROT_WRITE, syscall.MAP_SHARED)
+    if err != nil {
+        return nil, nil, err
+    }
+    return data, func() error { return syscall.Munmap(data) }, nil
+}
@@ -0,0 +1,20 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+//go:build !linux
+
+package main
+
+import (
+    "errors"
+)
+
+/*
+ Shared memory is only supported on Linux
+ */
+func mapSharedMemory(name string, size int) ([]byte, func() error, error) {
+    return nil, nil, errors.New("shared memory is only supported on Linux")
+}
@@ -0,0 +1,115 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "math"
+    "sync/atomic"
+)
+
+/*
+ How many seconds the signal has to be back before the loss counts as over, so an electrode that only touches the
+ skin now and then doesn't toggle the recording with every touch
+ */
+const signalReturn = 1.0
+
+/*
+ Notices that the signal was lost, like when an electrode fell off. Without contact, the input either drifts to one of
+ the limits of the ADC (lead-off), or the signal stays flat. The signal counts as lost once the raw signal was at the
+ limits, or varied by less than the flat-line voltage, for the given seconds. It is back once it was neither for
+ signalReturn seconds.
+ */
+type SignalMonitor struct {
+    after float64
+    flat float64
+    clip float64
+
+    /*
+     Whether the recording is paused while the signal is lost (see Pipeline.Push)
+     */
+    Pause bool
+
+    // Since when the signal is at the limits, and since when it stays in a range of the flat-line voltage, NaN if it
+    // isn't
+    railed float64
+    flatSince float64
+    low float64
+    high float64
+
+    // Since when the signal is back while it counts as lost, NaN if it isn't, and since when it was lost
+    returned float64
+    lostAt float64
+    lost int32
+}
+
+/*
+ Creates a monitor that considers the signal lost after the given seconds, or returns nil if that is zero. The clipping
+ voltage is zero if the limits of the ADC aren't known.
+ */
+func NewSignalMonitor(after float64, flat float64, clip float64) *SignalMonitor {
+    if after <= 0 {
+        return nil
+    }
+    return &SignalMonitor{after: after, flat: flat, clip: clip, railed: math.NaN(), flatSince: math.NaN(),
+        returned: math.NaN()}
+}
+
+/*
+ Adds the raw voltage of the next sample, and returns whether the signal is lost, and whether this changed with the
+ sample. The description tells what happened.
+ */
+func (m *SignalMonitor) Process(time float64, value float64) (bool, bool, string) {
+    if m.clip > 0 && math.Abs(value) >= m.clip {
+        if math.IsNaN(m.railed) {
+            m.railed = time
+        }
+    } else {
+        m.railed = math.NaN()
+    }
+    if math.IsNaN(m.flatSince) || math.Max(m.high, value) - math.Min(m.low, value) > m.flat {
+        m.flatSince, m.low, m.high = time, value, value
+    } else {
+        m.low, m.high = math.Min(m.low, value), math.Max(m.high, value)
+    }
+
+    if !m.Lost() {
+        reason := ""
+        if time - m.railed >= m.after {
+            reason, m.lostAt = "lead-off", m.railed
+        } else if time - m.flatSince >= m.after {
+            reason, m.lostAt = "flat line", m.flatSince
+        }
+        if reason == "" {
+            return false, false, ""
+        }
+        m.returned = math.NaN()
+        atomic.StoreInt32(&m.lost, 1)
+        return true, true, fmt.Sprintf("signal lost (%s for %.1fs)", reason, m.after)
+    }
+
+    // The signal only counts as back once it varies again, flat stretches shorter than a loss are part of it
+    if !math.IsNaN(m.railed) || time - m.flatSince >= math.Min(m.after, signalReturn) {
+        m.returned = math.NaN()
+        return true, false, ""
+    }
+    if math.IsNaN(m.returned) {
+        m.returned = time
+    }
+    if time - m.returned < signalReturn {
+        return true, false, ""
+    }
+    atomic.StoreInt32(&m.lost, 0)
+    return false, true, fmt.Sprintf("signal resumed after %.1fs", m.returned - m.lostAt)
+}
+
+/*
+ Whether the signal is lost at the moment. This can be called from any goroutine.
+ */
+func (m *SignalMonitor) Lost() bool {
+    return m != nil && atomic.LoadInt32(&m.lost) != 0
+}
@@ -0,0 +1,181 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "sync"
+    "time"
+    "sync/atomic"
+)
+
+/*
+ How long a failing sink waits before it tries again. The wait doubles after every failure, up to the maximum.
+ */
+const (
+    sinkRetryFirst = 100 * time.Millisecond
+    sinkRetryLast = 10 * time.Second
+)
+
+/*
+ The state of one sink of a stream, for the status bar and the metrics of the server
+ */
+type SinkHealth struct {
+    Name string
+    Healthy bool
+    Queued int
+    Dropped uint64
+    Failures uint64
+    Err error
+}
+
+/*
+ Writes the messages of a stream into one sink, from a goroutine and a queue of its own, so a sink that blocks or
+ fails, like a broker that went down or a full disk, holds up neither the acquisition nor the other sinks. A message
+ that the sink fails to take is retried until it succeeds, waiting longer and longer in between, while new messages
+ wait in the queue. Once the queue is full, they are dropped. Sinks that were opened from a URL are opened again
+ before every retry, and get the session message again first. Whatever the failed sink had buffered is lost.
+ */
+type sinkOutput struct {
+    sink Sink
+    name string
+    stream *Stream
+    messages chan interface{}
+    closing chan bool
+    done chan bool
+    session interface{}
+    dropped uint64
+    failures uint64
+
+    // The error of the last attempt, nil while the sink works
+    lock sync.Mutex
+    err error
+}
+
+func newSinkOutput(sink Sink, stream *Stream) *sinkOutput {
+    output := &sinkOutput{sink: sink, name: fmt.Sprintf("%T", sink), stream: stream,
+        messages: make(chan interface{}, streamBuffer), closing: make(chan bool), done: make(chan bool)}
+    if named, ok := sink.(fmt.Stringer); ok {
+        output.name = named.String()
+    }
+    go output.run()
+    return output
+}
+
+/*
+ Queues a message for the sink, or drops it if the queue is full
+ */
+func (o *sinkOutput) Send(message interface{}) {
+    select {
+    case o.messages <- message:
+    default:
+        atomic.AddUint64(&o.dropped, 1)
+    }
+}
+
+/*
+ Writes the queued messages and closes the sink. A failing sink doesn't get any more retries, and the messages that
+ are still waiting for it are dropped.
+ */
+func (o *sinkOutput) Close() {
+    close(o.closing)
+    close(o.messages)
+    <-o.done
+    o.stream.fail(o.sink.Close())
+}
+
+func (o *sinkOutput) Health() SinkHealth {
+    o.lock.Lock()
+    defer o.lock.Unlock()
+    return SinkHealth{Name: o.name, Healthy: o.err == nil, Queued: len(o.messages),
+        Dropped: atomic.LoadUint64(&o.dropped), Failures: atomic.LoadUint64(&o.failures), Err: o.err}
+}
+
+func (o *sinkOutput) run() {
+    defer close(o.done)
+    for message := range o.messages {
+        if _, ok := message.(SessionMessage); ok {
+            o.session = message
+        }
+        if !o.deliver(message) {
+            atomic.AddUint64(&o.dropped, 1)
+            continue
+        }
+        if sample, ok := message.(streamedSample); ok {
+            o.stream.latency.Observe(LatencySink, sample.acquired)
+        }
+    }
+}
+
+/*
+ Writes a message into the sink, and retries it until it succeeds or the stream is closed
+ */
+func (o *sinkOutput) deliver(message interface{}) bool {
+    wait := sinkRetryFirst
+    for true {
+        err := o.write(o.sink, message)
+        o.lock.Lock()
+        o.err = err
+        o.lock.Unlock()
+        if err == nil {
+            return true
+        }
+        atomic.AddUint64(&o.failures, 1)
+        o.stream.fail(fmt.Errorf("sink %s: %v", o.name, err))
+        select {
+        case <-o.closing:
+            return false
+        case <-time.After(wait):
+        }
+        if wait *= 2; wait > sinkRetryLast {
+            wait = sinkRetryLast
+        }
+        o.reopen(message)
+    }
+    return false
+}
+
+/*
+ Writes a message into a sink. A sink that panics fails like one that returns an error.
+ */
+func (o *sinkOutput) write(sink Sink, message interface{}) (err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            err = fmt.Errorf("%v", r)
+        }
+    }()
+    if sample, ok := message.(streamedSample); ok {
+        return sink.Write(sample.message)
+    }
+    return sink.Write(message)
+}
+
+/*
+ Replaces a failed sink with a new one from its URL, which gets the session message first, unless that is the
+ message that failed. If that doesn't work, the old sink is kept for the next attempt.
+ */
+func (o *sinkOutput) reopen(failed interface{}) {
+    opened, ok := o.sink.(*openedSink)
+    if !ok {
+        return
+    }
+    sink, err := opened.Reopen()
+    if _, session := failed.(SessionMessage); err == nil && o.session != nil && !session {
+        err = o.write(sink, o.session)
+        if err != nil {
+            sink.Close()
+        }
+    }
+    if err != nil {
+        o.lock.Lock()
+        o.err = err
+        o.lock.Unlock()
+        return
+    }
+    o.sink.Close()
+    o.sink = sink
+}
@@ -0,0 +1,292 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+    "net/url"
+    "sync/atomic"
+)
+
+/*
+ A destination that the session is streamed to while it is acquired, like a database or a program on another
+ computer. Sinks are selected with --sink, using the scheme of the URL, and several sinks can be given:
+    $ plot --file=data.csv --sink=influx://server:8086/emg --sink=udp://192.168.1.10:5000
+ Every message is one of the messages of the wire schema (see "plot schema"), starting with the SessionMessage.
+ Sinks that can't take every sample, like an MQTT broker, can be given a lower rate (see RateSink).
+ */
+type Sink interface {
+    Write(message interface{}) error
+    Close() error
+}
+
+/*
+ Opens a sink for the given URL
+ */
+type SinkFactory func(target *url.URL) (Sink, error)
+
+/*
+ All sinks that are available. Every sink registers itself in here from an init function in its own file.
+ */
+var Sinks = map[string]SinkFactory{}
+
+/*
+ Returns the schemes of all registered sinks in alphabetical order
+ */
+func SinkNames() []string {
+    names := []string{}
+    for name := range Sinks {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+/*
+ Opens the sink of a URL. If the URL asks for a rate, the sink only gets aggregated samples (see RateSink).
+ */
+func OpenSink(description string) (Sink, error) {
+    target, err := url.Parse(description)
+    if err != nil {
+        return nil, err
+    }
+    factory, ok := Sinks[target.Scheme]
+    if !ok {
+        return nil, fmt.Errorf("unknown sink %s, expected one of %s", target.Scheme, strings.Join(SinkNames(), ", "))
+    }
+    query := target.Query()
+    period, aggregate, err := parseRate(query)
+    if err != nil {
+        return nil, err
+    }
+    target.RawQuery = query.Encode()
+    sink, err := factory(target)
+    if err != nil {
+        return nil, err
+    }
+    if period > 0 {
+        sink = NewRateSink(sink, period, aggregate)
+    }
+    return &openedSink{Sink: sink, description: description, name: target.Redacted()}, nil
+}
+
+/*
+ A sink with the URL it was opened from, so it can be opened again after it failed (see sinkOutput), and named
+ without its password
+ */
+type openedSink struct {
+    Sink
+    description string
+    name string
+}
+
+func (s *openedSink) Reopen() (Sink, error) {
+    return OpenSink(s.description)
+}
+
+func (s *openedSink) String() string {
+    return s.name
+}
+
+/*
+ Opens the sinks of several URLs. If one of them fails, the others are closed again.
+ */
+func OpenSinks(urls []string) ([]Sink, error) {
+    sinks := []Sink{}
+    for _, u := range urls {
+        sink, err := OpenSink(u)
+        if err != nil {
+            for _, opened := range sinks {
+                opened.Close()
+            }
+            return nil, fmt.Errorf("sink %s: %v", u, err)
+        }
+        sinks = append(sinks, sink)
+    }
+    return sinks, nil
+}
+
+/*
+ The URLs of the sinks, which can be given several times on the command line
+ */
+type SinkList []string
+
+func (s *SinkList) String() string {
+    return strings.Join(*s, " ")
+}
+
+func (s *SinkList) Set(value string) error {
+    *s = append(*s, value)
+    return nil
+}
+
+/*
+ How many messages can wait for a slow sink before new ones are dropped
+ */
+const streamBuffer = 1 << 14
+
+/*
+ Streams a session to sinks. In live mode, every sink is written from its own goroutine, with its own queue, and if
+ it can't keep up or fails, its messages are retried or dropped instead of holding up the acquisition or the other
+ sinks (see sinkOutput). Otherwise every message is written before the call returns, which is used to stream
+ existing recordings.
+ */
+type Stream struct {
+    sinks []Sink
+    outputs []*sinkOutput
+    stages []string
+    aux []AuxChannel
+    live bool
+    latency *LatencyTracker
+
+    // The first error of a sink
+    lock sync.Mutex
+    failure error
+}
+
+/*
+ Starts streaming a session with the given processing stages to the sinks
+ */
+func NewStream(sinks []Sink, meta Metadata, stages []string, live bool) (*Stream, error) {
+    stream := &Stream{sinks: sinks, stages: stages, aux: meta.Aux, live: live}
+    if live {
+        for _, sink := range sinks {
+            stream.outputs = append(stream.outputs, newSinkOutput(sink, stream))
+        }
+    }
+    return stream, stream.send(NewSessionMessage(meta, stages))
+}
+
+func (s *Stream) Sample(sample Sample) error {
+    message := NewSampleMessage(sample, s.stages, s.aux)
+    if !s.live {
+        return s.write(message)
+    }
+    return s.send(streamedSample{message, sample.Acquired})
+}
+
+/*
+ A sample that waits for the sinks, with the time of its acquisition to measure the latency
+ */
+type streamedSample struct {
+    message SampleMessage
+    acquired time.Time
+}
+
+func (s *Stream) Event(time float64, sample int, event string) error {
+    return s.send(NewEventMessage(time, sample, event))
+}
+
+/*
+ Sends the event of a new class of the classifier, with its confidence
+ */
+func (s *Stream) Prediction(sample int, prediction Prediction) error {
+    message := NewEventMessage(prediction.Time, sample, prediction.Event())
+    message.Class, message.Confidence = prediction.Class, prediction.Confidence
+    return s.send(message)
+}
+
+func (s *Stream) Segment(segment Segment) error {
+    return s.send(NewSegmentMessage(segment))
+}
+
+/*
+ Returns how many messages were dropped because the sinks couldn't keep up, or failed for too long
+ */
+func (s *Stream) Dropped() uint64 {
+    dropped := uint64(0)
+    for _, output := range s.outputs {
+        dropped += atomic.LoadUint64(&output.dropped)
+    }
+    return dropped
+}
+
+/*
+ Returns the health of every sink of a live stream
+ */
+func (s *Stream) Health() []SinkHealth {
+    health := []SinkHealth{}
+    for _, output := range s.outputs {
+        health = append(health, output.Health())
+    }
+    return health
+}
+
+/*
+ Returns the first error of a sink, or nil if every message was written so far
+ */
+func (s *Stream) Err() error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    return s.failure
+}
+
+/*
+ Writes the remaining messages and closes the sinks. Sinks that are failing get no more retries. Returns the first
+ error of a sink.
+ */
+func (s *Stream) Close() error {
+    if s.live {
+        for _, output := range s.outputs {
+            output.Close()
+        }
+    } else {
+        s.fail(s.closeSinks())
+    }
+    return s.Err()
+}
+
+func (s *Stream) send(message interface{}) error {
+    if !s.live {
+        return s.write(message)
+    }
+    for _, output := range s.outputs {
+        output.Send(message)
+    }
+    return nil
+}
+
+/*
+ Writes a message into every sink. A failing sink doesn't keep the message from the others.
+ */
+func (s *Stream) write(message interface{}) error {
+    var result error
+    for _, sink := range s.sinks {
+        err := sink.Write(message)
+        if err != nil && result == nil {
+            result = err
+        }
+    }
+    s.fail(result)
+    return result
+}
+
+/*
+ Remembers the first error of a sink
+ */
+func (s *Stream) fail(err error) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    if s.failure == nil {
+        s.failure = err
+    }
+}
+
+func (s *Stream) closeSinks() error {
+    var result error
+    for _, sink := range s.sinks {
+        err := sink.Close()
+        if err != nil && result == nil {
+            result = err
+        }
+    }
+    return result
+}
@@ -0,0 +1,132 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "math"
+    "sort"
+    "strings"
+    "strconv"
+)
+
+/*
+ The kinds of smoothing, in the order they are cycled through at runtime
+ */
+var smoothingKinds = []string{"none", "sma", "ema", "median"}
+
+/*
+ Smooths the signal on the chart. Different muscles and placements of the electrodes need different smoothing to look
+ sensible, so it can be changed while the chart is shown. Only the display is smoothed, recordings are not affected.
+    sma     The mean of the window (simple moving average)
+    ema     An exponential moving average, with the window as its time constant
+    median  The median of the window, which keeps steep edges but removes spikes
+ */
+type Smoothing struct {
+    Kind string
+
+    /*
+     The length of the window in seconds
+     */
+    Window float64
+}
+
+/*
+ Parses a smoothing given as kind:seconds, e.g. "sma:0.1". An empty string or "none" disables smoothing.
+ */
+func ParseSmoothing(description string) (*Smoothing, error) {
+    smoothing := &Smoothing{Kind: "none", Window: 0.1}
+    if description == "" {
+        return smoothing, nil
+    }
+    parts := strings.SplitN(description, ":", 2)
+    smoothing.Kind = parts[0]
+    if smoothingIndex(smoothing.Kind) < 0 {
+        return nil, fmt.Errorf("unknown smoothing %s, expected one of %s", smoothing.Kind,
+            strings.Join(smoothingKinds, ", "))
+    }
+    if len(parts) == 2 {
+        window, err := strconv.ParseFloat(parts[1], 64)
+        if err != nil {
+            return nil, fmt.Errorf("invalid window for the smoothing: %v", err)
+        }
+        if window <= 0 {
+            return nil, fmt.Errorf("the window of the smoothing must be positive")
+        }
+        smoothing.Window = window
+    }
+    return smoothing, nil
+}
+
+func smoothingIndex(kind string) int {
+    for i, k := range smoothingKinds {
+        if k == kind {
+            return i
+        }
+    }
+    return -1
+}
+
+/*
+ Switches to the next kind of smoothing
+ */
+func (s *Smoothing) Next() {
+    s.Kind = smoothingKinds[(smoothingIndex(s.Kind) + 1) % len(smoothingKinds)]
+}
+
+/*
+ Makes the window longer (factor > 1) or shorter (factor < 1)
+ */
+func (s *Smoothing) Resize(factor float64) {
+    s.Window *= factor
+}
+
+func (s *Smoothing) String() string {
+    if s.Kind == "none" {
+        return "No smoothing"
+    }
+    return fmt.Sprintf("%s %gms", strings.ToUpper(s.Kind), math.Round(s.Window * 1000))
+}
+
+/*
+ Returns the last values, smoothed. The values before them are used to fill the window, so the smoothing doesn't
+ start from nothing at the left edge of the chart.
+ */
+func (s *Smoothing) Last(values []float64, count int, interval float64) []float64 {
+    length := max(1, int(math.Round(s.Window / interval)))
+    if s.Kind == "none" || length == 1 {
+        return values[len(values)-count:]
+    }
+    start := max(0, len(values) - count - 3 * length)
+    input := values[start:]
+    output := make([]float64, len(input))
+    switch s.Kind {
+    case "sma":
+        sum := float64(0)
+        for i, v := range input {
+            sum += v
+            if i >= length {
+                sum -= input[i-length]
+            }
+            output[i] = sum / float64(min(i + 1, length))
+        }
+    case "ema":
+        alpha := 1 - math.Exp(-interval / s.Window)
+        output[0] = input[0]
+        for i := 1; i < len(input); i++ {
+            output[i] = output[i-1] + alpha * (input[i] - output[i-1])
+        }
+    case "median":
+        window := make([]float64, 0, length)
+        for i := range input {
+            window = append(window[:0], input[max(0, i - length + 1):i+1]...)
+            sort.Float64s(window)
+            output[i] = percentile(window, 50)
+        }
+    }
+    return output[len(output)-count:]
+}
@@ -0,0 +1,130 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "io"
+    "fmt"
+    "math"
+    "strconv"
+    "encoding/csv"
+    "encoding/json"
+)
+
+/*
+ Samples as columns, which is how notebooks want them: the time, the raw signal, the processed stages by their keys
+ in the stream (see streamKeys) and the auxiliary sensors by their names
+ */
+type SampleTable struct {
+    Names []string
+    Columns [][]float64
+}
+
+/*
+ Collects rows into a table with the given columns
+ */
+func NewRowTable(names []string, rows [][]float64) SampleTable {
+    table := SampleTable{Names: names, Columns: make([][]float64, len(names))}
+    for i := range table.Columns {
+        table.Columns[i] = make([]float64, len(rows))
+        for j, row := range rows {
+            table.Columns[i][j] = row[i]
+        }
+    }
+    return table
+}
+
+/*
+ Collects the samples of a stream into a table. Values that a sample doesn't have are NaN.
+ */
+func NewSampleTable(session SessionMessage, samples []SampleMessage) SampleTable {
+    table := SampleTable{Names: []string{"time", "value"}}
+    table.Names = append(table.Names, session.Stages...)
+    for _, aux := range session.Aux {
+        table.Names = append(table.Names, aux.Name)
+    }
+    table.Columns = make([][]float64, len(table.Names))
+    for i := range table.Columns {
+        table.Columns[i] = make([]float64, len(samples))
+    }
+    stages := len(session.Stages)
+    for row, sample := range samples {
+        table.Columns[0][row] = sample.Time
+        table.Columns[1][row] = sample.Value
+        for i, key := range session.Stages {
+            value, ok := sample.Stages[key]
+            if !ok {
+                value = math.NaN()
+            }
+            table.Columns[2 + i][row] = value
+        }
+        for i, aux := range session.Aux {
+            value, ok := sample.Aux[aux.Name]
+            if !ok {
+                value = math.NaN()
+            }
+            table.Columns[2 + stages + i][row] = value
+        }
+    }
+    return table
+}
+
+/*
+ The formats that a table can be written in, with their content types
+ */
+var TableFormats = map[string]string{
+    "csv": "text/csv",
+    "json": "application/json",
+    "arrow": "application/vnd.apache.arrow.file",
+}
+
+/*
+ Writes the table in one of TableFormats:
+    csv     A header with the names, and a row per sample, separated by commas. Missing values are empty.
+    json    An object with an array per column, which pandas.DataFrame takes as it is. Missing values are null.
+    arrow   An Arrow file (Feather), see ArrowWriter
+ */
+func (t SampleTable) Write(w io.Writer, format string) error {
+    switch format {
+    case "csv":
+        table := csv.NewWriter(w)
+        table.Write(t.Names)
+        rows := 0
+        if len(t.Columns) > 0 {
+            rows = len(t.Columns[0])
+        }
+        for row := 0; row < rows; row++ {
+            line := make([]string, len(t.Columns))
+            for i, column := range t.Columns {
+                if !math.IsNaN(column[row]) {
+                    line[i] = strconv.FormatFloat(column[row], 'g', -1, 64)
+                }
+            }
+            table.Write(line)
+        }
+        table.Flush()
+        return table.Error()
+    case "json":
+        // JSON has no NaN
+        columns := map[string][]*float64{}
+        for i, name := range t.Names {
+            values := make([]*float64, len(t.Columns[i]))
+            for row := range values {
+                if !math.IsNaN(t.Columns[i][row]) {
+                    values[row] = &t.Columns[i][row]
+                }
+            }
+            columns[name] = values
+        }
+        return json.NewEncoder(w).Encode(columns)
+    case "arrow":
+        writer := NewArrowWriter(w, t.Names, true)
+        writer.Write(t.Columns)
+        return writer.Close()
+    }
+    return fmt.Errorf("unknown format %s, expected csv, json or arrow", format)
+}
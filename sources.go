@@ -0,0 +1,81 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "sort"
+    "net/url"
+)
+
+/*
+ A source of samples other than the ADCPi. Sources are selected with --source, using the scheme of the URL:
+    $ plot --file=data.csv --source=ble://AA:BB:CC:DD:EE:FF/2a37?format=hrm
+ The function runs in its own goroutine. It has to start the recording once it knows the interval between two samples
+ (see startRecording), and pushes samples into the pipeline until the source ends or fails.
+ */
+type Source func(pipeline *Pipeline, target *url.URL) error
+
+/*
+ All sources that are available. Every source registers itself in here from an init function in its own file.
+ */
+var Sources = map[string]Source{}
+
+/*
+ Describes the auxiliary channels of a source that brings its own, like the other channels of an EEG board. They
+ are found from the URL before the source runs, since the display and the recording read them from then on. Sources
+ that aren't in here record the auxiliary sensors that were given with --aux.
+ */
+var SourceChannels = map[string]func(target *url.URL) (AuxChannels, error){}
+
+/*
+ Returns the schemes of all registered sources in alphabetical order
+ */
+func SourceNames() []string {
+    names := []string{}
+    for name := range Sources {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+/*
+ Returns the auxiliary channels of the source that was selected with --source, or those of --aux if it doesn't bring
+ its own
+ */
+func sourceChannels() (AuxChannels, error) {
+    target, err := url.Parse(Settings.Source)
+    if err != nil {
+        return nil, err
+    }
+    describe, ok := SourceChannels[target.Scheme]
+    if !ok {
+        return Settings.Aux, nil
+    }
+    return describe(target)
+}
+
+/*
+ This function reads the source that was selected with --source, and writes the samples into the pipeline between
+ the source and the plotting logic
+ */
+func grabDataFromSource(pipeline *Pipeline) {
+    defer pipeline.Close()
+    target, err := url.Parse(Settings.Source)
+    if err != nil {
+        panic(err)
+    }
+    source, ok := Sources[target.Scheme]
+    if !ok {
+        panic(fmt.Errorf("unknown source %s", target.Scheme))
+    }
+    err = source(pipeline, target)
+    if err != nil {
+        panic(err)
+    }
+}
@@ -0,0 +1,151 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "math"
+    "math/cmplx"
+)
+
+/*
+ How many samples one window of the power spectrum has. Longer windows resolve finer frequencies, but average fewer
+ windows, so the spectrum is noisier.
+ */
+const spectrumWindow = 256
+
+/*
+ The power spectral density of a signal in V²/Hz, averaged over half overlapping windows with the window of Hann
+ (the method of Welch). The samples are added one at a time, and only the current window is kept.
+ */
+type Spectrum struct {
+    interval float64
+    samples []float64
+    power []float64
+    count int
+}
+
+func NewSpectrum(interval float64) *Spectrum {
+    return &Spectrum{interval: interval, power: make([]float64, spectrumWindow / 2 + 1)}
+}
+
+func (s *Spectrum) Add(v float64) {
+    s.samples = append(s.samples, v)
+    if len(s.samples) == spectrumWindow {
+        s.transform(s.samples, s.power)
+        s.count++
+        s.samples = append(s.samples[:0], s.samples[spectrumWindow / 2:]...)
+    }
+}
+
+/*
+ Returns the frequencies in Hz and the power spectral density at each of them. Signals shorter than a window are
+ transformed as a whole, as far as it is a power of two long. Returns nil for less than four samples.
+ */
+func (s *Spectrum) Result() ([]float64, []float64) {
+    length, power, count := spectrumWindow, s.power, s.count
+    if count == 0 {
+        for length > len(s.samples) && length > 4 {
+            length /= 2
+        }
+        if len(s.samples) < length {
+            return nil, nil
+        }
+        power = make([]float64, length / 2 + 1)
+        s.transform(s.samples[:length], power)
+        count = 1
+    }
+    energy := 0.0
+    for i := 0; i < length; i++ {
+        w := hann(i, length)
+        energy += w * w
+    }
+
+    // One-sided: everything but the DC and the Nyquist frequency appears twice in the full spectrum
+    frequencies := make([]float64, len(power))
+    density := make([]float64, len(power))
+    for i := range power {
+        frequencies[i] = float64(i) / (float64(length) * s.interval)
+        density[i] = power[i] * s.interval / (energy * float64(count))
+        if i > 0 && i < length / 2 {
+            density[i] *= 2
+        }
+    }
+    return frequencies, density
+}
+
+/*
+ Adds the squared magnitudes of the spectrum of a window, without its mean, to the power
+ */
+func (s *Spectrum) transform(values []float64, power []float64) {
+    mean := Calculate(values).Mean
+    buffer := make([]complex128, len(values))
+    for i, v := range values {
+        buffer[i] = complex((v - mean) * hann(i, len(values)), 0)
+    }
+    fft(buffer)
+    for i := range power {
+        power[i] += math.Pow(cmplx.Abs(buffer[i]), 2)
+    }
+}
+
+/*
+ The window of Hann of the given length at the index
+ */
+func hann(i int, length int) float64 {
+    return 0.5 - 0.5 * math.Cos(2 * math.Pi * float64(i) / float64(length))
+}
+
+/*
+ Returns the mean and the median frequency of a power spectrum. Both fall while a muscle fatigues.
+ */
+func SpectrumFrequencies(frequencies []float64, power []float64) (float64, float64) {
+    total, weighted := 0.0, 0.0
+    for i := range power {
+        total += power[i]
+        weighted += power[i] * frequencies[i]
+    }
+    if total == 0 {
+        return 0, 0
+    }
+    sum := 0.0
+    for i := range power {
+        sum += power[i]
+        if sum >= total / 2 {
+            return weighted / total, frequencies[i]
+        }
+    }
+    return weighted / total, frequencies[len(frequencies) - 1]
+}
+
+/*
+ Transforms the values into their spectrum in place, with the iterative radix-2 algorithm of Cooley and Tukey. The
+ length has to be a power of two.
+ */
+func fft(values []complex128) {
+    n := len(values)
+    for i, j := 1, 0; i < n; i++ {
+        bit := n >> 1
+        for ; j & bit != 0; bit >>= 1 {
+            j ^= bit
+        }
+        j |= bit
+        if i < j {
+            values[i], values[j] = values[j], values[i]
+        }
+    }
+    for size := 2; size <= n; size <<= 1 {
+        step := cmplx.Exp(complex(0, -2 * math.Pi / float64(size)))
+        for start := 0; start < n; start += size {
+            w := complex(1, 0)
+            for k := 0; k < size / 2; k++ {
+                even, odd := values[start + k], values[start + k + size / 2] * w
+                values[start + k], values[start + k + size / 2] = even + odd, even - odd
+                w *= step
+            }
+        }
+    }
+}
@@ -0,0 +1,134 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "math"
+)
+
+/*
+ Simple statistics over a series of voltages
+ */
+type Stats struct {
+    Count int
+    Min float64
+    Max float64
+    Mean float64
+    RMS float64
+}
+
+/*
+ Calculates the statistics of the given values
+ */
+func Calculate(values []float64) Stats {
+    stats := Stats{Count: len(values)}
+    if len(values) == 0 {
+        return stats
+    }
+    stats.Min = values[0]
+    stats.Max = values[0]
+    sum := float64(0)
+    squares := float64(0)
+    for _, v := range values {
+        stats.Min = math.Min(stats.Min, v)
+        stats.Max = math.Max(stats.Max, v)
+        sum += v
+        squares += v * v
+    }
+    stats.Mean = sum / float64(len(values))
+    stats.RMS = math.Sqrt(squares / float64(len(values)))
+    return stats
+}
+
+/*
+ The largest absolute value
+ */
+func (s Stats) Peak() float64 {
+    return math.Max(math.Abs(s.Min), math.Abs(s.Max))
+}
+
+/*
+ Formats the statistics as one line, using the given unit
+ */
+func (s Stats) Format(unit Unit) string {
+    unit = unit.For(s.Peak())
+    return fmt.Sprintf("Min %s   Max %s   Mean %s   RMS %s", unit.Format(s.Min), unit.Format(s.Max),
+        unit.Format(s.Mean), unit.Format(s.RMS))
+}
+
+/*
+ Collects the statistics of values that arrive one at a time, without keeping them, for recordings that don't fit
+ into memory
+ */
+type RunningStats struct {
+    count int
+    min, max float64
+    sum, squares float64
+}
+
+func (r *RunningStats) Add(v float64) {
+    if r.count == 0 || v < r.min {
+        r.min = v
+    }
+    if r.count == 0 || v > r.max {
+        r.max = v
+    }
+    r.count++
+    r.sum += v
+    r.squares += v * v
+}
+
+/*
+ Returns the statistics of the values so far, like Calculate
+ */
+func (r *RunningStats) Stats() Stats {
+    stats := Stats{Count: r.count, Min: r.min, Max: r.max}
+    if r.count > 0 {
+        stats.Mean = r.sum / float64(r.count)
+        stats.RMS = math.Sqrt(r.squares / float64(r.count))
+    }
+    return stats
+}
+
+/*
+ Returns the integrated EMG of values over time in volt seconds, the area under the rectified signal, like the iEMG of
+ a segment
+ */
+func IEMG(keys []float64, values []float64) float64 {
+    area := float64(0)
+    for i := 1; i < len(values) && i < len(keys); i++ {
+        area += (math.Abs(values[i]) + math.Abs(values[i - 1])) / 2 * (keys[i] - keys[i - 1])
+    }
+    return area
+}
+
+/*
+ Returns the first derivative of values over time, in their unit per second. The first value has no predecessor, so
+ its derivative is zero.
+ */
+func Derivative(keys []float64, values []float64) []float64 {
+    derivative := make([]float64, len(values))
+    for i := 1; i < len(values) && i < len(keys); i++ {
+        if keys[i] > keys[i - 1] {
+            derivative[i] = (values[i] - values[i - 1]) / (keys[i] - keys[i - 1])
+        }
+    }
+    return derivative
+}
+
+/*
+ Returns the steepest rise of values over time, in their unit per second, which is a proxy for the rate of force
+ development
+ */
+func PeakSlope(keys []float64, values []float64) float64 {
+    peak := float64(0)
+    for _, slope := range Derivative(keys, values) {
+        peak = math.Max(peak, slope)
+    }
+    return peak
+}
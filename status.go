@@ -0,0 +1,184 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "math"
+    "time"
+    "strings"
+)
+
+/*
+ The status bar below the chart. It shows whether the session is being recorded, the file, the effective sample rate
+ (as opposed to the configured one), how many samples the display dropped, and how much time has been recorded. For
+ recordings with a fixed duration, the remaining time is counted down. The CPU load and the temperature of the system
+ are shown as well, the latency of the display, sinks that are failing, and with a UPS, the charge of its battery.
+ */
+type Status struct {
+    measured time.Time
+    pushed uint64
+    rate float64
+}
+
+/*
+ Formats the status bar
+ */
+func (s *Status) Format(pipeline *Pipeline) string {
+
+    // Measure the sample rate over at least one second
+    now := time.Now()
+    if now.Sub(s.measured) >= time.Second {
+        pushed := pipeline.Pushed()
+        if !s.measured.IsZero() {
+            s.rate = float64(pushed - s.pushed) / now.Sub(s.measured).Seconds()
+        }
+        s.measured = now
+        s.pushed = pushed
+    }
+
+    name := SessionState(pipeline)
+    label := " " + strings.ToUpper(name) + " "
+    state := highlighted(label, colorBlack, colorWhite)
+    switch name {
+    case "play":
+        state = highlighted(label, colorBlack, colorGreen)
+    case "paused":
+        state = highlighted(label, colorBlack, colorYellow)
+    case "armed":
+        state = highlighted(label, colorBlack, colorCyan)
+    case "rec":
+        state = highlighted(label, colorWhite, colorRed)
+    }
+    file := Settings.File
+    if file == "" {
+        file = "-"
+    }
+    _, elapsed := pipeline.LastSample()
+    line := fmt.Sprintf("%s %s   %.1f Hz   %d dropped   %s", state, file, s.rate, pipeline.Dropped(),
+        formatDuration(elapsed))
+
+    // Count down the end of recordings with a fixed duration
+    if Settings.Duration > 0 {
+        remaining := math.Max(0, Settings.Duration.Seconds() - elapsed)
+        line += fmt.Sprintf(" / %s   %s remaining", formatDuration(Settings.Duration.Seconds()),
+            formatDuration(math.Ceil(remaining)))
+    }
+    if pipeline.Motion.Moving() {
+        line += "   " + highlighted(" MOTION ", colorBlack, colorYellow)
+    }
+    if pipeline.Signal.Lost() {
+        line += "   " + highlighted(" SIGNAL LOST ", colorWhite, colorRed)
+    }
+    if prediction, ok := pipeline.Classifier.Current(); ok {
+        line += fmt.Sprintf("   Class %s (%.0f%%)", bold(prediction.Class), prediction.Confidence * 100)
+    }
+    return line + s.sinks(pipeline) + s.telemetry(pipeline) + s.latency(pipeline) + s.battery(pipeline)
+}
+
+/*
+ Shows the sinks that are failing, in red, with how many messages wait for them
+ */
+func (s *Status) sinks(pipeline *Pipeline) string {
+    text := ""
+    for _, health := range pipeline.SinkHealth() {
+        if !health.Healthy {
+            text += "   " + colored(bold(fmt.Sprintf("%s failing, %d queued", health.Name, health.Queued)), colorRed)
+        }
+    }
+    return text
+}
+
+/*
+ Shows how long the samples take until they are shown, in red once the delay becomes perceptible. Without a display,
+ this is how long they take to the sinks, or through the processing stages.
+ */
+func (s *Status) latency(pipeline *Pipeline) string {
+    for _, stage := range []string{LatencyDisplay, LatencySink, LatencyProcess} {
+        percentiles, ok := pipeline.Latency.Percentiles(stage)
+        if !ok {
+            continue
+        }
+        text := fmt.Sprintf("Latency %s (p95 %s)", formatLatency(percentiles.P50), formatLatency(percentiles.P95))
+        if percentiles.P95 > perceptibleLatency {
+            text = colored(bold(text), colorRed)
+        }
+        return "   " + text
+    }
+    return ""
+}
+
+/*
+ Formats a latency in milliseconds
+ */
+func formatLatency(latency time.Duration) string {
+    return fmt.Sprintf("%.1f ms", latency.Seconds() * 1000)
+}
+
+/*
+ Shows the CPU load and the temperature, in red once the Pi gets close to throttling
+ */
+func (s *Status) telemetry(pipeline *Pipeline) string {
+    if pipeline.Telemetry == nil {
+        return ""
+    }
+    telemetry := pipeline.Telemetry.Current()
+    text := fmt.Sprintf("   CPU %.0f%%", telemetry.CPU)
+    if math.IsNaN(telemetry.Temperature) {
+        return text
+    }
+    temperature := fmt.Sprintf("%.0f °C", telemetry.Temperature)
+    if telemetry.Temperature >= hotTemperature - 5 {
+        temperature = colored(bold(temperature), colorRed)
+    }
+    return text + "   " + temperature
+}
+
+/*
+ Shows the charge of the battery, in red once it gets close to ending the session
+ */
+func (s *Status) battery(pipeline *Pipeline) string {
+    if pipeline.Battery == nil {
+        return ""
+    }
+    level, ok := pipeline.Battery.Level()
+    if !ok {
+        return "   Battery -"
+    }
+    text := fmt.Sprintf("Battery %.0f%%", level)
+    if level < 2 * Settings.BatteryCritical {
+        text = colored(bold(text), colorRed)
+    }
+    return "   " + text
+}
+
+/*
+ Returns the state of the session as shown in the status bar: the mode of the application (see AppMode), where
+ playback is shown as play, and a live session that is recorded as rec or armed
+ */
+func SessionState(pipeline *Pipeline) string {
+    mode := pipeline.Modes.Mode()
+    switch mode {
+    case ModePlayback:
+        return "play"
+    case ModeLive:
+        if pipeline.Trigger != nil && !pipeline.Trigger.Open() {
+            return "armed"
+        } else if pipeline.Recording() != nil {
+            return "rec"
+        }
+    }
+    return string(mode)
+}
+
+/*
+ Formats an amount of seconds as hours, minutes and seconds
+ */
+func formatDuration(seconds float64) string {
+    total := int(seconds)
+    return fmt.Sprintf("%02d:%02d:%02d", total / 3600, total / 60 % 60, total % 60)
+}
@@ -0,0 +1,278 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "math"
+    "html"
+    "strings"
+)
+
+/*
+ The size of the charts in reports, in pixels, and the room that is left for the axes
+ */
+const (
+    svgWidth = 720
+    svgHeight = 240
+    svgLeft = 72
+    svgBottom = 36
+    svgTop = 12
+    svgRight = 12
+)
+
+/*
+ A vertical line with a label in a chart, like the start of a segment
+ */
+type SVGMarker struct {
+    At float64
+    Label string
+}
+
+/*
+ Draws a line chart as SVG. Long series are reduced to the minimum and the maximum of every pixel, which looks the
+ same.
+ */
+func LineSVG(keys []float64, values []float64, xLabel string, xFormat func(float64) string,
+    yFormat func(float64) string, markers []SVGMarker) string {
+    keys, values = downsample(keys, values, svgWidth - svgLeft - svgRight)
+    xMin, xMax := 0.0, 1.0
+    if len(keys) > 0 {
+        xMin, xMax = keys[0], keys[len(keys) - 1]
+    }
+    yMin, yMax := math.Inf(1), math.Inf(-1)
+    for _, v := range values {
+        yMin, yMax = math.Min(yMin, v), math.Max(yMax, v)
+    }
+    if math.IsInf(yMin, 0) {
+        yMin, yMax = 0, 1
+    }
+    yMin = math.Min(yMin, 0)
+    frame := newSVGFrame(xMin, xMax, yMin, yMax, xLabel, xFormat, yFormat)
+
+    for _, marker := range markers {
+        if marker.At < xMin || marker.At > xMax {
+            continue
+        }
+        x := frame.x(marker.At)
+        fmt.Fprintf(frame, `<line x1="%.1f" y1="%d" x2="%.1f" y2="%d" class="marker"/>`, x, svgTop, x,
+            svgHeight - svgBottom)
+        fmt.Fprintf(frame, `<text x="%.1f" y="%d" class="marker">%s</text>`, x + 3, svgTop + 10,
+            html.EscapeString(marker.Label))
+    }
+    points := make([]string, len(keys))
+    for i := range keys {
+        points[i] = fmt.Sprintf("%.1f,%.1f", frame.x(keys[i]), frame.y(values[i]))
+    }
+    fmt.Fprintf(frame, `<polyline points="%s" class="series"/>`, strings.Join(points, " "))
+    return frame.close()
+}
+
+/*
+ Draws a bar chart as SVG, with a label below every bar
+ */
+func BarSVG(labels []string, values []float64, yFormat func(float64) string) string {
+    yMin, yMax := 0.0, 0.0
+    for _, v := range values {
+        yMin, yMax = math.Min(yMin, v), math.Max(yMax, v)
+    }
+    if yMin == yMax {
+        yMax = 1
+    }
+    frame := newSVGFrame(0, float64(len(values)), yMin, yMax, "", nil, yFormat)
+    width := frame.x(1) - frame.x(0)
+    for i, v := range values {
+        top, bottom := frame.y(math.Max(v, 0)), frame.y(math.Min(v, 0))
+        fmt.Fprintf(frame, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" class="bar"/>`,
+            frame.x(float64(i)) + width * 0.15, top, width * 0.7, bottom - top)
+        fmt.Fprintf(frame, `<text x="%.1f" y="%d" class="tick" text-anchor="middle">%s</text>`,
+            frame.x(float64(i) + 0.5), svgHeight - svgBottom + 14, html.EscapeString(labels[i]))
+    }
+    return frame.close()
+}
+
+/*
+ The axes of a chart, which the content is drawn into
+ */
+type svgFrame struct {
+    strings.Builder
+    xMin, xMax, yMin, yMax float64
+}
+
+func newSVGFrame(xMin float64, xMax float64, yMin float64, yMax float64, xLabel string,
+    xFormat func(float64) string, yFormat func(float64) string) *svgFrame {
+    if xMax <= xMin {
+        xMax = xMin + 1
+    }
+    if yMax <= yMin {
+        yMax = yMin + 1
+    }
+    frame := &svgFrame{xMin: xMin, xMax: xMax, yMin: yMin, yMax: yMax}
+    fmt.Fprintf(frame, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`,
+        svgWidth, svgHeight, svgWidth, svgHeight)
+    frame.WriteString(`<style>text{font:10px sans-serif;fill:#444}.grid{stroke:#e4e4e4}.axis{stroke:#888}` +
+        `.series{fill:none;stroke:#1f5fa8;stroke-width:1}.bar{fill:#1f5fa8}.marker{stroke:#c0392b;` +
+        `stroke-dasharray:3,3;fill:#c0392b}</style>`)
+    for _, tick := range niceTicks(yMin, yMax, 5) {
+        y := frame.y(tick)
+        label := "0"
+        if tick != 0 {
+            label = yFormat(tick)
+        }
+        fmt.Fprintf(frame, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" class="grid"/>`, svgLeft, y,
+            svgWidth - svgRight, y)
+        fmt.Fprintf(frame, `<text x="%d" y="%.1f" class="tick" text-anchor="end">%s</text>`, svgLeft - 4, y + 3,
+            html.EscapeString(label))
+    }
+    if xFormat != nil {
+        for _, tick := range niceTicks(xMin, xMax, 8) {
+            x := frame.x(tick)
+            fmt.Fprintf(frame, `<line x1="%.1f" y1="%d" x2="%.1f" y2="%d" class="axis"/>`, x,
+                svgHeight - svgBottom, x, svgHeight - svgBottom + 4)
+            fmt.Fprintf(frame, `<text x="%.1f" y="%d" class="tick" text-anchor="middle">%s</text>`, x,
+                svgHeight - svgBottom + 15, html.EscapeString(xFormat(tick)))
+        }
+    }
+    fmt.Fprintf(frame, `<text x="%d" y="%d" text-anchor="middle">%s</text>`, (svgWidth + svgLeft) / 2,
+        svgHeight - 4, html.EscapeString(xLabel))
+    fmt.Fprintf(frame, `<line x1="%d" y1="%d" x2="%d" y2="%d" class="axis"/>`, svgLeft, svgHeight - svgBottom,
+        svgWidth - svgRight, svgHeight - svgBottom)
+    return frame
+}
+
+func (f *svgFrame) x(value float64) float64 {
+    return svgLeft + (value - f.xMin) / (f.xMax - f.xMin) * float64(svgWidth - svgLeft - svgRight)
+}
+
+func (f *svgFrame) y(value float64) float64 {
+    return svgHeight - svgBottom - (value - f.yMin) / (f.yMax - f.yMin) * float64(svgHeight - svgTop - svgBottom)
+}
+
+func (f *svgFrame) close() string {
+    f.WriteString("</svg>")
+    return f.String()
+}
+
+/*
+ Returns about count ticks between low and high, at round steps of 1, 2 or 5 times a power of ten
+ */
+func niceTicks(low float64, high float64, count int) []float64 {
+    raw := (high - low) / float64(count)
+    magnitude := math.Pow(10, math.Floor(math.Log10(raw)))
+    step := magnitude * 10
+    for _, factor := range []float64{1, 2, 5} {
+        if raw <= factor * magnitude {
+            step = factor * magnitude
+            break
+        }
+    }
+    ticks := []float64{}
+    for tick := math.Ceil(low / step) * step; tick <= high + step * 1e-9; tick += step {
+        // Avoid ticks like 0.30000000000000004
+        ticks = append(ticks, math.Round(tick / step) * step)
+    }
+    return ticks
+}
+
+/*
+ Reduces a series to the minimum and the maximum of each of the given amount of buckets, in the order they appear
+ */
+func downsample(keys []float64, values []float64, buckets int) ([]float64, []float64) {
+    if len(values) <= buckets * 2 {
+        return keys, values
+    }
+    reducer := NewSeriesReducer(buckets)
+    for i := range values {
+        reducer.Add(keys[i], values[i])
+    }
+    return reducer.Series()
+}
+
+/*
+ Reduces a series of any length to the minimum and the maximum of at most the given amount of buckets while it is
+ read, for the charts of recordings that don't fit into memory. When all buckets are used, neighbours are merged,
+ so every bucket covers twice as many samples as before.
+ */
+type SeriesReducer struct {
+    limit int
+    size int
+    filled int
+    buckets []seriesBucket
+}
+
+type seriesBucket struct {
+    lowKey, low, highKey, high float64
+}
+
+func NewSeriesReducer(buckets int) *SeriesReducer {
+    return &SeriesReducer{limit: buckets, size: 1}
+}
+
+func (r *SeriesReducer) Add(key float64, value float64) {
+    if len(r.buckets) == r.limit && r.filled == r.size {
+        r.merge()
+    }
+    if len(r.buckets) == 0 || r.filled == r.size {
+        r.buckets = append(r.buckets, seriesBucket{key, value, key, value})
+        r.filled = 1
+        return
+    }
+    last := &r.buckets[len(r.buckets) - 1]
+    if value < last.low {
+        last.lowKey, last.low = key, value
+    }
+    if value > last.high {
+        last.highKey, last.high = key, value
+    }
+    r.filled++
+}
+
+/*
+ Merges every two buckets into one. A single bucket at the end only covers half of the new size.
+ */
+func (r *SeriesReducer) merge() {
+    merged := r.buckets[:0]
+    for i := 0; i < len(r.buckets); i += 2 {
+        bucket := r.buckets[i]
+        if i + 1 < len(r.buckets) {
+            next := r.buckets[i + 1]
+            if next.low < bucket.low {
+                bucket.lowKey, bucket.low = next.lowKey, next.low
+            }
+            if next.high > bucket.high {
+                bucket.highKey, bucket.high = next.highKey, next.high
+            }
+        }
+        merged = append(merged, bucket)
+    }
+    r.filled = r.size * 2
+    if len(r.buckets) % 2 == 1 {
+        r.filled = r.size
+    }
+    r.buckets = merged
+    r.size *= 2
+}
+
+/*
+ Returns the reduced series, with the minimum and the maximum of every bucket in the order they appeared
+ */
+func (r *SeriesReducer) Series() ([]float64, []float64) {
+    keys, values := []float64{}, []float64{}
+    for _, bucket := range r.buckets {
+        if bucket.lowKey < bucket.highKey {
+            keys = append(keys, bucket.lowKey, bucket.highKey)
+            values = append(values, bucket.low, bucket.high)
+        } else if bucket.lowKey > bucket.highKey {
+            keys = append(keys, bucket.highKey, bucket.lowKey)
+            values = append(values, bucket.high, bucket.low)
+        } else {
+            keys = append(keys, bucket.lowKey)
+            values = append(values, bucket.low)
+        }
+    }
+    return keys, values
+}
@@ -0,0 +1,166 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+//go:build !nonet
+
+package main
+
+import (
+    "io"
+    "fmt"
+    "math"
+    "time"
+    "bufio"
+    "strings"
+    "strconv"
+    "net/url"
+    "encoding/binary"
+)
+
+func init() {
+    Sources["tcp"] = grabDataFromTCP
+    Sources["tls"] = grabDataFromTCP
+    SourceBackends["tcp"] = Backend{
+        Usage: "tcp://<host>:<port>?framing=lines|length",
+        Description: "A remote sample server",
+        Probe: func(target *url.URL) error { return probeHost(target, "") },
+    }
+    SourceBackends["tls"] = Backend{
+        Usage: "tls://<host>:<port>?framing=lines|length&ca=ca.pem",
+        Description: "A remote sample server, connected with TLS",
+        Probe: func(target *url.URL) error { return probeHost(target, "") },
+    }
+}
+
+/*
+ This function connects to a remote sample server, for example a Pi that only does the acquisition while the display
+ runs on a faster computer. The source is given as
+    tcp://<host>:<port>?framing=<framing>
+ tls:// connects with TLS, see DialURL for the certificates.
+ With the default framing (lines), every line is a sample, either only the voltage, or the time in seconds, the voltage
+ and the values of the auxiliary sensors separated by semicolons, like the rows of a recording. Lines that aren't
+ numbers, like a header, are skipped. With framing=length, every sample is a frame of a 32 bit length followed by that
+ many bytes of 64 bit floats (time, voltage and auxiliary sensors), all big endian.
+ If the connection is lost, it is opened again, like the ADCPi is reconnected after an outage.
+ */
+func grabDataFromTCP(pipeline *Pipeline, target *url.URL) error {
+    framing := target.Query().Get("framing")
+    if framing == "" {
+        framing = "lines"
+    }
+    if framing != "lines" && framing != "length" {
+        return fmt.Errorf("unknown framing %s", framing)
+    }
+    secure := target.Scheme == "tls"
+    connection, err := DialURL(target, "tcp", target.Host, secure)
+    if err != nil {
+        return err
+    }
+
+    startRecording(pipeline, "remote")
+    if Settings.Watchdog > 0 {
+        go RunWatchdog(pipeline, watchdogTimeout())
+    }
+
+    // Wait at least a second before connecting again
+    retry := watchdogTimeout()
+    if retry < time.Second {
+        retry = time.Second
+    }
+
+    x := 0
+    last := time.Now()
+    for true {
+        reader := bufio.NewReader(connection)
+        for true {
+            var values []float64
+            if framing == "lines" {
+                values, err = readLineSample(reader)
+            } else {
+                values, err = readFrameSample(reader)
+            }
+            if err != nil {
+                break
+            }
+            if values == nil {
+                continue
+            }
+
+            // Samples without a time are counted, skipping the samples that were missed while disconnected
+            if len(values) == 1 {
+                if time.Since(last) > retry {
+                    x += int(time.Since(last).Seconds() / Settings.Interval) - 1
+                }
+                values = []float64{float64(x) * Settings.Interval, values[0]}
+            }
+            last = time.Now()
+            pipeline.Push(Sample{Index: x, Time: values[0], Value: values[1], Aux: values[2:]})
+            x++
+        }
+
+        // The server went away, try to connect to it again until it works
+        connection.Close()
+        for true {
+            time.Sleep(retry)
+            connection, err = DialURL(target, "tcp", target.Host, secure)
+            if err == nil {
+                break
+            }
+        }
+    }
+    return nil
+}
+
+/*
+ Reads a sample that is sent as a line of text. Lines that are not a sample return nil.
+ */
+func readLineSample(reader *bufio.Reader) ([]float64, error) {
+    line, err := reader.ReadString('\n')
+    if err != nil {
+        return nil, err
+    }
+    line = strings.TrimSpace(line)
+    if line == "" {
+        return nil, nil
+    }
+    fields := strings.Split(line, ";")
+    values := make([]float64, len(fields))
+    for i, field := range fields {
+        values[i], err = strconv.ParseFloat(strings.TrimSpace(field), 64)
+        if err != nil {
+            return nil, nil
+        }
+    }
+    return values, nil
+}
+
+/*
+ Reads a sample that is sent as a frame with a length prefix. Frames without a voltage return nil.
+ */
+func readFrameSample(reader *bufio.Reader) ([]float64, error) {
+    header := make([]byte, 4)
+    _, err := io.ReadFull(reader, header)
+    if err != nil {
+        return nil, err
+    }
+    length := binary.BigEndian.Uint32(header)
+    if length > 1 << 16 || length % 8 != 0 {
+        return nil, fmt.Errorf("invalid frame length %d", length)
+    }
+    frame := make([]byte, length)
+    _, err = io.ReadFull(reader, frame)
+    if err != nil {
+        return nil, err
+    }
+    if length < 16 {
+        return nil, nil
+    }
+    values := make([]float64, length / 8)
+    for i := range values {
+        values[i] = math.Float64frombits(binary.BigEndian.Uint64(frame[i * 8:]))
+    }
+    return values, nil
+}
@@ -0,0 +1,195 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "fmt"
+    "math"
+    "sync"
+    "time"
+    "os/exec"
+    "strings"
+    "strconv"
+)
+
+/*
+ How often the telemetry is sampled
+ */
+const telemetryInterval = 2 * time.Second
+
+/*
+ The temperature of the SoC in °C from which the Pi 4 starts to throttle
+ */
+const hotTemperature = 80
+
+/*
+ Where the kernel provides the throttling flags of the firmware, and the temperature of the SoC in millidegrees
+ */
+const throttledFile = "/sys/devices/platform/soc/soc:firmware/get_throttled"
+const temperatureFile = "/sys/class/thermal/thermal_zone0/temp"
+
+/*
+ The flags of the firmware that tell why the Pi is throttled at the moment. The same flags shifted by 16 bits tell
+ what happened since the boot.
+ */
+var throttleFlags = []struct {
+    bit uint32
+    name string
+}{
+    {0x1, "under-voltage"},
+    {0x2, "frequency capped"},
+    {0x4, "throttled"},
+    {0x8, "soft temperature limit"},
+}
+
+/*
+ The state of the system that runs the acquisition: the CPU load in percent, the temperature of the SoC in °C (NaN
+ if unknown) and the throttling flags of the firmware
+ */
+type Telemetry struct {
+    CPU float64
+    Temperature float64
+    Throttled uint32
+}
+
+/*
+ Returns the reasons why the Pi is throttled at the moment, empty if it isn't
+ */
+func (t Telemetry) Throttling() []string {
+    reasons := []string{}
+    for _, flag := range throttleFlags {
+        if t.Throttled & flag.bit != 0 {
+            reasons = append(reasons, flag.name)
+        }
+    }
+    return reasons
+}
+
+/*
+ Samples the telemetry regularly for the status bar and the metrics. Throttling slows the acquisition down, so when
+ it starts and ends, this is noted in the events of the session.
+ */
+type TelemetryMonitor struct {
+    lock sync.Mutex
+    current Telemetry
+    busy uint64
+    total uint64
+}
+
+func NewTelemetryMonitor() *TelemetryMonitor {
+    return &TelemetryMonitor{current: Telemetry{Temperature: math.NaN()}}
+}
+
+/*
+ Returns the telemetry as of the last sample
+ */
+func (m *TelemetryMonitor) Current() Telemetry {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+    return m.current
+}
+
+/*
+ Samples the telemetry until the session has ended
+ */
+func (m *TelemetryMonitor) Run(pipeline *Pipeline) {
+    for !pipeline.Closed() {
+        previous := m.Current()
+        current := m.sample()
+        was := strings.Join(previous.Throttling(), ", ")
+        is := strings.Join(current.Throttling(), ", ")
+        if is != was {
+            _, t := pipeline.LastSample()
+            if is == "" {
+                pipeline.Event(t, "throttling ended")
+            } else {
+                pipeline.Event(t, fmt.Sprintf("throttled: %s (%.0f °C)", is, current.Temperature))
+            }
+        }
+        time.Sleep(telemetryInterval)
+    }
+}
+
+func (m *TelemetryMonitor) sample() Telemetry {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+    busy, total, err := readCPUTimes()
+    if err == nil && total > m.total {
+        if m.total > 0 {
+            m.current.CPU = float64(busy - m.busy) / float64(total - m.total) * 100
+        }
+        m.busy = busy
+        m.total = total
+    }
+    temperature, err := readTemperature()
+    if err == nil {
+        m.current.Temperature = temperature
+    }
+    throttled, err := readThrottled()
+    if err == nil {
+        m.current.Throttled = throttled
+    }
+    return m.current
+}
+
+/*
+ Reads how much time the CPUs spent working, and how much time passed in total, in ticks since the boot
+ */
+func readCPUTimes() (uint64, uint64, error) {
+    data, err := os.ReadFile("/proc/stat")
+    if err != nil {
+        return 0, 0, err
+    }
+    fields := strings.Fields(strings.SplitN(string(data), "\n", 2)[0])
+    if len(fields) < 5 || fields[0] != "cpu" {
+        return 0, 0, fmt.Errorf("unexpected format of /proc/stat")
+    }
+    total := uint64(0)
+    idle := uint64(0)
+    for i, field := range fields[1:] {
+        ticks, err := strconv.ParseUint(field, 10, 64)
+        if err != nil {
+            return 0, 0, err
+        }
+        total += ticks
+
+        // Idle and waiting for I/O
+        if i == 3 || i == 4 {
+            idle += ticks
+        }
+    }
+    return total - idle, total, nil
+}
+
+func readTemperature() (float64, error) {
+    data, err := os.ReadFile(temperatureFile)
+    if err != nil {
+        return 0, err
+    }
+    millidegrees, err := strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+    return millidegrees / 1000, err
+}
+
+/*
+ Reads the throttling flags from the kernel, or from the firmware with vcgencmd on older kernels
+ */
+func readThrottled() (uint32, error) {
+    text := ""
+    data, err := os.ReadFile(throttledFile)
+    if err == nil {
+        text = "0x" + strings.TrimSpace(string(data))
+    } else {
+        output, err := exec.Command("vcgencmd", "get_throttled").Output()
+        if err != nil {
+            return 0, err
+        }
+        text = strings.TrimPrefix(strings.TrimSpace(string(output)), "throttled=")
+    }
+    flags, err := strconv.ParseUint(text, 0, 32)
+    return uint32(flags), err
+}
@@ -0,0 +1,149 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "math"
+    "sync/atomic"
+)
+
+/*
+ Decides which samples are recorded in triggered mode. The last seconds of the signal are kept in memory, and only
+ when the trigger fires, they are recorded together with the samples that follow. The trigger fires when the magnitude
+ of the processed signal reaches the threshold (see LevelTrigger), when a condition on several channels holds (see
+ TriggerCondition), or when it is fired by hand. The recording is
+ extended for as long as the trigger is active, so it only stops once the signal has been quiet for the whole
+ post-trigger window.
+ */
+type Gate struct {
+
+    /*
+     How many seconds before and after the trigger are recorded
+     */
+    Pre float64
+    Post float64
+
+    /*
+     Fires on the magnitude of the processed signal, or nil if the trigger is only fired by hand. With a condition,
+     it fires on whether the condition holds instead, which is a level of one or zero. The pipeline updates the
+     condition with every sample, the gate only reads it.
+     */
+    Level *LevelTrigger
+    When *TriggerCondition
+
+    buffer []Sample
+    until float64
+    open int32
+    fire int32
+}
+
+func NewGate(pre float64, post float64, level *LevelTrigger) *Gate {
+    return &Gate{Pre: pre, Post: post, Level: level, until: math.Inf(-1)}
+}
+
+/*
+ Takes the next sample and returns the samples that should be recorded, which are either none, the sample itself, or
+ the samples before the trigger followed by the sample. The second value is true if the trigger fired, but not if it
+ only extended a recording that was still running.
+ */
+func (g *Gate) Process(sample Sample) ([]Sample, bool) {
+    fired := atomic.SwapInt32(&g.fire, 0) != 0
+    active := fired
+    if g.Level != nil {
+        value := math.Abs(sample.Processed())
+        if g.When != nil {
+            value = 0
+            if g.When.Holds() {
+                value = 1
+            }
+        }
+        detected, level := g.Level.Process(sample.Time, value)
+        fired = fired || detected
+        active = active || level
+    }
+    started := false
+    if active {
+        started = fired && sample.Time > g.until
+        g.until = sample.Time + g.Post
+    }
+
+    if sample.Time <= g.until {
+        atomic.StoreInt32(&g.open, 1)
+        samples := append(g.buffer, sample)
+        g.buffer = nil
+        return samples, started
+    }
+    atomic.StoreInt32(&g.open, 0)
+
+    // Keep the pre-trigger window
+    g.buffer = append(g.buffer, sample)
+    first := 0
+    for first < len(g.buffer) && g.buffer[first].Time < sample.Time - g.Pre {
+        first++
+    }
+    g.buffer = g.buffer[first:]
+    return nil, false
+}
+
+/*
+ Fires the trigger with the next sample. This can be called from any goroutine.
+ */
+func (g *Gate) Fire() {
+    atomic.StoreInt32(&g.fire, 1)
+}
+
+/*
+ Whether samples are currently being recorded. This can be called from any goroutine.
+ */
+func (g *Gate) Open() bool {
+    return atomic.LoadInt32(&g.open) != 0
+}
+
+/*
+ Fires when a level reaches a threshold, without firing bursts while a noisy signal hovers around it. Once it fired,
+ it is active until the level falls below the threshold by the hysteresis, and only then can fire again. The level
+ has to stay at the threshold for the hold time before the trigger fires, which ignores short spikes, and after it
+ fired, it doesn't fire again for the refractory period. Raw EMG crosses any threshold many times within one
+ contraction, so it usually needs all three. Times are in seconds.
+ */
+type LevelTrigger struct {
+    Threshold float64
+    Hysteresis float64
+    Hold float64
+    Refractory float64
+
+    armed bool
+    above float64
+    fired float64
+}
+
+func NewLevelTrigger(threshold float64, hysteresis float64, hold float64, refractory float64) *LevelTrigger {
+    return &LevelTrigger{Threshold: threshold, Hysteresis: hysteresis, Hold: hold, Refractory: refractory,
+        armed: true, above: math.NaN(), fired: math.Inf(-1)}
+}
+
+/*
+ Takes the level at the given time, and returns whether the trigger fires with it, and whether it is active
+ */
+func (l *LevelTrigger) Process(time float64, level float64) (bool, bool) {
+    if level < l.Threshold {
+        l.above = math.NaN()
+        if level < l.Threshold - l.Hysteresis {
+            l.armed = true
+        }
+        return false, !l.armed
+    }
+    if math.IsNaN(l.above) {
+        l.above = time
+    }
+    if l.armed && time - l.above >= l.Hold && time - l.fired >= l.Refractory {
+        l.armed = false
+        l.fired = time
+        return true, true
+    }
+    return false, !l.armed
+}
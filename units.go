@@ -0,0 +1,170 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "math"
+    "strings"
+)
+
+/*
+ A unit that voltages can be displayed in. Internally every value is stored in volts, and only converted when it is
+ shown to the user or exported.
+ */
+type Unit struct {
+    Name string
+
+    /*
+     The factor that converts volts into this unit
+     */
+    Scale float64
+}
+
+/*
+ The units with SI prefixes, from the largest to the smallest
+ */
+var voltageUnits = []Unit{{"V", 1}, {"mV", 1e3}, {"µV", 1e6}}
+
+/*
+ Automatically chooses the prefix that fits the values best
+ */
+var AutoUnit = Unit{Name: "auto"}
+
+/*
+ Finds a unit by its name. Accepted are auto, V, mV, µV (or uV) and %MVC. Percent of the maximum voluntary contraction
+ requires the voltage of the MVC, which is usually measured during calibration.
+ */
+func ParseUnit(name string, mvc float64) (Unit, error) {
+    switch name {
+    case "", "auto":
+        return AutoUnit, nil
+    case "uV":
+        return voltageUnits[2], nil
+    case "%MVC":
+        if mvc <= 0 {
+            return Unit{}, fmt.Errorf("the unit %%MVC requires --mvc")
+        }
+        return Unit{"%MVC", 100 / mvc}, nil
+    }
+    for _, unit := range voltageUnits {
+        if unit.Name == name {
+            return unit, nil
+        }
+    }
+    return Unit{}, fmt.Errorf("unknown unit %s", name)
+}
+
+/*
+ Returns the unit that should be used for values up to the given magnitude. Fixed units are returned unchanged, auto
+ picks the largest prefix that keeps the magnitude at 1 or above.
+ */
+func (u Unit) For(magnitude float64) Unit {
+    if u.Name != AutoUnit.Name {
+        return u
+    }
+    magnitude = math.Abs(magnitude)
+    for _, unit := range voltageUnits {
+        if magnitude * unit.Scale >= 1 {
+            return unit
+        }
+    }
+    return voltageUnits[len(voltageUnits) - 1]
+}
+
+/*
+ Converts a voltage into this unit
+ */
+func (u Unit) Convert(volts float64) float64 {
+    return volts * u.For(volts).Scale
+}
+
+/*
+ Formats a voltage with its unit, e.g. "1.234 mV"
+ */
+func (u Unit) Format(volts float64) string {
+    unit := u.For(volts)
+    return fmt.Sprintf("%.3f %s", volts * unit.Scale, unit.Name)
+}
+
+/*
+ Adds the unit to the name of a column, e.g. "Voltage [mV]"
+ */
+func (u Unit) Column(name string) string {
+    return fmt.Sprintf("%s [%s]", name, u.Name)
+}
+
+/*
+ Splits the name of a column into its label and its unit. Columns without a unit (like in recordings of older
+ versions) are in volts.
+ */
+func ParseColumn(column string) (string, Unit) {
+    column = strings.TrimSpace(column)
+    start := strings.LastIndex(column, " [")
+    if start < 0 || !strings.HasSuffix(column, "]") {
+        return column, voltageUnits[0]
+    }
+    unit, err := ParseUnit(column[start + 2:len(column) - 1], 0)
+    if err != nil || unit.Name == AutoUnit.Name {
+        unit = voltageUnits[0]
+    }
+    return column[:start], unit
+}
+
+/*
+ How amplitudes are scaled on the Y axis. EMG envelopes span several orders of magnitude, which a linear axis hides.
+ The logarithmic scales show the magnitude of the signal: log is its base 10 logarithm, db is in decibels relative to
+ one unit (e.g. dBmV). Recordings are always linear.
+ */
+type YScale string
+
+const (
+    LinearScale YScale = "linear"
+    LogScale YScale = "log"
+    DecibelScale YScale = "db"
+)
+
+/*
+ The smallest magnitude in volts that is shown on a logarithmic scale, instead of minus infinity for zero
+ */
+const scaleFloor = 1e-9
+
+func ParseYScale(name string) (YScale, error) {
+    switch YScale(name) {
+    case "", LinearScale:
+        return LinearScale, nil
+    case LogScale, DecibelScale:
+        return YScale(name), nil
+    }
+    return "", fmt.Errorf("unknown scale %s", name)
+}
+
+/*
+ Converts a voltage into the given unit and applies the scale
+ */
+func (s YScale) Apply(volts float64, unit Unit) float64 {
+    switch s {
+    case LogScale:
+        return math.Log10(math.Max(math.Abs(volts), scaleFloor) * unit.Scale)
+    case DecibelScale:
+        return 20 * math.Log10(math.Max(math.Abs(volts), scaleFloor) * unit.Scale)
+    }
+    return volts * unit.Scale
+}
+
+/*
+ Adds the unit and the scale to the name of a column, e.g. "Voltage [dBmV]"
+ */
+func (s YScale) Column(name string, unit Unit) string {
+    switch s {
+    case LogScale:
+        return fmt.Sprintf("log10 %s", unit.Column(name))
+    case DecibelScale:
+        return fmt.Sprintf("%s [dB%s]", name, unit.Name)
+    }
+    return unit.Column(name)
+}
@@ -0,0 +1,184 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "math"
+    "time"
+    "sync/atomic"
+    "encoding/binary"
+)
+
+/*
+ How often the battery is read
+ */
+const batteryCheckInterval = 10 * time.Second
+
+/*
+ The battery of an uninterruptible power supply HAT, which powers the Pi for recordings in the field
+ */
+type UPS interface {
+    Read() (Battery, error)
+    Close() error
+}
+
+/*
+ The state of the battery: the charge in percent and the voltage of the battery
+ */
+type Battery struct {
+    Level float64
+    Voltage float64
+}
+
+/*
+ Connects to the fuel gauge of a UPS HAT. Supported are the MAX17040 (Geekworm X728 and X708, default address 0x36),
+ the CW2015 (UPS-Lite, default address 0x62) and the INA219 (Waveshare UPS HAT, default address 0x42). The INA219
+ only measures the voltage, so the charge is estimated from the voltage of the lithium cells. An address of zero uses
+ the default.
+ */
+func OpenUPS(kind string, bus string, address int) (UPS, error) {
+    defaults := map[string]int{"max17040": 0x36, "cw2015": 0x62, "ina219": 0x42}
+    if _, ok := defaults[kind]; !ok {
+        return nil, fmt.Errorf("unknown UPS %s, expected max17040, cw2015 or ina219", kind)
+    }
+    if address == 0 {
+        address = defaults[kind]
+    }
+    device, err := OpenI2C(bus, address)
+    if err != nil {
+        return nil, err
+    }
+    switch kind {
+    case "max17040":
+        return &MAX17040{device: device}, nil
+    case "cw2015":
+        // Wake the gauge up (MODE), it might have been put to sleep
+        err = device.WriteRegister(0x0A, 0x00)
+        if err != nil {
+            device.Close()
+            return nil, err
+        }
+        return &CW2015{device: device}, nil
+    }
+    return &INA219{device: device}, nil
+}
+
+/*
+ The MAX17040 fuel gauge from Maxim. VCELL holds the voltage in 1.25 mV steps in its upper 12 bits, SOC the charge in
+ percent, with 1/256 percent in the lower byte.
+ */
+type MAX17040 struct {
+    device *I2C
+}
+
+func (m *MAX17040) Read() (Battery, error) {
+    cell, level, err := readFuelGauge(m.device)
+    return Battery{Level: level, Voltage: float64(cell >> 4) * 0.00125}, err
+}
+
+func (m *MAX17040) Close() error {
+    return m.device.Close()
+}
+
+/*
+ The CW2015 fuel gauge from CellWise, which is laid out like the MAX17040, but measures the voltage in 305 µV steps
+ in the lower 14 bits
+ */
+type CW2015 struct {
+    device *I2C
+}
+
+func (c *CW2015) Read() (Battery, error) {
+    cell, level, err := readFuelGauge(c.device)
+    return Battery{Level: level, Voltage: float64(cell & 0x3FFF) * 0.000305}, err
+}
+
+func (c *CW2015) Close() error {
+    return c.device.Close()
+}
+
+/*
+ Reads the raw VCELL register and the charge from a fuel gauge
+ */
+func readFuelGauge(device *I2C) (uint16, float64, error) {
+    data := make([]byte, 4)
+    err := device.ReadRegister(0x02, data)
+    if err != nil {
+        return 0, 0, err
+    }
+    level := float64(data[2]) + float64(data[3]) / 256
+    return binary.BigEndian.Uint16(data[0:2]), math.Min(100, level), nil
+}
+
+/*
+ The INA219 power monitor from Texas Instruments. The bus voltage is stored in the upper 13 bits of its register, in
+ 4 mV steps. The charge is estimated linearly between 3.0 V (empty) and 4.2 V (full) per cell, and the number of
+ cells in series is guessed from the voltage.
+ */
+type INA219 struct {
+    device *I2C
+}
+
+func (i *INA219) Read() (Battery, error) {
+    data := make([]byte, 2)
+    err := i.device.ReadRegister(0x02, data)
+    if err != nil {
+        return Battery{}, err
+    }
+    voltage := float64(binary.BigEndian.Uint16(data) >> 3) * 0.004
+    cells := math.Max(1, math.Round(voltage / 3.7))
+    level := (voltage / cells - 3.0) / 1.2 * 100
+    return Battery{Level: math.Max(0, math.Min(100, level)), Voltage: voltage}, nil
+}
+
+func (i *INA219) Close() error {
+    return i.device.Close()
+}
+
+/*
+ Reads the battery regularly for the status bar, and ends the session while the recording can still be closed
+ properly once the charge drops below Settings.BatteryCritical
+ */
+type BatteryMonitor struct {
+    ups UPS
+    level uint64
+    read int32
+}
+
+func NewBatteryMonitor(ups UPS) *BatteryMonitor {
+    return &BatteryMonitor{ups: ups}
+}
+
+/*
+ Returns the last charge in percent, and whether the battery was read yet
+ */
+func (b *BatteryMonitor) Level() (float64, bool) {
+    return math.Float64frombits(atomic.LoadUint64(&b.level)), atomic.LoadInt32(&b.read) != 0
+}
+
+/*
+ Reads the battery until the session has ended
+ */
+func (b *BatteryMonitor) Run(pipeline *Pipeline) {
+    defer b.ups.Close()
+    for !pipeline.Closed() {
+        battery, err := b.ups.Read()
+        if err == nil {
+            atomic.StoreUint64(&b.level, math.Float64bits(battery.Level))
+            atomic.StoreInt32(&b.read, 1)
+            if battery.Level < Settings.BatteryCritical {
+                _, t := pipeline.LastSample()
+                pipeline.Event(t, fmt.Sprintf("battery critical (%.0f%%, %.2f V), recording stopped",
+                    battery.Level, battery.Voltage))
+                pipeline.Modes.Transition(ModeEnded)
+                return
+            }
+        }
+        time.Sleep(batteryCheckInterval)
+    }
+}
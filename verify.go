@@ -0,0 +1,143 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "io"
+    "fmt"
+    "flag"
+    "bufio"
+    "strings"
+    "strconv"
+    "hash/crc32"
+)
+
+func init() {
+    Commands["verify"] = verifyCommand
+}
+
+/*
+ Checks recordings against the checksums that were written while recording them, to detect files that were damaged or
+ truncated by the storage medium.
+ Example:
+    $ plot verify data.csv
+ */
+func verifyCommand(args []string) {
+    flags := flag.NewFlagSet("verify", flag.ExitOnError)
+    flags.StringVar(&(Settings.KeyFile), "key", "", "The key file for encrypted recordings")
+    flags.Parse(args)
+    if flags.NArg() == 0 {
+        fail("Usage: plot verify [--key=file] <file>...")
+    }
+    ok := true
+    for _, file := range flags.Args() {
+        problems, err := VerifyRecording(file)
+        if err != nil {
+            fmt.Printf("%s: %v\n", file, err)
+            ok = false
+            continue
+        }
+        if len(problems) == 0 {
+            fmt.Printf("%s: OK\n", file)
+            continue
+        }
+        for _, problem := range problems {
+            fmt.Printf("%s: %s\n", file, problem)
+        }
+        ok = false
+    }
+    if !ok {
+        os.Exit(1)
+    }
+}
+
+/*
+ Compares every block of a recording with its stored checksum, and returns a description of every problem that was
+ found. An empty list means that the recording is intact. The checksums of an encrypted recording cover its chunks as
+ they are stored, and afterwards it is decrypted, which notices chunks that were cut off together with their checksums.
+ */
+func VerifyRecording(file string) ([]string, error) {
+    csv, err := os.Open(file)
+    if err != nil {
+        return nil, err
+    }
+    defer csv.Close()
+    header := make([]byte, len(encryptionMagic) + saltSize)
+    read, _ := io.ReadFull(csv, header)
+    encrypted := string(header[:min(read, len(encryptionMagic))]) == encryptionMagic
+    end := int64(0)
+    if encrypted {
+        end = int64(read)
+    }
+    _, err = csv.Seek(end, io.SeekStart)
+    if err != nil {
+        return nil, err
+    }
+    sums, err := os.Open(file + ".sum")
+    if err != nil {
+        return nil, err
+    }
+    defer sums.Close()
+
+    problems := []string{}
+    scan := bufio.NewScanner(sums)
+    for scan.Scan() {
+        parts := strings.Split(scan.Text(), ";")
+        if len(parts) != 3 {
+            problems = append(problems, fmt.Sprintf("malformed checksum entry %q", scan.Text()))
+            return problems, nil
+        }
+        offset, err1 := strconv.ParseInt(parts[0], 10, 64)
+        length, err2 := strconv.ParseInt(parts[1], 10, 64)
+        expected, err3 := strconv.ParseUint(parts[2], 16, 32)
+        if err1 != nil || err2 != nil || err3 != nil || offset != end {
+            problems = append(problems, fmt.Sprintf("malformed checksum entry %q", scan.Text()))
+            return problems, nil
+        }
+
+        // Read the block and compare the checksums
+        crc := crc32.NewIEEE()
+        n, err := io.CopyN(crc, csv, length)
+        if err != nil && err != io.EOF {
+            problems = append(problems, err.Error())
+            return problems, nil
+        }
+        if n < length {
+            problems = append(problems, fmt.Sprintf("truncated: bytes %d to %d are missing", offset + n,
+                offset + length))
+            return problems, nil
+        }
+        if crc.Sum32() != uint32(expected) {
+            problems = append(problems, fmt.Sprintf("checksum mismatch in bytes %d to %d", offset, offset + length))
+        }
+        end = offset + length
+    }
+    if err := scan.Err(); err != nil {
+        return nil, err
+    }
+
+    // Data behind the last block was written after the last checksum, usually because the recording wasn't closed
+    n, err := io.Copy(io.Discard, csv)
+    if err != nil {
+        problems = append(problems, err.Error())
+    } else if n > 0 {
+        problems = append(problems, fmt.Sprintf("bytes %d to %d are not covered by a checksum", end, end + n))
+    }
+    if encrypted && len(problems) == 0 {
+        decrypted, err := OpenRecording(file)
+        if err != nil {
+            return nil, err
+        }
+        defer decrypted.Close()
+        _, err = io.Copy(io.Discard, decrypted)
+        if err != nil {
+            problems = append(problems, err.Error())
+        }
+    }
+    return problems, nil
+}
@@ -0,0 +1,207 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "os"
+    "fmt"
+    "math"
+    "time"
+    "bufio"
+    "strings"
+    "net/url"
+)
+
+func init() {
+    Sinks["elan"] = openAnnotationSink
+    Sinks["boris"] = openAnnotationSink
+    SinkBackends["elan"] = Backend{
+        Usage: "elan:<path>",
+        Description: "Writes the events as tab-delimited annotations that ELAN imports",
+        Probe: probeFile,
+    }
+    SinkBackends["boris"] = Backend{
+        Usage: "boris:<path>",
+        Description: "Writes the events as a spreadsheet of point events that BORIS imports",
+        Probe: probeFile,
+    }
+}
+
+/*
+ How long the output of the sync markers stays high, and how long the markers last as annotations
+ */
+const syncPulse = 100 * time.Millisecond
+
+/*
+ How the sync markers and the annotations give the time of the clock of the system, in UTC and with milliseconds
+ */
+const syncTime = "2006-01-02T15:04:05.000Z07:00"
+
+/*
+ Notes sync markers in the events at a fixed interval, so a video of the session can be aligned with the signal
+ without clapping in front of the camera. The first marker is the first sample, and every marker is the event
+ "sync <number> <time>" with the time of the clock of the system at which its sample was acquired, for cameras that
+ show the time in the picture. With an output, like an LED on a GPIO pin in view of the camera, every marker is a
+ flash as well. The events end up in the recording and in every sink, like elan: and boris:.
+ */
+type SyncMarkers struct {
+    every float64
+    next float64
+    count int
+    pulses chan bool
+}
+
+/*
+ Creates the markers for the given seconds between them, with an output that is pulsed with every marker, or nil
+ */
+func NewSyncMarkers(every float64, output PatternOutput) *SyncMarkers {
+    markers := &SyncMarkers{every: every}
+    if output != nil {
+        markers.pulses = make(chan bool, 1)
+        go markers.pulse(output)
+    }
+    return markers
+}
+
+/*
+ Takes the next sample, and returns the event of its marker, or an empty string if it has none
+ */
+func (s *SyncMarkers) Process(sample Sample) string {
+    if sample.Time < s.next && s.count > 0 {
+        return ""
+    }
+    s.next = (math.Floor(sample.Time / s.every) + 1) * s.every
+    s.count++
+    if s.pulses != nil {
+        select {
+        case s.pulses <- true:
+        default:
+        }
+    }
+    acquired := sample.Acquired
+    if acquired.IsZero() {
+        acquired = time.Now()
+    }
+    return fmt.Sprintf("sync %d %s", s.count, acquired.UTC().Format(syncTime))
+}
+
+func (s *SyncMarkers) pulse(output PatternOutput) {
+    for range s.pulses {
+        output.Set(true)
+        time.Sleep(syncPulse)
+        output.Set(false)
+    }
+}
+
+/*
+ Writes the session as annotations that tools for video annotation import, so the events show up next to the video:
+    elan:<path>     Tab-delimited text for ELAN (File > Import > CSV / Tab-delimited Text File) with the columns tier,
+                    begin, end and annotation, in seconds. The sync markers, the other events and the segments each
+                    get a tier, and the session one that spans it, with the time that it started.
+    boris:<path>    A tab-delimited spreadsheet for BORIS with the columns time, subject, behavior and comment, with
+                    one point event for every event. The behavior is the first word of the event, like sync or
+                    marker, and the comment the whole event.
+ The times are seconds since the start of the session. The video is aligned by moving it until the sync markers
+ (see SyncMarkers) match the flashes that it shows. Like file:, the sink appends to the file, and only writes the header
+ into a new one. Every line is written right away.
+ */
+type AnnotationSink struct {
+    format string
+    file *os.File
+    output *bufio.Writer
+    created time.Time
+    subject string
+    last float64
+}
+
+func openAnnotationSink(target *url.URL) (Sink, error) {
+    path := target.Opaque
+    if path == "" {
+        path = target.Path
+    }
+    file, err := os.OpenFile(path, os.O_CREATE | os.O_WRONLY | os.O_APPEND, 0600)
+    if err != nil {
+        return nil, err
+    }
+    sink := &AnnotationSink{format: target.Scheme, file: file, output: bufio.NewWriter(file)}
+    info, err := file.Stat()
+    if err != nil {
+        file.Close()
+        return nil, err
+    }
+    if info.Size() > 0 {
+        return sink, nil
+    }
+    if sink.format == "elan" {
+        fmt.Fprintf(sink.output, "Tier\tBegin\tEnd\tAnnotation\n")
+    } else {
+        fmt.Fprintf(sink.output, "Time\tSubject\tBehavior\tComment\n")
+    }
+    return sink, sink.output.Flush()
+}
+
+func (s *AnnotationSink) Write(message interface{}) error {
+    switch m := message.(type) {
+    case SessionMessage:
+        s.created = m.Created
+        s.subject = m.Subject
+        return nil
+    case SampleMessage:
+        s.last = m.Time
+        return nil
+    case EventMessage:
+        s.last = math.Max(s.last, m.Time)
+        kind := strings.Fields(m.Event + " event")[0]
+        if s.format == "elan" {
+            tier := "events"
+            if kind == "sync" {
+                tier = "sync"
+            }
+            s.annotate(tier, m.Time, m.Time + syncPulse.Seconds(), m.Event)
+        } else {
+            fmt.Fprintf(s.output, "%.3f\t%s\t%s\t%s\n", m.Time, s.subject, kind, annotationText(m.Event))
+        }
+    case SegmentMessage:
+        if s.format != "elan" {
+            return nil
+        }
+        s.annotate("segments", m.Start, m.End, m.Name)
+    default:
+        return nil
+    }
+    return s.output.Flush()
+}
+
+/*
+ Adds the session that spans all annotations, and closes the file
+ */
+func (s *AnnotationSink) Close() error {
+    if s.format == "elan" && !s.created.IsZero() {
+        text := "session " + s.created.UTC().Format(syncTime)
+        if s.subject != "" {
+            text += " " + s.subject
+        }
+        s.annotate("session", 0, s.last, text)
+    }
+    err := s.output.Flush()
+    if err != nil {
+        s.file.Close()
+        return err
+    }
+    return s.file.Close()
+}
+
+func (s *AnnotationSink) annotate(tier string, begin float64, end float64, text string) {
+    fmt.Fprintf(s.output, "%s\t%.3f\t%.3f\t%s\n", tier, begin, end, annotationText(text))
+}
+
+/*
+ Keeps tabs and line breaks out of the columns
+ */
+func annotationText(text string) string {
+    return strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(text)
+}
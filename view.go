@@ -0,0 +1,253 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+//go:build !nonet
+
+package main
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "flag"
+    "math"
+    "time"
+    "bufio"
+    "strings"
+    "net/url"
+    "net/http"
+    "encoding/json"
+)
+
+func init() {
+    Commands["view"] = viewCommand
+    Sources["view"] = grabDataFromInstance
+    Sources["views"] = grabDataFromInstance
+    SourceBackends["view"] = Backend{
+        Usage: "view://<host>:<port> (see plot view)",
+        Description: "The session of another instance that was started with --serve",
+        Probe: func(target *url.URL) error { return probeHost(target, "") },
+    }
+    SourceBackends["views"] = Backend{
+        Usage: "views://<host>:<port>?ca=ca.pem (see plot view)",
+        Description: "The session of another instance that was started with --serve, connected with TLS",
+        Probe: func(target *url.URL) error { return probeHost(target, "") },
+    }
+}
+
+/*
+ Shows the session of another instance (started with --serve) in the local terminal, with the full display. The keys
+ that control the session (p, t and m) are sent to the other instance, the others only change the local display.
+ Example:
+    $ plot view --connect=pi4.local:8080 --smooth=ema:0.1
+ With TLS, the address is given as https://pi4.local:8080?ca=ca.pem, see DialURL for the certificates. The token is
+ taken from --token or PLOT_TOKEN.
+ */
+func viewCommand(args []string) {
+    connect := flag.String("connect", "", "The instance to view, as host:port or https://host:port")
+    os.Args = append([]string{os.Args[0]}, args...)
+    LoadSettings()
+    if *connect == "" {
+        fail("Usage: plot view --connect=host:port [display options]")
+    }
+    target, err := url.Parse(*connect)
+    if err != nil || target.Host == "" {
+        target, err = url.Parse("http://" + *connect)
+        if err != nil {
+            fail("%v", err)
+        }
+    }
+    switch target.Scheme {
+    case "http":
+        target.Scheme = "view"
+    case "https":
+        target.Scheme = "views"
+    default:
+        fail("unknown scheme %s, expected http or https", target.Scheme)
+    }
+
+    // The viewer only shows the session, the other instance records and streams it
+    Settings.Source = target.String()
+    Settings.Advertise = false
+    Settings.Serve = ""
+    RunDisplay()
+}
+
+/*
+ The connection to another instance
+ */
+type instanceClient struct {
+    base string
+    target *url.URL
+    client *http.Client
+    watching bool
+}
+
+/*
+ This function receives the session of another instance, which is given as view://host:port, or views://host:port
+ for TLS. The samples arrive already processed. If the connection is lost, it is opened again.
+ */
+func grabDataFromInstance(pipeline *Pipeline, target *url.URL) error {
+    instance := &instanceClient{base: "http://" + target.Host, target: target, client: &http.Client{}}
+    if target.Scheme == "views" {
+        config, err := ClientTLS(target)
+        if err != nil {
+            return err
+        }
+        instance.base = "https://" + target.Host
+        instance.client.Transport = &http.Transport{TLSClientConfig: config}
+    }
+    pipeline.Remote = instance.control
+    x := 0
+    for true {
+        err := instance.receive(pipeline, &x)
+        if x == 0 && err != nil {
+            // The first connection has to work, later ones are retried
+            return err
+        }
+        time.Sleep(time.Second)
+    }
+    return nil
+}
+
+/*
+ Receives the stream of the instance until the connection is lost
+ */
+func (i *instanceClient) receive(pipeline *Pipeline, x *int) error {
+    request, err := i.request("GET", "/stream", nil)
+    if err != nil {
+        return err
+    }
+    response, err := i.client.Do(request)
+    if err != nil {
+        return err
+    }
+    defer response.Body.Close()
+    if response.StatusCode != http.StatusOK {
+        return fmt.Errorf("%s: %s", i.base, response.Status)
+    }
+
+    var stages []string
+    var aux []string
+    reader := bufio.NewReaderSize(response.Body, 1 << 16)
+    for true {
+        line, err := reader.ReadBytes('\n')
+        if err != nil {
+            return err
+        }
+        var header StreamHeader
+        err = json.Unmarshal(line, &header)
+        if err != nil {
+            return err
+        }
+        if header.Schema != SchemaVersion {
+            return fmt.Errorf("the instance streams schema %d, expected %d", header.Schema, SchemaVersion)
+        }
+        switch header.Type {
+        case "session":
+            var session SessionMessage
+            err = json.Unmarshal(line, &session)
+            if err != nil {
+                return err
+            }
+
+            // Describe the session like a local one. The stages are only named, they were applied remotely.
+            stages = session.Stages
+            aux = []string{}
+            channels := AuxChannels{}
+            for _, channel := range session.Aux {
+                aux = append(aux, channel.Name)
+                channels = append(channels, AuxChannel{Name: channel.Name, Unit: channel.Unit, Sensor: "remote"})
+            }
+            Settings.Interval = session.Interval
+            Settings.Aux = channels
+            pipeline.SetStarted(session.Created)
+            pipeline.Filters = &FilterChain{Names: stages}
+            if Settings.Watchdog > 0 && !i.watching {
+                go RunWatchdog(pipeline, watchdogTimeout())
+                i.watching = true
+            }
+        case "sample":
+            var message SampleMessage
+            err = json.Unmarshal(line, &message)
+            if err != nil {
+                return err
+            }
+            sample := Sample{Index: *x, Time: message.Time, Value: message.Value, Stages: make([]float64, len(stages))}
+            for j, key := range stages {
+                sample.Stages[j] = message.Stages[key]
+            }
+            for _, name := range aux {
+                value, ok := message.Aux[name]
+                if !ok {
+                    value = math.NaN()
+                }
+                sample.Aux = append(sample.Aux, value)
+            }
+            pipeline.Push(sample)
+            *x++
+        case "event":
+            var message EventMessage
+            err = json.Unmarshal(line, &message)
+            if err != nil {
+                return err
+            }
+
+            // Follow the mode and the segments of the session
+            if message.Event == "recording paused" {
+                pipeline.Modes.Follow(ModePaused)
+            } else if message.Event == "recording resumed" {
+                pipeline.Modes.Follow(ModeLive)
+            }
+            if segment, ok := EventSegment(message.Event); ok {
+                pipeline.Segments.Split(segment)
+            }
+        }
+    }
+    return nil
+}
+
+/*
+ Sends a command to the instance
+ */
+func (i *instanceClient) control(command string) error {
+    request, err := i.request("POST", "/control", strings.NewReader(url.Values{"command": {command}}.Encode()))
+    if err != nil {
+        return err
+    }
+    request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    response, err := i.client.Do(request)
+    if err != nil {
+        return err
+    }
+    defer response.Body.Close()
+    if response.StatusCode / 100 != 2 {
+        return fmt.Errorf("%s: %s", command, response.Status)
+    }
+    return nil
+}
+
+/*
+ Creates a request to the instance with the credentials of the viewer
+ */
+func (i *instanceClient) request(method string, path string, body io.Reader) (*http.Request, error) {
+    request, err := http.NewRequest(method, i.base + path, body)
+    if err != nil {
+        return nil, err
+    }
+    token := Settings.Token
+    if token == "" {
+        token = os.Getenv(tokenVariable)
+    }
+    if token != "" {
+        request.Header.Set("Authorization", "Bearer " + token)
+    }
+    if i.target.User != nil {
+        password, _ := i.target.User.Password()
+        request.SetBasicAuth(i.target.User.Username(), password)
+    }
+    return request, nil
+}
@@ -0,0 +1,38 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "time"
+)
+
+/*
+ Watches the pipeline for a source that stopped delivering samples. If no sample arrived within the timeout, the
+ pipeline is marked as stalled (which the display shows as an alert), and the outage is written into the events of the
+ session once the source recovers.
+ */
+func RunWatchdog(pipeline *Pipeline, timeout time.Duration) {
+    start := time.Now()
+    for range time.Tick(timeout / 4) {
+        last, t := pipeline.LastSample()
+        if last.Before(start) {
+            last = start
+        }
+        stalled := time.Since(last) > timeout
+        if stalled == pipeline.Stalled() {
+            continue
+        }
+        pipeline.SetStalled(stalled)
+        if stalled {
+            pipeline.Event(t, "acquisition stalled")
+            start = last
+        } else {
+            pipeline.Event(t, fmt.Sprintf("acquisition resumed after %.1fs", last.Sub(start).Seconds()))
+        }
+    }
+}
@@ -0,0 +1,258 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+package main
+
+import (
+    "fmt"
+    "flag"
+    "math"
+    "sort"
+    "strings"
+    "strconv"
+    "path/filepath"
+)
+
+func init() {
+    Commands["denoise"] = denoiseCommand
+}
+
+/*
+ The scaling (lowpass) filters of the wavelets that can be used for denoising
+ */
+var Wavelets = map[string][]float64{
+    "haar": {1 / math.Sqrt2, 1 / math.Sqrt2},
+    "db2": {0.48296291314469025, 0.836516303737469, 0.22414386804185735, -0.12940952255092145},
+    "db4": {0.23037781330885523, 0.7148465705525415, 0.6308807679295904, -0.02798376941698385,
+        -0.18703481171888114, 0.030841381835986965, 0.032883011666982945, -0.010597401784997278},
+    "sym4": {-0.07576571478927333, -0.02963552764599851, 0.49761866763201545, 0.8037387518059161,
+        0.29785779560527736, -0.09921954357684722, -0.012603967262037833, 0.0322231006040427},
+}
+
+/*
+ Removes noise from a signal by decomposing it with a discrete wavelet transform, shrinking the detail coefficients
+ towards zero and putting the signal back together. The threshold is the universal threshold of Donoho and
+ Johnstone, with the noise estimated from the finest details, so it adapts to the recording:
+    threshold = median(|details|) / 0.6745 * sqrt(2 * ln(n))
+ Bursts of muscle activity are large in few coefficients and survive, while noise is spread over all of them.
+ */
+func WaveletDenoise(values []float64, wavelet string, level int) []float64 {
+    low := Wavelets[wavelet]
+    if len(values) == 0 {
+        return nil
+    }
+
+    // The length of the signal has to be divisible by 2^level, so it is extended by mirroring its end
+    block := 1 << uint(level)
+    padded := append([]float64{}, values...)
+    for i := 0; len(padded) % block != 0; i++ {
+        padded = append(padded, values[len(values) - 1 - i % len(values)])
+    }
+
+    // Decompose, keeping the details of every level
+    details := [][]float64{}
+    approximation := padded
+    for i := 0; i < level; i++ {
+        var detail []float64
+        approximation, detail = dwt(approximation, low)
+        details = append(details, detail)
+    }
+
+    // Estimate the noise from the finest details, and shrink all of them
+    magnitudes := make([]float64, len(details[0]))
+    for i, d := range details[0] {
+        magnitudes[i] = math.Abs(d)
+    }
+    sort.Float64s(magnitudes)
+    threshold := percentile(magnitudes, 50) / 0.6745 * math.Sqrt(2 * math.Log(float64(len(padded))))
+    for _, detail := range details {
+        for i, d := range detail {
+            detail[i] = math.Copysign(math.Max(0, math.Abs(d) - threshold), d)
+        }
+    }
+
+    for i := level - 1; i >= 0; i-- {
+        approximation = idwt(approximation, details[i], low)
+    }
+    return approximation[:len(values)]
+}
+
+/*
+ One level of the periodic discrete wavelet transform. Returns the approximation and the details, which are half as
+ long as the signal each.
+ */
+func dwt(values []float64, low []float64) ([]float64, []float64) {
+    high := quadratureMirror(low)
+    n := len(values)
+    approximation := make([]float64, n / 2)
+    detail := make([]float64, n / 2)
+    for i := range approximation {
+        for k := range low {
+            x := values[(2 * i + k) % n]
+            approximation[i] += low[k] * x
+            detail[i] += high[k] * x
+        }
+    }
+    return approximation, detail
+}
+
+/*
+ Reverses one level of the transform
+ */
+func idwt(approximation []float64, detail []float64, low []float64) []float64 {
+    high := quadratureMirror(low)
+    n := len(approximation) * 2
+    values := make([]float64, n)
+    for i := range approximation {
+        for k := range low {
+            values[(2 * i + k) % n] += low[k] * approximation[i] + high[k] * detail[i]
+        }
+    }
+    return values
+}
+
+/*
+ Returns the wavelet (highpass) filter that belongs to a scaling filter
+ */
+func quadratureMirror(low []float64) []float64 {
+    high := make([]float64, len(low))
+    for k := range low {
+        high[k] = low[len(low) - 1 - k]
+        if k % 2 == 1 {
+            high[k] = -high[k]
+        }
+    }
+    return high
+}
+
+/*
+ Denoises the live signal. The transform needs a whole block of samples, so the signal is delayed by one block of
+ 32 * 2^level samples. Since every block is denoised on its own, small steps can appear between them; recordings are
+ better denoised afterwards using "plot denoise".
+ */
+type WaveletFilter struct {
+    wavelet string
+    level int
+    input []float64
+    output []float64
+    position int
+}
+
+/*
+ Creates the filter from a description of the stage like "wavelet:db4:4". The wavelet defaults to db4 and the level
+ to 4.
+ */
+func ParseWaveletFilter(description string) (*WaveletFilter, error) {
+    parts := strings.Split(description, ":")
+    filter := &WaveletFilter{wavelet: "db4", level: 4}
+    if len(parts) > 1 && parts[1] != "" {
+        filter.wavelet = parts[1]
+    }
+    if len(parts) > 2 {
+        level, err := strconv.Atoi(parts[2])
+        if err != nil {
+            return nil, fmt.Errorf("invalid level for the filter wavelet: %v", err)
+        }
+        filter.level = level
+    }
+    if len(parts) > 3 {
+        return nil, fmt.Errorf("expected wavelet[:name[:level]], got %s", description)
+    }
+    err := checkWavelet(filter.wavelet, filter.level)
+    if err != nil {
+        return nil, err
+    }
+    length := 32 << uint(filter.level)
+    filter.input = make([]float64, 0, length)
+    filter.output = make([]float64, length)
+    return filter, nil
+}
+
+func (w *WaveletFilter) Process(x float64) float64 {
+    y := w.output[w.position]
+    w.position++
+    w.input = append(w.input, x)
+    if len(w.input) == cap(w.input) {
+        w.output = WaveletDenoise(w.input, w.wavelet, w.level)
+        w.input = w.input[:0]
+        w.position = 0
+    }
+    return y
+}
+
+func checkWavelet(wavelet string, level int) error {
+    if _, ok := Wavelets[wavelet]; !ok {
+        names := []string{}
+        for name := range Wavelets {
+            names = append(names, name)
+        }
+        sort.Strings(names)
+        return fmt.Errorf("unknown wavelet %s, expected one of %s", wavelet, strings.Join(names, ", "))
+    }
+    if level < 1 || level > 10 {
+        return fmt.Errorf("the level of the wavelet transform must be between 1 and 10")
+    }
+    return nil
+}
+
+/*
+ Denoises recordings using the wavelet transform, for analysis of very noisy surface recordings. The denoised signal
+ is written next to the recording, like a processing stage (data.csv -> data.denoised.csv).
+ Example:
+    $ plot denoise --wavelet=sym4 --level=5 data.csv
+ */
+func denoiseCommand(args []string) {
+    flags := flag.NewFlagSet("denoise", flag.ExitOnError)
+    wavelet := flags.String("wavelet", "db4", "The wavelet: haar, db2, db4 or sym4")
+    level := flags.Int("level", 4, "How many levels the signal is decomposed into")
+    flags.StringVar(&(Settings.KeyFile), "key", "", "The key file for encrypted recordings")
+    jobs := flags.Int("jobs", defaultJobs, "How many recordings are denoised at the same time")
+    flags.Parse(args)
+    if flags.NArg() == 0 {
+        fail("Usage: plot denoise [--wavelet=name] [--level=n] [--key=file] [--jobs=n] <file>...")
+    }
+    err := checkWavelet(*wavelet, *level)
+    if err != nil {
+        fail("%v", err)
+    }
+    files := flags.Args()
+    err = ProcessFiles(files, *jobs, "Denoised", func(i int) (string, error) {
+        file := files[i]
+        meta, err := LoadMetadata(file)
+        if err != nil {
+            return "", err
+        }
+        times, values, err := ReadSignal(file)
+        if err != nil {
+            return "", err
+        }
+        denoised := WaveletDenoise(values, *wavelet, *level)
+
+        meta.Stage = "denoised"
+        meta.Aux = nil
+        meta.Filters = fmt.Sprintf("wavelet:%s:%d", *wavelet, *level)
+        target := strings.TrimSuffix(file, filepath.Ext(file)) + ".denoised" + filepath.Ext(file)
+        recorder, err := NewRecorder(target, meta, []string{voltageUnits[0].Column("Voltage")})
+        if err != nil {
+            return "", fmt.Errorf("%s: %v", target, err)
+        }
+        for i, value := range denoised {
+            err = recorder.Write(times[i], value)
+            if err != nil {
+                recorder.Close()
+                return "", fmt.Errorf("%s: %v", target, err)
+            }
+        }
+        err = recorder.Close()
+        if err != nil {
+            return "", fmt.Errorf("%s: %v", target, err)
+        }
+        return fmt.Sprintf("%s: denoised into %s\n", file, target), nil
+    })
+    if err != nil {
+        fail("%v", err)
+    }
+}
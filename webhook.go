@@ -0,0 +1,211 @@
+/*
+ SymnaTEC plot - Displays muscle activity measured using a Raspberry Pi
+ Copyright (c) Dorian Stoll 2017
+ Licensed under the Terms of the MIT License
+ */
+
+//go:build !nonet
+
+package main
+
+import (
+    "io"
+    "os"
+    "fmt"
+    "time"
+    "bytes"
+    "strings"
+    "strconv"
+    "net/url"
+    "net/http"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+)
+
+func init() {
+    Sinks["webhook"] = openWebhookSink
+    Sinks["webhooks"] = openWebhookSink
+    SinkBackends["webhook"] = Backend{
+        Usage: "webhook://<host>[:port]/<path>?events=triggered,marker&segments=1&retries=3",
+        Description: "Posts the events of the session to a webhook",
+        Probe: func(target *url.URL) error { return probeHost(target, "80") },
+    }
+    SinkBackends["webhooks"] = Backend{
+        Usage: "webhooks://<host>[:port]/<path>?events=triggered&ca=ca.pem",
+        Description: "Posts the events of the session to a webhook over HTTPS",
+        Probe: func(target *url.URL) error { return probeHost(target, "443") },
+    }
+}
+
+/*
+ The environment variable with the secret that the requests of the webhook sink are signed with. Like the
+ passphrase, it is never accepted on the command line.
+ */
+const webhookSecretVariable = "PLOT_WEBHOOK_SECRET"
+
+/*
+ How often a request is sent again by default, before the failure is left to the stream (see sinkOutput)
+ */
+const webhookRetries = 3
+
+/*
+ Posts the events of the session to an HTTP endpoint, like the server that orchestrates an experiment, so it is told
+ about triggers and markers the moment they happen instead of having to poll for them:
+    webhook://host[:port]/path[?events=triggered,marker][&segments=1][&retries=3]
+ Every request is one message of the wire schema as JSON: the session message when the session starts, and then
+ every event. events= only sends the events that start with one of the given names, and segments=1 also sends the
+ finished segments. Samples are never sent. webhooks:// uses HTTPS, see DialURL for the certificates.
+
+ Requests that fail with a network error or a status of 5xx or 429 are sent again up to retries times, waiting
+ longer in between. X-Plot-Delivery identifies the message, it stays the same when a request is repeated, so the
+ receiver can drop duplicates. If PLOT_WEBHOOK_SECRET is set, every request is signed: X-Plot-Signature is
+ "sha256=" and the hex HMAC-SHA256 of the value of X-Plot-Timestamp (seconds since 1970), a dot and the body,
+ keyed with the secret. The receiver should compute it the same way, compare it in constant time and reject
+ timestamps that are too old.
+ */
+type WebhookSink struct {
+    endpoint string
+    client *http.Client
+    secret []byte
+    events []string
+    segments bool
+    retries int
+}
+
+func openWebhookSink(target *url.URL) (Sink, error) {
+    query := target.Query()
+    sink := &WebhookSink{client: &http.Client{Timeout: 10 * time.Second}, retries: webhookRetries,
+        segments: query.Get("segments") == "1"}
+    if events := query.Get("events"); events != "" {
+        sink.events = strings.Split(events, ",")
+    }
+    if retries := query.Get("retries"); retries != "" {
+        count, err := strconv.Atoi(retries)
+        if err != nil || count < 0 {
+            return nil, fmt.Errorf("invalid retries %q, expected a number", retries)
+        }
+        sink.retries = count
+    }
+    if secret := os.Getenv(webhookSecretVariable); secret != "" {
+        sink.secret = []byte(secret)
+    }
+    scheme := "http"
+    if target.Scheme == "webhooks" {
+        config, err := ClientTLS(target)
+        if err != nil {
+            return nil, err
+        }
+        scheme = "https"
+        sink.client.Transport = &http.Transport{TLSClientConfig: config}
+    }
+
+    // The options of the sink aren't passed on to the endpoint
+    for _, option := range []string{"events", "segments", "retries", "ca", "cert", "key"} {
+        query.Del(option)
+    }
+    endpoint := url.URL{Scheme: scheme, User: target.User, Host: target.Host, Path: target.Path,
+        RawQuery: query.Encode()}
+    sink.endpoint = endpoint.String()
+    return sink, nil
+}
+
+func (s *WebhookSink) Write(message interface{}) error {
+    switch m := message.(type) {
+    case SessionMessage:
+        return s.post(m)
+    case EventMessage:
+        if s.wants(m.Event) {
+            return s.post(m)
+        }
+    case SegmentMessage:
+        if s.segments {
+            return s.post(m)
+        }
+    }
+    return nil
+}
+
+func (s *WebhookSink) Close() error {
+    return nil
+}
+
+/*
+ Returns whether an event is one of the events that should be sent
+ */
+func (s *WebhookSink) wants(event string) bool {
+    if len(s.events) == 0 {
+        return true
+    }
+    for _, name := range s.events {
+        if strings.HasPrefix(event, strings.TrimSpace(name)) {
+            return true
+        }
+    }
+    return false
+}
+
+/*
+ Sends a message, and sends it again after failures that might go away
+ */
+func (s *WebhookSink) post(message interface{}) error {
+    body, err := json.Marshal(message)
+    if err != nil {
+        return err
+    }
+    digest := sha256.Sum256(body)
+    delivery := hex.EncodeToString(digest[:16])
+    wait := sinkRetryFirst
+    for attempt := 0; true; attempt++ {
+        retry, err := s.send(body, delivery)
+        if err == nil || !retry || attempt >= s.retries {
+            return err
+        }
+        time.Sleep(wait)
+        if wait *= 2; wait > sinkRetryLast {
+            wait = sinkRetryLast
+        }
+    }
+    return nil
+}
+
+/*
+ Sends one request, and returns whether it should be sent again if it failed
+ */
+func (s *WebhookSink) send(body []byte, delivery string) (bool, error) {
+    request, err := http.NewRequest("POST", s.endpoint, bytes.NewReader(body))
+    if err != nil {
+        return false, err
+    }
+    timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+    request.Header.Set("Content-Type", "application/json")
+    request.Header.Set("User-Agent", "SymnaTEC-plot")
+    request.Header.Set("X-Plot-Delivery", delivery)
+    request.Header.Set("X-Plot-Timestamp", timestamp)
+    if s.secret != nil {
+        request.Header.Set("X-Plot-Signature", "sha256=" + WebhookSignature(s.secret, timestamp, body))
+    }
+    response, err := s.client.Do(request)
+    if err != nil {
+        return true, err
+    }
+    defer response.Body.Close()
+    if response.StatusCode / 100 != 2 {
+        text, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+        retry := response.StatusCode / 100 == 5 || response.StatusCode == http.StatusTooManyRequests
+        return retry, fmt.Errorf("webhook: %s: %s", response.Status, strings.TrimSpace(string(text)))
+    }
+    io.Copy(io.Discard, io.LimitReader(response.Body, 4096))
+    return false, nil
+}
+
+/*
+ Computes the signature of a request to a webhook, as hex
+ */
+func WebhookSignature(secret []byte, timestamp string, body []byte) string {
+    mac := hmac.New(sha256.New, secret)
+    mac.Write([]byte(timestamp + "."))
+    mac.Write(body)
+    return hex.EncodeToString(mac.Sum(nil))
+}